@@ -0,0 +1,394 @@
+package tenus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"syscall"
+)
+
+// traffic control action attributes and constants which are not exported by syscall package
+const (
+	tcaActKind              = 1
+	tcaActOptions           = 2
+	tcaGactParms            = 2
+	tcaMirredParms          = 2
+	tcaVlanParms            = 2
+	tcaVlanPushVlanId       = 3
+	tcaVlanPushVlanProtocol = 4
+	tcaVlanPushVlanPriority = 6
+	tcaPoliceTbf            = 1
+	tcaPoliceRate           = 2
+	tcaPoliceResult         = 5
+	tcaPoliceRate64         = 8
+	tcaEgressRedir          = 1
+	tcaEgressMirror         = 2
+	tcaIngressRedir         = 3
+	tcaIngressMirror        = 4
+	tcaVlanActPop           = 1
+	tcaVlanActPush          = 2
+	tcActOk                 = 0
+	tcActShot               = 2
+	tcActPipe               = 3
+	tcActStolen             = 4
+)
+
+// sizes of action option structures
+const (
+	sizeofTcGen    = 20
+	sizeofTcMirred = 28
+	sizeofTcVlan   = 24
+	sizeofTcPolice = 56
+	sizeofTcRtab   = 1024
+)
+
+// rate tables sent to the kernel cover packets up to 2047 bytes in 256 cells of 8 bytes
+const tcRtabCellLog = 3
+
+// actionKinds maps action kinds supported by tenus to the kernel's action kinds
+var actionKinds = map[string]string{
+	"mirred": "mirred",
+	"police": "police",
+	"drop":   "gact",
+	"vlan":   "vlan",
+}
+
+// Action is performed on packets matched by a filter. Actions of a filter are performed in order.
+// Only the options field which matches the action Kind is set.
+type Action struct {
+	// Action kind: mirred, police, drop or vlan. Actions of other kinds returned by Filters have the kernel's kind.
+	Kind string
+	// Options of mirred action. They're required.
+	Mirred *MirredAction
+	// Options of police action. They're required.
+	Police *PoliceAction
+	// Options of vlan action. They're required.
+	Vlan *VlanAction
+}
+
+// MirredAction mirrors packets to another link or redirects them to it.
+type MirredAction struct {
+	// Name of the link the packets are sent to. It must be in the same network namespace.
+	Dev string
+	// Redirect sends the packets to Dev only. Otherwise the packets are copied and the filter's next action continues.
+	Redirect bool
+	// Ingress sends the packets to the ingress of Dev as if they were received by it. Otherwise they're sent by Dev.
+	Ingress bool
+}
+
+// PoliceAction drops packets which exceed the rate. Conforming packets continue to the filter's next action.
+type PoliceAction struct {
+	// Rate in bytes per second
+	Rate uint64
+	// Number of bytes which can exceed the rate at once
+	Burst uint32
+}
+
+// VlanAction pops the outer VLAN tag of packets or pushes a new one.
+type VlanAction struct {
+	// Pop removes the outer VLAN tag. Otherwise a VLAN tag is pushed.
+	Pop bool
+	// VLAN ID of the pushed tag
+	Id uint16
+	// Ethernet protocol of the pushed tag in host byte order. 0 uses 802.1Q.
+	Protocol uint16
+	// Priority of the pushed tag
+	Priority uint8
+}
+
+// encodeActions encodes the list of actions nested in classifier's action attribute.
+// Links of mirred actions are looked up in the links backend.
+func encodeActions(ctx context.Context, links Backend, actions []*Action) ([]byte, error) {
+	var attrs [][]byte
+	for i, a := range actions {
+		if a == nil {
+			return nil, fmt.Errorf("Action %d is nil", i)
+		}
+
+		options, err := a.encode(ctx, links)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s action: %w", a.Kind, err)
+		}
+
+		attrs = append(attrs, encodeNestedRtAttr(uint16(i+1),
+			encodeRtAttr(tcaActKind, encodeString(actionKinds[a.Kind])),
+			encodeRtAttr(tcaActOptions, options)))
+	}
+
+	return concat(attrs), nil
+}
+
+// parseActions decodes the list of actions dumped by the kernel. Links of mirred actions are looked up in the links backend.
+func parseActions(ctx context.Context, links Backend, b []byte) ([]*Action, error) {
+	rtas, err := parseRouteAttrs(b)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := make([]*Action, 0, len(rtas))
+	for _, rta := range rtas {
+		attrs, err := tcAttrs(rta.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		a, err := parseAction(ctx, links, strings.TrimRight(string(attrs[tcaActKind]), "\x00"), attrs[tcaActOptions])
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+
+	return actions, nil
+}
+
+// copy returns deep copy of the action
+func (a *Action) copy() *Action {
+	if a == nil {
+		return nil
+	}
+
+	c := *a
+	if a.Mirred != nil {
+		mirred := *a.Mirred
+		c.Mirred = &mirred
+	}
+	if a.Police != nil {
+		police := *a.Police
+		c.Police = &police
+	}
+	if a.Vlan != nil {
+		vlan := *a.Vlan
+		c.Vlan = &vlan
+	}
+
+	return &c
+}
+
+// encode encodes the action's options
+func (a *Action) encode(ctx context.Context, links Backend) ([]byte, error) {
+	switch a.Kind {
+	case "mirred":
+		if a.Mirred == nil {
+			return nil, errors.New("Mirred options required")
+		}
+		return a.Mirred.encode(ctx, links)
+	case "police":
+		if a.Police == nil {
+			return nil, errors.New("Police options required")
+		}
+		return a.Police.encode()
+	case "vlan":
+		if a.Vlan == nil {
+			return nil, errors.New("Vlan options required")
+		}
+		return a.Vlan.encode()
+	case "drop":
+		return encodeRtAttr(tcaGactParms, encodeTcGen(tcActShot, sizeofTcGen)), nil
+	}
+
+	return nil, errors.New("unsupported action kind")
+}
+
+// parseAction decodes options of the action of the given kernel's kind
+func parseAction(ctx context.Context, links Backend, kind string, b []byte) (*Action, error) {
+	attrs, err := tcAttrs(b)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse %s action options: %s", kind, err)
+	}
+
+	a := &Action{Kind: kind}
+	switch kind {
+	case "mirred":
+		a.Mirred, err = parseMirredAction(ctx, links, attrs)
+	case "police":
+		a.Police, err = parsePoliceAction(attrs)
+	case "vlan":
+		a.Vlan, err = parseVlanAction(attrs)
+	case "gact":
+		if parms := attrs[tcaGactParms]; len(parms) >= sizeofTcGen && tcGenAction(parms) == tcActShot {
+			a.Kind = "drop"
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse %s action options: %s", kind, err)
+	}
+
+	return a, nil
+}
+
+// encode encodes mirred action options
+func (o *MirredAction) encode(ctx context.Context, links Backend) ([]byte, error) {
+	dev, err := links.LinkByName(ctx, o.Dev)
+	if err != nil {
+		return nil, fmt.Errorf("Could not find link %q: %w", o.Dev, err)
+	}
+
+	action, eaction := tcActPipe, tcaEgressMirror
+	switch {
+	case o.Redirect && o.Ingress:
+		action, eaction = tcActStolen, tcaIngressRedir
+	case o.Redirect:
+		action, eaction = tcActStolen, tcaEgressRedir
+	case o.Ingress:
+		eaction = tcaIngressMirror
+	}
+
+	parms := encodeTcGen(int32(action), sizeofTcMirred)
+	nativeEndian.PutUint32(parms[20:24], uint32(eaction))
+	nativeEndian.PutUint32(parms[24:28], uint32(dev.Index))
+
+	return encodeRtAttr(tcaMirredParms, parms), nil
+}
+
+// parseMirredAction decodes mirred action options. Dev is empty if the link can't be found.
+func parseMirredAction(ctx context.Context, links Backend, attrs map[uint16][]byte) (*MirredAction, error) {
+	parms := attrs[tcaMirredParms]
+	if len(parms) < sizeofTcMirred {
+		return nil, errors.New("missing mirred parameters")
+	}
+
+	o := &MirredAction{}
+	switch nativeEndian.Uint32(parms[20:24]) {
+	case tcaEgressRedir:
+		o.Redirect = true
+	case tcaIngressRedir:
+		o.Redirect, o.Ingress = true, true
+	case tcaIngressMirror:
+		o.Ingress = true
+	}
+
+	if dev, err := links.LinkByIndex(ctx, int(nativeEndian.Uint32(parms[24:28]))); err == nil {
+		o.Dev = dev.Name
+	}
+
+	return o, nil
+}
+
+// encode encodes police action options. The kernel requires the rate table computed by tc.
+func (o *PoliceAction) encode() ([]byte, error) {
+	if o.Rate == 0 || o.Burst == 0 {
+		return nil, errors.New("Rate and Burst required")
+	}
+
+	ratespec, rtab := encodeRateTable(o.Rate)
+
+	parms := make([]byte, sizeofTcPolice)
+	nativeEndian.PutUint32(parms[4:8], uint32(int32(tcActShot)))
+	nativeEndian.PutUint32(parms[12:16], bytesToTicks(o.Burst, o.Rate))
+	copy(parms[20:32], ratespec)
+
+	attrs := [][]byte{
+		encodeRtAttr(tcaPoliceTbf, parms),
+		encodeRtAttr(tcaPoliceRate, rtab),
+		encodeRtAttr(tcaPoliceResult, encodeUint32(tcActPipe)),
+	}
+	if o.Rate > math.MaxUint32 {
+		attrs = append(attrs, encodeRtAttr(tcaPoliceRate64, encodeUint64(o.Rate)))
+	}
+
+	return concat(attrs), nil
+}
+
+// parsePoliceAction decodes police action options
+func parsePoliceAction(attrs map[uint16][]byte) (*PoliceAction, error) {
+	parms := attrs[tcaPoliceTbf]
+	if len(parms) < sizeofTcPolice {
+		return nil, errors.New("missing police parameters")
+	}
+
+	o := &PoliceAction{Rate: uint64(nativeEndian.Uint32(parms[28:32]))}
+	if rate64 := attrs[tcaPoliceRate64]; len(rate64) == 8 {
+		o.Rate = nativeEndian.Uint64(rate64)
+	}
+	o.Burst = ticksToBytes(nativeEndian.Uint32(parms[12:16]), o.Rate)
+
+	return o, nil
+}
+
+// encode encodes vlan action options
+func (o *VlanAction) encode() ([]byte, error) {
+	parms := encodeTcGen(tcActPipe, sizeofTcVlan)
+	if o.Pop {
+		nativeEndian.PutUint32(parms[20:24], tcaVlanActPop)
+		return encodeRtAttr(tcaVlanParms, parms), nil
+	}
+
+	if o.Id == 0 || o.Id > 4094 {
+		return nil, fmt.Errorf("invalid VLAN ID %d", o.Id)
+	}
+
+	if o.Priority > 7 {
+		return nil, fmt.Errorf("invalid VLAN priority %d", o.Priority)
+	}
+
+	protocol := o.Protocol
+	if protocol == 0 {
+		protocol = syscall.ETH_P_8021Q
+	}
+
+	nativeEndian.PutUint32(parms[20:24], tcaVlanActPush)
+
+	return concat([][]byte{
+		encodeRtAttr(tcaVlanParms, parms),
+		encodeRtAttr(tcaVlanPushVlanId, encodeUint16(o.Id)),
+		encodeRtAttr(tcaVlanPushVlanProtocol, encodeUint16(htons(protocol))),
+		encodeRtAttr(tcaVlanPushVlanPriority, []byte{o.Priority}),
+	}), nil
+}
+
+// parseVlanAction decodes vlan action options
+func parseVlanAction(attrs map[uint16][]byte) (*VlanAction, error) {
+	parms := attrs[tcaVlanParms]
+	if len(parms) < sizeofTcVlan {
+		return nil, errors.New("missing vlan parameters")
+	}
+
+	if nativeEndian.Uint32(parms[20:24]) == tcaVlanActPop {
+		return &VlanAction{Pop: true}, nil
+	}
+
+	o := &VlanAction{}
+	if v := attrs[tcaVlanPushVlanId]; len(v) == 2 {
+		o.Id = nativeEndian.Uint16(v)
+	}
+	if v := attrs[tcaVlanPushVlanProtocol]; len(v) == 2 {
+		o.Protocol = htons(nativeEndian.Uint16(v))
+	}
+	if v := attrs[tcaVlanPushVlanPriority]; len(v) == 1 {
+		o.Priority = v[0]
+	}
+
+	return o, nil
+}
+
+// encodeTcGen encodes action structure of the given size which starts with tc_gen fields
+func encodeTcGen(action int32, size int) []byte {
+	b := make([]byte, size)
+	nativeEndian.PutUint32(b[8:12], uint32(action))
+
+	return b
+}
+
+// tcGenAction returns the control action of action structure which starts with tc_gen fields
+func tcGenAction(b []byte) int32 {
+	return int32(nativeEndian.Uint32(b[8:12]))
+}
+
+// encodeRateTable encodes ethernet rate spec and rate table which holds the time it takes to send packets
+// of each size at the given rate in psched ticks, the same way as tc does.
+func encodeRateTable(rate uint64) ([]byte, []byte) {
+	ratespec := encodeRatespec(rate)
+	ratespec[0] = tcRtabCellLog
+	// cell align is -1
+	nativeEndian.PutUint16(ratespec[4:6], 0xffff)
+
+	rtab := make([]byte, sizeofTcRtab)
+	for i := 0; i < sizeofTcRtab/4; i++ {
+		nativeEndian.PutUint32(rtab[4*i:], bytesToTicks(uint32(i+1)<<tcRtabCellLog, rate))
+	}
+
+	return ratespec, rtab
+}
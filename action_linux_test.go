@@ -0,0 +1,111 @@
+package tenus
+
+import (
+	"context"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+type actionTest struct {
+	action   *Action
+	expected *Action
+}
+
+var actionTests = []actionTest{
+	{
+		action: &Action{Kind: "mirred", Mirred: &MirredAction{Dev: "lo"}},
+	},
+	{
+		action: &Action{Kind: "mirred", Mirred: &MirredAction{Dev: "lo", Redirect: true}},
+	},
+	{
+		action: &Action{Kind: "mirred", Mirred: &MirredAction{Dev: "lo", Redirect: true, Ingress: true}},
+	},
+	{
+		action: &Action{Kind: "mirred", Mirred: &MirredAction{Dev: "lo", Ingress: true}},
+	},
+	{
+		action: &Action{Kind: "police", Police: &PoliceAction{Rate: 125000, Burst: 10000}},
+	},
+	{
+		action: &Action{Kind: "police", Police: &PoliceAction{Rate: 16e9, Burst: 1 << 20}},
+	},
+	{
+		action: &Action{Kind: "drop"},
+	},
+	{
+		action: &Action{Kind: "vlan", Vlan: &VlanAction{Pop: true}},
+	},
+	{
+		action:   &Action{Kind: "vlan", Vlan: &VlanAction{Id: 10, Priority: 5}},
+		expected: &Action{Kind: "vlan", Vlan: &VlanAction{Id: 10, Protocol: syscall.ETH_P_8021Q, Priority: 5}},
+	},
+}
+
+func Test_Actions(t *testing.T) {
+	var actions, expected []*Action
+	for _, tt := range actionTests {
+		actions = append(actions, tt.action)
+		if tt.expected != nil {
+			expected = append(expected, tt.expected)
+		} else {
+			expected = append(expected, tt.action)
+		}
+	}
+
+	ctx := context.Background()
+	links := NewFakeBackend()
+
+	b, err := encodeActions(ctx, links, actions)
+	if err != nil {
+		t.Fatalf("encodeActions() failed: %s", err)
+	}
+
+	parsed, err := parseActions(ctx, links, b)
+	if err != nil {
+		t.Fatalf("parseActions() failed: %s", err)
+	}
+
+	if len(parsed) != len(expected) {
+		t.Fatalf("parseActions() failed: expected %d actions, returned %d", len(expected), len(parsed))
+	}
+
+	for i := range expected {
+		if !reflect.DeepEqual(parsed[i], expected[i]) {
+			t.Errorf("parseActions() failed: expected %+v, returned %+v", expected[i], parsed[i])
+		}
+	}
+
+	for _, a := range []*Action{
+		nil,
+		{Kind: "nat"},
+		{Kind: "mirred"},
+		{Kind: "mirred", Mirred: &MirredAction{Dev: "tcnonexistent"}},
+		{Kind: "police", Police: &PoliceAction{Rate: 125000}},
+		{Kind: "vlan", Vlan: &VlanAction{}},
+		{Kind: "vlan", Vlan: &VlanAction{Id: 4095}},
+		{Kind: "vlan", Vlan: &VlanAction{Id: 10, Priority: 8}},
+	} {
+		if _, err := encodeActions(ctx, links, []*Action{a}); err == nil {
+			t.Errorf("encodeActions(%+v) expected to fail", a)
+		}
+	}
+}
+
+func Test_RateTable(t *testing.T) {
+	ratespec, rtab := encodeRateTable(125000)
+
+	if ratespec[0] != tcRtabCellLog || ratespec[1] != tcLinklayerEther || nativeEndian.Uint32(ratespec[8:12]) != 125000 {
+		t.Errorf("encodeRateTable() failed to encode rate spec: %v", ratespec)
+	}
+
+	// 8 bytes take 64 microseconds at 125000 bytes per second
+	if ticks := nativeEndian.Uint32(rtab[0:4]); ticks != 1000 {
+		t.Errorf("encodeRateTable() failed: expected 1000 ticks, returned %d", ticks)
+	}
+
+	if ticks := nativeEndian.Uint32(rtab[sizeofTcRtab-4:]); ticks != 256*1000 {
+		t.Errorf("encodeRateTable() failed: expected %d ticks, returned %d", 256*1000, ticks)
+	}
+}
@@ -0,0 +1,308 @@
+package tenus
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// link attributes which are not exported by syscall package
+const (
+	iflaInfoKind    = 1
+	iflaInfoData    = 2
+	iflaStats64     = 23
+	iflaNetNsFd     = 28
+	iflaLinkNetnsid = 37
+)
+
+// OperState is RFC 2863 operational state of the network link as reported by Linux kernel.
+type OperState uint8
+
+// Operational states of the network link
+const (
+	OperUnknown OperState = iota
+	OperNotPresent
+	OperDown
+	OperLowerLayerDown
+	OperTesting
+	OperDormant
+	OperUp
+)
+
+var operStates = map[OperState]string{
+	OperUnknown:        "unknown",
+	OperNotPresent:     "notpresent",
+	OperDown:           "down",
+	OperLowerLayerDown: "lowerlayerdown",
+	OperTesting:        "testing",
+	OperDormant:        "dormant",
+	OperUp:             "up",
+}
+
+// String returns operational state name as displayed by iproute2 tools.
+func (s OperState) String() string {
+	if name, ok := operStates[s]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("OperState(%d)", uint8(s))
+}
+
+// LinkAttrs contains network link attributes as currently reported by Linux kernel.
+type LinkAttrs struct {
+	// Link index
+	Index int
+	// Link name
+	Name string
+	// Link alias, i.e. ownership tag of links created by tenus. Empty if the alias is not set.
+	Alias string
+	// Link kind i.e. bridge, veth, vlan. Empty for physical links.
+	Kind string
+	// Link network flags
+	Flags net.Flags
+	// Link operational state
+	OperState OperState
+	// Maximum Transmission Unit
+	MTU int
+	// MAC address
+	HardwareAddr net.HardwareAddr
+	// Index of the link's master device i.e. bridge. 0 if the link has no master.
+	MasterIndex int
+	// Index of the link's parent device i.e. vlan master or veth peer. 0 if the link has no parent.
+	ParentIndex int
+	// TX queue length
+	TxQueueLen int
+	// VLAN tag of vlan links. 0 for other links.
+	VlanId uint16
+	// Mode of macvlan and macvtap links. Empty for other links.
+	MacVlanMode string
+	// ID of the network namespace the parent device lives in. -1 if it lives in the same namespace.
+	NetNsID int
+	// Link statistics. nil if the kernel did not report IFLA_STATS64.
+	Stats *LinkStats
+}
+
+// LinkAttrsByIndex returns current attributes of the network link with the given index.
+// It returns error if the link could not be found on the Linux host.
+func LinkAttrsByIndex(index int) (*LinkAttrs, error) {
+	return backend.LinkByIndex(context.Background(), index)
+}
+
+// LinkAttrsByName returns current attributes of the network link with the given name.
+// It returns error if the link could not be found on the Linux host.
+func LinkAttrsByName(name string) (*LinkAttrs, error) {
+	return backend.LinkByName(context.Background(), name)
+}
+
+// LinkList returns current attributes of all network links on the Linux host.
+// It is equivalent of running: ip link show
+func LinkList() ([]*LinkAttrs, error) {
+	return backend.LinkList(context.Background())
+}
+
+// LinkAddrsByName returns IP addresses assigned to the network link with the given name.
+// It is equivalent of running: ip address show dev ${name}
+func LinkAddrsByName(name string) ([]*net.IPNet, error) {
+	ifc, err := interfaceByName(name)
+	if err != nil {
+		return nil, newLinkError("list addresses", name, err)
+	}
+
+	addrs, err := backend.AddrList(context.Background(), ifc.Index)
+	if err != nil {
+		return nil, newLinkError("list addresses", name, err)
+	}
+
+	return addrs, nil
+}
+
+// netInterface returns the link's attributes as net.Interface
+func (attrs *LinkAttrs) netInterface() *net.Interface {
+	return &net.Interface{
+		Index:        attrs.Index,
+		MTU:          attrs.MTU,
+		Name:         attrs.Name,
+		HardwareAddr: attrs.HardwareAddr,
+		Flags:        attrs.Flags,
+	}
+}
+
+// linkAttrsList dumps attributes of all network links in the current network namespace.
+func linkAttrsList(ctx context.Context) ([]*LinkAttrs, error) {
+	msgs, err := rtnlDump(ctx, syscall.RTM_GETLINK,
+		encodeIfInfomsg(&syscall.IfInfomsg{Family: syscall.AF_UNSPEC}))
+	if err != nil {
+		return nil, fmt.Errorf("Could not dump network links: %s", err)
+	}
+
+	var links []*LinkAttrs
+	for i := range msgs {
+		if msgs[i].Header.Type != syscall.RTM_NEWLINK {
+			continue
+		}
+
+		attrs, err := parseLinkAttrs(&msgs[i])
+		if err != nil {
+			return nil, err
+		}
+
+		links = append(links, attrs)
+	}
+
+	return links, nil
+}
+
+// parseLinkAttrs decodes RTM_NEWLINK netlink message into LinkAttrs
+func parseLinkAttrs(m *syscall.NetlinkMessage) (*LinkAttrs, error) {
+	if len(m.Data) < syscall.SizeofIfInfomsg {
+		return nil, fmt.Errorf("Netlink message too short: %d", len(m.Data))
+	}
+
+	ifim := (*syscall.IfInfomsg)(unsafe.Pointer(&m.Data[0]))
+
+	rtas, err := syscall.ParseNetlinkRouteAttr(m)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse link attributes: %s", err)
+	}
+
+	attrs := &LinkAttrs{
+		Index:   int(ifim.Index),
+		Flags:   linkFlags(ifim.Flags),
+		NetNsID: -1,
+	}
+
+	for _, rta := range rtas {
+		switch rta.Attr.Type {
+		case syscall.IFLA_IFNAME:
+			attrs.Name = string(trimNull(rta.Value))
+		case syscall.IFLA_IFALIAS:
+			attrs.Alias = string(trimNull(rta.Value))
+		case syscall.IFLA_ADDRESS:
+			attrs.HardwareAddr = net.HardwareAddr(append([]byte(nil), rta.Value...))
+		case syscall.IFLA_MTU:
+			attrs.MTU = int(nativeUint32(rta.Value))
+		case syscall.IFLA_MASTER:
+			attrs.MasterIndex = int(nativeUint32(rta.Value))
+		case syscall.IFLA_LINK:
+			attrs.ParentIndex = int(nativeUint32(rta.Value))
+		case syscall.IFLA_TXQLEN:
+			attrs.TxQueueLen = int(nativeUint32(rta.Value))
+		case syscall.IFLA_OPERSTATE:
+			if len(rta.Value) > 0 {
+				attrs.OperState = OperState(rta.Value[0])
+			}
+		case iflaLinkNetnsid:
+			attrs.NetNsID = int(int32(nativeUint32(rta.Value)))
+		case iflaStats64:
+			attrs.Stats = parseLinkStats64(rta.Value)
+		case syscall.IFLA_LINKINFO:
+			infos, err := parseRouteAttrs(rta.Value)
+			if err != nil {
+				return nil, fmt.Errorf("Could not parse link info: %s", err)
+			}
+
+			var data []byte
+			for _, info := range infos {
+				switch info.Attr.Type {
+				case iflaInfoKind:
+					attrs.Kind = string(trimNull(info.Value))
+				case iflaInfoData:
+					data = info.Value
+				}
+			}
+
+			if err := parseLinkInfoData(attrs, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return attrs, nil
+}
+
+// parseLinkInfoData decodes kind specific attributes of vlan, macvlan and macvtap links
+func parseLinkInfoData(attrs *LinkAttrs, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	switch attrs.Kind {
+	case "vlan", "macvlan", "macvtap":
+	default:
+		return nil
+	}
+
+	rtas, err := parseRouteAttrs(data)
+	if err != nil {
+		return fmt.Errorf("Could not parse %s link data: %s", attrs.Kind, err)
+	}
+
+	for _, rta := range rtas {
+		switch {
+		case attrs.Kind == "vlan" && rta.Attr.Type == iflaVlanId && len(rta.Value) >= 2:
+			attrs.VlanId = nativeEndian.Uint16(rta.Value)
+		case attrs.Kind != "vlan" && rta.Attr.Type == iflaMacvlanMode:
+			mode := nativeUint32(rta.Value)
+			for name, value := range macvlanModes {
+				if value == mode {
+					attrs.MacVlanMode = name
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// linkFlags translates kernel interface flags into net.Flags the same way net package does
+func linkFlags(rawFlags uint32) net.Flags {
+	var f net.Flags
+	if rawFlags&syscall.IFF_UP != 0 {
+		f |= net.FlagUp
+	}
+	if rawFlags&syscall.IFF_BROADCAST != 0 {
+		f |= net.FlagBroadcast
+	}
+	if rawFlags&syscall.IFF_LOOPBACK != 0 {
+		f |= net.FlagLoopback
+	}
+	if rawFlags&syscall.IFF_POINTOPOINT != 0 {
+		f |= net.FlagPointToPoint
+	}
+	if rawFlags&syscall.IFF_MULTICAST != 0 {
+		f |= net.FlagMulticast
+	}
+
+	return f
+}
+
+// nativeUint32 decodes uint32 netlink attribute value in host byte order
+func nativeUint32(b []byte) uint32 {
+	if len(b) < 4 {
+		return 0
+	}
+
+	return nativeEndian.Uint32(b)
+}
+
+// trimNull strips trailing NUL bytes from netlink string attribute
+func trimNull(b []byte) []byte {
+	for len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+
+	return b
+}
+
+// nativeEndian is the host byte order used by netlink messages
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	var x uint32 = 0x01020304
+	if *(*byte)(unsafe.Pointer(&x)) == 0x01 {
+		return binary.BigEndian
+	}
+
+	return binary.LittleEndian
+}()
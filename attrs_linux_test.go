@@ -0,0 +1,115 @@
+package tenus
+
+import (
+	"net"
+	"testing"
+
+	"github.com/milosgajdos/tenus/tenustest"
+)
+
+type operStateTest struct {
+	state    OperState
+	expected string
+}
+
+var operStateTests = []operStateTest{
+	{OperUnknown, "unknown"},
+	{OperDown, "down"},
+	{OperLowerLayerDown, "lowerlayerdown"},
+	{OperUp, "up"},
+	{OperState(42), "OperState(42)"},
+}
+
+func Test_OperStateString(t *testing.T) {
+	for _, tt := range operStateTests {
+		if ret := tt.state.String(); ret != tt.expected {
+			t.Errorf("OperState(%d).String(): expected %s, returned %s", tt.state, tt.expected, ret)
+		}
+	}
+}
+
+func Test_LinkRefresh(t *testing.T) {
+	ns := tenustest.New(t)
+
+	br, err := NewBridgeWithName("brrefresh01")
+	if err != nil {
+		t.Fatalf("NewBridgeWithName(%s) failed to run: %s", "brrefresh01", err)
+	}
+
+	if err := br.SetLinkMTU(1400); err != nil {
+		t.Fatalf("SetLinkMTU(1400) failed: %s", err)
+	}
+
+	if mtu := br.NetInterface().MTU; mtu != 1400 {
+		t.Fatalf("SetLinkMTU(1400) failed to refresh interface: expected %d, returned %d", 1400, mtu)
+	}
+
+	if err := br.SetLinkMacAddress("22:ce:e0:99:63:6f"); err != nil {
+		t.Fatalf("SetLinkMacAddress failed: %s", err)
+	}
+
+	if mac := br.NetInterface().HardwareAddr.String(); mac != "22:ce:e0:99:63:6f" {
+		t.Fatalf("SetLinkMacAddress failed to refresh interface: expected %s, returned %s", "22:ce:e0:99:63:6f", mac)
+	}
+
+	if err := br.SetLinkUp(); err != nil {
+		t.Fatalf("SetLinkUp() failed: %s", err)
+	}
+
+	if (br.NetInterface().Flags & net.FlagUp) != net.FlagUp {
+		t.Fatalf("SetLinkUp() failed to refresh interface flags: %v", br.NetInterface().Flags)
+	}
+
+	if err := RenameInterfaceByName("brrefresh01", "brrefresh02"); err != nil {
+		t.Fatalf("RenameInterfaceByName() failed: %s", err)
+	}
+
+	if err := br.Refresh(); err != nil {
+		t.Fatalf("Refresh() failed: %s", err)
+	}
+
+	if name := br.NetInterface().Name; name != "brrefresh02" {
+		t.Fatalf("Refresh() failed: expected name %s, returned %s", "brrefresh02", name)
+	}
+
+	if err := br.DeleteLink(); err != nil {
+		t.Fatalf("DeleteLink() failed: %v", err)
+	}
+	ns.AssertNoLink("brrefresh02")
+
+	if err := br.Refresh(); err == nil {
+		t.Fatalf("Refresh() of deleted link expected to fail")
+	}
+}
+
+func Test_LinkAttrs(t *testing.T) {
+	tenustest.New(t)
+
+	veth, err := NewVethPairWithOptions("vethattrs01", VethOptions{PeerName: "vethattrs02", TxQueueLen: 100})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions(%s) failed to run: %s", "vethattrs01", err)
+	}
+
+	attrs, err := veth.Attrs()
+	if err != nil {
+		t.Fatalf("Attrs() failed: %s", err)
+	}
+
+	if attrs.Name != "vethattrs01" || attrs.Index != veth.NetInterface().Index {
+		t.Fatalf("Attrs() failed: expected %s/%d, returned %s/%d",
+			"vethattrs01", veth.NetInterface().Index, attrs.Name, attrs.Index)
+	}
+
+	if attrs.TxQueueLen != 100 {
+		t.Fatalf("Attrs() failed: expected txqlen %d, returned %d", 100, attrs.TxQueueLen)
+	}
+
+	if attrs.ParentIndex != veth.PeerNetInterface().Index {
+		t.Fatalf("Attrs() failed: expected parent index %d, returned %d",
+			veth.PeerNetInterface().Index, attrs.ParentIndex)
+	}
+
+	if attrs.OperState != OperDown {
+		t.Fatalf("Attrs() failed: expected operstate %s, returned %s", OperDown, attrs.OperState)
+	}
+}
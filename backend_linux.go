@@ -0,0 +1,596 @@
+package tenus
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+
+	"github.com/milosgajdos/tenus/internal/netns"
+)
+
+// Backend performs all the kernel interaction of tenus: network links, addresses, routes, traffic control
+// and network namespaces.
+//
+// Links are referred to by their index in the backend's current network namespace.
+// Operations on missing links return syscall.ENODEV and creating links whose name is taken
+// returns syscall.EEXIST so errors can be inspected the same way regardless of the backend.
+// The kernel backend gives up waiting for the kernel's reply once the context passed to a method is done.
+// Event subscriptions are not part of Backend; Subscribe always reads events from the kernel.
+type Backend interface {
+	// LinkAdd creates new network link described by spec
+	LinkAdd(ctx context.Context, spec LinkSpec) error
+	// LinkDel deletes network link
+	LinkDel(ctx context.Context, index int) error
+	// LinkByIndex returns attributes of the link with the given index.
+	// It returns error wrapping ErrLinkNotFound if the link does not exist.
+	LinkByIndex(ctx context.Context, index int) (*LinkAttrs, error)
+	// LinkByName returns attributes of the link with the given name.
+	// It returns error wrapping ErrLinkNotFound if the link does not exist.
+	LinkByName(ctx context.Context, name string) (*LinkAttrs, error)
+	// LinkList returns attributes of all network links
+	LinkList(ctx context.Context) ([]*LinkAttrs, error)
+	// LinkSetName renames the link
+	LinkSetName(ctx context.Context, index int, name string) error
+	// LinkSetMTU sets the link's MTU
+	LinkSetMTU(ctx context.Context, index int, mtu int) error
+	// LinkSetHardwareAddr sets the link's MAC address
+	LinkSetHardwareAddr(ctx context.Context, index int, hwaddr net.HardwareAddr) error
+	// LinkSetUp brings the link up
+	LinkSetUp(ctx context.Context, index int) error
+	// LinkSetDown brings the link down
+	LinkSetDown(ctx context.Context, index int) error
+	// LinkSetMaster enslaves the link to the master device. masterIndex 0 releases the link from its master.
+	LinkSetMaster(ctx context.Context, index int, masterIndex int) error
+	// LinkSetNs moves the link to network namespace specified by filesystem path
+	LinkSetNs(ctx context.Context, index int, nspath string) error
+	// AddrAdd assigns IP address to the link. addr.IP is the address, addr.Mask is the network mask.
+	AddrAdd(ctx context.Context, index int, addr *net.IPNet) error
+	// AddrDel removes IP address from the link
+	AddrDel(ctx context.Context, index int, addr *net.IPNet) error
+	// AddrList returns IP addresses assigned to the link
+	AddrList(ctx context.Context, index int) ([]*net.IPNet, error)
+	// RouteAdd adds route to the main routing table
+	RouteAdd(ctx context.Context, route *Route) error
+	// RouteDel deletes route from the main routing table
+	RouteDel(ctx context.Context, route *Route) error
+	// RouteList returns routes in the main routing table
+	RouteList(ctx context.Context) ([]*Route, error)
+	// QdiscAdd attaches the qdisc to the link. It fails if the link has a qdisc of the same parent or handle.
+	QdiscAdd(ctx context.Context, index int, q *Qdisc) error
+	// QdiscReplace attaches the qdisc to the link replacing the qdisc of the same parent,
+	// or changes the options of the qdisc with the same handle
+	QdiscReplace(ctx context.Context, index int, q *Qdisc) error
+	// QdiscDel detaches the qdisc of the given parent and handle from the link
+	QdiscDel(ctx context.Context, index int, q *Qdisc) error
+	// QdiscList returns qdiscs attached to the link
+	QdiscList(ctx context.Context, index int) ([]*Qdisc, error)
+	// ClassAdd adds the class to the link's classful qdisc. It fails if the class already exists.
+	ClassAdd(ctx context.Context, index int, c *Class) error
+	// ClassReplace adds the class to the link's classful qdisc or changes its options if it already exists
+	ClassReplace(ctx context.Context, index int, c *Class) error
+	// ClassDel deletes the link's class of the given parent and handle
+	ClassDel(ctx context.Context, index int, c *Class) error
+	// ClassList returns classes of all qdiscs attached to the link
+	ClassList(ctx context.Context, index int) ([]*Class, error)
+	// FilterAdd attaches the filter to the link's qdisc or class. It fails if the filter already exists.
+	// Links of mirred actions are looked up by name in the backend.
+	FilterAdd(ctx context.Context, index int, f *Filter) error
+	// FilterReplace attaches the filter to the link's qdisc or class or changes the filter with the same handle
+	FilterReplace(ctx context.Context, index int, f *Filter) error
+	// FilterDel detaches the filters of the given parent, priority, protocol and handle from the link
+	FilterDel(ctx context.Context, index int, f *Filter) error
+	// FilterList returns filters attached to the link's qdisc or class with the given parent handle
+	FilterList(ctx context.Context, index int, parent uint32) ([]*Filter, error)
+	// NsCreate creates new network namespace and pins it to the filesystem path
+	NsCreate(nspath string) error
+	// NsDelete unpins network namespace from the filesystem path
+	NsDelete(nspath string) error
+	// NsExists returns true if network namespace is pinned to the filesystem path
+	NsExists(nspath string) bool
+	// NsList returns filesystem paths of network namespaces pinned in NetNsRunDir
+	NsList() ([]string, error)
+	// ExecInNs runs fn in network namespace specified by filesystem path
+	ExecInNs(nspath string, fn func() error) error
+}
+
+// LinkSpec describes network link to be created by Backend.
+type LinkSpec struct {
+	// Link name
+	Name string
+	// Link kind: dummy, bridge, veth, vlan, macvlan or macvtap
+	Kind string
+	// Name of the veth peer link
+	PeerName string
+	// TX queue length of veth links. 0 keeps the kernel default.
+	TxQueueLen int
+	// Index of the parent device of vlan, macvlan and macvtap links
+	ParentIndex int
+	// VLAN tag of vlan links
+	VlanId uint16
+	// Mode of macvlan and macvtap links
+	MacVlanMode string
+	// Alias of the link, i.e. its ownership tag. Veth peer gets the same alias. Empty leaves the alias unset.
+	Alias string
+}
+
+// backend performs all the kernel interaction of the package
+var backend Backend = kernelBackend{}
+
+// SetBackend replaces the backend used by all tenus functions and returns the previous one.
+// Passing nil restores the default backend which talks to the Linux kernel.
+// SetBackend is not safe to call concurrently with other tenus functions.
+func SetBackend(b Backend) Backend {
+	prev := backend
+	if b == nil {
+		b = kernelBackend{}
+	}
+	backend = b
+
+	return prev
+}
+
+// link kind specific attributes which are not exported by syscall package
+const (
+	vethInfoPeer    = 1
+	iflaVlanId      = 1
+	iflaMacvlanMode = 1
+)
+
+// macvlanModes maps macvlan modes supported by tenus to their kernel values
+var macvlanModes = map[string]uint32{
+	"private": 1,
+	"vepa":    2,
+	"bridge":  4,
+}
+
+// kernelBackend is Backend which talks to the Linux kernel via rtnetlink
+type kernelBackend struct{}
+
+func (k kernelBackend) LinkAdd(ctx context.Context, spec LinkSpec) error {
+	data, err := linkAddMsg(spec)
+	if err != nil {
+		return err
+	}
+
+	if err := rtnlRequest(ctx, syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, data); err != nil {
+		return err
+	}
+
+	if spec.Alias == "" {
+		return nil
+	}
+
+	// Linux kernel ignores IFLA_IFALIAS of newly created links so the alias is set once the link exists
+	for _, name := range []string{spec.Name, spec.PeerName} {
+		if name == "" {
+			continue
+		}
+
+		alias := linkMsg(0, encodeRtAttr(syscall.IFLA_IFNAME, encodeString(name)),
+			encodeRtAttr(syscall.IFLA_IFALIAS, []byte(spec.Alias)))
+		if err := k.setLink(ctx, alias); err != nil {
+			// deleting the link deletes its veth peer, too; it's deleted even if the context is done
+			if attrs, lerr := k.LinkByName(context.Background(), spec.Name); lerr == nil {
+				k.LinkDel(context.Background(), attrs.Index)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (k kernelBackend) LinkDel(ctx context.Context, index int) error {
+	return rtnlRequest(ctx, syscall.RTM_DELLINK, 0, linkMsg(index))
+}
+
+func (k kernelBackend) LinkByIndex(ctx context.Context, index int) (*LinkAttrs, error) {
+	attrs, err := k.getLink(ctx, linkMsg(index))
+	if err == syscall.ENODEV {
+		return nil, fmt.Errorf("%w: no link with index %d on the host", ErrLinkNotFound, index)
+	}
+
+	return attrs, err
+}
+
+func (k kernelBackend) LinkByName(ctx context.Context, name string) (*LinkAttrs, error) {
+	attrs, err := k.getLink(ctx, linkMsg(0, encodeRtAttr(syscall.IFLA_IFNAME, encodeString(name))))
+	if err == syscall.ENODEV {
+		return nil, fmt.Errorf("%w: no link %s on the host", ErrLinkNotFound, name)
+	}
+
+	return attrs, err
+}
+
+// getLink requests attributes of a single link selected by the RTM_GETLINK request payload
+func (k kernelBackend) getLink(ctx context.Context, data []byte) (*LinkAttrs, error) {
+	msgs, err := rtnlExecute(ctx, syscall.RTM_GETLINK, syscall.NLM_F_ACK, data)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range msgs {
+		if msgs[i].Header.Type == syscall.RTM_NEWLINK {
+			return parseLinkAttrs(&msgs[i])
+		}
+	}
+
+	return nil, syscall.ENODEV
+}
+
+func (k kernelBackend) LinkList(ctx context.Context) ([]*LinkAttrs, error) {
+	return linkAttrsList(ctx)
+}
+
+func (k kernelBackend) LinkSetName(ctx context.Context, index int, name string) error {
+	return k.setLink(ctx, linkMsg(index, encodeRtAttr(syscall.IFLA_IFNAME, encodeString(name))))
+}
+
+func (k kernelBackend) LinkSetMTU(ctx context.Context, index int, mtu int) error {
+	return k.setLink(ctx, linkMsg(index, encodeRtAttr(syscall.IFLA_MTU, encodeUint32(uint32(mtu)))))
+}
+
+func (k kernelBackend) LinkSetHardwareAddr(ctx context.Context, index int, hwaddr net.HardwareAddr) error {
+	return k.setLink(ctx, linkMsg(index, encodeRtAttr(syscall.IFLA_ADDRESS, hwaddr)))
+}
+
+func (k kernelBackend) LinkSetUp(ctx context.Context, index int) error {
+	return k.setLink(ctx, linkFlagsMsg(index, syscall.IFF_UP))
+}
+
+func (k kernelBackend) LinkSetDown(ctx context.Context, index int) error {
+	return k.setLink(ctx, linkFlagsMsg(index, 0))
+}
+
+func (k kernelBackend) LinkSetMaster(ctx context.Context, index int, masterIndex int) error {
+	return k.setLink(ctx, linkMsg(index, encodeRtAttr(syscall.IFLA_MASTER, encodeUint32(uint32(masterIndex)))))
+}
+
+func (k kernelBackend) LinkSetNs(ctx context.Context, index int, nspath string) error {
+	fd, err := syscall.Open(nspath, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nsError(nspath, err)
+	}
+	defer syscall.Close(fd)
+
+	return k.setLink(ctx, linkMsg(index, encodeRtAttr(iflaNetNsFd, encodeUint32(uint32(fd)))))
+}
+
+// setLink sends RTM_SETLINK request with the given payload
+func (k kernelBackend) setLink(ctx context.Context, data []byte) error {
+	return rtnlRequest(ctx, syscall.RTM_SETLINK, 0, data)
+}
+
+func (k kernelBackend) AddrAdd(ctx context.Context, index int, addr *net.IPNet) error {
+	data, err := addrMsg(index, addr)
+	if err != nil {
+		return err
+	}
+
+	return rtnlRequest(ctx, syscall.RTM_NEWADDR, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, data)
+}
+
+func (k kernelBackend) AddrDel(ctx context.Context, index int, addr *net.IPNet) error {
+	data, err := addrMsg(index, addr)
+	if err != nil {
+		return err
+	}
+
+	return rtnlRequest(ctx, syscall.RTM_DELADDR, 0, data)
+}
+
+func (k kernelBackend) AddrList(ctx context.Context, index int) ([]*net.IPNet, error) {
+	msgs, err := rtnlDump(ctx, syscall.RTM_GETADDR,
+		encodeIfAddrmsg(&syscall.IfAddrmsg{Family: syscall.AF_UNSPEC}))
+	if err != nil {
+		return nil, fmt.Errorf("Could not dump addresses: %s", err)
+	}
+
+	var addrs []*net.IPNet
+	for i := range msgs {
+		if msgs[i].Header.Type != syscall.RTM_NEWADDR {
+			continue
+		}
+
+		update, err := parseAddrUpdate(&msgs[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if update.Index == index && update.IPNet != nil {
+			addrs = append(addrs, update.IPNet)
+		}
+	}
+
+	return addrs, nil
+}
+
+func (k kernelBackend) RouteAdd(ctx context.Context, route *Route) error {
+	return rtnlRequest(ctx, syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL,
+		routeMsg(route, true))
+}
+
+func (k kernelBackend) RouteDel(ctx context.Context, route *Route) error {
+	return rtnlRequest(ctx, syscall.RTM_DELROUTE, 0, routeMsg(route, false))
+}
+
+func (k kernelBackend) RouteList(ctx context.Context) ([]*Route, error) {
+	msgs, err := rtnlDump(ctx, syscall.RTM_GETROUTE,
+		encodeRtMsg(&syscall.RtMsg{Family: syscall.AF_UNSPEC}))
+	if err != nil {
+		return nil, fmt.Errorf("Could not dump routes: %s", err)
+	}
+
+	var routes []*Route
+	for i := range msgs {
+		if msgs[i].Header.Type != syscall.RTM_NEWROUTE {
+			continue
+		}
+
+		update, err := parseRouteUpdate(&msgs[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if update.Table != syscall.RT_TABLE_MAIN {
+			continue
+		}
+
+		routes = append(routes, &Route{Dst: update.Dst, Gw: update.Gw, Index: update.Index})
+	}
+
+	return routes, nil
+}
+
+func (k kernelBackend) NsCreate(nspath string) error {
+	if err := os.MkdirAll(filepath.Dir(nspath), 0755); err != nil {
+		return fmt.Errorf("Could not create %s: %s", filepath.Dir(nspath), err)
+	}
+
+	f, err := os.OpenFile(nspath, os.O_RDONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if err != nil {
+		return fmt.Errorf("Could not create network namespace %s: %s", nspath, err)
+	}
+	f.Close()
+
+	runtime.LockOSThread()
+
+	origNs, err := syscall.Open(threadNetNsPath(), syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		runtime.UnlockOSThread()
+		os.Remove(nspath)
+		return fmt.Errorf("Could not open current network namespace: %s", err)
+	}
+	defer syscall.Close(origNs)
+
+	if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		os.Remove(nspath)
+		return fmt.Errorf("Could not create network namespace %s: %w", nspath, capabilityError(capSysAdmin, err))
+	}
+
+	mountErr := syscall.Mount(threadNetNsPath(), nspath, "none", syscall.MS_BIND, "")
+
+	// if we can't switch back the thread is left locked so that it's discarded when the goroutine exits
+	if err := netns.Set(origNs); err != nil {
+		return fmt.Errorf("Unable to restore the original network namespace: %s", err)
+	}
+	runtime.UnlockOSThread()
+
+	if mountErr != nil {
+		os.Remove(nspath)
+		return fmt.Errorf("Could not bind mount network namespace %s: %w", nspath, capabilityError(capSysAdmin, mountErr))
+	}
+
+	return nil
+}
+
+func (k kernelBackend) NsDelete(nspath string) error {
+	if err := syscall.Unmount(nspath, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL {
+		return fmt.Errorf("Could not unmount network namespace %s: %s", nspath, err)
+	}
+
+	if err := os.Remove(nspath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Could not remove network namespace %s: %s", nspath, err)
+	}
+
+	return nil
+}
+
+func (k kernelBackend) NsExists(nspath string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(nspath, &st); err != nil {
+		return false
+	}
+
+	// NSFS_MAGIC; an unmounted placeholder file lives on the run directory's filesystem
+	return st.Type == 0x6e736673
+}
+
+func (k kernelBackend) NsList() ([]string, error) {
+	entries, err := ioutil.ReadDir(NetNsRunDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not list network namespaces: %s", err)
+	}
+
+	var nspaths []string
+	for _, entry := range entries {
+		nspath := filepath.Join(NetNsRunDir, entry.Name())
+		if k.NsExists(nspath) {
+			nspaths = append(nspaths, nspath)
+		}
+	}
+
+	return nspaths, nil
+}
+
+// ExecInNs runs fn on a locked OS thread which is switched back to the original network namespace once fn returns.
+func (k kernelBackend) ExecInNs(nspath string, fn func() error) error {
+	runtime.LockOSThread()
+
+	origNs, err := syscall.Open(threadNetNsPath(), syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("Could not open current network namespace: %s", err)
+	}
+	defer syscall.Close(origNs)
+
+	nsFd, err := syscall.Open(nspath, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nsError(nspath, err)
+	}
+	defer syscall.Close(nsFd)
+
+	if err := netns.Set(nsFd); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("Switching to %s network namespace failed: %w", nspath, capabilityError(capSysAdmin, err))
+	}
+
+	fnErr := fn()
+
+	// if we can't switch back the thread is left locked so that it's discarded when the goroutine exits
+	if err := netns.Set(origNs); err != nil {
+		return fmt.Errorf("Unable to restore the original network namespace: %s", err)
+	}
+	runtime.UnlockOSThread()
+
+	return fnErr
+}
+
+// ipBytes returns IP address in the byte length of the address family
+func ipBytes(ip net.IP, family int) []byte {
+	if family == syscall.AF_INET {
+		return ip.To4()
+	}
+
+	return ip.To16()
+}
+
+// linkMsg encodes link message payload which refers to the link with the given index
+func linkMsg(index int, attrs ...[]byte) []byte {
+	data := encodeIfInfomsg(&syscall.IfInfomsg{Family: syscall.AF_UNSPEC, Index: int32(index)})
+	for _, attr := range attrs {
+		data = append(data, attr...)
+	}
+
+	return data
+}
+
+// linkFlagsMsg encodes link message payload which sets IFF_UP flag of the link to the value in flags
+func linkFlagsMsg(index int, flags uint32) []byte {
+	return encodeIfInfomsg(&syscall.IfInfomsg{
+		Family: syscall.AF_UNSPEC,
+		Index:  int32(index),
+		Flags:  flags,
+		Change: syscall.IFF_UP,
+	})
+}
+
+// linkAddMsg encodes RTM_NEWLINK request payload which creates the link described by spec
+func linkAddMsg(spec LinkSpec) ([]byte, error) {
+	attrs := [][]byte{encodeRtAttr(syscall.IFLA_IFNAME, encodeString(spec.Name))}
+	info := [][]byte{encodeRtAttr(iflaInfoKind, encodeString(spec.Kind))}
+
+	switch spec.Kind {
+	case "dummy", "bridge":
+	case "veth":
+		peer := linkMsg(0, encodeRtAttr(syscall.IFLA_IFNAME, encodeString(spec.PeerName)))
+		if spec.TxQueueLen > 0 {
+			txQLen := encodeRtAttr(syscall.IFLA_TXQLEN, encodeUint32(uint32(spec.TxQueueLen)))
+			attrs = append(attrs, txQLen)
+			peer = append(peer, txQLen...)
+		}
+		info = append(info, encodeNestedRtAttr(iflaInfoData, encodeRtAttr(vethInfoPeer, peer)))
+	case "vlan":
+		attrs = append(attrs, encodeRtAttr(syscall.IFLA_LINK, encodeUint32(uint32(spec.ParentIndex))))
+		info = append(info, encodeNestedRtAttr(iflaInfoData, encodeRtAttr(iflaVlanId, encodeUint16(spec.VlanId))))
+	case "macvlan", "macvtap":
+		mode, ok := macvlanModes[spec.MacVlanMode]
+		if !ok {
+			return nil, fmt.Errorf("Unsupported macvlan mode: %s", spec.MacVlanMode)
+		}
+		attrs = append(attrs, encodeRtAttr(syscall.IFLA_LINK, encodeUint32(uint32(spec.ParentIndex))))
+		info = append(info, encodeNestedRtAttr(iflaInfoData, encodeRtAttr(iflaMacvlanMode, encodeUint32(mode))))
+	default:
+		return nil, fmt.Errorf("Unsupported link kind: %s", spec.Kind)
+	}
+
+	attrs = append(attrs, encodeNestedRtAttr(syscall.IFLA_LINKINFO, info...))
+
+	return linkMsg(0, attrs...), nil
+}
+
+// addrMsg encodes address message payload which refers to IP address addr of the link with the given index
+func addrMsg(index int, addr *net.IPNet) ([]byte, error) {
+	family := syscall.AF_INET
+	if addr.IP.To4() == nil {
+		family = syscall.AF_INET6
+	}
+
+	ip := ipBytes(addr.IP, family)
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid IP address: %s", addr.IP)
+	}
+
+	prefixLen, _ := addr.Mask.Size()
+	data := encodeIfAddrmsg(&syscall.IfAddrmsg{
+		Family:    uint8(family),
+		Prefixlen: uint8(prefixLen),
+		Index:     uint32(index),
+	})
+	data = append(data, encodeRtAttr(syscall.IFA_LOCAL, ip)...)
+	data = append(data, encodeRtAttr(syscall.IFA_ADDRESS, ip)...)
+
+	return data, nil
+}
+
+// routeMsg encodes route message payload of the route in the main routing table.
+// Routes to be deleted are matched regardless of their scope and protocol.
+func routeMsg(route *Route, add bool) []byte {
+	family := syscall.AF_INET
+	switch {
+	case route.Dst != nil && route.Dst.IP.To4() == nil:
+		family = syscall.AF_INET6
+	case route.Dst == nil && route.Gw != nil && route.Gw.To4() == nil:
+		family = syscall.AF_INET6
+	}
+
+	rtm := syscall.RtMsg{
+		Family: uint8(family),
+		Table:  syscall.RT_TABLE_MAIN,
+		Scope:  syscall.RT_SCOPE_NOWHERE,
+		Type:   syscall.RTN_UNICAST,
+	}
+
+	if add {
+		rtm.Protocol = syscall.RTPROT_BOOT
+		rtm.Scope = syscall.RT_SCOPE_UNIVERSE
+		if route.Gw == nil {
+			rtm.Scope = syscall.RT_SCOPE_LINK
+		}
+	}
+
+	var attrs []byte
+	if route.Dst != nil {
+		ones, _ := route.Dst.Mask.Size()
+		rtm.Dst_len = uint8(ones)
+		attrs = append(attrs, encodeRtAttr(syscall.RTA_DST, ipBytes(route.Dst.IP, family))...)
+	}
+	if route.Gw != nil {
+		attrs = append(attrs, encodeRtAttr(syscall.RTA_GATEWAY, ipBytes(route.Gw, family))...)
+	}
+	if route.Index != 0 {
+		attrs = append(attrs, encodeRtAttr(syscall.RTA_OIF, encodeUint32(uint32(route.Index)))...)
+	}
+
+	return append(encodeRtMsg(&rtm), attrs...)
+}
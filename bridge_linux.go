@@ -2,10 +2,8 @@ package tenus
 
 import (
 	"bytes"
-	"fmt"
+	"context"
 	"net"
-
-	"github.com/docker/libcontainer/netlink"
 )
 
 // Bridger embeds Linker interface and adds one extra function.
@@ -33,28 +31,23 @@ type Bridge struct {
 // a random name starting with "br".
 // It returns error if the bridge could not be created.
 func NewBridge() (Bridger, error) {
-	brDev := makeNetInterfaceName("br")
-
-	if ok, err := NetInterfaceNameValid(brDev); !ok {
-		return nil, err
-	}
-
-	if _, err := net.InterfaceByName(brDev); err == nil {
-		return nil, fmt.Errorf("Interface name %s already assigned on the host", brDev)
-	}
-
-	if err := netlink.NetworkLinkAdd(brDev, "bridge"); err != nil {
-		return nil, err
+	var brDev string
+	err := createWithGeneratedNames("br", []*string{&brDev}, func() error {
+		return addLink(context.Background(), LinkSpec{Name: brDev, Kind: "bridge"})
+	})
+	if err != nil {
+		return nil, newLinkError("create", brDev, err)
 	}
 
-	newIfc, err := net.InterfaceByName(brDev)
+	newIfc, err := interfaceByName(brDev)
 	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+		return nil, newLinkError("create", brDev, err)
 	}
 
 	return &Bridge{
 		Link: Link{
-			ifc: newIfc,
+			ifc:        newIfc,
+			namePrefix: "br",
 		},
 	}, nil
 }
@@ -63,21 +56,17 @@ func NewBridge() (Bridger, error) {
 // It is equivalent of running: ip link add name ${ifcName} type bridge
 // It returns error if the bridge can not be created.
 func NewBridgeWithName(ifcName string) (Bridger, error) {
-	if ok, err := NetInterfaceNameValid(ifcName); !ok {
-		return nil, err
-	}
-
-	if _, err := net.InterfaceByName(ifcName); err == nil {
-		return nil, fmt.Errorf("Interface name %s already assigned on the host", ifcName)
+	if err := linkNameAvailable(ifcName); err != nil {
+		return nil, newLinkError("create", ifcName, err)
 	}
 
-	if err := netlink.NetworkLinkAdd(ifcName, "bridge"); err != nil {
-		return nil, err
+	if err := addLink(context.Background(), LinkSpec{Name: ifcName, Kind: "bridge"}); err != nil {
+		return nil, newLinkError("create", ifcName, err)
 	}
 
-	newIfc, err := net.InterfaceByName(ifcName)
+	newIfc, err := interfaceByName(ifcName)
 	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+		return nil, newLinkError("create", ifcName, err)
 	}
 
 	return &Bridge{
@@ -91,12 +80,12 @@ func NewBridgeWithName(ifcName string) (Bridger, error) {
 // It returns error if the bridge of the given name cannot be found.
 func BridgeFromName(ifcName string) (Bridger, error) {
 	if ok, err := NetInterfaceNameValid(ifcName); !ok {
-		return nil, err
+		return nil, newLinkError("find", ifcName, err)
 	}
 
-	newIfc, err := net.InterfaceByName(ifcName)
+	newIfc, err := interfaceByName(ifcName)
 	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+		return nil, newLinkError("find", ifcName, err)
 	}
 
 	return &Bridge{
@@ -110,22 +99,22 @@ func BridgeFromName(ifcName string) (Bridger, error) {
 // It is equivalent of running: ip link set ${netIfc name} master ${netBridge name}
 // It returns error when it fails to add the network interface to bridge.
 func AddToBridge(netIfc, netBridge *net.Interface) error {
-	return netlink.NetworkSetMaster(netIfc, netBridge)
+	return newLinkError("set master", netIfc.Name, backend.LinkSetMaster(context.Background(), netIfc.Index, netBridge.Index))
 }
 
 // AddToBridge adds network interfaces to network bridge.
 // It is equivalent of running: ip link set dev ${netIfc name} nomaster
 // It returns error when it fails to remove the network interface from the bridge.
 func RemoveFromBridge(netIfc *net.Interface) error {
-	return netlink.NetworkSetNoMaster(netIfc)
+	return newLinkError("set nomaster", netIfc.Name, backend.LinkSetMaster(context.Background(), netIfc.Index, 0))
 }
 
 // AddSlaveIfc adds network interface to network bridge.
 // It is equivalent of running: ip link set ${ifc name} master ${bridge name}
 // It returns error if the network interface could not be added to the bridge.
 func (br *Bridge) AddSlaveIfc(ifc *net.Interface) error {
-	if err := netlink.NetworkSetMaster(ifc, br.ifc); err != nil {
-		return err
+	if err := backend.LinkSetMaster(context.Background(), ifc.Index, br.ifc.Index); err != nil {
+		return newLinkError("set master", ifc.Name, err)
 	}
 
 	br.slaveIfcs = append(br.slaveIfcs, *ifc)
@@ -138,8 +127,8 @@ func (br *Bridge) AddSlaveIfc(ifc *net.Interface) error {
 // It returns error if the network interface is not in the bridge or
 // it could not be removed from the bridge.
 func (br *Bridge) RemoveSlaveIfc(ifc *net.Interface) error {
-	if err := netlink.NetworkSetNoMaster(ifc); err != nil {
-		return err
+	if err := backend.LinkSetMaster(context.Background(), ifc.Index, 0); err != nil {
+		return newLinkError("set nomaster", ifc.Name, err)
 	}
 
 	for index, i := range br.slaveIfcs {
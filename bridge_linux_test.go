@@ -1,81 +1,60 @@
 package tenus
 
 import (
-	"net"
 	"testing"
-	"time"
+
+	"github.com/milosgajdos/tenus/tenustest"
 )
 
 func Test_NewBridge(t *testing.T) {
-	tl := &testLink{}
+	ns := tenustest.New(t)
 
 	br, err := NewBridge()
 	if err != nil {
 		t.Fatalf("NewBridge() failed to run: %s", err)
 	}
 
-	brName := br.NetInterface().Name
-	if err := tl.prepTestLink(brName, "bridge"); err != nil {
-		t.Skipf("NewBridge test requries external command: %v", err)
-	}
-
-	if _, err := net.InterfaceByName(brName); err != nil {
-		tl.teardown()
-		t.Fatalf("Could not find %s on the host: %s", brName, err)
-	}
-
-	testRes, err := linkInfo(brName, "bridge")
-	if err != nil {
-		tl.teardown()
-		t.Fatalf("Failed to list %s operation mode: %s", brName, err)
-	}
-
-	if testRes.linkType != "bridge" {
-		tl.teardown()
-		t.Fatalf("NewBridge() failed: expected linktype bridge, returned %s", testRes.linkType)
-	}
-
-	if err := tl.teardown(); err != nil {
-		t.Fatalf("testLink.teardown failed: %v", err)
-	} else {
-		time.Sleep(10 * time.Millisecond)
-	}
+	ns.AssertLinkKind(br.NetInterface().Name, "bridge")
 }
 
 func Test_NewBridgeWithName(t *testing.T) {
+	ns := tenustest.New(t)
+
 	brTests := []string{"br01", "br02", "br03"}
 	for _, tt := range brTests {
-		tl := &testLink{}
-
-		_, err := NewBridgeWithName(tt)
-		if err != nil {
+		if _, err := NewBridgeWithName(tt); err != nil {
 			t.Fatalf("NewBridge(%s) failed to run: %s", tt, err)
 		}
 
-		if err := tl.prepTestLink(tt, "bridge"); err != nil {
-			t.Skipf("test requries external command: %v", err)
-		}
+		ns.AssertLinkKind(tt, "bridge")
+	}
+}
 
-		if _, err := net.InterfaceByName(tt); err != nil {
-			tl.teardown()
-			t.Fatalf("Could not find %s on the host: %s", tt, err)
-		}
+func Test_BridgeInNetNs(t *testing.T) {
+	t.Parallel()
 
-		testRes, err := linkInfo(tt, "bridge")
-		if err != nil {
-			tl.teardown()
-			t.Fatalf("Failed to list %s operation mode: %s", tt, err)
-		}
+	ns := tenustest.New(t)
 
-		if testRes.linkType != "bridge" {
-			tl.teardown()
-			t.Fatalf("NewBridge() failed: expected linktype bridge, returned %s", testRes.linkType)
-		}
+	br, err := NewBridgeWithName("brns01")
+	if err != nil {
+		t.Fatalf("NewBridgeWithName() failed to run: %s", err)
+	}
 
-		if err := tl.teardown(); err != nil {
-			t.Fatalf("testIfcLink.teardown failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
-		}
+	veth, err := NewVethPairWithOptions("vethbr01", VethOptions{PeerName: "vethbr02"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed to run: %s", err)
+	}
+
+	if err := br.AddSlaveIfc(veth.NetInterface()); err != nil {
+		t.Fatalf("AddSlaveIfc() failed: %s", err)
 	}
+
+	ns.AssertLinkKind("brns01", "bridge")
+	ns.AssertLinkMaster("vethbr01", "brns01")
+
+	if err := br.RemoveSlaveIfc(veth.NetInterface()); err != nil {
+		t.Fatalf("RemoveSlaveIfc() failed: %s", err)
+	}
+
+	ns.AssertLinkMaster("vethbr01", "")
 }
@@ -0,0 +1,296 @@
+package tenus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"syscall"
+)
+
+// htb class attributes which are not exported by syscall package
+const (
+	tcaHtbParms  = 1
+	tcaHtbRate64 = 6
+	tcaHtbCeil64 = 7
+)
+
+// sizeofHtbOpt is the size of tc_htb_opt structure
+const sizeofHtbOpt = 44
+
+// defaults used by tc to compute htb class bursts: clock rate in Hz and the link MTU
+const (
+	htbBurstHz  = 1000
+	htbBurstMtu = 1600
+)
+
+// Class is a traffic class of a classful qdisc. Packets are sent to classes by filters.
+// Only htb classes can be created by tenus, classes of other kinds are listed without options.
+type Class struct {
+	// Class kind, i.e. htb. It must match the kind of the class's qdisc.
+	Kind string
+	// Class handle made of the qdisc's major number and the class's minor number, i.e. MakeHandle(1, 0x10)
+	Handle uint32
+	// Parent handle: handle of the qdisc or of the parent class
+	Parent uint32
+	// Options of htb class. They're required.
+	Htb *HtbClassOptions
+	// Class statistics. It's only set by Classes.
+	Stats *QdiscStats
+}
+
+// HtbClassOptions configures htb class which guarantees Rate to its traffic and lends unused bandwidth
+// of its parent up to Ceil.
+type HtbClassOptions struct {
+	// Guaranteed rate in bytes per second
+	Rate uint64
+	// Maximum rate in bytes per second. 0 uses Rate.
+	Ceil uint64
+	// Number of bytes which can be sent at the ceil rate at once. 0 computes it the same way as tc.
+	Burst uint32
+	// Number of bytes which can be sent faster than the ceil rate at once. 0 computes it the same way as tc.
+	Cburst uint32
+	// Priority of the class when borrowing unused bandwidth. Lower value is served first.
+	Prio uint32
+	// Number of bytes the class is allowed to borrow at once. 0 lets the kernel compute it from the rate.
+	Quantum uint32
+}
+
+// AddClass adds the class to the link's classful qdisc. It fails if the class already exists.
+// It is equivalent of running: tc class add dev ${link} parent ${parent} classid ${handle} ${kind} ${options}
+func (l *Link) AddClass(c *Class) error {
+	return l.AddClassContext(context.Background(), c)
+}
+
+// AddClassContext is like AddClass but it returns without changing the link if the context is done.
+func (l *Link) AddClassContext(ctx context.Context, c *Class) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("add class", l.ifc.Name, err)
+	}
+
+	return newLinkError("add class", l.ifc.Name, backend.ClassAdd(ctx, l.ifc.Index, c))
+}
+
+// ReplaceClass adds the class to the link's classful qdisc or changes its options if it already exists.
+// It is equivalent of running: tc class replace dev ${link} parent ${parent} classid ${handle} ${kind} ${options}
+func (l *Link) ReplaceClass(c *Class) error {
+	return l.ReplaceClassContext(context.Background(), c)
+}
+
+// ReplaceClassContext is like ReplaceClass but it returns without changing the link if the context is done.
+func (l *Link) ReplaceClassContext(ctx context.Context, c *Class) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("replace class", l.ifc.Name, err)
+	}
+
+	return newLinkError("replace class", l.ifc.Name, backend.ClassReplace(ctx, l.ifc.Index, c))
+}
+
+// DelClass deletes the class of the given parent and handle. Options of the class are ignored.
+// Classes with child classes or filters can't be deleted.
+// It is equivalent of running: tc class del dev ${link} parent ${parent} classid ${handle}
+func (l *Link) DelClass(c *Class) error {
+	return l.DelClassContext(context.Background(), c)
+}
+
+// DelClassContext is like DelClass but it returns without changing the link if the context is done.
+func (l *Link) DelClassContext(ctx context.Context, c *Class) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("del class", l.ifc.Name, err)
+	}
+
+	return newLinkError("del class", l.ifc.Name, backend.ClassDel(ctx, l.ifc.Index, c))
+}
+
+// Classes returns classes of all qdiscs attached to the link.
+// It is equivalent of running: tc class show dev ${link}
+func (l *Link) Classes() ([]*Class, error) {
+	return l.ClassesContext(context.Background())
+}
+
+// ClassesContext is like Classes but it gives up waiting for the classes once the context is done.
+func (l *Link) ClassesContext(ctx context.Context) ([]*Class, error) {
+	classes, err := backend.ClassList(ctx, l.ifc.Index)
+	if err != nil {
+		return nil, newLinkError("list classes", l.ifc.Name, err)
+	}
+
+	return classes, nil
+}
+
+func (k kernelBackend) ClassAdd(ctx context.Context, index int, c *Class) error {
+	return classRequest(ctx, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, index, c)
+}
+
+func (k kernelBackend) ClassReplace(ctx context.Context, index int, c *Class) error {
+	return classRequest(ctx, syscall.NLM_F_CREATE, index, c)
+}
+
+func (k kernelBackend) ClassDel(ctx context.Context, index int, c *Class) error {
+	return rtnlRequest(ctx, syscall.RTM_DELTCLASS, 0, encodeTcmsg(index, c.Handle, c.Parent, 0))
+}
+
+func (k kernelBackend) ClassList(ctx context.Context, index int) ([]*Class, error) {
+	objs, err := tcDump(ctx, syscall.RTM_GETTCLASS, index, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	classes := make([]*Class, 0, len(objs))
+	for _, obj := range objs {
+		c, err := parseClass(obj)
+		if err != nil {
+			return nil, err
+		}
+		classes = append(classes, c)
+	}
+
+	return classes, nil
+}
+
+// classRequest sends rtnetlink request which creates or changes the class of the link with the given index
+func classRequest(ctx context.Context, flags uint16, index int, c *Class) error {
+	data, err := c.encode(index)
+	if err != nil {
+		return err
+	}
+
+	return rtnlRequest(ctx, syscall.RTM_NEWTCLASS, flags, data)
+}
+
+// copyClass returns deep copy of the class
+func copyClass(c *Class) *Class {
+	cc := *c
+	if c.Htb != nil {
+		htb := *c.Htb
+		cc.Htb = &htb
+	}
+	if c.Stats != nil {
+		stats := *c.Stats
+		cc.Stats = &stats
+	}
+
+	return &cc
+}
+
+// encode encodes the class into tcmsg header followed by its kind and options
+func (c *Class) encode(index int) ([]byte, error) {
+	if c.Kind != "htb" {
+		return nil, fmt.Errorf("Unsupported class kind: %q", c.Kind)
+	}
+
+	if c.Htb == nil {
+		return nil, errors.New("htb class requires Htb options")
+	}
+
+	if c.Handle == 0 || c.Parent == 0 {
+		return nil, errors.New("htb class requires Handle and Parent")
+	}
+
+	options, err := c.Htb.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	data := append(encodeTcmsg(index, c.Handle, c.Parent, 0), encodeRtAttr(tcaKind, encodeString(c.Kind))...)
+
+	return append(data, encodeRtAttr(tcaOptions, options)...), nil
+}
+
+// parseClass decodes class dumped by the kernel
+func parseClass(obj *tcObject) (*Class, error) {
+	c := &Class{Kind: obj.kind, Handle: obj.handle, Parent: obj.parent, Stats: parseTcStats(obj.stats)}
+
+	if obj.kind == "htb" {
+		var err error
+		if c.Htb, err = parseHtbClassOptions(obj.options); err != nil {
+			return nil, fmt.Errorf("Could not parse %s class options: %s", obj.kind, err)
+		}
+	}
+
+	return c, nil
+}
+
+// encode encodes htb class options. Bursts are sent in psched ticks it takes to send them.
+func (o *HtbClassOptions) encode() ([]byte, error) {
+	if o.Rate == 0 {
+		return nil, errors.New("htb class requires Rate")
+	}
+
+	ceil := o.Ceil
+	if ceil == 0 {
+		ceil = o.Rate
+	}
+
+	if ceil < o.Rate {
+		return nil, fmt.Errorf("htb class Ceil %d is lower than Rate %d", ceil, o.Rate)
+	}
+
+	burst, cburst := o.Burst, o.Cburst
+	if burst == 0 {
+		burst = htbBurst(o.Rate)
+	}
+	if cburst == 0 {
+		cburst = htbBurst(ceil)
+	}
+
+	opt := make([]byte, sizeofHtbOpt)
+	copy(opt[0:], encodeRatespec(o.Rate))
+	copy(opt[12:], encodeRatespec(ceil))
+	nativeEndian.PutUint32(opt[24:], bytesToTicks(burst, o.Rate))
+	nativeEndian.PutUint32(opt[28:], bytesToTicks(cburst, ceil))
+	nativeEndian.PutUint32(opt[32:], o.Quantum)
+	nativeEndian.PutUint32(opt[40:], o.Prio)
+
+	attrs := [][]byte{encodeRtAttr(tcaHtbParms, opt)}
+	if o.Rate > math.MaxUint32 {
+		attrs = append(attrs, encodeRtAttr(tcaHtbRate64, encodeUint64(o.Rate)))
+	}
+	if ceil > math.MaxUint32 {
+		attrs = append(attrs, encodeRtAttr(tcaHtbCeil64, encodeUint64(ceil)))
+	}
+
+	return concat(attrs), nil
+}
+
+// parseHtbClassOptions decodes htb class options dumped by the kernel
+func parseHtbClassOptions(b []byte) (*HtbClassOptions, error) {
+	attrs, err := tcAttrs(b)
+	if err != nil {
+		return nil, err
+	}
+
+	opt := attrs[tcaHtbParms]
+	if len(opt) < sizeofHtbOpt {
+		return nil, errors.New("missing htb class parameters")
+	}
+
+	o := &HtbClassOptions{
+		Rate:    uint64(nativeEndian.Uint32(opt[8:12])),
+		Ceil:    uint64(nativeEndian.Uint32(opt[20:24])),
+		Quantum: nativeEndian.Uint32(opt[32:36]),
+		Prio:    nativeEndian.Uint32(opt[40:44]),
+	}
+
+	if rate64 := attrs[tcaHtbRate64]; len(rate64) == 8 {
+		o.Rate = nativeEndian.Uint64(rate64)
+	}
+	if ceil64 := attrs[tcaHtbCeil64]; len(ceil64) == 8 {
+		o.Ceil = nativeEndian.Uint64(ceil64)
+	}
+
+	o.Burst = ticksToBytes(nativeEndian.Uint32(opt[24:28]), o.Rate)
+	o.Cburst = ticksToBytes(nativeEndian.Uint32(opt[28:32]), o.Ceil)
+
+	return o, nil
+}
+
+// htbBurst returns the default burst of htb class with the given rate computed the same way as tc does
+func htbBurst(rate uint64) uint32 {
+	burst := rate/htbBurstHz + htbBurstMtu
+	if burst > math.MaxUint32 {
+		return math.MaxUint32
+	}
+
+	return uint32(burst)
+}
@@ -0,0 +1,127 @@
+package tenus
+
+import (
+	"errors"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/milosgajdos/tenus/tenustest"
+)
+
+// findClass returns the link's class with the given handle
+func findClass(t *testing.T, link Linker, handle uint32) *Class {
+	classes, err := link.Classes()
+	if err != nil {
+		t.Fatalf("Classes() failed: %s", err)
+	}
+
+	for _, c := range classes {
+		if c.Handle == handle {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func Test_Classes(t *testing.T) {
+	tenustest.New(t)
+
+	veth, err := NewVethPairWithOptions("tcclass01", VethOptions{PeerName: "tcclass02"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	if err := veth.AddQdisc(&Qdisc{Kind: "htb", Handle: MakeHandle(1, 0), Htb: &HtbOptions{Default: 0x20}}); err != nil {
+		t.Fatalf("AddQdisc() failed: %s", err)
+	}
+
+	root := &Class{Kind: "htb", Handle: MakeHandle(1, 1), Parent: MakeHandle(1, 0), Htb: &HtbClassOptions{Rate: 1250000}}
+	child := &Class{Kind: "htb", Handle: MakeHandle(1, 0x10), Parent: MakeHandle(1, 1),
+		Htb: &HtbClassOptions{Rate: 125000, Ceil: 1250000, Prio: 1}}
+
+	for _, c := range []*Class{root, child} {
+		if err := veth.AddClass(c); err != nil {
+			t.Fatalf("AddClass(%+v) failed: %s", c, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		handle   uint32
+		expected *Class
+	}{
+		{root.Handle, &Class{Kind: "htb", Handle: root.Handle, Parent: HandleRoot,
+			Htb: &HtbClassOptions{Rate: 1250000, Ceil: 1250000, Burst: 2850, Cburst: 2850, Quantum: 125000}}},
+		{child.Handle, &Class{Kind: "htb", Handle: child.Handle, Parent: root.Handle,
+			Htb: &HtbClassOptions{Rate: 125000, Ceil: 1250000, Burst: 1725, Cburst: 2850, Prio: 1, Quantum: 12500}}},
+	} {
+		c := findClass(t, veth, tt.handle)
+		if c == nil || c.Stats == nil {
+			t.Fatalf("Classes() failed to return class %s with statistics: %+v", HandleString(tt.handle), c)
+		}
+
+		c.Stats = nil
+		if !reflect.DeepEqual(c, tt.expected) {
+			t.Errorf("Classes() failed: expected %+v, returned %+v", tt.expected.Htb, c.Htb)
+		}
+	}
+
+	if err := veth.AddClass(child); !errors.Is(err, syscall.EEXIST) {
+		t.Errorf("AddClass(%+v) failed: expected %v, returned %v", child, syscall.EEXIST, err)
+	}
+
+	child.Htb = &HtbClassOptions{Rate: 250000, Burst: 5000}
+	if err := veth.ReplaceClass(child); err != nil {
+		t.Fatalf("ReplaceClass(%+v) failed: %s", child, err)
+	}
+
+	if c := findClass(t, veth, child.Handle); c == nil || c.Htb.Rate != 250000 || c.Htb.Ceil != 250000 || c.Htb.Burst != 5000 {
+		t.Fatalf("ReplaceClass(%+v) failed to change the class: %+v", child, c)
+	}
+
+	if err := veth.DelClass(root); !errors.Is(err, syscall.EBUSY) {
+		t.Errorf("DelClass(%+v) failed: expected %v, returned %v", root, syscall.EBUSY, err)
+	}
+
+	for _, c := range []*Class{child, root} {
+		if err := veth.DelClass(c); err != nil {
+			t.Fatalf("DelClass(%+v) failed: %s", c, err)
+		}
+	}
+
+	if classes, err := veth.Classes(); err != nil || len(classes) != 0 {
+		t.Fatalf("DelClass() failed to delete classes: %v, %v", classes, err)
+	}
+
+	for _, c := range []*Class{
+		{Kind: "prio", Handle: MakeHandle(1, 1), Parent: MakeHandle(1, 0)},
+		{Kind: "htb", Handle: MakeHandle(1, 1), Parent: MakeHandle(1, 0)},
+		{Kind: "htb", Handle: MakeHandle(1, 1), Htb: &HtbClassOptions{Rate: 125000}},
+		{Kind: "htb", Handle: MakeHandle(1, 1), Parent: MakeHandle(1, 0), Htb: &HtbClassOptions{}},
+		{Kind: "htb", Handle: MakeHandle(1, 1), Parent: MakeHandle(1, 0), Htb: &HtbClassOptions{Rate: 125000, Ceil: 1000}},
+	} {
+		if err := veth.AddClass(c); err == nil || errors.Is(err, syscall.EINVAL) {
+			t.Errorf("AddClass(%+v) expected to fail validation, returned %v", c, err)
+		}
+	}
+}
+
+func Test_HtbClassOptions(t *testing.T) {
+	// 64-bit rates whose bursts round trip psched ticks exactly
+	o := &HtbClassOptions{Rate: 16e9, Ceil: 32e9, Burst: 1 << 20, Cburst: 1 << 20, Prio: 3, Quantum: 200000}
+
+	b, err := o.encode()
+	if err != nil {
+		t.Fatalf("encode() failed: %s", err)
+	}
+
+	parsed, err := parseHtbClassOptions(b)
+	if err != nil {
+		t.Fatalf("parseHtbClassOptions() failed: %s", err)
+	}
+
+	if !reflect.DeepEqual(parsed, o) {
+		t.Errorf("parseHtbClassOptions() failed: expected %+v, returned %+v", o, parsed)
+	}
+}
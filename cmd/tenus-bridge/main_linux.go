@@ -0,0 +1,20 @@
+// Command tenus-bridge is CNI plugin which connects containers to a Linux bridge via veth pairs.
+//
+// Example network configuration:
+//
+//	{
+//		"cniVersion": "1.0.0",
+//		"name": "mynet",
+//		"type": "tenus-bridge",
+//		"bridge": "cni0",
+//		"isGateway": true,
+//		"mtu": 1500,
+//		"ipam": {"type": "host-local", "subnet": "10.22.0.0/16"}
+//	}
+package main
+
+import "github.com/milosgajdos/tenus/cni"
+
+func main() {
+	cni.PluginMain(&cni.BridgePlugin{})
+}
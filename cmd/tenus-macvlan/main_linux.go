@@ -0,0 +1,19 @@
+// Command tenus-macvlan is CNI plugin which connects containers to the network of a host link via macvlan links.
+//
+// Example network configuration:
+//
+//	{
+//		"cniVersion": "1.0.0",
+//		"name": "mynet",
+//		"type": "tenus-macvlan",
+//		"master": "eth0",
+//		"mode": "bridge",
+//		"ipam": {"type": "host-local", "subnet": "192.168.1.0/24"}
+//	}
+package main
+
+import "github.com/milosgajdos/tenus/cni"
+
+func main() {
+	cni.PluginMain(&cni.MacVlanPlugin{})
+}
@@ -0,0 +1,19 @@
+// Command tenus-vlan is CNI plugin which connects containers to a VLAN of a host link via vlan links.
+//
+// Example network configuration:
+//
+//	{
+//		"cniVersion": "1.0.0",
+//		"name": "mynet",
+//		"type": "tenus-vlan",
+//		"master": "eth0",
+//		"vlanId": 100,
+//		"ipam": {"type": "host-local", "subnet": "10.100.0.0/24"}
+//	}
+package main
+
+import "github.com/milosgajdos/tenus/cni"
+
+func main() {
+	cni.PluginMain(&cni.VlanPlugin{})
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/milosgajdos/tenus"
+)
+
+// linkCmd manages generic network links
+func linkCmd(c *cli, args []string) error {
+	return subcommand(c, "link", args, map[string]command{
+		"add":  linkAdd,
+		"del":  linkDel,
+		"set":  linkSet,
+		"list": linkList,
+	})
+}
+
+// linkAdd creates dummy link: link add [-mtu N] [-mac MAC] [-up] NAME
+func linkAdd(c *cli, args []string) error {
+	fs := newFlagSet("link add")
+	mtu := fs.Int("mtu", 0, "MTU of the link")
+	mac := fs.String("mac", "", "MAC address of the link")
+	up := fs.Bool("up", false, "bring the link up")
+
+	args, err := parseArgs(fs, args, "NAME")
+	if err != nil {
+		return err
+	}
+
+	if err := validName(args[0]); err != nil {
+		return err
+	}
+
+	opts := tenus.LinkOptions{MTU: *mtu, MacAddr: *mac}
+	if *up {
+		opts.Flags = net.FlagUp
+	}
+
+	link, err := tenus.NewLinkWithOptions(args[0], opts)
+	if err != nil {
+		return err
+	}
+
+	return c.printLinkNames(link.NetInterface().Name)
+}
+
+// linkDel deletes link: link del NAME
+func linkDel(c *cli, args []string) error {
+	args, err := parseArgs(newFlagSet("link del"), args, "NAME")
+	if err != nil {
+		return err
+	}
+
+	return tenus.DeleteLink(args[0])
+}
+
+// linkSet configures existing link:
+// link set [-mtu N] [-mac MAC] [-name NEWNAME] [-up|-down] [-netns PID|-netns-path PATH] NAME
+func linkSet(c *cli, args []string) error {
+	fs := newFlagSet("link set")
+	mtu := fs.Int("mtu", 0, "MTU of the link")
+	mac := fs.String("mac", "", "MAC address of the link")
+	newName := fs.String("name", "", "new name of the link; the link must be down")
+	up := fs.Bool("up", false, "bring the link up")
+	down := fs.Bool("down", false, "bring the link down")
+	nsPid := fs.Int("netns", 0, "move the link to network namespace of the process")
+	nsPath := fs.String("netns-path", "", "move the link to network namespace bind mounted at the path")
+
+	args, err := parseArgs(fs, args, "NAME")
+	if err != nil {
+		return err
+	}
+
+	if *up && *down {
+		return usageError("link set: -up and -down are mutually exclusive")
+	}
+
+	if *nsPid != 0 && *nsPath != "" {
+		return usageError("link set: -netns and -netns-path are mutually exclusive")
+	}
+
+	name := args[0]
+	if *newName != "" {
+		if err := validName(*newName); err != nil {
+			return err
+		}
+	}
+
+	link, err := tenus.NewLinkFrom(name)
+	if err != nil {
+		return err
+	}
+
+	if *mac != "" {
+		if err := link.SetLinkMacAddress(*mac); err != nil {
+			return err
+		}
+	}
+
+	if *mtu != 0 {
+		if err := link.SetLinkMTU(*mtu); err != nil {
+			return err
+		}
+	}
+
+	if *down {
+		if err := link.SetLinkDown(); err != nil {
+			return err
+		}
+	}
+
+	if *newName != "" {
+		if err := tenus.RenameInterfaceByName(name, *newName); err != nil {
+			return err
+		}
+
+		if link, err = tenus.NewLinkFrom(*newName); err != nil {
+			return err
+		}
+	}
+
+	if *up {
+		if err := link.SetLinkUp(); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case *nsPid != 0:
+		return link.SetLinkNetNsPid(*nsPid)
+	case *nsPath != "":
+		// links returned by NewLinkFrom are *tenus.Link which can be moved by namespace path
+		return link.(*tenus.Link).SetLinkNsFd(*nsPath)
+	}
+
+	return nil
+}
+
+// linkList prints all links or the link of the given name: link list [NAME]
+func linkList(c *cli, args []string) error {
+	fs := newFlagSet("link list")
+	if err := fs.Parse(args); err != nil {
+		return usageError(fmt.Sprintf("link list: %s", err))
+	}
+
+	if fs.NArg() > 1 {
+		return usageError("link list: expected arguments: [NAME]")
+	}
+
+	if fs.NArg() == 1 {
+		return c.printLinkNames(fs.Arg(0))
+	}
+
+	links, err := tenus.LinkList()
+	if err != nil {
+		return err
+	}
+
+	return c.printLinks(links)
+}
+
+// vethCmd manages veth pairs
+func vethCmd(c *cli, args []string) error {
+	return subcommand(c, "veth", args, map[string]command{
+		"add": vethAdd,
+	})
+}
+
+// vethAdd creates veth pair: veth add [-peer NAME] [-txqueuelen N] NAME
+func vethAdd(c *cli, args []string) error {
+	fs := newFlagSet("veth add")
+	peer := fs.String("peer", "", "name of the peer link")
+	txQueueLen := fs.Int("txqueuelen", 0, "TX queue length of the links")
+
+	args, err := parseArgs(fs, args, "NAME")
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{args[0], *peer} {
+		if name == "" {
+			continue
+		}
+
+		if err := validName(name); err != nil {
+			return err
+		}
+	}
+
+	veth, err := tenus.NewVethPairWithOptions(args[0], tenus.VethOptions{PeerName: *peer, TxQueueLen: *txQueueLen})
+	if err != nil {
+		return err
+	}
+
+	return c.printLinkNames(veth.NetInterface().Name, veth.PeerNetInterface().Name)
+}
+
+// vlanCmd manages VLAN links
+func vlanCmd(c *cli, args []string) error {
+	return subcommand(c, "vlan", args, map[string]command{
+		"add": vlanAdd,
+	})
+}
+
+// vlanAdd creates VLAN link: vlan add -id ID [-name NAME] [-mac MAC] MASTER
+func vlanAdd(c *cli, args []string) error {
+	fs := newFlagSet("vlan add")
+	id := fs.Uint("id", 0, "VLAN tag id")
+	name := fs.String("name", "", "name of the VLAN link")
+	mac := fs.String("mac", "", "MAC address of the VLAN link")
+
+	args, err := parseArgs(fs, args, "MASTER")
+	if err != nil {
+		return err
+	}
+
+	if *id == 0 || *id > 4094 {
+		return usageError(fmt.Sprintf("vlan add: invalid VLAN id %d", *id))
+	}
+
+	if *name != "" {
+		if err := validName(*name); err != nil {
+			return err
+		}
+	}
+
+	vlan, err := tenus.NewVlanLinkWithOptions(args[0], tenus.VlanOptions{Dev: *name, Id: uint16(*id), MacAddr: *mac})
+	if err != nil {
+		return err
+	}
+
+	return c.printLinkNames(vlan.NetInterface().Name)
+}
+
+// macVlanCmd manages MAC VLAN links
+func macVlanCmd(c *cli, args []string) error {
+	return subcommand(c, "macvlan", args, map[string]command{
+		"add": func(c *cli, args []string) error {
+			return macVlanAdd(c, "macvlan add", args, func(master string, opts tenus.MacVlanOptions) (tenus.Linker, error) {
+				return tenus.NewMacVlanLinkWithOptions(master, opts)
+			})
+		},
+	})
+}
+
+// macVtapCmd manages MAC VTAP links
+func macVtapCmd(c *cli, args []string) error {
+	return subcommand(c, "macvtap", args, map[string]command{
+		"add": func(c *cli, args []string) error {
+			return macVlanAdd(c, "macvtap add", args, func(master string, opts tenus.MacVlanOptions) (tenus.Linker, error) {
+				return tenus.NewMacVtapLinkWithOptions(master, opts)
+			})
+		},
+	})
+}
+
+// macVlanAdd creates MAC VLAN or MAC VTAP link: add [-name NAME] [-mode MODE] [-mac MAC] MASTER
+func macVlanAdd(c *cli, name string, args []string, create func(string, tenus.MacVlanOptions) (tenus.Linker, error)) error {
+	fs := newFlagSet(name)
+	dev := fs.String("name", "", "name of the link")
+	mode := fs.String("mode", "", "operation mode: private, vepa or bridge")
+	mac := fs.String("mac", "", "MAC address of the link")
+
+	args, err := parseArgs(fs, args, "MASTER")
+	if err != nil {
+		return err
+	}
+
+	opts := tenus.MacVlanOptions{Dev: *dev, Mode: *mode, MacAddr: *mac}
+	if err := tenus.ValidateMacVlanOptions(&opts); err != nil {
+		return err
+	}
+
+	link, err := create(args[0], opts)
+	if err != nil {
+		return err
+	}
+
+	return c.printLinkNames(link.NetInterface().Name)
+}
+
+// bridgeCmd manages bridges and their ports
+func bridgeCmd(c *cli, args []string) error {
+	return subcommand(c, "bridge", args, map[string]command{
+		"add":   bridgeAdd,
+		"addif": bridgeAddIf,
+		"delif": bridgeDelIf,
+	})
+}
+
+// bridgeAdd creates bridge: bridge add NAME
+func bridgeAdd(c *cli, args []string) error {
+	args, err := parseArgs(newFlagSet("bridge add"), args, "NAME")
+	if err != nil {
+		return err
+	}
+
+	if err := validName(args[0]); err != nil {
+		return err
+	}
+
+	br, err := tenus.NewBridgeWithName(args[0])
+	if err != nil {
+		return err
+	}
+
+	return c.printLinkNames(br.NetInterface().Name)
+}
+
+// bridgeAddIf adds link to bridge: bridge addif BRIDGE NAME
+func bridgeAddIf(c *cli, args []string) error {
+	args, err := parseArgs(newFlagSet("bridge addif"), args, "BRIDGE", "NAME")
+	if err != nil {
+		return err
+	}
+
+	br, err := tenus.BridgeFromName(args[0])
+	if err != nil {
+		return err
+	}
+
+	link, err := tenus.NewLinkFrom(args[1])
+	if err != nil {
+		return err
+	}
+
+	return br.AddSlaveIfc(link.NetInterface())
+}
+
+// bridgeDelIf removes link from bridge: bridge delif BRIDGE NAME
+func bridgeDelIf(c *cli, args []string) error {
+	args, err := parseArgs(newFlagSet("bridge delif"), args, "BRIDGE", "NAME")
+	if err != nil {
+		return err
+	}
+
+	br, err := tenus.BridgeFromName(args[0])
+	if err != nil {
+		return err
+	}
+
+	link, err := tenus.NewLinkFrom(args[1])
+	if err != nil {
+		return err
+	}
+
+	return br.RemoveSlaveIfc(link.NetInterface())
+}
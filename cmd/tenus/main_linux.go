@@ -0,0 +1,199 @@
+// Command tenus configures Linux network links, addresses, routes and network namespaces.
+//
+// It is a thin wrapper around the tenus package, so the links it creates are validated and
+// configured exactly the same way as by Go programs using the package.
+//
+// Usage:
+//
+//	tenus [-json] [-dry-run] [-n NETNS] COMMAND SUBCOMMAND [FLAGS] ARGS
+//
+// Commands:
+//
+//	link add [-mtu N] [-mac MAC] [-up] NAME
+//	link del NAME
+//	link set [-mtu N] [-mac MAC] [-name NEWNAME] [-up|-down] [-netns PID|-netns-path PATH] NAME
+//	link list [NAME]
+//	veth add [-peer NAME] [-txqueuelen N] NAME
+//	vlan add -id ID [-name NAME] [-mac MAC] MASTER
+//	macvlan add [-name NAME] [-mode MODE] [-mac MAC] MASTER
+//	macvtap add [-name NAME] [-mode MODE] [-mac MAC] MASTER
+//	bridge add NAME
+//	bridge addif BRIDGE NAME
+//	bridge delif BRIDGE NAME
+//	addr add NAME CIDR
+//	addr del NAME CIDR
+//	addr list NAME
+//	route add default via GW dev NAME
+//	route del default via GW dev NAME
+//	route list
+//	ns add NAME
+//	ns del NAME
+//	ns list
+//	ns exec NAME COMMAND [ARGS...]
+//
+// With -n the command runs in the named network namespace, like ip -n does.
+// With -json the links, addresses, routes and namespaces are printed as JSON.
+// With -dry-run no changes are made; the command prints them as iproute2 batch script instead.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/milosgajdos/tenus"
+)
+
+// usageError is returned when the command line arguments are invalid
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// cli holds global options and output of the command
+type cli struct {
+	out  io.Writer
+	json bool
+}
+
+// command runs a command with its arguments
+type command func(c *cli, args []string) error
+
+// commands maps top level commands to their implementations
+var commands = map[string]command{
+	"link":    linkCmd,
+	"veth":    vethCmd,
+	"vlan":    vlanCmd,
+	"macvlan": macVlanCmd,
+	"macvtap": macVtapCmd,
+	"bridge":  bridgeCmd,
+	"addr":    addrCmd,
+	"route":   routeCmd,
+	"ns":      nsCmd,
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "tenus: %s\n", err)
+
+		var usageErr usageError
+		if errors.As(err, &usageErr) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// run parses global flags and runs the command
+func run(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("tenus", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	jsonOut := fs.Bool("json", false, "print output as JSON")
+	netns := fs.String("n", "", "run the command in the named network namespace")
+	dryRun := fs.Bool("dry-run", false, "print changes as iproute2 batch script instead of making them")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: tenus [-json] [-dry-run] [-n NETNS] COMMAND SUBCOMMAND [FLAGS] ARGS\n\nCommands: %s\n\nFlags:\n",
+			strings.Join(commandNames(commands), ", "))
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return usageError("no command specified")
+	}
+
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		return usageError(fmt.Sprintf("unknown command %q", fs.Arg(0)))
+	}
+
+	c := &cli{out: stdout, json: *jsonOut}
+	runCmd := func() error {
+		if *netns != "" {
+			return tenus.ExecInNamedNetNs(*netns, func() error {
+				return cmd(c, fs.Args()[1:])
+			})
+		}
+
+		return cmd(c, fs.Args()[1:])
+	}
+
+	if !*dryRun {
+		return runCmd()
+	}
+
+	c.out = ioutil.Discard
+	ops, err := tenus.DryRun(runCmd)
+	if err != nil {
+		return err
+	}
+
+	return tenus.WriteIPBatch(stdout, ops)
+}
+
+// subcommand runs the subcommand selected by the first argument
+func subcommand(c *cli, name string, args []string, subs map[string]command) error {
+	if len(args) == 0 {
+		return usageError(fmt.Sprintf("%s: no subcommand specified, expected one of: %s",
+			name, strings.Join(commandNames(subs), ", ")))
+	}
+
+	cmd, ok := subs[args[0]]
+	if !ok {
+		return usageError(fmt.Sprintf("%s: unknown subcommand %q, expected one of: %s",
+			name, args[0], strings.Join(commandNames(subs), ", ")))
+	}
+
+	return cmd(c, args[1:])
+}
+
+// newFlagSet returns flag set of the command which reports errors as usageError
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+
+	return fs
+}
+
+// parseArgs parses the command's flags and checks the number of its positional arguments
+func parseArgs(fs *flag.FlagSet, args []string, names ...string) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, usageError(fmt.Sprintf("%s: %s", fs.Name(), err))
+	}
+
+	if fs.NArg() != len(names) {
+		return nil, usageError(fmt.Sprintf("%s: expected arguments: %s", fs.Name(), strings.Join(names, " ")))
+	}
+
+	return fs.Args(), nil
+}
+
+// validName validates link name the same way tenus does before creating links
+func validName(name string) error {
+	if ok, err := tenus.NetInterfaceNameValid(name); !ok {
+		return err
+	}
+
+	return nil
+}
+
+// commandNames returns sorted names of the commands
+func commandNames(cmds map[string]command) []string {
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/milosgajdos/tenus"
+)
+
+type cliTest struct {
+	args []string
+	out  string
+}
+
+var cliTests = []cliTest{
+	{[]string{"veth", "add", "-peer", "vethcli02", "vethcli01"}, "vethcli01@vethcli02"},
+	{[]string{"bridge", "add", "brcli01"}, "brcli01"},
+	{[]string{"bridge", "addif", "brcli01", "vethcli02"}, ""},
+	{[]string{"link", "set", "-mtu", "1400", "-up", "brcli01"}, ""},
+	{[]string{"link", "list", "vethcli02"}, "master brcli01"},
+	{[]string{"link", "list", "brcli01"}, "mtu 1400"},
+	{[]string{"addr", "add", "brcli01", "10.30.0.1/24"}, ""},
+	{[]string{"addr", "list", "brcli01"}, "10.30.0.1/24"},
+	{[]string{"route", "add", "default", "via", "10.30.0.254", "dev", "brcli01"}, ""},
+	{[]string{"route", "list"}, "default via 10.30.0.254 dev brcli01"},
+	{[]string{"route", "del", "default", "via", "10.30.0.254", "dev", "brcli01"}, ""},
+	{[]string{"bridge", "delif", "brcli01", "vethcli02"}, ""},
+	{[]string{"link", "set", "-down", "-name", "brcli02", "brcli01"}, ""},
+	{[]string{"link", "list"}, "brcli02"},
+	{[]string{"link", "del", "vethcli01"}, ""},
+}
+
+func Test_Run(t *testing.T) {
+	defer tenus.SetBackend(tenus.SetBackend(tenus.NewFakeBackend()))
+
+	for _, tt := range cliTests {
+		var out bytes.Buffer
+		if err := run(tt.args, &out, ioutil.Discard); err != nil {
+			t.Fatalf("run(%v) failed: %s", tt.args, err)
+		}
+
+		if !strings.Contains(out.String(), tt.out) {
+			t.Fatalf("run(%v) failed: expected output containing %q, returned %q", tt.args, tt.out, out.String())
+		}
+	}
+
+	if _, err := tenus.LinkAttrsByName("vethcli02"); !errors.Is(err, tenus.ErrLinkNotFound) {
+		t.Fatalf("link del failed to delete veth peer: returned %v", err)
+	}
+}
+
+func Test_RunJSON(t *testing.T) {
+	defer tenus.SetBackend(tenus.SetBackend(tenus.NewFakeBackend()))
+
+	var out bytes.Buffer
+	if err := run([]string{"-json", "veth", "add", "-peer", "vethcli02", "-txqueuelen", "500", "vethcli01"}, &out, ioutil.Discard); err != nil {
+		t.Fatalf("run() failed: %s", err)
+	}
+
+	var links []linkInfo
+	if err := json.Unmarshal(out.Bytes(), &links); err != nil {
+		t.Fatalf("run() failed to print JSON: %s", err)
+	}
+
+	if len(links) != 2 || links[0].Name != "vethcli01" || links[0].Link != "vethcli02" ||
+		links[0].Kind != "veth" || links[0].TxQueueLen != 500 || links[0].Address == "" {
+		t.Fatalf("run() failed: returned %+v", links)
+	}
+}
+
+func Test_RunDryRun(t *testing.T) {
+	defer tenus.SetBackend(tenus.SetBackend(tenus.NewFakeBackend()))
+	defer tenus.SetLinkOwner(tenus.SetLinkOwner(""))
+
+	var out bytes.Buffer
+	if err := run([]string{"-dry-run", "veth", "add", "-peer", "vethcli02", "vethcli01"}, &out, ioutil.Discard); err != nil {
+		t.Fatalf("run() failed: %s", err)
+	}
+
+	if expected := "link add vethcli01 type veth peer name vethcli02\n"; out.String() != expected {
+		t.Fatalf("run() failed: expected %q, returned %q", expected, out.String())
+	}
+
+	if _, err := tenus.LinkAttrsByName("vethcli01"); err == nil {
+		t.Fatalf("run() with -dry-run created link vethcli01")
+	}
+}
+
+type cliErrorTest struct {
+	args  []string
+	usage bool
+	err   error
+}
+
+var cliErrorTests = []cliErrorTest{
+	{[]string{}, true, nil},
+	{[]string{"bogus"}, true, nil},
+	{[]string{"link"}, true, nil},
+	{[]string{"link", "bogus"}, true, nil},
+	{[]string{"link", "add"}, true, nil},
+	{[]string{"link", "add", "-mtu", "x", "dummycli01"}, true, nil},
+	{[]string{"link", "set", "-up", "-down", "lo"}, true, nil},
+	{[]string{"vlan", "add", "-id", "5000", "lo"}, true, nil},
+	{[]string{"addr", "add", "lo", "10.0.0.1"}, true, nil},
+	{[]string{"route", "add", "default", "dev", "lo"}, true, nil},
+	{[]string{"link", "add", "dummy cli"}, false, tenus.ErrInvalidName},
+	{[]string{"veth", "add", "-peer", "a", "vethcli01"}, false, tenus.ErrInvalidName},
+	{[]string{"link", "add", "lo"}, false, tenus.ErrLinkExists},
+	{[]string{"link", "del", "dummycli01"}, false, tenus.ErrLinkNotFound},
+	{[]string{"bridge", "addif", "brcli01", "lo"}, false, tenus.ErrLinkNotFound},
+	{[]string{"macvlan", "add", "-mode", "bogus", "lo"}, false, nil},
+	{[]string{"macvtap", "add", "-name", "lo", "lo"}, false, tenus.ErrLinkExists},
+	{[]string{"-n", "nscli01", "link", "list"}, false, tenus.ErrNsNotFound},
+}
+
+func Test_RunErrors(t *testing.T) {
+	defer tenus.SetBackend(tenus.SetBackend(tenus.NewFakeBackend()))
+
+	for _, tt := range cliErrorTests {
+		err := run(tt.args, ioutil.Discard, ioutil.Discard)
+		if err == nil {
+			t.Errorf("run(%v) expected to fail", tt.args)
+			continue
+		}
+
+		var usageErr usageError
+		if usage := errors.As(err, &usageErr); usage != tt.usage {
+			t.Errorf("run(%v) failed: expected usage error %v, returned %v", tt.args, tt.usage, err)
+		}
+
+		if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("run(%v) failed: expected %v, returned %v", tt.args, tt.err, err)
+		}
+	}
+
+	links, err := tenus.LinkList()
+	if err != nil {
+		t.Fatalf("LinkList() failed: %s", err)
+	}
+
+	if len(links) != 1 {
+		t.Fatalf("run() of failed commands created links: %+v", links)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+
+	"github.com/milosgajdos/tenus"
+)
+
+// addrCmd manages IP addresses of links
+func addrCmd(c *cli, args []string) error {
+	return subcommand(c, "addr", args, map[string]command{
+		"add":  addrAdd,
+		"del":  addrDel,
+		"list": addrList,
+	})
+}
+
+// addrAdd assigns IP address to link: addr add NAME CIDR
+func addrAdd(c *cli, args []string) error {
+	args, err := parseArgs(newFlagSet("addr add"), args, "NAME", "CIDR")
+	if err != nil {
+		return err
+	}
+
+	ip, network, err := net.ParseCIDR(args[1])
+	if err != nil {
+		return usageError(fmt.Sprintf("addr add: %s", err))
+	}
+
+	link, err := tenus.NewLinkFrom(args[0])
+	if err != nil {
+		return err
+	}
+
+	return link.SetLinkIp(ip, network)
+}
+
+// addrDel removes IP address from link: addr del NAME CIDR
+func addrDel(c *cli, args []string) error {
+	args, err := parseArgs(newFlagSet("addr del"), args, "NAME", "CIDR")
+	if err != nil {
+		return err
+	}
+
+	ip, network, err := net.ParseCIDR(args[1])
+	if err != nil {
+		return usageError(fmt.Sprintf("addr del: %s", err))
+	}
+
+	link, err := tenus.NewLinkFrom(args[0])
+	if err != nil {
+		return err
+	}
+
+	return link.UnsetLinkIp(ip, network)
+}
+
+// addrList prints IP addresses of link: addr list NAME
+func addrList(c *cli, args []string) error {
+	args, err := parseArgs(newFlagSet("addr list"), args, "NAME")
+	if err != nil {
+		return err
+	}
+
+	addrs, err := tenus.LinkAddrsByName(args[0])
+	if err != nil {
+		return err
+	}
+
+	return c.printAddrs(addrs)
+}
+
+// routeCmd manages default routes
+func routeCmd(c *cli, args []string) error {
+	return subcommand(c, "route", args, map[string]command{
+		"add":  routeAdd,
+		"del":  routeDel,
+		"list": routeList,
+	})
+}
+
+// routeAdd adds default route: route add default via GW dev NAME
+func routeAdd(c *cli, args []string) error {
+	gw, name, err := parseDefaultRoute("route add", args)
+	if err != nil {
+		return err
+	}
+
+	link, err := tenus.NewLinkFrom(name)
+	if err != nil {
+		return err
+	}
+
+	return link.SetLinkDefaultGw(&gw)
+}
+
+// routeDel deletes default route: route del default via GW dev NAME
+func routeDel(c *cli, args []string) error {
+	gw, name, err := parseDefaultRoute("route del", args)
+	if err != nil {
+		return err
+	}
+
+	return tenus.DelDefaultGw(gw, name)
+}
+
+// routeList prints routes of the main routing table: route list
+func routeList(c *cli, args []string) error {
+	if _, err := parseArgs(newFlagSet("route list"), args); err != nil {
+		return err
+	}
+
+	routes, err := tenus.RouteList()
+	if err != nil {
+		return err
+	}
+
+	return c.printRoutes(routes)
+}
+
+// parseDefaultRoute parses arguments in iproute2 syntax: default via GW dev NAME
+func parseDefaultRoute(name string, args []string) (net.IP, string, error) {
+	if len(args) != 5 || args[0] != "default" || args[1] != "via" || args[3] != "dev" {
+		return nil, "", usageError(fmt.Sprintf("%s: expected arguments: default via GW dev NAME", name))
+	}
+
+	gw := net.ParseIP(args[2])
+	if gw == nil {
+		return nil, "", usageError(fmt.Sprintf("%s: invalid gateway address %s", name, args[2]))
+	}
+
+	return gw, args[4], nil
+}
+
+// nsCmd manages named network namespaces
+func nsCmd(c *cli, args []string) error {
+	return subcommand(c, "ns", args, map[string]command{
+		"add":  nsAdd,
+		"del":  nsDel,
+		"list": nsList,
+		"exec": nsExec,
+	})
+}
+
+// nsAdd creates named network namespace: ns add NAME
+func nsAdd(c *cli, args []string) error {
+	args, err := parseArgs(newFlagSet("ns add"), args, "NAME")
+	if err != nil {
+		return err
+	}
+
+	return tenus.NewNamedNetNs(args[0])
+}
+
+// nsDel deletes named network namespace: ns del NAME
+func nsDel(c *cli, args []string) error {
+	args, err := parseArgs(newFlagSet("ns del"), args, "NAME")
+	if err != nil {
+		return err
+	}
+
+	return tenus.DeleteNamedNetNs(args[0])
+}
+
+// nsList prints named network namespaces: ns list
+func nsList(c *cli, args []string) error {
+	if _, err := parseArgs(newFlagSet("ns list"), args); err != nil {
+		return err
+	}
+
+	names, err := tenus.NamedNetNsList()
+	if err != nil {
+		return err
+	}
+
+	return c.printNames(names)
+}
+
+// nsExec runs command in named network namespace: ns exec NAME COMMAND [ARGS...]
+func nsExec(c *cli, args []string) error {
+	if len(args) < 2 {
+		return usageError("ns exec: expected arguments: NAME COMMAND [ARGS...]")
+	}
+
+	return tenus.ExecInNamedNetNs(args[0], func() error {
+		// the command is forked from the locked thread so it inherits its network namespace
+		cmd := exec.Command(args[1], args[2:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = c.out
+		cmd.Stderr = os.Stderr
+
+		return cmd.Run()
+	})
+}
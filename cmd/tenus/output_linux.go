@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/milosgajdos/tenus"
+)
+
+// linkInfo is the link as printed by the command. JSON keys follow ip -json output.
+type linkInfo struct {
+	Index      int      `json:"ifindex"`
+	Name       string   `json:"ifname"`
+	Kind       string   `json:"kind,omitempty"`
+	Flags      []string `json:"flags"`
+	MTU        int      `json:"mtu"`
+	OperState  string   `json:"operstate"`
+	Master     string   `json:"master,omitempty"`
+	Link       string   `json:"link,omitempty"`
+	TxQueueLen int      `json:"txqlen"`
+	Address    string   `json:"address,omitempty"`
+	Alias      string   `json:"ifalias,omitempty"`
+}
+
+// routeInfo is the route as printed by the command. JSON keys follow ip -json output.
+type routeInfo struct {
+	Dst     string `json:"dst"`
+	Gateway string `json:"gateway,omitempty"`
+	Dev     string `json:"dev"`
+}
+
+// printLinkNames prints links of the given names
+func (c *cli) printLinkNames(names ...string) error {
+	links := make([]*tenus.LinkAttrs, 0, len(names))
+	for _, name := range names {
+		attrs, err := tenus.LinkAttrsByName(name)
+		if err != nil {
+			return err
+		}
+
+		links = append(links, attrs)
+	}
+
+	return c.printLinks(links)
+}
+
+// printLinks prints links one per line or as JSON array
+func (c *cli) printLinks(links []*tenus.LinkAttrs) error {
+	names, err := linkNames()
+	if err != nil {
+		return err
+	}
+
+	infos := make([]linkInfo, 0, len(links))
+	for _, attrs := range links {
+		info := linkInfo{
+			Index:      attrs.Index,
+			Name:       attrs.Name,
+			Kind:       attrs.Kind,
+			Flags:      linkFlags(attrs.Flags),
+			MTU:        attrs.MTU,
+			OperState:  strings.ToUpper(attrs.OperState.String()),
+			Master:     names[attrs.MasterIndex],
+			TxQueueLen: attrs.TxQueueLen,
+			Alias:      attrs.Alias,
+		}
+
+		// parent device in another network namespace can't be named
+		if attrs.NetNsID < 0 {
+			info.Link = names[attrs.ParentIndex]
+		}
+
+		if len(attrs.HardwareAddr) > 0 {
+			info.Address = attrs.HardwareAddr.String()
+		}
+
+		infos = append(infos, info)
+	}
+
+	if c.json {
+		return c.printJSON(infos)
+	}
+
+	for _, info := range infos {
+		name := info.Name
+		if info.Link != "" {
+			name += "@" + info.Link
+		}
+
+		line := fmt.Sprintf("%d: %s: <%s> mtu %d", info.Index, name, strings.ToUpper(strings.Join(info.Flags, ",")), info.MTU)
+		if info.Master != "" {
+			line += " master " + info.Master
+		}
+		line += fmt.Sprintf(" state %s qlen %d", info.OperState, info.TxQueueLen)
+		if info.Kind != "" {
+			line += " kind " + info.Kind
+		}
+		if info.Address != "" {
+			line += " address " + info.Address
+		}
+
+		fmt.Fprintln(c.out, line)
+	}
+
+	return nil
+}
+
+// printAddrs prints IP addresses in CIDR notation
+func (c *cli) printAddrs(addrs []*net.IPNet) error {
+	cidrs := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		cidrs = append(cidrs, addr.String())
+	}
+
+	return c.printNames(cidrs)
+}
+
+// printRoutes prints routes one per line or as JSON array
+func (c *cli) printRoutes(routes []*tenus.Route) error {
+	names, err := linkNames()
+	if err != nil {
+		return err
+	}
+
+	infos := make([]routeInfo, 0, len(routes))
+	for _, route := range routes {
+		info := routeInfo{Dst: "default", Dev: names[route.Index]}
+		if route.Dst != nil {
+			info.Dst = route.Dst.String()
+		}
+		if route.Gw != nil {
+			info.Gateway = route.Gw.String()
+		}
+
+		infos = append(infos, info)
+	}
+
+	if c.json {
+		return c.printJSON(infos)
+	}
+
+	for _, info := range infos {
+		line := info.Dst
+		if info.Gateway != "" {
+			line += " via " + info.Gateway
+		}
+		fmt.Fprintln(c.out, line+" dev "+info.Dev)
+	}
+
+	return nil
+}
+
+// printNames prints strings one per line or as JSON array
+func (c *cli) printNames(names []string) error {
+	if c.json {
+		return c.printJSON(names)
+	}
+
+	for _, name := range names {
+		fmt.Fprintln(c.out, name)
+	}
+
+	return nil
+}
+
+// printJSON prints v as indented JSON
+func (c *cli) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
+
+// linkNames maps indices of all links to their names
+func linkNames() (map[int]string, error) {
+	links, err := tenus.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[int]string, len(links))
+	for _, attrs := range links {
+		names[attrs.Index] = attrs.Name
+	}
+
+	return names, nil
+}
+
+// linkFlags returns names of the network flags
+func linkFlags(flags net.Flags) []string {
+	if flags == 0 {
+		return []string{}
+	}
+
+	return strings.Split(flags.String(), "|")
+}
@@ -0,0 +1,258 @@
+package cni
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/milosgajdos/tenus"
+)
+
+// DefaultBridge is the name of the bridge BridgePlugin connects containers to if the configuration does not specify one
+const DefaultBridge = "cni0"
+
+// BridgeConf is network configuration of BridgePlugin.
+type BridgeConf struct {
+	NetConf
+	// Bridge name. DefaultBridge is used if empty.
+	Bridge string `json:"bridge,omitempty"`
+	// IsGateway assigns gateway addresses of the IPAM result to the bridge
+	IsGateway bool `json:"isGateway,omitempty"`
+	// MTU of the veth pair. MTU is not changed if 0.
+	MTU int `json:"mtu,omitempty"`
+}
+
+// BridgePlugin connects containers to a Linux bridge on the host.
+//
+// ADD creates the bridge if it does not exist and connects the container to it via veth pair.
+// The host veth link is attached to the bridge, the peer link is moved into the container
+// and configured with IP addresses allocated by the IPAM plugin.
+type BridgePlugin struct{}
+
+// loadBridgeConf decodes BridgePlugin network configuration
+func loadBridgeConf(data []byte) (*BridgeConf, error) {
+	conf := &BridgeConf{}
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, newError(CodeDecodingFailure, "Could not decode network configuration: %s", err)
+	}
+
+	if conf.Bridge == "" {
+		conf.Bridge = DefaultBridge
+	}
+
+	if ok, err := tenus.NetInterfaceNameValid(conf.Bridge); !ok {
+		return nil, newError(CodeInvalidNetworkConfig, "Invalid bridge name: %s", err)
+	}
+
+	return conf, nil
+}
+
+// Add connects the container to the bridge and returns the bridge, the host veth link and the container interface.
+func (p *BridgePlugin) Add(args *Args) (*Result, error) {
+	conf, err := loadBridgeConf(args.StdinData)
+	if err != nil {
+		return nil, err
+	}
+
+	br, err := ensureBridge(conf.Bridge)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := execIPAM(args, conf.IPAM.Type, "ADD")
+	if err != nil {
+		return nil, err
+	}
+
+	hostIfc, ifc, err := addBridgeVeth(args, conf, br, result)
+	if err != nil {
+		execIPAM(args, conf.IPAM.Type, "DEL")
+		return nil, err
+	}
+
+	if conf.IsGateway {
+		if err := setBridgeGateway(br, result); err != nil {
+			deleteContainerLink(args)
+			execIPAM(args, conf.IPAM.Type, "DEL")
+			return nil, err
+		}
+	}
+
+	brIfc := br.NetInterface()
+	result.Interfaces = []*Interface{
+		{Name: brIfc.Name, Mac: brIfc.HardwareAddr.String()},
+		hostIfc,
+		ifc,
+	}
+
+	return result, nil
+}
+
+// Del releases the container's IP addresses and deletes its veth pair.
+func (p *BridgePlugin) Del(args *Args) error {
+	conf, err := loadBridgeConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	return delContainer(args, conf.IPAM.Type)
+}
+
+// Check verifies the container interface and that its host veth link is attached to the bridge.
+func (p *BridgePlugin) Check(args *Args) error {
+	conf, err := loadBridgeConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	attrs, err := checkContainer(args, conf.IPAM.Type, conf.PrevResult, "veth")
+	if err != nil {
+		return err
+	}
+
+	br, err := tenus.LinkAttrsByName(conf.Bridge)
+	if err != nil {
+		return err
+	}
+
+	// veth peers report each other's index as the parent index; the peer is in the host network namespace
+	for _, ifc := range conf.PrevResult.Interfaces {
+		if ifc.Sandbox != "" || ifc.Name == conf.Bridge {
+			continue
+		}
+
+		host, err := tenus.LinkAttrsByName(ifc.Name)
+		if err != nil {
+			return err
+		}
+
+		if host.Index != attrs.ParentIndex {
+			return fmt.Errorf("Host interface %s is not peer of container interface %s", ifc.Name, args.IfName)
+		}
+
+		if host.MasterIndex != br.Index {
+			return fmt.Errorf("Host interface %s is not attached to bridge %s", ifc.Name, conf.Bridge)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("Host interface of container interface %s not found in prevResult", args.IfName)
+}
+
+// ensureBridge returns the bridge of the given name which is up, creating it if it does not exist
+func ensureBridge(name string) (tenus.Bridger, error) {
+	attrs, err := tenus.LinkAttrsByName(name)
+	switch {
+	case errors.Is(err, tenus.ErrLinkNotFound):
+		br, err := tenus.NewBridgeWithName(name)
+		if err != nil && !errors.Is(err, tenus.ErrLinkExists) {
+			return nil, err
+		}
+		// another plugin instance may have created the bridge concurrently
+		if err != nil {
+			return ensureBridge(name)
+		}
+
+		return br, br.SetLinkUp()
+	case err != nil:
+		return nil, err
+	case attrs.Kind != "bridge":
+		return nil, newError(CodeInvalidNetworkConfig, "Interface %s is %q link, expected bridge", name, attrs.Kind)
+	}
+
+	br, err := tenus.BridgeFromName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if attrs.Flags&net.FlagUp == 0 {
+		return br, br.SetLinkUp()
+	}
+
+	return br, nil
+}
+
+// addBridgeVeth creates veth pair of the container, attaches the host link to the bridge
+// and moves the peer link into the container. The veth pair is deleted if it could not be set up.
+func addBridgeVeth(args *Args, conf *BridgeConf, br tenus.Bridger, result *Result) (*Interface, *Interface, error) {
+	// host link names are derived from the container so that they are stable across retries
+	names := tenus.HashedNames(args.ContainerID + "/" + args.IfName)
+	hostName := names("veth", 0)
+
+	veth, err := tenus.NewVethPairWithOptions(hostName, tenus.VethOptions{PeerName: names("tmp", 1)})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ifc, err := setupBridgeVeth(args, conf, br, veth, result)
+	if err != nil {
+		// deleting the host link deletes its peer wherever it is
+		veth.DeleteLink()
+		return nil, nil, err
+	}
+
+	hostIfc := veth.NetInterface()
+
+	return &Interface{Name: hostIfc.Name, Mac: hostIfc.HardwareAddr.String()}, ifc, nil
+}
+
+// setupBridgeVeth configures the host veth link and the container interface
+func setupBridgeVeth(args *Args, conf *BridgeConf, br tenus.Bridger, veth tenus.Vether, result *Result) (*Interface, error) {
+	if conf.MTU != 0 {
+		if err := veth.SetLinkMTU(conf.MTU); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := br.AddSlaveIfc(veth.NetInterface()); err != nil {
+		return nil, err
+	}
+
+	if err := veth.SetLinkUp(); err != nil {
+		return nil, err
+	}
+
+	if err := veth.SetPeerLinkNsFd(args.Netns); err != nil {
+		return nil, err
+	}
+
+	return configureContainerLink(args, veth.PeerNetInterface().Name, conf.MTU, result, 2)
+}
+
+// setBridgeGateway assigns gateway addresses of the IPAM result to the bridge unless the bridge already has them
+func setBridgeGateway(br tenus.Bridger, result *Result) error {
+	name := br.NetInterface().Name
+	addrs, err := tenus.LinkAddrsByName(name)
+	if err != nil {
+		return err
+	}
+
+	for _, ipc := range result.IPs {
+		if ipc.Gateway == "" {
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(ipc.Address)
+		if err != nil {
+			return newError(CodeDecodingFailure, "Invalid IP address %q: %s", ipc.Address, err)
+		}
+
+		gw := net.ParseIP(ipc.Gateway)
+		if gw == nil || !network.Contains(gw) {
+			return newError(CodeInvalidNetworkConfig, "Gateway %s is not in network %s", ipc.Gateway, network)
+		}
+
+		if hasAddr(addrs, gw, network.Mask) {
+			continue
+		}
+
+		if err := br.SetLinkIp(gw, network); err != nil {
+			return err
+		}
+		addrs = append(addrs, &net.IPNet{IP: gw, Mask: network.Mask})
+	}
+
+	return nil
+}
@@ -0,0 +1,394 @@
+// Package cni implements Container Network Interface (CNI) plugins on top of tenus link types.
+//
+// The package implements ADD, DEL, CHECK and VERSION commands of CNI specification versions
+// 0.3.0 up to 1.0.0 without depending on CNI libraries. BridgePlugin connects containers to a Linux bridge
+// via veth pairs, MacVlanPlugin and VlanPlugin create macvlan and vlan links on a master link and move them
+// into the container. IP addresses are allocated by IPAM plugins found in CNI_PATH.
+//
+// Plugin binaries are built from cmd/tenus-bridge, cmd/tenus-macvlan and cmd/tenus-vlan.
+package cni
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/milosgajdos/tenus"
+)
+
+// Error codes defined by CNI specification
+const (
+	CodeIncompatibleVersion  = 1
+	CodeUnsupportedField     = 2
+	CodeUnknownContainer     = 3
+	CodeInvalidEnvironment   = 4
+	CodeIOFailure            = 5
+	CodeDecodingFailure      = 6
+	CodeInvalidNetworkConfig = 7
+	CodeTryAgainLater        = 11
+	// CodeInternal is returned for errors which are not covered by CNI specification
+	CodeInternal = 999
+)
+
+// SupportedVersions are CNI specification versions supported by the plugins
+var SupportedVersions = []string{"0.3.0", "0.3.1", "0.4.0", "1.0.0"}
+
+// Error is CNI error result printed by plugins which failed.
+type Error struct {
+	CNIVersion string `json:"cniVersion,omitempty"`
+	// Error code
+	Code uint `json:"code"`
+	// Error message
+	Msg string `json:"msg"`
+	// Details of the error
+	Details string `json:"details,omitempty"`
+}
+
+// Error returns the error message and its details
+func (e *Error) Error() string {
+	if e.Details == "" {
+		return e.Msg
+	}
+
+	return e.Msg + ": " + e.Details
+}
+
+// newError returns CNI error with the code
+func newError(code uint, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Args are parameters passed to CNI plugin in environment variables and standard input.
+type Args struct {
+	// CNI_COMMAND: ADD, DEL, CHECK or VERSION
+	Command string
+	// CNI_CONTAINERID
+	ContainerID string
+	// CNI_NETNS: filesystem path of the container's network namespace
+	Netns string
+	// CNI_IFNAME: name of the interface to create in the container
+	IfName string
+	// CNI_ARGS: extra arguments as KEY=VALUE pairs separated by semicolons
+	Args string
+	// CNI_PATH: directories to search for IPAM plugins separated by colons
+	Path string
+	// Network configuration read from standard input
+	StdinData []byte
+}
+
+// NetConf is network configuration common to all plugins.
+type NetConf struct {
+	CNIVersion string `json:"cniVersion"`
+	// Network name
+	Name string `json:"name"`
+	// Plugin type
+	Type string `json:"type"`
+	// IPAM configuration
+	IPAM struct {
+		// IPAM plugin type
+		Type string `json:"type,omitempty"`
+	} `json:"ipam,omitempty"`
+	// Result of the previous plugin in the chain or of the ADD command for CHECK and DEL
+	PrevResult *Result `json:"prevResult,omitempty"`
+}
+
+// Result is CNI result returned by ADD command and passed to CHECK and DEL as prevResult.
+type Result struct {
+	CNIVersion string       `json:"cniVersion,omitempty"`
+	Interfaces []*Interface `json:"interfaces,omitempty"`
+	IPs        []*IPConfig  `json:"ips,omitempty"`
+	Routes     []*Route     `json:"routes,omitempty"`
+	DNS        DNS          `json:"dns,omitempty"`
+}
+
+// Interface describes network interface created by the plugin.
+type Interface struct {
+	// Interface name
+	Name string `json:"name"`
+	// MAC address
+	Mac string `json:"mac,omitempty"`
+	// Network namespace path of container interfaces. Empty for host interfaces.
+	Sandbox string `json:"sandbox,omitempty"`
+}
+
+// IPConfig describes IP address assigned to an interface.
+type IPConfig struct {
+	// IP version "4" or "6"; used by CNI specification versions before 1.0.0
+	Version string `json:"version,omitempty"`
+	// Index of the interface in Result.Interfaces
+	Interface *int `json:"interface,omitempty"`
+	// Address in CIDR notation
+	Address string `json:"address"`
+	// Gateway address
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// Route describes a route to add in the container.
+type Route struct {
+	// Destination network in CIDR notation
+	Dst string `json:"dst"`
+	// Gateway address. The gateway of the IP address of the same family is used if empty.
+	GW string `json:"gw,omitempty"`
+}
+
+// DNS describes DNS configuration of the container.
+type DNS struct {
+	Nameservers []string `json:"nameservers,omitempty"`
+	Domain      string   `json:"domain,omitempty"`
+	Search      []string `json:"search,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// Plugin implements CNI commands.
+// The methods receive the plugin's network configuration in Args.StdinData.
+type Plugin interface {
+	// Add connects the container to the network
+	Add(args *Args) (*Result, error)
+	// Del disconnects the container from the network. It must succeed if the container is already disconnected.
+	Del(args *Args) error
+	// Check verifies the container is connected to the network as described by prevResult
+	Check(args *Args) error
+}
+
+// PluginMain runs the plugin as CNI plugin binary and exits with the exit code returned by Run.
+func PluginMain(p Plugin) {
+	os.Exit(Run(p, os.Environ(), os.Stdin, os.Stdout))
+}
+
+// Run runs the CNI command of the plugin with the environment and the network configuration read from stdin.
+// The result or the error is printed to stdout as JSON. It returns the exit code of the plugin binary.
+func Run(p Plugin, env []string, stdin io.Reader, stdout io.Writer) int {
+	result, err := run(p, parseEnv(env), stdin)
+	if err != nil {
+		var cniErr *Error
+		if !errors.As(err, &cniErr) {
+			cniErr = &Error{Code: CodeInternal, Msg: err.Error()}
+			if errors.Is(err, tenus.ErrNsNotFound) {
+				cniErr.Code = CodeUnknownContainer
+			}
+		}
+
+		json.NewEncoder(stdout).Encode(cniErr)
+		return 1
+	}
+
+	if result != nil {
+		if err := json.NewEncoder(stdout).Encode(result); err != nil {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// run validates the arguments and the network configuration and dispatches the command to the plugin
+func run(p Plugin, env map[string]string, stdin io.Reader) (interface{}, error) {
+	args := &Args{
+		Command:     env["CNI_COMMAND"],
+		ContainerID: env["CNI_CONTAINERID"],
+		Netns:       env["CNI_NETNS"],
+		IfName:      env["CNI_IFNAME"],
+		Args:        env["CNI_ARGS"],
+		Path:        env["CNI_PATH"],
+	}
+
+	if args.Command == "VERSION" {
+		return map[string]interface{}{"cniVersion": "1.0.0", "supportedVersions": SupportedVersions}, nil
+	}
+
+	data, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return nil, newError(CodeIOFailure, "Could not read network configuration: %s", err)
+	}
+	args.StdinData = data
+
+	var conf NetConf
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, newError(CodeDecodingFailure, "Could not decode network configuration: %s", err)
+	}
+
+	if !supportedVersion(conf.CNIVersion) {
+		return nil, newError(CodeIncompatibleVersion, "Unsupported CNI version %q", conf.CNIVersion)
+	}
+
+	cniErr := func(err error) error {
+		var e *Error
+		if errors.As(err, &e) && e.CNIVersion == "" {
+			e.CNIVersion = conf.CNIVersion
+		}
+		return err
+	}
+
+	if args.ContainerID == "" {
+		return nil, cniErr(newError(CodeInvalidEnvironment, "CNI_CONTAINERID is not set"))
+	}
+
+	if args.IfName == "" {
+		return nil, cniErr(newError(CodeInvalidEnvironment, "CNI_IFNAME is not set"))
+	}
+
+	switch args.Command {
+	case "ADD", "CHECK":
+		if args.Netns == "" {
+			return nil, cniErr(newError(CodeInvalidEnvironment, "CNI_NETNS is not set"))
+		}
+	case "DEL":
+	default:
+		return nil, cniErr(newError(CodeInvalidEnvironment, "Unknown CNI_COMMAND %q", args.Command))
+	}
+
+	if ok, err := tenus.NetInterfaceNameValid(args.IfName); !ok {
+		return nil, cniErr(newError(CodeInvalidEnvironment, "Invalid CNI_IFNAME: %s", err))
+	}
+
+	switch args.Command {
+	case "ADD":
+		result, err := p.Add(args)
+		if err != nil {
+			return nil, cniErr(err)
+		}
+
+		return result.convert(conf.CNIVersion), nil
+	case "CHECK":
+		if compareVersions(conf.CNIVersion, "0.4.0") < 0 {
+			return nil, cniErr(newError(CodeIncompatibleVersion, "CHECK is not supported by CNI version %s", conf.CNIVersion))
+		}
+
+		if conf.PrevResult == nil {
+			return nil, cniErr(newError(CodeInvalidNetworkConfig, "CHECK requires prevResult"))
+		}
+
+		return nil, cniErr(p.Check(args))
+	}
+
+	return nil, cniErr(p.Del(args))
+}
+
+// convert returns the result in the format of the CNI specification version
+func (r *Result) convert(version string) *Result {
+	res := *r
+	res.CNIVersion = version
+	res.IPs = make([]*IPConfig, len(r.IPs))
+
+	for i, ip := range r.IPs {
+		c := *ip
+		c.Version = ""
+		if compareVersions(version, "1.0.0") < 0 {
+			c.Version = "6"
+			if addr, _, err := net.ParseCIDR(c.Address); err == nil && addr.To4() != nil {
+				c.Version = "4"
+			}
+		}
+		res.IPs[i] = &c
+	}
+
+	return &res
+}
+
+// parseEnv returns environment variables by name
+func parseEnv(env []string) map[string]string {
+	vars := make(map[string]string, len(env))
+	for _, kv := range env {
+		if i := strings.Index(kv, "="); i > 0 {
+			vars[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	return vars
+}
+
+// supportedVersion returns true if the CNI specification version is supported
+func supportedVersion(version string) bool {
+	for _, v := range SupportedVersions {
+		if v == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compareVersions compares MAJOR.MINOR.PATCH versions and returns -1, 0 or 1
+func compareVersions(a, b string) int {
+	var x, y [3]int
+	fmt.Sscanf(a, "%d.%d.%d", &x[0], &x[1], &x[2])
+	fmt.Sscanf(b, "%d.%d.%d", &y[0], &y[1], &y[2])
+
+	for i := range x {
+		switch {
+		case x[i] < y[i]:
+			return -1
+		case x[i] > y[i]:
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// execIPAM runs the IPAM plugin of the network configuration for the command and returns its result.
+// The plugin is looked up in CNI_PATH and receives the same network configuration as the calling plugin.
+// Result is nil for DEL and CHECK commands.
+func execIPAM(args *Args, ipamType, command string) (*Result, error) {
+	if ipamType == "" || filepath.Base(ipamType) != ipamType {
+		return nil, newError(CodeInvalidNetworkConfig, "Invalid IPAM plugin type %q", ipamType)
+	}
+
+	var path string
+	for _, dir := range filepath.SplitList(args.Path) {
+		if info, err := os.Stat(filepath.Join(dir, ipamType)); err == nil && !info.IsDir() {
+			path = filepath.Join(dir, ipamType)
+			break
+		}
+	}
+
+	if path == "" {
+		return nil, newError(CodeInvalidNetworkConfig, "IPAM plugin %s not found in CNI_PATH %q", ipamType, args.Path)
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(path)
+	cmd.Stdin = bytes.NewReader(args.StdinData)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"CNI_COMMAND="+command,
+		"CNI_CONTAINERID="+args.ContainerID,
+		"CNI_NETNS="+args.Netns,
+		"CNI_IFNAME="+args.IfName,
+		"CNI_ARGS="+args.Args,
+		"CNI_PATH="+args.Path,
+	)
+
+	if err := cmd.Run(); err != nil {
+		var cniErr Error
+		if json.Unmarshal(stdout.Bytes(), &cniErr) == nil && cniErr.Msg != "" {
+			return nil, &cniErr
+		}
+
+		return nil, newError(CodeInternal, "IPAM plugin %s failed: %s", ipamType, err)
+	}
+
+	if command != "ADD" {
+		return nil, nil
+	}
+
+	var result Result
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		return nil, newError(CodeDecodingFailure, "Could not decode IPAM plugin %s result: %s", ipamType, err)
+	}
+
+	if len(result.IPs) == 0 {
+		return nil, newError(CodeInternal, "IPAM plugin %s returned no IP addresses", ipamType)
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,412 @@
+package cni
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/milosgajdos/tenus"
+	"github.com/milosgajdos/tenus/tenustest"
+)
+
+// hostNsEnv is environment variable with network namespace path the test plugin binary runs in
+const hostNsEnv = "TENUS_CNI_TEST_NETNS"
+
+// TestMain runs the test binary as a plugin when it is invoked via a symlink named after the plugin
+func TestMain(m *testing.M) {
+	switch filepath.Base(os.Args[0]) {
+	case "fake-ipam":
+		os.Exit(Run(&fakeIPAM{}, os.Environ(), os.Stdin, os.Stdout))
+	case "tenus-bridge":
+		code := 1
+		err := tenus.ExecInNetNsPath(os.Getenv(hostNsEnv), func() error {
+			code = Run(&BridgePlugin{}, os.Environ(), os.Stdin, os.Stdout)
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(code)
+	}
+
+	os.Exit(m.Run())
+}
+
+// fakeIPAM is IPAM plugin which returns the address configured in network configuration.
+// It logs the commands it runs to ipam.log in its directory.
+type fakeIPAM struct{}
+
+func (p *fakeIPAM) log(args *Args) {
+	f, err := os.OpenFile(filepath.Join(filepath.Dir(os.Args[0]), "ipam.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err == nil {
+		fmt.Fprintln(f, args.Command, args.ContainerID)
+		f.Close()
+	}
+}
+
+func (p *fakeIPAM) Add(args *Args) (*Result, error) {
+	p.log(args)
+
+	var conf struct {
+		IPAM struct {
+			Address string `json:"address"`
+			Gateway string `json:"gateway"`
+		} `json:"ipam"`
+	}
+	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		IPs:    []*IPConfig{{Address: conf.IPAM.Address, Gateway: conf.IPAM.Gateway}},
+		Routes: []*Route{{Dst: "0.0.0.0/0"}},
+	}, nil
+}
+
+func (p *fakeIPAM) Del(args *Args) error {
+	p.log(args)
+	return nil
+}
+
+func (p *fakeIPAM) Check(args *Args) error {
+	p.log(args)
+	return nil
+}
+
+// newPluginDir creates CNI_PATH directory with the test binary linked as the plugins
+func newPluginDir(t *testing.T, plugins ...string) string {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("Could not find test binary: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "tenus")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+
+	for _, name := range plugins {
+		if err := os.Symlink(exe, filepath.Join(dir, name)); err != nil {
+			os.RemoveAll(dir)
+			t.Fatalf("Could not link plugin %s: %s", name, err)
+		}
+	}
+
+	return dir
+}
+
+// ipamLog returns commands run by fakeIPAM in dir
+func ipamLog(dir string) string {
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "ipam.log"))
+	return string(data)
+}
+
+// cniEnv returns CNI environment variables of the command
+func cniEnv(command, netns, dir string) []string {
+	return []string{
+		"CNI_COMMAND=" + command,
+		"CNI_CONTAINERID=ctr1",
+		"CNI_NETNS=" + netns,
+		"CNI_IFNAME=eth0",
+		"CNI_PATH=" + dir,
+	}
+}
+
+// runPlugin runs the CNI command of the plugin with the network configuration.
+// It returns the plugin's output and fails the test if the exit code is not as expected.
+func runPlugin(t *testing.T, p Plugin, env []string, conf string, ok bool) []byte {
+	var out bytes.Buffer
+	if code := Run(p, env, strings.NewReader(conf), &out); (code == 0) != ok {
+		t.Fatalf("Run(%v) failed: exit code %d, output %s", env, code, out.String())
+	}
+
+	return out.Bytes()
+}
+
+// withPrevResult returns the network configuration with the result as prevResult
+func withPrevResult(t *testing.T, conf string, result []byte) string {
+	var c map[string]interface{}
+	if err := json.Unmarshal([]byte(conf), &c); err != nil {
+		t.Fatalf("Invalid network configuration: %s", err)
+	}
+
+	var prev interface{}
+	if err := json.Unmarshal(result, &prev); err != nil {
+		t.Fatalf("Invalid result: %s", err)
+	}
+	c["prevResult"] = prev
+
+	data, _ := json.Marshal(c)
+	return string(data)
+}
+
+type errorTest struct {
+	env  []string
+	conf string
+	code uint
+}
+
+var errorTests = []errorTest{
+	{[]string{"CNI_COMMAND=ADD", "CNI_CONTAINERID=ctr1", "CNI_IFNAME=eth0", "CNI_NETNS=/ns"}, `{"cniVersion":"0.2.0"}`, CodeIncompatibleVersion},
+	{[]string{"CNI_COMMAND=ADD", "CNI_CONTAINERID=ctr1", "CNI_IFNAME=eth0", "CNI_NETNS=/ns"}, `{"cniVersion":`, CodeDecodingFailure},
+	{[]string{"CNI_COMMAND=ADD", "CNI_CONTAINERID=ctr1", "CNI_IFNAME=eth0"}, `{"cniVersion":"1.0.0"}`, CodeInvalidEnvironment},
+	{[]string{"CNI_COMMAND=ADD", "CNI_CONTAINERID=ctr1", "CNI_IFNAME=eth0123456789abcdef", "CNI_NETNS=/ns"}, `{"cniVersion":"1.0.0"}`, CodeInvalidEnvironment},
+	{[]string{"CNI_COMMAND=DEL", "CNI_IFNAME=eth0"}, `{"cniVersion":"1.0.0"}`, CodeInvalidEnvironment},
+	{[]string{"CNI_COMMAND=GC", "CNI_CONTAINERID=ctr1", "CNI_IFNAME=eth0"}, `{"cniVersion":"1.0.0"}`, CodeInvalidEnvironment},
+	{[]string{"CNI_COMMAND=CHECK", "CNI_CONTAINERID=ctr1", "CNI_IFNAME=eth0", "CNI_NETNS=/ns"}, `{"cniVersion":"0.3.1","prevResult":{}}`, CodeIncompatibleVersion},
+	{[]string{"CNI_COMMAND=CHECK", "CNI_CONTAINERID=ctr1", "CNI_IFNAME=eth0", "CNI_NETNS=/ns"}, `{"cniVersion":"1.0.0"}`, CodeInvalidNetworkConfig},
+	{[]string{"CNI_COMMAND=ADD", "CNI_CONTAINERID=ctr1", "CNI_IFNAME=eth0", "CNI_NETNS=/ns"}, `{"cniVersion":"1.0.0","bridge":"br/0"}`, CodeInvalidNetworkConfig},
+	{[]string{"CNI_COMMAND=ADD", "CNI_CONTAINERID=ctr1", "CNI_IFNAME=eth0", "CNI_NETNS=/ns"}, `{"cniVersion":"1.0.0","ipam":{"type":"none"}}`, CodeInvalidNetworkConfig},
+}
+
+func Test_RunErrors(t *testing.T) {
+	defer tenus.SetBackend(tenus.SetBackend(tenus.NewFakeBackend()))
+
+	for _, tt := range errorTests {
+		out := runPlugin(t, &BridgePlugin{}, tt.env, tt.conf, false)
+
+		var cniErr Error
+		if err := json.Unmarshal(out, &cniErr); err != nil || cniErr.Code != tt.code || cniErr.Msg == "" {
+			t.Errorf("Run(%v, %s) failed: expected code %d, returned %s", tt.env, tt.conf, tt.code, out)
+		}
+	}
+
+	out := runPlugin(t, &BridgePlugin{}, []string{"CNI_COMMAND=VERSION"}, "", true)
+	if !strings.Contains(string(out), `"supportedVersions":["0.3.0","0.3.1","0.4.0","1.0.0"]`) {
+		t.Fatalf("Run(VERSION) failed: returned %s", out)
+	}
+}
+
+// pluginTest describes ADD, CHECK and DEL of a plugin
+type pluginTest struct {
+	plugin Plugin
+	conf   string
+	kind   string
+}
+
+var pluginTests = []pluginTest{
+	{&BridgePlugin{}, `{"cniVersion":"1.0.0","name":"net","type":"tenus-bridge","bridge":"brcni0","isGateway":true,"mtu":1400,
+		"ipam":{"type":"fake-ipam","address":"10.40.0.2/24","gateway":"10.40.0.1"}}`, "veth"},
+	{&MacVlanPlugin{}, `{"cniVersion":"0.4.0","name":"net","type":"tenus-macvlan","master":"eth0","mode":"private",
+		"ipam":{"type":"fake-ipam","address":"10.41.0.2/24","gateway":"10.41.0.1"}}`, "macvlan"},
+	{&VlanPlugin{}, `{"cniVersion":"0.4.0","name":"net","type":"tenus-vlan","master":"eth0","vlanId":100,
+		"ipam":{"type":"fake-ipam","address":"10.42.0.2/24","gateway":"10.42.0.1"}}`, "vlan"},
+}
+
+func Test_Plugins(t *testing.T) {
+	fake := tenus.NewFakeBackend()
+	defer tenus.SetBackend(tenus.SetBackend(fake))
+
+	dir := newPluginDir(t, "fake-ipam")
+	defer os.RemoveAll(dir)
+
+	// master link of macvlan and vlan links has the same name as the container interface
+	if _, err := tenus.NewLink("eth0"); err != nil {
+		t.Fatalf("NewLink() failed: %s", err)
+	}
+
+	const netns = "/var/run/netns/ctr1"
+	if err := fake.NsCreate(netns); err != nil {
+		t.Fatalf("NsCreate() failed: %s", err)
+	}
+
+	for _, tt := range pluginTests {
+		out := runPlugin(t, tt.plugin, cniEnv("ADD", netns, dir), tt.conf, true)
+
+		var result Result
+		if err := json.Unmarshal(out, &result); err != nil {
+			t.Fatalf("ADD returned invalid result: %s", err)
+		}
+
+		ifc := result.Interfaces[len(result.Interfaces)-1]
+		if ifc.Name != "eth0" || ifc.Sandbox != netns || ifc.Mac == "" || len(result.IPs) != 1 ||
+			*result.IPs[0].Interface != len(result.Interfaces)-1 || result.CNIVersion == "1.0.0" && result.IPs[0].Version != "" ||
+			result.CNIVersion != "1.0.0" && result.IPs[0].Version != "4" {
+			t.Fatalf("%s ADD failed: returned %s", tt.kind, out)
+		}
+
+		err := tenus.ExecInNetNsPath(netns, func() error {
+			attrs, err := tenus.LinkAttrsByName("eth0")
+			if err != nil {
+				return err
+			}
+
+			if attrs.Kind != tt.kind || attrs.Flags&net.FlagUp == 0 {
+				t.Errorf("%s ADD failed: container interface %+v", tt.kind, attrs)
+			}
+
+			routes, err := tenus.RouteList()
+			if err != nil || len(routes) != 1 || routes[0].Gw.String() != result.IPs[0].Gateway {
+				t.Errorf("%s ADD failed: container routes %v, %v", tt.kind, routes, err)
+			}
+
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s ADD failed: %s", tt.kind, err)
+		}
+
+		check := withPrevResult(t, tt.conf, out)
+		runPlugin(t, tt.plugin, cniEnv("CHECK", netns, dir), check, true)
+
+		// interface which already exists in the container is not replaced
+		runPlugin(t, tt.plugin, cniEnv("ADD", netns, dir), tt.conf, false)
+		runPlugin(t, tt.plugin, cniEnv("CHECK", netns, dir), check, true)
+
+		runPlugin(t, tt.plugin, cniEnv("DEL", netns, dir), check, true)
+		runPlugin(t, tt.plugin, cniEnv("CHECK", netns, dir), check, false)
+		runPlugin(t, tt.plugin, cniEnv("DEL", netns, dir), check, true)
+		runPlugin(t, tt.plugin, cniEnv("DEL", "/var/run/netns/none", dir), check, true)
+	}
+
+	links, err := tenus.LinkList()
+	if err != nil {
+		t.Fatalf("LinkList() failed: %s", err)
+	}
+
+	// loopback, eth0 and the bridge are left on the host
+	for _, link := range links {
+		if link.Kind != "" && link.Kind != "bridge" && link.Kind != "dummy" {
+			t.Errorf("Plugins left %s link %s on the host", link.Kind, link.Name)
+		}
+	}
+
+	expected := strings.Repeat("ADD ctr1\nCHECK ctr1\nADD ctr1\nDEL ctr1\nCHECK ctr1\nDEL ctr1\nCHECK ctr1\nDEL ctr1\nDEL ctr1\n", len(pluginTests))
+	if log := ipamLog(dir); log != expected {
+		t.Fatalf("Plugins ran IPAM commands:\n%s\nexpected:\n%s", log, expected)
+	}
+}
+
+func Test_BridgePluginCheck(t *testing.T) {
+	fake := tenus.NewFakeBackend()
+	defer tenus.SetBackend(tenus.SetBackend(fake))
+
+	dir := newPluginDir(t, "fake-ipam")
+	defer os.RemoveAll(dir)
+
+	const netns = "/var/run/netns/ctr1"
+	if err := fake.NsCreate(netns); err != nil {
+		t.Fatalf("NsCreate() failed: %s", err)
+	}
+
+	conf := pluginTests[0].conf
+	out := runPlugin(t, &BridgePlugin{}, cniEnv("ADD", netns, dir), conf, true)
+	check := withPrevResult(t, conf, out)
+
+	var result Result
+	json.Unmarshal(out, &result)
+	host, err := tenus.LinkAttrsByName(result.Interfaces[1].Name)
+	if err != nil {
+		t.Fatalf("LinkAttrsByName() failed: %s", err)
+	}
+
+	if err := tenus.RemoveFromBridge(&net.Interface{Index: host.Index, Name: host.Name}); err != nil {
+		t.Fatalf("RemoveFromBridge() failed: %s", err)
+	}
+
+	out = runPlugin(t, &BridgePlugin{}, cniEnv("CHECK", netns, dir), check, false)
+	if !strings.Contains(string(out), "not attached to bridge brcni0") {
+		t.Fatalf("CHECK failed: returned %s", out)
+	}
+
+	err = tenus.ExecInNetNsPath(netns, func() error {
+		return tenus.DeleteLink("eth0")
+	})
+	if err != nil {
+		t.Fatalf("DeleteLink() failed: %s", err)
+	}
+
+	var cniErr Error
+	out = runPlugin(t, &BridgePlugin{}, cniEnv("CHECK", netns, dir), check, false)
+	if err := json.Unmarshal(out, &cniErr); err != nil || !strings.Contains(cniErr.Msg, "eth0") {
+		t.Fatalf("CHECK failed: returned %s", out)
+	}
+}
+
+// Test_BridgePluginBinary runs tenus-bridge plugin binary in a test network namespace
+// and connects a network namespace to the bridge.
+func Test_BridgePluginBinary(t *testing.T) {
+	ns := tenustest.New(t)
+
+	name := fmt.Sprintf("tenus-cni-%d", os.Getpid())
+	if err := tenus.NewNamedNetNs(name); err != nil {
+		t.Skipf("Could not create network namespace: %s", err)
+	}
+	defer tenus.DeleteNamedNetNs(name)
+	netns := tenus.NetNsPath(name)
+
+	dir := newPluginDir(t, "fake-ipam", "tenus-bridge")
+	defer os.RemoveAll(dir)
+
+	conf := strings.Replace(pluginTests[0].conf, "1.0.0", "0.4.0", 1)
+	plugin := func(command, conf string) ([]byte, error) {
+		var out bytes.Buffer
+		cmd := exec.Command(filepath.Join(dir, "tenus-bridge"))
+		cmd.Env = append(cniEnv(command, netns, dir), hostNsEnv+"="+ns.Path())
+		cmd.Stdin = strings.NewReader(conf)
+		cmd.Stdout = &out
+		cmd.Stderr = os.Stderr
+
+		err := cmd.Run()
+		return out.Bytes(), err
+	}
+
+	out, err := plugin("ADD", conf)
+	if err != nil {
+		t.Fatalf("ADD failed: %s: %s", err, out)
+	}
+
+	var result Result
+	if err := json.Unmarshal(out, &result); err != nil || len(result.Interfaces) != 3 || result.CNIVersion != "0.4.0" {
+		t.Fatalf("ADD returned invalid result: %s", out)
+	}
+
+	hostVeth := result.Interfaces[1].Name
+	ns.AssertLinkKind("brcni0", "bridge")
+	ns.AssertLinkUp("brcni0")
+	ns.AssertLinkAddrs("brcni0", "10.40.0.1/24")
+	ns.AssertLinkMaster(hostVeth, "brcni0")
+	ns.AssertLinkMTU(hostVeth, 1400)
+	ns.AssertLinkUp(hostVeth)
+
+	err = tenus.ExecInNetNsPath(netns, func() error {
+		addrs, err := tenus.LinkAddrsByName("eth0")
+		if err != nil {
+			return err
+		}
+
+		if attrs, err := tenus.LinkAttrsByName("eth0"); err != nil || attrs.MTU != 1400 || attrs.HardwareAddr.String() != result.Interfaces[2].Mac {
+			t.Errorf("ADD failed: container interface %+v, %v", attrs, err)
+		}
+
+		if len(addrs) == 0 || addrs[0].String() != "10.40.0.2/24" {
+			t.Errorf("ADD failed: container addresses %v", addrs)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ADD failed: %s", err)
+	}
+
+	if out, err := plugin("CHECK", withPrevResult(t, conf, out)); err != nil {
+		t.Fatalf("CHECK failed: %s: %s", err, out)
+	}
+
+	if out, err := plugin("DEL", conf); err != nil {
+		t.Fatalf("DEL failed: %s: %s", err, out)
+	}
+	ns.AssertNoLink(hostVeth)
+
+	if err := tenus.ExecInNetNsPath(netns, func() error { return tenus.DeleteLink("eth0") }); !errors.Is(err, tenus.ErrLinkNotFound) {
+		t.Fatalf("DEL failed to delete container interface: %v", err)
+	}
+}
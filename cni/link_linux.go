@@ -0,0 +1,194 @@
+package cni
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/milosgajdos/tenus"
+)
+
+// configureContainerLink renames the link which was moved into the container's network namespace
+// to CNI_IFNAME, sets its MTU, brings it up and configures IP addresses and routes of the IPAM result.
+// The IP addresses of the result are assigned to the container interface of the given index.
+// It returns the container interface. The link is deleted if it could not be configured.
+func configureContainerLink(args *Args, name string, mtu int, result *Result, index int) (*Interface, error) {
+	ifc := &Interface{Name: args.IfName, Sandbox: args.Netns}
+
+	err := tenus.ExecInNetNsPath(args.Netns, func() error {
+		if err := tenus.RenameInterfaceByName(name, args.IfName); err != nil {
+			tenus.DeleteLink(name)
+			return err
+		}
+
+		if err := setupContainerLink(args.IfName, mtu, result, index, ifc); err != nil {
+			tenus.DeleteLink(args.IfName)
+			return err
+		}
+
+		return nil
+	})
+
+	return ifc, err
+}
+
+// setupContainerLink configures the link in the current network namespace and records its MAC address in ifc
+func setupContainerLink(name string, mtu int, result *Result, index int, ifc *Interface) error {
+	link, err := tenus.NewLinkFrom(name)
+	if err != nil {
+		return err
+	}
+
+	if mtu != 0 {
+		if err := link.SetLinkMTU(mtu); err != nil {
+			return err
+		}
+	}
+
+	if err := link.SetLinkUp(); err != nil {
+		return err
+	}
+
+	for _, ipc := range result.IPs {
+		ip, network, err := net.ParseCIDR(ipc.Address)
+		if err != nil {
+			return newError(CodeDecodingFailure, "Invalid IP address %q: %s", ipc.Address, err)
+		}
+
+		if err := link.SetLinkIp(ip, network); err != nil {
+			return err
+		}
+		ipc.Interface = &index
+	}
+
+	for _, r := range result.Routes {
+		route, err := parseRoute(r, result.IPs)
+		if err != nil {
+			return err
+		}
+		route.Index = link.NetInterface().Index
+
+		if err := tenus.AddRoute(route); err != nil {
+			return err
+		}
+	}
+
+	ifc.Mac = link.NetInterface().HardwareAddr.String()
+
+	return nil
+}
+
+// parseRoute returns the route of CNI result. Routes without gateway use the gateway of the IP address
+// of the same family; the route is directly connected if there is no such gateway.
+func parseRoute(r *Route, ips []*IPConfig) (*tenus.Route, error) {
+	_, dst, err := net.ParseCIDR(r.Dst)
+	if err != nil {
+		return nil, newError(CodeDecodingFailure, "Invalid route destination %q: %s", r.Dst, err)
+	}
+
+	gw := r.GW
+	if gw == "" {
+		for _, ipc := range ips {
+			ip, _, err := net.ParseCIDR(ipc.Address)
+			if err == nil && ipc.Gateway != "" && (ip.To4() == nil) == (dst.IP.To4() == nil) {
+				gw = ipc.Gateway
+				break
+			}
+		}
+	}
+
+	route := &tenus.Route{Dst: dst}
+	if gw != "" {
+		if route.Gw = net.ParseIP(gw); route.Gw == nil {
+			return nil, newError(CodeDecodingFailure, "Invalid route gateway %q", gw)
+		}
+	}
+
+	return route, nil
+}
+
+// deleteContainerLink deletes CNI_IFNAME link in the container's network namespace.
+// It succeeds if either the network namespace or the link no longer exist.
+func deleteContainerLink(args *Args) error {
+	if args.Netns == "" {
+		return nil
+	}
+
+	err := tenus.ExecInNetNsPath(args.Netns, func() error {
+		return tenus.DeleteLink(args.IfName)
+	})
+	if errors.Is(err, tenus.ErrNsNotFound) || errors.Is(err, tenus.ErrLinkNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// checkContainerLink verifies that CNI_IFNAME link in the container's network namespace is of the given kind
+// and that it has the MAC address and IP addresses recorded in prevResult.
+// It returns the link's attributes so the caller can check kind specific attributes.
+func checkContainerLink(args *Args, prev *Result, kind string) (*tenus.LinkAttrs, error) {
+	var attrs *tenus.LinkAttrs
+	var addrs []*net.IPNet
+
+	err := tenus.ExecInNetNsPath(args.Netns, func() error {
+		var err error
+		if attrs, err = tenus.LinkAttrsByName(args.IfName); err != nil {
+			return err
+		}
+
+		addrs, err = tenus.LinkAddrsByName(args.IfName)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if attrs.Kind != kind {
+		return nil, fmt.Errorf("Container interface %s is %q link, expected %q", args.IfName, attrs.Kind, kind)
+	}
+
+	index := -1
+	for i, ifc := range prev.Interfaces {
+		if ifc.Name == args.IfName && ifc.Sandbox == args.Netns {
+			index = i
+		}
+	}
+
+	if index < 0 {
+		return nil, fmt.Errorf("Container interface %s not found in prevResult", args.IfName)
+	}
+
+	if mac := prev.Interfaces[index].Mac; mac != "" && mac != attrs.HardwareAddr.String() {
+		return nil, fmt.Errorf("Container interface %s has MAC address %s, expected %s", args.IfName, attrs.HardwareAddr, mac)
+	}
+
+	for _, ipc := range prev.IPs {
+		if ipc.Interface != nil && *ipc.Interface != index {
+			continue
+		}
+
+		ip, network, err := net.ParseCIDR(ipc.Address)
+		if err != nil {
+			return nil, newError(CodeDecodingFailure, "Invalid IP address %q: %s", ipc.Address, err)
+		}
+
+		if !hasAddr(addrs, ip, network.Mask) {
+			return nil, fmt.Errorf("Container interface %s does not have IP address %s", args.IfName, ipc.Address)
+		}
+	}
+
+	return attrs, nil
+}
+
+// hasAddr returns true if addrs contain IP address ip with the network mask
+func hasAddr(addrs []*net.IPNet, ip net.IP, mask net.IPMask) bool {
+	ones, _ := mask.Size()
+	for _, addr := range addrs {
+		if n, _ := addr.Mask.Size(); addr.IP.Equal(ip) && n == ones {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,133 @@
+package cni
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/milosgajdos/tenus"
+)
+
+// MacVlanConf is network configuration of MacVlanPlugin.
+type MacVlanConf struct {
+	NetConf
+	// Master link on the host
+	Master string `json:"master"`
+	// macvlan mode: private, vepa, bridge or passthru. bridge mode is used if empty.
+	Mode string `json:"mode,omitempty"`
+	// MTU of the macvlan link. MTU is not changed if 0.
+	MTU int `json:"mtu,omitempty"`
+}
+
+// MacVlanPlugin connects containers to the network of a master link on the host via macvlan links.
+type MacVlanPlugin struct{}
+
+// loadMacVlanConf decodes MacVlanPlugin network configuration
+func loadMacVlanConf(data []byte) (*MacVlanConf, error) {
+	conf := &MacVlanConf{}
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, newError(CodeDecodingFailure, "Could not decode network configuration: %s", err)
+	}
+
+	if ok, err := tenus.NetInterfaceNameValid(conf.Master); !ok {
+		return nil, newError(CodeInvalidNetworkConfig, "Invalid master link: %s", err)
+	}
+
+	opts := tenus.MacVlanOptions{Mode: conf.Mode}
+	if err := tenus.ValidateMacVlanOptions(&opts); err != nil {
+		return nil, newError(CodeInvalidNetworkConfig, "Invalid macvlan configuration: %s", err)
+	}
+	conf.Mode = opts.Mode
+
+	return conf, nil
+}
+
+// Add creates macvlan link on the master link, moves it into the container and returns the container interface.
+func (p *MacVlanPlugin) Add(args *Args) (*Result, error) {
+	conf, err := loadMacVlanConf(args.StdinData)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := execIPAM(args, conf.IPAM.Type, "ADD")
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := tenus.NewMacVlanLinkWithOptions(conf.Master, tenus.MacVlanOptions{Mode: conf.Mode})
+	if err != nil {
+		execIPAM(args, conf.IPAM.Type, "DEL")
+		return nil, err
+	}
+
+	ifc, err := moveToContainer(args, link, conf.MTU, result)
+	if err != nil {
+		execIPAM(args, conf.IPAM.Type, "DEL")
+		return nil, err
+	}
+	result.Interfaces = []*Interface{ifc}
+
+	return result, nil
+}
+
+// Del releases the container's IP addresses and deletes its macvlan link.
+func (p *MacVlanPlugin) Del(args *Args) error {
+	conf, err := loadMacVlanConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	return delContainer(args, conf.IPAM.Type)
+}
+
+// Check verifies the container interface is macvlan link in the configured mode.
+func (p *MacVlanPlugin) Check(args *Args) error {
+	conf, err := loadMacVlanConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	attrs, err := checkContainer(args, conf.IPAM.Type, conf.PrevResult, "macvlan")
+	if err != nil {
+		return err
+	}
+
+	if attrs.MacVlanMode != conf.Mode {
+		return fmt.Errorf("Container interface %s is in %s mode, expected %s", args.IfName, attrs.MacVlanMode, conf.Mode)
+	}
+
+	return nil
+}
+
+// moveToContainer moves the link created on the host into the container and configures it.
+// The link is deleted if it could not be moved or configured.
+func moveToContainer(args *Args, link tenus.Linker, mtu int, result *Result) (*Interface, error) {
+	// all tenus links embed Link which moves links by network namespace path
+	if err := link.(interface{ SetLinkNsFd(string) error }).SetLinkNsFd(args.Netns); err != nil {
+		link.DeleteLink()
+		return nil, err
+	}
+
+	return configureContainerLink(args, link.NetInterface().Name, mtu, result, 0)
+}
+
+// delContainer releases the container's IP addresses and deletes its interface
+func delContainer(args *Args, ipamType string) error {
+	if ipamType != "" {
+		if _, err := execIPAM(args, ipamType, "DEL"); err != nil {
+			return err
+		}
+	}
+
+	return deleteContainerLink(args)
+}
+
+// checkContainer checks the container's IP addresses and its interface
+func checkContainer(args *Args, ipamType string, prev *Result, kind string) (*tenus.LinkAttrs, error) {
+	if ipamType != "" {
+		if _, err := execIPAM(args, ipamType, "CHECK"); err != nil {
+			return nil, err
+		}
+	}
+
+	return checkContainerLink(args, prev, kind)
+}
@@ -0,0 +1,97 @@
+package cni
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/milosgajdos/tenus"
+)
+
+// VlanConf is network configuration of VlanPlugin.
+type VlanConf struct {
+	NetConf
+	// Master link on the host
+	Master string `json:"master"`
+	// VLAN tag
+	VlanId uint16 `json:"vlanId"`
+	// MTU of the vlan link. MTU is not changed if 0.
+	MTU int `json:"mtu,omitempty"`
+}
+
+// VlanPlugin connects containers to a VLAN of a master link on the host via vlan links.
+type VlanPlugin struct{}
+
+// loadVlanConf decodes VlanPlugin network configuration
+func loadVlanConf(data []byte) (*VlanConf, error) {
+	conf := &VlanConf{}
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, newError(CodeDecodingFailure, "Could not decode network configuration: %s", err)
+	}
+
+	if ok, err := tenus.NetInterfaceNameValid(conf.Master); !ok {
+		return nil, newError(CodeInvalidNetworkConfig, "Invalid master link: %s", err)
+	}
+
+	if conf.VlanId == 0 || conf.VlanId >= 4095 {
+		return nil, newError(CodeInvalidNetworkConfig, "Invalid VLAN tag %d", conf.VlanId)
+	}
+
+	return conf, nil
+}
+
+// Add creates vlan link on the master link, moves it into the container and returns the container interface.
+func (p *VlanPlugin) Add(args *Args) (*Result, error) {
+	conf, err := loadVlanConf(args.StdinData)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := execIPAM(args, conf.IPAM.Type, "ADD")
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := tenus.NewVlanLinkWithOptions(conf.Master, tenus.VlanOptions{Id: conf.VlanId})
+	if err != nil {
+		execIPAM(args, conf.IPAM.Type, "DEL")
+		return nil, err
+	}
+
+	ifc, err := moveToContainer(args, link, conf.MTU, result)
+	if err != nil {
+		execIPAM(args, conf.IPAM.Type, "DEL")
+		return nil, err
+	}
+	result.Interfaces = []*Interface{ifc}
+
+	return result, nil
+}
+
+// Del releases the container's IP addresses and deletes its vlan link.
+func (p *VlanPlugin) Del(args *Args) error {
+	conf, err := loadVlanConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	return delContainer(args, conf.IPAM.Type)
+}
+
+// Check verifies the container interface is vlan link with the configured VLAN tag.
+func (p *VlanPlugin) Check(args *Args) error {
+	conf, err := loadVlanConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	attrs, err := checkContainer(args, conf.IPAM.Type, conf.PrevResult, "vlan")
+	if err != nil {
+		return err
+	}
+
+	if attrs.VlanId != conf.VlanId {
+		return fmt.Errorf("Container interface %s has VLAN tag %d, expected %d", args.IfName, attrs.VlanId, conf.VlanId)
+	}
+
+	return nil
+}
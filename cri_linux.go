@@ -0,0 +1,709 @@
+package tenus
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DefaultContainerdSocket is the path of containerd socket serving CRI
+const DefaultContainerdSocket = "/run/containerd/containerd.sock"
+
+// criService is the gRPC service name of CRI runtime service
+const criService = "/runtime.v1.RuntimeService/"
+
+// PodSandbox describes a ready pod sandbox of CRI runtime.
+type PodSandbox struct {
+	// Sandbox ID
+	ID string
+	// Pod name
+	Name string
+	// Pod namespace
+	Namespace string
+	// Pod UID
+	UID string
+	// Attempt increases every time the sandbox of the pod is recreated
+	Attempt uint32
+	// Creation time in nanoseconds
+	CreatedAt int64
+}
+
+// PodSandboxStatus describes network namespace of a pod sandbox.
+type PodSandboxStatus struct {
+	PodSandbox
+	// PID of the sandbox process. Zero if not reported by CRI runtime.
+	Pid int
+	// Filesystem path of the sandbox network namespace. Empty if not reported by CRI runtime.
+	NetNsPath string
+}
+
+// CRIRuntime looks up pod sandboxes of CRI runtime. CRIClient is CRIRuntime.
+type CRIRuntime interface {
+	// ListPodSandboxes returns ready sandboxes of the pod with the given UID
+	ListPodSandboxes(ctx context.Context, podUID string) ([]PodSandbox, error)
+	// PodSandboxStatus returns status of the pod sandbox with the given ID
+	PodSandboxStatus(ctx context.Context, id string) (*PodSandboxStatus, error)
+}
+
+// CRIClient queries runtime service of Container Runtime Interface (CRI) served by containerd or CRI-O.
+// It speaks CRI v1 gRPC protocol over HTTP/2 on UNIX socket without dependencies on gRPC libraries,
+// so only the calls needed to resolve network namespaces of pod sandboxes are supported.
+// CRIClient is NsResolver which resolves pod sandbox IDs.
+type CRIClient struct {
+	// Socket is the path of CRI UNIX socket. DefaultContainerdSocket is used if empty.
+	Socket string
+}
+
+// gRPC status codes CRIClient tells apart
+const (
+	grpcCodeOK       = 0
+	grpcCodeNotFound = 5
+)
+
+// CRIError is returned when CRI runtime fails the gRPC call.
+// It matches ErrNsNotFound if the runtime reports the pod sandbox does not exist.
+type CRIError struct {
+	// gRPC method
+	Method string
+	// gRPC status code reported by the runtime. 0 if the call failed before the runtime reported its status.
+	Code int
+	// Error reported by the runtime
+	Err error
+}
+
+// Error returns the gRPC method and the underlying error
+func (e *CRIError) Error() string {
+	return "CRI " + e.Method + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *CRIError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the runtime failed the call with the gRPC status matching target
+func (e *CRIError) Is(target error) bool {
+	return target == ErrNsNotFound && e.Code == grpcCodeNotFound
+}
+
+// ListPodSandboxes returns ready sandboxes of the pod with the given UID ordered by attempt.
+// If podUID is empty, ready sandboxes of all pods are returned.
+func (c *CRIClient) ListPodSandboxes(ctx context.Context, podUID string) ([]PodSandbox, error) {
+	// ListPodSandboxRequest.filter with state SANDBOX_READY and pod UID label selector
+	var filter []byte
+	filter = appendProtoBytes(filter, 2, appendProtoVarint(nil, 1, 0))
+	if podUID != "" {
+		label := appendProtoString(nil, 1, "io.kubernetes.pod.uid")
+		label = appendProtoString(label, 2, podUID)
+		filter = appendProtoBytes(filter, 3, label)
+	}
+
+	resp, err := c.call(ctx, "ListPodSandbox", appendProtoBytes(nil, 1, filter))
+	if err != nil {
+		return nil, err
+	}
+
+	var sandboxes []PodSandbox
+	err = walkProto(resp, func(num int, _ uint64, data []byte) error {
+		if num != 1 {
+			return nil
+		}
+
+		sandbox, state, err := parsePodSandbox(data)
+		if err != nil {
+			return err
+		}
+
+		// runtimes ignoring the state filter report sandboxes which are not ready
+		if state == 0 && (podUID == "" || sandbox.UID == podUID) {
+			sandboxes = append(sandboxes, sandbox)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, &CRIError{Method: "ListPodSandbox", Err: err}
+	}
+
+	sort.Slice(sandboxes, func(i, j int) bool {
+		return sandboxes[i].Attempt < sandboxes[j].Attempt
+	})
+
+	return sandboxes, nil
+}
+
+// PodSandboxStatus returns status of the pod sandbox.
+// The PID and the network namespace path are read from verbose information reported by the runtime.
+func (c *CRIClient) PodSandboxStatus(ctx context.Context, id string) (*PodSandboxStatus, error) {
+	req := appendProtoString(nil, 1, id)
+	req = appendProtoVarint(req, 2, 1)
+
+	resp, err := c.call(ctx, "PodSandboxStatus", req)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &PodSandboxStatus{}
+	found := false
+	err = walkProto(resp, func(num int, _ uint64, data []byte) error {
+		switch num {
+		case 1:
+			sandbox, _, err := parsePodSandbox(data)
+			status.PodSandbox, found = sandbox, true
+			return err
+		case 2:
+			key, value, err := parseProtoMapEntry(data)
+			if err != nil || key != "info" {
+				return err
+			}
+
+			status.Pid, status.NetNsPath = parseSandboxInfo(value)
+		}
+
+		return nil
+	})
+	if err == nil && !found {
+		err = errors.New("no sandbox status")
+	}
+
+	if err != nil {
+		return nil, &CRIError{Method: "PodSandboxStatus", Err: err}
+	}
+
+	return status, nil
+}
+
+// ResolveNs returns network namespace path of the pod sandbox.
+// It returns CRIError matching ErrNsNotFound if the runtime reports the pod sandbox does not exist.
+func (c *CRIClient) ResolveNs(ctx context.Context, id string) (string, error) {
+	status, err := c.PodSandboxStatus(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("Could not get status of pod sandbox %s: %w", id, err)
+	}
+
+	return sandboxNsPath(status)
+}
+
+// sandboxNsPath returns path of the sandbox network namespace.
+// Pinned network namespace is preferred as it outlives the sandbox process.
+func sandboxNsPath(status *PodSandboxStatus) (string, error) {
+	if status.NetNsPath != "" {
+		return status.NetNsPath, nil
+	}
+
+	return pidNsPathOf("pod sandbox", status.ID, status.Pid)
+}
+
+// parsePodSandbox decodes PodSandbox or PodSandboxStatus message. Both share the field numbers of
+// the ID, metadata, state and creation time. It returns the sandbox and its state.
+func parsePodSandbox(data []byte) (PodSandbox, uint64, error) {
+	var sandbox PodSandbox
+	var state uint64
+
+	err := walkProto(data, func(num int, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			sandbox.ID = string(data)
+		case 2:
+			return walkProto(data, func(num int, v uint64, data []byte) error {
+				switch num {
+				case 1:
+					sandbox.Name = string(data)
+				case 2:
+					sandbox.UID = string(data)
+				case 3:
+					sandbox.Namespace = string(data)
+				case 4:
+					sandbox.Attempt = uint32(v)
+				}
+				return nil
+			})
+		case 3:
+			state = v
+		case 4:
+			sandbox.CreatedAt = int64(v)
+		}
+		return nil
+	})
+
+	return sandbox, state, err
+}
+
+// parseSandboxInfo decodes the PID and the network namespace path from verbose sandbox information.
+// containerd and CRI-O both report the PID and OCI runtime spec of the sandbox.
+func parseSandboxInfo(info string) (int, string) {
+	data := struct {
+		Pid         int `json:"pid"`
+		RuntimeSpec struct {
+			Linux struct {
+				Namespaces []struct {
+					Type string `json:"type"`
+					Path string `json:"path"`
+				} `json:"namespaces"`
+			} `json:"linux"`
+		} `json:"runtimeSpec"`
+	}{}
+
+	if err := json.Unmarshal([]byte(info), &data); err != nil {
+		return 0, ""
+	}
+
+	for _, ns := range data.RuntimeSpec.Linux.Namespaces {
+		if ns.Type == "network" {
+			return data.Pid, ns.Path
+		}
+	}
+
+	return data.Pid, ""
+}
+
+// HTTP/2 frame types and flags used by CRIClient
+const (
+	h2FrameData         = 0x0
+	h2FrameHeaders      = 0x1
+	h2FrameRstStream    = 0x3
+	h2FrameSettings     = 0x4
+	h2FramePing         = 0x6
+	h2FrameGoAway       = 0x7
+	h2FrameWindowUpdate = 0x8
+	h2FrameContinuation = 0x9
+
+	h2FlagEndStream  = 0x1
+	h2FlagAck        = 0x1
+	h2FlagEndHeaders = 0x4
+	h2FlagPadded     = 0x8
+	h2FlagPriority   = 0x20
+
+	h2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+	// h2MaxFrameSize is the largest frame the peer may send unless it's told otherwise
+	h2MaxFrameSize = 1 << 14
+)
+
+// h2Frame is HTTP/2 frame
+type h2Frame struct {
+	typ     byte
+	flags   byte
+	stream  uint32
+	payload []byte
+}
+
+// readH2Frame reads HTTP/2 frame
+func readH2Frame(r io.Reader) (*h2Frame, error) {
+	var hdr [9]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+
+	f := &h2Frame{
+		typ:     hdr[3],
+		flags:   hdr[4],
+		stream:  binary.BigEndian.Uint32(hdr[5:]) & 0x7fffffff,
+		payload: make([]byte, int(hdr[0])<<16|int(hdr[1])<<8|int(hdr[2])),
+	}
+
+	if len(f.payload) > h2MaxFrameSize {
+		return nil, fmt.Errorf("HTTP/2 frame too large: %d", len(f.payload))
+	}
+
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// writeH2Frame writes HTTP/2 frame
+func writeH2Frame(w io.Writer, typ, flags byte, stream uint32, payload []byte) error {
+	hdr := []byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), typ, flags, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(hdr[5:], stream)
+
+	_, err := w.Write(append(hdr, payload...))
+
+	return err
+}
+
+// h2Data returns payload of DATA frame without padding
+func h2Data(f *h2Frame) ([]byte, error) {
+	if f.flags&h2FlagPadded == 0 {
+		return f.payload, nil
+	}
+
+	if len(f.payload) == 0 || int(f.payload[0]) >= len(f.payload) {
+		return nil, errors.New("invalid HTTP/2 padding")
+	}
+
+	return f.payload[1 : len(f.payload)-int(f.payload[0])], nil
+}
+
+// h2HeaderBlock returns header block fragment of HEADERS frame without padding and priority
+func h2HeaderBlock(f *h2Frame) ([]byte, error) {
+	block, err := h2Data(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.flags&h2FlagPriority != 0 {
+		if len(block) < 5 {
+			return nil, errors.New("invalid HTTP/2 priority")
+		}
+		block = block[5:]
+	}
+
+	return block, nil
+}
+
+// appendHpackInt appends HPACK integer with n-bit prefix and the given first byte bits
+func appendHpackInt(b []byte, bits byte, n uint, v uint64) []byte {
+	max := uint64(1)<<n - 1
+	if v < max {
+		return append(b, bits|byte(v))
+	}
+
+	b = append(b, bits|byte(max))
+	for v -= max; v >= 128; v >>= 7 {
+		b = append(b, byte(v&0x7f)|0x80)
+	}
+
+	return append(b, byte(v))
+}
+
+// appendHpackHeader appends header field as HPACK literal without indexing with a literal name
+func appendHpackHeader(b []byte, name, value string) []byte {
+	b = append(b, 0)
+	b = appendHpackInt(b, 0, 7, uint64(len(name)))
+	b = append(b, name...)
+	b = appendHpackInt(b, 0, 7, uint64(len(value)))
+
+	return append(b, value...)
+}
+
+// call sends unary gRPC request for the CRI runtime service method and returns the response message.
+// Calls failed by the runtime are reported as CRIError with gRPC status code of the failure.
+func (c *CRIClient) call(ctx context.Context, method string, req []byte) ([]byte, error) {
+	socket := c.Socket
+	if socket == "" {
+		socket = DefaultContainerdSocket
+	}
+
+	dialer := &net.Dialer{Timeout: 2 * time.Second}
+	conn, err := dialer.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to CRI runtime: %w", err)
+	}
+	defer conn.Close()
+
+	// unblock reads and writes once the context is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	resp, err := criExchange(conn, method, req)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+
+	if err != nil {
+		var criErr *CRIError
+		if !errors.As(err, &criErr) {
+			criErr = &CRIError{Err: err}
+		}
+		criErr.Method = method
+
+		return nil, criErr
+	}
+
+	return resp, nil
+}
+
+// criExchange performs unary gRPC call on HTTP/2 connection using stream 1
+func criExchange(conn net.Conn, method string, req []byte) ([]byte, error) {
+	var headers []byte
+	headers = appendHpackHeader(headers, ":method", "POST")
+	headers = appendHpackHeader(headers, ":scheme", "http")
+	headers = appendHpackHeader(headers, ":path", criService+method)
+	headers = appendHpackHeader(headers, ":authority", "localhost")
+	headers = appendHpackHeader(headers, "content-type", "application/grpc")
+	headers = appendHpackHeader(headers, "te", "trailers")
+
+	msg := make([]byte, 5, 5+len(req))
+	binary.BigEndian.PutUint32(msg[1:], uint32(len(req)))
+	msg = append(msg, req...)
+
+	w := bufio.NewWriter(conn)
+	if _, err := w.WriteString(h2Preface); err != nil {
+		return nil, err
+	}
+
+	if err := writeH2Frame(w, h2FrameSettings, 0, 0, nil); err != nil {
+		return nil, err
+	}
+
+	if err := writeH2Frame(w, h2FrameHeaders, h2FlagEndHeaders, 1, headers); err != nil {
+		return nil, err
+	}
+
+	for len(msg) > 0 {
+		n := len(msg)
+		if n > h2MaxFrameSize {
+			n = h2MaxFrameSize
+		}
+
+		var flags byte
+		if n == len(msg) {
+			flags = h2FlagEndStream
+		}
+
+		if err := writeH2Frame(w, h2FrameData, flags, 1, msg[:n]); err != nil {
+			return nil, err
+		}
+		msg = msg[n:]
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	hpack := newHpackDecoder()
+	// response headers and trailers of the call and header block which is being received
+	metadata := make(map[string]string)
+	var data, block []byte
+	var endStream bool
+	for {
+		f, err := readH2Frame(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if f.stream == 1 && f.flags&h2FlagEndStream != 0 && (f.typ == h2FrameData || f.typ == h2FrameHeaders) {
+			endStream = true
+		}
+
+		switch f.typ {
+		case h2FrameSettings:
+			if f.flags&h2FlagAck == 0 {
+				if err := writeH2Frame(conn, h2FrameSettings, h2FlagAck, 0, nil); err != nil {
+					return nil, err
+				}
+			}
+		case h2FramePing:
+			if f.flags&h2FlagAck == 0 {
+				if err := writeH2Frame(conn, h2FramePing, h2FlagAck, 0, f.payload); err != nil {
+					return nil, err
+				}
+			}
+		case h2FrameGoAway:
+			return nil, errors.New("connection closed by CRI runtime")
+		case h2FrameRstStream:
+			if f.stream == 1 {
+				return nil, errors.New("request reset by CRI runtime")
+			}
+		case h2FrameHeaders, h2FrameContinuation:
+			fragment := f.payload
+			if f.typ == h2FrameHeaders {
+				if fragment, err = h2HeaderBlock(f); err != nil {
+					return nil, err
+				}
+			}
+			block = append(block, fragment...)
+
+			if f.flags&h2FlagEndHeaders == 0 {
+				continue
+			}
+
+			// header blocks of all streams are decoded to keep the dynamic table in sync with the runtime
+			fields, err := hpack.decode(block)
+			if err != nil {
+				return nil, err
+			}
+			block = nil
+
+			if f.stream == 1 {
+				for _, field := range fields {
+					metadata[field.name] = field.value
+				}
+			}
+		case h2FrameData:
+			if f.stream != 1 {
+				continue
+			}
+
+			payload, err := h2Data(f)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, payload...)
+
+			// return the flow control window so large responses are not stalled
+			if len(f.payload) > 0 {
+				var inc [4]byte
+				binary.BigEndian.PutUint32(inc[:], uint32(len(f.payload)))
+				for _, stream := range []uint32{0, 1} {
+					if err := writeH2Frame(conn, h2FrameWindowUpdate, 0, stream, inc[:]); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+
+		// the stream ends once trailers carried by HEADERS and CONTINUATION frames are received
+		if endStream && block == nil {
+			break
+		}
+	}
+
+	if status := metadata[":status"]; status != "200" {
+		return nil, fmt.Errorf("call failed with HTTP status %s", status)
+	}
+
+	status, ok := metadata["grpc-status"]
+	if !ok {
+		return nil, errors.New("call failed: no gRPC status")
+	}
+
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return nil, fmt.Errorf("call failed: invalid gRPC status %q", status)
+	}
+
+	if code != grpcCodeOK {
+		return nil, &CRIError{Code: code, Err: grpcStatusError(code, metadata["grpc-message"])}
+	}
+
+	// gRPC message is prefixed by compression flag and message length
+	if len(data) < 5 {
+		return nil, errors.New("call failed: no response message")
+	}
+
+	if data[0] != 0 {
+		return nil, errors.New("compressed response messages are not supported")
+	}
+
+	size := binary.BigEndian.Uint32(data[1:5])
+	if uint64(size) > uint64(len(data)-5) {
+		return nil, errors.New("truncated response message")
+	}
+
+	return data[5 : 5+size], nil
+}
+
+// grpcStatusError returns error of the failed gRPC call with the percent-encoded status message
+func grpcStatusError(code int, message string) error {
+	if decoded, err := url.PathUnescape(message); err == nil {
+		message = decoded
+	}
+
+	if message == "" {
+		return fmt.Errorf("call failed with gRPC status %d", code)
+	}
+
+	return fmt.Errorf("call failed with gRPC status %d: %s", code, message)
+}
+
+// appendUvarint appends varint encoded v
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// appendProtoVarint appends protobuf varint field
+func appendProtoVarint(b []byte, num int, v uint64) []byte {
+	b = appendUvarint(b, uint64(num)<<3)
+
+	return appendUvarint(b, v)
+}
+
+// appendProtoBytes appends protobuf length-delimited field
+func appendProtoBytes(b []byte, num int, data []byte) []byte {
+	b = appendUvarint(b, uint64(num)<<3|2)
+	b = appendUvarint(b, uint64(len(data)))
+
+	return append(b, data...)
+}
+
+// appendProtoString appends protobuf string field
+func appendProtoString(b []byte, num int, s string) []byte {
+	return appendProtoBytes(b, num, []byte(s))
+}
+
+// walkProto calls fn for every field of protobuf message. Varint fields are passed in v,
+// length-delimited fields in data. Fixed size fields are skipped.
+func walkProto(msg []byte, fn func(num int, v uint64, data []byte) error) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errors.New("invalid protobuf field key")
+		}
+		msg = msg[n:]
+
+		num := int(key >> 3)
+		var v uint64
+		var data []byte
+
+		switch key & 7 {
+		case 0:
+			if v, n = binary.Uvarint(msg); n <= 0 {
+				return errors.New("invalid protobuf varint")
+			}
+			msg = msg[n:]
+		case 1:
+			if len(msg) < 8 {
+				return errors.New("truncated protobuf field")
+			}
+			msg = msg[8:]
+			continue
+		case 2:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || size > uint64(len(msg)-n) {
+				return errors.New("truncated protobuf field")
+			}
+			data, msg = msg[n:n+int(size)], msg[n+int(size):]
+		case 5:
+			if len(msg) < 4 {
+				return errors.New("truncated protobuf field")
+			}
+			msg = msg[4:]
+			continue
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", key&7)
+		}
+
+		if err := fn(num, v, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseProtoMapEntry decodes key and value of protobuf map<string, string> entry
+func parseProtoMapEntry(data []byte) (string, string, error) {
+	var key, value string
+	err := walkProto(data, func(num int, _ uint64, data []byte) error {
+		switch num {
+		case 1:
+			key = string(data)
+		case 2:
+			value = string(data)
+		}
+		return nil
+	})
+
+	return key, value, err
+}
@@ -0,0 +1,368 @@
+package tenus
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSandbox is a pod sandbox served by fakeCRI
+type fakeSandbox struct {
+	PodSandbox
+	ready bool
+	// verbose sandbox information
+	info string
+}
+
+// fakeCRI emulates CRI runtime service served over gRPC.
+// It decodes only the HPACK literals sent by CRIClient and ignores the state filter of ListPodSandbox.
+type fakeCRI struct {
+	mu        sync.Mutex
+	sandboxes []fakeSandbox
+	// gRPC status codes of failed PodSandboxStatus calls by sandbox ID
+	failures map[string]int
+	// gRPC methods of the received calls
+	calls []string
+}
+
+// newFakeCRI starts fakeCRI listening on UNIX socket in dir and returns its path
+func newFakeCRI(t *testing.T, dir string, cri *fakeCRI) (string, func()) {
+	sock := filepath.Join(dir, "cri.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("CRI test requires UNIX socket: %v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go cri.serve(conn)
+		}
+	}()
+
+	return sock, func() { l.Close() }
+}
+
+func (cri *fakeCRI) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	preface := make([]byte, len(h2Preface))
+	if _, err := io.ReadFull(r, preface); err != nil || string(preface) != h2Preface {
+		return
+	}
+
+	if err := writeH2Frame(conn, h2FrameSettings, 0, 0, nil); err != nil {
+		return
+	}
+
+	paths := make(map[uint32]string)
+	bodies := make(map[uint32][]byte)
+	for {
+		f, err := readH2Frame(r)
+		if err != nil {
+			return
+		}
+
+		switch f.typ {
+		case h2FrameHeaders:
+			paths[f.stream] = hpackLiterals(f.payload)[":path"]
+		case h2FrameData:
+			bodies[f.stream] = append(bodies[f.stream], f.payload...)
+		default:
+			continue
+		}
+
+		if f.flags&h2FlagEndStream != 0 {
+			cri.respond(conn, f.stream, paths[f.stream], bodies[f.stream])
+		}
+	}
+}
+
+// respond sends gRPC response to the call of the method with the request message in body
+func (cri *fakeCRI) respond(conn net.Conn, stream uint32, path string, body []byte) {
+	cri.mu.Lock()
+	cri.calls = append(cri.calls, path)
+	cri.mu.Unlock()
+
+	var resp []byte
+	code := grpcCodeNotFound
+	if len(body) >= 5 {
+		switch path {
+		case criService + "ListPodSandbox":
+			resp, code = cri.listPodSandbox(body[5:])
+		case criService + "PodSandboxStatus":
+			resp, code = cri.podSandboxStatus(body[5:])
+		}
+	}
+
+	if code != grpcCodeOK {
+		// trailers-only response with percent-encoded grpc-message split into HEADERS and CONTINUATION frames
+		trailers := appendHpackHeader([]byte{0x88}, "grpc-status", strconv.Itoa(code))
+		trailers = appendHpackHeader(trailers, "grpc-message", "pod%20sandbox%20failed")
+		writeH2Frame(conn, h2FrameHeaders, h2FlagEndStream, stream, trailers[:len(trailers)/2])
+		writeH2Frame(conn, h2FrameContinuation, h2FlagEndHeaders, stream, trailers[len(trailers)/2:])
+		return
+	}
+
+	msg := make([]byte, 5, 5+len(resp))
+	binary.BigEndian.PutUint32(msg[1:], uint32(len(resp)))
+	msg = append(msg, resp...)
+
+	// split the message into padded and plain DATA frames
+	half := len(msg) / 2
+	padded := append(append([]byte{3}, msg[:half]...), 0, 0, 0)
+
+	writeH2Frame(conn, h2FrameHeaders, h2FlagEndHeaders, stream, []byte{0x88})
+	writeH2Frame(conn, h2FrameData, h2FlagPadded, stream, padded)
+	writeH2Frame(conn, h2FramePing, 0, 0, make([]byte, 8))
+	writeH2Frame(conn, h2FrameData, 0, stream, msg[half:])
+	// grpc-status literal is added to the dynamic table and referenced by the next header block
+	writeH2Frame(conn, h2FrameHeaders, h2FlagEndHeaders, stream, append([]byte{0x40}, appendHpackHeader(nil, "grpc-status", "0")[1:]...))
+	writeH2Frame(conn, h2FrameHeaders, h2FlagEndHeaders|h2FlagEndStream, stream, []byte{0xbe})
+}
+
+func (cri *fakeCRI) listPodSandbox(req []byte) ([]byte, int) {
+	var uid string
+	walkProto(req, func(num int, _ uint64, filter []byte) error {
+		return walkProto(filter, func(num int, _ uint64, data []byte) error {
+			if key, value, _ := parseProtoMapEntry(data); num == 3 && key == "io.kubernetes.pod.uid" {
+				uid = value
+			}
+			return nil
+		})
+	})
+
+	var resp []byte
+	for _, sandbox := range cri.sandboxes {
+		if uid == "" || sandbox.UID == uid {
+			resp = appendProtoBytes(resp, 1, encodeFakeSandbox(sandbox))
+		}
+	}
+
+	return resp, grpcCodeOK
+}
+
+func (cri *fakeCRI) podSandboxStatus(req []byte) ([]byte, int) {
+	var id string
+	walkProto(req, func(num int, _ uint64, data []byte) error {
+		if num == 1 {
+			id = string(data)
+		}
+		return nil
+	})
+
+	if code, ok := cri.failures[id]; ok {
+		return nil, code
+	}
+
+	for _, sandbox := range cri.sandboxes {
+		if sandbox.ID != id {
+			continue
+		}
+
+		resp := appendProtoBytes(nil, 1, encodeFakeSandbox(sandbox))
+		if sandbox.info != "" {
+			entry := appendProtoString(nil, 1, "info")
+			entry = appendProtoString(entry, 2, sandbox.info)
+			resp = appendProtoBytes(resp, 2, entry)
+		}
+
+		return resp, grpcCodeOK
+	}
+
+	return nil, grpcCodeNotFound
+}
+
+// encodeFakeSandbox encodes sandbox as PodSandbox message
+func encodeFakeSandbox(sandbox fakeSandbox) []byte {
+	meta := appendProtoString(nil, 1, sandbox.Name)
+	meta = appendProtoString(meta, 2, sandbox.UID)
+	meta = appendProtoString(meta, 3, sandbox.Namespace)
+	meta = appendProtoVarint(meta, 4, uint64(sandbox.Attempt))
+
+	state := uint64(1)
+	if sandbox.ready {
+		state = 0
+	}
+
+	msg := appendProtoString(nil, 1, sandbox.ID)
+	msg = appendProtoBytes(msg, 2, meta)
+	msg = appendProtoVarint(msg, 3, state)
+
+	return appendProtoVarint(msg, 4, uint64(sandbox.CreatedAt))
+}
+
+// hpackLiterals decodes header block of HPACK literals without indexing with literal names
+func hpackLiterals(block []byte) map[string]string {
+	headers := make(map[string]string)
+	for len(block) > 2 && block[0] == 0 {
+		n := int(block[1])
+		if len(block) < 3+n {
+			break
+		}
+		name := string(block[2 : 2+n])
+		block = block[2+n:]
+
+		n = int(block[0])
+		if len(block) < 1+n {
+			break
+		}
+		headers[name] = string(block[1 : 1+n])
+		block = block[1+n:]
+	}
+
+	return headers
+}
+
+func newFakeCRISandboxes() *fakeCRI {
+	return &fakeCRI{
+		sandboxes: []fakeSandbox{
+			{PodSandbox{ID: "a1", Name: "web", Namespace: "default", UID: "uid-web", Attempt: 0}, false, `{"pid":0}`},
+			{PodSandbox{ID: "a3", Name: "web", Namespace: "default", UID: "uid-web", Attempt: 2}, true,
+				`{"pid":4321,"runtimeSpec":{"linux":{"namespaces":[{"type":"pid"},{"type":"network","path":"/var/run/netns/cni-1234"}]}}}`},
+			{PodSandbox{ID: "a2", Name: "web", Namespace: "default", UID: "uid-web", Attempt: 1}, true, `{"pid":4320}`},
+			{PodSandbox{ID: "b1", Name: "db", Namespace: "prod", UID: "uid-db", Attempt: 0}, true, `{"pid":5432}`},
+			{PodSandbox{ID: "c1", Name: "batch", Namespace: "prod", UID: "uid-batch", Attempt: 0}, true, `{}`},
+		},
+		// runtime failing internally
+		failures: map[string]int{"e1": 13},
+	}
+}
+
+func Test_CRIClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenus")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cri := newFakeCRISandboxes()
+	sock, stop := newFakeCRI(t, dir, cri)
+	defer stop()
+
+	client := &CRIClient{Socket: sock}
+	sandboxes, err := client.ListPodSandboxes(context.Background(), "uid-web")
+	if err != nil {
+		t.Fatalf("ListPodSandboxes() failed: %s", err)
+	}
+
+	if len(sandboxes) != 2 || !reflect.DeepEqual(sandboxes[1], cri.sandboxes[1].PodSandbox) || sandboxes[0].ID != "a2" {
+		t.Fatalf("ListPodSandboxes() failed: returned %+v", sandboxes)
+	}
+
+	if sandboxes, err := client.ListPodSandboxes(context.Background(), ""); err != nil || len(sandboxes) != 4 {
+		t.Fatalf("ListPodSandboxes() failed: returned %+v, %v", sandboxes, err)
+	}
+
+	status, err := client.PodSandboxStatus(context.Background(), "a3")
+	if err != nil {
+		t.Fatalf("PodSandboxStatus() failed: %s", err)
+	}
+
+	expected := &PodSandboxStatus{PodSandbox: cri.sandboxes[1].PodSandbox, Pid: 4321, NetNsPath: "/var/run/netns/cni-1234"}
+	if !reflect.DeepEqual(status, expected) {
+		t.Fatalf("PodSandboxStatus() failed: expected %+v, returned %+v", expected, status)
+	}
+
+	var criErr *CRIError
+	_, err = client.PodSandboxStatus(context.Background(), "ff")
+	if !errors.As(err, &criErr) || criErr.Method != "PodSandboxStatus" || criErr.Code != grpcCodeNotFound {
+		t.Fatalf("PodSandboxStatus() failed: expected CRIError, returned %v", err)
+	}
+
+	if !strings.HasSuffix(err.Error(), "gRPC status 5: pod sandbox failed") {
+		t.Fatalf("PodSandboxStatus() failed to decode grpc-message: %v", err)
+	}
+
+	// only NOT_FOUND status means the sandbox does not exist
+	if _, err := client.ResolveNs(context.Background(), "e1"); !errors.As(err, &criErr) || criErr.Code != 13 || errors.Is(err, ErrNsNotFound) {
+		t.Fatalf("ResolveNs() failed: expected CRIError with status 13, returned %v", err)
+	}
+
+	testResolver(t, client, []resolverTest{
+		{"a3", "/var/run/netns/cni-1234", nil},
+		{"b1", "/proc/5432/ns/net", nil},
+		{"c1", "", ErrContainerNotRunning},
+		{"ff", "", ErrNsNotFound},
+	})
+
+	if _, err := (&CRIClient{Socket: filepath.Join(dir, "none.sock")}).ListPodSandboxes(context.Background(), ""); err == nil {
+		t.Fatalf("ListPodSandboxes() expected to fail without CRI runtime")
+	}
+}
+
+func Test_CRIClientContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenus")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "cri.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("CRI test requires UNIX socket: %v", err)
+	}
+	defer l.Close()
+
+	// accept connections but never respond to emulate hung CRI runtime
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := (&CRIClient{Socket: sock}).PodSandboxStatus(ctx, "a1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("PodSandboxStatus() failed: expected %v, returned %v", context.DeadlineExceeded, err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("PodSandboxStatus() failed: returned after %s", elapsed)
+	}
+}
+
+type protoTest struct {
+	msg []byte
+	ok  bool
+}
+
+var protoTests = []protoTest{
+	{appendProtoString(appendProtoVarint(nil, 1, 300), 2, "web"), true},
+	{[]byte{0x09, 1, 2, 3, 4, 5, 6, 7, 8, 0x15, 1, 2, 3, 4}, true},
+	{[]byte{0x12, 5, 'w', 'e', 'b'}, false},
+	{[]byte{0x08, 0x80}, false},
+	{[]byte{0x0b}, false},
+}
+
+func Test_WalkProto(t *testing.T) {
+	for _, tt := range protoTests {
+		if err := walkProto(tt.msg, func(int, uint64, []byte) error { return nil }); (err == nil) != tt.ok {
+			t.Errorf("walkProto(%v) failed: expected %v, returned %v", tt.msg, tt.ok, err)
+		}
+	}
+}
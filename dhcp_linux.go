@@ -0,0 +1,1025 @@
+package tenus
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DHCPv4 UDP ports
+const (
+	dhcpServerPort = 67
+	dhcpClientPort = 68
+)
+
+// DHCP message types
+const (
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+	dhcpRequest  = 3
+	dhcpAck      = 5
+	dhcpNak      = 6
+	dhcpRelease  = 7
+)
+
+// DHCP options used by the client
+const (
+	dhcpOptPad             = 0
+	dhcpOptSubnetMask      = 1
+	dhcpOptRouter          = 3
+	dhcpOptDNS             = 6
+	dhcpOptHostname        = 12
+	dhcpOptRequestedIP     = 50
+	dhcpOptLeaseTime       = 51
+	dhcpOptMessageType     = 53
+	dhcpOptServerID        = 54
+	dhcpOptParams          = 55
+	dhcpOptMessage         = 56
+	dhcpOptRenewalTime     = 58
+	dhcpOptRebindingTime   = 59
+	dhcpOptClientID        = 61
+	dhcpOptClasslessRoutes = 121
+	dhcpOptEnd             = 255
+)
+
+// BOOTP header fields
+const (
+	dhcpBootRequest   = 1
+	dhcpBootReply     = 2
+	dhcpFlagBroadcast = 0x8000
+)
+
+// packet sizes
+const (
+	dhcpHeaderLen     = 236
+	dhcpMinMessageLen = 300
+	dhcpMaxPacketLen  = 1500
+	ipv4HeaderLen     = 20
+	udpHeaderLen      = 8
+)
+
+// ethPIP is EtherType of IPv4 packets
+const ethPIP = 0x0800
+
+// dhcpMinRetryInterval is the minimal time between retries of failed lease renewal
+const dhcpMinRetryInterval = time.Second
+
+// Default DHCPOptions values
+const (
+	// DefaultDHCPTimeout is the time the client waits for DHCP server response before it retransmits the request
+	DefaultDHCPTimeout = 4 * time.Second
+	// DefaultDHCPRetries is the number of retransmissions of unanswered DHCP request
+	DefaultDHCPRetries = 3
+)
+
+// dhcpMagicCookie marks the beginning of DHCP options
+var dhcpMagicCookie = []byte{99, 130, 83, 99}
+
+// dhcpParams are the options the client asks DHCP server for
+var dhcpParams = []byte{dhcpOptSubnetMask, dhcpOptRouter, dhcpOptDNS, dhcpOptClasslessRoutes,
+	dhcpOptLeaseTime, dhcpOptRenewalTime, dhcpOptRebindingTime}
+
+// DHCPOptions configures DHCP client.
+type DHCPOptions struct {
+	// Network namespace path the link lives in, e.g. returned by NsResolver. Empty for the current network namespace.
+	NsPath string
+	// Host name sent to DHCP server, at most 255 bytes long. Empty string sends no host name.
+	Hostname string
+	// Time to wait for DHCP server response. 0 uses DefaultDHCPTimeout.
+	Timeout time.Duration
+	// Number of retransmissions of unanswered requests. 0 uses DefaultDHCPRetries.
+	Retries int
+}
+
+// DHCPLease is an IPv4 address lease granted by DHCP server.
+type DHCPLease struct {
+	// Leased IP address
+	IP net.IP
+	// Network the leased address belongs to
+	Network *net.IPNet
+	// Default gateway. It is not configured if the server sent classless static routes.
+	Router net.IP
+	// Classless static routes
+	Routes []*Route
+	// DNS servers
+	DNS []net.IP
+	// Address of the DHCP server which granted the lease
+	Server net.IP
+	// Lease time
+	Duration time.Duration
+	// Time after which the lease is renewed with the server which granted it (T1)
+	RenewAfter time.Duration
+	// Time after which the lease is renewed with any server (T2)
+	RebindAfter time.Duration
+	// Time the lease was granted
+	Acquired time.Time
+
+	// MAC address the server's replies came from
+	serverHwaddr net.HardwareAddr
+}
+
+// linkRoutes returns the routes of the lease configured on the link with the given index
+func (l *DHCPLease) linkRoutes(index int) []*Route {
+	if len(l.Routes) == 0 {
+		if l.Router == nil {
+			return nil
+		}
+		return []*Route{{Gw: l.Router, Index: index}}
+	}
+
+	routes := make([]*Route, len(l.Routes))
+	for i, r := range l.Routes {
+		routes[i] = &Route{Dst: r.Dst, Gw: r.Gw, Index: index}
+	}
+
+	return routes
+}
+
+// equal returns true if the leases configure the same address and routes
+func (l *DHCPLease) equal(o *DHCPLease) bool {
+	if !l.IP.Equal(o.IP) || l.Network.String() != o.Network.String() {
+		return false
+	}
+
+	return fmt.Sprint(l.linkRoutes(0)) == fmt.Sprint(o.linkRoutes(0))
+}
+
+// DHCPClient acquires IPv4 address of a link from DHCP server and configures it on the link
+// together with the default gateway or classless static routes sent by the server.
+//
+// The client talks to DHCP server over a packet socket bound to the link, so the link does not need
+// to have an IP address and it may live in another network namespace. The link is brought up if it is down.
+// Acquire, Renew and Release must not be called while the client renews the lease in the background.
+type DHCPClient struct {
+	link Linker
+	opts DHCPOptions
+
+	mu    sync.Mutex
+	lease *DHCPLease
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewDHCPClient returns DHCP client of the link.
+func NewDHCPClient(link Linker, opts DHCPOptions) *DHCPClient {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultDHCPTimeout
+	}
+
+	if opts.Retries == 0 {
+		opts.Retries = DefaultDHCPRetries
+	}
+
+	return &DHCPClient{link: link, opts: opts}
+}
+
+// Lease returns the lease configured on the link. It returns nil if the client holds no lease.
+func (c *DHCPClient) Lease() *DHCPLease {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lease
+}
+
+// Acquire obtains new lease from DHCP server and configures it on the link.
+// It broadcasts DHCPDISCOVER, requests the first offered address and applies the acknowledged lease,
+// replacing the lease the client held before. It returns error wrapping ErrDHCPNak if the server
+// refused the request and ErrDHCPTimeout if no server responded.
+func (c *DHCPClient) Acquire(ctx context.Context) (*DHCPLease, error) {
+	conn, err := c.open(ctx)
+	if err != nil {
+		return nil, c.error("dhcp discover", err)
+	}
+	defer conn.close()
+
+	xid, err := dhcpXid()
+	if err != nil {
+		return nil, c.error("dhcp discover", err)
+	}
+
+	discover := c.message(conn, dhcpDiscover, xid)
+	offer, _, err := conn.exchange(ctx, discover, nil, c.opts, dhcpOffer)
+	if err != nil {
+		return nil, c.error("dhcp discover", err)
+	}
+
+	request := c.message(conn, dhcpRequest, xid)
+	request.options[dhcpOptRequestedIP] = offer.yiaddr.To4()
+	request.options[dhcpOptServerID] = offer.options[dhcpOptServerID]
+
+	lease, err := c.request(ctx, conn, request, nil)
+	if err != nil {
+		return nil, c.error("dhcp request", err)
+	}
+
+	return lease, nil
+}
+
+// Renew extends the lease with the DHCP server which granted it.
+// If the server refuses to renew the lease, the lease is removed from the link and error wrapping ErrDHCPNak is returned.
+func (c *DHCPClient) Renew(ctx context.Context) (*DHCPLease, error) {
+	return c.renew(ctx, false)
+}
+
+// Release returns the lease to DHCP server and removes its address and routes from the link.
+// It does nothing if the client holds no lease.
+func (c *DHCPClient) Release(ctx context.Context) error {
+	lease := c.Lease()
+	if lease == nil {
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return c.error("dhcp release", err)
+	}
+
+	conn, err := c.open(ctx)
+	if err != nil {
+		return c.error("dhcp release", err)
+	}
+	defer conn.close()
+
+	xid, err := dhcpXid()
+	if err != nil {
+		return c.error("dhcp release", err)
+	}
+
+	release := c.message(conn, dhcpRelease, xid)
+	release.flags = 0
+	release.ciaddr = lease.IP
+	release.options[dhcpOptServerID] = lease.Server.To4()
+
+	// server does not respond to DHCPRELEASE, so the lease is removed even if it could not be sent
+	var sendErr error
+	if lease.Server != nil {
+		sendErr = conn.send(release, lease.IP, lease.Server, lease.serverHwaddr)
+	}
+	if err := c.unconfigure(ctx); err != nil {
+		return err
+	}
+
+	return c.error("dhcp release", sendErr)
+}
+
+// Start acquires the lease and keeps renewing it in the background until Stop is called.
+// If the lease can not be renewed before it expires, the client removes it from the link and acquires new one.
+func (c *DHCPClient) Start(ctx context.Context) (*DHCPLease, error) {
+	if c.done != nil {
+		return nil, errors.New("DHCP client is already running")
+	}
+
+	lease, err := c.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	go c.run(runCtx)
+
+	return lease, nil
+}
+
+// Stop stops renewing the lease in the background and releases it.
+func (c *DHCPClient) Stop() error {
+	if c.done == nil {
+		return nil
+	}
+
+	c.cancel()
+	<-c.done
+	c.cancel, c.done = nil, nil
+
+	return c.Release(context.Background())
+}
+
+// run renews the lease when its renewal time is reached and acquires new lease once it expires
+func (c *DHCPClient) run(ctx context.Context) {
+	defer close(c.done)
+
+	for {
+		var wait time.Duration
+		var err error
+
+		lease := c.Lease()
+		if lease == nil {
+			if _, err = c.Acquire(ctx); err != nil {
+				wait = c.opts.Timeout
+			}
+		} else {
+			now := time.Now()
+			renew := lease.Acquired.Add(lease.RenewAfter)
+			rebind := lease.Acquired.Add(lease.RebindAfter)
+			expire := lease.Acquired.Add(lease.Duration)
+
+			switch {
+			case now.Before(renew):
+				wait = renew.Sub(now)
+			case now.Before(rebind):
+				if _, err = c.renew(ctx, false); err != nil {
+					wait = dhcpRetryInterval(rebind.Sub(now))
+				}
+			case now.Before(expire):
+				if _, err = c.renew(ctx, true); err != nil {
+					wait = dhcpRetryInterval(expire.Sub(now))
+				}
+			default:
+				if err = c.unconfigure(ctx); err != nil {
+					wait = c.opts.Timeout
+				}
+			}
+		}
+
+		// refused lease has been removed, new one is acquired right away
+		if errors.Is(err, ErrDHCPNak) {
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// renew requests extension of the lease. Rebinding broadcasts the request to any DHCP server
+// while renewing sends it to the server which granted the lease.
+func (c *DHCPClient) renew(ctx context.Context, rebind bool) (*DHCPLease, error) {
+	lease := c.Lease()
+	if lease == nil {
+		return nil, c.error("dhcp renew", errors.New("No DHCP lease to renew"))
+	}
+
+	conn, err := c.open(ctx)
+	if err != nil {
+		return nil, c.error("dhcp renew", err)
+	}
+	defer conn.close()
+
+	xid, err := dhcpXid()
+	if err != nil {
+		return nil, c.error("dhcp renew", err)
+	}
+
+	request := c.message(conn, dhcpRequest, xid)
+	request.flags = 0
+	request.ciaddr = lease.IP
+
+	server := lease
+	if rebind {
+		server = nil
+	}
+
+	lease, err = c.request(ctx, conn, request, server)
+	if err != nil {
+		return nil, c.error("dhcp renew", err)
+	}
+
+	return lease, nil
+}
+
+// request sends DHCPREQUEST and configures the acknowledged lease on the link.
+// The request is sent to the server of the given lease, or broadcast if it's nil.
+// The lease held by the client is removed if the server refuses the request.
+func (c *DHCPClient) request(ctx context.Context, conn *dhcpConn, request *dhcpMessage, server *DHCPLease) (*DHCPLease, error) {
+	reply, hwaddr, err := conn.exchange(ctx, request, server, c.opts, dhcpAck, dhcpNak)
+	if err != nil {
+		return nil, err
+	}
+
+	if reply.msgType() == dhcpNak {
+		if err := c.unconfigure(ctx); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s", ErrDHCPNak, reply.options[dhcpOptMessage])
+	}
+
+	lease, err := parseDHCPLease(reply)
+	if err != nil {
+		return nil, err
+	}
+	lease.serverHwaddr = hwaddr
+
+	if err := c.configure(ctx, lease); err != nil {
+		return nil, err
+	}
+
+	return lease, nil
+}
+
+// message returns DHCP message of the given type sent by the client
+func (c *DHCPClient) message(conn *dhcpConn, msgType byte, xid uint32) *dhcpMessage {
+	m := &dhcpMessage{
+		op:      dhcpBootRequest,
+		xid:     xid,
+		flags:   dhcpFlagBroadcast,
+		chaddr:  conn.hwaddr,
+		options: map[byte][]byte{dhcpOptMessageType: {msgType}},
+	}
+
+	m.options[dhcpOptClientID] = append([]byte{1}, conn.hwaddr...)
+	if msgType != dhcpRelease {
+		m.options[dhcpOptParams] = dhcpParams
+		if c.opts.Hostname != "" {
+			m.options[dhcpOptHostname] = []byte(c.opts.Hostname)
+		}
+	}
+
+	return m
+}
+
+// open opens packet socket bound to the link in the client's network namespace
+func (c *DHCPClient) open(ctx context.Context) (*dhcpConn, error) {
+	var conn *dhcpConn
+	err := execInNetNsPath(c.opts.NsPath, func() error {
+		attrs, err := backend.LinkByName(ctx, c.link.NetInterface().Name)
+		if err != nil {
+			return err
+		}
+
+		if attrs.Flags&net.FlagUp == 0 {
+			if err := backend.LinkSetUp(ctx, attrs.Index); err != nil {
+				return err
+			}
+		}
+
+		conn, err = openDHCPConn(attrs.Index, attrs.HardwareAddr)
+		return err
+	})
+
+	return conn, err
+}
+
+// configure replaces the address and routes of the lease held by the client with the new lease
+func (c *DHCPClient) configure(ctx context.Context, lease *DHCPLease) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := c.link.NetInterface().Name
+	err := execInNetNsPath(c.opts.NsPath, func() error {
+		attrs, err := backend.LinkByName(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		if c.lease != nil && !c.lease.equal(lease) {
+			if err := unconfigureDHCPLease(ctx, attrs.Index, c.lease); err != nil {
+				return err
+			}
+		}
+
+		if err := backend.AddrAdd(ctx, attrs.Index, linkAddr(lease.IP, lease.Network)); err != nil && !errors.Is(err, syscall.EEXIST) {
+			return err
+		}
+
+		for _, route := range lease.linkRoutes(attrs.Index) {
+			if err := backend.RouteAdd(ctx, route); err != nil && !errors.Is(err, syscall.EEXIST) {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return newLinkNsError("dhcp configure", name, c.opts.NsPath, err)
+	}
+
+	c.lease = lease
+
+	return nil
+}
+
+// unconfigure removes the address and routes of the lease held by the client from the link
+func (c *DHCPClient) unconfigure(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lease == nil {
+		return nil
+	}
+
+	name := c.link.NetInterface().Name
+	err := execInNetNsPath(c.opts.NsPath, func() error {
+		attrs, err := backend.LinkByName(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		return unconfigureDHCPLease(ctx, attrs.Index, c.lease)
+	})
+	if err != nil {
+		return newLinkNsError("dhcp unconfigure", name, c.opts.NsPath, err)
+	}
+
+	c.lease = nil
+
+	return nil
+}
+
+// error wraps err into LinkError of the client's link
+func (c *DHCPClient) error(op string, err error) error {
+	return newLinkNsError(op, c.link.NetInterface().Name, c.opts.NsPath, err)
+}
+
+// unconfigureDHCPLease removes the lease's routes and address from the link with the given index
+func unconfigureDHCPLease(ctx context.Context, index int, lease *DHCPLease) error {
+	for _, route := range lease.linkRoutes(index) {
+		// routes are removed by the kernel together with the address
+		backend.RouteDel(ctx, route)
+	}
+
+	if err := backend.AddrDel(ctx, index, linkAddr(lease.IP, lease.Network)); err != nil && !errors.Is(err, syscall.EADDRNOTAVAIL) {
+		return err
+	}
+
+	return nil
+}
+
+// dhcpRetryInterval returns the time to wait before retrying failed renewal
+// given the time remaining until the next lease state
+func dhcpRetryInterval(remaining time.Duration) time.Duration {
+	wait := remaining / 2
+	if wait < dhcpMinRetryInterval {
+		wait = dhcpMinRetryInterval
+	}
+	if wait > remaining {
+		wait = remaining
+	}
+
+	return wait
+}
+
+// dhcpXid returns random DHCP transaction ID
+func dhcpXid() (uint32, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return 0, fmt.Errorf("Could not generate DHCP transaction ID: %s", err)
+	}
+
+	return binary.BigEndian.Uint32(b), nil
+}
+
+// parseDHCPLease returns the lease acknowledged by DHCPACK message
+func parseDHCPLease(m *dhcpMessage) (*DHCPLease, error) {
+	ip := m.yiaddr.To4()
+	if ip == nil || ip.IsUnspecified() {
+		return nil, errors.New("DHCP server acknowledged no IP address")
+	}
+
+	mask := net.IPMask(m.options[dhcpOptSubnetMask])
+	if len(mask) != net.IPv4len {
+		mask = ip.DefaultMask()
+	}
+
+	lease := &DHCPLease{
+		IP:       ip,
+		Network:  &net.IPNet{IP: ip.Mask(mask), Mask: mask},
+		Server:   dhcpIP(m.options[dhcpOptServerID]),
+		Acquired: time.Now(),
+	}
+
+	if routers := dhcpIPs(m.options[dhcpOptRouter]); len(routers) > 0 {
+		lease.Router = routers[0]
+	}
+	lease.DNS = dhcpIPs(m.options[dhcpOptDNS])
+
+	if opt, ok := m.options[dhcpOptClasslessRoutes]; ok {
+		routes, err := parseDHCPRoutes(opt)
+		if err != nil {
+			return nil, err
+		}
+		lease.Routes = routes
+	}
+
+	leaseTime, ok := dhcpSeconds(m.options[dhcpOptLeaseTime])
+	if !ok {
+		return nil, errors.New("DHCP server acknowledged no lease time")
+	}
+	lease.Duration = leaseTime
+
+	if lease.RenewAfter, ok = dhcpSeconds(m.options[dhcpOptRenewalTime]); !ok {
+		lease.RenewAfter = leaseTime / 2
+	}
+
+	if lease.RebindAfter, ok = dhcpSeconds(m.options[dhcpOptRebindingTime]); !ok {
+		lease.RebindAfter = leaseTime * 7 / 8
+	}
+
+	return lease, nil
+}
+
+// parseDHCPRoutes decodes classless static routes option as defined in RFC 3442
+func parseDHCPRoutes(b []byte) ([]*Route, error) {
+	var routes []*Route
+	for len(b) > 0 {
+		width := int(b[0])
+		size := (width + 7) / 8
+		if width > 32 || len(b) < 1+size+net.IPv4len {
+			return nil, errors.New("Invalid DHCP classless static routes option")
+		}
+
+		route := &Route{}
+		if width > 0 {
+			dst := make(net.IP, net.IPv4len)
+			copy(dst, b[1:1+size])
+			route.Dst = &net.IPNet{IP: dst, Mask: net.CIDRMask(width, 32)}
+		}
+
+		if gw := net.IP(b[1+size : 1+size+net.IPv4len]); !gw.IsUnspecified() {
+			route.Gw = dhcpIP(gw)
+		}
+
+		routes = append(routes, route)
+		b = b[1+size+net.IPv4len:]
+	}
+
+	return routes, nil
+}
+
+// dhcpIP returns copy of IPv4 address option value. It returns nil if the value is not IPv4 address.
+func dhcpIP(b []byte) net.IP {
+	if len(b) != net.IPv4len {
+		return nil
+	}
+
+	return net.IPv4(b[0], b[1], b[2], b[3]).To4()
+}
+
+// dhcpIPs decodes list of IPv4 addresses option value
+func dhcpIPs(b []byte) []net.IP {
+	var ips []net.IP
+	for ; len(b) >= net.IPv4len; b = b[net.IPv4len:] {
+		ips = append(ips, dhcpIP(b[:net.IPv4len]))
+	}
+
+	return ips
+}
+
+// dhcpSeconds decodes time option value
+func dhcpSeconds(b []byte) (time.Duration, bool) {
+	if len(b) != 4 {
+		return 0, false
+	}
+
+	return time.Duration(binary.BigEndian.Uint32(b)) * time.Second, true
+}
+
+// dhcpMessage is DHCP message as defined in RFC 2131
+type dhcpMessage struct {
+	op      byte
+	xid     uint32
+	flags   uint16
+	ciaddr  net.IP
+	yiaddr  net.IP
+	chaddr  net.HardwareAddr
+	options map[byte][]byte
+}
+
+// msgType returns DHCP message type
+func (m *dhcpMessage) msgType() byte {
+	if t := m.options[dhcpOptMessageType]; len(t) == 1 {
+		return t[0]
+	}
+
+	return 0
+}
+
+// encode encodes the message. The message type option is encoded first, the rest is ordered by option code.
+func (m *dhcpMessage) encode() ([]byte, error) {
+	b := make([]byte, dhcpHeaderLen, dhcpMinMessageLen)
+	b[0] = m.op
+	b[1] = 1 // ethernet
+	b[2] = byte(len(m.chaddr))
+	binary.BigEndian.PutUint32(b[4:], m.xid)
+	binary.BigEndian.PutUint16(b[10:], m.flags)
+	copy(b[12:16], m.ciaddr.To4())
+	copy(b[16:20], m.yiaddr.To4())
+	copy(b[28:44], m.chaddr)
+	b = append(b, dhcpMagicCookie...)
+
+	codes := make([]int, 0, len(m.options))
+	for code := range m.options {
+		if code != dhcpOptMessageType {
+			codes = append(codes, int(code))
+		}
+	}
+	sort.Ints(codes)
+
+	if _, ok := m.options[dhcpOptMessageType]; ok {
+		codes = append([]int{dhcpOptMessageType}, codes...)
+	}
+
+	for _, code := range codes {
+		value := m.options[byte(code)]
+		// option length is a single byte
+		if len(value) > 255 {
+			return nil, fmt.Errorf("DHCP option %d too long: %d bytes", code, len(value))
+		}
+		b = append(append(b, byte(code), byte(len(value))), value...)
+	}
+	b = append(b, dhcpOptEnd)
+
+	for len(b) < dhcpMinMessageLen {
+		b = append(b, dhcpOptPad)
+	}
+
+	return b, nil
+}
+
+// decodeDHCPMessage decodes DHCP message. Values of options which appear more than once are concatenated.
+func decodeDHCPMessage(b []byte) (*dhcpMessage, error) {
+	if len(b) < dhcpHeaderLen+len(dhcpMagicCookie) || !bytes.Equal(b[dhcpHeaderLen:dhcpHeaderLen+4], dhcpMagicCookie) {
+		return nil, errors.New("Invalid DHCP message")
+	}
+
+	hlen := int(b[2])
+	if hlen > 16 {
+		return nil, errors.New("Invalid DHCP message hardware address length")
+	}
+
+	m := &dhcpMessage{
+		op:      b[0],
+		xid:     binary.BigEndian.Uint32(b[4:]),
+		flags:   binary.BigEndian.Uint16(b[10:]),
+		ciaddr:  net.IP(append([]byte(nil), b[12:16]...)),
+		yiaddr:  net.IP(append([]byte(nil), b[16:20]...)),
+		chaddr:  net.HardwareAddr(append([]byte(nil), b[28:28+hlen]...)),
+		options: make(map[byte][]byte),
+	}
+
+	opts := b[dhcpHeaderLen+4:]
+	for len(opts) > 0 {
+		code := opts[0]
+		if code == dhcpOptEnd {
+			break
+		}
+		if code == dhcpOptPad {
+			opts = opts[1:]
+			continue
+		}
+
+		if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			return nil, fmt.Errorf("Invalid DHCP option %d", code)
+		}
+
+		m.options[code] = append(m.options[code], opts[2:2+int(opts[1])]...)
+		opts = opts[2+int(opts[1]):]
+	}
+
+	return m, nil
+}
+
+// dhcpConn is packet socket which sends and receives DHCP messages on a link
+type dhcpConn struct {
+	sock   *os.File
+	index  int
+	hwaddr net.HardwareAddr
+}
+
+// openDHCPConn opens packet socket bound to the link with the given index in the current network namespace
+func openDHCPConn(index int, hwaddr net.HardwareAddr) (*dhcpConn, error) {
+	proto := htons(ethPIP)
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, int(proto))
+	if err != nil {
+		return nil, fmt.Errorf("Could not open packet socket: %s", err)
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: proto, Ifindex: index}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("Could not bind packet socket: %s", err)
+	}
+
+	return &dhcpConn{sock: os.NewFile(uintptr(fd), "dhcp"), index: index, hwaddr: hwaddr}, nil
+}
+
+// close closes the socket
+func (c *dhcpConn) close() error {
+	return c.sock.Close()
+}
+
+// exchange sends the message to the server of the lease, or broadcasts it if the lease is nil,
+// and waits for the reply of one of the given types. The message is retransmitted if no reply arrives in time.
+// It returns the reply and MAC address it was sent from.
+func (c *dhcpConn) exchange(ctx context.Context, m *dhcpMessage, server *DHCPLease, opts DHCPOptions, types ...byte) (*dhcpMessage, net.HardwareAddr, error) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			// closing the socket unblocks pending reads
+			c.close()
+		case <-stop:
+		}
+	}()
+
+	src, dst, dstHwaddr := net.IPv4zero, net.IPv4bcast, net.HardwareAddr(nil)
+	// client holding a lease sends from the leased address even when it broadcasts the message
+	if m.ciaddr != nil && !m.ciaddr.IsUnspecified() {
+		src = m.ciaddr
+	}
+	if server != nil && server.Server != nil {
+		dst, dstHwaddr = server.Server, server.serverHwaddr
+	}
+
+	for i := 0; i <= opts.Retries; i++ {
+		if err := c.send(m, src, dst, dstHwaddr); err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			return nil, nil, err
+		}
+
+		reply, hwaddr, err := c.recv(time.Now().Add(opts.Timeout), m.xid, types)
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		if err == nil {
+			return reply, hwaddr, nil
+		}
+		if !os.IsTimeout(err) {
+			return nil, nil, err
+		}
+	}
+
+	return nil, nil, ErrDHCPTimeout
+}
+
+// send sends the message from src to dst IP address. Broadcast is used if the destination MAC address is nil.
+func (c *dhcpConn) send(m *dhcpMessage, src, dst net.IP, dstHwaddr net.HardwareAddr) error {
+	if dstHwaddr == nil {
+		dstHwaddr = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	}
+
+	payload, err := m.encode()
+	if err != nil {
+		return err
+	}
+
+	pkt := encodeUDPPacket(src, dst, dhcpClientPort, dhcpServerPort, payload)
+	sa := &syscall.SockaddrLinklayer{Protocol: htons(ethPIP), Ifindex: c.index, Halen: uint8(len(dstHwaddr))}
+	copy(sa.Addr[:], dstHwaddr)
+
+	rc, err := c.sock.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sendErr error
+	if err := rc.Write(func(fd uintptr) bool {
+		sendErr = syscall.Sendto(int(fd), pkt, 0, sa)
+		return sendErr != syscall.EAGAIN
+	}); err != nil {
+		return err
+	}
+
+	if sendErr != nil {
+		return fmt.Errorf("Could not send DHCP message: %s", sendErr)
+	}
+
+	return nil
+}
+
+// recv waits until the deadline for the reply to the transaction of one of the given types
+func (c *dhcpConn) recv(deadline time.Time, xid uint32, types []byte) (*dhcpMessage, net.HardwareAddr, error) {
+	if err := c.sock.SetReadDeadline(deadline); err != nil {
+		return nil, nil, err
+	}
+
+	rc, err := c.sock.SyscallConn()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buf := make([]byte, dhcpMaxPacketLen)
+	for {
+		var n int
+		var from syscall.Sockaddr
+		var recvErr error
+		if err := rc.Read(func(fd uintptr) bool {
+			n, from, recvErr = syscall.Recvfrom(int(fd), buf, 0)
+			return recvErr != syscall.EAGAIN
+		}); err != nil {
+			return nil, nil, err
+		}
+		if recvErr != nil {
+			return nil, nil, recvErr
+		}
+
+		payload, ok := decodeUDPPacket(buf[:n], dhcpClientPort)
+		if !ok {
+			continue
+		}
+
+		m, err := decodeDHCPMessage(payload)
+		if err != nil || m.op != dhcpBootReply || m.xid != xid || !bytes.Equal(m.chaddr, c.hwaddr) {
+			continue
+		}
+
+		for _, t := range types {
+			if m.msgType() == t {
+				var hwaddr net.HardwareAddr
+				if ll, ok := from.(*syscall.SockaddrLinklayer); ok {
+					hwaddr = append(hwaddr, ll.Addr[:ll.Halen]...)
+				}
+				return m, hwaddr, nil
+			}
+		}
+	}
+}
+
+// encodeUDPPacket encodes IPv4 packet carrying UDP datagram
+func encodeUDPPacket(src, dst net.IP, srcPort, dstPort int, payload []byte) []byte {
+	b := make([]byte, ipv4HeaderLen+udpHeaderLen+len(payload))
+
+	ip := b[:ipv4HeaderLen]
+	ip[0] = 0x45 // version 4, 5 words long header
+	binary.BigEndian.PutUint16(ip[2:], uint16(len(b)))
+	ip[8] = 64 // TTL
+	ip[9] = syscall.IPPROTO_UDP
+	copy(ip[12:16], src.To4())
+	copy(ip[16:20], dst.To4())
+	binary.BigEndian.PutUint16(ip[10:], checksum(ip))
+
+	udp := b[ipv4HeaderLen:]
+	binary.BigEndian.PutUint16(udp[0:], uint16(srcPort))
+	binary.BigEndian.PutUint16(udp[2:], uint16(dstPort))
+	binary.BigEndian.PutUint16(udp[4:], uint16(len(udp)))
+	copy(udp[udpHeaderLen:], payload)
+
+	// UDP checksum covers pseudo header made of addresses, protocol and UDP length
+	pseudo := make([]byte, 12)
+	copy(pseudo[0:4], src.To4())
+	copy(pseudo[4:8], dst.To4())
+	pseudo[9] = syscall.IPPROTO_UDP
+	binary.BigEndian.PutUint16(pseudo[10:], uint16(len(udp)))
+	sum := checksum(pseudo, udp)
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], sum)
+
+	return b
+}
+
+// decodeUDPPacket returns payload of UDP datagram sent to the given port carried by IPv4 packet.
+// It returns false if the packet is not such datagram.
+func decodeUDPPacket(b []byte, port int) ([]byte, bool) {
+	if len(b) < ipv4HeaderLen || b[0]>>4 != 4 || b[9] != syscall.IPPROTO_UDP {
+		return nil, false
+	}
+
+	ihl := int(b[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(b[2:]))
+	if ihl < ipv4HeaderLen || total > len(b) || total < ihl+udpHeaderLen {
+		return nil, false
+	}
+
+	udp := b[ihl:total]
+	length := int(binary.BigEndian.Uint16(udp[4:]))
+	if int(binary.BigEndian.Uint16(udp[2:])) != port || length < udpHeaderLen || length > len(udp) {
+		return nil, false
+	}
+
+	return udp[udpHeaderLen:length], true
+}
+
+// checksum returns Internet checksum as defined in RFC 1071 of the concatenated parts.
+// All parts but the last one must be of even length.
+func checksum(parts ...[]byte) uint16 {
+	var sum uint32
+	for _, b := range parts {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+
+	return ^uint16(sum)
+}
+
+// htons converts uint16 from host to network byte order
+func htons(n uint16) uint16 {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, n)
+
+	return nativeEndian.Uint16(b)
+}
@@ -0,0 +1,481 @@
+package tenus
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/milosgajdos/tenus/tenustest"
+)
+
+func Test_DHCPMessage(t *testing.T) {
+	m := &dhcpMessage{
+		op:     dhcpBootRequest,
+		xid:    0xdeadbeef,
+		flags:  dhcpFlagBroadcast,
+		ciaddr: net.ParseIP("10.0.0.10"),
+		chaddr: net.HardwareAddr{0x02, 0x42, 0xac, 0x00, 0x00, 0x01},
+		options: map[byte][]byte{
+			dhcpOptMessageType: {dhcpRequest},
+			dhcpOptHostname:    []byte("tenus"),
+			dhcpOptParams:      dhcpParams,
+		},
+	}
+
+	b, err := m.encode()
+	if err != nil {
+		t.Fatalf("encode() failed: %s", err)
+	}
+
+	if len(b) < dhcpMinMessageLen || b[dhcpHeaderLen+4] != dhcpOptMessageType {
+		t.Fatalf("encode() failed: returned %d bytes long message starting options with %d", len(b), b[dhcpHeaderLen+4])
+	}
+
+	decoded, err := decodeDHCPMessage(b)
+	if err != nil {
+		t.Fatalf("decodeDHCPMessage() failed: %s", err)
+	}
+
+	if decoded.xid != m.xid || decoded.flags != m.flags || !decoded.ciaddr.Equal(m.ciaddr) ||
+		decoded.chaddr.String() != m.chaddr.String() || !reflect.DeepEqual(decoded.options, m.options) {
+		t.Fatalf("decodeDHCPMessage() failed: expected %+v, returned %+v", m, decoded)
+	}
+
+	// values of repeated options are concatenated
+	opts := []byte{dhcpOptDNS, 4, 10, 0, 0, 53, dhcpOptPad, dhcpOptDNS, 4, 10, 0, 0, 54, dhcpOptEnd}
+	decoded, err = decodeDHCPMessage(append(b[:dhcpHeaderLen+4:dhcpHeaderLen+4], opts...))
+	if err != nil || fmt.Sprint(dhcpIPs(decoded.options[dhcpOptDNS])) != "[10.0.0.53 10.0.0.54]" {
+		t.Fatalf("decodeDHCPMessage() failed: returned %+v, %v", decoded, err)
+	}
+
+	if _, err := decodeDHCPMessage(append(b[:dhcpHeaderLen+4:dhcpHeaderLen+4], dhcpOptDNS, 8, 10)); err == nil {
+		t.Fatalf("decodeDHCPMessage() expected to fail for truncated option")
+	}
+
+	if _, err := decodeDHCPMessage(b[:dhcpHeaderLen]); err == nil {
+		t.Fatalf("decodeDHCPMessage() expected to fail for message without magic cookie")
+	}
+
+	// option length does not fit single byte
+	m.options[dhcpOptHostname] = bytes.Repeat([]byte("a"), 256)
+	if _, err := m.encode(); err == nil {
+		t.Fatalf("encode() expected to fail for %d bytes long host name", 256)
+	}
+
+	m.options[dhcpOptHostname] = bytes.Repeat([]byte("a"), 255)
+	if _, err := m.encode(); err != nil {
+		t.Fatalf("encode() failed for %d bytes long host name: %s", 255, err)
+	}
+
+	src, dst := net.ParseIP("10.0.0.10"), net.ParseIP("10.0.0.1")
+	pkt := encodeUDPPacket(src, dst, dhcpClientPort, dhcpServerPort, b)
+	if sum := checksum(pkt[:ipv4HeaderLen]); sum != 0 {
+		t.Fatalf("encodeUDPPacket() failed: invalid IP header checksum")
+	}
+
+	pseudo := make([]byte, 12)
+	copy(pseudo[0:4], src.To4())
+	copy(pseudo[4:8], dst.To4())
+	pseudo[9] = syscall.IPPROTO_UDP
+	binary.BigEndian.PutUint16(pseudo[10:], uint16(len(pkt)-ipv4HeaderLen))
+	if sum := checksum(pseudo, pkt[ipv4HeaderLen:]); sum != 0 {
+		t.Fatalf("encodeUDPPacket() failed: invalid UDP checksum")
+	}
+
+	if payload, ok := decodeUDPPacket(pkt, dhcpServerPort); !ok || !reflect.DeepEqual(payload, b) {
+		t.Fatalf("decodeUDPPacket() failed to decode the packet")
+	}
+
+	if _, ok := decodeUDPPacket(pkt, dhcpClientPort); ok {
+		t.Fatalf("decodeUDPPacket() returned datagram sent to another port")
+	}
+}
+
+type dhcpLeaseTest struct {
+	options map[byte][]byte
+	lease   string
+	routes  string
+	renew   time.Duration
+	rebind  time.Duration
+	err     bool
+}
+
+var dhcpLeaseTests = []dhcpLeaseTest{
+	{
+		options: map[byte][]byte{
+			dhcpOptSubnetMask: {255, 255, 255, 0},
+			dhcpOptRouter:     {10, 0, 0, 1, 10, 0, 0, 2},
+			dhcpOptLeaseTime:  {0, 0, 0, 80},
+		},
+		lease:  "10.0.0.10 10.0.0.0/24 10.0.0.1",
+		routes: "[{<nil> 10.0.0.1 7}]",
+		renew:  40 * time.Second,
+		rebind: 70 * time.Second,
+	},
+	{
+		options: map[byte][]byte{
+			dhcpOptSubnetMask:      {255, 255, 0, 0},
+			dhcpOptRouter:          {10, 0, 0, 1},
+			dhcpOptClasslessRoutes: {16, 192, 168, 10, 0, 0, 254, 0, 10, 0, 0, 1, 24, 10, 1, 0, 0, 0, 0, 0},
+			dhcpOptLeaseTime:       {0, 0, 0, 60},
+			dhcpOptRenewalTime:     {0, 0, 0, 10},
+			dhcpOptRebindingTime:   {0, 0, 0, 20},
+		},
+		lease:  "10.0.0.10 10.0.0.0/16 10.0.0.1",
+		routes: "[{192.168.0.0/16 10.0.0.254 7} {<nil> 10.0.0.1 7} {10.1.0.0/24 <nil> 7}]",
+		renew:  10 * time.Second,
+		rebind: 20 * time.Second,
+	},
+	{
+		options: map[byte][]byte{dhcpOptSubnetMask: {255, 255, 255, 0}},
+		err:     true,
+	},
+	{
+		options: map[byte][]byte{dhcpOptLeaseTime: {0, 0, 0, 60}, dhcpOptClasslessRoutes: {33, 10, 0, 0, 0, 0}},
+		err:     true,
+	},
+}
+
+func Test_ParseDHCPLease(t *testing.T) {
+	for _, tt := range dhcpLeaseTests {
+		m := &dhcpMessage{yiaddr: net.ParseIP("10.0.0.10"), options: tt.options}
+		lease, err := parseDHCPLease(m)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseDHCPLease(%v) expected to fail", tt.options)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseDHCPLease(%v) failed: %s", tt.options, err)
+			continue
+		}
+
+		var routes []Route
+		for _, r := range lease.linkRoutes(7) {
+			routes = append(routes, *r)
+		}
+
+		if s := fmt.Sprintf("%s %s %s", lease.IP, lease.Network, lease.Router); s != tt.lease ||
+			fmt.Sprint(routes) != tt.routes || lease.RenewAfter != tt.renew || lease.RebindAfter != tt.rebind {
+			t.Errorf("parseDHCPLease(%v) failed: returned %s %v %s %s", tt.options, s, routes, lease.RenewAfter, lease.RebindAfter)
+		}
+	}
+}
+
+// dhcpTestServer is DHCP server stand-in which leases addresses of 10.0.0.0/24 network.
+// It logs the types of received messages and refuses requests if nak is set.
+type dhcpTestServer struct {
+	conn net.PacketConn
+
+	mu     sync.Mutex
+	log    []string
+	leases map[string]net.IP
+	nak    bool
+	// source addresses of the requests to extend the lease
+	sources []string
+}
+
+// newDHCPTestServer starts DHCP server stand-in on the link with 10.0.0.1 address in the current network namespace
+func newDHCPTestServer(t *testing.T, link string) *dhcpTestServer {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("Could not open UDP socket: %s", err)
+	}
+
+	for _, opt := range []int{syscall.SO_REUSEADDR, syscall.SO_BROADCAST} {
+		if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, opt, 1); err != nil {
+			t.Fatalf("Could not set socket option: %s", err)
+		}
+	}
+
+	if err := syscall.BindToDevice(fd, link); err != nil {
+		t.Fatalf("Could not bind socket to %s: %s", link, err)
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Port: dhcpServerPort}); err != nil {
+		t.Fatalf("Could not bind socket: %s", err)
+	}
+
+	f := os.NewFile(uintptr(fd), "dhcp")
+	defer f.Close()
+
+	conn, err := net.FilePacketConn(f)
+	if err != nil {
+		t.Fatalf("Could not create packet connection: %s", err)
+	}
+
+	s := &dhcpTestServer{conn: conn, leases: make(map[string]net.IP)}
+	go s.serve()
+
+	return s
+}
+
+func (s *dhcpTestServer) serve() {
+	buf := make([]byte, dhcpMaxPacketLen)
+	for {
+		n, from, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		m, err := decodeDHCPMessage(buf[:n])
+		if err != nil || m.op != dhcpBootRequest {
+			continue
+		}
+
+		if m.msgType() == dhcpRequest && !m.ciaddr.IsUnspecified() {
+			s.mu.Lock()
+			s.sources = append(s.sources, from.(*net.UDPAddr).IP.String())
+			s.mu.Unlock()
+		}
+
+		if reply := s.reply(m); reply != nil {
+			dst := &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpClientPort}
+			if !m.ciaddr.IsUnspecified() {
+				dst.IP = m.ciaddr
+			}
+			if b, err := reply.encode(); err == nil {
+				s.conn.WriteTo(b, dst)
+			}
+		}
+	}
+}
+
+func (s *dhcpTestServer) reply(m *dhcpMessage) *dhcpMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reply := &dhcpMessage{
+		op:     dhcpBootReply,
+		xid:    m.xid,
+		flags:  m.flags,
+		chaddr: m.chaddr,
+		options: map[byte][]byte{
+			dhcpOptServerID:      {10, 0, 0, 1},
+			dhcpOptSubnetMask:    {255, 255, 255, 0},
+			dhcpOptRouter:        {10, 0, 0, 1},
+			dhcpOptDNS:           {10, 0, 0, 53},
+			dhcpOptLeaseTime:     {0, 0, 0, 4},
+			dhcpOptRenewalTime:   {0, 0, 0, 1},
+			dhcpOptRebindingTime: {0, 0, 0, 3},
+		},
+	}
+
+	ip, ok := s.leases[m.chaddr.String()]
+	if !ok {
+		ip = net.IPv4(10, 0, 0, byte(10+len(s.leases)))
+	}
+	reply.yiaddr = ip
+
+	switch m.msgType() {
+	case dhcpDiscover:
+		s.log = append(s.log, "discover")
+		reply.options[dhcpOptMessageType] = []byte{dhcpOffer}
+	case dhcpRequest:
+		if m.ciaddr.IsUnspecified() {
+			s.log = append(s.log, "request")
+		} else {
+			s.log = append(s.log, "renew")
+		}
+
+		if s.nak {
+			return &dhcpMessage{op: dhcpBootReply, xid: m.xid, chaddr: m.chaddr,
+				options: map[byte][]byte{dhcpOptMessageType: {dhcpNak}, dhcpOptServerID: {10, 0, 0, 1}}}
+		}
+
+		s.leases[m.chaddr.String()] = ip
+		reply.options[dhcpOptMessageType] = []byte{dhcpAck}
+	case dhcpRelease:
+		s.log = append(s.log, "release")
+		delete(s.leases, m.chaddr.String())
+		return nil
+	default:
+		return nil
+	}
+
+	return reply
+}
+
+// requests returns the types of messages received by the server
+func (s *dhcpTestServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.log...)
+}
+
+// lastSource returns source address of the last request to extend the lease
+func (s *dhcpTestServer) lastSource() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.sources) == 0 {
+		return ""
+	}
+
+	return s.sources[len(s.sources)-1]
+}
+
+func (s *dhcpTestServer) setNak(nak bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nak = nak
+}
+
+// dhcpLinkNet returns IPv4 addresses and routes of the link in the network namespace
+func dhcpLinkNet(t *testing.T, nspath, name string) (string, string) {
+	var addrs []string
+	var routes []string
+	err := ExecInNetNsPath(nspath, func() error {
+		ipnets, err := LinkAddrsByName(name)
+		if err != nil {
+			return err
+		}
+
+		for _, ipnet := range ipnets {
+			if ipnet.IP.To4() != nil {
+				addrs = append(addrs, ipnet.String())
+			}
+		}
+
+		list, err := RouteList()
+		if err != nil {
+			return err
+		}
+
+		for _, r := range list {
+			if r.Gw != nil {
+				routes = append(routes, fmt.Sprintf("%s via %s", r.Dst, r.Gw))
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Could not list %s addresses: %s", name, err)
+	}
+
+	return fmt.Sprint(addrs), fmt.Sprint(routes)
+}
+
+func Test_DHCPClient(t *testing.T) {
+	tenustest.New(t)
+
+	name := fmt.Sprintf("tenus-dhcp-%d", os.Getpid())
+	if err := NewNamedNetNs(name); err != nil {
+		t.Skipf("Could not create network namespace: %s", err)
+	}
+	defer DeleteNamedNetNs(name)
+	nspath := NetNsPath(name)
+
+	veth, err := NewVethPairWithOptions("dhcps0", VethOptions{PeerName: "dhcpc0"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	if err := veth.SetLinkIp(net.ParseIP("10.0.0.1"), &net.IPNet{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(24, 32)}); err != nil {
+		t.Fatalf("SetLinkIp() failed: %s", err)
+	}
+
+	if err := veth.SetLinkUp(); err != nil {
+		t.Fatalf("SetLinkUp() failed: %s", err)
+	}
+
+	link, err := NewLinkFrom("dhcpc0")
+	if err != nil {
+		t.Fatalf("NewLinkFrom() failed: %s", err)
+	}
+
+	if err := veth.SetPeerLinkNsFd(nspath); err != nil {
+		t.Fatalf("SetPeerLinkNsFd() failed: %s", err)
+	}
+
+	server := newDHCPTestServer(t, "dhcps0")
+	defer server.conn.Close()
+
+	client := NewDHCPClient(link, DHCPOptions{NsPath: nspath, Hostname: "tenus", Timeout: 500 * time.Millisecond})
+
+	lease, err := client.Start(context.Background())
+	if err != nil {
+		t.Fatalf("Start() failed: %s", err)
+	}
+
+	if lease.IP.String() != "10.0.0.10" || !lease.Server.Equal(net.ParseIP("10.0.0.1")) || fmt.Sprint(lease.DNS) != "[10.0.0.53]" {
+		t.Fatalf("Start() failed: returned lease %+v", lease)
+	}
+
+	if addrs, routes := dhcpLinkNet(t, nspath, "dhcpc0"); addrs != "[10.0.0.10/24]" || routes != "[<nil> via 10.0.0.1]" {
+		t.Fatalf("Start() failed: link addresses %s, routes %s", addrs, routes)
+	}
+
+	// the lease is renewed in the background after a second
+	deadline := time.Now().Add(5 * time.Second)
+	for client.Lease() == lease && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if renewed := client.Lease(); renewed == lease || renewed == nil || !renewed.IP.Equal(lease.IP) {
+		t.Fatalf("Lease() was not renewed: returned %+v, server received %v", renewed, server.requests())
+	}
+
+	if err := client.Stop(); err != nil {
+		t.Fatalf("Stop() failed: %s", err)
+	}
+
+	if addrs, routes := dhcpLinkNet(t, nspath, "dhcpc0"); addrs != "[]" || routes != "[]" {
+		t.Fatalf("Stop() failed to remove the lease: link addresses %s, routes %s", addrs, routes)
+	}
+
+	requests := server.requests()
+	if len(requests) < 4 || fmt.Sprint(requests[:3]) != "[discover request renew]" || requests[len(requests)-1] != "release" {
+		t.Fatalf("Server received unexpected requests: %v", requests)
+	}
+
+	// refused renewal removes the lease from the link
+	lease, err = client.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire() failed: %s", err)
+	}
+
+	// rebinding broadcasts the request from the leased address
+	if _, err := client.renew(context.Background(), true); err != nil {
+		t.Fatalf("renew() failed to rebind: %s", err)
+	}
+
+	if src := server.lastSource(); src != lease.IP.String() {
+		t.Fatalf("renew() failed to rebind: expected request from %s, server received it from %s", lease.IP, src)
+	}
+
+	server.setNak(true)
+	if _, err := client.Renew(context.Background()); !errors.Is(err, ErrDHCPNak) {
+		t.Fatalf("Renew() failed: expected %v, returned %v", ErrDHCPNak, err)
+	}
+
+	if addrs, _ := dhcpLinkNet(t, nspath, "dhcpc0"); addrs != "[]" || client.Lease() != nil {
+		t.Fatalf("Renew() failed to remove refused lease: link addresses %s", addrs)
+	}
+
+	server.conn.Close()
+	client = NewDHCPClient(link, DHCPOptions{NsPath: nspath, Timeout: 100 * time.Millisecond, Retries: 1})
+	if _, err := client.Acquire(context.Background()); !errors.Is(err, ErrDHCPTimeout) {
+		t.Fatalf("Acquire() failed: expected %v, returned %v", ErrDHCPTimeout, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.Acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Acquire() failed: expected %v, returned %v", context.Canceled, err)
+	}
+}
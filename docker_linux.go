@@ -0,0 +1,318 @@
+package tenus
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDockerHost is the address of Docker daemon used when DOCKER_HOST is not set
+	DefaultDockerHost = "unix:///var/run/docker.sock"
+	// DockerMaxAPIVersion is the highest Docker API version DockerClient negotiates
+	DockerMaxAPIVersion = "1.43"
+	// dockerFallbackAPIVersion is used when Docker daemon does not report its API version
+	dockerFallbackAPIVersion = "1.24"
+)
+
+// DockerClient queries Docker API of local or remote Docker daemon.
+// The zero value talks to DefaultDockerHost with the API version negotiated with the daemon.
+// DockerClient is safe for concurrent use.
+type DockerClient struct {
+	// Host is the address of Docker daemon: unix:///path, tcp://HOST:PORT, http(s)://HOST:PORT,
+	// full path to Docker UNIX socket or HOST:PORT address string. DefaultDockerHost is used if empty.
+	Host string
+	// APIVersion is the Docker API version, i.e. "1.41". It's negotiated with Docker daemon if empty.
+	APIVersion string
+	// TLSConfig enables TLS for TCP connections to Docker daemon
+	TLSConfig *tls.Config
+
+	mu sync.Mutex
+	// API version negotiated with Docker daemon
+	version string
+}
+
+// NewDockerClientFromEnv returns DockerClient configured by the environment variables used by Docker CLI:
+// DOCKER_HOST, DOCKER_API_VERSION, DOCKER_CERT_PATH and DOCKER_TLS_VERIFY.
+// It returns error if TLS certificates can not be loaded.
+func NewDockerClientFromEnv() (*DockerClient, error) {
+	c := &DockerClient{
+		Host:       os.Getenv("DOCKER_HOST"),
+		APIVersion: os.Getenv("DOCKER_API_VERSION"),
+	}
+
+	if certPath := os.Getenv("DOCKER_CERT_PATH"); certPath != "" {
+		config, err := DockerTLSConfig(certPath, os.Getenv("DOCKER_TLS_VERIFY") != "")
+		if err != nil {
+			return nil, err
+		}
+
+		c.TLSConfig = config
+	}
+
+	return c, nil
+}
+
+// DockerTLSConfig loads TLS client configuration from ca.pem, cert.pem and key.pem files in certPath directory.
+// If verify is false, certificate of Docker daemon is not verified.
+// It returns error if any of the files can not be loaded.
+func DockerTLSConfig(certPath string, verify bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(certPath, "cert.pem"), filepath.Join(certPath, "key.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("Could not load Docker client certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: !verify,
+	}
+
+	ca, err := ioutil.ReadFile(filepath.Join(certPath, "ca.pem"))
+	if err != nil {
+		if verify {
+			return nil, fmt.Errorf("Could not load Docker CA certificate: %w", err)
+		}
+
+		return config, nil
+	}
+
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("Could not load Docker CA certificate: no certificates in %s", filepath.Join(certPath, "ca.pem"))
+	}
+
+	return config, nil
+}
+
+// ContainerPid returns PID of the running Docker container specified by ID or name.
+// It returns ErrContainerNotRunning if the container is not running and DockerError if Docker API
+// responds with error, which matches ErrNsNotFound if the container does not exist.
+func (c *DockerClient) ContainerPid(ctx context.Context, id string) (int, error) {
+	if id == "" {
+		return 0, errors.New("Docker name can not be empty!")
+	}
+
+	api, err := c.newAPI()
+	if err != nil {
+		return 0, err
+	}
+	defer api.close()
+
+	version, err := c.negotiate(ctx, api)
+	if err != nil {
+		return 0, err
+	}
+
+	data := struct {
+		State struct {
+			Status  string
+			Running bool
+			Pid     int
+		}
+	}{}
+
+	if err := api.get(ctx, "/v"+version+"/containers/"+url.PathEscape(id)+"/json", &data); err != nil {
+		return 0, err
+	}
+
+	if !data.State.Running || data.State.Pid <= 0 {
+		return 0, fmt.Errorf("%w: docker %s is %s", ErrContainerNotRunning, id, data.State.Status)
+	}
+
+	return data.State.Pid, nil
+}
+
+// ResolveNs returns network namespace path of the running Docker container specified by ID or name.
+func (c *DockerClient) ResolveNs(ctx context.Context, id string) (string, error) {
+	pid, err := c.ContainerPid(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	return pidNsPath(pid), nil
+}
+
+// negotiate returns API version to use with Docker daemon.
+// Docker daemon reports the highest API version it supports which is capped at DockerMaxAPIVersion.
+func (c *DockerClient) negotiate(ctx context.Context, api *dockerAPI) (string, error) {
+	if c.APIVersion != "" {
+		return strings.TrimPrefix(c.APIVersion, "v"), nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.version != "" {
+		return c.version, nil
+	}
+
+	version, err := api.ping(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if version == "" {
+		version = dockerFallbackAPIVersion
+	}
+
+	if compareAPIVersions(version, DockerMaxAPIVersion) > 0 {
+		version = DockerMaxAPIVersion
+	}
+
+	c.version = version
+
+	return version, nil
+}
+
+// compareAPIVersions compares MAJOR.MINOR API versions and returns -1, 0 or 1
+func compareAPIVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// dockerAPI sends requests to Docker daemon
+type dockerAPI struct {
+	base      string
+	client    http.Client
+	transport *http.Transport
+}
+
+// newAPI returns dockerAPI talking to the Docker daemon at the client's host
+func (c *DockerClient) newAPI() (*dockerAPI, error) {
+	host := c.Host
+	if host == "" {
+		host = DefaultDockerHost
+	}
+
+	var network, addr, scheme string
+	switch {
+	case strings.HasPrefix(host, "unix://"):
+		network, addr, scheme = "unix", strings.TrimPrefix(host, "unix://"), "http"
+	case filepath.IsAbs(host):
+		network, addr, scheme = "unix", host, "http"
+	case strings.HasPrefix(host, "tcp://"), strings.HasPrefix(host, "http://"), strings.HasPrefix(host, "https://"):
+		u, err := url.Parse(host)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("Invalid Docker host %q", host)
+		}
+		network, addr, scheme = "tcp", u.Host, u.Scheme
+	case !strings.Contains(host, "://"):
+		network, addr, scheme = "tcp", host, "http"
+	default:
+		return nil, fmt.Errorf("Invalid Docker host %q: unsupported protocol", host)
+	}
+
+	if scheme == "tcp" {
+		scheme = "http"
+	}
+
+	if network == "tcp" && c.TLSConfig != nil {
+		scheme = "https"
+	}
+
+	dialer := &net.Dialer{Timeout: 2 * time.Second}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, proto string, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+		TLSClientConfig: c.TLSConfig,
+	}
+
+	base := scheme + "://" + addr
+	if network == "unix" {
+		base = "http://docker.socket"
+	}
+
+	return &dockerAPI{base: base, client: http.Client{Transport: transport}, transport: transport}, nil
+}
+
+// close closes idle connections to Docker daemon
+func (api *dockerAPI) close() {
+	api.transport.CloseIdleConnections()
+}
+
+// ping returns API version reported by Docker daemon
+func (api *dockerAPI) ping(ctx context.Context) (string, error) {
+	resp, err := api.do(ctx, "/_ping")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	return resp.Header.Get("API-Version"), nil
+}
+
+// get sends GET request for path and decodes JSON response into v
+func (api *dockerAPI) get(ctx context.Context, path string, v interface{}) error {
+	resp, err := api.do(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("Unable to decode json response: %w", err)
+	}
+
+	return nil
+}
+
+// do sends GET request for path and returns the response. Error responses are returned as DockerError.
+func (api *dockerAPI) do(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", api.base+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to create http request: %s", err)
+	}
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Docker API: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	data := struct {
+		Message string
+	}{}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err := json.Unmarshal(body, &data); err != nil {
+		data.Message = strings.TrimSpace(string(body))
+	}
+
+	return nil, &DockerError{StatusCode: resp.StatusCode, Message: data.Message}
+}
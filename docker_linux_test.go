@@ -0,0 +1,357 @@
+package tenus
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDocker emulates Docker daemon serving container inspect API
+type fakeDocker struct {
+	mu sync.Mutex
+	// API version reported by /_ping, none if empty
+	version string
+	// container inspect documents by container ID
+	containers map[string]string
+	// URL paths of the received requests
+	requests []string
+}
+
+func (d *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	path := r.URL.EscapedPath()
+	d.requests = append(d.requests, path)
+
+	if path == "/_ping" {
+		if d.version != "" {
+			w.Header().Set("API-Version", d.version)
+		}
+		w.Write([]byte("OK"))
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) != 5 || !strings.HasPrefix(parts[1], "v") || parts[2] != "containers" || parts[4] != "json" {
+		http.Error(w, `{"message":"page not found"}`, http.StatusNotFound)
+		return
+	}
+
+	switch parts[3] {
+	case "locked01":
+		http.Error(w, `{"message":"authentication required"}`, http.StatusUnauthorized)
+		return
+	case "removing01":
+		http.Error(w, `{"message":"removal of container removing01 is already in progress"}`, http.StatusConflict)
+		return
+	}
+
+	doc, ok := d.containers[parts[3]]
+	if !ok {
+		http.Error(w, `{"message":"No such container: `+parts[3]+`"}`, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(doc))
+}
+
+func (d *fakeDocker) lastRequest() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.requests[len(d.requests)-1]
+}
+
+func newFakeDocker(version string) *fakeDocker {
+	return &fakeDocker{
+		version: version,
+		containers: map[string]string{
+			"web01":     `{"Id":"3f4e","State":{"Status":"running","Running":true,"Pid":1234}}`,
+			"web%2F02":  `{"Id":"8a1b","State":{"Status":"running","Running":true,"Pid":1235}}`,
+			"stopped01": `{"Id":"6c0d","State":{"Status":"exited","Running":false,"Pid":0}}`,
+		},
+	}
+}
+
+type dockerClientTest struct {
+	id  string
+	pid int
+	err error
+}
+
+var dockerClientTests = []dockerClientTest{
+	{"web01", 1234, nil},
+	{"web/02", 1235, nil},
+	{"stopped01", 0, ErrContainerNotRunning},
+	{"missing01", 0, ErrNsNotFound},
+	{"locked01", 0, ErrDockerUnauthorized},
+	{"removing01", 0, ErrDockerConflict},
+}
+
+func Test_DockerClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenus")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	docker := newFakeDocker("1.41")
+	sock, stop := newUnixServer(t, dir, docker)
+	defer stop()
+
+	client := &DockerClient{Host: "unix://" + sock}
+	for _, tt := range dockerClientTests {
+		pid, err := client.ContainerPid(context.Background(), tt.id)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("ContainerPid(%q) failed: expected %v, returned %v", tt.id, tt.err, err)
+			}
+			continue
+		}
+
+		if err != nil || pid != tt.pid {
+			t.Errorf("ContainerPid(%q) failed: expected %d, returned %d, %v", tt.id, tt.pid, pid, err)
+		}
+	}
+
+	if expected := "/v1.41/containers/removing01/json"; docker.lastRequest() != expected {
+		t.Fatalf("ContainerPid() failed: expected request %s, returned %s", expected, docker.lastRequest())
+	}
+
+	// API version is negotiated once
+	if docker.requests[0] != "/_ping" || docker.requests[1] == "/_ping" || len(docker.requests) != len(dockerClientTests)+1 {
+		t.Fatalf("ContainerPid() failed to negotiate API version: requests %v", docker.requests)
+	}
+
+	_, err = client.ContainerPid(context.Background(), "locked01")
+
+	var dockerErr *DockerError
+	if !errors.As(err, &dockerErr) || dockerErr.StatusCode != http.StatusUnauthorized || dockerErr.Message != "authentication required" {
+		t.Fatalf("ContainerPid() failed: expected DockerError, returned %v", err)
+	}
+
+	nspath, err := (&DockerResolver{Host: sock}).ResolveNs(context.Background(), "web01")
+	if err != nil || nspath != "/proc/1234/ns/net" {
+		t.Fatalf("DockerResolver.ResolveNs() failed: returned %q, %v", nspath, err)
+	}
+
+	if pid, err := DockerPidByName("web01", sock); err != nil || pid != 1234 {
+		t.Fatalf("DockerPidByName() failed: returned %d, %v", pid, err)
+	}
+}
+
+type dockerVersionTest struct {
+	server   string
+	client   string
+	expected string
+}
+
+var dockerVersionTests = []dockerVersionTest{
+	{"1.41", "", "1.41"},
+	{"1.45", "", DockerMaxAPIVersion},
+	{"1.9", "", "1.9"},
+	{"", "", "1.24"},
+	{"1.41", "1.30", "1.30"},
+	{"1.41", "v1.30", "1.30"},
+}
+
+func Test_DockerClientAPIVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenus")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, tt := range dockerVersionTests {
+		docker := newFakeDocker(tt.server)
+		sock, stop := newUnixServer(t, dir, docker)
+
+		client := &DockerClient{Host: sock, APIVersion: tt.client}
+		if _, err := client.ContainerPid(context.Background(), "web01"); err != nil {
+			t.Errorf("ContainerPid() failed: %s", err)
+		}
+
+		stop()
+		os.Remove(sock)
+
+		if expected := "/v" + tt.expected + "/containers/web01/json"; docker.lastRequest() != expected {
+			t.Errorf("%d: ContainerPid() failed: expected request %s, returned %s", i, expected, docker.lastRequest())
+		}
+	}
+}
+
+func Test_NewDockerClientFromEnv(t *testing.T) {
+	for _, name := range []string{"DOCKER_HOST", "DOCKER_API_VERSION", "DOCKER_CERT_PATH", "DOCKER_TLS_VERIFY"} {
+		if val, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, val)
+		} else {
+			defer os.Unsetenv(name)
+		}
+	}
+
+	os.Setenv("DOCKER_HOST", "tcp://10.0.0.1:2376")
+	os.Setenv("DOCKER_API_VERSION", "1.40")
+	os.Setenv("DOCKER_CERT_PATH", "")
+
+	client, err := NewDockerClientFromEnv()
+	if err != nil {
+		t.Fatalf("NewDockerClientFromEnv() failed: %s", err)
+	}
+
+	if client.Host != "tcp://10.0.0.1:2376" || client.APIVersion != "1.40" || client.TLSConfig != nil {
+		t.Fatalf("NewDockerClientFromEnv() failed: returned %+v", client)
+	}
+
+	os.Setenv("DOCKER_CERT_PATH", "/nonexistent")
+	if _, err := NewDockerClientFromEnv(); err == nil {
+		t.Fatalf("NewDockerClientFromEnv() expected to fail with missing certificates")
+	}
+
+	for _, host := range []string{"ssh://docker.example.com", "tcp://"} {
+		if _, err := (&DockerClient{Host: host}).ContainerPid(context.Background(), "web01"); err == nil {
+			t.Errorf("ContainerPid() expected to fail with Docker host %s", host)
+		}
+	}
+}
+
+// writeClientCert generates self-signed client certificate into cert.pem and key.pem files in dir
+func writeClientCert(dir string) (*x509.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "tenus"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "cert.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+func Test_DockerClientTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenus")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, err := writeClientCert(dir)
+	if err != nil {
+		t.Fatalf("Could not create client certificate: %s", err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+
+	srv := httptest.NewUnstartedServer(newFakeDocker("1.41"))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+
+	host := "tcp://" + srv.Listener.Addr().String()
+
+	// daemon certificate can't be verified without CA certificate
+	config, err := DockerTLSConfig(dir, true)
+	if err == nil {
+		t.Fatalf("DockerTLSConfig() expected to fail without CA certificate")
+	}
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(filepath.Join(dir, "ca.pem"), ca, 0644); err != nil {
+		t.Fatalf("Could not create CA certificate: %s", err)
+	}
+
+	config, err = DockerTLSConfig(dir, true)
+	if err != nil {
+		t.Fatalf("DockerTLSConfig() failed: %s", err)
+	}
+
+	pid, err := (&DockerClient{Host: host, TLSConfig: config}).ContainerPid(context.Background(), "web01")
+	if err != nil || pid != 1234 {
+		t.Fatalf("ContainerPid() over TLS failed: returned %d, %v", pid, err)
+	}
+
+	// daemon requires client certificate
+	config.Certificates = nil
+	if _, err := (&DockerClient{Host: host, TLSConfig: config}).ContainerPid(context.Background(), "web01"); err == nil {
+		t.Fatalf("ContainerPid() expected to fail without client certificate")
+	}
+
+	if _, err := (&DockerClient{Host: host}).ContainerPid(context.Background(), "web01"); err == nil {
+		t.Fatalf("ContainerPid() expected to fail without TLS")
+	}
+}
+
+func Test_SetLinkNsToDockerErrors(t *testing.T) {
+	defer SetBackend(SetBackend(NewFakeBackend()))
+
+	dir, err := ioutil.TempDir("", "tenus")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock, stop := newUnixServer(t, dir, newFakeDocker("1.41"))
+	defer stop()
+
+	veth, err := NewVethPairWithOptions("vethdck01", VethOptions{PeerName: "vethdck02"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	err = veth.SetPeerLinkNsToDocker("locked01", sock)
+
+	var dockerErr *DockerError
+	if !errors.Is(err, ErrNsNotFound) || !errors.Is(err, ErrDockerUnauthorized) || !errors.As(err, &dockerErr) {
+		t.Fatalf("SetPeerLinkNsToDocker() failed: expected %v, returned %v", ErrDockerUnauthorized, err)
+	}
+
+	err = veth.SetLinkNsTo(&DockerClient{Host: sock}, "stopped01")
+	if !errors.Is(err, ErrContainerNotRunning) || !errors.Is(err, ErrNsNotFound) {
+		t.Fatalf("SetLinkNsTo() failed: expected %v, returned %v", ErrContainerNotRunning, err)
+	}
+}
@@ -0,0 +1,192 @@
+package tenus
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"syscall"
+)
+
+// Sentinel errors returned by tenus functions. Use errors.Is to check for them.
+var (
+	// ErrLinkExists is returned when a link of the given name already exists
+	ErrLinkExists = errors.New("link already exists")
+	// ErrLinkNotFound is returned when a link can not be found
+	ErrLinkNotFound = errors.New("link not found")
+	// ErrInvalidName is returned when an invalid interface name is supplied
+	ErrInvalidName = errors.New("invalid interface name")
+	// ErrInvalidMAC is returned when an invalid or already assigned MAC address is supplied
+	ErrInvalidMAC = errors.New("invalid MAC address")
+	// ErrNsNotFound is returned when a network namespace can not be found
+	ErrNsNotFound = errors.New("network namespace not found")
+	// ErrPermission is returned when the caller is not allowed to perform the operation
+	ErrPermission = errors.New("permission denied")
+	// ErrNoCapability is returned when the process lacks Linux capability required by the operation
+	ErrNoCapability = errors.New("missing capability")
+	// ErrContainerNotRunning is returned when a container exists, but it's not running.
+	// It matches ErrNsNotFound, too.
+	ErrContainerNotRunning = fmt.Errorf("%w: container is not running", ErrNsNotFound)
+	// ErrDockerUnauthorized is returned when Docker daemon rejects the request as unauthorized
+	ErrDockerUnauthorized = errors.New("docker: unauthorized")
+	// ErrDockerConflict is returned when the request conflicts with the state of Docker container
+	ErrDockerConflict = errors.New("docker: conflict")
+	// ErrPoolExists is returned when an IPAM pool of the given name already exists
+	ErrPoolExists = errors.New("ipam: pool already exists")
+	// ErrPoolNotFound is returned when an IPAM pool can not be found
+	ErrPoolNotFound = errors.New("ipam: pool not found")
+	// ErrPoolExhausted is returned when an IPAM pool has no free IP address left
+	ErrPoolExhausted = errors.New("ipam: pool exhausted")
+	// ErrAddrInUse is returned when the requested IP address is allocated, reserved or outside of the pool
+	ErrAddrInUse = errors.New("ipam: address not available")
+	// ErrDHCPNak is returned when DHCP server refuses to grant or renew the lease
+	ErrDHCPNak = errors.New("dhcp: server refused the lease")
+	// ErrDHCPTimeout is returned when no DHCP server responded to the client
+	ErrDHCPTimeout = errors.New("dhcp: no response from server")
+)
+
+// LinkError records a failed network link operation.
+// The underlying error is preserved, so syscall.Errno returned by Linux kernel
+// can be retrieved with errors.As.
+type LinkError struct {
+	// Operation i.e. "create", "set mtu"
+	Op string
+	// Link name
+	Link string
+	// Network namespace the operation was performed in. Empty for the current network namespace.
+	Ns string
+	// Underlying error
+	Err error
+}
+
+// Error returns the operation, link, network namespace and the underlying error
+func (e *LinkError) Error() string {
+	msg := e.Op
+	if e.Link != "" {
+		msg += " " + e.Link
+	}
+	if e.Ns != "" {
+		msg += " in netns " + e.Ns
+	}
+
+	return msg + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *LinkError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the underlying syscall.Errno matches one of the sentinel errors
+func (e *LinkError) Is(target error) bool {
+	var errno syscall.Errno
+	if !errors.As(e.Err, &errno) {
+		return false
+	}
+
+	switch target {
+	case ErrLinkExists:
+		return errno == syscall.EEXIST
+	case ErrLinkNotFound:
+		return errno == syscall.ENODEV
+	case ErrPermission:
+		return errno == syscall.EPERM || errno == syscall.EACCES
+	}
+
+	return false
+}
+
+// newLinkError wraps err into LinkError. It returns nil if err is nil.
+// LinkError of the same link is returned unchanged so errors don't get wrapped twice.
+func newLinkError(op, link string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if le, ok := err.(*LinkError); ok && le.Link == link {
+		return err
+	}
+
+	return &LinkError{Op: op, Link: link, Err: err}
+}
+
+// newLinkNsError wraps err into LinkError of the operation performed in the network namespace.
+// It returns nil if err is nil.
+func newLinkNsError(op, link, ns string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if le, ok := err.(*LinkError); ok && le.Link == link {
+		if le.Ns == "" {
+			le.Ns = ns
+		}
+		return err
+	}
+
+	return &LinkError{Op: op, Link: link, Ns: ns, Err: err}
+}
+
+// CapabilityError records an operation which failed because the process lacks a Linux capability.
+// It matches both ErrNoCapability and ErrPermission.
+type CapabilityError struct {
+	// Capability name i.e. "CAP_NET_ADMIN"
+	Cap string
+	// Underlying error, usually syscall.EPERM returned by Linux kernel
+	Err error
+}
+
+// Error returns the missing capability and the underlying error
+func (e *CapabilityError) Error() string {
+	msg := "missing capability " + e.Cap
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+
+	return msg
+}
+
+// Unwrap returns the underlying error
+func (e *CapabilityError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrNoCapability or ErrPermission
+func (e *CapabilityError) Is(target error) bool {
+	return target == ErrNoCapability || target == ErrPermission
+}
+
+// DockerError records an error response of Docker API.
+// It matches ErrNsNotFound if the container does not exist, ErrDockerUnauthorized if
+// the request was not authorized and ErrDockerConflict if the container is in conflicting state.
+type DockerError struct {
+	// HTTP status code of the response
+	StatusCode int
+	// Error message returned by Docker daemon
+	Message string
+}
+
+// Error returns the status code and the error message
+func (e *DockerError) Error() string {
+	msg := fmt.Sprintf("docker API error %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+
+	return msg
+}
+
+// Is reports whether the status code matches one of the sentinel errors
+func (e *DockerError) Is(target error) bool {
+	switch target {
+	case ErrNsNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrDockerUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrDockerConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrPermission:
+		return e.StatusCode == http.StatusForbidden
+	}
+
+	return false
+}
@@ -0,0 +1,107 @@
+package tenus
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/milosgajdos/tenus/tenustest"
+)
+
+type linkErrorTest struct {
+	err      error
+	sentinel error
+	match    bool
+}
+
+var linkErrorTests = []linkErrorTest{
+	{&LinkError{Op: "create", Link: "veth01", Err: syscall.EEXIST}, ErrLinkExists, true},
+	{&LinkError{Op: "set mtu", Link: "veth01", Err: syscall.ENODEV}, ErrLinkNotFound, true},
+	{&LinkError{Op: "set up", Link: "veth01", Err: syscall.EPERM}, ErrPermission, true},
+	{&LinkError{Op: "set up", Link: "veth01", Err: syscall.EACCES}, ErrPermission, true},
+	{&LinkError{Op: "create", Link: "veth01", Err: &TxError{Step: "create link veth01", Err: syscall.EEXIST}}, ErrLinkExists, true},
+	{&LinkError{Op: "create", Link: "veth01", Err: syscall.EINVAL}, ErrLinkExists, false},
+	{&LinkError{Op: "create", Link: "veth01", Err: ErrInvalidName}, ErrInvalidName, true},
+	{&LinkError{Op: "set mtu", Link: "veth01", Err: syscall.EEXIST}, ErrLinkNotFound, false},
+}
+
+func Test_LinkErrorIs(t *testing.T) {
+	for _, tt := range linkErrorTests {
+		if match := errors.Is(tt.err, tt.sentinel); match != tt.match {
+			t.Errorf("errors.Is(%v, %v) failed: expected %v, returned %v", tt.err, tt.sentinel, tt.match, match)
+		}
+	}
+}
+
+func Test_LinkErrorAs(t *testing.T) {
+	err := newLinkNsError("set netns", "veth01", "1234", syscall.EPERM)
+
+	var linkErr *LinkError
+	if !errors.As(err, &linkErr) {
+		t.Fatalf("errors.As(%v) failed: expected *LinkError", err)
+	}
+
+	if linkErr.Op != "set netns" || linkErr.Link != "veth01" || linkErr.Ns != "1234" {
+		t.Fatalf("errors.As(%v) failed: returned %+v", err, linkErr)
+	}
+
+	var errno syscall.Errno
+	if !errors.As(err, &errno) || errno != syscall.EPERM {
+		t.Fatalf("errors.As(%v) failed: expected %v, returned %v", err, syscall.EPERM, errno)
+	}
+
+	expected := "set netns veth01 in netns 1234: operation not permitted"
+	if err.Error() != expected {
+		t.Fatalf("LinkError.Error() failed: expected %q, returned %q", expected, err.Error())
+	}
+
+	if newLinkError("set mtu", "veth01", err) != err {
+		t.Fatalf("newLinkError() failed: LinkError of the same link wrapped twice")
+	}
+
+	if newLinkError("set mtu", "veth01", nil) != nil {
+		t.Fatalf("newLinkError() failed: expected nil for nil error")
+	}
+}
+
+func Test_SentinelErrors(t *testing.T) {
+	if _, err := NetInterfaceNameValid("a b"); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("NetInterfaceNameValid() failed: expected %v, returned %v", ErrInvalidName, err)
+	}
+
+	if _, err := NewLinkFrom("tenusnone01"); !errors.Is(err, ErrLinkNotFound) {
+		t.Errorf("NewLinkFrom() failed: expected %v, returned %v", ErrLinkNotFound, err)
+	}
+
+	if err := ExecInNamedNetNs("tenusnone01", func() error { return nil }); !errors.Is(err, ErrNsNotFound) {
+		t.Errorf("ExecInNamedNetNs() failed: expected %v, returned %v", ErrNsNotFound, err)
+	}
+
+	tenustest.New(t)
+
+	veth, err := NewVethPairWithOptions("vetherr01", VethOptions{PeerName: "vetherr02"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions(%s) failed to run: %s", "vetherr01", err)
+	}
+
+	if _, err := NewVethPairWithOptions("vetherr01", VethOptions{PeerName: "vetherr03"}); !errors.Is(err, ErrLinkExists) {
+		t.Fatalf("NewVethPairWithOptions() failed: expected %v, returned %v", ErrLinkExists, err)
+	}
+
+	if err := veth.SetLinkMacAddress("zz:zz"); !errors.Is(err, ErrInvalidMAC) {
+		t.Fatalf("SetLinkMacAddress() failed: expected %v, returned %v", ErrInvalidMAC, err)
+	}
+
+	if err := veth.SetLinkMTU(-1); err == nil {
+		t.Fatalf("SetLinkMTU() expected to fail")
+	}
+
+	if err := DeleteLink("vetherr01"); err != nil {
+		t.Fatalf("DeleteLink(%s) failed: %s", "vetherr01", err)
+	}
+
+	var linkErr *LinkError
+	if err := veth.SetLinkUp(); !errors.As(err, &linkErr) || !errors.Is(err, ErrLinkNotFound) {
+		t.Fatalf("SetLinkUp() of deleted link failed: expected %v, returned %v", ErrLinkNotFound, err)
+	}
+}
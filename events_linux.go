@@ -0,0 +1,562 @@
+package tenus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// rtnetlink multicast groups which are not exported by syscall package
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpNeigh      = 0x4
+	rtmgrpIpv4Ifaddr = 0x10
+	rtmgrpIpv4Route  = 0x40
+	rtmgrpIpv6Ifaddr = 0x100
+	rtmgrpIpv6Route  = 0x400
+)
+
+// neighbour table attributes which are not exported by syscall package
+const (
+	ndaDst    = 1
+	ndaLladdr = 2
+)
+
+// sizeofNdmsg is the size of ndmsg structure which prefixes neighbour netlink messages
+const sizeofNdmsg = 12
+
+// size of the buffer used to receive netlink multicast messages
+const eventBufSize = 1 << 16
+
+// EventType is the type of network event delivered by Subscribe.
+type EventType int
+
+// Network event types
+const (
+	EventLinkNew EventType = iota
+	EventLinkDel
+	EventAddrNew
+	EventAddrDel
+	EventRouteNew
+	EventRouteDel
+	EventNeighNew
+	EventNeighDel
+	// EventResync is delivered when the kernel dropped events because the subscriber
+	// did not keep up. It is followed by the current state of all subscribed objects.
+	EventResync
+)
+
+var eventTypes = map[EventType]string{
+	EventLinkNew:  "newlink",
+	EventLinkDel:  "dellink",
+	EventAddrNew:  "newaddr",
+	EventAddrDel:  "deladdr",
+	EventRouteNew: "newroute",
+	EventRouteDel: "delroute",
+	EventNeighNew: "newneigh",
+	EventNeighDel: "delneigh",
+	EventResync:   "resync",
+}
+
+// String returns event type name
+func (t EventType) String() string {
+	if name, ok := eventTypes[t]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// EventFilter allows you to specify which network events should be delivered by Subscribe.
+type EventFilter struct {
+	// Subscribe to link events
+	Link bool
+	// Subscribe to IP address events
+	Addr bool
+	// Subscribe to route events
+	Route bool
+	// Subscribe to neighbour events
+	Neigh bool
+	// Deliver only events related to the link with the given index. 0 delivers events of all links.
+	Index int
+	// Network namespace PID to watch. 0 watches the current network namespace.
+	Ns int
+}
+
+// AddrUpdate describes an IP address change of a network link.
+type AddrUpdate struct {
+	// Index of the link the address is assigned to
+	Index int
+	// IP address and its network mask
+	IPNet *net.IPNet
+}
+
+// RouteUpdate describes a routing table change.
+type RouteUpdate struct {
+	// Destination network. nil for default route.
+	Dst *net.IPNet
+	// Gateway address
+	Gw net.IP
+	// Index of the outgoing link
+	Index int
+	// Routing table
+	Table int
+}
+
+// NeighUpdate describes a neighbour table change.
+type NeighUpdate struct {
+	// Index of the link the neighbour was seen on
+	Index int
+	// Neighbour IP address
+	IP net.IP
+	// Neighbour MAC address
+	HardwareAddr net.HardwareAddr
+	// Neighbour state i.e. NUD_REACHABLE
+	State int
+}
+
+// Event is a network event received from Linux kernel.
+// Only the field which matches the event Type is set.
+type Event struct {
+	// Event type
+	Type EventType
+	// Resync is true if the event was generated by resynchronisation after event queue overflow
+	Resync bool
+	// Link attributes for link events
+	Link *LinkAttrs
+	// Address update for address events
+	Addr *AddrUpdate
+	// Route update for route events
+	Route *RouteUpdate
+	// Neighbour update for neighbour events
+	Neigh *NeighUpdate
+}
+
+// index returns the index of the link the event relates to
+func (e *Event) index() int {
+	switch {
+	case e.Link != nil:
+		return e.Link.Index
+	case e.Addr != nil:
+		return e.Addr.Index
+	case e.Route != nil:
+		return e.Route.Index
+	case e.Neigh != nil:
+		return e.Neigh.Index
+	}
+
+	return 0
+}
+
+// Subscribe streams network events matching the filter until the context is cancelled.
+//
+// Events are read from rtnetlink multicast groups, optionally in the network namespace specified by
+// filter's Ns. If the subscriber does not keep up and the kernel drops events, Subscribe sends EventResync
+// followed by the current state of all subscribed objects marked with Resync.
+// The returned channel is closed when the context is cancelled or when reading events fails.
+// It returns error if no event group is selected or if the netlink socket could not be opened.
+func Subscribe(ctx context.Context, filter EventFilter) (<-chan Event, error) {
+	groups := filter.groups()
+	if groups == 0 {
+		return nil, errors.New("No event groups specified in the event filter")
+	}
+
+	var sock *os.File
+	if err := execInNetNs(filter.Ns, func() error {
+		var err error
+		sock, err = openEventSocket(groups)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event, 64)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			// expired deadline unblocks pending reads
+			sock.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	go func() {
+		defer close(events)
+		defer sock.Close()
+		defer close(done)
+
+		buf := make([]byte, eventBufSize)
+		for {
+			n, err := recvEvents(sock, buf)
+			if err == syscall.ENOBUFS {
+				if !filter.resync(ctx, events) {
+					return
+				}
+				continue
+			}
+			if err != nil {
+				return
+			}
+
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+
+			for i := range msgs {
+				ev, err := parseEvent(&msgs[i])
+				if err != nil || ev == nil || !filter.match(ev) {
+					continue
+				}
+
+				if !sendEvent(ctx, events, *ev) {
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// WaitForLinkState blocks until the link of the given name reaches the given operational state.
+// It returns error if the context is cancelled before the link reaches the requested state.
+func WaitForLinkState(ctx context.Context, name string, state OperState) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// subscribe before checking the current state so no transition is missed
+	events, err := Subscribe(ctx, EventFilter{Link: true})
+	if err != nil {
+		return err
+	}
+
+	if attrs, err := LinkAttrsByName(name); err == nil && attrs.OperState == state {
+		return nil
+	}
+
+	for ev := range events {
+		if ev.Type != EventLinkNew || ev.Link.Name != name {
+			continue
+		}
+
+		if ev.Link.OperState == state {
+			return nil
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("Link %s did not reach %s state: %s", name, state, err)
+	}
+
+	return fmt.Errorf("Event stream closed before link %s reached %s state", name, state)
+}
+
+// groups returns rtnetlink multicast groups bitmask for the filter
+func (f EventFilter) groups() uint32 {
+	var groups uint32
+	if f.Link {
+		groups |= rtmgrpLink
+	}
+	if f.Addr {
+		groups |= rtmgrpIpv4Ifaddr | rtmgrpIpv6Ifaddr
+	}
+	if f.Route {
+		groups |= rtmgrpIpv4Route | rtmgrpIpv6Route
+	}
+	if f.Neigh {
+		groups |= rtmgrpNeigh
+	}
+
+	return groups
+}
+
+// match returns true if the event passes the filter
+func (f EventFilter) match(ev *Event) bool {
+	if ev.Type == EventResync {
+		return true
+	}
+
+	return f.Index == 0 || f.Index == ev.index()
+}
+
+// resync sends EventResync followed by the dump of the current state of all subscribed objects.
+// It returns false if the context was cancelled.
+func (f EventFilter) resync(ctx context.Context, events chan<- Event) bool {
+	if !sendEvent(ctx, events, Event{Type: EventResync}) {
+		return false
+	}
+
+	// dump requests carry family header of the dumped objects
+	type dump struct {
+		msgType uint16
+		header  []byte
+	}
+
+	var dumps []dump
+	if f.Link {
+		dumps = append(dumps, dump{syscall.RTM_GETLINK, encodeIfInfomsg(&syscall.IfInfomsg{Family: syscall.AF_UNSPEC})})
+	}
+	if f.Addr {
+		dumps = append(dumps, dump{syscall.RTM_GETADDR, encodeIfAddrmsg(&syscall.IfAddrmsg{Family: syscall.AF_UNSPEC})})
+	}
+	if f.Route {
+		dumps = append(dumps, dump{syscall.RTM_GETROUTE, encodeRtMsg(&syscall.RtMsg{Family: syscall.AF_UNSPEC})})
+	}
+	if f.Neigh {
+		dumps = append(dumps, dump{syscall.RTM_GETNEIGH, make([]byte, sizeofNdmsg)})
+	}
+
+	for _, d := range dumps {
+		var msgs []syscall.NetlinkMessage
+		if err := execInNetNs(f.Ns, func() error {
+			var err error
+			msgs, err = rtnlDump(ctx, d.msgType, d.header)
+			return err
+		}); err != nil {
+			continue
+		}
+
+		for i := range msgs {
+			ev, err := parseEvent(&msgs[i])
+			if err != nil || ev == nil || !f.match(ev) {
+				continue
+			}
+
+			ev.Resync = true
+			if !sendEvent(ctx, events, *ev) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// sendEvent sends the event to events channel. It returns false if the context was cancelled.
+func sendEvent(ctx context.Context, events chan<- Event, ev Event) bool {
+	select {
+	case events <- ev:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// openEventSocket opens non-blocking netlink socket subscribed to the given multicast groups
+func openEventSocket(groups uint32) (*os.File, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK,
+		syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open netlink socket: %s", err)
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: groups}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("Could not subscribe to netlink groups: %s", err)
+	}
+
+	return os.NewFile(uintptr(fd), "netlink"), nil
+}
+
+// recvEvents reads netlink messages from the event socket into buf
+func recvEvents(sock *os.File, buf []byte) (int, error) {
+	rc, err := sock.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	var recvErr error
+	if err := rc.Read(func(fd uintptr) bool {
+		n, _, recvErr = syscall.Recvfrom(int(fd), buf, 0)
+		return recvErr != syscall.EAGAIN
+	}); err != nil {
+		return 0, err
+	}
+
+	return n, recvErr
+}
+
+// parseEvent decodes rtnetlink message into Event.
+// It returns nil Event if the message type is not supported.
+func parseEvent(m *syscall.NetlinkMessage) (*Event, error) {
+	switch m.Header.Type {
+	case syscall.RTM_NEWLINK, syscall.RTM_DELLINK:
+		attrs, err := parseLinkAttrs(m)
+		if err != nil {
+			return nil, err
+		}
+
+		ev := &Event{Type: EventLinkNew, Link: attrs}
+		if m.Header.Type == syscall.RTM_DELLINK {
+			ev.Type = EventLinkDel
+		}
+
+		return ev, nil
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		addr, err := parseAddrUpdate(m)
+		if err != nil {
+			return nil, err
+		}
+
+		ev := &Event{Type: EventAddrNew, Addr: addr}
+		if m.Header.Type == syscall.RTM_DELADDR {
+			ev.Type = EventAddrDel
+		}
+
+		return ev, nil
+	case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+		route, err := parseRouteUpdate(m)
+		if err != nil {
+			return nil, err
+		}
+
+		ev := &Event{Type: EventRouteNew, Route: route}
+		if m.Header.Type == syscall.RTM_DELROUTE {
+			ev.Type = EventRouteDel
+		}
+
+		return ev, nil
+	case syscall.RTM_NEWNEIGH, syscall.RTM_DELNEIGH:
+		neigh, err := parseNeighUpdate(m)
+		if err != nil {
+			return nil, err
+		}
+
+		ev := &Event{Type: EventNeighNew, Neigh: neigh}
+		if m.Header.Type == syscall.RTM_DELNEIGH {
+			ev.Type = EventNeighDel
+		}
+
+		return ev, nil
+	}
+
+	return nil, nil
+}
+
+// parseAddrUpdate decodes RTM_NEWADDR and RTM_DELADDR netlink messages
+func parseAddrUpdate(m *syscall.NetlinkMessage) (*AddrUpdate, error) {
+	if len(m.Data) < syscall.SizeofIfAddrmsg {
+		return nil, fmt.Errorf("Netlink message too short: %d", len(m.Data))
+	}
+
+	ifam := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+
+	rtas, err := syscall.ParseNetlinkRouteAttr(m)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse address attributes: %s", err)
+	}
+
+	var ip net.IP
+	for _, rta := range rtas {
+		switch rta.Attr.Type {
+		case syscall.IFA_LOCAL:
+			ip = net.IP(append([]byte(nil), rta.Value...))
+		case syscall.IFA_ADDRESS:
+			// IFA_LOCAL takes precedence on point-to-point links
+			if ip == nil {
+				ip = net.IP(append([]byte(nil), rta.Value...))
+			}
+		}
+	}
+
+	update := &AddrUpdate{Index: int(ifam.Index)}
+	if ip != nil {
+		update.IPNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(int(ifam.Prefixlen), 8*len(ip))}
+	}
+
+	return update, nil
+}
+
+// parseRouteUpdate decodes RTM_NEWROUTE and RTM_DELROUTE netlink messages
+func parseRouteUpdate(m *syscall.NetlinkMessage) (*RouteUpdate, error) {
+	if len(m.Data) < syscall.SizeofRtMsg {
+		return nil, fmt.Errorf("Netlink message too short: %d", len(m.Data))
+	}
+
+	rtm := (*syscall.RtMsg)(unsafe.Pointer(&m.Data[0]))
+
+	rtas, err := syscall.ParseNetlinkRouteAttr(m)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse route attributes: %s", err)
+	}
+
+	update := &RouteUpdate{Table: int(rtm.Table)}
+	for _, rta := range rtas {
+		switch rta.Attr.Type {
+		case syscall.RTA_DST:
+			ip := net.IP(append([]byte(nil), rta.Value...))
+			update.Dst = &net.IPNet{IP: ip, Mask: net.CIDRMask(int(rtm.Dst_len), 8*len(ip))}
+		case syscall.RTA_GATEWAY:
+			update.Gw = net.IP(append([]byte(nil), rta.Value...))
+		case syscall.RTA_OIF:
+			update.Index = int(nativeUint32(rta.Value))
+		case syscall.RTA_TABLE:
+			update.Table = int(nativeUint32(rta.Value))
+		}
+	}
+
+	return update, nil
+}
+
+// parseNeighUpdate decodes RTM_NEWNEIGH and RTM_DELNEIGH netlink messages
+func parseNeighUpdate(m *syscall.NetlinkMessage) (*NeighUpdate, error) {
+	if len(m.Data) < sizeofNdmsg {
+		return nil, fmt.Errorf("Netlink message too short: %d", len(m.Data))
+	}
+
+	// struct ndmsg: family, pad1, pad2, ifindex, state, flags, type
+	update := &NeighUpdate{
+		Index: int(int32(nativeEndian.Uint32(m.Data[4:8]))),
+		State: int(nativeEndian.Uint16(m.Data[8:10])),
+	}
+
+	rtas, err := parseRouteAttrs(m.Data[sizeofNdmsg:])
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse neighbour attributes: %s", err)
+	}
+
+	for _, rta := range rtas {
+		switch rta.Attr.Type {
+		case ndaDst:
+			update.IP = net.IP(append([]byte(nil), rta.Value...))
+		case ndaLladdr:
+			update.HardwareAddr = net.HardwareAddr(append([]byte(nil), rta.Value...))
+		}
+	}
+
+	return update, nil
+}
+
+// parseRouteAttrs decodes a stream of rtnetlink attributes.
+// syscall.ParseNetlinkRouteAttr does not support all rtnetlink message types.
+func parseRouteAttrs(b []byte) ([]syscall.NetlinkRouteAttr, error) {
+	var attrs []syscall.NetlinkRouteAttr
+	for len(b) >= syscall.SizeofRtAttr {
+		l := int(nativeEndian.Uint16(b[0:2]))
+		if l < syscall.SizeofRtAttr || l > len(b) {
+			return nil, syscall.EINVAL
+		}
+
+		attrs = append(attrs, syscall.NetlinkRouteAttr{
+			Attr:  syscall.RtAttr{Len: uint16(l), Type: nativeEndian.Uint16(b[2:4])},
+			Value: b[syscall.SizeofRtAttr:l],
+		})
+
+		alen := (l + syscall.RTA_ALIGNTO - 1) & ^(syscall.RTA_ALIGNTO - 1)
+		if alen > len(b) {
+			break
+		}
+		b = b[alen:]
+	}
+
+	return attrs, nil
+}
@@ -0,0 +1,151 @@
+package tenus
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/milosgajdos/tenus/tenustest"
+)
+
+func Test_ParseEventLink(t *testing.T) {
+	ifim := syscall.IfInfomsg{Family: syscall.AF_UNSPEC, Index: 7, Flags: syscall.IFF_UP | syscall.IFF_BROADCAST}
+	data := (*[syscall.SizeofIfInfomsg]byte)(unsafe.Pointer(&ifim))[:]
+
+	hwaddr, _ := net.ParseMAC("22:ce:e0:99:63:6f")
+	data = append(data, encodeRtAttr(syscall.IFLA_IFNAME, []byte("veth01\x00"))...)
+	data = append(data, encodeRtAttr(syscall.IFLA_MTU, encodeUint32(1400))...)
+	data = append(data, encodeRtAttr(syscall.IFLA_ADDRESS, hwaddr)...)
+	data = append(data, encodeRtAttr(syscall.IFLA_MASTER, encodeUint32(3))...)
+	data = append(data, encodeRtAttr(syscall.IFLA_OPERSTATE, []byte{byte(OperUp)})...)
+	data = append(data, encodeRtAttr(iflaLinkNetnsid, encodeUint32(1))...)
+
+	m := &syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{Type: syscall.RTM_DELLINK},
+		Data:   data,
+	}
+
+	ev, err := parseEvent(m)
+	if err != nil {
+		t.Fatalf("parseEvent() failed: %s", err)
+	}
+
+	if ev.Type != EventLinkDel {
+		t.Fatalf("parseEvent() failed: expected %s, returned %s", EventLinkDel, ev.Type)
+	}
+
+	expected := LinkAttrs{
+		Index:        7,
+		Name:         "veth01",
+		Flags:        net.FlagUp | net.FlagBroadcast,
+		OperState:    OperUp,
+		MTU:          1400,
+		HardwareAddr: hwaddr,
+		MasterIndex:  3,
+		NetNsID:      1,
+	}
+
+	if ev.Link.Name != expected.Name || ev.Link.Index != expected.Index || ev.Link.Flags != expected.Flags ||
+		ev.Link.OperState != expected.OperState || ev.Link.MTU != expected.MTU ||
+		ev.Link.HardwareAddr.String() != expected.HardwareAddr.String() ||
+		ev.Link.MasterIndex != expected.MasterIndex || ev.Link.NetNsID != expected.NetNsID {
+		t.Fatalf("parseEvent() failed: expected %+v, returned %+v", expected, *ev.Link)
+	}
+}
+
+func Test_ParseEventNeigh(t *testing.T) {
+	data := make([]byte, sizeofNdmsg)
+	data[0] = syscall.AF_INET
+	nativeEndian.PutUint32(data[4:8], 5)
+	nativeEndian.PutUint16(data[8:10], 0x02)
+
+	hwaddr, _ := net.ParseMAC("26:2e:71:98:60:8f")
+	data = append(data, encodeRtAttr(ndaDst, net.ParseIP("10.0.0.1").To4())...)
+	data = append(data, encodeRtAttr(ndaLladdr, hwaddr)...)
+
+	m := &syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{Type: syscall.RTM_NEWNEIGH},
+		Data:   data,
+	}
+
+	ev, err := parseEvent(m)
+	if err != nil {
+		t.Fatalf("parseEvent() failed: %s", err)
+	}
+
+	if ev.Type != EventNeighNew || ev.Neigh.Index != 5 || ev.Neigh.State != 0x02 ||
+		!ev.Neigh.IP.Equal(net.ParseIP("10.0.0.1")) || ev.Neigh.HardwareAddr.String() != hwaddr.String() {
+		t.Fatalf("parseEvent() failed: returned %s %+v", ev.Type, *ev.Neigh)
+	}
+}
+
+func Test_SubscribeNoGroups(t *testing.T) {
+	if _, err := Subscribe(context.Background(), EventFilter{}); err == nil {
+		t.Fatalf("Subscribe() with empty filter expected to fail")
+	}
+}
+
+func Test_Subscribe(t *testing.T) {
+	tenustest.New(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	events, err := Subscribe(ctx, EventFilter{Link: true})
+	if err != nil {
+		t.Fatalf("Subscribe() failed: %s", err)
+	}
+
+	if _, err := NewBridgeWithName("brevent01"); err != nil {
+		t.Fatalf("NewBridgeWithName() failed to run: %s", err)
+	}
+
+	found := false
+	for ev := range events {
+		if ev.Type == EventLinkNew && ev.Link.Name == "brevent01" {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		t.Fatalf("Subscribe() failed: no %s event received for %s", EventLinkNew, "brevent01")
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func Test_WaitForLinkState(t *testing.T) {
+	ns := tenustest.New(t)
+
+	veth, err := NewVethPairWithOptions("vethwait01", VethOptions{PeerName: "vethwait02"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions(%s) failed to run: %s", "vethwait01", err)
+	}
+
+	if err := veth.SetLinkUp(); err != nil {
+		t.Fatalf("SetLinkUp() failed: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	if err := WaitForLinkState(ctx, "vethwait01", OperUp); err == nil {
+		cancel()
+		t.Fatalf("WaitForLinkState() expected to time out while peer is down")
+	}
+	cancel()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the peer is brought up by goroutine which has to enter the test's network namespace first
+	go execInNetNsPath(ns.Path(), veth.SetPeerLinkUp)
+
+	if err := WaitForLinkState(ctx, "vethwait01", OperUp); err != nil {
+		t.Fatalf("WaitForLinkState() failed: %s", err)
+	}
+}
@@ -0,0 +1,1515 @@
+package tenus
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FakeBackend is in-memory Backend which models network links, masters, addresses, routes, traffic control
+// and network namespaces.
+//
+// FakeBackend lets code using tenus be tested by unprivileged users with deterministic results:
+// link indices are allocated sequentially and MAC addresses are derived from link indices.
+// Like Linux kernel, it rejects invalid requests with syscall.Errno errors.
+// Like the kernel backend, it returns the context's error without changing anything once the context is done.
+// Every network namespace, including the initial one, contains loopback link "lo".
+// Network namespaces of processes can be modelled by creating them with NsCreate at /proc/${PID}/ns/net.
+// Links have no default qdiscs and their qdiscs, classes and filters are removed when they're moved
+// to another network namespace.
+//
+// FakeBackend is safe for concurrent use, but ExecInNs switches the current network namespace
+// of the whole backend rather than of the calling goroutine.
+type FakeBackend struct {
+	mu sync.Mutex
+	// initial network namespace
+	root *fakeNs
+	// current network namespace
+	cur *fakeNs
+	// network namespaces pinned to filesystem paths
+	namespaces map[string]*fakeNs
+	// index of the next created link
+	nextIndex int
+	// ID of the next created network namespace
+	nextNsID int
+}
+
+// fakeNs is a network namespace modelled by FakeBackend
+type fakeNs struct {
+	id     int
+	links  map[int]*fakeLink
+	routes []*Route
+}
+
+// fakeLink is a network link modelled by FakeBackend
+type fakeLink struct {
+	attrs LinkAttrs
+	ns    *fakeNs
+	addrs []*net.IPNet
+	// qdiscs attached to the link. Qdiscs with 0 handle are the kernel's default ones.
+	qdiscs []*Qdisc
+	// classes of the link's qdiscs
+	classes []*Class
+	// filters attached to the link's qdiscs and classes
+	filters []*Filter
+	// veth peer or parent device of vlan, macvlan and macvtap links
+	link *fakeLink
+}
+
+// NewFakeBackend returns FakeBackend whose initial network namespace contains only loopback link.
+func NewFakeBackend() *FakeBackend {
+	f := &FakeBackend{
+		namespaces: make(map[string]*fakeNs),
+		nextIndex:  1,
+	}
+	f.root = f.newNs()
+	f.cur = f.root
+
+	return f
+}
+
+// newNs creates network namespace with loopback link
+func (f *FakeBackend) newNs() *fakeNs {
+	ns := &fakeNs{id: f.nextNsID, links: make(map[int]*fakeLink)}
+	f.nextNsID++
+
+	lo := f.newLink(ns, "lo", "")
+	lo.attrs.Flags = net.FlagLoopback
+	lo.attrs.MTU = 65536
+	lo.attrs.HardwareAddr = make(net.HardwareAddr, 6)
+
+	return ns
+}
+
+// newLink creates link in the network namespace
+func (f *FakeBackend) newLink(ns *fakeNs, name, kind string) *fakeLink {
+	index := f.nextIndex
+	f.nextIndex++
+
+	l := &fakeLink{
+		attrs: LinkAttrs{
+			Index:        index,
+			Name:         name,
+			Kind:         kind,
+			Flags:        net.FlagBroadcast | net.FlagMulticast,
+			MTU:          1500,
+			HardwareAddr: net.HardwareAddr{0x02, 0x42, 0, byte(index >> 16), byte(index >> 8), byte(index)},
+			TxQueueLen:   1000,
+		},
+		ns: ns,
+	}
+	ns.links[index] = l
+
+	return l
+}
+
+// seed replaces links, their addresses and traffic control and routes of the network namespace with existing ones, e.g. read from Linux kernel.
+// Link indices are kept and veth peers and parent devices living in the same network namespace are linked.
+func (f *FakeBackend) seed(ns *fakeNs, state *nsState) {
+	ns.links = make(map[int]*fakeLink)
+	for _, attrs := range state.links {
+		l := &fakeLink{attrs: *attrs, ns: ns}
+		l.attrs.HardwareAddr = append(net.HardwareAddr(nil), attrs.HardwareAddr...)
+		l.attrs.OperState = OperUnknown
+		l.attrs.ParentIndex = 0
+		l.attrs.NetNsID = 0
+		l.attrs.Stats = nil
+
+		for _, a := range state.addrs[attrs.Index] {
+			l.addrs = append(l.addrs, &net.IPNet{IP: append(net.IP(nil), a.IP...), Mask: append(net.IPMask(nil), a.Mask...)})
+		}
+
+		for _, q := range state.qdiscs[attrs.Index] {
+			l.qdiscs = append(l.qdiscs, copyQdisc(q))
+			l.qdiscs[len(l.qdiscs)-1].Stats = nil
+		}
+
+		for _, c := range state.classes[attrs.Index] {
+			l.classes = append(l.classes, copyClass(c))
+			l.classes[len(l.classes)-1].Stats = nil
+		}
+
+		for _, flt := range state.filters[attrs.Index] {
+			l.filters = append(l.filters, copyFilter(flt))
+		}
+
+		ns.links[attrs.Index] = l
+		if attrs.Index >= f.nextIndex {
+			f.nextIndex = attrs.Index + 1
+		}
+	}
+
+	for _, attrs := range state.links {
+		if attrs.ParentIndex == 0 || attrs.ParentIndex == attrs.Index || attrs.NetNsID >= 0 {
+			continue
+		}
+
+		if parent, ok := ns.links[attrs.ParentIndex]; ok {
+			ns.links[attrs.Index].link = parent
+		}
+	}
+
+	ns.routes = nil
+	for _, r := range state.routes {
+		ns.routes = append(ns.routes, copyRoute(r))
+	}
+}
+
+// byName returns link of the given name in the network namespace
+func (ns *fakeNs) byName(name string) *fakeLink {
+	for _, l := range ns.links {
+		if l.attrs.Name == name {
+			return l
+		}
+	}
+
+	return nil
+}
+
+// nsByPath returns network namespace pinned to the path.
+// Paths of the current process' network namespace refer to the initial network namespace.
+func (f *FakeBackend) nsByPath(nspath string) (*fakeNs, error) {
+	if nspath == "/proc/self/ns/net" || nspath == pidNsPath(os.Getpid()) {
+		return f.root, nil
+	}
+
+	ns, ok := f.namespaces[nspath]
+	if !ok {
+		// descriptors of the caller's network namespace refer to the initial network namespace, too
+		if sameNsFile(nspath, threadNetNsPath()) {
+			return f.root, nil
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNsNotFound, nspath)
+	}
+
+	return ns, nil
+}
+
+// sameNsFile reports whether both paths refer to the same namespace
+func sameNsFile(a, b string) bool {
+	var sta, stb syscall.Stat_t
+	if syscall.Stat(a, &sta) != nil || syscall.Stat(b, &stb) != nil {
+		return false
+	}
+
+	return sta.Dev == stb.Dev && sta.Ino == stb.Ino
+}
+
+// link returns link of the given index in the current network namespace
+func (f *FakeBackend) link(index int) (*fakeLink, error) {
+	l, ok := f.cur.links[index]
+	if !ok {
+		return nil, syscall.ENODEV
+	}
+
+	return l, nil
+}
+
+// snapshot returns copy of link's attributes as reported by Linux kernel
+func (f *FakeBackend) snapshot(l *fakeLink) *LinkAttrs {
+	attrs := l.attrs
+	attrs.HardwareAddr = append(net.HardwareAddr(nil), l.attrs.HardwareAddr...)
+	attrs.OperState = l.operState()
+	attrs.NetNsID = -1
+	attrs.Stats = &LinkStats{Time: time.Now()}
+
+	if l.link != nil {
+		attrs.ParentIndex = l.link.attrs.Index
+		if l.link.ns != l.ns {
+			attrs.NetNsID = l.link.ns.id
+		}
+	}
+
+	return &attrs
+}
+
+// operState returns operational state of the link derived from its and its lower devices' state
+func (l *fakeLink) operState() OperState {
+	if l.attrs.Flags&net.FlagUp == 0 {
+		return OperDown
+	}
+
+	switch l.attrs.Kind {
+	case "", "dummy":
+		return OperUnknown
+	case "bridge":
+		for _, port := range l.ns.links {
+			if port.attrs.MasterIndex == l.attrs.Index && port.attrs.Flags&net.FlagUp != 0 {
+				return OperUp
+			}
+		}
+		return OperDown
+	}
+
+	if l.link != nil && l.link.attrs.Flags&net.FlagUp == 0 {
+		return OperLowerLayerDown
+	}
+
+	return OperUp
+}
+
+// LinkAdd creates new network link described by spec in the current network namespace.
+func (f *FakeBackend) LinkAdd(ctx context.Context, spec LinkSpec) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.validName(spec.Name); err != nil {
+		return err
+	}
+
+	switch spec.Kind {
+	case "dummy", "bridge":
+		f.newLink(f.cur, spec.Name, spec.Kind)
+	case "veth":
+		if err := f.validName(spec.PeerName); err != nil {
+			return err
+		}
+		if spec.PeerName == spec.Name {
+			return syscall.EEXIST
+		}
+
+		l := f.newLink(f.cur, spec.Name, "veth")
+		peer := f.newLink(f.cur, spec.PeerName, "veth")
+		l.link, peer.link = peer, l
+		if spec.TxQueueLen > 0 {
+			l.attrs.TxQueueLen = spec.TxQueueLen
+			peer.attrs.TxQueueLen = spec.TxQueueLen
+		}
+	case "vlan", "macvlan", "macvtap":
+		parent, err := f.link(spec.ParentIndex)
+		if err != nil {
+			return err
+		}
+
+		if spec.Kind == "vlan" && (spec.VlanId == 0 || spec.VlanId >= 4095) {
+			return syscall.ERANGE
+		}
+
+		if spec.Kind != "vlan" && !MacVlanModes[spec.MacVlanMode] {
+			return syscall.EINVAL
+		}
+
+		l := f.newLink(f.cur, spec.Name, spec.Kind)
+		l.link = parent
+		l.attrs.MTU = parent.attrs.MTU
+		if spec.Kind == "vlan" {
+			copy(l.attrs.HardwareAddr, parent.attrs.HardwareAddr)
+			l.attrs.VlanId = spec.VlanId
+		} else {
+			l.attrs.MacVlanMode = spec.MacVlanMode
+		}
+	default:
+		return syscall.EOPNOTSUPP
+	}
+
+	for _, name := range []string{spec.Name, spec.PeerName} {
+		if l := f.cur.byName(name); name != "" && l != nil {
+			l.attrs.Alias = spec.Alias
+		}
+	}
+
+	return nil
+}
+
+// validName checks that the link name is valid and not taken in the current network namespace
+func (f *FakeBackend) validName(name string) error {
+	if name == "" || len(name) >= syscall.IFNAMSIZ {
+		return syscall.EINVAL
+	}
+
+	if f.cur.byName(name) != nil {
+		return syscall.EEXIST
+	}
+
+	return nil
+}
+
+// LinkDel deletes network link. Deleting veth link deletes its peer; deleting parent device deletes
+// its vlan, macvlan and macvtap links.
+func (f *FakeBackend) LinkDel(ctx context.Context, index int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return err
+	}
+
+	if l.attrs.Name == "lo" && l.attrs.Kind == "" {
+		return syscall.EOPNOTSUPP
+	}
+
+	f.delete(l)
+
+	return nil
+}
+
+// delete removes the link and everything which depends on it
+func (f *FakeBackend) delete(l *fakeLink) {
+	if _, ok := l.ns.links[l.attrs.Index]; !ok {
+		return
+	}
+
+	delete(l.ns.links, l.attrs.Index)
+	l.ns.routes = removeRoutes(l.ns.routes, l.attrs.Index)
+	f.release(l)
+
+	if l.attrs.Kind == "veth" && l.link != nil {
+		f.delete(l.link)
+	}
+
+	for _, child := range l.ns.links {
+		if child.link == l && child.attrs.Kind != "veth" {
+			f.delete(child)
+		}
+	}
+}
+
+// release frees all the ports of the master link
+func (f *FakeBackend) release(master *fakeLink) {
+	for _, port := range master.ns.links {
+		if port.attrs.MasterIndex == master.attrs.Index {
+			port.attrs.MasterIndex = 0
+		}
+	}
+}
+
+// removeRoutes returns routes which don't go via the link
+func removeRoutes(routes []*Route, index int) []*Route {
+	var kept []*Route
+	for _, r := range routes {
+		if r.Index != index {
+			kept = append(kept, r)
+		}
+	}
+
+	return kept
+}
+
+// LinkByIndex returns attributes of the link with the given index in the current network namespace.
+func (f *FakeBackend) LinkByIndex(ctx context.Context, index int) (*LinkAttrs, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, ok := f.cur.links[index]
+	if !ok {
+		return nil, fmt.Errorf("%w: no link with index %d on the host", ErrLinkNotFound, index)
+	}
+
+	return f.snapshot(l), nil
+}
+
+// LinkByName returns attributes of the link with the given name in the current network namespace.
+func (f *FakeBackend) LinkByName(ctx context.Context, name string) (*LinkAttrs, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l := f.cur.byName(name)
+	if l == nil {
+		return nil, fmt.Errorf("%w: no link %s on the host", ErrLinkNotFound, name)
+	}
+
+	return f.snapshot(l), nil
+}
+
+// LinkList returns attributes of all network links in the current network namespace ordered by index.
+func (f *FakeBackend) LinkList(ctx context.Context) ([]*LinkAttrs, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	links := make([]*LinkAttrs, 0, len(f.cur.links))
+	for _, l := range f.cur.links {
+		links = append(links, f.snapshot(l))
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].Index < links[j].Index
+	})
+
+	return links, nil
+}
+
+// LinkSetName renames the link. Like Linux kernel, it refuses to rename links which are up.
+func (f *FakeBackend) LinkSetName(ctx context.Context, index int, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return err
+	}
+
+	if l.attrs.Name == name {
+		return nil
+	}
+
+	if err := f.validName(name); err != nil {
+		return err
+	}
+
+	if l.attrs.Flags&net.FlagUp != 0 {
+		return syscall.EBUSY
+	}
+
+	l.attrs.Name = name
+
+	return nil
+}
+
+// LinkSetMTU sets the link's MTU.
+func (f *FakeBackend) LinkSetMTU(ctx context.Context, index int, mtu int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return err
+	}
+
+	if mtu < 68 || mtu > 65535 {
+		return syscall.EINVAL
+	}
+
+	l.attrs.MTU = mtu
+
+	return nil
+}
+
+// LinkSetHardwareAddr sets the link's MAC address. Multicast addresses are rejected.
+func (f *FakeBackend) LinkSetHardwareAddr(ctx context.Context, index int, hwaddr net.HardwareAddr) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return err
+	}
+
+	if len(hwaddr) != len(l.attrs.HardwareAddr) {
+		return syscall.EINVAL
+	}
+
+	if hwaddr[0]&0x01 != 0 {
+		return syscall.EADDRNOTAVAIL
+	}
+
+	l.attrs.HardwareAddr = append(net.HardwareAddr(nil), hwaddr...)
+
+	return nil
+}
+
+// LinkSetUp brings the link up.
+func (f *FakeBackend) LinkSetUp(ctx context.Context, index int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return err
+	}
+
+	l.attrs.Flags |= net.FlagUp
+
+	return nil
+}
+
+// LinkSetDown brings the link down.
+func (f *FakeBackend) LinkSetDown(ctx context.Context, index int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return err
+	}
+
+	l.attrs.Flags &^= net.FlagUp
+
+	return nil
+}
+
+// LinkSetMaster enslaves the link to the bridge. masterIndex 0 releases the link from its master.
+func (f *FakeBackend) LinkSetMaster(ctx context.Context, index int, masterIndex int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return err
+	}
+
+	if masterIndex == 0 {
+		l.attrs.MasterIndex = 0
+		return nil
+	}
+
+	master, err := f.link(masterIndex)
+	if err != nil {
+		return err
+	}
+
+	if master == l {
+		return syscall.ELOOP
+	}
+
+	if master.attrs.Kind != "bridge" {
+		return syscall.EOPNOTSUPP
+	}
+
+	l.attrs.MasterIndex = masterIndex
+
+	return nil
+}
+
+// LinkSetNs moves the link to network namespace specified by filesystem path.
+// Like Linux kernel, it brings the link down, flushes its addresses and releases it from its master.
+func (f *FakeBackend) LinkSetNs(ctx context.Context, index int, nspath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return err
+	}
+
+	ns, err := f.nsByPath(nspath)
+	if err != nil {
+		return err
+	}
+
+	if ns == l.ns {
+		return nil
+	}
+
+	if l.attrs.Name == "lo" && l.attrs.Kind == "" {
+		return syscall.EINVAL
+	}
+
+	if ns.byName(l.attrs.Name) != nil {
+		return syscall.EEXIST
+	}
+
+	if _, ok := ns.links[l.attrs.Index]; ok {
+		// the kernel allocates new index if the link's index is taken in the target namespace
+		l.attrs.Index = f.nextIndex
+		f.nextIndex++
+	}
+
+	delete(l.ns.links, index)
+	l.ns.routes = removeRoutes(l.ns.routes, index)
+	f.release(l)
+
+	l.ns = ns
+	ns.links[l.attrs.Index] = l
+	l.attrs.Flags &^= net.FlagUp
+	l.attrs.MasterIndex = 0
+	l.addrs = nil
+	l.qdiscs = nil
+	l.classes = nil
+	l.filters = nil
+
+	return nil
+}
+
+// AddrAdd assigns IP address to the link.
+func (f *FakeBackend) AddrAdd(ctx context.Context, index int, addr *net.IPNet) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return err
+	}
+
+	if addr == nil || addr.IP == nil {
+		return syscall.EINVAL
+	}
+
+	for _, a := range l.addrs {
+		if a.IP.Equal(addr.IP) {
+			return syscall.EEXIST
+		}
+	}
+
+	l.addrs = append(l.addrs, &net.IPNet{IP: append(net.IP(nil), addr.IP...), Mask: append(net.IPMask(nil), addr.Mask...)})
+
+	return nil
+}
+
+// AddrDel removes IP address from the link.
+func (f *FakeBackend) AddrDel(ctx context.Context, index int, addr *net.IPNet) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return err
+	}
+
+	for i, a := range l.addrs {
+		if a.IP.Equal(addr.IP) {
+			l.addrs = append(l.addrs[:i], l.addrs[i+1:]...)
+			return nil
+		}
+	}
+
+	return syscall.EADDRNOTAVAIL
+}
+
+// AddrList returns IP addresses assigned to the link.
+func (f *FakeBackend) AddrList(ctx context.Context, index int) ([]*net.IPNet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]*net.IPNet, len(l.addrs))
+	for i, a := range l.addrs {
+		addrs[i] = &net.IPNet{IP: append(net.IP(nil), a.IP...), Mask: append(net.IPMask(nil), a.Mask...)}
+	}
+
+	return addrs, nil
+}
+
+// RouteAdd adds route to the main routing table of the current network namespace.
+// Like Linux kernel, it requires the gateway to be reachable via an address of the link which is up.
+func (f *FakeBackend) RouteAdd(ctx context.Context, route *Route) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(route.Index)
+	if err != nil {
+		return err
+	}
+
+	if route.Gw != nil {
+		reachable := false
+		for _, a := range l.addrs {
+			if a.Contains(route.Gw) {
+				reachable = true
+			}
+		}
+
+		if !reachable || l.attrs.Flags&net.FlagUp == 0 {
+			return syscall.ENETUNREACH
+		}
+	}
+
+	for _, r := range f.cur.routes {
+		if sameDst(r.Dst, route.Dst) && (r.Dst != nil || isIPv4(r.Gw) == isIPv4(route.Gw)) {
+			return syscall.EEXIST
+		}
+	}
+
+	f.cur.routes = append(f.cur.routes, copyRoute(route))
+
+	return nil
+}
+
+// RouteDel deletes route from the main routing table of the current network namespace.
+// Gateway and link index are only matched if they are set.
+func (f *FakeBackend) RouteDel(ctx context.Context, route *Route) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, r := range f.cur.routes {
+		if !sameDst(r.Dst, route.Dst) {
+			continue
+		}
+		if route.Gw != nil && !route.Gw.Equal(r.Gw) {
+			continue
+		}
+		if route.Index != 0 && route.Index != r.Index {
+			continue
+		}
+
+		f.cur.routes = append(f.cur.routes[:i], f.cur.routes[i+1:]...)
+		return nil
+	}
+
+	return syscall.ESRCH
+}
+
+// RouteList returns routes in the main routing table of the current network namespace.
+func (f *FakeBackend) RouteList(ctx context.Context) ([]*Route, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	routes := make([]*Route, len(f.cur.routes))
+	for i, r := range f.cur.routes {
+		routes[i] = copyRoute(r)
+	}
+
+	return routes, nil
+}
+
+// sameDst returns true if both route destinations are equal
+func sameDst(a, b *net.IPNet) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	return a.String() == b.String()
+}
+
+// isIPv4 returns true if ip is IPv4 address
+func isIPv4(ip net.IP) bool {
+	return ip == nil || ip.To4() != nil
+}
+
+// copyRoute returns deep copy of the route
+func copyRoute(r *Route) *Route {
+	c := &Route{Index: r.Index}
+	if r.Dst != nil {
+		c.Dst = &net.IPNet{IP: append(net.IP(nil), r.Dst.IP...), Mask: append(net.IPMask(nil), r.Dst.Mask...)}
+	}
+	if r.Gw != nil {
+		c.Gw = append(net.IP(nil), r.Gw...)
+	}
+
+	return c
+}
+
+// QdiscAdd attaches the qdisc to the link. Like Linux kernel, it replaces the link's default qdisc
+// and returns syscall.EEXIST if the qdisc's parent or handle is taken.
+func (f *FakeBackend) QdiscAdd(ctx context.Context, index int, q *Qdisc) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return f.addQdisc(index, q, false)
+}
+
+// QdiscReplace attaches the qdisc to the link replacing the qdisc of the same parent together with its
+// child qdiscs, or changes the options of the qdisc with the same handle.
+func (f *FakeBackend) QdiscReplace(ctx context.Context, index int, q *Qdisc) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return f.addQdisc(index, q, true)
+}
+
+// addQdisc attaches the qdisc to the link, replacing the qdisc of the same parent or handle if replace is set
+func (f *FakeBackend) addQdisc(index int, q *Qdisc, replace bool) error {
+	// the qdisc is validated the same way as by the kernel backend
+	if _, err := q.encode(index); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return err
+	}
+
+	q = copyQdisc(q)
+	q.Handle, q.Parent, q.Stats = q.handle(), q.parent(), nil
+
+	if q.ingress() != (q.Parent == HandleIngress) {
+		return syscall.EINVAL
+	}
+
+	if q.Parent != HandleRoot && q.Parent != HandleIngress && l.class(q.Parent) == nil {
+		return syscall.ENOENT
+	}
+
+	if old := l.qdisc(q.Handle); q.Handle != 0 && old != nil {
+		if !replace {
+			return syscall.EEXIST
+		}
+
+		if old.Kind != q.Kind || old.Parent != q.Parent {
+			return syscall.EINVAL
+		}
+
+		*old = *q
+
+		return nil
+	}
+
+	for _, old := range l.qdiscs {
+		if old.Parent != q.Parent {
+			continue
+		}
+
+		if old.Handle != 0 && !replace {
+			return syscall.EEXIST
+		}
+
+		l.delQdisc(old)
+		break
+	}
+
+	if q.Handle == 0 {
+		// the kernel allocates handles starting at 8001:
+		q.Handle = MakeHandle(0x8001, 0)
+		for l.qdisc(q.Handle) != nil {
+			q.Handle += MakeHandle(1, 0)
+		}
+	}
+
+	l.qdiscs = append(l.qdiscs, q)
+
+	return nil
+}
+
+// QdiscDel detaches the qdisc of the given parent and handle from the link together with its child qdiscs.
+// It returns syscall.ENOENT if there is no such qdisc or if it's the link's default qdisc.
+func (f *FakeBackend) QdiscDel(ctx context.Context, index int, q *Qdisc) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return err
+	}
+
+	handle, parent := q.handle(), q.parent()
+	for _, old := range l.qdiscs {
+		if old.Parent != parent || old.Handle == 0 || (handle != 0 && old.Handle != handle) {
+			continue
+		}
+
+		l.delQdisc(old)
+
+		return nil
+	}
+
+	return syscall.ENOENT
+}
+
+// QdiscList returns qdiscs attached to the link.
+func (f *FakeBackend) QdiscList(ctx context.Context, index int) ([]*Qdisc, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return nil, err
+	}
+
+	qdiscs := make([]*Qdisc, len(l.qdiscs))
+	for i, q := range l.qdiscs {
+		qdiscs[i] = copyQdisc(q)
+		qdiscs[i].Stats = &QdiscStats{}
+	}
+
+	return qdiscs, nil
+}
+
+// qdisc returns the link's qdisc with the given handle
+func (l *fakeLink) qdisc(handle uint32) *Qdisc {
+	for _, q := range l.qdiscs {
+		if q.Handle == handle {
+			return q
+		}
+	}
+
+	return nil
+}
+
+// delQdisc removes the qdisc together with its classes and filters and the qdiscs attached to its classes from the link
+func (l *fakeLink) delQdisc(q *Qdisc) {
+	var kept []*Qdisc
+	for _, c := range l.qdiscs {
+		if c != q {
+			kept = append(kept, c)
+		}
+	}
+	l.qdiscs = kept
+
+	if q.Handle == 0 {
+		return
+	}
+
+	var classes []*Class
+	for _, c := range l.classes {
+		if c.Handle&0xffff0000 != q.Handle {
+			classes = append(classes, c)
+		}
+	}
+	l.classes = classes
+
+	var filters []*Filter
+	for _, flt := range l.filters {
+		if flt.Parent&0xffff0000 != q.Handle {
+			filters = append(filters, flt)
+		}
+	}
+	l.filters = filters
+
+	for _, c := range kept {
+		if c.Parent&0xffff0000 == q.Handle && c.Parent != HandleRoot && c.Parent != HandleIngress {
+			l.delQdisc(c)
+		}
+	}
+}
+
+// ClassAdd adds the class to the link's classful qdisc. Like Linux kernel, it returns syscall.ENOENT
+// if the class's qdisc or parent class doesn't exist and syscall.EEXIST if the class already exists.
+func (f *FakeBackend) ClassAdd(ctx context.Context, index int, c *Class) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return f.addClass(index, c, false)
+}
+
+// ClassReplace adds the class to the link's classful qdisc or changes its options if it already exists.
+func (f *FakeBackend) ClassReplace(ctx context.Context, index int, c *Class) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return f.addClass(index, c, true)
+}
+
+// addClass adds the class to the link's qdisc, changing the options of the existing class if replace is set
+func (f *FakeBackend) addClass(index int, c *Class, replace bool) error {
+	// the class is validated the same way as by the kernel backend
+	if _, err := c.encode(index); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return err
+	}
+
+	q := l.qdisc(c.Handle & 0xffff0000)
+	if q == nil || (c.Parent != q.Handle && l.class(c.Parent) == nil) {
+		return syscall.ENOENT
+	}
+
+	if q.Kind != c.Kind || c.Parent&0xffff0000 != q.Handle {
+		return syscall.EINVAL
+	}
+
+	c = copyClass(c)
+	c.Stats = nil
+
+	if old := l.class(c.Handle); old != nil {
+		if !replace {
+			return syscall.EEXIST
+		}
+
+		if old.Parent != c.Parent {
+			return syscall.EINVAL
+		}
+
+		*old = *c
+
+		return nil
+	}
+
+	l.classes = append(l.classes, c)
+
+	return nil
+}
+
+// ClassDel deletes the link's class together with the qdisc attached to it. Like Linux kernel, it returns
+// syscall.EBUSY if the class has child classes or filters attached to it or sending packets to it.
+func (f *FakeBackend) ClassDel(ctx context.Context, index int, c *Class) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return err
+	}
+
+	old := l.class(c.Handle)
+	if old == nil || (c.Parent != 0 && c.Parent != old.Parent) {
+		return syscall.ENOENT
+	}
+
+	for _, child := range l.classes {
+		if child.Parent == old.Handle {
+			return syscall.EBUSY
+		}
+	}
+
+	for _, flt := range l.filters {
+		if flt.Parent == old.Handle || flt.ClassID == old.Handle {
+			return syscall.EBUSY
+		}
+	}
+
+	for _, q := range l.qdiscs {
+		if q.Parent == old.Handle {
+			l.delQdisc(q)
+			break
+		}
+	}
+
+	var kept []*Class
+	for _, other := range l.classes {
+		if other != old {
+			kept = append(kept, other)
+		}
+	}
+	l.classes = kept
+
+	return nil
+}
+
+// ClassList returns classes of all qdiscs attached to the link.
+func (f *FakeBackend) ClassList(ctx context.Context, index int) ([]*Class, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return nil, err
+	}
+
+	classes := make([]*Class, len(l.classes))
+	for i, c := range l.classes {
+		classes[i] = copyClass(c)
+		classes[i].Stats = &QdiscStats{}
+	}
+
+	return classes, nil
+}
+
+// class returns the link's class with the given handle
+func (l *fakeLink) class(handle uint32) *Class {
+	for _, c := range l.classes {
+		if c.Handle == handle {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// FilterAdd attaches the filter to the link's qdisc or class. Like Linux kernel, it chooses the filter's
+// priority and handle if they're 0 and returns syscall.EEXIST if the filter already exists.
+// Filters whose Protocol is 0 are returned with syscall.ETH_P_ALL protocol.
+func (f *FakeBackend) FilterAdd(ctx context.Context, index int, flt *Filter) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return f.addFilter(ctx, index, flt, false)
+}
+
+// FilterReplace attaches the filter to the link's qdisc or class or changes the filter with the same handle.
+func (f *FakeBackend) FilterReplace(ctx context.Context, index int, flt *Filter) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return f.addFilter(ctx, index, flt, true)
+}
+
+// addFilter attaches the filter to the link, changing the filter with the same handle if replace is set
+func (f *FakeBackend) addFilter(ctx context.Context, index int, flt *Filter, replace bool) error {
+	// the filter is validated the same way as by the kernel backend. Links of mirred actions are
+	// looked up before the backend is locked.
+	if _, err := flt.encode(ctx, f, index); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return err
+	}
+
+	parent, err := l.filterParent(flt.Parent)
+	if err != nil {
+		return err
+	}
+
+	flt = copyFilter(flt)
+	flt.Parent = parent
+	if flt.Protocol == 0 {
+		flt.Protocol = syscall.ETH_P_ALL
+	}
+
+	if flt.Priority == 0 {
+		// the kernel uses priority lower than any of the existing filters
+		flt.Priority = 0xc000
+		for i, other := range l.parentFilters(parent) {
+			if i == 0 || other.Priority <= flt.Priority {
+				flt.Priority = other.Priority - 1
+			}
+		}
+	}
+
+	for _, other := range l.parentFilters(parent) {
+		if other.Priority != flt.Priority {
+			continue
+		}
+
+		if other.Kind != flt.Kind || other.Protocol != flt.Protocol {
+			return syscall.EINVAL
+		}
+
+		if flt.Handle != 0 && other.Handle == flt.Handle {
+			if !replace {
+				return syscall.EEXIST
+			}
+
+			*other = *flt
+
+			return nil
+		}
+	}
+
+	if flt.Handle == 0 {
+		// the kernel allocates u32 handles from node 800 of the priority's hash table and handles of
+		// other kinds from 1
+		flt.Handle = 1
+		if flt.Kind == "u32" {
+			flt.Handle = l.u32Table(parent, flt.Priority) | 0x800
+		}
+
+		for l.filter(parent, flt.Priority, flt.Handle) != nil {
+			flt.Handle++
+		}
+	}
+
+	l.filters = append(l.filters, flt)
+
+	return nil
+}
+
+// FilterDel detaches the filter of the given parent, priority and handle from the link. 0 handle deletes
+// all filters of the priority and 0 priority deletes all filters of the parent.
+// It returns syscall.ENOENT if there are no such filters.
+func (f *FakeBackend) FilterDel(ctx context.Context, index int, flt *Filter) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return err
+	}
+
+	parent, err := l.filterParent(flt.Parent)
+	if err != nil {
+		return err
+	}
+
+	protocol := flt.Protocol
+	if protocol == 0 {
+		protocol = syscall.ETH_P_ALL
+	}
+
+	var kept []*Filter
+	for _, other := range l.filters {
+		if other.Parent != parent || (flt.Priority != 0 && other.Priority != flt.Priority) ||
+			(flt.Priority != 0 && flt.Handle != 0 && other.Handle != flt.Handle) {
+			kept = append(kept, other)
+			continue
+		}
+
+		if flt.Priority != 0 && (other.Protocol != protocol || (flt.Kind != "" && other.Kind != flt.Kind)) {
+			return syscall.EINVAL
+		}
+	}
+
+	if len(kept) == len(l.filters) {
+		return syscall.ENOENT
+	}
+	l.filters = kept
+
+	return nil
+}
+
+// FilterList returns filters attached to the link's qdisc or class with the given parent handle.
+func (f *FakeBackend) FilterList(ctx context.Context, index int, parent uint32) ([]*Filter, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.link(index)
+	if err != nil {
+		return nil, err
+	}
+
+	if parent, err = l.filterParent(parent); err != nil {
+		return nil, err
+	}
+
+	var filters []*Filter
+	for _, flt := range l.parentFilters(parent) {
+		filters = append(filters, copyFilter(flt))
+	}
+
+	return filters, nil
+}
+
+// filterParent returns handle of the link's qdisc or class filters with the given parent are attached to.
+// 0 parent refers to the root qdisc.
+func (l *fakeLink) filterParent(parent uint32) (uint32, error) {
+	var q *Qdisc
+	switch parent {
+	case 0:
+		for _, root := range l.qdiscs {
+			if root.Parent == HandleRoot && root.Handle != 0 {
+				q, parent = root, root.Handle
+			}
+		}
+	case HandleMinIngress, HandleMinEgress:
+		if q = l.qdisc(MakeHandle(0xffff, 0)); q != nil && q.Kind != "clsact" {
+			return 0, syscall.EINVAL
+		}
+	default:
+		if q = l.qdisc(parent & 0xffff0000); q != nil && parent&0xffff != 0 && l.class(parent) == nil {
+			return 0, syscall.ENOENT
+		}
+	}
+
+	if q == nil {
+		return 0, syscall.ENOENT
+	}
+
+	switch q.Kind {
+	case "htb", "prio", "ingress", "clsact":
+		return parent, nil
+	}
+
+	// classless qdiscs don't have filters
+	return 0, syscall.EOPNOTSUPP
+}
+
+// parentFilters returns filters attached to the link's qdisc or class with the given handle
+func (l *fakeLink) parentFilters(parent uint32) []*Filter {
+	var filters []*Filter
+	for _, flt := range l.filters {
+		if flt.Parent == parent {
+			filters = append(filters, flt)
+		}
+	}
+
+	return filters
+}
+
+// u32Table returns handle of the hash table of u32 filters with the given parent and priority. Like Linux
+// kernel, it allocates a new hash table from 800: for every u32 filter priority of the qdisc.
+func (l *fakeLink) u32Table(parent uint32, priority uint16) uint32 {
+	tables := make(map[uint32]bool)
+	for _, flt := range l.filters {
+		if flt.Kind != "u32" || flt.Parent&0xffff0000 != parent&0xffff0000 {
+			continue
+		}
+
+		if flt.Parent == parent && flt.Priority == priority {
+			return flt.Handle & 0xfff00000
+		}
+		tables[flt.Handle&0xfff00000] = true
+	}
+
+	table := uint32(0x800) << 20
+	for tables[table] {
+		table += 1 << 20
+	}
+
+	return table
+}
+
+// filter returns the link's filter of the given parent, priority and handle
+func (l *fakeLink) filter(parent uint32, priority uint16, handle uint32) *Filter {
+	for _, flt := range l.filters {
+		if flt.Parent == parent && flt.Priority == priority && flt.Handle == handle {
+			return flt
+		}
+	}
+
+	return nil
+}
+
+// NsCreate creates new network namespace pinned to the filesystem path.
+func (f *FakeBackend) NsCreate(nspath string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.namespaces[nspath]; ok {
+		return fmt.Errorf("Could not create network namespace %s: %w", nspath, syscall.EEXIST)
+	}
+
+	f.namespaces[nspath] = f.newNs()
+
+	return nil
+}
+
+// NsDelete unpins network namespace from the filesystem path and destroys it together with its links.
+// Veth links whose peers live in the destroyed network namespace are deleted, too.
+func (f *FakeBackend) NsDelete(nspath string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ns, ok := f.namespaces[nspath]
+	if !ok {
+		return nil
+	}
+	delete(f.namespaces, nspath)
+
+	for _, l := range ns.links {
+		f.delete(l)
+	}
+
+	return nil
+}
+
+// NsExists returns true if network namespace is pinned to the filesystem path.
+func (f *FakeBackend) NsExists(nspath string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, err := f.nsByPath(nspath)
+
+	return err == nil
+}
+
+// NsList returns filesystem paths of network namespaces pinned in NetNsRunDir.
+func (f *FakeBackend) NsList() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var nspaths []string
+	for nspath := range f.namespaces {
+		if filepath.Dir(nspath) == filepath.Clean(NetNsRunDir) {
+			nspaths = append(nspaths, nspath)
+		}
+	}
+	sort.Strings(nspaths)
+
+	return nspaths, nil
+}
+
+// ExecInNs runs fn with the backend switched to network namespace specified by filesystem path.
+func (f *FakeBackend) ExecInNs(nspath string, fn func() error) error {
+	f.mu.Lock()
+	ns, err := f.nsByPath(nspath)
+	if err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	prev := f.cur
+	f.cur = ns
+	f.mu.Unlock()
+
+	fnErr := fn()
+
+	f.mu.Lock()
+	f.cur = prev
+	f.mu.Unlock()
+
+	return fnErr
+}
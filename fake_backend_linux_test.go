@@ -0,0 +1,220 @@
+package tenus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func Test_FakeBackendVethPair(t *testing.T) {
+	fake := NewFakeBackend()
+	defer SetBackend(SetBackend(fake))
+
+	veth, err := NewVethPairWithOptions("vethfake01", VethOptions{PeerName: "vethfake02"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	if err := veth.SetLinkMTU(1400); err != nil {
+		t.Fatalf("SetLinkMTU() failed: %s", err)
+	}
+
+	if err := veth.SetLinkUp(); err != nil {
+		t.Fatalf("SetLinkUp() failed: %s", err)
+	}
+
+	attrs, err := veth.Attrs()
+	if err != nil {
+		t.Fatalf("Attrs() failed: %s", err)
+	}
+
+	if attrs.MTU != 1400 || attrs.OperState != OperLowerLayerDown || attrs.ParentIndex != veth.PeerNetInterface().Index {
+		t.Fatalf("Attrs() failed: returned %+v", attrs)
+	}
+
+	if _, err := NewVethPairWithOptions("vethfake01", VethOptions{PeerName: "vethfake03"}); !errors.Is(err, ErrLinkExists) {
+		t.Fatalf("NewVethPairWithOptions() failed: expected %v, returned %v", ErrLinkExists, err)
+	}
+
+	if err := fake.NsCreate(NetNsPath("nsfake01")); err != nil {
+		t.Fatalf("NsCreate() failed: %s", err)
+	}
+
+	if err := veth.SetPeerLinkNsFd(NetNsPath("nsfake01")); err != nil {
+		t.Fatalf("SetPeerLinkNsFd() failed: %s", err)
+	}
+
+	if _, err := LinkAttrsByName("vethfake02"); !errors.Is(err, ErrLinkNotFound) {
+		t.Fatalf("LinkAttrsByName() failed: expected %v, returned %v", ErrLinkNotFound, err)
+	}
+
+	err = ExecInNamedNetNs("nsfake01", func() error {
+		_, err := LinkAttrsByName("vethfake02")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ExecInNamedNetNs() failed: %s", err)
+	}
+
+	if err := veth.DeleteLink(); err != nil {
+		t.Fatalf("DeleteLink() failed: %s", err)
+	}
+
+	err = ExecInNamedNetNs("nsfake01", func() error {
+		_, err := LinkAttrsByName("vethfake02")
+		return err
+	})
+	if !errors.Is(err, ErrLinkNotFound) {
+		t.Fatalf("DeleteLink() failed to delete peer: returned %v", err)
+	}
+}
+
+func Test_FakeBackendErrors(t *testing.T) {
+	fake := NewFakeBackend()
+	defer SetBackend(SetBackend(fake))
+
+	br, err := NewBridgeWithName("brfake01")
+	if err != nil {
+		t.Fatalf("NewBridgeWithName() failed: %s", err)
+	}
+
+	ip, network, _ := net.ParseCIDR("10.20.0.1/24")
+	if err := br.SetLinkIp(ip, network); err != nil {
+		t.Fatalf("SetLinkIp() failed: %s", err)
+	}
+
+	if err := br.SetLinkIp(ip, network); !errors.Is(err, ErrLinkExists) {
+		t.Fatalf("SetLinkIp() failed: expected %v, returned %v", ErrLinkExists, err)
+	}
+
+	gw := net.ParseIP("10.20.0.254")
+	if err := br.SetLinkDefaultGw(&gw); err == nil {
+		t.Fatalf("SetLinkDefaultGw() via link which is down expected to fail")
+	}
+
+	if err := br.SetLinkUp(); err != nil {
+		t.Fatalf("SetLinkUp() failed: %s", err)
+	}
+
+	if err := br.SetLinkDefaultGw(&gw); err != nil {
+		t.Fatalf("SetLinkDefaultGw() failed: %s", err)
+	}
+
+	if err := RenameInterfaceByName("brfake01", "brfake02"); err == nil {
+		t.Fatalf("RenameInterfaceByName() of link which is up expected to fail")
+	}
+
+	if err := br.SetLinkMacAddress("01:00:5e:00:00:01"); err == nil {
+		t.Fatalf("SetLinkMacAddress() with multicast address expected to fail")
+	}
+
+	if err := DelDefaultGw(gw, "brfake01"); err != nil {
+		t.Fatalf("DelDefaultGw() failed: %s", err)
+	}
+
+	if err := DeleteLink("brfake01"); err != nil {
+		t.Fatalf("DeleteLink() failed: %s", err)
+	}
+
+	if err := br.SetLinkUp(); !errors.Is(err, ErrLinkNotFound) {
+		t.Fatalf("SetLinkUp() of deleted link failed: expected %v, returned %v", ErrLinkNotFound, err)
+	}
+}
+
+func Test_FakeBackendTxRollback(t *testing.T) {
+	fake := NewFakeBackend()
+	defer SetBackend(SetBackend(fake))
+
+	tx := NewTx().CreateLink("dummyfake01", func() error {
+		return fake.LinkAdd(context.Background(), LinkSpec{Name: "dummyfake01", Kind: "dummy"})
+	}).SetLinkMTU("dummyfake01", 1400).SetLinkMacAddress("dummyfake01", "01:00:5e:00:00:01")
+
+	var txErr *TxError
+	if err := tx.Commit(); !errors.As(err, &txErr) || len(txErr.RollbackErrs) != 0 {
+		t.Fatalf("Commit() failed: expected *TxError without rollback errors, returned %v", err)
+	}
+
+	links, err := fake.LinkList(context.Background())
+	if err != nil {
+		t.Fatalf("LinkList() failed: %s", err)
+	}
+
+	if len(links) != 1 || links[0].Name != "lo" {
+		t.Fatalf("Commit() failed to roll back: links %+v", links)
+	}
+}
+
+func Test_FakeBackendContext(t *testing.T) {
+	fake := NewFakeBackend()
+	defer SetBackend(SetBackend(fake))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := fake.LinkAdd(ctx, LinkSpec{Name: "brfake01", Kind: "bridge"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("LinkAdd() failed: expected %v, returned %v", context.Canceled, err)
+	}
+
+	if _, err := fake.LinkList(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("LinkList() failed: expected %v, returned %v", context.Canceled, err)
+	}
+
+	if _, err := NewVethPairWithOptionsContext(ctx, "vethfake01", VethOptions{PeerName: "vethfake02"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("NewVethPairWithOptionsContext() failed: expected %v, returned %v", context.Canceled, err)
+	}
+
+	veth, err := NewVethPairWithOptions("vethfake01", VethOptions{PeerName: "vethfake02"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	if err := veth.SetLinkMTUContext(ctx, 1400); !errors.Is(err, context.Canceled) {
+		t.Fatalf("SetLinkMTUContext() failed: expected %v, returned %v", context.Canceled, err)
+	}
+
+	links, err := fake.LinkList(context.Background())
+	if err != nil {
+		t.Fatalf("LinkList() failed: %s", err)
+	}
+
+	if len(links) != 3 {
+		t.Fatalf("LinkList() failed: expected lo and veth pair, returned %+v", links)
+	}
+
+	for _, attrs := range links {
+		if attrs.MTU == 1400 {
+			t.Fatalf("SetLinkMTUContext() failed: MTU of %s changed after cancellation", attrs.Name)
+		}
+	}
+}
+
+func Test_FakeBackendTopology(t *testing.T) {
+	defer SetBackend(SetBackend(NewFakeBackend()))
+
+	topo, err := ParseTopology([]byte(topologyYAML))
+	if err != nil {
+		t.Fatalf("ParseTopology() failed: %s", err)
+	}
+
+	if err := topo.Apply(); err != nil {
+		t.Fatalf("Apply() failed: %s", err)
+	}
+
+	steps, err := topo.Plan()
+	if err != nil {
+		t.Fatalf("Plan() failed: %s", err)
+	}
+
+	if len(steps) != 0 {
+		t.Fatalf("Plan() after Apply() failed: expected no steps, returned %v", steps)
+	}
+
+	if err := topo.Destroy(); err != nil {
+		t.Fatalf("Destroy() failed: %s", err)
+	}
+
+	if NamedNetNsExists("tnsa01") {
+		t.Fatalf("Destroy() failed to delete network namespace tnsa01")
+	}
+}
@@ -0,0 +1,591 @@
+package tenus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// classifier attributes which are not exported by syscall package
+const (
+	tcaU32Classid           = 1
+	tcaU32Sel               = 5
+	tcaU32Act               = 7
+	tcaMatchallClassid      = 1
+	tcaMatchallAct          = 2
+	tcaFlowerClassid        = 1
+	tcaFlowerAct            = 3
+	tcaFlowerKeyEthDst      = 4
+	tcaFlowerKeyEthSrc      = 6
+	tcaFlowerKeyEthType     = 8
+	tcaFlowerKeyIpProto     = 9
+	tcaFlowerKeyIpv4Src     = 10
+	tcaFlowerKeyIpv4SrcMask = 11
+	tcaFlowerKeyIpv4Dst     = 12
+	tcaFlowerKeyIpv4DstMask = 13
+	tcaFlowerKeyIpv6Src     = 14
+	tcaFlowerKeyIpv6SrcMask = 15
+	tcaFlowerKeyIpv6Dst     = 16
+	tcaFlowerKeyIpv6DstMask = 17
+	tcaFlowerKeyTcpSrc      = 18
+	tcaFlowerKeyTcpDst      = 19
+	tcaFlowerKeyUdpSrc      = 20
+	tcaFlowerKeyUdpDst      = 21
+	tcaFlowerKeyVlanId      = 23
+	tcaFlowerKeyVlanEthType = 25
+	tcU32Terminal           = 1
+)
+
+// sizes of u32 selector structures
+const (
+	sizeofU32Sel = 16
+	sizeofU32Key = 16
+)
+
+// u32 hash table handles have zero node ID
+const u32NodeMask = 0xfff
+
+// filterKinds are classifier kinds which can be attached to links by tenus
+var filterKinds = map[string]bool{
+	"u32":      true,
+	"flower":   true,
+	"matchall": true,
+}
+
+// filterAttrs are class ID and action attributes of each classifier kind
+var filterAttrs = map[string]struct{ classID, act uint16 }{
+	"u32":      {tcaU32Classid, tcaU32Act},
+	"flower":   {tcaFlowerClassid, tcaFlowerAct},
+	"matchall": {tcaMatchallClassid, tcaMatchallAct},
+}
+
+// Filter classifies packets processed by a qdisc. Matched packets are sent to the class ClassID
+// and the Actions are performed on them. Only the options field which matches the filter Kind is set.
+type Filter struct {
+	// Classifier kind: u32, flower or matchall
+	Kind string
+	// Parent handle: handle of the qdisc or class, MakeHandle(0xffff, 0) for ingress qdisc or
+	// HandleMinIngress and HandleMinEgress for clsact qdisc. 0 attaches the filter to the root qdisc.
+	Parent uint32
+	// Filter handle. 0 lets the kernel choose the handle.
+	Handle uint32
+	// Filters with lower priority are matched first. 0 lets the kernel choose the priority.
+	Priority uint16
+	// Ethernet protocol of the matched packets in host byte order, i.e. syscall.ETH_P_IP.
+	// 0 matches packets of all protocols.
+	Protocol uint16
+	// Handle of the class the matched packets are sent to. 0 doesn't classify the packets.
+	ClassID uint32
+	// Options of u32 filter. nil matches all packets.
+	U32 *U32Options
+	// Options of flower filter. nil matches all packets of the filter's Protocol.
+	Flower *FlowerOptions
+	// Actions performed on the matched packets
+	Actions []*Action
+}
+
+// U32Options matches packets whose 32-bit words at the given offsets match all the Keys.
+type U32Options struct {
+	// Keys matched by the filter. No keys match all packets.
+	Keys []U32Key
+}
+
+// U32Key matches 32-bit word of the packet at the offset from the start of the network header.
+// Val and Mask are values of the word in network byte order, i.e. 0x0a000001 matches IP address 10.0.0.1.
+type U32Key struct {
+	// Value of the word
+	Val uint32
+	// Mask of the bits of the word which are matched
+	Mask uint32
+	// Offset of the word from the start of the network header
+	Off int32
+}
+
+// FlowerOptions matches packets by the values of their headers. Zero values don't match the header.
+type FlowerOptions struct {
+	// Source MAC address
+	SrcMac net.HardwareAddr
+	// Destination MAC address
+	DstMac net.HardwareAddr
+	// VLAN ID of the outer VLAN tag. Filter Protocol must be syscall.ETH_P_8021Q.
+	VlanId uint16
+	// Ethernet protocol of VLAN tagged packets in host byte order. It's required to match IP headers of tagged packets.
+	VlanEthType uint16
+	// IP protocol, i.e. syscall.IPPROTO_TCP. It's required to match ports.
+	IPProto uint8
+	// Source IP network
+	SrcIP *net.IPNet
+	// Destination IP network
+	DstIP *net.IPNet
+	// Source TCP or UDP port
+	SrcPort uint16
+	// Destination TCP or UDP port
+	DstPort uint16
+}
+
+// U32SrcIP returns u32 keys which match source IP address of IPv4 or IPv6 packets in the network
+func U32SrcIP(network *net.IPNet) []U32Key {
+	if network.IP.To4() != nil {
+		return u32IPKeys(network, 12)
+	}
+
+	return u32IPKeys(network, 8)
+}
+
+// U32DstIP returns u32 keys which match destination IP address of IPv4 or IPv6 packets in the network
+func U32DstIP(network *net.IPNet) []U32Key {
+	if network.IP.To4() != nil {
+		return u32IPKeys(network, 16)
+	}
+
+	return u32IPKeys(network, 24)
+}
+
+// U32IPProto returns u32 key which matches IP protocol of IPv4 packets
+func U32IPProto(proto uint8) U32Key {
+	return U32Key{Val: uint32(proto) << 16, Mask: 0x00ff0000, Off: 8}
+}
+
+// U32SrcPort returns u32 key which matches TCP or UDP source port of IPv4 packets without IP options
+func U32SrcPort(port uint16) U32Key {
+	return U32Key{Val: uint32(port) << 16, Mask: 0xffff0000, Off: 20}
+}
+
+// U32DstPort returns u32 key which matches TCP or UDP destination port of IPv4 packets without IP options
+func U32DstPort(port uint16) U32Key {
+	return U32Key{Val: uint32(port), Mask: 0x0000ffff, Off: 20}
+}
+
+// u32IPKeys returns u32 keys which match IP address at the given offset. Words the mask doesn't cover are skipped.
+func u32IPKeys(network *net.IPNet, off int32) []U32Key {
+	ip, mask := network.IP.To4(), network.Mask
+	if ip == nil || len(mask) != net.IPv4len {
+		ip, mask = network.IP.To16(), net.IPMask(net.IP(mask).To16())
+	}
+
+	var keys []U32Key
+	for i := 0; i < len(ip); i += 4 {
+		m := binary.BigEndian.Uint32(mask[i : i+4])
+		if m == 0 {
+			continue
+		}
+		keys = append(keys, U32Key{Val: binary.BigEndian.Uint32(ip[i:i+4]) & m, Mask: m, Off: off + int32(i)})
+	}
+
+	return keys
+}
+
+// AddFilter attaches the filter to the link's qdisc or class. It fails if the filter already exists.
+// It is equivalent of running: tc filter add dev ${link} parent ${parent} handle ${handle} prio ${priority} protocol ${protocol} ${kind} ${options}
+func (l *Link) AddFilter(f *Filter) error {
+	return l.AddFilterContext(context.Background(), f)
+}
+
+// AddFilterContext is like AddFilter but it returns without changing the link if the context is done.
+func (l *Link) AddFilterContext(ctx context.Context, f *Filter) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("add filter", l.ifc.Name, err)
+	}
+
+	return newLinkError("add filter", l.ifc.Name, backend.FilterAdd(ctx, l.ifc.Index, f))
+}
+
+// ReplaceFilter attaches the filter to the link's qdisc or class or changes the filter with the same handle.
+// Filters with 0 handle are always added.
+// It is equivalent of running: tc filter replace dev ${link} parent ${parent} handle ${handle} prio ${priority} protocol ${protocol} ${kind} ${options}
+func (l *Link) ReplaceFilter(f *Filter) error {
+	return l.ReplaceFilterContext(context.Background(), f)
+}
+
+// ReplaceFilterContext is like ReplaceFilter but it returns without changing the link if the context is done.
+func (l *Link) ReplaceFilterContext(ctx context.Context, f *Filter) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("replace filter", l.ifc.Name, err)
+	}
+
+	return newLinkError("replace filter", l.ifc.Name, backend.FilterReplace(ctx, l.ifc.Index, f))
+}
+
+// DelFilter detaches the filter of the given parent, priority, protocol and handle from the link.
+// Options and actions of the filter are ignored. 0 handle deletes all filters of the priority and
+// 0 priority deletes all filters of the parent.
+// It is equivalent of running: tc filter del dev ${link} parent ${parent} handle ${handle} prio ${priority} protocol ${protocol} ${kind}
+func (l *Link) DelFilter(f *Filter) error {
+	return l.DelFilterContext(context.Background(), f)
+}
+
+// DelFilterContext is like DelFilter but it returns without changing the link if the context is done.
+func (l *Link) DelFilterContext(ctx context.Context, f *Filter) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("del filter", l.ifc.Name, err)
+	}
+
+	return newLinkError("del filter", l.ifc.Name, backend.FilterDel(ctx, l.ifc.Index, f))
+}
+
+// Filters returns filters attached to the link's qdisc or class with the given parent handle.
+// u32 hash tables are not returned.
+// It is equivalent of running: tc filter show dev ${link} parent ${parent}
+func (l *Link) Filters(parent uint32) ([]*Filter, error) {
+	return l.FiltersContext(context.Background(), parent)
+}
+
+// FiltersContext is like Filters but it gives up waiting for the filters once the context is done.
+func (l *Link) FiltersContext(ctx context.Context, parent uint32) ([]*Filter, error) {
+	filters, err := backend.FilterList(ctx, l.ifc.Index, parent)
+	if err != nil {
+		return nil, newLinkError("list filters", l.ifc.Name, err)
+	}
+
+	return filters, nil
+}
+
+func (k kernelBackend) FilterAdd(ctx context.Context, index int, f *Filter) error {
+	return k.filterRequest(ctx, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, index, f)
+}
+
+func (k kernelBackend) FilterReplace(ctx context.Context, index int, f *Filter) error {
+	return k.filterRequest(ctx, syscall.NLM_F_CREATE, index, f)
+}
+
+func (k kernelBackend) FilterDel(ctx context.Context, index int, f *Filter) error {
+	data := encodeTcmsg(index, f.Handle, f.Parent, f.info())
+	if f.Kind != "" && f.Priority != 0 {
+		data = append(data, encodeRtAttr(tcaKind, encodeString(f.Kind))...)
+	}
+
+	return rtnlRequest(ctx, syscall.RTM_DELTFILTER, 0, data)
+}
+
+func (k kernelBackend) FilterList(ctx context.Context, index int, parent uint32) ([]*Filter, error) {
+	objs, err := tcDump(ctx, syscall.RTM_GETTFILTER, index, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	var filters []*Filter
+	for _, obj := range objs {
+		// classifier instances are dumped without handle
+		if obj.handle == 0 || (obj.kind == "u32" && obj.handle&u32NodeMask == 0) {
+			continue
+		}
+
+		f, err := parseFilter(ctx, k, obj)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+
+	return filters, nil
+}
+
+// filterRequest sends rtnetlink request which creates or changes the filter of the link with the given index
+func (k kernelBackend) filterRequest(ctx context.Context, flags uint16, index int, f *Filter) error {
+	data, err := f.encode(ctx, k, index)
+	if err != nil {
+		return err
+	}
+
+	return rtnlRequest(ctx, syscall.RTM_NEWTFILTER, flags, data)
+}
+
+// info returns tcmsg info field of the filter which holds its priority and protocol
+func (f *Filter) info() uint32 {
+	protocol := f.Protocol
+	if protocol == 0 {
+		protocol = syscall.ETH_P_ALL
+	}
+
+	return uint32(f.Priority)<<16 | uint32(htons(protocol))
+}
+
+// copyFilter returns deep copy of the filter
+func copyFilter(f *Filter) *Filter {
+	c := *f
+	if f.U32 != nil {
+		c.U32 = &U32Options{Keys: append([]U32Key(nil), f.U32.Keys...)}
+	}
+
+	if f.Flower != nil {
+		flower := *f.Flower
+		flower.SrcMac = append(net.HardwareAddr(nil), f.Flower.SrcMac...)
+		flower.DstMac = append(net.HardwareAddr(nil), f.Flower.DstMac...)
+		flower.SrcIP = copyIPNet(f.Flower.SrcIP)
+		flower.DstIP = copyIPNet(f.Flower.DstIP)
+		c.Flower = &flower
+	}
+
+	c.Actions = nil
+	for _, a := range f.Actions {
+		c.Actions = append(c.Actions, a.copy())
+	}
+
+	return &c
+}
+
+// encode encodes the filter into tcmsg header followed by its kind and options.
+// Links of mirred actions are looked up in the links backend.
+func (f *Filter) encode(ctx context.Context, links Backend, index int) ([]byte, error) {
+	if !filterKinds[f.Kind] {
+		return nil, fmt.Errorf("Unsupported filter kind: %q", f.Kind)
+	}
+
+	actions, err := encodeActions(ctx, links, f.Actions)
+	if err != nil {
+		return nil, err
+	}
+
+	var options [][]byte
+	switch f.Kind {
+	case "u32":
+		options, err = f.encodeU32(actions)
+	case "flower":
+		options, err = f.encodeFlower(actions)
+	case "matchall":
+		if f.ClassID != 0 {
+			options = append(options, encodeRtAttr(tcaMatchallClassid, encodeUint32(f.ClassID)))
+		}
+		if actions != nil {
+			options = append(options, encodeRtAttr(tcaMatchallAct, actions))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data := append(encodeTcmsg(index, f.Handle, f.Parent, f.info()), encodeRtAttr(tcaKind, encodeString(f.Kind))...)
+
+	return append(data, encodeNestedRtAttr(tcaOptions, options...)...), nil
+}
+
+// encodeU32 encodes u32 filter options and its encoded actions
+func (f *Filter) encodeU32(actions []byte) ([][]byte, error) {
+	var keys []U32Key
+	if f.U32 != nil {
+		keys = f.U32.Keys
+	}
+
+	if len(keys) > 128 {
+		return nil, fmt.Errorf("u32 filter supports up to 128 keys, not %d", len(keys))
+	}
+
+	sel := make([]byte, sizeofU32Sel+sizeofU32Key*len(keys))
+	sel[0] = tcU32Terminal
+	sel[2] = uint8(len(keys))
+	for i, key := range keys {
+		b := sel[sizeofU32Sel+sizeofU32Key*i:]
+		binary.BigEndian.PutUint32(b[0:4], key.Mask)
+		binary.BigEndian.PutUint32(b[4:8], key.Val&key.Mask)
+		nativeEndian.PutUint32(b[8:12], uint32(key.Off))
+	}
+
+	options := [][]byte{encodeRtAttr(tcaU32Sel, sel)}
+	if f.ClassID != 0 {
+		options = append(options, encodeRtAttr(tcaU32Classid, encodeUint32(f.ClassID)))
+	}
+	if actions != nil {
+		options = append(options, encodeRtAttr(tcaU32Act, actions))
+	}
+
+	return options, nil
+}
+
+// encodeFlower encodes flower filter options and its encoded actions.
+// Keys are sent without masks, so the kernel matches them exactly, except for IP networks.
+func (f *Filter) encodeFlower(actions []byte) ([][]byte, error) {
+	var options [][]byte
+	if f.ClassID != 0 {
+		options = append(options, encodeRtAttr(tcaFlowerClassid, encodeUint32(f.ClassID)))
+	}
+	if actions != nil {
+		options = append(options, encodeRtAttr(tcaFlowerAct, actions))
+	}
+	if f.Protocol != 0 {
+		options = append(options, encodeRtAttr(tcaFlowerKeyEthType, encodeUint16(htons(f.Protocol))))
+	}
+
+	o := f.Flower
+	if o == nil {
+		return options, nil
+	}
+
+	if o.SrcMac != nil {
+		options = append(options, encodeRtAttr(tcaFlowerKeyEthSrc, o.SrcMac))
+	}
+	if o.DstMac != nil {
+		options = append(options, encodeRtAttr(tcaFlowerKeyEthDst, o.DstMac))
+	}
+
+	if o.VlanId != 0 {
+		if f.Protocol != syscall.ETH_P_8021Q {
+			return nil, errors.New("flower filter matches VLAN ID of 802.1Q protocol only")
+		}
+		options = append(options, encodeRtAttr(tcaFlowerKeyVlanId, encodeUint16(o.VlanId)))
+	}
+	if o.VlanEthType != 0 {
+		options = append(options, encodeRtAttr(tcaFlowerKeyVlanEthType, encodeUint16(htons(o.VlanEthType))))
+	}
+
+	if o.IPProto != 0 {
+		options = append(options, encodeRtAttr(tcaFlowerKeyIpProto, []byte{o.IPProto}))
+	}
+
+	for _, ip := range []struct {
+		network                        *net.IPNet
+		ipv4, ipv4Mask, ipv6, ipv6Mask uint16
+	}{
+		{o.SrcIP, tcaFlowerKeyIpv4Src, tcaFlowerKeyIpv4SrcMask, tcaFlowerKeyIpv6Src, tcaFlowerKeyIpv6SrcMask},
+		{o.DstIP, tcaFlowerKeyIpv4Dst, tcaFlowerKeyIpv4DstMask, tcaFlowerKeyIpv6Dst, tcaFlowerKeyIpv6DstMask},
+	} {
+		if ip.network == nil {
+			continue
+		}
+
+		if ip4 := ip.network.IP.To4(); ip4 != nil && len(ip.network.Mask) == net.IPv4len {
+			options = append(options, encodeRtAttr(ip.ipv4, ip4.Mask(ip.network.Mask)),
+				encodeRtAttr(ip.ipv4Mask, ip.network.Mask))
+			continue
+		}
+
+		if len(ip.network.Mask) != net.IPv6len {
+			return nil, fmt.Errorf("flower filter invalid IP network %s", ip.network)
+		}
+		options = append(options, encodeRtAttr(ip.ipv6, ip.network.IP.To16().Mask(ip.network.Mask)),
+			encodeRtAttr(ip.ipv6Mask, ip.network.Mask))
+	}
+
+	if o.SrcPort != 0 || o.DstPort != 0 {
+		var src, dst uint16
+		switch o.IPProto {
+		case syscall.IPPROTO_TCP:
+			src, dst = tcaFlowerKeyTcpSrc, tcaFlowerKeyTcpDst
+		case syscall.IPPROTO_UDP:
+			src, dst = tcaFlowerKeyUdpSrc, tcaFlowerKeyUdpDst
+		default:
+			return nil, errors.New("flower filter matches ports of TCP and UDP packets only")
+		}
+
+		if o.SrcPort != 0 {
+			options = append(options, encodeRtAttr(src, encodeUint16(htons(o.SrcPort))))
+		}
+		if o.DstPort != 0 {
+			options = append(options, encodeRtAttr(dst, encodeUint16(htons(o.DstPort))))
+		}
+	}
+
+	return options, nil
+}
+
+// parseFilter decodes filter dumped by the kernel. Links of mirred actions are looked up in the links backend.
+func parseFilter(ctx context.Context, links Backend, obj *tcObject) (*Filter, error) {
+	f := &Filter{
+		Kind:     obj.kind,
+		Parent:   obj.parent,
+		Handle:   obj.handle,
+		Priority: uint16(obj.info >> 16),
+		Protocol: htons(uint16(obj.info)),
+	}
+
+	if !filterKinds[obj.kind] {
+		return f, nil
+	}
+
+	attrs, err := tcAttrs(obj.options)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse %s filter options: %s", obj.kind, err)
+	}
+
+	kindAttrs := filterAttrs[obj.kind]
+	if v := attrs[kindAttrs.classID]; len(v) == 4 {
+		f.ClassID = nativeEndian.Uint32(v)
+	}
+
+	if v, ok := attrs[kindAttrs.act]; ok {
+		if f.Actions, err = parseActions(ctx, links, v); err != nil {
+			return nil, fmt.Errorf("Could not parse %s filter actions: %s", obj.kind, err)
+		}
+	}
+
+	switch obj.kind {
+	case "u32":
+		f.U32, err = parseU32Options(attrs)
+	case "flower":
+		f.Flower = parseFlowerOptions(attrs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse %s filter options: %s", obj.kind, err)
+	}
+
+	return f, nil
+}
+
+// parseU32Options decodes u32 filter selector
+func parseU32Options(attrs map[uint16][]byte) (*U32Options, error) {
+	sel := attrs[tcaU32Sel]
+	if len(sel) < sizeofU32Sel || len(sel) < sizeofU32Sel+sizeofU32Key*int(sel[2]) {
+		return nil, errors.New("missing u32 selector")
+	}
+
+	o := &U32Options{}
+	for i := 0; i < int(sel[2]); i++ {
+		b := sel[sizeofU32Sel+sizeofU32Key*i:]
+		o.Keys = append(o.Keys, U32Key{
+			Mask: binary.BigEndian.Uint32(b[0:4]),
+			Val:  binary.BigEndian.Uint32(b[4:8]),
+			Off:  int32(nativeEndian.Uint32(b[8:12])),
+		})
+	}
+
+	return o, nil
+}
+
+// parseFlowerOptions decodes flower filter keys
+func parseFlowerOptions(attrs map[uint16][]byte) *FlowerOptions {
+	o := &FlowerOptions{}
+	if v := attrs[tcaFlowerKeyEthSrc]; len(v) == 6 {
+		o.SrcMac = net.HardwareAddr(v)
+	}
+	if v := attrs[tcaFlowerKeyEthDst]; len(v) == 6 {
+		o.DstMac = net.HardwareAddr(v)
+	}
+	if v := attrs[tcaFlowerKeyVlanId]; len(v) == 2 {
+		o.VlanId = nativeEndian.Uint16(v)
+	}
+	if v := attrs[tcaFlowerKeyVlanEthType]; len(v) == 2 {
+		o.VlanEthType = htons(nativeEndian.Uint16(v))
+	}
+	if v := attrs[tcaFlowerKeyIpProto]; len(v) == 1 {
+		o.IPProto = v[0]
+	}
+
+	network := func(ip, mask []byte) *net.IPNet {
+		if ip == nil || len(ip) != len(mask) {
+			return nil
+		}
+		return &net.IPNet{IP: net.IP(ip), Mask: net.IPMask(mask)}
+	}
+
+	if o.SrcIP = network(attrs[tcaFlowerKeyIpv4Src], attrs[tcaFlowerKeyIpv4SrcMask]); o.SrcIP == nil {
+		o.SrcIP = network(attrs[tcaFlowerKeyIpv6Src], attrs[tcaFlowerKeyIpv6SrcMask])
+	}
+	if o.DstIP = network(attrs[tcaFlowerKeyIpv4Dst], attrs[tcaFlowerKeyIpv4DstMask]); o.DstIP == nil {
+		o.DstIP = network(attrs[tcaFlowerKeyIpv6Dst], attrs[tcaFlowerKeyIpv6DstMask])
+	}
+
+	src, dst := uint16(tcaFlowerKeyTcpSrc), uint16(tcaFlowerKeyTcpDst)
+	if o.IPProto == syscall.IPPROTO_UDP {
+		src, dst = tcaFlowerKeyUdpSrc, tcaFlowerKeyUdpDst
+	}
+	if v := attrs[src]; len(v) == 2 {
+		o.SrcPort = htons(nativeEndian.Uint16(v))
+	}
+	if v := attrs[dst]; len(v) == 2 {
+		o.DstPort = htons(nativeEndian.Uint16(v))
+	}
+
+	return o
+}
@@ -0,0 +1,429 @@
+package tenus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/milosgajdos/tenus/tenustest"
+)
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return network
+}
+
+func Test_U32Keys(t *testing.T) {
+	for _, tt := range []struct {
+		keys     []U32Key
+		expected []U32Key
+	}{
+		{U32SrcIP(mustParseCIDR("10.1.0.0/16")), []U32Key{{Val: 0x0a010000, Mask: 0xffff0000, Off: 12}}},
+		{U32DstIP(mustParseCIDR("192.168.1.1/32")), []U32Key{{Val: 0xc0a80101, Mask: 0xffffffff, Off: 16}}},
+		{U32SrcIP(mustParseCIDR("fd00:1::/48")), []U32Key{{Val: 0xfd000001, Mask: 0xffffffff, Off: 8}, {Val: 0, Mask: 0xffff0000, Off: 12}}},
+		{U32DstIP(mustParseCIDR("fd00::/8")), []U32Key{{Val: 0xfd000000, Mask: 0xff000000, Off: 24}}},
+		{[]U32Key{U32IPProto(syscall.IPPROTO_UDP)}, []U32Key{{Val: 0x00110000, Mask: 0x00ff0000, Off: 8}}},
+		{[]U32Key{U32SrcPort(53), U32DstPort(80)}, []U32Key{{Val: 53 << 16, Mask: 0xffff0000, Off: 20}, {Val: 80, Mask: 0xffff, Off: 20}}},
+	} {
+		if !reflect.DeepEqual(tt.keys, tt.expected) {
+			t.Errorf("U32 keys failed: expected %+v, returned %+v", tt.expected, tt.keys)
+		}
+	}
+}
+
+type filterTest struct {
+	filter   *Filter
+	expected *Filter
+}
+
+var filterTests = []filterTest{
+	{
+		filter: &Filter{Kind: "u32", Parent: MakeHandle(1, 0), Handle: 0x80000800, Priority: 1, Protocol: syscall.ETH_P_IP,
+			ClassID: MakeHandle(1, 0x10), U32: &U32Options{Keys: append(U32DstIP(mustParseCIDR("10.1.0.0/16")), U32DstPort(80))}},
+	},
+	{
+		filter:   &Filter{Kind: "u32", Parent: HandleMinEgress, Priority: 2, Actions: []*Action{{Kind: "drop"}}},
+		expected: &Filter{Kind: "u32", Parent: HandleMinEgress, Priority: 2, Protocol: syscall.ETH_P_ALL, U32: &U32Options{}, Actions: []*Action{{Kind: "drop"}}},
+	},
+	{
+		filter: &Filter{Kind: "flower", Parent: HandleMinIngress, Handle: 1, Priority: 3, Protocol: syscall.ETH_P_8021Q,
+			Flower: &FlowerOptions{
+				SrcMac:      net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+				VlanId:      10,
+				VlanEthType: syscall.ETH_P_IP,
+				IPProto:     syscall.IPPROTO_UDP,
+				SrcIP:       mustParseCIDR("10.0.0.0/8"),
+				DstIP:       mustParseCIDR("192.168.1.1/32"),
+				DstPort:     53,
+			},
+			Actions: []*Action{{Kind: "vlan", Vlan: &VlanAction{Pop: true}}, {Kind: "mirred", Mirred: &MirredAction{Dev: "lo", Redirect: true}}},
+		},
+	},
+	{
+		filter: &Filter{Kind: "flower", Parent: HandleMinIngress, Handle: 1, Priority: 4, Protocol: syscall.ETH_P_IPV6,
+			Flower: &FlowerOptions{IPProto: syscall.IPPROTO_TCP, DstIP: mustParseCIDR("fd00::/8"), SrcPort: 22}},
+	},
+	{
+		filter: &Filter{Kind: "matchall", Parent: MakeHandle(1, 0), Handle: 1, Priority: 5, Protocol: syscall.ETH_P_ALL,
+			ClassID: MakeHandle(1, 0x20), Actions: []*Action{{Kind: "police", Police: &PoliceAction{Rate: 125000, Burst: 10000}}}},
+	},
+}
+
+func Test_FilterOptions(t *testing.T) {
+	for _, tt := range filterTests {
+		data, err := tt.filter.encode(context.Background(), NewFakeBackend(), 1)
+		if err != nil {
+			t.Fatalf("encode(%+v) failed: %s", tt.filter, err)
+		}
+
+		obj, err := parseTcObject(&syscall.NetlinkMessage{Data: data})
+		if err != nil {
+			t.Fatalf("parseTcObject() failed: %s", err)
+		}
+
+		f, err := parseFilter(context.Background(), NewFakeBackend(), obj)
+		if err != nil {
+			t.Fatalf("parseFilter() failed: %s", err)
+		}
+
+		expected := tt.filter
+		if tt.expected != nil {
+			expected = tt.expected
+		}
+
+		if !reflect.DeepEqual(f, expected) {
+			t.Errorf("parseFilter() failed: expected %+v, returned %+v", expected, f)
+		}
+	}
+
+	for _, f := range []*Filter{
+		{Kind: "basic"},
+		{Kind: "flower", Flower: &FlowerOptions{DstPort: 80}},
+		{Kind: "flower", Protocol: syscall.ETH_P_IP, Flower: &FlowerOptions{VlanId: 10}},
+		{Kind: "u32", U32: &U32Options{Keys: make([]U32Key, 129)}},
+		{Kind: "matchall", Actions: []*Action{{Kind: "mirred"}}},
+	} {
+		if _, err := f.encode(context.Background(), NewFakeBackend(), 1); err == nil {
+			t.Errorf("encode(%+v) expected to fail", f)
+		}
+	}
+}
+
+func Test_Filters(t *testing.T) {
+	tenustest.New(t)
+
+	veth, err := NewVethPairWithOptions("tcfilter01", VethOptions{PeerName: "tcfilter02"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	if err := veth.AddQdisc(&Qdisc{Kind: "htb", Handle: MakeHandle(1, 0)}); err != nil {
+		t.Fatalf("AddQdisc() failed: %s", err)
+	}
+
+	for _, minor := range []uint16{0x10, 0x20} {
+		c := &Class{Kind: "htb", Handle: MakeHandle(1, minor), Parent: MakeHandle(1, 0), Htb: &HtbClassOptions{Rate: 125000}}
+		if err := veth.AddClass(c); err != nil {
+			t.Fatalf("AddClass(%+v) failed: %s", c, err)
+		}
+	}
+
+	f := &Filter{Kind: "u32", Parent: MakeHandle(1, 0), Priority: 1, Protocol: syscall.ETH_P_IP, ClassID: MakeHandle(1, 0x10),
+		U32: &U32Options{Keys: append(U32DstIP(mustParseCIDR("10.1.0.0/16")), U32IPProto(syscall.IPPROTO_TCP), U32DstPort(80))}}
+	if err := veth.AddFilter(f); err != nil {
+		t.Fatalf("AddFilter(%+v) failed: %s", f, err)
+	}
+
+	filters, err := veth.Filters(MakeHandle(1, 0))
+	if err != nil || len(filters) != 1 {
+		t.Fatalf("Filters() failed: %+v, %v", filters, err)
+	}
+
+	// the kernel assigns the first node of u32 hash table 800:
+	f.Handle = 0x80000800
+	if !reflect.DeepEqual(filters[0], f) {
+		t.Fatalf("Filters() failed: expected %+v, returned %+v", f, filters[0])
+	}
+
+	if err := veth.AddFilter(f); !errors.Is(err, syscall.EEXIST) {
+		t.Errorf("AddFilter(%+v) failed: expected %v, returned %v", f, syscall.EEXIST, err)
+	}
+
+	f.ClassID = MakeHandle(1, 0x20)
+	if err := veth.ReplaceFilter(f); err != nil {
+		t.Fatalf("ReplaceFilter(%+v) failed: %s", f, err)
+	}
+
+	if filters, err := veth.Filters(MakeHandle(1, 0)); err != nil || len(filters) != 1 || filters[0].ClassID != f.ClassID {
+		t.Fatalf("ReplaceFilter(%+v) failed to change the filter: %+v, %v", f, filters, err)
+	}
+
+	if err := veth.DelFilter(f); err != nil {
+		t.Fatalf("DelFilter(%+v) failed: %s", f, err)
+	}
+
+	if filters, err := veth.Filters(MakeHandle(1, 0)); err != nil || len(filters) != 0 {
+		t.Fatalf("DelFilter(%+v) failed to delete the filter: %+v, %v", f, filters, err)
+	}
+
+	// classifiers and actions which are not built into every kernel
+	for _, f := range []*Filter{
+		{Kind: "flower", Parent: MakeHandle(1, 0), Priority: 2, Protocol: syscall.ETH_P_IP, ClassID: MakeHandle(1, 0x10),
+			Flower: &FlowerOptions{IPProto: syscall.IPPROTO_UDP, DstPort: 53}},
+		{Kind: "matchall", Parent: MakeHandle(1, 0), Priority: 3, ClassID: MakeHandle(1, 0x20)},
+		{Kind: "u32", Parent: MakeHandle(1, 0), Priority: 4, Actions: []*Action{{Kind: "drop"}}},
+		{Kind: "u32", Parent: MakeHandle(1, 0), Priority: 5, Actions: []*Action{{Kind: "police", Police: &PoliceAction{Rate: 125000, Burst: 10000}}}},
+		{Kind: "u32", Parent: MakeHandle(1, 0), Priority: 6, Actions: []*Action{{Kind: "vlan", Vlan: &VlanAction{Id: 10}}}},
+	} {
+		if err := veth.AddFilter(f); err != nil {
+			if errors.Is(err, syscall.ENOENT) {
+				t.Logf("Skipping filter %+v not supported by the kernel", f)
+				continue
+			}
+			t.Fatalf("AddFilter(%+v) failed: %s", f, err)
+		}
+
+		if filters, err := veth.Filters(MakeHandle(1, 0)); err != nil || len(filters) != 1 || filters[0].Kind != f.Kind {
+			t.Fatalf("Filters() failed to return %s filter: %+v, %v", f.Kind, filters, err)
+		}
+
+		// 0 priority deletes all filters of the parent
+		if err := veth.DelFilter(&Filter{Parent: MakeHandle(1, 0)}); err != nil {
+			t.Fatalf("DelFilter() failed: %s", err)
+		}
+	}
+}
+
+func Test_FilterMirred(t *testing.T) {
+	tenustest.New(t)
+
+	veth, err := NewVethPairWithOptions("tcmirred01", VethOptions{PeerName: "tcmirred02"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	br, err := NewBridgeWithName("tcmirredbr")
+	if err != nil {
+		t.Fatalf("NewBridgeWithName() failed: %s", err)
+	}
+
+	if err := br.AddSlaveIfc(veth.PeerNetInterface()); err != nil {
+		t.Fatalf("AddSlaveIfc() failed: %s", err)
+	}
+
+	// the veth peer is a bridge port
+	port, err := NewLinkFrom(veth.PeerNetInterface().Name)
+	if err != nil {
+		t.Fatalf("NewLinkFrom() failed: %s", err)
+	}
+
+	var captures []Vether
+	for _, names := range [][2]string{{"tccapture01", "tccapture02"}, {"tccapture03", "tccapture04"}} {
+		capture, err := NewVethPairWithOptions(names[0], VethOptions{PeerName: names[1]})
+		if err != nil {
+			t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+		}
+		if err := capture.SetPeerLinkUp(); err != nil {
+			t.Fatalf("SetPeerLinkUp() failed: %s", err)
+		}
+		captures = append(captures, capture)
+	}
+
+	for _, link := range []Linker{veth, port, br, captures[0], captures[1]} {
+		if err := link.SetLinkUp(); err != nil {
+			t.Fatalf("SetLinkUp() failed: %s", err)
+		}
+	}
+
+	// mirror traffic sent by the veth and received by the bridge port
+	for _, tt := range []struct {
+		link   Linker
+		filter *Filter
+	}{
+		{veth, &Filter{Kind: "u32", Parent: HandleMinEgress, Priority: 1,
+			Actions: []*Action{{Kind: "mirred", Mirred: &MirredAction{Dev: "tccapture01"}}}}},
+		{port, &Filter{Kind: "u32", Parent: HandleMinIngress, Priority: 1,
+			Actions: []*Action{{Kind: "mirred", Mirred: &MirredAction{Dev: "tccapture03"}}}}},
+	} {
+		if err := tt.link.AddQdisc(&Qdisc{Kind: "clsact"}); err != nil {
+			t.Fatalf("AddQdisc() failed: %s", err)
+		}
+
+		if err := tt.link.AddFilter(tt.filter); err != nil {
+			t.Fatalf("AddFilter(%+v) failed: %s", tt.filter, err)
+		}
+
+		filters, err := tt.link.Filters(tt.filter.Parent)
+		if err != nil || len(filters) != 1 || len(filters[0].Actions) != 1 ||
+			!reflect.DeepEqual(filters[0].Actions[0], tt.filter.Actions[0]) {
+			t.Fatalf("Filters() failed to return mirred filter: %+v, %v", filters, err)
+		}
+	}
+
+	if err := veth.SetLinkIp(net.ParseIP("10.99.0.1"), mustParseCIDR("10.99.0.0/24")); err != nil {
+		t.Fatalf("SetLinkIp() failed: %s", err)
+	}
+
+	// sending to the unresolved neighbour makes the veth send ARP requests
+	conn, err := net.Dial("udp4", "10.99.0.2:9")
+	if err != nil {
+		t.Fatalf("Dial() failed: %s", err)
+	}
+	defer conn.Close()
+
+	// the mirrored ARP requests are received by the capture links' peers
+	for _, capture := range captures {
+		fd := openARPCapture(t, capture.PeerNetInterface().Index)
+		defer syscall.Close(fd)
+
+		if !captureARP(fd, conn, net.ParseIP("10.99.0.1").To4()) {
+			t.Fatalf("Traffic was not mirrored to %s", capture.NetInterface().Name)
+		}
+	}
+}
+
+// openARPCapture opens packet socket which receives ARP packets of the link with the given index
+func openARPCapture(t *testing.T, index int) int {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, int(htons(syscall.ETH_P_ARP)))
+	if err != nil {
+		t.Fatalf("Socket() failed: %s", err)
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ARP), Ifindex: index}); err != nil {
+		syscall.Close(fd)
+		t.Fatalf("Bind() failed: %s", err)
+	}
+
+	tv := syscall.NsecToTimeval(int64(100 * time.Millisecond))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		t.Fatalf("SetsockoptTimeval() failed: %s", err)
+	}
+
+	return fd
+}
+
+// captureARP keeps sending packets on conn until ARP request of the sender IP is received on fd
+func captureARP(fd int, conn net.Conn, sender net.IP) bool {
+	b := make([]byte, 1500)
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); {
+		conn.Write([]byte("tenus"))
+
+		n, _, err := syscall.Recvfrom(fd, b, 0)
+		// sender IP address follows ARP header and sender MAC address
+		if err == nil && n >= 28 && net.IP(b[14:18]).Equal(sender) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func Test_FiltersFakeBackend(t *testing.T) {
+	defer SetBackend(SetBackend(NewFakeBackend()))
+
+	link, err := NewLinkWithOptions("tcfilter03", LinkOptions{})
+	if err != nil {
+		t.Fatalf("NewLinkWithOptions() failed: %s", err)
+	}
+
+	if err := link.AddFilter(&Filter{Kind: "matchall"}); !errors.Is(err, syscall.ENOENT) {
+		t.Fatalf("AddFilter() failed: expected %v, returned %v", syscall.ENOENT, err)
+	}
+
+	class := &Class{Kind: "htb", Handle: MakeHandle(1, 0x10), Parent: MakeHandle(1, 0), Htb: &HtbClassOptions{Rate: 125000}}
+	if err := link.AddClass(class); !errors.Is(err, syscall.ENOENT) {
+		t.Fatalf("AddClass() failed: expected %v, returned %v", syscall.ENOENT, err)
+	}
+
+	if err := link.AddQdisc(&Qdisc{Kind: "htb", Handle: MakeHandle(1, 0), Htb: &HtbOptions{Default: 0x10}}); err != nil {
+		t.Fatalf("AddQdisc() failed: %s", err)
+	}
+
+	if err := link.AddClass(class); err != nil {
+		t.Fatalf("AddClass() failed: %s", err)
+	}
+
+	if err := link.AddClass(class); !errors.Is(err, syscall.EEXIST) {
+		t.Fatalf("AddClass() failed: expected %v, returned %v", syscall.EEXIST, err)
+	}
+
+	class.Htb.Ceil = 250000
+	if err := link.ReplaceClass(class); err != nil {
+		t.Fatalf("ReplaceClass() failed: %s", err)
+	}
+
+	classes, err := link.Classes()
+	if err != nil || len(classes) != 1 || classes[0].Htb.Ceil != 250000 || classes[0].Stats == nil {
+		t.Fatalf("Classes() failed to return replaced class: %+v, %v", classes, err)
+	}
+
+	u32 := &Filter{Kind: "u32", ClassID: class.Handle, U32: &U32Options{Keys: []U32Key{U32DstPort(80)}}}
+	matchall := &Filter{Kind: "matchall", Priority: 1, ClassID: class.Handle}
+	for _, f := range []*Filter{u32, matchall} {
+		if err := link.AddFilter(f); err != nil {
+			t.Fatalf("AddFilter(%+v) failed: %s", f, err)
+		}
+	}
+
+	filters, err := link.Filters(0)
+	if err != nil || len(filters) != 2 {
+		t.Fatalf("Filters() failed: %+v, %v", filters, err)
+	}
+
+	if f := filters[0]; f.Parent != MakeHandle(1, 0) || f.Priority != 0xc000 || f.Handle != 0x80000800 || f.Protocol != syscall.ETH_P_ALL {
+		t.Errorf("Filters() failed to return kernel assigned u32 filter attributes: %+v", f)
+	}
+
+	if f := filters[1]; f.Priority != 1 || f.Handle != 1 {
+		t.Errorf("Filters() failed to return kernel assigned matchall filter attributes: %+v", f)
+	}
+
+	for _, tt := range []struct {
+		filter *Filter
+		err    error
+	}{
+		{&Filter{Kind: "matchall", Priority: 1, Handle: 1}, syscall.EEXIST},
+		{&Filter{Kind: "u32", Priority: 1, U32: &U32Options{}}, syscall.EINVAL},
+		{&Filter{Kind: "matchall", Parent: MakeHandle(1, 0x20)}, syscall.ENOENT},
+		{&Filter{Kind: "matchall", Parent: HandleMinIngress}, syscall.ENOENT},
+		{&Filter{Kind: "matchall", Actions: []*Action{{Kind: "mirred", Mirred: &MirredAction{Dev: "tcnonexistent"}}}}, ErrLinkNotFound},
+	} {
+		if err := link.AddFilter(tt.filter); !errors.Is(err, tt.err) {
+			t.Errorf("AddFilter(%+v) failed: expected %v, returned %v", tt.filter, tt.err, err)
+		}
+	}
+
+	// the class can't be deleted while filters send packets to it
+	if err := link.DelClass(class); !errors.Is(err, syscall.EBUSY) {
+		t.Fatalf("DelClass() failed: expected %v, returned %v", syscall.EBUSY, err)
+	}
+
+	if err := link.DelFilter(&Filter{Priority: 1}); err != nil {
+		t.Fatalf("DelFilter() failed: %s", err)
+	}
+
+	if err := link.DelFilter(&Filter{Priority: 1}); !errors.Is(err, syscall.ENOENT) {
+		t.Fatalf("DelFilter() failed: expected %v, returned %v", syscall.ENOENT, err)
+	}
+
+	if err := link.DelFilter(&Filter{}); err != nil {
+		t.Fatalf("DelFilter() failed: %s", err)
+	}
+
+	if err := link.DelClass(class); err != nil {
+		t.Fatalf("DelClass() failed: %s", err)
+	}
+
+	if classes, err := link.Classes(); err != nil || len(classes) != 0 {
+		t.Fatalf("Classes() failed to delete class: %+v, %v", classes, err)
+	}
+}
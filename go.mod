@@ -1,5 +1,5 @@
 module github.com/milosgajdos/tenus
 
-go 1.13
+go 1.14
 
-require github.com/docker/libcontainer v2.2.1+incompatible
+require gopkg.in/yaml.v2 v2.4.0
@@ -2,25 +2,25 @@ package tenus
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
-	"net/http"
 	"os"
 	"path"
-	"path/filepath"
+	"runtime"
 	"strconv"
 	"syscall"
 	"time"
 	"unicode"
 
-	"github.com/docker/libcontainer/netlink"
-	"github.com/docker/libcontainer/system"
+	"github.com/milosgajdos/tenus/internal/netns"
 )
 
-// generates random string for makeNetInterfaceName()
+// generates random string for RandomName()
 func randomString(size int) string {
 	alphanum := "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
 	bytes := make([]byte, size)
@@ -33,20 +33,196 @@ func randomString(size int) string {
 	return string(bytes)
 }
 
+// maxNameAttempts limits the number of generated names tried when creating a link with generated name
+const maxNameAttempts = 16
+
+// NameGenerator returns candidate name of a new link starting with prefix. n counts the names requested
+// while creating a single link and taken names are skipped, so creating veth pair requests names 0 and 1,
+// or names 1 and 2 if name 0 is taken.
+// Links with generated names are renamed when they are moved to a network namespace where their name is taken.
+type NameGenerator func(prefix string, n int) string
+
+// nameGenerator generates names of the links created by tenus constructors when no name is supplied
+var nameGenerator NameGenerator = RandomName
+
+// SetNameGenerator replaces the generator of link names used by tenus constructors and returns the previous one.
+// Passing nil restores RandomName. SetNameGenerator is not safe to call concurrently with other tenus functions.
+func SetNameGenerator(g NameGenerator) NameGenerator {
+	prev := nameGenerator
+	if g == nil {
+		g = RandomName
+	}
+	nameGenerator = g
+
+	return prev
+}
+
+// RandomName returns prefix followed by 6 random alphanumeric characters.
+// The prefix is truncated so that the name fits IFNAMSIZ.
+func RandomName(prefix string, n int) string {
+	const size = 6
+	return truncatePrefix(prefix, size) + randomString(size)
+}
+
+// HashedNames returns NameGenerator which derives names deterministically from the id, e.g. container ID.
+// The name is the prefix followed by hex encoded SHA-256 hash of the id and the name's number n,
+// truncated so that the name fits IFNAMSIZ. At least 6 hash characters are used.
+func HashedNames(id string) NameGenerator {
+	return func(prefix string, n int) string {
+		prefix = truncatePrefix(prefix, 6)
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", id, n)))
+
+		return prefix + hex.EncodeToString(sum[:])[:syscall.IFNAMSIZ-1-len(prefix)]
+	}
+}
+
+// truncatePrefix shortens prefix so that suffix of the given size fits IFNAMSIZ
+func truncatePrefix(prefix string, size int) string {
+	if max := syscall.IFNAMSIZ - 1 - size; len(prefix) > max {
+		return prefix[:max]
+	}
+
+	return prefix
+}
+
+// MakeNetInterfaceName generates name of a new link which starts with base and is not taken in the current
+// network namespace. The name fits IFNAMSIZ.
+//
+// Another process can claim the name before the link is created; tenus constructors which generate
+// link names retry creation with new names when that happens.
 func MakeNetInterfaceName(base string) string {
 	return makeNetInterfaceName(base)
 }
 
+// MakeNetInterfaceNameInNs is like MakeNetInterfaceName but the name is not taken in network namespace
+// specified by filesystem path either, so that the link can be moved there.
+// It returns error if the network namespace can not be inspected.
+func MakeNetInterfaceNameInNs(base string, nspath string) (string, error) {
+	for n := 0; n < maxNameAttempts; n++ {
+		var name string
+		name, n = generateName(base, n, nil)
+
+		var taken bool
+		err := backend.ExecInNs(nspath, func() error {
+			_, err := backend.LinkByName(context.Background(), name)
+			taken = err == nil
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+
+		if !taken {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: no free name starting with %s in %s", ErrLinkExists, base, nspath)
+}
+
+// setLinkNs moves the link to network namespace specified by filesystem path.
+// If the link's name was generated with the prefix and it is taken in the target network namespace,
+// the link is renamed to a name which is free in both network namespaces before it is moved.
+func setLinkNs(ctx context.Context, ifc *net.Interface, prefix string, nspath string) error {
+	if prefix != "" {
+		var taken bool
+		if err := backend.ExecInNs(nspath, func() error {
+			_, err := backend.LinkByName(ctx, ifc.Name)
+			taken = err == nil
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		if taken {
+			name, err := MakeNetInterfaceNameInNs(prefix, nspath)
+			if err != nil {
+				return err
+			}
+
+			if err := backend.LinkSetName(ctx, ifc.Index, name); err != nil {
+				return err
+			}
+			ifc.Name = name
+		}
+	}
+
+	return backend.LinkSetNs(ctx, ifc.Index, nspath)
+}
+
 // generates new unused network interfaces name with given prefix
 func makeNetInterfaceName(base string) string {
-	for {
-		name := base + randomString(6)
-		if _, err := net.InterfaceByName(name); err == nil {
+	name, _ := generateName(base, 0, nil)
+	return name
+}
+
+// generateName returns the first name generated by nameGenerator starting from the n-th one
+// which is neither taken in the current network namespace nor in picked, and the number of the name.
+// It gives up after maxNameAttempts names.
+func generateName(prefix string, n int, picked map[string]bool) (string, int) {
+	var name string
+	for i := 0; i < maxNameAttempts; i++ {
+		name = nameGenerator(prefix, n+i)
+		if picked[name] {
 			continue
 		}
 
-		return name
+		if _, err := backend.LinkByName(context.Background(), name); err != nil {
+			return name, n + i
+		}
 	}
+
+	return name, n + maxNameAttempts - 1
+}
+
+// createWithGeneratedNames runs create with every empty name in names generated with the prefix.
+// If create fails because a generated name was claimed by another process before the link was created,
+// it is run again with new names. Names supplied by the caller are never changed.
+func createWithGeneratedNames(prefix string, names []*string, create func() error) error {
+	var generated []*string
+	supplied := make(map[string]bool)
+	for _, name := range names {
+		if *name == "" {
+			generated = append(generated, name)
+		} else {
+			supplied[*name] = true
+		}
+	}
+
+	n := 0
+	for attempt := 0; ; attempt++ {
+		// names generated in the same attempt must differ from each other and from the supplied ones
+		picked := make(map[string]bool)
+		for name := range supplied {
+			picked[name] = true
+		}
+
+		for _, name := range generated {
+			*name, n = generateName(prefix, n, picked)
+			picked[*name] = true
+			n++
+		}
+
+		err := create()
+		if err == nil || attempt == maxNameAttempts-1 || !nameTaken(err, generated) {
+			return err
+		}
+	}
+}
+
+// nameTaken returns true if err reports existing link and any of the generated names is taken
+func nameTaken(err error, generated []*string) bool {
+	if !errors.Is(err, ErrLinkExists) && !errors.Is(err, syscall.EEXIST) {
+		return false
+	}
+
+	for _, name := range generated {
+		if _, err := backend.LinkByName(context.Background(), *name); err == nil {
+			return true
+		}
+	}
+
+	return false
 }
 
 // validates MTU LinkOption
@@ -60,12 +236,12 @@ func validMtu(mtu int) error {
 
 // validates MacAddress LinkOption
 func validMacAddress(macaddr string) error {
-	if _, err := net.ParseMAC(macaddr); err != nil {
-		return fmt.Errorf("Can not parse MAC address: %s", err)
+	if ok, err := MacAddressValid(macaddr); !ok {
+		return err
 	}
 
 	if _, err := FindInterfaceByMacAddress(macaddr); err == nil {
-		return fmt.Errorf("MAC Address already assigned on the host: %s", macaddr)
+		return fmt.Errorf("%w: %s already assigned on the host", ErrInvalidMAC, macaddr)
 	}
 
 	return nil
@@ -93,20 +269,20 @@ func validFlags(flags net.Flags) error {
 // It accepts interface name as a string. It returns error if invalid interface name is supplied.
 func NetInterfaceNameValid(name string) (bool, error) {
 	if name == "" {
-		return false, errors.New("Interface name can not be empty")
+		return false, fmt.Errorf("%w: name can not be empty", ErrInvalidName)
 	}
 
 	if len(name) == 1 {
-		return false, fmt.Errorf("Interface name too short: %s", name)
+		return false, fmt.Errorf("%w: %s too short", ErrInvalidName, name)
 	}
 
-	if len(name) > netlink.IFNAMSIZ {
-		return false, fmt.Errorf("Interface name too long: %s", name)
+	if len(name) >= syscall.IFNAMSIZ {
+		return false, fmt.Errorf("%w: %s too long", ErrInvalidName, name)
 	}
 
 	for _, char := range name {
 		if unicode.IsSpace(char) || char > 0x7F {
-			return false, fmt.Errorf("Invalid characters in interface name: %s", name)
+			return false, fmt.Errorf("%w: invalid characters in %s", ErrInvalidName, name)
 		}
 	}
 
@@ -118,35 +294,47 @@ func NetInterfaceNameValid(name string) (bool, error) {
 // with the given MAC address assigned on Linux host.
 func FindInterfaceByMacAddress(macaddr string) (*net.Interface, error) {
 	if macaddr == "" {
-		return nil, errors.New("Empty MAC address specified!")
+		return nil, fmt.Errorf("%w: empty MAC address specified", ErrInvalidMAC)
 	}
 
-	ifcs, err := net.Interfaces()
+	links, err := backend.LinkList(context.Background())
 	if err != nil {
 		return nil, err
 	}
 
 	hwaddr, err := net.ParseMAC(macaddr)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMAC, err)
 	}
 
-	for _, ifc := range ifcs {
-		if bytes.Equal(hwaddr, ifc.HardwareAddr) {
-			return &ifc, nil
+	for _, attrs := range links {
+		if bytes.Equal(hwaddr, attrs.HardwareAddr) {
+			return attrs.netInterface(), nil
 		}
 	}
 
-	return nil, fmt.Errorf("Could not find interface with MAC address on the host: %s", macaddr)
+	return nil, fmt.Errorf("%w: no interface with MAC address %s on the host", ErrLinkNotFound, macaddr)
 }
 
+// dockerRequestTimeout bounds Docker API requests made by DockerPidByName
+const dockerRequestTimeout = 10 * time.Second
+
 // DockerPidByName returns PID of the running docker container.
 // It accepts Docker container name and Docker host as parameters and queries Docker API via HTTP.
 // Docker host passed as an argument can be either full path to Docker UNIX socket or HOST:PORT address string.
 // It returns error if Docker container can not be found or if an error occurs when querying Docker API.
+// If the container is not running, the error matches ErrContainerNotRunning.
 func DockerPidByName(name string, dockerHost string) (int, error) {
-	var network string
+	ctx, cancel := context.WithTimeout(context.Background(), dockerRequestTimeout)
+	defer cancel()
+
+	return DockerPidByNameContext(ctx, name, dockerHost)
+}
 
+// DockerPidByNameContext returns PID of the running docker container.
+// It works like DockerPidByName, but the Docker API request is aborted when the context is cancelled.
+// Use DockerClient to talk to Docker daemon over TLS or with a fixed API version.
+func DockerPidByNameContext(ctx context.Context, name string, dockerHost string) (int, error) {
 	if name == "" {
 		return 0, errors.New("Docker name can not be empty!")
 	}
@@ -155,87 +343,144 @@ func DockerPidByName(name string, dockerHost string) (int, error) {
 		return 0, errors.New("Docker host can not be empty!")
 	}
 
-	if filepath.IsAbs(dockerHost) {
-		network = "unix"
-	} else {
-		network = "tcp"
+	return (&DockerClient{Host: dockerHost}).ContainerPid(ctx, name)
+}
+
+// NetNsHandle returns a file descriptor handle for network namespace specified by PID.
+// It returns error if network namespace could not be found or if network namespace path could not be opened.
+func NetNsHandle(nspid int) (uintptr, error) {
+	if nspid <= 0 {
+		return 0, fmt.Errorf("Incorred PID specified: %d", nspid)
 	}
 
-	req, err := http.NewRequest("GET", "http://docker.socket/containers/"+name+"/json", nil)
+	nsPath := path.Join("/", "proc", strconv.Itoa(nspid), "ns/net")
+
+	// the descriptor is owned by the caller so it must not be tied to os.File finalizer
+	fd, err := syscall.Open(nsPath, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
 	if err != nil {
-		return 0, fmt.Errorf("Fail to create http request: %s", err)
+		return 0, nsError(nsPath, err)
 	}
 
-	timeout := time.Duration(2 * time.Second)
-	httpTransport := &http.Transport{
-		Dial: func(proto string, addr string) (net.Conn, error) {
-			return net.DialTimeout(network, dockerHost, timeout)
-		},
-	}
+	return uintptr(fd), nil
+}
 
-	dockerClient := http.Client{Transport: httpTransport}
+// SetNetNsToPid sets network namespace to the one specied by PID.
+// It returns error if the network namespace could not be set.
+func SetNetNsToPid(nspid int) error {
+	if nspid <= 0 {
+		return fmt.Errorf("Incorred PID specified: %d", nspid)
+	}
 
-	resp, err := dockerClient.Do(req)
+	nsFd, err := NetNsHandle(nspid)
 	if err != nil {
-		return 0, fmt.Errorf("Failed to create http client: %s", err)
+		return fmt.Errorf("Could not get network namespace handle: %w", err)
 	}
+	defer syscall.Close(int(nsFd))
 
-	switch resp.StatusCode {
-	case http.StatusNotFound:
-		return 0, fmt.Errorf("Docker container \"%s\" does not seem to exist!", name)
-	case http.StatusInternalServerError:
-		return 0, fmt.Errorf("Could not retrieve Docker %s pid due to Docker server error", name)
+	if err := netns.Set(int(nsFd)); err != nil {
+		return fmt.Errorf("Unable to set the network namespace: %w", capabilityError(capSysAdmin, err))
 	}
 
-	data := struct {
-		State struct {
-			Pid float64
-		}
-	}{}
+	return nil
+}
 
-	err = json.NewDecoder(resp.Body).Decode(&data)
-	if err != nil {
-		return 0, fmt.Errorf("Unable to decode json response: %s", err)
+// execInNetNs runs fn in network namespace specified by PID.
+// If nspid is 0 or the PID of the current process, fn runs in the current network namespace.
+func execInNetNs(nspid int, fn func() error) error {
+	if nspid == 0 || nspid == os.Getpid() {
+		return fn()
+	}
+
+	if nspid < 0 {
+		return fmt.Errorf("Incorred PID specified: %d", nspid)
 	}
 
-	return int(data.State.Pid), nil
+	return execInNetNsPath(pidNsPath(nspid), fn)
 }
 
-// NetNsHandle returns a file descriptor handle for network namespace specified by PID.
-// It returns error if network namespace could not be found or if network namespace path could not be opened.
-func NetNsHandle(nspid int) (uintptr, error) {
-	if nspid <= 0 || nspid == 1 {
-		return 0, fmt.Errorf("Incorred PID specified: %d", nspid)
-	}
+// pidNsPath returns filesystem path of the network namespace of the process with the given PID
+func pidNsPath(nspid int) string {
+	return path.Join("/", "proc", strconv.Itoa(nspid), "ns/net")
+}
 
-	nsPath := path.Join("/", "proc", strconv.Itoa(nspid), "ns/net")
-	if nsPath == "" {
-		return 0, fmt.Errorf("Could not find Network namespace for pid: %d", nspid)
+// linkAddr returns IP address ip with the network mask of network as assigned to links by Backend
+func linkAddr(ip net.IP, network *net.IPNet) *net.IPNet {
+	return &net.IPNet{IP: ip, Mask: network.Mask}
+}
+
+// execInNetNsPath runs fn in network namespace specified by filesystem path.
+// The kernel backend executes fn on a locked OS thread which is switched back to the original network namespace
+// once fn returns. If nspath is empty, fn runs in the current network namespace.
+func execInNetNsPath(nspath string, fn func() error) error {
+	if nspath == "" {
+		return fn()
 	}
 
-	file, err := os.Open(nsPath)
+	return backend.ExecInNs(nspath, fn)
+}
+
+// threadNetNsPath returns filesystem path of the calling thread's network namespace
+func threadNetNsPath() string {
+	return fmt.Sprintf("/proc/%d/task/%d/ns/net", os.Getpid(), syscall.Gettid())
+}
+
+// openThreadNetNs opens the calling thread's network namespace.
+// The returned descriptor is owned by the caller.
+func openThreadNetNs() (int, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	nspath := threadNetNsPath()
+	fd, err := syscall.Open(nspath, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
 	if err != nil {
-		return 0, fmt.Errorf("Could not open Network Namespace: %s", err)
+		return -1, nsError(nspath, err)
 	}
 
-	return file.Fd(), nil
+	return fd, nil
 }
 
-// SetNetNsToPid sets network namespace to the one specied by PID.
-// It returns error if the network namespace could not be set.
-func SetNetNsToPid(nspid int) error {
-	if nspid <= 0 || nspid == 1 {
-		return fmt.Errorf("Incorred PID specified: %d", nspid)
+// nsError translates error returned when opening network namespace into sentinel errors
+func nsError(nspath string, err error) error {
+	switch {
+	case os.IsNotExist(err):
+		return fmt.Errorf("%w: %s", ErrNsNotFound, nspath)
+	case os.IsPermission(err):
+		return fmt.Errorf("%w: %s", ErrPermission, nspath)
 	}
 
-	nsFd, err := NetNsHandle(nspid)
-	defer syscall.Close(int(nsFd))
+	return fmt.Errorf("Could not open network namespace %s: %w", nspath, err)
+}
+
+// interfaceByName returns network interface of the given name.
+// It returns ErrLinkNotFound if the interface does not exist in the current network namespace.
+func interfaceByName(name string) (*net.Interface, error) {
+	attrs, err := backend.LinkByName(context.Background(), name)
+	if err != nil {
+		return nil, err
+	}
+
+	return attrs.netInterface(), nil
+}
+
+// interfaceByIndex returns network interface of the given index.
+// It returns ErrLinkNotFound if the interface does not exist in the current network namespace.
+func interfaceByIndex(index int) (*net.Interface, error) {
+	attrs, err := backend.LinkByIndex(context.Background(), index)
 	if err != nil {
-		return fmt.Errorf("Could not get network namespace handle: %s", err)
+		return nil, err
+	}
+
+	return attrs.netInterface(), nil
+}
+
+// linkNameAvailable checks that the interface name is valid and not assigned on the host.
+func linkNameAvailable(name string) error {
+	if ok, err := NetInterfaceNameValid(name); !ok {
+		return err
 	}
 
-	if err := system.Setns(nsFd, syscall.CLONE_NEWNET); err != nil {
-		return fmt.Errorf("Unable to set the network namespace: %v", err)
+	if _, err := backend.LinkByName(context.Background(), name); err == nil {
+		return fmt.Errorf("%w: interface name %s already assigned on the host", ErrLinkExists, name)
 	}
 
 	return nil
@@ -1,13 +1,19 @@
 package tenus
 
 import (
-	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"net"
-	"os/exec"
-	"strconv"
+	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/milosgajdos/tenus/tenustest"
 )
 
 type ifcNameTest struct {
@@ -20,6 +26,8 @@ var ifcNameTests = []ifcNameTest{
 	{"", false},
 	{"a", false},
 	{"abcdefghijklmnopqr", false},
+	{"abcdefghijklmno", true},
+	{"abcdefghijklmnop", false},
 	{"link\uF021", false},
 	{"eth0.123", true},
 }
@@ -34,231 +42,360 @@ func Test_NetInterfaceNameValid(t *testing.T) {
 }
 
 type ifcMacTest struct {
-	testLink
-	opts     LinkOptions
+	name     string
+	macAddr  string
 	testVal  string
-	expected *net.Interface
+	expected bool
 }
 
-// correct MAC Address will always parse into HardwareAddr
-var hw, _ = net.ParseMAC("22:ce:e0:99:63:6f")
 var ifcMacTests = []ifcMacTest{
-	{testLink{name: "ifc01", linkType: "dummy"},
-		LinkOptions{MacAddr: "22:ce:e0:99:63:6f"}, "22:ce:e0:99:63:6f",
-		&net.Interface{Name: "ifc01", HardwareAddr: hw}},
-	{testLink{name: "ifc02", linkType: "dummy"},
-		LinkOptions{MacAddr: "26:2e:71:98:60:8f"}, "",
-		nil},
-	{testLink{name: "ifc03", linkType: "dummy"},
-		LinkOptions{MacAddr: "fa:de:b0:99:52:1c"}, "randomstring",
-		nil},
+	{"ifc01", "22:ce:e0:99:63:6f", "22:ce:e0:99:63:6f", true},
+	{"ifc02", "26:2e:71:98:60:8f", "", false},
+	{"ifc03", "fa:de:b0:99:52:1c", "randomstring", false},
 }
 
 func Test_FindInterfaceByMacAddress(t *testing.T) {
-	for _, tt := range ifcMacTests {
-		tl := &testLink{}
-
-		if err := tl.prepTestLink(tt.name, tt.linkType); err != nil {
-			t.Skipf("InterfaceByMacAddress test requries external command: %v", err)
-		}
-
-		if err := tl.prepLinkOpts(LinkOptions{MacAddr: tt.opts.MacAddr}); err != nil {
-			t.Skipf("InterfaceByMacAddress test requries external command: %v", err)
-		}
+	tenustest.New(t)
 
-		if err := tl.create(); err != nil {
-			t.Fatalf("testLink.create failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
+	for _, tt := range ifcMacTests {
+		veth, err := NewVethPairWithOptions(tt.name, VethOptions{PeerName: tt.name + "p"})
+		if err != nil {
+			t.Fatalf("NewVethPairWithOptions(%s) failed to run: %s", tt.name, err)
 		}
 
-		if err := tl.setup(); err != nil {
-			t.Fatalf("testLink.setup failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
+		if err := veth.SetLinkMacAddress(tt.macAddr); err != nil {
+			t.Fatalf("SetLinkMacAddress(%s) failed: %s", tt.macAddr, err)
 		}
 
 		ifc, err := FindInterfaceByMacAddress(tt.testVal)
-		if ifc != nil {
-			if tt.expected != nil {
-				if ifc.Name != tt.expected.Name || !bytes.Equal(ifc.HardwareAddr, tt.expected.HardwareAddr) {
-					tl.teardown()
-					t.Fatalf("FindInterfaceByMacAddress(%s): expected %v, returned %v",
-						tt.testVal, tt.expected, ifc)
-				}
-			}
-
-			if tt.expected == nil {
-				tl.teardown()
-				t.Fatalf("FindInterfaceByMacAddress(%s): expected %v, returned %v ",
-					tt.testVal, tt.expected, ifc)
+		if !tt.expected {
+			if ifc != nil {
+				t.Fatalf("FindInterfaceByMacAddress(%s): expected nil, returned %v", tt.testVal, ifc)
 			}
+			continue
 		}
 
-		if ifc == nil {
-			if tt.expected != nil {
-				tl.teardown()
-				t.Fatalf("FindInterfaceByMacAddress(%s): expected %v, returned %v, error: %s",
-					tt.testVal, tt.expected, ifc, err)
-			}
+		if err != nil {
+			t.Fatalf("FindInterfaceByMacAddress(%s) failed: %s", tt.testVal, err)
 		}
 
-		if err := tl.teardown(); err != nil {
-			t.Fatalf("testLink.teardown failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
+		if ifc.Name != tt.name || ifc.HardwareAddr.String() != tt.macAddr {
+			t.Fatalf("FindInterfaceByMacAddress(%s): expected %s/%s, returned %s/%s",
+				tt.testVal, tt.name, tt.macAddr, ifc.Name, ifc.HardwareAddr)
 		}
 	}
 }
 
 type dockerPidTest struct {
-	testDocker
+	name     string
 	host     string
 	expected int
 }
 
-var dockerPidTests = []dockerPidTest{
-	{testDocker{name: "topper1", command: "/usr/bin/top"}, "/var/run/docker.sock", 1234},
-	{testDocker{name: "topper2", command: "/usr/bin/top"}, "somehost.com:9011", 0},
-	{testDocker{name: "topper3", command: "/usr/bin/top"}, "", 0},
-}
-
 func Test_DockerPidByName(t *testing.T) {
-	for _, tt := range dockerPidTests {
-		td := &testDocker{}
+	dir, err := ioutil.TempDir("", "tenus")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
 
-		if err := td.prepTestDocker(tt.name, tt.command); err != nil {
-			t.Skipf("DockerPidByName test requries external command: %v", err)
-		}
+	sock, stop := newUnixServer(t, dir, newFakeDocker("1.41"))
+	defer stop()
 
-		if err := td.create(); err != nil {
-			t.Fatalf("prepTestDocker.create failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
-		}
+	dockerPidTests := []dockerPidTest{
+		{"web01", sock, 1234},
+		{"missing01", sock, 0},
+		{"web01", "", 0},
+		{"", sock, 0},
+	}
 
-		retPid, err := DockerPidByName(tt.name, tt.host)
-		if retPid != 0 {
-			if tt.expected != 0 {
-				dockerPath, err := exec.LookPath("docker")
-				if err != nil {
-					t.Errorf("Unable to find docker in PATH: %s", err)
-				}
-
-				out, err := exec.Command(dockerPath, "inspect", "-f", "{{.State.Pid }}", tt.name).Output()
-				if err != nil {
-					td.teardown()
-					t.Fatalf("Failed to run tt.testCmd.Output(): %s", err)
-				}
-
-				actualPid, err := strconv.Atoi(strings.TrimSpace(string(out)))
-				if err != nil {
-					td.teardown()
-					t.Fatalf("Failed to run strconv.Atoi(strings.TrimSpace(string(%v))): %s",
-						out, err)
-				}
-
-				if retPid != actualPid {
-					td.teardown()
-					t.Errorf("DockerPidByName(%s, %s): expected: %v, returned: %v",
-						tt.name, tt.host, out, retPid)
-				}
+	for _, tt := range dockerPidTests {
+		pid, err := DockerPidByName(tt.name, tt.host)
+		if tt.expected == 0 {
+			if err == nil {
+				t.Errorf("DockerPidByName(%s, %s): expected error, returned %d", tt.name, tt.host, pid)
 			}
+			continue
+		}
 
-			if tt.expected == 0 {
-				td.teardown()
-				t.Errorf("DockerPidByName(%s, %s): expected: %v, returned: %v",
-					tt.name, tt.host, tt.expected, retPid)
-			}
+		if err != nil || pid != tt.expected {
+			t.Errorf("DockerPidByName(%s, %s): expected %d, returned %d, error: %v",
+				tt.name, tt.host, tt.expected, pid, err)
 		}
+	}
+}
+
+func Test_DockerPidByNameContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenus")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
 
-		if retPid == 0 {
-			if tt.expected != 0 {
-				td.teardown()
-				t.Errorf("DockerPidByName(%s, %s): expected: %v, returned: %v, error: %s",
-					tt.name, tt.host, tt.expected, retPid, err)
+	sock := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("DockerPidByNameContext test requires UNIX socket: %v", err)
+	}
+	defer l.Close()
+
+	// accept connections but never respond to emulate hung Docker daemon
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
 			}
+			defer conn.Close()
 		}
+	}()
 
-		if err := td.teardown(); err != nil {
-			t.Fatalf("testDocker.teardown failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := DockerPidByNameContext(ctx, "topper1", sock); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("DockerPidByNameContext() failed: expected %v, returned %v", context.DeadlineExceeded, err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("DockerPidByNameContext() failed: returned after %s", elapsed)
 	}
 }
 
 type netNsTest struct {
 	pid      int
-	testCmd  *exec.Cmd
-	expected int
+	expected bool
 }
 
 var netNsTests = []netNsTest{
-	{1234, &exec.Cmd{
-		Path: "",
-		Args: []string{"docker", "inspect", "-f", "{{.State.Pid }}", "testdckr"}},
-		1234},
-	{0, &exec.Cmd{}, 0},
+	{os.Getpid(), true},
+	{0, false},
+	{-1, false},
 }
 
 func Test_NetNsHandle(t *testing.T) {
 	for _, tt := range netNsTests {
-		td := &testDocker{}
-
-		if err := td.prepTestDocker("testdckr", "/usr/bin/top"); err != nil {
-			t.Skipf("NetNsHandle test requries external command: %v", err)
+		nsFd, err := NetNsHandle(tt.pid)
+		if !tt.expected {
+			if err == nil {
+				syscall.Close(int(nsFd))
+				t.Fatalf("NetNsHandle(%d): expected error, returned %v", tt.pid, nsFd)
+			}
+			continue
 		}
 
-		if err := td.create(); err != nil {
-			t.Fatalf("prepTestDocker.create failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
+		if err != nil {
+			t.Fatalf("NetNsHandle(%d) failed: %s", tt.pid, err)
 		}
+		syscall.Close(int(nsFd))
+	}
+}
 
-		if tt.pid != 0 {
-			dockerPath, err := exec.LookPath("docker")
-			if err != nil {
-				t.Errorf("Unable to find docker in PATH: %s", err)
-			}
+func Test_NameGenerators(t *testing.T) {
+	hashed := HashedNames("4b825dc642cb6eb9a060e54bf8d69288fbee4904")
 
-			out, err := exec.Command(dockerPath, "inspect", "-f", "{{.State.Pid }}", "testdckr").Output()
-			if err != nil {
-				td.teardown()
-				t.Fatalf("Failed to run tt.testCmd.Output(): %s", err)
+	for _, prefix := range []string{"veth", "br", "averylongprefix"} {
+		names := []string{RandomName(prefix, 0), hashed(prefix, 0), hashed(prefix, 1)}
+		for _, name := range names {
+			if ok, err := NetInterfaceNameValid(name); !ok {
+				t.Fatalf("name generator failed: %s", err)
 			}
+		}
 
-			actualPid, err := strconv.Atoi(strings.TrimSpace(string(out)))
-			if err != nil {
-				td.teardown()
-				t.Fatalf("Failed to run strconv.Atoi(strings.TrimSpace(string(%v))): %s",
-					out, err)
-			}
+		if names[1] != hashed(prefix, 0) || names[1] == names[2] {
+			t.Fatalf("HashedNames() failed: returned %v", names)
+		}
+	}
+
+	if name := hashed("veth", 0); len(name) != syscall.IFNAMSIZ-1 || !strings.HasPrefix(name, "veth") {
+		t.Fatalf("HashedNames() failed: returned %s", name)
+	}
+}
 
-			tt.pid = actualPid
+// racingBackend claims the name of the first created link, or its veth peer, before creating it
+// like a concurrent process would
+type racingBackend struct {
+	*FakeBackend
+	raced bool
+}
+
+func (b *racingBackend) LinkAdd(ctx context.Context, spec LinkSpec) error {
+	if !b.raced {
+		b.raced = true
+		name := spec.Name
+		if spec.PeerName != "" {
+			name = spec.PeerName
 		}
 
-		nsFd, err := NetNsHandle(tt.pid)
-		if nsFd == 0 {
-			if tt.expected != 0 {
-				td.teardown()
-				t.Fatalf("NetNsHandle(%d): expected: non-zero, returned: %v, error: %s",
-					tt.pid, nsFd, err)
-			}
+		if err := b.FakeBackend.LinkAdd(ctx, LinkSpec{Name: name, Kind: "dummy"}); err != nil {
+			return err
 		}
+	}
 
-		if nsFd != 0 {
-			if tt.expected == 0 {
-				td.teardown()
-				t.Fatalf("NetNsHandle(%d): expected: %d, returned: %v",
-					tt.pid, tt.expected, nsFd)
-			}
+	return b.FakeBackend.LinkAdd(ctx, spec)
+}
+
+func Test_CreateWithTakenName(t *testing.T) {
+	defer SetNameGenerator(SetNameGenerator(HashedNames("ctr01")))
+
+	creates := []func() (Linker, error){
+		func() (Linker, error) { return NewBridge() },
+		func() (Linker, error) { return NewVethPair() },
+		func() (Linker, error) { return NewVethPairWithOptions("vethname01", VethOptions{}) },
+		func() (Linker, error) { return NewVlanLinkWithOptions("lo", VlanOptions{Id: 10}) },
+		func() (Linker, error) { return NewMacVtapLinkWithOptions("lo", MacVlanOptions{MacAddr: "02:42:ac:11:00:02"}) },
+	}
+
+	for i, create := range creates {
+		fake := &racingBackend{FakeBackend: NewFakeBackend()}
+		prev := SetBackend(fake)
+
+		link, err := create()
+		SetBackend(prev)
+		if err != nil {
+			t.Fatalf("create %d failed: %s", i, err)
+		}
+
+		if !fake.raced {
+			t.Fatalf("create %d did not race", i)
 		}
 
-		if err := td.teardown(); err != nil {
-			t.Fatalf("testDocker.teardown failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
+		links, err := fake.LinkList(context.Background())
+		if err != nil {
+			t.Fatalf("LinkList() failed: %s", err)
 		}
+
+		// loopback, the link created by the other process and the new link
+		expected := 3
+		if _, ok := link.(Vether); ok {
+			expected = 4
+		}
+
+		if len(links) != expected || links[1].Kind != "dummy" {
+			t.Fatalf("create %d failed: returned %s, links %+v", i, link.NetInterface().Name, links)
+		}
+	}
+
+	// names supplied by the caller are never changed
+	defer SetBackend(SetBackend(&racingBackend{FakeBackend: NewFakeBackend()}))
+	if _, err := NewBridgeWithName("brname01"); !errors.Is(err, ErrLinkExists) {
+		t.Fatalf("NewBridgeWithName() failed: expected %v, returned %v", ErrLinkExists, err)
+	}
+}
+
+func Test_CreateWithLeftoverName(t *testing.T) {
+	hashed := HashedNames("ctr02")
+	defer SetNameGenerator(SetNameGenerator(hashed))
+
+	fake := NewFakeBackend()
+	defer SetBackend(SetBackend(fake))
+
+	// link left behind by a previous run with the same ID
+	if err := fake.LinkAdd(context.Background(), LinkSpec{Name: hashed("veth", 0), Kind: "dummy"}); err != nil {
+		t.Fatalf("LinkAdd() failed: %s", err)
+	}
+
+	veth, err := NewVethPair()
+	if err != nil {
+		t.Fatalf("NewVethPair() failed: %s", err)
+	}
+
+	name, peerName := veth.NetInterface().Name, veth.PeerNetInterface().Name
+	if name != hashed("veth", 1) || peerName != hashed("veth", 2) {
+		t.Fatalf("NewVethPair() failed: expected %s and %s, returned %s and %s",
+			hashed("veth", 1), hashed("veth", 2), name, peerName)
+	}
+}
+
+func Test_MakeNetInterfaceNameInNs(t *testing.T) {
+	fake := NewFakeBackend()
+	defer SetBackend(SetBackend(fake))
+	defer SetNameGenerator(SetNameGenerator(func(prefix string, n int) string {
+		return fmt.Sprintf("%s%d", prefix, n)
+	}))
+
+	if err := fake.LinkAdd(context.Background(), LinkSpec{Name: "veth0", Kind: "dummy"}); err != nil {
+		t.Fatalf("LinkAdd() failed: %s", err)
+	}
+
+	if err := NewNamedNetNs("nsname01"); err != nil {
+		t.Fatalf("NewNamedNetNs() failed: %s", err)
+	}
+
+	err := ExecInNamedNetNs("nsname01", func() error {
+		return fake.LinkAdd(context.Background(), LinkSpec{Name: "veth1", Kind: "dummy"})
+	})
+	if err != nil {
+		t.Fatalf("LinkAdd() failed: %s", err)
+	}
+
+	if name := MakeNetInterfaceName("veth"); name != "veth1" {
+		t.Fatalf("MakeNetInterfaceName() failed: expected veth1, returned %s", name)
+	}
+
+	name, err := MakeNetInterfaceNameInNs("veth", NetNsPath("nsname01"))
+	if err != nil || name != "veth2" {
+		t.Fatalf("MakeNetInterfaceNameInNs() failed: expected veth2, returned %s, %v", name, err)
+	}
+
+	if _, err := MakeNetInterfaceNameInNs("veth", NetNsPath("nsname02")); !errors.Is(err, ErrNsNotFound) {
+		t.Fatalf("MakeNetInterfaceNameInNs() failed: expected %v, returned %v", ErrNsNotFound, err)
+	}
+}
+
+func Test_SetLinkNsGeneratedName(t *testing.T) {
+	hashed := HashedNames("ctr03")
+	defer SetNameGenerator(SetNameGenerator(hashed))
+
+	fake := NewFakeBackend()
+	defer SetBackend(SetBackend(fake))
+
+	if err := NewNamedNetNs("nsname03"); err != nil {
+		t.Fatalf("NewNamedNetNs() failed: %s", err)
+	}
+
+	veth, err := NewVethPair()
+	if err != nil {
+		t.Fatalf("NewVethPair() failed: %s", err)
+	}
+
+	named, err := NewVethPairWithOptions("vethname03", VethOptions{PeerName: "vethname04"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	// links of the same names live in the target network namespace
+	taken := []string{veth.PeerNetInterface().Name, "vethname04"}
+	if err := ExecInNamedNetNs("nsname03", func() error {
+		for _, name := range taken {
+			if err := fake.LinkAdd(context.Background(), LinkSpec{Name: name, Kind: "dummy"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("LinkAdd() failed: %s", err)
+	}
+
+	if err := veth.SetPeerLinkNsFd(NetNsPath("nsname03")); err != nil {
+		t.Fatalf("SetPeerLinkNsFd() failed: %s", err)
+	}
+
+	peerName := veth.PeerNetInterface().Name
+	if peerName == taken[0] || peerName == veth.NetInterface().Name || !strings.HasPrefix(peerName, "veth") {
+		t.Fatalf("SetPeerLinkNsFd() failed to rename the peer link with generated name: %s", peerName)
+	}
+
+	if err := ExecInNamedNetNs("nsname03", func() error {
+		_, err := fake.LinkByName(context.Background(), peerName)
+		return err
+	}); err != nil {
+		t.Fatalf("SetPeerLinkNsFd() failed to move the peer link %s: %s", peerName, err)
+	}
+
+	// names supplied by the caller are never changed
+	if err := named.SetPeerLinkNsFd(NetNsPath("nsname03")); !errors.Is(err, syscall.EEXIST) {
+		t.Fatalf("SetPeerLinkNsFd() failed: expected %v, returned %v", syscall.EEXIST, err)
 	}
 }
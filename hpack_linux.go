@@ -0,0 +1,347 @@
+package tenus
+
+import (
+	"errors"
+	"fmt"
+)
+
+// hpackField is HTTP/2 header field
+type hpackField struct {
+	name  string
+	value string
+}
+
+// hpackDecoder decodes HPACK header blocks received on one HTTP/2 connection. RFC 7541.
+type hpackDecoder struct {
+	// dynamic table with the newest field first
+	dynamic []hpackField
+	// size of the dynamic table as defined by HPACK, i.e. including 32 octets of overhead per field
+	size int
+	// maximum size of the dynamic table
+	maxSize int
+}
+
+// hpackMaxTableSize is the dynamic table size the peer may use unless it's told otherwise
+const hpackMaxTableSize = 4096
+
+var errHpackTruncated = errors.New("truncated HPACK header block")
+
+// newHpackDecoder returns hpackDecoder with the default dynamic table size
+func newHpackDecoder() *hpackDecoder {
+	return &hpackDecoder{maxSize: hpackMaxTableSize}
+}
+
+// decode decodes the header block and returns its header fields in order
+func (d *hpackDecoder) decode(block []byte) ([]hpackField, error) {
+	var fields []hpackField
+	for len(block) > 0 {
+		b := block[0]
+		switch {
+		case b&0x80 != 0:
+			// indexed header field
+			index, rest, err := readHpackInt(block, 7)
+			if err != nil {
+				return nil, err
+			}
+
+			f, err := d.field(index)
+			if err != nil {
+				return nil, err
+			}
+
+			fields, block = append(fields, f), rest
+		case b&0xe0 == 0x20:
+			// dynamic table size update
+			size, rest, err := readHpackInt(block, 5)
+			if err != nil {
+				return nil, err
+			}
+
+			if size > hpackMaxTableSize {
+				return nil, fmt.Errorf("HPACK dynamic table size %d exceeds %d", size, hpackMaxTableSize)
+			}
+
+			d.maxSize, block = int(size), rest
+			d.evict()
+		default:
+			// literal header field with incremental indexing, without indexing or never indexed
+			prefix := uint(4)
+			if b&0x40 != 0 {
+				prefix = 6
+			}
+
+			index, rest, err := readHpackInt(block, prefix)
+			if err != nil {
+				return nil, err
+			}
+
+			var f hpackField
+			if index == 0 {
+				if f.name, rest, err = readHpackString(rest); err != nil {
+					return nil, err
+				}
+			} else {
+				named, err := d.field(index)
+				if err != nil {
+					return nil, err
+				}
+				f.name = named.name
+			}
+
+			if f.value, rest, err = readHpackString(rest); err != nil {
+				return nil, err
+			}
+
+			if b&0x40 != 0 {
+				d.add(f)
+			}
+
+			fields, block = append(fields, f), rest
+		}
+	}
+
+	return fields, nil
+}
+
+// field returns header field of the static or the dynamic table with the given index
+func (d *hpackDecoder) field(index uint64) (hpackField, error) {
+	switch {
+	case index == 0:
+		return hpackField{}, errors.New("invalid HPACK index 0")
+	case index <= uint64(len(hpackStaticTable)):
+		return hpackStaticTable[index-1], nil
+	case index-uint64(len(hpackStaticTable)) <= uint64(len(d.dynamic)):
+		return d.dynamic[index-uint64(len(hpackStaticTable))-1], nil
+	}
+
+	return hpackField{}, fmt.Errorf("invalid HPACK index %d", index)
+}
+
+// add inserts the header field to the dynamic table evicting the oldest fields which don't fit in it
+func (d *hpackDecoder) add(f hpackField) {
+	d.dynamic = append([]hpackField{f}, d.dynamic...)
+	d.size += len(f.name) + len(f.value) + 32
+	d.evict()
+}
+
+// evict removes the oldest fields until the dynamic table fits its maximum size
+func (d *hpackDecoder) evict() {
+	for d.size > d.maxSize {
+		f := d.dynamic[len(d.dynamic)-1]
+		d.dynamic = d.dynamic[:len(d.dynamic)-1]
+		d.size -= len(f.name) + len(f.value) + 32
+	}
+}
+
+// readHpackInt reads HPACK integer with n-bit prefix and returns it together with the rest of b
+func readHpackInt(b []byte, n uint) (uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, nil, errHpackTruncated
+	}
+
+	max := uint64(1)<<n - 1
+	v := uint64(b[0]) & max
+	if v < max {
+		return v, b[1:], nil
+	}
+
+	for i, c := range b[1:] {
+		if i > 8 {
+			return 0, nil, errors.New("HPACK integer overflow")
+		}
+
+		v += uint64(c&0x7f) << (7 * uint(i))
+		if c&0x80 == 0 {
+			return v, b[i+2:], nil
+		}
+	}
+
+	return 0, nil, errHpackTruncated
+}
+
+// readHpackString reads HPACK string literal and returns it together with the rest of b
+func readHpackString(b []byte) (string, []byte, error) {
+	if len(b) == 0 {
+		return "", nil, errHpackTruncated
+	}
+
+	huffman := b[0]&0x80 != 0
+	n, rest, err := readHpackInt(b, 7)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if n > uint64(len(rest)) {
+		return "", nil, errHpackTruncated
+	}
+
+	s, rest := rest[:n], rest[n:]
+	if !huffman {
+		return string(s), rest, nil
+	}
+
+	decoded, err := decodeHpackHuffman(s)
+
+	return decoded, rest, err
+}
+
+// decodeHpackHuffman decodes Huffman encoded string. Header fields read by CRIClient are short,
+// so the codes are looked up by scanning the code table.
+func decodeHpackHuffman(b []byte) (string, error) {
+	var s []byte
+	var code uint32
+	var n uint8
+	for _, c := range b {
+		for i := 7; i >= 0; i-- {
+			code, n = code<<1|uint32(c>>uint(i)&1), n+1
+			if sym, ok := hpackHuffmanSymbol(code, n); ok {
+				s, code, n = append(s, sym), 0, 0
+				continue
+			}
+
+			// the longest code is EOS which must not be encoded
+			if n >= 30 {
+				return "", errors.New("invalid HPACK Huffman code")
+			}
+		}
+	}
+
+	// the string is padded by the most significant bits of EOS code, i.e. by up to 7 ones
+	if n > 7 || code != 1<<n-1 {
+		return "", errors.New("invalid HPACK Huffman padding")
+	}
+
+	return string(s), nil
+}
+
+// hpackHuffmanSymbol returns the octet with the given Huffman code of n bits
+func hpackHuffmanSymbol(code uint32, n uint8) (byte, bool) {
+	for sym, length := range hpackHuffmanCodeLen {
+		if length == n && hpackHuffmanCodes[sym] == code {
+			return byte(sym), true
+		}
+	}
+
+	return 0, false
+}
+
+// hpackStaticTable is HPACK static table of header fields indexed from 1. RFC 7541, Appendix A.
+var hpackStaticTable = [...]hpackField{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
+
+// hpackHuffmanCodes and hpackHuffmanCodeLen are HPACK Huffman codes of all octets and their lengths in bits.
+// RFC 7541, Appendix B.
+var hpackHuffmanCodes = [256]uint32{
+	0x1ff8, 0x7fffd8, 0xfffffe2, 0xfffffe3, 0xfffffe4, 0xfffffe5, 0xfffffe6, 0xfffffe7,
+	0xfffffe8, 0xffffea, 0x3ffffffc, 0xfffffe9, 0xfffffea, 0x3ffffffd, 0xfffffeb, 0xfffffec,
+	0xfffffed, 0xfffffee, 0xfffffef, 0xffffff0, 0xffffff1, 0xffffff2, 0x3ffffffe, 0xffffff3,
+	0xffffff4, 0xffffff5, 0xffffff6, 0xffffff7, 0xffffff8, 0xffffff9, 0xffffffa, 0xffffffb,
+	0x14, 0x3f8, 0x3f9, 0xffa, 0x1ff9, 0x15, 0xf8, 0x7fa,
+	0x3fa, 0x3fb, 0xf9, 0x7fb, 0xfa, 0x16, 0x17, 0x18,
+	0x0, 0x1, 0x2, 0x19, 0x1a, 0x1b, 0x1c, 0x1d,
+	0x1e, 0x1f, 0x5c, 0xfb, 0x7ffc, 0x20, 0xffb, 0x3fc,
+	0x1ffa, 0x21, 0x5d, 0x5e, 0x5f, 0x60, 0x61, 0x62,
+	0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a,
+	0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70, 0x71, 0x72,
+	0xfc, 0x73, 0xfd, 0x1ffb, 0x7fff0, 0x1ffc, 0x3ffc, 0x22,
+	0x7ffd, 0x3, 0x23, 0x4, 0x24, 0x5, 0x25, 0x26,
+	0x27, 0x6, 0x74, 0x75, 0x28, 0x29, 0x2a, 0x7,
+	0x2b, 0x76, 0x2c, 0x8, 0x9, 0x2d, 0x77, 0x78,
+	0x79, 0x7a, 0x7b, 0x7ffe, 0x7fc, 0x3ffd, 0x1ffd, 0xffffffc,
+	0xfffe6, 0x3fffd2, 0xfffe7, 0xfffe8, 0x3fffd3, 0x3fffd4, 0x3fffd5, 0x7fffd9,
+	0x3fffd6, 0x7fffda, 0x7fffdb, 0x7fffdc, 0x7fffdd, 0x7fffde, 0xffffeb, 0x7fffdf,
+	0xffffec, 0xffffed, 0x3fffd7, 0x7fffe0, 0xffffee, 0x7fffe1, 0x7fffe2, 0x7fffe3,
+	0x7fffe4, 0x1fffdc, 0x3fffd8, 0x7fffe5, 0x3fffd9, 0x7fffe6, 0x7fffe7, 0xffffef,
+	0x3fffda, 0x1fffdd, 0xfffe9, 0x3fffdb, 0x3fffdc, 0x7fffe8, 0x7fffe9, 0x1fffde,
+	0x7fffea, 0x3fffdd, 0x3fffde, 0xfffff0, 0x1fffdf, 0x3fffdf, 0x7fffeb, 0x7fffec,
+	0x1fffe0, 0x1fffe1, 0x3fffe0, 0x1fffe2, 0x7fffed, 0x3fffe1, 0x7fffee, 0x7fffef,
+	0xfffea, 0x3fffe2, 0x3fffe3, 0x3fffe4, 0x7ffff0, 0x3fffe5, 0x3fffe6, 0x7ffff1,
+	0x3ffffe0, 0x3ffffe1, 0xfffeb, 0x7fff1, 0x3fffe7, 0x7ffff2, 0x3fffe8, 0x1ffffec,
+	0x3ffffe2, 0x3ffffe3, 0x3ffffe4, 0x7ffffde, 0x7ffffdf, 0x3ffffe5, 0xfffff1, 0x1ffffed,
+	0x7fff2, 0x1fffe3, 0x3ffffe6, 0x7ffffe0, 0x7ffffe1, 0x3ffffe7, 0x7ffffe2, 0xfffff2,
+	0x1fffe4, 0x1fffe5, 0x3ffffe8, 0x3ffffe9, 0xffffffd, 0x7ffffe3, 0x7ffffe4, 0x7ffffe5,
+	0xfffec, 0xfffff3, 0xfffed, 0x1fffe6, 0x3fffe9, 0x1fffe7, 0x1fffe8, 0x7ffff3,
+	0x3fffea, 0x3fffeb, 0x1ffffee, 0x1ffffef, 0xfffff4, 0xfffff5, 0x3ffffea, 0x7ffff4,
+	0x3ffffeb, 0x7ffffe6, 0x3ffffec, 0x3ffffed, 0x7ffffe7, 0x7ffffe8, 0x7ffffe9, 0x7ffffea,
+	0x7ffffeb, 0xffffffe, 0x7ffffec, 0x7ffffed, 0x7ffffee, 0x7ffffef, 0x7fffff0, 0x3ffffee,
+}
+
+var hpackHuffmanCodeLen = [256]uint8{
+	13, 23, 28, 28, 28, 28, 28, 28, 28, 24, 30, 28, 28, 30, 28, 28,
+	28, 28, 28, 28, 28, 28, 30, 28, 28, 28, 28, 28, 28, 28, 28, 28,
+	6, 10, 10, 12, 13, 6, 8, 11, 10, 10, 8, 11, 8, 6, 6, 6,
+	5, 5, 5, 6, 6, 6, 6, 6, 6, 6, 7, 8, 15, 6, 12, 10,
+	13, 6, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7,
+	7, 7, 7, 7, 7, 7, 7, 7, 8, 7, 8, 13, 19, 13, 14, 6,
+	15, 5, 6, 5, 6, 5, 6, 6, 6, 5, 7, 7, 6, 6, 6, 5,
+	6, 7, 6, 5, 5, 6, 7, 7, 7, 7, 7, 15, 11, 14, 13, 28,
+	20, 22, 20, 20, 22, 22, 22, 23, 22, 23, 23, 23, 23, 23, 24, 23,
+	24, 24, 22, 23, 24, 23, 23, 23, 23, 21, 22, 23, 22, 23, 23, 24,
+	22, 21, 20, 22, 22, 23, 23, 21, 23, 22, 22, 24, 21, 22, 23, 23,
+	21, 21, 22, 21, 23, 22, 23, 23, 20, 22, 22, 22, 23, 22, 22, 23,
+	26, 26, 20, 19, 22, 23, 22, 25, 26, 26, 26, 27, 27, 26, 24, 25,
+	19, 21, 26, 27, 27, 26, 27, 24, 21, 21, 26, 26, 28, 27, 27, 27,
+	20, 24, 20, 21, 22, 21, 21, 23, 22, 22, 25, 25, 24, 24, 26, 23,
+	26, 27, 26, 26, 27, 27, 27, 27, 27, 28, 27, 27, 27, 27, 27, 26,
+}
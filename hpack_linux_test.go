@@ -0,0 +1,64 @@
+package tenus
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+type hpackTest struct {
+	block    string
+	expected []hpackField
+}
+
+// requests of RFC 7541, Appendix C.4 decoded by the same decoder
+var hpackTests = []hpackTest{
+	{"828684418cf1e3c2e5f23a6ba0ab90f4ff", []hpackField{
+		{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"}}},
+	{"828684be5886a8eb10649cbf", []hpackField{
+		{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"}, {"cache-control", "no-cache"}}},
+	{"828785bf408825a849e95ba97d7f8925a849e95bb8e8b4bf", []hpackField{
+		{":method", "GET"}, {":scheme", "https"}, {":path", "/index.html"}, {":authority", "www.example.com"}, {"custom-key", "custom-value"}}},
+}
+
+func Test_HpackDecoder(t *testing.T) {
+	d := newHpackDecoder()
+	for _, tt := range hpackTests {
+		block, _ := hex.DecodeString(tt.block)
+		fields, err := d.decode(block)
+		if err != nil {
+			t.Fatalf("decode(%s) failed: %s", tt.block, err)
+		}
+
+		if !reflect.DeepEqual(fields, tt.expected) {
+			t.Fatalf("decode(%s) failed: expected %v, returned %v", tt.block, tt.expected, fields)
+		}
+	}
+
+	if len(d.dynamic) != 3 || d.size != 164 {
+		t.Fatalf("decode() failed to update dynamic table: %v, size %d", d.dynamic, d.size)
+	}
+
+	// dynamic table size update evicts all fields
+	if fields, err := d.decode([]byte{0x20}); err != nil || len(fields) != 0 || len(d.dynamic) != 0 {
+		t.Fatalf("decode() failed to evict dynamic table: %v, %v", d.dynamic, err)
+	}
+
+	for _, block := range []string{
+		// index 0
+		"80",
+		// index beyond the dynamic table
+		"be",
+		// truncated literal
+		"400a637573746f6d",
+		// Huffman padding longer than 7 bits
+		"0088ff",
+		// table size update larger than allowed
+		"3fe21f",
+	} {
+		b, _ := hex.DecodeString(block)
+		if _, err := newHpackDecoder().decode(b); err == nil {
+			t.Errorf("decode(%s) expected to fail", block)
+		}
+	}
+}
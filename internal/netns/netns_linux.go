@@ -0,0 +1,34 @@
+// Package netns switches threads between network namespaces.
+// It's shared by tenus and tenustest, which don't depend on each other.
+package netns
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// setns syscall numbers; syscall package does not export SYS_SETNS on all architectures
+var sysSetns = map[string]uintptr{
+	"386":     346,
+	"amd64":   308,
+	"arm":     375,
+	"arm64":   268,
+	"ppc64":   350,
+	"ppc64le": 350,
+	"s390x":   339,
+}
+
+// Set switches the calling thread to network namespace referred to by the file descriptor.
+func Set(fd int) error {
+	trap, ok := sysSetns[runtime.GOARCH]
+	if !ok {
+		return fmt.Errorf("Unsupported platform: %s", runtime.GOARCH)
+	}
+
+	if _, _, errno := syscall.RawSyscall(trap, uintptr(fd), syscall.CLONE_NEWNET, 0); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
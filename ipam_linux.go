@@ -0,0 +1,643 @@
+package tenus
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// DefaultIPAMStore is the path of the file IPAM keeps its state in if IPAM.Store is empty
+const DefaultIPAMStore = "/var/lib/tenus/ipam.json"
+
+// Pool is IPv4 or IPv6 subnet IPAM allocates IP addresses from.
+// Pool can be serialized to JSON or YAML.
+type Pool struct {
+	// Pool name
+	Name string `json:"name" yaml:"name"`
+	// Subnet in CIDR notation
+	Subnet string `json:"subnet" yaml:"subnet"`
+	// Gateway address which is never allocated. The first host address of the subnet is used if empty.
+	Gateway string `json:"gateway,omitempty" yaml:"gateway,omitempty"`
+	// Reserved addresses which are never allocated: single IP addresses, START-END ranges or subnets in CIDR notation
+	Reserved []string `json:"reserved,omitempty" yaml:"reserved,omitempty"`
+}
+
+// Allocation is an IP address allocated from IPAM pool.
+type Allocation struct {
+	// IP address in CIDR notation with the pool's network mask
+	Addr string `json:"addr" yaml:"addr"`
+	// Owner ID the address was allocated to, e.g. container ID
+	Owner string `json:"owner" yaml:"owner"`
+}
+
+// IPAM allocates IP addresses from subnet pools to owners, e.g. containers or links.
+//
+// Each owner is allocated at most one IP address from a pool: allocating an address again returns
+// the address the owner already has. Addresses are allocated sequentially, so released addresses are
+// not reused until the rest of the pool is allocated. Network and IPv4 broadcast addresses of subnets
+// with more than two addresses, the gateway and reserved addresses are never allocated.
+//
+// The state is kept in a JSON file. Every operation locks the file, so multiple processes
+// on a host can safely share the pools.
+type IPAM struct {
+	// Store is path of the state file. DefaultIPAMStore is used if empty.
+	// The file is locked via ${Store}.lock file.
+	Store string
+}
+
+// ipamState is the content of IPAM state file
+type ipamState struct {
+	Pools map[string]*poolState `json:"pools"`
+}
+
+// poolState is IPAM pool together with its allocations
+type poolState struct {
+	Pool
+	// Last allocated address; the next allocation starts right after it
+	Last string `json:"last,omitempty"`
+	// Owners of the allocated addresses by the address
+	Allocations map[string]string `json:"allocations,omitempty"`
+
+	subnet   *net.IPNet
+	gateway  net.IP
+	reserved []ipRange
+}
+
+// ipRange is an inclusive range of IP addresses
+type ipRange struct {
+	start, end *big.Int
+}
+
+// AddPool adds the pool to IPAM.
+// It returns ErrPoolExists if a pool of the same name exists and error if the pool is invalid or its subnet
+// overlaps with the subnet of another pool.
+func (m *IPAM) AddPool(pool Pool) error {
+	p := &poolState{Pool: pool}
+	if err := p.parse(); err != nil {
+		return err
+	}
+
+	return m.update(func(state *ipamState) error {
+		if _, ok := state.Pools[pool.Name]; ok {
+			return fmt.Errorf("%w: %s", ErrPoolExists, pool.Name)
+		}
+
+		for _, other := range state.Pools {
+			if other.subnet.Contains(p.subnet.IP) || p.subnet.Contains(other.subnet.IP) {
+				return fmt.Errorf("Pool %s subnet %s overlaps with pool %s subnet %s", pool.Name, pool.Subnet, other.Name, other.Subnet)
+			}
+		}
+
+		state.Pools[pool.Name] = p
+		return nil
+	})
+}
+
+// DelPool deletes the pool from IPAM.
+// It returns ErrPoolNotFound if the pool does not exist and error if any of its addresses are allocated.
+func (m *IPAM) DelPool(name string) error {
+	return m.update(func(state *ipamState) error {
+		p, err := state.pool(name)
+		if err != nil {
+			return err
+		}
+
+		if len(p.Allocations) > 0 {
+			return fmt.Errorf("Pool %s has %d allocated addresses", name, len(p.Allocations))
+		}
+
+		delete(state.Pools, name)
+		return nil
+	})
+}
+
+// Pools returns all IPAM pools sorted by name.
+func (m *IPAM) Pools() ([]Pool, error) {
+	var pools []Pool
+	err := m.view(func(state *ipamState) error {
+		for _, p := range state.Pools {
+			pools = append(pools, p.Pool)
+		}
+		return nil
+	})
+
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Name < pools[j].Name })
+
+	return pools, err
+}
+
+// Gateway returns the gateway address of the pool and the pool's network.
+// It returns ErrPoolNotFound if the pool does not exist.
+func (m *IPAM) Gateway(pool string) (net.IP, *net.IPNet, error) {
+	var gw net.IP
+	var network *net.IPNet
+	err := m.view(func(state *ipamState) error {
+		p, err := state.pool(pool)
+		if err != nil {
+			return err
+		}
+
+		gw, network = p.gateway, p.subnet
+		return nil
+	})
+
+	return gw, network, err
+}
+
+// Allocate allocates an IP address from the pool to the owner.
+// It returns the address and the pool's network, so they can be passed to SetLinkIp.
+// If the owner already has an address from the pool, the address is returned.
+// It returns ErrPoolNotFound if the pool does not exist and ErrPoolExhausted if the pool has no free address.
+func (m *IPAM) Allocate(pool, owner string) (net.IP, *net.IPNet, error) {
+	l, err := m.allocate(pool, owner, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return l.ip, l.network, nil
+}
+
+// AllocateIP allocates the given IP address from the pool to the owner.
+// It returns ErrAddrInUse if the address is allocated to another owner, reserved or outside of the pool
+// and error if the owner already has a different address from the pool.
+func (m *IPAM) AllocateIP(pool, owner string, ip net.IP) (net.IP, *net.IPNet, error) {
+	if ip == nil {
+		return nil, nil, fmt.Errorf("Could not allocate address from pool %s: no IP address given", pool)
+	}
+
+	l, err := m.allocate(pool, owner, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return l.ip, l.network, nil
+}
+
+// lease is IP address allocated by IPAM
+type lease struct {
+	ip      net.IP
+	network *net.IPNet
+	gateway net.IP
+	// the owner had the address allocated before
+	existing bool
+}
+
+// allocate allocates the IP address, or the next free address if ip is nil, from the pool to the owner
+func (m *IPAM) allocate(pool, owner string, ip net.IP) (*lease, error) {
+	if owner == "" {
+		return nil, fmt.Errorf("Could not allocate address from pool %s: empty owner", pool)
+	}
+
+	l := &lease{}
+	err := m.update(func(state *ipamState) error {
+		p, err := state.pool(pool)
+		if err != nil {
+			return err
+		}
+		l.network, l.gateway = p.subnet, p.gateway
+
+		if addr := p.ownerAddr(owner); addr != nil {
+			if ip != nil && !ip.Equal(addr) {
+				return fmt.Errorf("Owner %s already has address %s from pool %s", owner, addr, pool)
+			}
+
+			l.ip, l.existing = normalizeIP(addr), true
+			return nil
+		}
+
+		if ip == nil {
+			if ip, err = p.next(); err != nil {
+				return err
+			}
+		} else if !p.available(ipToInt(ip)) {
+			return fmt.Errorf("%w: %s in pool %s", ErrAddrInUse, ip, pool)
+		}
+
+		l.ip = normalizeIP(ip)
+		p.Allocations[l.ip.String()] = owner
+		p.Last = l.ip.String()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Release releases the IP address the owner was allocated from the pool.
+// It returns ErrPoolNotFound if the pool does not exist. Releasing an owner without an address succeeds.
+func (m *IPAM) Release(pool, owner string) error {
+	return m.update(func(state *ipamState) error {
+		p, err := state.pool(pool)
+		if err != nil {
+			return err
+		}
+
+		for addr, o := range p.Allocations {
+			if o == owner {
+				delete(p.Allocations, addr)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Allocations returns IP addresses allocated from the pool sorted by address.
+// It returns ErrPoolNotFound if the pool does not exist.
+func (m *IPAM) Allocations(pool string) ([]Allocation, error) {
+	var allocations []Allocation
+	err := m.view(func(state *ipamState) error {
+		p, err := state.pool(pool)
+		if err != nil {
+			return err
+		}
+
+		addrs := make([]net.IP, 0, len(p.Allocations))
+		for addr := range p.Allocations {
+			addrs = append(addrs, net.ParseIP(addr))
+		}
+
+		sort.Slice(addrs, func(i, j int) bool { return ipToInt(addrs[i]).Cmp(ipToInt(addrs[j])) < 0 })
+
+		ones, _ := p.subnet.Mask.Size()
+		for _, addr := range addrs {
+			ip := normalizeIP(addr)
+			allocations = append(allocations, Allocation{
+				Addr:  fmt.Sprintf("%s/%d", ip, ones),
+				Owner: p.Allocations[ip.String()],
+			})
+		}
+
+		return nil
+	})
+
+	return allocations, err
+}
+
+// AssignLinkIp allocates an IP address from the pool to the owner and assigns it to the link.
+// The address is released if it could not be assigned, unless the owner had it allocated before.
+func (m *IPAM) AssignLinkIp(link Linker, pool, owner string) (net.IP, *net.IPNet, error) {
+	return m.assign(pool, owner, func(ip net.IP, network *net.IPNet, gw net.IP) error {
+		return link.SetLinkIp(ip, network)
+	})
+}
+
+// AssignLinkNetInNs allocates an IP address from the pool to the owner and configures it on the link
+// in network namespace specified by PID together with the pool's gateway as the default gateway.
+// The address is released if it could not be configured, unless the owner had it allocated before.
+func (m *IPAM) AssignLinkNetInNs(link Linker, nspid int, pool, owner string) (net.IP, *net.IPNet, error) {
+	return m.assign(pool, owner, func(ip net.IP, network *net.IPNet, gw net.IP) error {
+		return link.SetLinkNetInNs(nspid, ip, network, &gw)
+	})
+}
+
+// AssignPeerLinkNetInNs allocates an IP address from the pool to the owner and configures it on the veth peer link
+// in network namespace specified by PID together with the pool's gateway as the default gateway.
+// The address is released if it could not be configured, unless the owner had it allocated before.
+func (m *IPAM) AssignPeerLinkNetInNs(veth Vether, nspid int, pool, owner string) (net.IP, *net.IPNet, error) {
+	return m.assign(pool, owner, func(ip net.IP, network *net.IPNet, gw net.IP) error {
+		return veth.SetPeerLinkNetInNs(nspid, ip, network, &gw)
+	})
+}
+
+// assign allocates an IP address from the pool to the owner and configures it with fn
+func (m *IPAM) assign(pool, owner string, fn func(net.IP, *net.IPNet, net.IP) error) (net.IP, *net.IPNet, error) {
+	l, err := m.allocate(pool, owner, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := fn(l.ip, l.network, l.gateway); err != nil {
+		if !l.existing {
+			m.Release(pool, owner)
+		}
+		return nil, nil, err
+	}
+
+	return l.ip, l.network, nil
+}
+
+// view runs fn with the IPAM state while holding shared lock of the state file
+func (m *IPAM) view(fn func(*ipamState) error) error {
+	return m.locked(syscall.LOCK_SH, func() error {
+		state, err := m.load()
+		if err != nil {
+			return err
+		}
+
+		return fn(state)
+	})
+}
+
+// update runs fn with the IPAM state while holding exclusive lock of the state file.
+// The state is saved if fn succeeds.
+func (m *IPAM) update(fn func(*ipamState) error) error {
+	return m.locked(syscall.LOCK_EX, func() error {
+		state, err := m.load()
+		if err != nil {
+			return err
+		}
+
+		if err := fn(state); err != nil {
+			return err
+		}
+
+		return m.save(state)
+	})
+}
+
+// store returns path of the state file
+func (m *IPAM) store() string {
+	if m.Store == "" {
+		return DefaultIPAMStore
+	}
+
+	return m.Store
+}
+
+// locked runs fn while holding the lock of the state file
+func (m *IPAM) locked(how int, fn func() error) error {
+	if err := os.MkdirAll(filepath.Dir(m.store()), 0755); err != nil {
+		return fmt.Errorf("Could not create IPAM store directory: %s", err)
+	}
+
+	f, err := os.OpenFile(m.store()+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("Could not open IPAM lock file: %s", err)
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		return fmt.Errorf("Could not lock IPAM store: %s", err)
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	return fn()
+}
+
+// load reads the IPAM state from the state file. Missing state file is empty state.
+func (m *IPAM) load() (*ipamState, error) {
+	state := &ipamState{}
+
+	data, err := ioutil.ReadFile(m.store())
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, fmt.Errorf("Could not read IPAM store: %s", err)
+	default:
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, fmt.Errorf("Could not decode IPAM store %s: %s", m.store(), err)
+		}
+	}
+
+	if state.Pools == nil {
+		state.Pools = make(map[string]*poolState)
+	}
+
+	for name, p := range state.Pools {
+		p.Name = name
+		if err := p.parse(); err != nil {
+			return nil, fmt.Errorf("Invalid pool in IPAM store %s: %s", m.store(), err)
+		}
+	}
+
+	return state, nil
+}
+
+// save atomically replaces the state file with the IPAM state
+func (m *IPAM) save(state *ipamState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Could not encode IPAM store: %s", err)
+	}
+
+	tmp := m.store() + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("Could not write IPAM store: %s", err)
+	}
+
+	if err := os.Rename(tmp, m.store()); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("Could not write IPAM store: %s", err)
+	}
+
+	return nil
+}
+
+// pool returns the pool of the given name
+func (state *ipamState) pool(name string) (*poolState, error) {
+	p, ok := state.Pools[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, name)
+	}
+
+	return p, nil
+}
+
+// parse validates the pool and parses its subnet, gateway and reserved addresses
+func (p *poolState) parse() error {
+	if p.Name == "" {
+		return fmt.Errorf("Invalid pool: empty name")
+	}
+
+	ip, subnet, err := net.ParseCIDR(p.Subnet)
+	if err != nil {
+		return fmt.Errorf("Invalid pool %s subnet: %s", p.Name, err)
+	}
+
+	if !ip.Equal(subnet.IP) {
+		return fmt.Errorf("Invalid pool %s subnet %s: host bits are set", p.Name, p.Subnet)
+	}
+	p.subnet = subnet
+
+	lo, hi := p.hosts()
+	if p.Gateway == "" {
+		p.gateway = intToIP(lo, len(subnet.IP))
+	} else if p.gateway = net.ParseIP(p.Gateway); p.gateway == nil || !p.contains(ipToInt(p.gateway), lo, hi) {
+		return fmt.Errorf("Invalid pool %s gateway %s: not a host address of subnet %s", p.Name, p.Gateway, p.Subnet)
+	}
+	p.gateway = normalizeIP(p.gateway)
+
+	p.reserved = []ipRange{{ipToInt(p.gateway), ipToInt(p.gateway)}}
+	for _, s := range p.Reserved {
+		r, err := parseIPRange(s)
+		if err != nil {
+			return fmt.Errorf("Invalid pool %s reserved addresses: %s", p.Name, err)
+		}
+
+		if !subnet.Contains(intToIP(r.start, len(subnet.IP))) || !subnet.Contains(intToIP(r.end, len(subnet.IP))) {
+			return fmt.Errorf("Invalid pool %s reserved addresses %s: not in subnet %s", p.Name, s, p.Subnet)
+		}
+		p.reserved = append(p.reserved, r)
+	}
+
+	if p.Allocations == nil {
+		p.Allocations = make(map[string]string)
+	}
+
+	return nil
+}
+
+// hosts returns the first and the last allocatable address of the pool's subnet
+func (p *poolState) hosts() (*big.Int, *big.Int) {
+	ones, bits := p.subnet.Mask.Size()
+	lo := ipToInt(p.subnet.IP)
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	hi := new(big.Int).Add(lo, size)
+	hi.Sub(hi, big.NewInt(1))
+
+	// subnets of one or two addresses have no network and broadcast addresses
+	if bits-ones < 2 {
+		return lo, hi
+	}
+
+	lo = new(big.Int).Add(lo, big.NewInt(1))
+	if bits == 32 {
+		hi.Sub(hi, big.NewInt(1))
+	}
+
+	return lo, hi
+}
+
+// contains returns true if n is between lo and hi inclusive
+func (p *poolState) contains(n, lo, hi *big.Int) bool {
+	return n.Cmp(lo) >= 0 && n.Cmp(hi) <= 0
+}
+
+// reservedEnd returns the end of the reserved range containing n or nil if n is not reserved
+func (p *poolState) reservedEnd(n *big.Int) *big.Int {
+	for _, r := range p.reserved {
+		if p.contains(n, r.start, r.end) {
+			return r.end
+		}
+	}
+
+	return nil
+}
+
+// available returns true if n is allocatable address of the pool which is neither reserved nor allocated
+func (p *poolState) available(n *big.Int) bool {
+	lo, hi := p.hosts()
+	if !p.contains(n, lo, hi) || p.reservedEnd(n) != nil {
+		return false
+	}
+
+	_, ok := p.Allocations[intToIP(n, len(p.subnet.IP)).String()]
+	return !ok
+}
+
+// next returns the first available address following the last allocated address.
+// It returns ErrPoolExhausted if there is no available address.
+func (p *poolState) next() (net.IP, error) {
+	lo, hi := p.hosts()
+	start := lo
+	if last := net.ParseIP(p.Last); last != nil && p.contains(ipToInt(last), lo, hi) {
+		start = new(big.Int).Add(ipToInt(last), big.NewInt(1))
+	}
+
+	one := big.NewInt(1)
+	n := new(big.Int).Set(start)
+	wrapped := false
+	for {
+		if n.Cmp(hi) > 0 {
+			if wrapped {
+				break
+			}
+			wrapped = true
+			n.Set(lo)
+		}
+
+		if wrapped && n.Cmp(start) >= 0 {
+			break
+		}
+
+		if end := p.reservedEnd(n); end != nil {
+			n.Add(end, one)
+			continue
+		}
+
+		if p.available(n) {
+			return intToIP(n, len(p.subnet.IP)), nil
+		}
+
+		n.Add(n, one)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrPoolExhausted, p.Name)
+}
+
+// ownerAddr returns the address allocated to the owner or nil if the owner has no address
+func (p *poolState) ownerAddr(owner string) net.IP {
+	for addr, o := range p.Allocations {
+		if o == owner {
+			return net.ParseIP(addr)
+		}
+	}
+
+	return nil
+}
+
+// parseIPRange parses single IP address, START-END range or subnet in CIDR notation
+func parseIPRange(s string) (ipRange, error) {
+	if _, network, err := net.ParseCIDR(s); err == nil {
+		ones, bits := network.Mask.Size()
+		start := ipToInt(network.IP)
+		end := new(big.Int).Add(start, new(big.Int).Lsh(big.NewInt(1), uint(bits-ones)))
+
+		return ipRange{start, end.Sub(end, big.NewInt(1))}, nil
+	}
+
+	parts := strings.SplitN(s, "-", 2)
+	start := net.ParseIP(strings.TrimSpace(parts[0]))
+	end := start
+	if len(parts) == 2 {
+		end = net.ParseIP(strings.TrimSpace(parts[1]))
+	}
+
+	if start == nil || end == nil || (start.To4() == nil) != (end.To4() == nil) {
+		return ipRange{}, fmt.Errorf("invalid IP address range %q", s)
+	}
+
+	r := ipRange{ipToInt(start), ipToInt(end)}
+	if r.start.Cmp(r.end) > 0 {
+		return ipRange{}, fmt.Errorf("invalid IP address range %q: start is after end", s)
+	}
+
+	return r, nil
+}
+
+// normalizeIP returns 4 byte representation of IPv4 addresses
+func normalizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+
+	return ip
+}
+
+// ipToInt returns IP address as integer
+func ipToInt(ip net.IP) *big.Int {
+	return new(big.Int).SetBytes(normalizeIP(ip))
+}
+
+// intToIP returns integer as IP address of the given length
+func intToIP(n *big.Int, size int) net.IP {
+	b := n.Bytes()
+	if len(b) > size {
+		b = b[len(b)-size:]
+	}
+
+	ip := make(net.IP, size)
+	copy(ip[size-len(b):], b)
+
+	return ip
+}
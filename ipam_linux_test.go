@@ -0,0 +1,282 @@
+package tenus
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// newTestIPAM returns IPAM keeping its state in a temporary directory
+func newTestIPAM(t *testing.T) (*IPAM, func()) {
+	dir, err := ioutil.TempDir("", "tenus")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+
+	return &IPAM{Store: filepath.Join(dir, "ipam", "ipam.json")}, func() { os.RemoveAll(dir) }
+}
+
+type poolTest struct {
+	pool Pool
+	err  error
+}
+
+var poolTests = []poolTest{
+	{Pool{Name: "v4", Subnet: "10.0.0.0/29", Reserved: []string{"10.0.0.3-10.0.0.4"}}, nil},
+	{Pool{Name: "v6", Subnet: "fd00::/120", Gateway: "fd00::ff", Reserved: []string{"fd00::1", "fd00::10/124"}}, nil},
+	{Pool{Name: "v4", Subnet: "10.1.0.0/24"}, ErrPoolExists},
+	{Pool{Name: "overlap", Subnet: "10.0.0.0/16"}, nil},
+	{Pool{Name: "", Subnet: "10.2.0.0/24"}, nil},
+	{Pool{Name: "subnet", Subnet: "10.2.0.0"}, nil},
+	{Pool{Name: "hostbits", Subnet: "10.2.0.1/24"}, nil},
+	{Pool{Name: "gateway", Subnet: "10.2.0.0/24", Gateway: "10.2.0.255"}, nil},
+	{Pool{Name: "gateway", Subnet: "10.2.0.0/24", Gateway: "10.3.0.1"}, nil},
+	{Pool{Name: "reserved", Subnet: "10.2.0.0/24", Reserved: []string{"10.2.0.10-10.3.0.1"}}, nil},
+	{Pool{Name: "reserved", Subnet: "10.2.0.0/24", Reserved: []string{"10.2.0.10-10.2.0.1"}}, nil},
+	{Pool{Name: "reserved", Subnet: "10.2.0.0/24", Reserved: []string{"10.2.0.10-fd00::1"}}, nil},
+}
+
+func Test_IPAMPools(t *testing.T) {
+	ipam, cleanup := newTestIPAM(t)
+	defer cleanup()
+
+	for i, tt := range poolTests {
+		err := ipam.AddPool(tt.pool)
+		switch {
+		case i < 2 && err != nil:
+			t.Fatalf("AddPool(%+v) failed: %s", tt.pool, err)
+		case i >= 2 && (err == nil || tt.err != nil && !errors.Is(err, tt.err)):
+			t.Errorf("AddPool(%+v) failed: expected error %v, returned %v", tt.pool, tt.err, err)
+		}
+	}
+
+	pools, err := ipam.Pools()
+	if err != nil || !reflect.DeepEqual(pools, []Pool{poolTests[0].pool, poolTests[1].pool}) {
+		t.Fatalf("Pools() failed: returned %+v, %v", pools, err)
+	}
+
+	gw, network, err := ipam.Gateway("v4")
+	if err != nil || gw.String() != "10.0.0.1" || network.String() != "10.0.0.0/29" {
+		t.Fatalf("Gateway() failed: returned %s, %s, %v", gw, network, err)
+	}
+
+	if _, _, err := ipam.Allocate("v6", "ctr1"); err != nil {
+		t.Fatalf("Allocate() failed: %s", err)
+	}
+
+	if err := ipam.DelPool("v6"); err == nil {
+		t.Fatalf("DelPool() expected to fail with allocated addresses")
+	}
+
+	if err := ipam.Release("v6", "ctr1"); err != nil {
+		t.Fatalf("Release() failed: %s", err)
+	}
+
+	if err := ipam.DelPool("v6"); err != nil {
+		t.Fatalf("DelPool() failed: %s", err)
+	}
+
+	if err := ipam.DelPool("v6"); !errors.Is(err, ErrPoolNotFound) {
+		t.Fatalf("DelPool() failed: expected %v, returned %v", ErrPoolNotFound, err)
+	}
+}
+
+type allocateTest struct {
+	pool  string
+	owner string
+	ip    string
+	addr  string
+	err   error
+}
+
+var allocateTests = []allocateTest{
+	// gateway 10.0.0.1 and 10.0.0.3-10.0.0.4 are reserved
+	{"v4", "ctr1", "", "10.0.0.2", nil},
+	{"v4", "ctr1", "", "10.0.0.2", nil},
+	{"v4", "ctr2", "", "10.0.0.5", nil},
+	{"v4", "ctr3", "10.0.0.5", "", ErrAddrInUse},
+	{"v4", "ctr3", "10.0.0.3", "", ErrAddrInUse},
+	{"v4", "ctr3", "10.0.0.7", "", ErrAddrInUse},
+	{"v4", "ctr3", "10.0.1.6", "", ErrAddrInUse},
+	{"v4", "ctr3", "10.0.0.6", "10.0.0.6", nil},
+	{"v4", "ctr3", "10.0.0.2", "", nil},
+	{"v4", "ctr4", "", "", ErrPoolExhausted},
+	{"v6", "ctr1", "", "fd00::2", nil},
+	{"v6", "ctr2", "fd00::12", "", ErrAddrInUse},
+	{"v6", "ctr2", "10.0.0.2", "", ErrAddrInUse},
+	{"v6", "ctr2", "fd00::20", "fd00::20", nil},
+	{"v6", "ctr3", "", "fd00::21", nil},
+	{"none", "ctr1", "", "", ErrPoolNotFound},
+	{"v4", "", "", "", nil},
+}
+
+func Test_IPAMAllocate(t *testing.T) {
+	ipam, cleanup := newTestIPAM(t)
+	defer cleanup()
+
+	for _, pool := range poolTests[:2] {
+		if err := ipam.AddPool(pool.pool); err != nil {
+			t.Fatalf("AddPool() failed: %s", err)
+		}
+	}
+
+	for _, tt := range allocateTests {
+		var ip net.IP
+		var err error
+		if tt.ip == "" {
+			ip, _, err = ipam.Allocate(tt.pool, tt.owner)
+		} else {
+			ip, _, err = ipam.AllocateIP(tt.pool, tt.owner, net.ParseIP(tt.ip))
+		}
+
+		if tt.addr == "" {
+			if err == nil || tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("Allocate(%s, %s, %s) failed: expected error %v, returned %s, %v", tt.pool, tt.owner, tt.ip, tt.err, ip, err)
+			}
+			continue
+		}
+
+		if err != nil || ip.String() != tt.addr {
+			t.Errorf("Allocate(%s, %s, %s) failed: expected %s, returned %s, %v", tt.pool, tt.owner, tt.ip, tt.addr, ip, err)
+		}
+	}
+
+	allocations, err := ipam.Allocations("v4")
+	expected := []Allocation{{"10.0.0.2/29", "ctr1"}, {"10.0.0.5/29", "ctr2"}, {"10.0.0.6/29", "ctr3"}}
+	if err != nil || !reflect.DeepEqual(allocations, expected) {
+		t.Fatalf("Allocations() failed: expected %+v, returned %+v, %v", expected, allocations, err)
+	}
+
+	// released addresses are reused once the rest of the pool is allocated
+	if err := ipam.Release("v4", "ctr2"); err != nil {
+		t.Fatalf("Release() failed: %s", err)
+	}
+
+	if ip, network, err := ipam.Allocate("v4", "ctr4"); err != nil || ip.String() != "10.0.0.5" || network.String() != "10.0.0.0/29" {
+		t.Fatalf("Allocate() failed: expected 10.0.0.5, returned %s, %s, %v", ip, network, err)
+	}
+
+	if err := ipam.Release("v4", "ctr2"); err != nil {
+		t.Fatalf("Release() of owner without address failed: %s", err)
+	}
+
+	// state is shared by IPAM instances using the same store
+	allocations, err = (&IPAM{Store: ipam.Store}).Allocations("v6")
+	expected = []Allocation{{"fd00::2/120", "ctr1"}, {"fd00::20/120", "ctr2"}, {"fd00::21/120", "ctr3"}}
+	if err != nil || !reflect.DeepEqual(allocations, expected) {
+		t.Fatalf("Allocations() failed: expected %+v, returned %+v, %v", expected, allocations, err)
+	}
+}
+
+func Test_IPAMConcurrentAllocate(t *testing.T) {
+	ipam, cleanup := newTestIPAM(t)
+	defer cleanup()
+
+	if err := ipam.AddPool(Pool{Name: "pool", Subnet: "10.0.0.0/26"}); err != nil {
+		t.Fatalf("AddPool() failed: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	addrs := make([]string, 32)
+	for i := range addrs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			ip, _, err := (&IPAM{Store: ipam.Store}).Allocate("pool", fmt.Sprintf("ctr%d", i))
+			if err != nil {
+				t.Errorf("Allocate() failed: %s", err)
+				return
+			}
+			addrs[i] = ip.String()
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for _, addr := range addrs {
+		if seen[addr] {
+			t.Fatalf("Allocate() allocated %s twice", addr)
+		}
+		seen[addr] = true
+	}
+
+	if allocations, err := ipam.Allocations("pool"); err != nil || len(allocations) != len(addrs) {
+		t.Fatalf("Allocations() failed: returned %d allocations, %v", len(allocations), err)
+	}
+}
+
+func Test_IPAMAssignLinkIp(t *testing.T) {
+	defer SetBackend(SetBackend(NewFakeBackend()))
+
+	ipam, cleanup := newTestIPAM(t)
+	defer cleanup()
+
+	if err := ipam.AddPool(Pool{Name: "pool", Subnet: "10.0.0.0/24"}); err != nil {
+		t.Fatalf("AddPool() failed: %s", err)
+	}
+
+	veth, err := NewVethPairWithOptions("vethipam01", VethOptions{PeerName: "vethipam02"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	ip, network, err := ipam.AssignLinkIp(veth, "pool", "ctr1")
+	if err != nil || ip.String() != "10.0.0.2" {
+		t.Fatalf("AssignLinkIp() failed: returned %s, %v", ip, err)
+	}
+
+	addrs, err := LinkAddrsByName("vethipam01")
+	if err != nil || len(addrs) != 1 || addrs[0].String() != "10.0.0.2/24" || network.String() != "10.0.0.0/24" {
+		t.Fatalf("AssignLinkIp() failed: link addresses %v, %v", addrs, err)
+	}
+
+	if err := backend.NsCreate("/proc/4321/ns/net"); err != nil {
+		t.Fatalf("NsCreate() failed: %s", err)
+	}
+
+	if err := veth.SetPeerLinkNsPid(4321); err != nil {
+		t.Fatalf("SetPeerLinkNsPid() failed: %s", err)
+	}
+
+	if ip, _, err := ipam.AssignPeerLinkNetInNs(veth, 4321, "pool", "ctr2"); err != nil || ip.String() != "10.0.0.3" {
+		t.Fatalf("AssignPeerLinkNetInNs() failed: returned %s, %v", ip, err)
+	}
+
+	err = execInNetNsPath("/proc/4321/ns/net", func() error {
+		routes, err := RouteList()
+		if err != nil || len(routes) != 1 || routes[0].Gw.String() != "10.0.0.1" {
+			t.Errorf("AssignPeerLinkNetInNs() failed: routes %v, %v", routes, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("execInNetNsPath() failed: %s", err)
+	}
+
+	// the address is released if it can not be assigned
+	if err := veth.DeleteLink(); err != nil {
+		t.Fatalf("DeleteLink() failed: %s", err)
+	}
+
+	if _, _, err := ipam.AssignLinkIp(veth, "pool", "ctr3"); err == nil {
+		t.Fatalf("AssignLinkIp() expected to fail for deleted link")
+	}
+
+	// owner keeps the address it had before the failed assignment
+	if _, _, err := ipam.AssignLinkIp(veth, "pool", "ctr1"); err == nil {
+		t.Fatalf("AssignLinkIp() expected to fail for deleted link")
+	}
+
+	allocations, err := ipam.Allocations("pool")
+	expected := []Allocation{{"10.0.0.2/24", "ctr1"}, {"10.0.0.3/24", "ctr2"}}
+	if err != nil || !reflect.DeepEqual(allocations, expected) {
+		t.Fatalf("Allocations() failed: expected %+v, returned %+v, %v", expected, allocations, err)
+	}
+}
@@ -0,0 +1,693 @@
+package tenus
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// WriteIPBatch writes the changes as iproute2 batch script which can be applied by running: ip -batch ${file}
+//
+// Changes made in named network namespaces are wrapped in: netns exec ${name} ip ...
+// Aliases of created links are set by separate commands following the link creation.
+// Traffic control changes are skipped; they are written by WriteTcBatch.
+// It returns error if a change can't be expressed by iproute2, e.g. a change made inside network namespace
+// which is neither named nor referred to by PID when moving links.
+func WriteIPBatch(w io.Writer, ops []Op) error {
+	bw := bufio.NewWriter(w)
+	for _, op := range ops {
+		if op.tc() {
+			continue
+		}
+
+		cmds, err := ipCommands(op)
+		if err != nil {
+			return err
+		}
+
+		for _, cmd := range cmds {
+			if _, err := fmt.Fprintln(bw, cmd); err != nil {
+				return err
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// WriteTcBatch writes the traffic control changes as tc batch script which can be applied by running: tc -batch ${file}
+//
+// Other changes are skipped, so the script is meant to be applied after the one written by WriteIPBatch.
+// tc batch scripts can't switch network namespaces, so all the traffic control changes must be made in the same
+// network namespace. The script of changes made in named network namespace is applied by running:
+// ip netns exec ${name} tc -batch ${file}
+func WriteTcBatch(w io.Writer, ops []Op) error {
+	bw := bufio.NewWriter(w)
+	ns, seen := "", false
+	for _, op := range ops {
+		if !op.tc() {
+			continue
+		}
+
+		if seen && op.Ns != ns {
+			return fmt.Errorf("Could not render %s %s: traffic control changes were made in more than one network namespace",
+				op.Type, op.Link)
+		}
+		ns, seen = op.Ns, true
+
+		args, err := tcArgs(op)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintln(bw, strings.Join(args, " ")); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// tcCommand returns tc command of the change. Changes made in named network namespaces are run by ip netns exec.
+func tcCommand(op Op) (string, error) {
+	args, err := tcArgs(op)
+	if err != nil {
+		return "", err
+	}
+
+	cmd := "tc " + strings.Join(args, " ")
+	if op.Ns == "" {
+		return cmd, nil
+	}
+
+	name, ok := namedNetNs(op.Ns)
+	if !ok {
+		return "", fmt.Errorf("Could not render %s %s: network namespace %s is not named", op.Type, op.Link, op.Ns)
+	}
+
+	return "ip netns exec " + name + " " + cmd, nil
+}
+
+// ipCommands returns iproute2 commands of the change without the leading "ip"
+func ipCommands(op Op) ([]string, error) {
+	args, err := ipArgs(op)
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := []string{strings.Join(args, " ")}
+	if op.Type == OpLinkAdd && op.Spec.Alias != "" {
+		for _, name := range []string{op.Spec.Name, op.Spec.PeerName} {
+			if name != "" {
+				cmds = append(cmds, "link set dev "+name+" alias "+batchQuote(op.Spec.Alias))
+			}
+		}
+	}
+
+	if op.Ns == "" {
+		return cmds, nil
+	}
+
+	name, ok := namedNetNs(op.Ns)
+	if !ok {
+		return nil, fmt.Errorf("Could not render %s %s: network namespace %s is not named", op.Type, op.Link, op.Ns)
+	}
+
+	for i := range cmds {
+		cmds[i] = "netns exec " + name + " ip " + cmds[i]
+	}
+
+	return cmds, nil
+}
+
+// batchQuote quotes the argument if it contains characters iproute2 batch parser splits arguments on
+func batchQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\#") {
+		return arg
+	}
+
+	return strconv.Quote(arg)
+}
+
+// ipArgs returns arguments of iproute2 command of the change
+func ipArgs(op Op) ([]string, error) {
+	switch op.Type {
+	case OpLinkAdd:
+		return linkAddArgs(op)
+	case OpLinkDel:
+		return []string{"link", "del", "dev", op.Link}, nil
+	case OpLinkSetName:
+		return []string{"link", "set", "dev", op.Link, "name", op.Name}, nil
+	case OpLinkSetMTU:
+		return []string{"link", "set", "dev", op.Link, "mtu", strconv.Itoa(op.MTU)}, nil
+	case OpLinkSetHardwareAddr:
+		return []string{"link", "set", "dev", op.Link, "address", op.HardwareAddr.String()}, nil
+	case OpLinkSetUp:
+		return []string{"link", "set", "dev", op.Link, "up"}, nil
+	case OpLinkSetDown:
+		return []string{"link", "set", "dev", op.Link, "down"}, nil
+	case OpLinkSetMaster:
+		if op.Master == "" {
+			return []string{"link", "set", "dev", op.Link, "nomaster"}, nil
+		}
+		return []string{"link", "set", "dev", op.Link, "master", op.Master}, nil
+	case OpLinkSetNs:
+		ns, ok := namedNetNs(op.NsPath)
+		if !ok {
+			ns, ok = pidNetNs(op.NsPath)
+		}
+		if !ok {
+			return nil, fmt.Errorf("Could not render %s %s: network namespace %s is neither named nor owned by a process",
+				op.Type, op.Link, op.NsPath)
+		}
+		return []string{"link", "set", "dev", op.Link, "netns", ns}, nil
+	case OpAddrAdd:
+		return []string{"address", "add", op.Addr.String(), "dev", op.Link}, nil
+	case OpAddrDel:
+		return []string{"address", "del", op.Addr.String(), "dev", op.Link}, nil
+	case OpRouteAdd, OpRouteDel:
+		args := []string{"route", "add", "default"}
+		if op.Type == OpRouteDel {
+			args[1] = "del"
+		}
+		if op.Dst != nil {
+			args[2] = op.Dst.String()
+		}
+		if op.Gw != nil {
+			args = append(args, "via", op.Gw.String())
+		}
+		if op.Link != "" {
+			args = append(args, "dev", op.Link)
+		}
+		return args, nil
+	case OpNsCreate, OpNsDelete:
+		name, ok := namedNetNs(op.NsPath)
+		if !ok {
+			return nil, fmt.Errorf("Could not render %s: network namespace %s is not named", op.Type, op.NsPath)
+		}
+		if op.Type == OpNsDelete {
+			return []string{"netns", "delete", name}, nil
+		}
+		return []string{"netns", "add", name}, nil
+	}
+
+	return nil, fmt.Errorf("Could not render unknown operation %q", op.Type)
+}
+
+// linkAddArgs returns arguments of iproute2 command which creates the link
+func linkAddArgs(op Op) ([]string, error) {
+	spec := op.Spec
+
+	switch spec.Kind {
+	case "dummy", "bridge":
+		return []string{"link", "add", spec.Name, "type", spec.Kind}, nil
+	case "veth":
+		args := []string{"link", "add", spec.Name}
+		peer := []string{"peer", "name", spec.PeerName}
+		if spec.TxQueueLen > 0 {
+			txQueueLen := strconv.Itoa(spec.TxQueueLen)
+			args = append(args, "txqueuelen", txQueueLen)
+			peer = append(peer, "txqueuelen", txQueueLen)
+		}
+		return append(append(args, "type", "veth"), peer...), nil
+	case "vlan":
+		return []string{"link", "add", "link", op.Parent, "name", spec.Name, "type", "vlan",
+			"id", strconv.Itoa(int(spec.VlanId))}, nil
+	case "macvlan", "macvtap":
+		return []string{"link", "add", "link", op.Parent, "name", spec.Name, "type", spec.Kind,
+			"mode", spec.MacVlanMode}, nil
+	}
+
+	return nil, fmt.Errorf("Could not render %s %s: unsupported link kind %q", op.Type, spec.Name, spec.Kind)
+}
+
+// namedNetNs returns name of the network namespace if it's pinned in NetNsRunDir
+func namedNetNs(nspath string) (string, bool) {
+	if filepath.Dir(nspath) != filepath.Clean(NetNsRunDir) {
+		return "", false
+	}
+
+	return filepath.Base(nspath), true
+}
+
+// pidNetNs returns PID of the process if nspath is the process' network namespace
+func pidNetNs(nspath string) (string, bool) {
+	parts := strings.Split(nspath, "/")
+	if len(parts) != 5 || parts[0] != "" || parts[1] != "proc" || parts[3] != "ns" || parts[4] != "net" {
+		return "", false
+	}
+
+	if _, err := strconv.Atoi(parts[2]); err != nil {
+		return "", false
+	}
+
+	return parts[2], true
+}
+
+// tcArgs returns arguments of tc command of the change
+func tcArgs(op Op) ([]string, error) {
+	var args []string
+	var err error
+	switch op.Type {
+	case OpQdiscAdd:
+		args, err = qdiscArgs("add", op.Link, op.Qdisc)
+	case OpQdiscReplace:
+		args, err = qdiscArgs("replace", op.Link, op.Qdisc)
+	case OpQdiscDel:
+		args, err = qdiscArgs("del", op.Link, op.Qdisc)
+	case OpClassAdd:
+		args, err = classArgs("add", op.Link, op.Class)
+	case OpClassReplace:
+		args, err = classArgs("replace", op.Link, op.Class)
+	case OpClassDel:
+		args, err = classArgs("del", op.Link, op.Class)
+	case OpFilterAdd:
+		args, err = filterArgs("add", op.Link, op.Filter)
+	case OpFilterReplace:
+		args, err = filterArgs("replace", op.Link, op.Filter)
+	case OpFilterDel:
+		args, err = filterArgs("del", op.Link, op.Filter)
+	default:
+		return nil, fmt.Errorf("Could not render unknown operation %q", op.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not render %s %s: %s", op.Type, op.Link, err)
+	}
+
+	return args, nil
+}
+
+// qdiscArgs returns arguments of tc command which changes the link's qdisc. Deleted qdiscs are rendered without options.
+func qdiscArgs(cmd, link string, q *Qdisc) ([]string, error) {
+	args := []string{"qdisc", cmd, "dev", link}
+
+	// parent and handle of ingress and clsact qdiscs are implied by their kind
+	if q.ingress() {
+		return append(args, q.Kind), nil
+	}
+
+	if parent := q.parent(); parent == HandleRoot {
+		args = append(args, "root")
+	} else {
+		args = append(args, "parent", HandleString(parent))
+	}
+
+	if q.Handle != 0 {
+		args = append(args, "handle", HandleString(q.Handle))
+	}
+
+	if cmd == "del" {
+		return args, nil
+	}
+
+	if !qdiscKinds[q.Kind] {
+		return nil, fmt.Errorf("unsupported qdisc kind %q", q.Kind)
+	}
+	args = append(args, q.Kind)
+
+	switch {
+	case q.Kind == "tbf":
+		if q.Tbf == nil {
+			return nil, errors.New("tbf qdisc requires Tbf options")
+		}
+		args = append(args, q.Tbf.args()...)
+	case q.Kind == "htb" && q.Htb != nil:
+		args = append(args, q.Htb.args()...)
+	case q.Kind == "prio" && q.Prio != nil:
+		args = append(args, q.Prio.args()...)
+	case q.Kind == "fq_codel" && q.FqCodel != nil:
+		args = append(args, q.FqCodel.args()...)
+	case q.Kind == "netem" && q.Netem != nil:
+		args = append(args, q.Netem.args()...)
+	}
+
+	return args, nil
+}
+
+// args returns tc arguments of tbf options
+func (o *TbfOptions) args() []string {
+	args := []string{"rate", tcRate(o.Rate), "burst", uitoa(o.Burst)}
+	if o.Limit != 0 {
+		args = append(args, "limit", uitoa(o.Limit))
+	} else {
+		args = append(args, "latency", tcTime(o.Latency))
+	}
+
+	if o.PeakRate != 0 {
+		args = append(args, "peakrate", tcRate(o.PeakRate), "minburst", uitoa(o.MinBurst))
+	}
+
+	return args
+}
+
+// args returns tc arguments of htb qdisc options
+func (o *HtbOptions) args() []string {
+	var args []string
+	if o.Default != 0 {
+		// tc parses the default class minor number as hexadecimal
+		args = append(args, "default", strconv.FormatUint(uint64(o.Default), 16))
+	}
+	if o.Rate2Quantum != 0 {
+		args = append(args, "r2q", uitoa(o.Rate2Quantum))
+	}
+	if o.DirectQlen != 0 {
+		args = append(args, "direct_qlen", uitoa(o.DirectQlen))
+	}
+
+	return args
+}
+
+// args returns tc arguments of prio qdisc options
+func (o *PrioOptions) args() []string {
+	var args []string
+	if o.Bands != 0 {
+		args = append(args, "bands", uitoa(o.Bands))
+	}
+
+	if o.Priomap != ([16]uint8{}) || (o.Bands != 0 && o.Bands != 3) {
+		args = append(args, "priomap")
+		for _, band := range o.Priomap {
+			args = append(args, uitoa(uint32(band)))
+		}
+	}
+
+	return args
+}
+
+// args returns tc arguments of fq_codel options
+func (o *FqCodelOptions) args() []string {
+	var args []string
+	if o.Limit > 0 {
+		args = append(args, "limit", uitoa(o.Limit))
+	}
+	if o.Flows > 0 {
+		args = append(args, "flows", uitoa(o.Flows))
+	}
+	if o.Target > 0 {
+		args = append(args, "target", tcTime(o.Target))
+	}
+	if o.Interval > 0 {
+		args = append(args, "interval", tcTime(o.Interval))
+	}
+	if o.Quantum > 0 {
+		args = append(args, "quantum", uitoa(o.Quantum))
+	}
+
+	if o.DisableECN {
+		return append(args, "noecn")
+	}
+
+	return append(args, "ecn")
+}
+
+// args returns tc arguments of netem options
+func (o *NetemOptions) args() []string {
+	limit := o.Limit
+	if limit == 0 {
+		limit = netemDefaultLimit
+	}
+
+	args := []string{"limit", uitoa(limit)}
+	if o.Delay > 0 || o.Jitter > 0 || o.Reorder > 0 {
+		args = append(args, "delay", tcTime(o.Delay))
+		if o.Jitter > 0 || o.DelayCorrelation > 0 {
+			args = append(args, tcTime(o.Jitter))
+		}
+		if o.DelayCorrelation > 0 {
+			args = append(args, tcPercent(o.DelayCorrelation))
+		}
+	}
+
+	for _, p := range []struct {
+		name        string
+		value, corr float64
+	}{
+		{"loss", o.Loss, o.LossCorrelation},
+		{"duplicate", o.Duplicate, o.DuplicateCorrelation},
+		{"reorder", o.Reorder, o.ReorderCorrelation},
+	} {
+		if p.value <= 0 {
+			continue
+		}
+
+		args = append(args, p.name, tcPercent(p.value))
+		if p.corr > 0 {
+			args = append(args, tcPercent(p.corr))
+		}
+	}
+
+	if o.Reorder > 0 && o.Gap > 0 {
+		args = append(args, "gap", uitoa(o.Gap))
+	}
+
+	if o.Rate > 0 {
+		args = append(args, "rate", tcRate(o.Rate))
+	}
+
+	return args
+}
+
+// classArgs returns arguments of tc command which changes the link's class. Deleted classes are rendered without options.
+func classArgs(cmd, link string, c *Class) ([]string, error) {
+	args := []string{"class", cmd, "dev", link}
+	if c.Parent != 0 {
+		args = append(args, "parent", HandleString(c.Parent))
+	}
+	args = append(args, "classid", HandleString(c.Handle))
+
+	if cmd == "del" {
+		return args, nil
+	}
+
+	if c.Kind != "htb" {
+		return nil, fmt.Errorf("unsupported class kind %q", c.Kind)
+	}
+
+	if c.Htb == nil {
+		return nil, errors.New("htb class requires Htb options")
+	}
+
+	o := c.Htb
+	args = append(args, "htb", "rate", tcRate(o.Rate))
+	if o.Ceil != 0 {
+		args = append(args, "ceil", tcRate(o.Ceil))
+	}
+	if o.Burst != 0 {
+		args = append(args, "burst", uitoa(o.Burst))
+	}
+	if o.Cburst != 0 {
+		args = append(args, "cburst", uitoa(o.Cburst))
+	}
+	if o.Prio != 0 {
+		args = append(args, "prio", uitoa(o.Prio))
+	}
+	if o.Quantum != 0 {
+		args = append(args, "quantum", uitoa(o.Quantum))
+	}
+
+	return args, nil
+}
+
+// filterArgs returns arguments of tc command which changes the link's filter.
+// Deleted filters are rendered without options and actions.
+func filterArgs(cmd, link string, f *Filter) ([]string, error) {
+	args := []string{"filter", cmd, "dev", link}
+	switch f.Parent {
+	case 0:
+		args = append(args, "root")
+	case HandleMinIngress:
+		args = append(args, "ingress")
+	case HandleMinEgress:
+		args = append(args, "egress")
+	default:
+		args = append(args, "parent", HandleString(f.Parent))
+	}
+
+	if f.Handle != 0 && (cmd != "del" || (f.Priority != 0 && f.Kind != "")) {
+		args = append(args, "handle", filterHandle(f.Kind, f.Handle))
+	}
+
+	if f.Priority != 0 {
+		args = append(args, "prio", strconv.Itoa(int(f.Priority)))
+	}
+
+	if cmd == "del" {
+		if f.Priority != 0 {
+			args = append(args, "protocol", tcProtocol(f.Protocol))
+			if f.Kind != "" {
+				args = append(args, f.Kind)
+			}
+		}
+		return args, nil
+	}
+
+	if !filterKinds[f.Kind] {
+		return nil, fmt.Errorf("unsupported filter kind %q", f.Kind)
+	}
+	args = append(args, "protocol", tcProtocol(f.Protocol), f.Kind)
+
+	switch f.Kind {
+	case "u32":
+		var keys []U32Key
+		if f.U32 != nil {
+			keys = f.U32.Keys
+		}
+		// u32 filter without keys matches all packets
+		if len(keys) == 0 {
+			keys = []U32Key{{}}
+		}
+		for _, key := range keys {
+			args = append(args, "match", "u32", fmt.Sprintf("0x%08x", key.Val&key.Mask), fmt.Sprintf("0x%08x", key.Mask),
+				"at", strconv.Itoa(int(key.Off)))
+		}
+	case "flower":
+		if f.Flower != nil {
+			args = append(args, f.Flower.args()...)
+		}
+	}
+
+	if f.ClassID != 0 {
+		args = append(args, "classid", HandleString(f.ClassID))
+	}
+
+	for i, a := range f.Actions {
+		if a == nil {
+			return nil, fmt.Errorf("action %d is nil", i)
+		}
+
+		actionArgs, err := a.args()
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s action: %s", a.Kind, err)
+		}
+		args = append(append(args, "action"), actionArgs...)
+	}
+
+	return args, nil
+}
+
+// args returns tc arguments of flower filter keys
+func (o *FlowerOptions) args() []string {
+	var args []string
+	if o.SrcMac != nil {
+		args = append(args, "src_mac", o.SrcMac.String())
+	}
+	if o.DstMac != nil {
+		args = append(args, "dst_mac", o.DstMac.String())
+	}
+	if o.VlanId != 0 {
+		args = append(args, "vlan_id", strconv.Itoa(int(o.VlanId)))
+	}
+	if o.VlanEthType != 0 {
+		args = append(args, "vlan_ethtype", tcProtocol(o.VlanEthType))
+	}
+
+	switch o.IPProto {
+	case 0:
+	case syscall.IPPROTO_TCP:
+		args = append(args, "ip_proto", "tcp")
+	case syscall.IPPROTO_UDP:
+		args = append(args, "ip_proto", "udp")
+	default:
+		// tc parses IP protocol numbers as hexadecimal
+		args = append(args, "ip_proto", fmt.Sprintf("0x%x", o.IPProto))
+	}
+
+	if o.SrcIP != nil {
+		args = append(args, "src_ip", o.SrcIP.String())
+	}
+	if o.DstIP != nil {
+		args = append(args, "dst_ip", o.DstIP.String())
+	}
+	if o.SrcPort != 0 {
+		args = append(args, "src_port", strconv.Itoa(int(o.SrcPort)))
+	}
+	if o.DstPort != 0 {
+		args = append(args, "dst_port", strconv.Itoa(int(o.DstPort)))
+	}
+
+	return args
+}
+
+// args returns tc arguments of the action without the leading "action"
+func (a *Action) args() ([]string, error) {
+	switch a.Kind {
+	case "mirred":
+		if a.Mirred == nil {
+			return nil, errors.New("Mirred options required")
+		}
+		direction, action := "egress", "mirror"
+		if a.Mirred.Ingress {
+			direction = "ingress"
+		}
+		if a.Mirred.Redirect {
+			action = "redirect"
+		}
+		return []string{"mirred", direction, action, "dev", a.Mirred.Dev}, nil
+	case "police":
+		if a.Police == nil {
+			return nil, errors.New("Police options required")
+		}
+		return []string{"police", "rate", tcRate(a.Police.Rate), "burst", uitoa(a.Police.Burst),
+			"conform-exceed", "drop/pipe"}, nil
+	case "vlan":
+		if a.Vlan == nil {
+			return nil, errors.New("Vlan options required")
+		}
+		if a.Vlan.Pop {
+			return []string{"vlan", "pop"}, nil
+		}
+		args := []string{"vlan", "push", "id", strconv.Itoa(int(a.Vlan.Id))}
+		if a.Vlan.Protocol != 0 {
+			args = append(args, "protocol", tcProtocol(a.Vlan.Protocol))
+		}
+		return append(args, "priority", strconv.Itoa(int(a.Vlan.Priority))), nil
+	case "drop":
+		return []string{"drop"}, nil
+	}
+
+	return nil, errors.New("unsupported action kind")
+}
+
+// filterHandle formats filter handle the way tc parses it. u32 handles are made of hash table, bucket and node IDs.
+func filterHandle(kind string, handle uint32) string {
+	if kind == "u32" {
+		return fmt.Sprintf("%x:%x:%x", handle>>20, (handle>>12)&0xff, handle&u32NodeMask)
+	}
+
+	return strconv.FormatUint(uint64(handle), 10)
+}
+
+// tcProtocol formats ethernet protocol in host byte order the way tc parses it
+func tcProtocol(protocol uint16) string {
+	if protocol == 0 || protocol == syscall.ETH_P_ALL {
+		return "all"
+	}
+
+	return fmt.Sprintf("0x%04x", protocol)
+}
+
+// tcRate formats rate in bytes per second the way tc parses it
+func tcRate(rate uint64) string {
+	return strconv.FormatUint(rate, 10) + "bps"
+}
+
+// tcTime formats the duration in microseconds the way tc parses it
+func tcTime(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Microsecond), 'f', -1, 64) + "us"
+}
+
+// tcPercent formats the percentage the way tc parses it
+func tcPercent(p float64) string {
+	return strconv.FormatFloat(p, 'f', -1, 64) + "%"
+}
+
+// uitoa formats unsigned integer in decimal
+func uitoa(n uint32) string {
+	return strconv.FormatUint(uint64(n), 10)
+}
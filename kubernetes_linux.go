@@ -0,0 +1,142 @@
+package tenus
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DefaultKubeletDir is the root directory of kubelet
+	DefaultKubeletDir = "/var/lib/kubelet"
+	// DefaultPodLogDir is the directory kubelet keeps pod logs in
+	DefaultPodLogDir = "/var/log/pods"
+)
+
+// PodResolver resolves Kubernetes pods running on the local node into network namespaces of their sandboxes.
+//
+// The pod is specified either by NAMESPACE/NAME or by its UID. Pod names are looked up in the pod log
+// directory which kubelet names ${NAMESPACE}_${NAME}_${UID}. The pod must have its kubelet pod directory,
+// so pods which have been deleted are not resolved. The network namespace of the latest ready pod sandbox
+// is looked up via CRI sandbox status.
+type PodResolver struct {
+	// Runtime is CRI runtime running the pods. CRIClient talking to containerd is used if nil.
+	Runtime CRIRuntime
+	// KubeletDir is the root directory of kubelet. DefaultKubeletDir is used if empty.
+	KubeletDir string
+	// PodLogDir is the pod log directory of kubelet. DefaultPodLogDir is used if empty.
+	PodLogDir string
+}
+
+// ResolveNs returns network namespace path of the pod specified by NAMESPACE/NAME or UID.
+// It returns ErrNsNotFound if the pod is not running on the node.
+func (r *PodResolver) ResolveNs(ctx context.Context, id string) (string, error) {
+	status, err := r.PodSandboxStatus(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	return sandboxNsPath(status)
+}
+
+// PodSandboxStatus returns status of the latest ready sandbox of the pod specified by NAMESPACE/NAME or UID.
+// It returns ErrNsNotFound if the pod is not running on the node.
+func (r *PodResolver) PodSandboxStatus(ctx context.Context, id string) (*PodSandboxStatus, error) {
+	uid, err := r.PodUID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	runtime := r.Runtime
+	if runtime == nil {
+		runtime = &CRIClient{}
+	}
+
+	sandboxes, err := runtime.ListPodSandboxes(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("Could not list sandboxes of pod %s: %w", id, err)
+	}
+
+	if len(sandboxes) == 0 {
+		return nil, fmt.Errorf("%w: pod %s has no ready sandbox", ErrContainerNotRunning, id)
+	}
+
+	sandbox := sandboxes[len(sandboxes)-1]
+	status, err := runtime.PodSandboxStatus(ctx, sandbox.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get status of pod %s sandbox %s: %w", id, sandbox.ID, err)
+	}
+
+	return status, nil
+}
+
+// PodUID returns UID of the pod specified by NAMESPACE/NAME or UID which has kubelet pod directory on the node.
+// It returns ErrNsNotFound if there is no such pod and error if the pod name is ambiguous.
+func (r *PodResolver) PodUID(id string) (string, error) {
+	kubeletDir, logDir := r.KubeletDir, r.PodLogDir
+	if kubeletDir == "" {
+		kubeletDir = DefaultKubeletDir
+	}
+
+	if logDir == "" {
+		logDir = DefaultPodLogDir
+	}
+
+	podExists := func(uid string) bool {
+		info, err := os.Stat(filepath.Join(kubeletDir, "pods", uid))
+		return err == nil && info.IsDir()
+	}
+
+	parts := strings.Split(id, "/")
+	switch {
+	case len(parts) == 1 && validPodName(id):
+		if !podExists(id) {
+			return "", fmt.Errorf("%w: pod %s is not on this node", ErrNsNotFound, id)
+		}
+		return id, nil
+	case len(parts) != 2 || !validPodName(parts[0]) || !validPodName(parts[1]):
+		return "", fmt.Errorf("%w: invalid pod %q: expected NAMESPACE/NAME or UID", ErrNsNotFound, id)
+	}
+
+	entries, err := ioutil.ReadDir(logDir)
+	if err != nil {
+		return "", fmt.Errorf("%w: pod %s: %s", ErrNsNotFound, id, err)
+	}
+
+	// pods which were deleted and recreated under the same name leave their log directories behind
+	prefix := parts[0] + "_" + parts[1] + "_"
+	var uids []string
+	for _, entry := range entries {
+		uid := strings.TrimPrefix(entry.Name(), prefix)
+		if uid != entry.Name() && entry.IsDir() && validPodName(uid) && podExists(uid) {
+			uids = append(uids, uid)
+		}
+	}
+
+	switch len(uids) {
+	case 0:
+		return "", fmt.Errorf("%w: pod %s is not on this node", ErrNsNotFound, id)
+	case 1:
+		return uids[0], nil
+	}
+
+	return "", fmt.Errorf("Pod %s is ambiguous: UIDs %s", id, strings.Join(uids, ", "))
+}
+
+// validPodName returns true if s is valid Kubernetes namespace, pod name or UID
+func validPodName(s string) bool {
+	if s == "" || len(s) > 253 {
+		return false
+	}
+
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+
+	return s != "." && s != ".."
+}
@@ -0,0 +1,150 @@
+package tenus
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newKubeletFixture creates kubelet pod directories and pod log directories of the pods in dir
+func newKubeletFixture(t *testing.T, dir string) *PodResolver {
+	dirs := []string{
+		"kubelet/pods/uid-web",
+		"kubelet/pods/uid-db",
+		"kubelet/pods/uid-idle",
+		"kubelet/pods/uid-twin1",
+		"kubelet/pods/uid-twin2",
+		"pods/default_web_uid-web",
+		// log directory of the deleted pod of the same name
+		"pods/default_web_uid-old",
+		"pods/prod_db_uid-db",
+		"pods/prod_idle_uid-idle",
+		"pods/prod_twin_uid-twin1",
+		"pods/prod_twin_uid-twin2",
+		"pods/prod_db-replica_uid-replica",
+	}
+
+	for _, d := range dirs {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatalf("Could not create directory: %s", err)
+		}
+	}
+
+	return &PodResolver{KubeletDir: filepath.Join(dir, "kubelet"), PodLogDir: filepath.Join(dir, "pods")}
+}
+
+type podUIDTest struct {
+	id  string
+	uid string
+	err error
+}
+
+var podUIDTests = []podUIDTest{
+	{"default/web", "uid-web", nil},
+	{"prod/db", "uid-db", nil},
+	{"uid-db", "uid-db", nil},
+	{"uid-old", "", ErrNsNotFound},
+	{"prod/db-replica", "", ErrNsNotFound},
+	{"prod/none", "", ErrNsNotFound},
+	{"prod/twin", "", nil},
+	{"prod/web/extra", "", ErrNsNotFound},
+	{"Prod/Web", "", ErrNsNotFound},
+	{"../pods", "", ErrNsNotFound},
+	{"", "", ErrNsNotFound},
+}
+
+func Test_PodUID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenus")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := newKubeletFixture(t, dir)
+	for _, tt := range podUIDTests {
+		uid, err := r.PodUID(tt.id)
+		if tt.uid == "" {
+			if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+				t.Errorf("PodUID(%q) failed: expected %v, returned %q, %v", tt.id, tt.err, uid, err)
+			}
+			continue
+		}
+
+		if err != nil || uid != tt.uid {
+			t.Errorf("PodUID(%q) failed: expected %s, returned %q, %v", tt.id, tt.uid, uid, err)
+		}
+	}
+}
+
+func Test_PodResolver(t *testing.T) {
+	defer SetBackend(SetBackend(NewFakeBackend()))
+
+	dir, err := ioutil.TempDir("", "tenus")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cri := newFakeCRISandboxes()
+	cri.sandboxes = append(cri.sandboxes, fakeSandbox{PodSandbox{ID: "d1", Name: "idle", Namespace: "prod", UID: "uid-idle"}, false, `{}`})
+
+	sock, stop := newFakeCRI(t, dir, cri)
+	defer stop()
+
+	r := newKubeletFixture(t, dir)
+	r.Runtime = &CRIClient{Socket: sock}
+
+	testResolver(t, r, []resolverTest{
+		{"default/web", "/var/run/netns/cni-1234", nil},
+		{"prod/db", "/proc/5432/ns/net", nil},
+		{"uid-db", "/proc/5432/ns/net", nil},
+		{"prod/idle", "", ErrContainerNotRunning},
+		{"prod/none", "", ErrNsNotFound},
+	})
+
+	if err := backend.NsCreate("/proc/5432/ns/net"); err != nil {
+		t.Fatalf("NsCreate() failed: %s", err)
+	}
+
+	veth, err := NewVethPairWithOptions("vethpod01", VethOptions{PeerName: "vethpod02"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	if err := veth.SetPeerLinkNsTo(r, "prod/db"); err != nil {
+		t.Fatalf("SetPeerLinkNsTo() failed: %s", err)
+	}
+
+	mvlan, err := NewMacVlanLinkWithOptions("vethpod01", MacVlanOptions{Dev: "mcpod01", Mode: "bridge"})
+	if err != nil {
+		t.Fatalf("NewMacVlanLinkWithOptions() failed: %s", err)
+	}
+
+	if err := mvlan.SetLinkNsTo(r, "prod/db"); err != nil {
+		t.Fatalf("SetLinkNsTo() failed: %s", err)
+	}
+
+	if err := mvlan.SetLinkNsTo(r, "prod/idle"); !errors.Is(err, ErrContainerNotRunning) {
+		t.Fatalf("SetLinkNsTo() failed: expected %v, returned %v", ErrContainerNotRunning, err)
+	}
+
+	err = execInNetNsPath("/proc/5432/ns/net", func() error {
+		for _, name := range []string{"vethpod02", "mcpod01"} {
+			if _, err := LinkAttrsByName(name); err != nil {
+				t.Errorf("SetLinkNsTo() failed to move %s: %s", name, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("execInNetNsPath() failed: %s", err)
+	}
+
+	if _, err := r.PodSandboxStatus(context.Background(), "default/web"); err != nil {
+		t.Fatalf("PodSandboxStatus() failed: %s", err)
+	}
+}
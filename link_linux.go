@@ -1,19 +1,19 @@
 package tenus
 
 import (
+	"context"
 	"fmt"
 	"net"
-	"os"
+	"strconv"
 	"syscall"
-
-	"github.com/docker/libcontainer/netlink"
-	"github.com/docker/libcontainer/system"
 )
 
 // LinkOptions allows you to specify network link options.
 type LinkOptions struct {
 	// MAC address
 	MacAddr string
+	// MAC address generator used by NewLinkWithOptions if MacAddr is empty
+	MacGen MacGenerator `json:"-" yaml:"-"`
 	// Maximum Transmission Unit
 	MTU int
 	// Link network flags i.e. FlagUp, FlagLoopback, FlagMulticast
@@ -44,13 +44,93 @@ type Linker interface {
 	SetLinkDefaultGw(*net.IP) error
 	// SetLinkNetNsPid moves the link to network namespace specified by PID
 	SetLinkNetNsPid(int) error
+	// SetLinkNsTo moves the link to network namespace of the container resolved by NsResolver
+	SetLinkNsTo(NsResolver, string) error
 	// SetLinkNetInNs configures network settings of the link in network namespace
 	SetLinkNetInNs(int, net.IP, *net.IPNet, *net.IP) error
+	// Refresh reloads the link's network interface from Linux kernel
+	Refresh() error
+	// Attrs returns the link's current kernel attributes
+	Attrs() (*LinkAttrs, error)
+	// Stats returns the link's 64-bit counters
+	Stats() (*LinkStats, error)
+	// AddQdisc attaches the qdisc to the link
+	AddQdisc(*Qdisc) error
+	// ReplaceQdisc attaches the qdisc to the link replacing the qdisc of the same parent
+	ReplaceQdisc(*Qdisc) error
+	// DelQdisc detaches the qdisc from the link
+	DelQdisc(*Qdisc) error
+	// Qdiscs returns qdiscs attached to the link
+	Qdiscs() ([]*Qdisc, error)
+	// AddClass adds the class to the link's classful qdisc
+	AddClass(*Class) error
+	// ReplaceClass adds the class to the link's classful qdisc or changes its options
+	ReplaceClass(*Class) error
+	// DelClass deletes the class from the link's classful qdisc
+	DelClass(*Class) error
+	// Classes returns classes of qdiscs attached to the link
+	Classes() ([]*Class, error)
+	// AddFilter attaches the filter to the link's qdisc or class
+	AddFilter(*Filter) error
+	// ReplaceFilter attaches the filter to the link's qdisc or class or changes the existing filter
+	ReplaceFilter(*Filter) error
+	// DelFilter detaches the filter from the link
+	DelFilter(*Filter) error
+	// Filters returns filters attached to the link's qdisc or class with the given handle
+	Filters(uint32) ([]*Filter, error)
+	// DeleteLinkContext deletes the link from Linux host unless the context is done
+	DeleteLinkContext(context.Context) error
+	// SetLinkMTUContext sets the link's MTU unless the context is done
+	SetLinkMTUContext(context.Context, int) error
+	// SetLinkMacAddressContext sets the link's MAC address unless the context is done
+	SetLinkMacAddressContext(context.Context, string) error
+	// SetLinkUpContext brings the link up unless the context is done
+	SetLinkUpContext(context.Context) error
+	// SetLinkDownContext brings the link down unless the context is done
+	SetLinkDownContext(context.Context) error
+	// SetLinkIpContext configures the link's IP address unless the context is done
+	SetLinkIpContext(context.Context, net.IP, *net.IPNet) error
+	// UnsetLinkIpContext removes an IP address from the link unless the context is done
+	UnsetLinkIpContext(context.Context, net.IP, *net.IPNet) error
+	// SetLinkDefaultGwContext configures the link's default gateway unless the context is done
+	SetLinkDefaultGwContext(context.Context, *net.IP) error
+	// SetLinkNetNsPidContext moves the link to network namespace specified by PID unless the context is done
+	SetLinkNetNsPidContext(context.Context, int) error
+	// SetLinkNsToContext moves the link to network namespace of the container resolved by NsResolver unless the context is done
+	SetLinkNsToContext(context.Context, NsResolver, string) error
+	// SetLinkNetInNsContext configures network settings of the link in network namespace until the context is done
+	SetLinkNetInNsContext(context.Context, int, net.IP, *net.IPNet, *net.IP) error
+	// AddQdiscContext attaches the qdisc to the link unless the context is done
+	AddQdiscContext(context.Context, *Qdisc) error
+	// ReplaceQdiscContext replaces the link's qdisc unless the context is done
+	ReplaceQdiscContext(context.Context, *Qdisc) error
+	// DelQdiscContext detaches the qdisc from the link unless the context is done
+	DelQdiscContext(context.Context, *Qdisc) error
+	// QdiscsContext returns qdiscs attached to the link until the context is done
+	QdiscsContext(context.Context) ([]*Qdisc, error)
+	// AddClassContext adds the class to the link's classful qdisc unless the context is done
+	AddClassContext(context.Context, *Class) error
+	// ReplaceClassContext adds or changes the link's class unless the context is done
+	ReplaceClassContext(context.Context, *Class) error
+	// DelClassContext deletes the class from the link's classful qdisc unless the context is done
+	DelClassContext(context.Context, *Class) error
+	// ClassesContext returns classes of qdiscs attached to the link until the context is done
+	ClassesContext(context.Context) ([]*Class, error)
+	// AddFilterContext attaches the filter to the link unless the context is done
+	AddFilterContext(context.Context, *Filter) error
+	// ReplaceFilterContext attaches or changes the link's filter unless the context is done
+	ReplaceFilterContext(context.Context, *Filter) error
+	// DelFilterContext detaches the filter from the link unless the context is done
+	DelFilterContext(context.Context, *Filter) error
+	// FiltersContext returns filters attached to the link's qdisc or class until the context is done
+	FiltersContext(context.Context, uint32) ([]*Filter, error)
 }
 
 // Link has a logical network interface
 type Link struct {
 	ifc *net.Interface
+	// prefix the link's name was generated with. Empty if the name was supplied by the caller.
+	namePrefix string
 }
 
 // NewLink creates new network link on Linux host.
@@ -60,21 +140,17 @@ type Link struct {
 // link was created successfully on the Linux host.
 // It returns error if the network link could not be created on Linux host.
 func NewLink(ifcName string) (Linker, error) {
-	if ok, err := NetInterfaceNameValid(ifcName); !ok {
-		return nil, err
-	}
-
-	if _, err := net.InterfaceByName(ifcName); err == nil {
-		return nil, fmt.Errorf("Interface name %s already assigned on the host", ifcName)
+	if err := linkNameAvailable(ifcName); err != nil {
+		return nil, newLinkError("create", ifcName, err)
 	}
 
-	if err := netlink.NetworkLinkAdd(ifcName, "dummy"); err != nil {
-		return nil, fmt.Errorf("Could not create new link %s: %s", ifcName, err)
+	if err := addLink(context.Background(), LinkSpec{Name: ifcName, Kind: "dummy"}); err != nil {
+		return nil, newLinkError("create", ifcName, err)
 	}
 
-	newIfc, err := net.InterfaceByName(ifcName)
+	newIfc, err := interfaceByName(ifcName)
 	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+		return nil, newLinkError("create", ifcName, err)
 	}
 
 	return &Link{
@@ -85,12 +161,12 @@ func NewLink(ifcName string) (Linker, error) {
 // NewLinkFrom creates new tenus link on Linux host from an existing interface of given name
 func NewLinkFrom(ifcName string) (Linker, error) {
 	if ok, err := NetInterfaceNameValid(ifcName); !ok {
-		return nil, err
+		return nil, newLinkError("find", ifcName, err)
 	}
 
-	newIfc, err := net.InterfaceByName(ifcName)
+	newIfc, err := interfaceByName(ifcName)
 	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+		return nil, newLinkError("find", ifcName, err)
 	}
 
 	return &Link{
@@ -109,46 +185,60 @@ func NewLinkFrom(ifcName string) (Linker, error) {
 //		ip link set dev ${ifcName} up
 // NewLinkWithOptions returns Linker which is initialized to a pointer of type Link if the network
 // link with given LinkOptions was created successfully on the Linux host.
-// It attempts to delete the link if any of the LinkOptions are incorrect or if setting the options
-// failed and returns error.
+// LinkOptions are validated before the link is created. If setting any of the options fails,
+// all the changes are rolled back, the link is deleted and error is returned.
 func NewLinkWithOptions(ifcName string, opts LinkOptions) (Linker, error) {
-	if ok, err := NetInterfaceNameValid(ifcName); !ok {
-		return nil, err
+	return NewLinkWithOptionsContext(context.Background(), ifcName, opts)
+}
+
+// NewLinkWithOptionsContext is like NewLinkWithOptions but it gives up once the context is done.
+// Links created before the context was done are deleted.
+func NewLinkWithOptionsContext(ctx context.Context, ifcName string, opts LinkOptions) (Linker, error) {
+	if err := linkNameAvailable(ifcName); err != nil {
+		return nil, newLinkError("create", ifcName, err)
 	}
 
-	if _, err := net.InterfaceByName(ifcName); err == nil {
-		return nil, fmt.Errorf("Interface name %s already assigned on the host", ifcName)
+	macaddr, err := linkMacAddr(opts.MacAddr, opts.MacGen, ifcName)
+	if err != nil {
+		return nil, newLinkError("create", ifcName, err)
 	}
+	opts.MacAddr = macaddr
 
-	if err := netlink.NetworkLinkAdd(ifcName, "dummy"); err != nil {
-		return nil, fmt.Errorf("Could not create new link %s: %s", ifcName, err)
+	if err := validateLinkOptions(opts); err != nil {
+		return nil, newLinkError("create", ifcName, err)
 	}
 
-	newIfc, err := net.InterfaceByName(ifcName)
-	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+	link := &Link{}
+	tx := NewTx().CreateLink(ifcName, func() error {
+		return addLink(ctx, LinkSpec{Name: ifcName, Kind: "dummy"})
+	}).Do("find link "+ifcName, func() error {
+		var err error
+		link.ifc, err = interfaceByName(ifcName)
+		return err
+	}, nil)
+	linkOptionsTx(tx, ifcName, opts)
+
+	// link moved to another network namespace can no longer be looked up from here
+	if opts.Ns == 0 {
+		tx.Do("refresh link "+ifcName, link.Refresh, nil)
 	}
 
-	if (opts != LinkOptions{}) {
-		errOpts := setLinkOptions(newIfc, opts)
-		if errOpts != nil {
-			if errDel := DeleteLink(newIfc.Name); err != nil {
-				return nil, fmt.Errorf("Incorrect options specified: %s. Attempt to delete the link failed: %s",
-					errOpts, errDel)
-			}
-			return nil, fmt.Errorf("Could not set link options: %s", errOpts)
-		}
+	if err := tx.CommitContext(ctx); err != nil {
+		return nil, newLinkError("create", ifcName, err)
 	}
 
-	return &Link{
-		ifc: newIfc,
-	}, nil
+	return link, nil
 }
 
 // DeleteLink deletes netowrk link from Linux Host
 // It is equivalent of running: ip link delete dev ${name}
 func DeleteLink(name string) error {
-	return netlink.NetworkLinkDel(name)
+	ifc, err := interfaceByName(name)
+	if err != nil {
+		return newLinkError("delete", name, err)
+	}
+
+	return newLinkError("delete", name, backend.LinkDel(context.Background(), ifc.Index))
 }
 
 // NetInterface returns link's logical network interface.
@@ -156,178 +246,339 @@ func (l *Link) NetInterface() *net.Interface {
 	return l.ifc
 }
 
+// Refresh reloads link's logical network interface from Linux kernel.
+// Link's interface is looked up by its index so Refresh picks up the link's new name if it was renamed.
+// It returns error if the link no longer exists in the current network namespace.
+func (l *Link) Refresh() error {
+	ifc, err := interfaceByIndex(l.ifc.Index)
+	if err != nil {
+		return newLinkError("refresh", l.ifc.Name, err)
+	}
+
+	l.ifc = ifc
+
+	return nil
+}
+
+// Attrs returns link's current attributes as reported by Linux kernel.
+// It returns error if the link no longer exists in the current network namespace.
+func (l *Link) Attrs() (*LinkAttrs, error) {
+	attrs, err := LinkAttrsByIndex(l.ifc.Index)
+	if err != nil {
+		return nil, newLinkError("attrs", l.ifc.Name, err)
+	}
+
+	return attrs, nil
+}
+
 // DeleteLink deletes link interface on Linux host.
 // It is equivalent of running: ip link delete dev ${interface name}
 func (l *Link) DeleteLink() error {
-	return netlink.NetworkLinkDel(l.NetInterface().Name)
+	return l.DeleteLinkContext(context.Background())
+}
+
+// DeleteLinkContext is like DeleteLink but it returns without changing the link if the context is done.
+func (l *Link) DeleteLinkContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("delete", l.ifc.Name, err)
+	}
+
+	return newLinkError("delete", l.ifc.Name, backend.LinkDel(ctx, l.ifc.Index))
 }
 
 // SetLinkMTU sets link's MTU.
 // It is equivalent of running: ip link set dev ${interface name} mtu ${MTU value}
 func (l *Link) SetLinkMTU(mtu int) error {
-	return netlink.NetworkSetMTU(l.NetInterface(), mtu)
+	return l.SetLinkMTUContext(context.Background(), mtu)
+}
+
+// SetLinkMTUContext is like SetLinkMTU but it returns without changing the link if the context is done.
+func (l *Link) SetLinkMTUContext(ctx context.Context, mtu int) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("set mtu", l.ifc.Name, err)
+	}
+
+	if err := backend.LinkSetMTU(ctx, l.ifc.Index, mtu); err != nil {
+		return newLinkError("set mtu", l.ifc.Name, err)
+	}
+
+	return l.Refresh()
 }
 
 // SetLinkMacAddress sets link's MAC address.
 // It is equivalent of running: ip link set dev ${interface name} address ${address}
 func (l *Link) SetLinkMacAddress(macaddr string) error {
-	return netlink.NetworkSetMacAddress(l.NetInterface(), macaddr)
+	return l.SetLinkMacAddressContext(context.Background(), macaddr)
+}
+
+// SetLinkMacAddressContext is like SetLinkMacAddress but it returns without changing the link if the context is done.
+func (l *Link) SetLinkMacAddressContext(ctx context.Context, macaddr string) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("set address", l.ifc.Name, err)
+	}
+
+	hwaddr, err := net.ParseMAC(macaddr)
+	if err != nil {
+		return newLinkError("set address", l.ifc.Name, fmt.Errorf("%w: %s", ErrInvalidMAC, err))
+	}
+
+	if err := backend.LinkSetHardwareAddr(ctx, l.ifc.Index, hwaddr); err != nil {
+		return newLinkError("set address", l.ifc.Name, err)
+	}
+
+	return l.Refresh()
 }
 
 // SetLinkUp brings the link up.
 // It is equivalent of running: ip link set dev ${interface name} up
 func (l *Link) SetLinkUp() error {
-	return netlink.NetworkLinkUp(l.NetInterface())
+	return l.SetLinkUpContext(context.Background())
+}
+
+// SetLinkUpContext is like SetLinkUp but it returns without changing the link if the context is done.
+func (l *Link) SetLinkUpContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("set up", l.ifc.Name, err)
+	}
+
+	if err := backend.LinkSetUp(ctx, l.ifc.Index); err != nil {
+		return newLinkError("set up", l.ifc.Name, err)
+	}
+
+	return l.Refresh()
 }
 
 // SetLinkDown brings the link down.
 // It is equivalent of running: ip link set dev ${interface name} down
 func (l *Link) SetLinkDown() error {
-	return netlink.NetworkLinkDown(l.NetInterface())
+	return l.SetLinkDownContext(context.Background())
+}
+
+// SetLinkDownContext is like SetLinkDown but it returns without changing the link if the context is done.
+func (l *Link) SetLinkDownContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("set down", l.ifc.Name, err)
+	}
+
+	if err := backend.LinkSetDown(ctx, l.ifc.Index); err != nil {
+		return newLinkError("set down", l.ifc.Name, err)
+	}
+
+	return l.Refresh()
 }
 
 // SetLinkIp configures the link's IP address.
 // It is equivalent of running: ip address add ${address}/${mask} dev ${interface name}
 func (l *Link) SetLinkIp(ip net.IP, network *net.IPNet) error {
-	return netlink.NetworkLinkAddIp(l.NetInterface(), ip, network)
+	return l.SetLinkIpContext(context.Background(), ip, network)
+}
+
+// SetLinkIpContext is like SetLinkIp but it returns without changing the link if the context is done.
+func (l *Link) SetLinkIpContext(ctx context.Context, ip net.IP, network *net.IPNet) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("add address", l.ifc.Name, err)
+	}
+
+	return newLinkError("add address", l.ifc.Name, backend.AddrAdd(ctx, l.ifc.Index, linkAddr(ip, network)))
 }
 
 // UnsetLinkIp configures the link's IP address.
 // It is equivalent of running: ip address del ${address}/${mask} dev ${interface name}
 func (l *Link) UnsetLinkIp(ip net.IP, network *net.IPNet) error {
-	return netlink.NetworkLinkDelIp(l.NetInterface(), ip, network)
+	return l.UnsetLinkIpContext(context.Background(), ip, network)
+}
+
+// UnsetLinkIpContext is like UnsetLinkIp but it returns without changing the link if the context is done.
+func (l *Link) UnsetLinkIpContext(ctx context.Context, ip net.IP, network *net.IPNet) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("del address", l.ifc.Name, err)
+	}
+
+	return newLinkError("del address", l.ifc.Name, backend.AddrDel(ctx, l.ifc.Index, linkAddr(ip, network)))
 }
 
 // SetLinkDefaultGw configures the link's default Gateway.
 // It is equivalent of running: ip route add default via ${ip address}
 func (l *Link) SetLinkDefaultGw(gw *net.IP) error {
-	return netlink.AddDefaultGw(gw.String(), l.NetInterface().Name)
+	return l.SetLinkDefaultGwContext(context.Background(), gw)
+}
+
+// SetLinkDefaultGwContext is like SetLinkDefaultGw but it returns without changing the link if the context is done.
+func (l *Link) SetLinkDefaultGwContext(ctx context.Context, gw *net.IP) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("add default gw", l.ifc.Name, err)
+	}
+
+	return newLinkError("add default gw", l.ifc.Name, backend.RouteAdd(ctx, &Route{Gw: *gw, Index: l.ifc.Index}))
 }
 
 // SetLinkNetNsPid moves the link to Network namespace specified by PID.
 func (l *Link) SetLinkNetNsPid(nspid int) error {
-	return netlink.NetworkSetNsPid(l.NetInterface(), nspid)
+	return l.SetLinkNetNsPidContext(context.Background(), nspid)
+}
+
+// SetLinkNetNsPidContext is like SetLinkNetNsPid but it returns without changing the link if the context is done.
+func (l *Link) SetLinkNetNsPidContext(ctx context.Context, nspid int) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("set netns", l.ifc.Name, err)
+	}
+
+	return newLinkError("set netns", l.ifc.Name, setLinkNs(ctx, l.ifc, l.namePrefix, pidNsPath(nspid)))
 }
 
 // SetLinkNetInNs configures network settings of the link in network namespace specified by PID.
 func (l *Link) SetLinkNetInNs(nspid int, ip net.IP, network *net.IPNet, gw *net.IP) error {
-	origNs, _ := NetNsHandle(os.Getpid())
-	defer syscall.Close(int(origNs))
-	defer system.Setns(origNs, syscall.CLONE_NEWNET)
+	return l.SetLinkNetInNsContext(context.Background(), nspid, ip, network, gw)
+}
 
-	if err := SetNetNsToPid(nspid); err != nil {
-		return fmt.Errorf("Setting network namespace failed: %s", err)
-	}
+// SetLinkNetInNsContext is like SetLinkNetInNs but it stops configuring the link once the context is done.
+func (l *Link) SetLinkNetInNsContext(ctx context.Context, nspid int, ip net.IP, network *net.IPNet, gw *net.IP) error {
+	return configureLinkInNs(ctx, l.ifc, nspid, ip, network, gw)
+}
 
-	if err := netlink.NetworkLinkAddIp(l.NetInterface(), ip, network); err != nil {
-		return fmt.Errorf("Unable to set IP: %s in pid: %d network namespace", ip.String(), nspid)
-	}
+// SetLinkNsFd sets the link's Linux namespace to the one specified by filesystem path.
+func (l *Link) SetLinkNsFd(nspath string) error {
+	return l.SetLinkNsFdContext(context.Background(), nspath)
+}
 
-	if err := netlink.NetworkLinkUp(l.ifc); err != nil {
-		return fmt.Errorf("Unable to bring %s interface UP: %d", l.ifc.Name, nspid)
+// SetLinkNsFdContext is like SetLinkNsFd but it returns without changing the link if the context is done.
+func (l *Link) SetLinkNsFdContext(ctx context.Context, nspath string) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("set netns", l.ifc.Name, err)
 	}
 
-	if gw != nil {
-		if err := netlink.AddDefaultGw(gw.String(), l.NetInterface().Name); err != nil {
-			return fmt.Errorf("Unable to set Default gateway: %s in pid: %d network namespace", gw.String(), nspid)
-		}
-	}
+	return newLinkNsError("set netns", l.ifc.Name, nspath, setLinkNs(ctx, l.ifc, l.namePrefix, nspath))
+}
 
-	return nil
+// SetLinkNsToDocker sets the link's Linux namespace to a running Docker one specified by Docker name.
+func (l *Link) SetLinkNsToDocker(name string, dockerHost string) error {
+	return l.SetLinkNsToDockerContext(context.Background(), name, dockerHost)
 }
 
-// SetLinkNsFd sets the link's Linux namespace to the one specified by filesystem path.
-func (l *Link) SetLinkNsFd(nspath string) error {
-	fd, err := syscall.Open(nspath, syscall.O_RDONLY, 0)
+// SetLinkNsToDockerContext is like SetLinkNsToDocker but the Docker API request is aborted when the context is cancelled.
+func (l *Link) SetLinkNsToDockerContext(ctx context.Context, name string, dockerHost string) error {
+	pid, err := DockerPidByNameContext(ctx, name, dockerHost)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return newLinkNsError("set netns", l.ifc.Name, name, ctxErr)
+	}
+
 	if err != nil {
-		return fmt.Errorf("Could not attach to Network namespace: %s", err)
+		return newLinkNsError("set netns", l.ifc.Name, name, &lookupError{id: "docker " + name, err: err})
 	}
 
-	return netlink.NetworkSetNsFd(l.NetInterface(), fd)
+	return l.SetLinkNetNsPidContext(ctx, pid)
 }
 
-// SetLinkNsToDocker sets the link's Linux namespace to a running Docker one specified by Docker name.
-func (l *Link) SetLinkNsToDocker(name string, dockerHost string) error {
-	pid, err := DockerPidByName(name, dockerHost)
+// SetLinkNsTo sets the link's Linux namespace to the one of the container with the given ID.
+// The network namespace of the container is looked up by the resolver.
+func (l *Link) SetLinkNsTo(resolver NsResolver, id string) error {
+	return l.SetLinkNsToContext(context.Background(), resolver, id)
+}
+
+// SetLinkNsToContext is like SetLinkNsTo but the container lookup is aborted when the context is cancelled.
+func (l *Link) SetLinkNsToContext(ctx context.Context, resolver NsResolver, id string) error {
+	nspath, err := resolveNs(ctx, resolver, id)
 	if err != nil {
-		return fmt.Errorf("Failed to find docker %s :  %s", name, err)
+		return newLinkNsError("set netns", l.ifc.Name, id, err)
 	}
 
-	return l.SetLinkNetNsPid(pid)
+	return newLinkNsError("set netns", l.ifc.Name, id, setLinkNs(ctx, l.ifc, l.namePrefix, nspath))
 }
 
 // RenameInterfaceByName renames an interface of given name.
 func RenameInterfaceByName(old string, newName string) error {
-	iface, err := net.InterfaceByName(old)
+	if err := linkNameAvailable(newName); err != nil {
+		return newLinkError("rename", old, err)
+	}
+
+	iface, err := interfaceByName(old)
 	if err != nil {
-		return err
+		return newLinkError("rename", old, err)
 	}
-	return netlink.NetworkChangeName(iface, newName)
-}
 
-// setLinkOptions validates and sets link's various options passed in as LinkOptions.
-func setLinkOptions(ifc *net.Interface, opts LinkOptions) error {
-	macaddr, mtu, flags, ns := opts.MacAddr, opts.MTU, opts.Flags, opts.Ns
+	return newLinkError("rename", old, backend.LinkSetName(context.Background(), iface.Index, newName))
+}
 
-	// if MTU is passed in LinkOptions
-	if mtu != 0 {
-		if err := validMtu(mtu); err != nil {
+// validateLinkOptions validates link's various options passed in as LinkOptions.
+func validateLinkOptions(opts LinkOptions) error {
+	if opts.MTU != 0 {
+		if err := validMtu(opts.MTU); err != nil {
 			return err
 		}
-
-		if err := netlink.NetworkSetMTU(ifc, mtu); err != nil {
-			return fmt.Errorf("Unable to set MTU: %s", err)
-		}
 	}
 
-	// if MacAddress is passed in LinkOptions
-	if macaddr != "" {
-		if err := validMacAddress(macaddr); err != nil {
+	if opts.MacAddr != "" {
+		if err := validMacAddress(opts.MacAddr); err != nil {
 			return err
 		}
+	}
 
-		if err := netlink.NetworkSetMacAddress(ifc, macaddr); err != nil {
-			return fmt.Errorf("Unable to set MAC Address: %s", err)
+	if opts.Ns != 0 {
+		if err := validNs(opts.Ns); err != nil {
+			return err
 		}
 	}
 
-	// if ns is passed in LinkOptions
-	if ns != 0 {
-		if err := validNs(ns); err != nil {
+	if opts.Flags != 0 {
+		if err := validFlags(opts.Flags); err != nil {
 			return err
 		}
+	}
 
-		if err := netlink.NetworkSetNsPid(ifc, ns); err != nil {
-			return fmt.Errorf("Unable to set Network namespace: %s", err)
-		}
+	return nil
+}
+
+// linkOptionsTx records setting link's various options passed in as LinkOptions into the transaction.
+// Link is brought up after it has been moved to the network namespace if both options are specified.
+func linkOptionsTx(tx *Tx, ifcName string, opts LinkOptions) {
+	if opts.MTU != 0 {
+		tx.SetLinkMTU(ifcName, opts.MTU)
 	}
 
-	// if flags is passed in LinkOptions
-	if flags != 0 {
-		if err := validFlags(flags); err != nil {
-			return err
+	if opts.MacAddr != "" {
+		tx.SetLinkMacAddress(ifcName, opts.MacAddr)
+	}
+
+	if opts.Ns != 0 {
+		tx.SetLinkNsPid(ifcName, opts.Ns)
+	}
+
+	if (opts.Flags & syscall.IFF_UP) == syscall.IFF_UP {
+		tx.SetLinkUp(ifcName)
+	}
+}
+
+// configureLinkInNs configures IP address and default gateway of the link in network namespace
+// specified by PID and brings the link up. The context is checked before every change.
+func configureLinkInNs(ctx context.Context, ifc *net.Interface, nspid int, ip net.IP, network *net.IPNet, gw *net.IP) error {
+	ns := strconv.Itoa(nspid)
+	return newLinkNsError("configure", ifc.Name, ns, execInNetNs(nspid, func() error {
+		if err := ctx.Err(); err != nil {
+			return newLinkNsError("add address", ifc.Name, ns, err)
+		}
+
+		if err := backend.AddrAdd(ctx, ifc.Index, linkAddr(ip, network)); err != nil {
+			return newLinkNsError("add address", ifc.Name, ns, err)
 		}
 
-		if ns != 0 && (ns != 1 || ns != os.Getpid()) {
-			if (flags & syscall.IFF_UP) == syscall.IFF_UP {
-				origNs, _ := NetNsHandle(os.Getpid())
-				defer syscall.Close(int(origNs))
-				defer system.Setns(origNs, syscall.CLONE_NEWNET)
+		if err := ctx.Err(); err != nil {
+			return newLinkNsError("set up", ifc.Name, ns, err)
+		}
 
-				if err := SetNetNsToPid(ns); err != nil {
-					return fmt.Errorf("Switching to %d network namespace failed: %s", ns, err)
-				}
+		if err := backend.LinkSetUp(ctx, ifc.Index); err != nil {
+			return newLinkNsError("set up", ifc.Name, ns, err)
+		}
 
-				if err := netlink.NetworkLinkUp(ifc); err != nil {
-					return fmt.Errorf("Unable to bring %s interface UP: %d", ifc.Name, ns)
-				}
+		if gw != nil {
+			if err := ctx.Err(); err != nil {
+				return newLinkNsError("add default gw", ifc.Name, ns, err)
 			}
-		} else {
-			if err := netlink.NetworkLinkUp(ifc); err != nil {
-				return fmt.Errorf("Could not bring up network link %s: %s", ifc.Name, err)
+
+			if err := backend.RouteAdd(ctx, &Route{Gw: *gw, Index: ifc.Index}); err != nil {
+				return newLinkNsError("add default gw", ifc.Name, ns, err)
 			}
 		}
-	}
 
-	return nil
+		return nil
+	}))
 }
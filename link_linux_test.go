@@ -3,144 +3,85 @@ package tenus
 import (
 	"net"
 	"testing"
-	"time"
+
+	"github.com/milosgajdos/tenus/tenustest"
 )
 
 func Test_NewLink(t *testing.T) {
+	ns := tenustest.New(t)
+	ns.RequireLinkKind("dummy")
+
 	testLinks := []string{"ifc01", "ifc02", "ifc03"}
 	for _, tt := range testLinks {
-		tl := &testLink{}
-
-		if err := tl.prepTestLink(tt, "dummy"); err != nil {
-			t.Skipf("NewLink test requries external command: %v", err)
-		}
-
-		_, err := NewLink(tt)
-		if err != nil {
+		if _, err := NewLink(tt); err != nil {
 			t.Fatalf("NewLink(%s) failed to run: %s", tt, err)
 		}
 
-		if _, err := net.InterfaceByName(tt); err != nil {
-			tl.teardown()
-			t.Fatalf("Could not find %s on the host: %s", tt, err)
-		}
-
-		if err := tl.teardown(); err != nil {
-			t.Fatalf("testLink.teardown failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
-		}
+		ns.AssertLinkKind(tt, "dummy")
 	}
 }
 
 type ifcLinkOptsTest struct {
-	testLink
-	opts     LinkOptions
-	expected *net.Interface
+	name string
+	opts LinkOptions
+	ok   bool
 }
 
-var hwaddr, _ = net.ParseMAC("22:ce:e0:99:63:6f")
 var ifcLinkOptsTests = []ifcLinkOptsTest{
-	{testLink{name: "ifc01"},
-		LinkOptions{MacAddr: "22:ce:e0:99:63:6f", MTU: 1400, Flags: net.FlagUp},
-		&net.Interface{Name: "ifc01", MTU: 1400, Flags: net.FlagUp, HardwareAddr: hwaddr}},
-	{testLink{name: "ifc02"},
-		LinkOptions{},
-		&net.Interface{Name: "ifc01"}},
-	{testLink{name: "ifc03"},
-		LinkOptions{MTU: -100},
-		nil},
+	{"ifc01", LinkOptions{MacAddr: "22:ce:e0:99:63:6f", MTU: 1400, Flags: net.FlagUp}, true},
+	{"ifc02", LinkOptions{}, true},
+	{"ifc03", LinkOptions{MTU: -100}, false},
 }
 
 func Test_NewLinkWithOptions(t *testing.T) {
+	ns := tenustest.New(t)
+	ns.RequireLinkKind("dummy")
+
 	for _, tt := range ifcLinkOptsTests {
-		tl := &testLink{}
+		_, err := NewLinkWithOptions(tt.name, tt.opts)
+		if !tt.ok {
+			if err == nil {
+				t.Fatalf("NewLinkWithOptions(%s, %v) expected to fail", tt.name, tt.opts)
+			}
 
-		if err := tl.prepTestLink(tt.name, "dummy"); err != nil {
-			t.Skipf("NewLinkWithOptions test requries external command: %v", err)
+			ns.AssertNoLink(tt.name)
+			continue
 		}
 
-		_, err := NewLinkWithOptions(tt.name, tt.opts)
-		if err != nil && tt.expected != nil {
+		if err != nil {
 			t.Fatalf("NewLinkWithOptions(%s, %v) failed to run: %s", tt.name, tt.opts, err)
 		}
 
-		ifc, err := net.InterfaceByName(tt.name)
-		if err != nil && tt.expected != nil {
-			tl.teardown()
-			t.Fatalf("Could not find %s on the host: %s", tt.name, err)
-		}
-
-		if tt.expected != nil {
-			if ifc.Name != tt.name {
-				tl.teardown()
-				t.Fatalf("NewLinkWithOptions(%s, %v) failed: expected %s, returned: %s",
-					tt.name, tt.opts, tt.name, ifc.Name)
-			}
-
-			if tt.opts.MacAddr != "" {
-				if ifc.HardwareAddr.String() != tt.opts.MacAddr {
-					tl.teardown()
-					t.Fatalf("NewLinkWithOptions(%s, %v) failed: expected %s, returned: %s",
-						tt.name, tt.opts, tt.opts.MacAddr, ifc.HardwareAddr.String())
-				}
-			}
-
-			if tt.opts.MTU != 0 {
-				if ifc.MTU != tt.opts.MTU {
-					tl.teardown()
-					t.Fatalf("NewLinkWithOptions(%s, %v) failed: expected %d, returned: %d",
-						tt.name, tt.opts, tt.opts.MTU, ifc.MTU)
-				}
-			}
+		ns.AssertLinkKind(tt.name, "dummy")
 
-			if tt.opts.Flags != 0 {
-				if (ifc.Flags & tt.opts.Flags) != tt.opts.Flags {
-					tl.teardown()
-					t.Fatalf("NewLinkWithOptions(%s, %v) failed: expected %v, returned: %v",
-						tt.name, tt.opts, tt.opts.Flags, ifc.Flags)
-				}
-			}
+		if tt.opts.MacAddr != "" {
+			ns.AssertLinkMAC(tt.name, tt.opts.MacAddr)
+		}
 
-			if err := tl.teardown(); err != nil {
-				t.Fatalf("testIfcLink.teardown failed: %v", err)
-			} else {
-				time.Sleep(10 * time.Millisecond)
-			}
+		if tt.opts.MTU != 0 {
+			ns.AssertLinkMTU(tt.name, tt.opts.MTU)
 		}
 
-		if tt.expected == nil && ifc != nil {
-			tl.teardown()
-			t.Fatalf("NewLinkWithOptions(%s, %v) failed. Expected: %v, Returned: %v",
-				tt.name, tt.opts, tt.expected, ifc)
+		if tt.opts.Flags&net.FlagUp != 0 {
+			ns.AssertLinkUp(tt.name)
 		}
 	}
 }
 
 func Test_DeleteLink(t *testing.T) {
+	ns := tenustest.New(t)
+	ns.RequireLinkKind("dummy")
+
 	testLinks := []string{"ifc01", "ifc02", "ifc03"}
 	for _, tt := range testLinks {
-		tl := &testLink{}
-
-		if err := tl.prepTestLink(tt, "dummy"); err != nil {
-			t.Skipf("DeleteLink test requries external command: %v", err)
-		}
-
-		if err := tl.create(); err != nil {
-			t.Fatalf("testLink.setup failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
+		if _, err := NewLink(tt); err != nil {
+			t.Fatalf("NewLink(%s) failed to run: %s", tt, err)
 		}
 
 		if err := DeleteLink(tt); err != nil {
-			tl.teardown()
 			t.Fatalf("Failed to delete %s interface: %s", tt, err)
 		}
 
-		i, _ := net.InterfaceByName(tt)
-		if i != nil {
-			tl.teardown()
-			t.Fatalf("DeleteLink(%s) expected: nil, returned: %v", tt, i)
-		}
+		ns.AssertNoLink(tt)
 	}
 }
@@ -0,0 +1,122 @@
+package tenus
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+// MacGenerator returns MAC address of a new link of the given name.
+// Generators provided by tenus return locally administered unicast addresses.
+type MacGenerator func(name string) (net.HardwareAddr, error)
+
+// RandomMac returns random locally administered unicast MAC address.
+// It is MacGenerator which ignores the link name.
+func RandomMac(name string) (net.HardwareAddr, error) {
+	hwaddr := make(net.HardwareAddr, 6)
+	if _, err := rand.Read(hwaddr); err != nil {
+		return nil, fmt.Errorf("Could not generate random MAC address: %s", err)
+	}
+
+	return localUnicast(hwaddr), nil
+}
+
+// HashedMacs returns MacGenerator which derives MAC addresses deterministically from the id, e.g. container ID,
+// and the link name. The address is the locally administered unicast address made of the first 6 bytes
+// of SHA-256 hash of the id and the name, so links get the same MAC address when they are recreated.
+func HashedMacs(id string) MacGenerator {
+	return func(name string) (net.HardwareAddr, error) {
+		sum := sha256.Sum256([]byte(id + "/" + name))
+		return localUnicast(net.HardwareAddr(sum[:6])), nil
+	}
+}
+
+// OUIMacs returns MacGenerator which generates MAC addresses made of the 3 byte organizationally unique identifier,
+// e.g. 02:42:ac, followed by 3 byte counter starting at first. The counter is kept by the generator,
+// so separate generators of the same OUI return the same addresses.
+// The generator returns ErrInvalidMAC if the OUI is not locally administered unicast prefix
+// and error once the counter is exhausted.
+func OUIMacs(oui string, first uint32) MacGenerator {
+	prefix, err := hex.DecodeString(strings.Replace(oui, ":", "", -1))
+	switch {
+	case err != nil || len(prefix) != 3:
+		err = fmt.Errorf("%w: invalid OUI %q", ErrInvalidMAC, oui)
+	case prefix[0]&0x01 != 0 || prefix[0]&0x02 == 0:
+		err = fmt.Errorf("%w: OUI %s is not locally administered unicast prefix", ErrInvalidMAC, oui)
+	}
+
+	next := first
+	return func(name string) (net.HardwareAddr, error) {
+		if err != nil {
+			return nil, err
+		}
+
+		n := atomic.AddUint32(&next, 1) - 1
+		if n > 0xffffff || n < first {
+			return nil, fmt.Errorf("Could not generate MAC address: OUI %s counter exhausted", oui)
+		}
+
+		return net.HardwareAddr{prefix[0], prefix[1], prefix[2], byte(n >> 16), byte(n >> 8), byte(n)}, nil
+	}
+}
+
+// MacAddressValid checks if MAC address is valid 48-bit unicast address which can be assigned to a link.
+// It rejects multicast, broadcast and all-zero addresses.
+// It returns error wrapping ErrInvalidMAC if the MAC address is invalid.
+func MacAddressValid(macaddr string) (bool, error) {
+	hwaddr, err := net.ParseMAC(macaddr)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrInvalidMAC, err)
+	}
+
+	if len(hwaddr) != 6 {
+		return false, fmt.Errorf("%w: %s is not 48-bit MAC address", ErrInvalidMAC, macaddr)
+	}
+
+	zero := true
+	broadcast := true
+	for _, b := range hwaddr {
+		zero = zero && b == 0x00
+		broadcast = broadcast && b == 0xff
+	}
+
+	switch {
+	case zero:
+		return false, fmt.Errorf("%w: %s is all-zero address", ErrInvalidMAC, macaddr)
+	case broadcast:
+		return false, fmt.Errorf("%w: %s is broadcast address", ErrInvalidMAC, macaddr)
+	case hwaddr[0]&0x01 != 0:
+		return false, fmt.Errorf("%w: %s is multicast address", ErrInvalidMAC, macaddr)
+	}
+
+	return true, nil
+}
+
+// linkMacAddr returns MAC address option of the link of the given name.
+// If macaddr is empty, the address is generated by gen unless it's nil.
+func linkMacAddr(macaddr string, gen MacGenerator, name string) (string, error) {
+	if macaddr != "" || gen == nil {
+		return macaddr, nil
+	}
+
+	hwaddr, err := gen(name)
+	if err != nil {
+		return "", err
+	}
+
+	if ok, err := MacAddressValid(hwaddr.String()); !ok {
+		return "", err
+	}
+
+	return hwaddr.String(), nil
+}
+
+// localUnicast sets locally administered bit and clears multicast bit of the MAC address
+func localUnicast(hwaddr net.HardwareAddr) net.HardwareAddr {
+	hwaddr[0] = hwaddr[0]&^0x01 | 0x02
+	return hwaddr
+}
@@ -0,0 +1,121 @@
+package tenus
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+type macAddressTest struct {
+	macaddr string
+	valid   bool
+}
+
+var macAddressTests = []macAddressTest{
+	{"02:42:ac:11:00:02", true},
+	{"00:1b:21:3a:4f:10", true},
+	{"00:00:00:00:00:00", false},
+	{"ff:ff:ff:ff:ff:ff", false},
+	{"01:00:5e:00:00:01", false},
+	{"33:33:00:00:00:01", false},
+	{"00:00:00:00:fe:80:00:00:00:00:00:00:02:00:5e:10:00:00:00:01", false},
+	{"02:42:ac:11:00", false},
+	{"foobar", false},
+}
+
+func Test_MacAddressValid(t *testing.T) {
+	for _, tt := range macAddressTests {
+		ok, err := MacAddressValid(tt.macaddr)
+		if ok != tt.valid {
+			t.Errorf("MacAddressValid(%s) failed: expected %v, returned %v, %v", tt.macaddr, tt.valid, ok, err)
+		}
+
+		if !ok && !errors.Is(err, ErrInvalidMAC) {
+			t.Errorf("MacAddressValid(%s) failed: expected %v, returned %v", tt.macaddr, ErrInvalidMAC, err)
+		}
+	}
+}
+
+func Test_MacGenerators(t *testing.T) {
+	for i := 0; i < 16; i++ {
+		hwaddr, err := RandomMac("eth0")
+		if err != nil {
+			t.Fatalf("RandomMac() failed: %s", err)
+		}
+
+		if ok, err := MacAddressValid(hwaddr.String()); !ok || hwaddr[0]&0x02 == 0 {
+			t.Fatalf("RandomMac() failed: returned %s, %v", hwaddr, err)
+		}
+	}
+
+	gen := HashedMacs("ctr1")
+	hwaddr1, _ := gen("eth0")
+	hwaddr2, _ := HashedMacs("ctr1")("eth0")
+	hwaddr3, _ := gen("eth1")
+	hwaddr4, _ := HashedMacs("ctr2")("eth0")
+	if hwaddr1.String() != hwaddr2.String() || hwaddr1.String() == hwaddr3.String() || hwaddr1.String() == hwaddr4.String() {
+		t.Fatalf("HashedMacs() failed: returned %s, %s, %s, %s", hwaddr1, hwaddr2, hwaddr3, hwaddr4)
+	}
+
+	if ok, err := MacAddressValid(hwaddr1.String()); !ok || hwaddr1[0]&0x02 == 0 {
+		t.Fatalf("HashedMacs() failed: returned %s, %v", hwaddr1, err)
+	}
+
+	gen = OUIMacs("02:42:ac", 0xfffffe)
+	for _, expected := range []string{"02:42:ac:ff:ff:fe", "02:42:ac:ff:ff:ff"} {
+		if hwaddr, err := gen("eth0"); err != nil || hwaddr.String() != expected {
+			t.Fatalf("OUIMacs() failed: expected %s, returned %s, %v", expected, hwaddr, err)
+		}
+	}
+
+	if hwaddr, err := gen("eth0"); err == nil {
+		t.Fatalf("OUIMacs() expected to fail once the counter is exhausted, returned %s", hwaddr)
+	}
+
+	for _, oui := range []string{"00:1b:21", "03:42:ac", "02:42", "foobar"} {
+		if _, err := OUIMacs(oui, 1)("eth0"); !errors.Is(err, ErrInvalidMAC) {
+			t.Errorf("OUIMacs(%s) failed: expected %v, returned %v", oui, ErrInvalidMAC, err)
+		}
+	}
+}
+
+func Test_LinkMacGen(t *testing.T) {
+	defer SetBackend(SetBackend(NewFakeBackend()))
+
+	link, err := NewLinkWithOptions("tmacgen01", LinkOptions{MacGen: OUIMacs("02:42:ac", 1)})
+	if err != nil {
+		t.Fatalf("NewLinkWithOptions() failed: %s", err)
+	}
+
+	if hwaddr := link.NetInterface().HardwareAddr.String(); hwaddr != "02:42:ac:00:00:01" {
+		t.Fatalf("NewLinkWithOptions() failed: expected 02:42:ac:00:00:01, returned %s", hwaddr)
+	}
+
+	mvln, err := NewMacVlanLinkWithOptions("tmacgen01", MacVlanOptions{Dev: "tmacgen02", Mode: "bridge", MacGen: HashedMacs("ctr1")})
+	if err != nil {
+		t.Fatalf("NewMacVlanLinkWithOptions() failed: %s", err)
+	}
+
+	expected, _ := HashedMacs("ctr1")("tmacgen02")
+	if hwaddr := mvln.NetInterface().HardwareAddr.String(); hwaddr != expected.String() {
+		t.Fatalf("NewMacVlanLinkWithOptions() failed: expected %s, returned %s", expected, hwaddr)
+	}
+
+	// MacAddr takes precedence over the generator
+	vln, err := NewVlanLinkWithOptions("tmacgen01", VlanOptions{Dev: "tmacgen03", Id: 10, MacAddr: "02:42:ac:00:00:10", MacGen: RandomMac})
+	if err != nil {
+		t.Fatalf("NewVlanLinkWithOptions() failed: %s", err)
+	}
+
+	if hwaddr := vln.NetInterface().HardwareAddr.String(); hwaddr != "02:42:ac:00:00:10" {
+		t.Fatalf("NewVlanLinkWithOptions() failed: expected 02:42:ac:00:00:10, returned %s", hwaddr)
+	}
+
+	multicast := func(name string) (net.HardwareAddr, error) {
+		return net.HardwareAddr{0x01, 0x00, 0x5e, 0x00, 0x00, 0x01}, nil
+	}
+
+	if _, err := NewLinkWithOptions("tmacgen04", LinkOptions{MacGen: multicast}); !errors.Is(err, ErrInvalidMAC) {
+		t.Fatalf("NewLinkWithOptions() failed: expected %v, returned %v", ErrInvalidMAC, err)
+	}
+}
@@ -1,10 +1,9 @@
 package tenus
 
 import (
+	"context"
 	"fmt"
 	"net"
-
-	"github.com/docker/libcontainer/netlink"
 )
 
 // Default MacVlan mode
@@ -27,6 +26,8 @@ type MacVlanOptions struct {
 	Mode string
 	// MAC address
 	MacAddr string
+	// MAC address generator used if MacAddr is empty
+	MacGen MacGenerator `json:"-" yaml:"-"`
 }
 
 // MacVlaner embeds Linker interface and adds few more functions.
@@ -58,33 +59,38 @@ type MacVlanLink struct {
 // a random name starting with "mc". It sets the macvlan mode to "bridge" mode which is a default.
 // It returns error if the link could not be created.
 func NewMacVlanLink(masterDev string) (MacVlaner, error) {
-	macVlanDev := makeNetInterfaceName("mc")
+	var macVlanDev string
 
 	if ok, err := NetInterfaceNameValid(masterDev); !ok {
-		return nil, err
+		return nil, newLinkError("create", macVlanDev, err)
 	}
 
-	if _, err := net.InterfaceByName(masterDev); err != nil {
-		return nil, fmt.Errorf("Master MAC VLAN device %s does not exist on the host", masterDev)
+	master, err := interfaceByName(masterDev)
+	if err != nil {
+		return nil, newLinkError("create", macVlanDev, fmt.Errorf("%w: master MAC VLAN device %s does not exist on the host", ErrLinkNotFound, masterDev))
 	}
 
-	if err := netlink.NetworkLinkAddMacVlan(masterDev, macVlanDev, default_mode); err != nil {
-		return nil, err
+	err = createWithGeneratedNames("mc", []*string{&macVlanDev}, func() error {
+		return addLink(context.Background(), LinkSpec{Name: macVlanDev, Kind: "macvlan", ParentIndex: master.Index, MacVlanMode: default_mode})
+	})
+	if err != nil {
+		return nil, newLinkError("create", macVlanDev, err)
 	}
 
-	macVlanIfc, err := net.InterfaceByName(macVlanDev)
+	macVlanIfc, err := interfaceByName(macVlanDev)
 	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+		return nil, newLinkError("create", macVlanDev, err)
 	}
 
-	masterIfc, err := net.InterfaceByName(masterDev)
+	masterIfc, err := interfaceByName(masterDev)
 	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+		return nil, newLinkError("create", macVlanDev, err)
 	}
 
 	return &MacVlanLink{
 		Link: Link{
-			ifc: macVlanIfc,
+			ifc:        macVlanIfc,
+			namePrefix: "mc",
 		},
 		masterIfc: masterIfc,
 		mode:      default_mode,
@@ -99,55 +105,60 @@ func NewMacVlanLink(masterDev string) (MacVlaner, error) {
 // NewMacVlanLinkWithOptions returns MacVlaner which is initialized to a pointer of type MacVlanLink if the
 // macvlan link was created successfully on the Linux host. If particular option is empty, it sets default value if possible.
 // It returns error if the macvlan link could not be created or if incorrect options have been passed.
+// If setting the MAC address fails, the newly created link is deleted.
 func NewMacVlanLinkWithOptions(masterDev string, opts MacVlanOptions) (MacVlaner, error) {
+	return NewMacVlanLinkWithOptionsContext(context.Background(), masterDev, opts)
+}
+
+// NewMacVlanLinkWithOptionsContext is like NewMacVlanLinkWithOptions but it gives up once the context is done.
+// Links created before the context was done are deleted.
+func NewMacVlanLinkWithOptionsContext(ctx context.Context, masterDev string, opts MacVlanOptions) (MacVlaner, error) {
 	if ok, err := NetInterfaceNameValid(masterDev); !ok {
-		return nil, err
+		return nil, newLinkError("create", opts.Dev, err)
 	}
 
-	if _, err := net.InterfaceByName(masterDev); err != nil {
-		return nil, fmt.Errorf("Master MAC VLAN device %s does not exist on the host", masterDev)
+	master, err := interfaceByName(masterDev)
+	if err != nil {
+		return nil, newLinkError("create", opts.Dev, fmt.Errorf("%w: master MAC VLAN device %s does not exist on the host", ErrLinkNotFound, masterDev))
 	}
 
 	if err := validateMacVlanOptions(&opts); err != nil {
-		return nil, err
-	}
-
-	if err := netlink.NetworkLinkAddMacVlan(masterDev, opts.Dev, opts.Mode); err != nil {
-		return nil, err
+		return nil, newLinkError("create", opts.Dev, err)
 	}
 
-	macVlanIfc, err := net.InterfaceByName(opts.Dev)
-	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+	macVlan := &MacVlanLink{masterIfc: master, mode: opts.Mode}
+	if opts.Dev == "" {
+		macVlan.namePrefix = "mc"
 	}
-
-	if opts.MacAddr != "" {
-		if err := netlink.NetworkSetMacAddress(macVlanIfc, opts.MacAddr); err != nil {
-			if errDel := DeleteLink(macVlanIfc.Name); errDel != nil {
-				return nil, fmt.Errorf("Incorrect options specified. Attempt to delete the link failed: %s", errDel)
-			}
+	err = createWithGeneratedNames("mc", []*string{&opts.Dev}, func() error {
+		macaddr, err := linkMacAddr(opts.MacAddr, opts.MacGen, opts.Dev)
+		if err != nil {
+			return err
 		}
 
-		hwaddr, err := net.ParseMAC(opts.MacAddr)
-		if err != nil {
-			return nil, err
+		tx := NewTx().CreateLink(opts.Dev, func() error {
+			return addLink(ctx, LinkSpec{Name: opts.Dev, Kind: "macvlan", ParentIndex: master.Index, MacVlanMode: opts.Mode})
+		})
+
+		if macaddr != "" {
+			tx.SetLinkMacAddress(opts.Dev, macaddr)
 		}
 
-		macVlanIfc.HardwareAddr = hwaddr
-	}
+		// the link is deleted if it can't be looked up after it was created
+		return tx.Do("find macvlan link "+opts.Dev, func() error {
+			var err error
+			if macVlan.ifc, err = interfaceByName(opts.Dev); err != nil {
+				return err
+			}
 
-	masterIfc, err := net.InterfaceByName(masterDev)
+			return macVlan.Refresh()
+		}, nil).CommitContext(ctx)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+		return nil, newLinkError("create", opts.Dev, err)
 	}
 
-	return &MacVlanLink{
-		Link: Link{
-			ifc: macVlanIfc,
-		},
-		masterIfc: masterIfc,
-		mode:      opts.Mode,
-	}, nil
+	return macVlan, nil
 }
 
 // NetInterface returns macvlan link's network interface
@@ -155,6 +166,23 @@ func (macvln *MacVlanLink) NetInterface() *net.Interface {
 	return macvln.ifc
 }
 
+// Refresh reloads macvlan link's and its master's network interfaces from Linux kernel.
+// It returns error if either of the links no longer exists in the current network namespace.
+func (macvln *MacVlanLink) Refresh() error {
+	if err := macvln.Link.Refresh(); err != nil {
+		return err
+	}
+
+	masterIfc, err := interfaceByIndex(macvln.masterIfc.Index)
+	if err != nil {
+		return newLinkError("refresh", macvln.masterIfc.Name, err)
+	}
+
+	macvln.masterIfc = masterIfc
+
+	return nil
+}
+
 // MasterNetInterface returns macvlan link's master network interface
 func (macvln *MacVlanLink) MasterNetInterface() *net.Interface {
 	return macvln.masterIfc
@@ -165,17 +193,22 @@ func (macvln *MacVlanLink) Mode() string {
 	return macvln.mode
 }
 
+// ValidateMacVlanOptions validates MacVlanOptions the same way NewMacVlanLinkWithOptions
+// and NewMacVtapLinkWithOptions do. Missing mode is filled in with the default one.
+// Missing device name is left empty: the constructors generate it when creating the link.
+func ValidateMacVlanOptions(opts *MacVlanOptions) error {
+	return validateMacVlanOptions(opts)
+}
+
 func validateMacVlanOptions(opts *MacVlanOptions) error {
 	if opts.Dev != "" {
 		if ok, err := NetInterfaceNameValid(opts.Dev); !ok {
 			return err
 		}
 
-		if _, err := net.InterfaceByName(opts.Dev); err == nil {
-			return fmt.Errorf("MAC VLAN device %s already assigned on the host", opts.Dev)
+		if _, err := backend.LinkByName(context.Background(), opts.Dev); err == nil {
+			return fmt.Errorf("%w: MAC VLAN device %s already assigned on the host", ErrLinkExists, opts.Dev)
 		}
-	} else {
-		opts.Dev = makeNetInterfaceName("mc")
 	}
 
 	if opts.Mode != "" {
@@ -187,8 +220,8 @@ func validateMacVlanOptions(opts *MacVlanOptions) error {
 	}
 
 	if opts.MacAddr != "" {
-		if _, err := net.ParseMAC(opts.MacAddr); err != nil {
-			return fmt.Errorf("Incorrect MAC ADDRESS specified: %s", opts.MacAddr)
+		if ok, err := MacAddressValid(opts.MacAddr); !ok {
+			return err
 		}
 	}
 
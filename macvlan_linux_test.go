@@ -1,9 +1,9 @@
 package tenus
 
 import (
-	"net"
 	"testing"
-	"time"
+
+	"github.com/milosgajdos/tenus/tenustest"
 )
 
 type macvlnTest struct {
@@ -15,18 +15,10 @@ var macvlnTests = []macvlnTest{
 }
 
 func Test_NewMacVlanLink(t *testing.T) {
-	for _, tt := range macvlnTests {
-		tl := &testLink{}
-
-		if err := tl.prepTestLink(tt.masterDev, "dummy"); err != nil {
-			t.Skipf("NewMacVlanLink test requries external command: %v", err)
-		}
+	ns := tenustest.New(t)
 
-		if err := tl.create(); err != nil {
-			t.Fatalf("testLink.create failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
-		}
+	for _, tt := range macvlnTests {
+		newTestMaster(t, tt.masterDev)
 
 		mvln, err := NewMacVlanLink(tt.masterDev)
 		if err != nil {
@@ -34,33 +26,10 @@ func Test_NewMacVlanLink(t *testing.T) {
 		}
 
 		mvlnName := mvln.NetInterface().Name
-		if _, err := net.InterfaceByName(mvlnName); err != nil {
-			tl.teardown()
-			t.Fatalf("Could not find %s on the host: %s", mvlnName, err)
-		}
-
-		testRes, err := linkInfo(mvlnName, "macvlan")
-		if err != nil {
-			tl.teardown()
-			t.Fatalf("Failed to list %s operation mode: %s", mvlnName, err)
-		}
-
-		if testRes.linkType != "macvlan" {
-			tl.teardown()
-			t.Fatalf("NewMacVlanLink(%s) failed: expected macvlan, returned %s",
-				tt.masterDev, testRes.linkType)
-		}
+		ns.AssertLinkKind(mvlnName, "macvlan")
 
-		if testRes.linkData != "bridge" {
-			tl.teardown()
-			t.Fatalf("NewMacVlanLink(%s) failed: expected bridge, returned %s",
-				tt.masterDev, testRes.linkData)
-		}
-
-		if err := tl.teardown(); err != nil {
-			t.Fatalf("testLink.teardown failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
+		if mode := kernelLinkAttrs(t, mvlnName).MacVlanMode; mode != "bridge" {
+			t.Fatalf("NewMacVlanLink(%s) failed: expected bridge, returned %s", tt.masterDev, mode)
 		}
 	}
 }
@@ -75,62 +44,23 @@ var macvlnWithOptionsTests = []macvlnWithOptionsTest{
 }
 
 func Test_NewMacVlanLinkWithOptions(t *testing.T) {
-	for _, tt := range macvlnWithOptionsTests {
-		var iface *net.Interface
-
-		tl := &testLink{}
+	ns := tenustest.New(t)
 
-		if err := tl.prepTestLink(tt.masterDev, "dummy"); err != nil {
-			t.Skipf("NewMacVlanLinkWithOptions test requries external command: %v", err)
-		}
-
-		if err := tl.create(); err != nil {
-			t.Fatalf("testLink.create failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
-		}
+	for _, tt := range macvlnWithOptionsTests {
+		newTestMaster(t, tt.masterDev)
 
 		mvln, err := NewMacVlanLinkWithOptions(tt.masterDev, *tt.opts)
 		if err != nil {
-			t.Fatalf("NewMacVlanLinkWithOptions(%s, %s) failed to run: %s", tt.masterDev, *tt.opts, err)
-		}
-
-		iface = mvln.NetInterface()
-
-		if iface.HardwareAddr.String() != tt.opts.MacAddr {
-			tl.teardown()
-			t.Fatalf("NewMacVlanLinkWithOptions(%s, %s) failed: expected %s, returned %s",
-				tt.masterDev, *tt.opts, tt.opts.MacAddr, iface.HardwareAddr.String())
-		}
-
-		mvlnName := iface.Name
-		if _, err := net.InterfaceByName(mvlnName); err != nil {
-			tl.teardown()
-			t.Fatalf("Could not find %s on the host: %s", mvlnName, err)
-		}
-
-		testRes, err := linkInfo(mvlnName, "macvlan")
-		if err != nil {
-			tl.teardown()
-			t.Fatalf("Failed to list %s operation mode: %s", mvlnName, err)
+			t.Fatalf("NewMacVlanLinkWithOptions(%s, %v) failed to run: %s", tt.masterDev, *tt.opts, err)
 		}
 
-		if testRes.linkType != "macvlan" {
-			tl.teardown()
-			t.Fatalf("NewMacVlanLinkWithOptions(%s, %s) failed: expected macvlan, returned %s",
-				tt.masterDev, *tt.opts, testRes.linkType)
-		}
-
-		if testRes.linkData != "bridge" {
-			tl.teardown()
-			t.Fatalf("NewMacVlanLinkWithOptions(%s, %s) failed: expected bridge, returned %s",
-				tt.masterDev, *tt.opts, testRes.linkData)
-		}
+		mvlnName := mvln.NetInterface().Name
+		ns.AssertLinkKind(mvlnName, "macvlan")
+		ns.AssertLinkMAC(mvlnName, tt.opts.MacAddr)
 
-		if err := tl.teardown(); err != nil {
-			t.Fatalf("testLink.teardown failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
+		if mode := kernelLinkAttrs(t, mvlnName).MacVlanMode; mode != tt.opts.Mode {
+			t.Fatalf("NewMacVlanLinkWithOptions(%s, %v) failed: expected %s, returned %s",
+				tt.masterDev, *tt.opts, tt.opts.Mode, mode)
 		}
 	}
 }
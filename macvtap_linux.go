@@ -1,10 +1,8 @@
 package tenus
 
 import (
+	"context"
 	"fmt"
-	"net"
-
-	"github.com/docker/libcontainer/netlink"
 )
 
 // MacVtaper embeds MacVlaner interface
@@ -26,34 +24,39 @@ type MacVtapLink struct {
 // a random name starting with "mvt". It sets the macvlan mode to "bridge" which is a default.
 // It returns error if the link could not be created.
 func NewMacVtapLink(masterDev string) (MacVtaper, error) {
-	macVtapDev := makeNetInterfaceName("mvt")
+	var macVtapDev string
 
 	if ok, err := NetInterfaceNameValid(masterDev); !ok {
-		return nil, err
+		return nil, newLinkError("create", macVtapDev, err)
 	}
 
-	if _, err := net.InterfaceByName(masterDev); err != nil {
-		return nil, fmt.Errorf("Master MAC VTAP device %s does not exist on the host", masterDev)
+	master, err := interfaceByName(masterDev)
+	if err != nil {
+		return nil, newLinkError("create", macVtapDev, fmt.Errorf("%w: master MAC VTAP device %s does not exist on the host", ErrLinkNotFound, masterDev))
 	}
 
-	if err := netlink.NetworkLinkAddMacVtap(masterDev, macVtapDev, default_mode); err != nil {
-		return nil, err
+	err = createWithGeneratedNames("mvt", []*string{&macVtapDev}, func() error {
+		return addLink(context.Background(), LinkSpec{Name: macVtapDev, Kind: "macvtap", ParentIndex: master.Index, MacVlanMode: default_mode})
+	})
+	if err != nil {
+		return nil, newLinkError("create", macVtapDev, err)
 	}
 
-	macVtapIfc, err := net.InterfaceByName(macVtapDev)
+	macVtapIfc, err := interfaceByName(macVtapDev)
 	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+		return nil, newLinkError("create", macVtapDev, err)
 	}
 
-	masterIfc, err := net.InterfaceByName(masterDev)
+	masterIfc, err := interfaceByName(masterDev)
 	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+		return nil, newLinkError("create", macVtapDev, err)
 	}
 
 	return &MacVtapLink{
 		MacVlanLink: &MacVlanLink{
 			Link: Link{
-				ifc: macVtapIfc,
+				ifc:        macVtapIfc,
+				namePrefix: "mvt",
 			},
 			masterIfc: masterIfc,
 			mode:      default_mode,
@@ -68,56 +71,58 @@ func NewMacVtapLink(masterDev string) (MacVtaper, error) {
 // 		ip link add name ${macvlan name} link ${master interface} address ${macaddress} type macvtap mode ${mode}
 // NewMacVtapLinkWithOptions returns MacVtaper which is initialized to a pointer of type MacVtapLink if the
 // macvtap link was created successfully on the Linux host. It returns error if the macvtap link could not be created.
+// If setting the MAC address fails, the newly created link is deleted.
 func NewMacVtapLinkWithOptions(masterDev string, opts MacVlanOptions) (MacVtaper, error) {
+	return NewMacVtapLinkWithOptionsContext(context.Background(), masterDev, opts)
+}
+
+// NewMacVtapLinkWithOptionsContext is like NewMacVtapLinkWithOptions but it gives up once the context is done.
+// Links created before the context was done are deleted.
+func NewMacVtapLinkWithOptionsContext(ctx context.Context, masterDev string, opts MacVlanOptions) (MacVtaper, error) {
 	if ok, err := NetInterfaceNameValid(masterDev); !ok {
-		return nil, err
+		return nil, newLinkError("create", opts.Dev, err)
 	}
 
-	if _, err := net.InterfaceByName(masterDev); err != nil {
-		return nil, fmt.Errorf("Master MAC VLAN device %s does not exist on the host", masterDev)
+	master, err := interfaceByName(masterDev)
+	if err != nil {
+		return nil, newLinkError("create", opts.Dev, fmt.Errorf("%w: master MAC VLAN device %s does not exist on the host", ErrLinkNotFound, masterDev))
 	}
 
 	if err := validateMacVlanOptions(&opts); err != nil {
-		return nil, err
+		return nil, newLinkError("create", opts.Dev, err)
 	}
 
-	if err := netlink.NetworkLinkAddMacVtap(masterDev, opts.Dev, opts.Mode); err != nil {
-		return nil, err
+	macVtap := &MacVtapLink{MacVlanLink: &MacVlanLink{masterIfc: master, mode: opts.Mode}}
+	if opts.Dev == "" {
+		macVtap.namePrefix = "mvt"
 	}
-
-	macVtapIfc, err := net.InterfaceByName(opts.Dev)
-	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
-	}
-
-	if opts.MacAddr != "" {
-		if err := netlink.NetworkSetMacAddress(macVtapIfc, opts.MacAddr); err != nil {
-			if errDel := DeleteLink(macVtapIfc.Name); errDel != nil {
-				return nil, fmt.Errorf("Incorrect options specified. Attempt to delete the link failed: %s",
-					errDel)
-			}
+	err = createWithGeneratedNames("mvt", []*string{&opts.Dev}, func() error {
+		macaddr, err := linkMacAddr(opts.MacAddr, opts.MacGen, opts.Dev)
+		if err != nil {
+			return err
 		}
 
-		hwaddr, err := net.ParseMAC(opts.MacAddr)
-		if err != nil {
-			return nil, err
+		tx := NewTx().CreateLink(opts.Dev, func() error {
+			return addLink(ctx, LinkSpec{Name: opts.Dev, Kind: "macvtap", ParentIndex: master.Index, MacVlanMode: opts.Mode})
+		})
+
+		if macaddr != "" {
+			tx.SetLinkMacAddress(opts.Dev, macaddr)
 		}
 
-		macVtapIfc.HardwareAddr = hwaddr
-	}
+		// the link is deleted if it can't be looked up after it was created
+		return tx.Do("find macvtap link "+opts.Dev, func() error {
+			var err error
+			if macVtap.ifc, err = interfaceByName(opts.Dev); err != nil {
+				return err
+			}
 
-	masterIfc, err := net.InterfaceByName(masterDev)
+			return macVtap.Refresh()
+		}, nil).CommitContext(ctx)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+		return nil, newLinkError("create", opts.Dev, err)
 	}
 
-	return &MacVtapLink{
-		MacVlanLink: &MacVlanLink{
-			Link: Link{
-				ifc: macVtapIfc,
-			},
-			masterIfc: masterIfc,
-			mode:      opts.Mode,
-		},
-	}, nil
+	return macVtap, nil
 }
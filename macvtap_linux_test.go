@@ -1,9 +1,9 @@
 package tenus
 
 import (
-	"net"
 	"testing"
-	"time"
+
+	"github.com/milosgajdos/tenus/tenustest"
 )
 
 type macvtpTest struct {
@@ -15,18 +15,10 @@ var macvtpTests = []macvtpTest{
 }
 
 func Test_NewMacVtapLink(t *testing.T) {
-	for _, tt := range macvtpTests {
-		tl := &testLink{}
-
-		if err := tl.prepTestLink(tt.masterDev, "dummy"); err != nil {
-			t.Skipf("NewMacVtapLink test requries external command: %v", err)
-		}
+	ns := tenustest.New(t)
 
-		if err := tl.create(); err != nil {
-			t.Fatalf("testLink.create failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
-		}
+	for _, tt := range macvtpTests {
+		newTestMaster(t, tt.masterDev)
 
 		macvtp, err := NewMacVtapLink(tt.masterDev)
 		if err != nil {
@@ -34,33 +26,10 @@ func Test_NewMacVtapLink(t *testing.T) {
 		}
 
 		mvtpName := macvtp.NetInterface().Name
-		if _, err := net.InterfaceByName(mvtpName); err != nil {
-			tl.teardown()
-			t.Fatalf("Could not find %s on the host: %s", mvtpName, err)
-		}
-
-		testRes, err := linkInfo(mvtpName, "macvtap")
-		if err != nil {
-			tl.teardown()
-			t.Fatalf("Failed to list %s operation mode: %s", mvtpName, err)
-		}
-
-		if testRes.linkType != "macvtap" {
-			tl.teardown()
-			t.Fatalf("NewMacVtapLink(%s) failed: expected macvtap, returned %s",
-				tt.masterDev, testRes.linkType)
-		}
+		ns.AssertLinkKind(mvtpName, "macvtap")
 
-		if testRes.linkData != "bridge" {
-			tl.teardown()
-			t.Fatalf("NewMacVtapLink(%s) failed: expected bridge, returned %s",
-				tt.masterDev, testRes.linkData)
-		}
-
-		if err := tl.teardown(); err != nil {
-			t.Fatalf("testLink.teardown failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
+		if mode := kernelLinkAttrs(t, mvtpName).MacVlanMode; mode != "bridge" {
+			t.Fatalf("NewMacVtapLink(%s) failed: expected bridge, returned %s", tt.masterDev, mode)
 		}
 	}
 }
@@ -75,62 +44,23 @@ var macvtpWithOptionsTests = []macvtpWithOptionsTest{
 }
 
 func Test_NewMacVtapLinkWithOptions(t *testing.T) {
-	for _, tt := range macvtpWithOptionsTests {
-		var iface *net.Interface
-
-		tl := &testLink{}
+	ns := tenustest.New(t)
 
-		if err := tl.prepTestLink(tt.masterDev, "dummy"); err != nil {
-			t.Skipf("NewMacVtapLinkWithOptions test requries external command: %v", err)
-		}
-
-		if err := tl.create(); err != nil {
-			t.Fatalf("testLink.create failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
-		}
+	for _, tt := range macvtpWithOptionsTests {
+		newTestMaster(t, tt.masterDev)
 
 		macvtp, err := NewMacVtapLinkWithOptions(tt.masterDev, *tt.opts)
 		if err != nil {
-			t.Fatalf("NewMacVtapLinkWithOptions(%s, %s) failed to run: %s", tt.masterDev, *tt.opts, err)
-		}
-
-		iface = macvtp.NetInterface()
-
-		if iface.HardwareAddr.String() != tt.opts.MacAddr {
-			tl.teardown()
-			t.Fatalf("NewMacVtapLinkWithOptions(%s, %s) failed: expected %s, returned %s",
-				tt.masterDev, *tt.opts, tt.opts.MacAddr, iface.HardwareAddr.String())
-		}
-
-		mvtpName := iface.Name
-		if _, err := net.InterfaceByName(mvtpName); err != nil {
-			tl.teardown()
-			t.Fatalf("Could not find %s on the host: %s", mvtpName, err)
-		}
-
-		testRes, err := linkInfo(mvtpName, "macvtap")
-		if err != nil {
-			tl.teardown()
-			t.Fatalf("Failed to list %s operation mode: %s", mvtpName, err)
+			t.Fatalf("NewMacVtapLinkWithOptions(%s, %v) failed to run: %s", tt.masterDev, *tt.opts, err)
 		}
 
-		if testRes.linkType != "macvtap" {
-			tl.teardown()
-			t.Fatalf("NewMacVtapLinkWithOptions(%s, %s) failed: expected macvtap, returned %s",
-				tt.masterDev, *tt.opts, testRes.linkType)
-		}
-
-		if testRes.linkData != "bridge" {
-			tl.teardown()
-			t.Fatalf("NewMacVtapLinkWithOptions(%s, %s) failed: expected bridge, returned %s",
-				tt.masterDev, *tt.opts, testRes.linkData)
-		}
+		mvtpName := macvtp.NetInterface().Name
+		ns.AssertLinkKind(mvtpName, "macvtap")
+		ns.AssertLinkMAC(mvtpName, tt.opts.MacAddr)
 
-		if err := tl.teardown(); err != nil {
-			t.Fatalf("testLink.teardown failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
+		if mode := kernelLinkAttrs(t, mvtpName).MacVlanMode; mode != tt.opts.Mode {
+			t.Fatalf("NewMacVtapLinkWithOptions(%s, %v) failed: expected %s, returned %s",
+				tt.masterDev, *tt.opts, tt.opts.Mode, mode)
 		}
 	}
 }
@@ -0,0 +1,91 @@
+package tenus
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// NetNsRunDir is the directory where named network namespaces are bind mounted.
+// It is the same directory iproute2 uses so named namespaces are visible to: ip netns list
+var NetNsRunDir = "/var/run/netns"
+
+// NetNsPath returns filesystem path of the named network namespace.
+func NetNsPath(name string) string {
+	return filepath.Join(NetNsRunDir, name)
+}
+
+// NamedNetNsExists returns true if the named network namespace exists on the host.
+func NamedNetNsExists(name string) bool {
+	return backend.NsExists(NetNsPath(name))
+}
+
+// NamedNetNsList returns names of all named network namespaces on the host.
+// It is equivalent of running: ip netns list
+func NamedNetNsList() ([]string, error) {
+	nspaths, err := backend.NsList()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(nspaths))
+	for _, nspath := range nspaths {
+		names = append(names, filepath.Base(nspath))
+	}
+
+	return names, nil
+}
+
+// NewNamedNetNs creates new named network namespace.
+//
+// It is equivalent of running: ip netns add ${name}
+// It returns error if the network namespace already exists or if it could not be created.
+func NewNamedNetNs(name string) error {
+	if name == "" || filepath.Base(name) != name {
+		return fmt.Errorf("Invalid network namespace name: %q", name)
+	}
+
+	return backend.NsCreate(NetNsPath(name))
+}
+
+// DeleteNamedNetNs deletes named network namespace.
+//
+// It is equivalent of running: ip netns delete ${name}
+// Network namespace is destroyed by kernel once the last process running in it exits.
+// It returns error if the network namespace could not be unmounted.
+func DeleteNamedNetNs(name string) error {
+	if name == "" || filepath.Base(name) != name {
+		return fmt.Errorf("Invalid network namespace name: %q", name)
+	}
+
+	return backend.NsDelete(NetNsPath(name))
+}
+
+// ExecInNamedNetNs runs fn in the named network namespace on a locked OS thread.
+// If name is empty, fn runs in the current network namespace.
+func ExecInNamedNetNs(name string, fn func() error) error {
+	if name == "" {
+		return fn()
+	}
+
+	if !NamedNetNsExists(name) {
+		return fmt.Errorf("%w: %s", ErrNsNotFound, name)
+	}
+
+	return execInNetNsPath(NetNsPath(name), fn)
+}
+
+// ExecInNetNsPath runs fn in network namespace specified by filesystem path on a locked OS thread.
+// The path is either a pinned network namespace or /proc/${PID}/ns/net.
+// If nspath is empty, fn runs in the current network namespace.
+// It returns ErrNsNotFound if the network namespace does not exist.
+func ExecInNetNsPath(nspath string, fn func() error) error {
+	if nspath == "" {
+		return fn()
+	}
+
+	if !backend.NsExists(nspath) {
+		return fmt.Errorf("%w: %s", ErrNsNotFound, nspath)
+	}
+
+	return execInNetNsPath(nspath, fn)
+}
@@ -1,11 +1,21 @@
 package tenus
 
 import (
-	"github.com/docker/libcontainer/netlink"
+	"net"
 )
 
 type NetworkOptions struct {
 	IpAddr string
 	Gw     string
-	Routes []netlink.Route
+	Routes []Route
+}
+
+// Route describes a route in the main routing table.
+type Route struct {
+	// Destination network. nil for the default route.
+	Dst *net.IPNet
+	// Gateway address. nil for directly connected routes.
+	Gw net.IP
+	// Index of the route's output link
+	Index int
 }
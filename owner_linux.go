@@ -0,0 +1,234 @@
+package tenus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ownerTagPrefix prefixes aliases of the links created by tenus
+const ownerTagPrefix = "tenus:"
+
+// linkOwner is the owner ID links created by tenus are tagged with
+var linkOwner = "tenus"
+
+// OwnedLink is a network link tagged with ownership tag by tenus.
+type OwnedLink struct {
+	// Named network namespace the link lives in. Empty for the current network namespace.
+	Ns string
+	// Owner ID the link was tagged with
+	Owner string
+	// Inode number of the PID namespace of the process which created the link
+	PidNs uint64
+	// PID of the process which created the link
+	Pid int
+	// Time the link was created at
+	Created time.Time
+	// Link attributes
+	Attrs *LinkAttrs
+}
+
+// SetLinkOwner sets owner ID of the links created by tenus and returns the previous one.
+//
+// Every link tenus creates, including both veth peers, is tagged with the owner ID, PID namespace and PID
+// of the creating process and the creation time. The tag is stored in the link's alias:
+// tenus:${owner}:${pidns}:${pid}:${unixtime} where ${pidns} is inode number of the PID namespace.
+// Default owner ID is "tenus". Empty owner ID disables tagging.
+// SetLinkOwner is not safe to call concurrently with other tenus functions.
+func SetLinkOwner(owner string) string {
+	prev := linkOwner
+	linkOwner = owner
+
+	return prev
+}
+
+// ownerTag returns ownership tag of links created now by the current process.
+// It returns empty string if tagging is disabled.
+func ownerTag() string {
+	if linkOwner == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s%s:%d:%d:%d", ownerTagPrefix, linkOwner, pidNsInode(), os.Getpid(), time.Now().Unix())
+}
+
+// ownerTagInfo is a decoded ownership tag
+type ownerTagInfo struct {
+	owner   string
+	pidNs   uint64
+	pid     int
+	created time.Time
+}
+
+// parseOwnerTag decodes the link alias into owner ID, PID namespace, PID and creation time.
+// It returns false if the alias is not an ownership tag.
+func parseOwnerTag(alias string) (ownerTagInfo, bool) {
+	if !strings.HasPrefix(alias, ownerTagPrefix) {
+		return ownerTagInfo{}, false
+	}
+
+	// owner ID may contain colons so the tag is split from the right
+	fields := strings.Split(strings.TrimPrefix(alias, ownerTagPrefix), ":")
+	if len(fields) < 4 {
+		return ownerTagInfo{}, false
+	}
+
+	n := len(fields)
+	owner := strings.Join(fields[:n-3], ":")
+	pidNs, err := strconv.ParseUint(fields[n-3], 10, 64)
+	if err != nil || owner == "" {
+		return ownerTagInfo{}, false
+	}
+
+	pid, err := strconv.Atoi(fields[n-2])
+	if err != nil || pid <= 0 {
+		return ownerTagInfo{}, false
+	}
+
+	created, err := strconv.ParseInt(fields[n-1], 10, 64)
+	if err != nil {
+		return ownerTagInfo{}, false
+	}
+
+	return ownerTagInfo{owner: owner, pidNs: pidNs, pid: pid, created: time.Unix(created, 0)}, true
+}
+
+// pidNsInode returns inode number of the current process' PID namespace or 0 if it can't be read
+func pidNsInode() uint64 {
+	var st syscall.Stat_t
+	if err := syscall.Stat("/proc/self/ns/pid", &st); err != nil {
+		return 0
+	}
+
+	return st.Ino
+}
+
+// addLink creates the link described by spec tagged with ownership tag
+func addLink(ctx context.Context, spec LinkSpec) error {
+	spec.Alias = ownerTag()
+
+	return backend.LinkAdd(ctx, spec)
+}
+
+// ListOwnedLinks returns links tagged with the owner ID in the current and all named network namespaces.
+// If owner is empty, links of all owners are returned.
+// It returns error if any of the network namespaces could not be inspected.
+func ListOwnedLinks(owner string) ([]OwnedLink, error) {
+	names, err := NamedNetNsList()
+	if err != nil {
+		return nil, err
+	}
+
+	var owned []OwnedLink
+	for _, ns := range append([]string{""}, names...) {
+		err := ExecInNamedNetNs(ns, func() error {
+			links, err := backend.LinkList(context.Background())
+			if err != nil {
+				return err
+			}
+
+			for _, attrs := range links {
+				tag, ok := parseOwnerTag(attrs.Alias)
+				if !ok || (owner != "" && tag.owner != owner) {
+					continue
+				}
+
+				owned = append(owned, OwnedLink{
+					Ns:      ns,
+					Owner:   tag.owner,
+					PidNs:   tag.pidNs,
+					Pid:     tag.pid,
+					Created: tag.created,
+					Attrs:   attrs,
+				})
+			}
+
+			return nil
+		})
+		// network namespace might have been deleted since it was listed
+		if err != nil && !errors.Is(err, ErrNsNotFound) {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(owned, func(i, j int) bool {
+		return owned[i].Ns < owned[j].Ns
+	})
+
+	return owned, nil
+}
+
+// GarbageCollect deletes orphaned links tagged with the owner ID in the current and all named network namespaces
+// and returns them. Deleting a veth link deletes its peer, too, regardless of the network namespace it lives in.
+//
+// A link is orphaned if it was created more than olderThan ago by a process which is no longer running.
+// PIDs of processes running in other PID namespaces can't be checked, so links created in a PID namespace
+// other than the caller's are never deleted; run GarbageCollect in the PID namespace of the link owners.
+// Before deleting a link GarbageCollect checks that its ownership tag has not changed, so links
+// recreated under the same name are not deleted. GarbageCollect carries on when a link
+// could not be deleted and returns the first error it encountered.
+func GarbageCollect(owner string, olderThan time.Duration) ([]OwnedLink, error) {
+	if owner == "" {
+		return nil, fmt.Errorf("Invalid owner: %q", owner)
+	}
+
+	owned, err := ListOwnedLinks(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	pidNs := pidNsInode()
+
+	var deleted []OwnedLink
+	var firstErr error
+	for _, link := range owned {
+		if link.PidNs == 0 || link.PidNs != pidNs {
+			continue
+		}
+
+		if time.Since(link.Created) < olderThan || processAlive(link.Pid) {
+			continue
+		}
+
+		err := ExecInNamedNetNs(link.Ns, func() error {
+			attrs, err := backend.LinkByIndex(context.Background(), link.Attrs.Index)
+			if err != nil {
+				// veth peer or parent device has already been deleted
+				return err
+			}
+
+			if attrs.Name != link.Attrs.Name || attrs.Alias != link.Attrs.Alias {
+				return fmt.Errorf("ownership tag changed to %q", attrs.Alias)
+			}
+
+			return backend.LinkDel(context.Background(), attrs.Index)
+		})
+		if err == nil || errors.Is(err, ErrLinkNotFound) || errors.Is(err, ErrNsNotFound) {
+			deleted = append(deleted, link)
+			continue
+		}
+
+		if firstErr == nil {
+			firstErr = newLinkNsError("delete", link.Attrs.Name, link.Ns, err)
+		}
+	}
+
+	return deleted, firstErr
+}
+
+// processAlive returns true if process with the given PID is running
+func processAlive(pid int) bool {
+	if pid == os.Getpid() {
+		return true
+	}
+
+	err := syscall.Kill(pid, 0)
+
+	return err == nil || err == syscall.EPERM
+}
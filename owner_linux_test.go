@@ -0,0 +1,232 @@
+package tenus
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/milosgajdos/tenus/tenustest"
+)
+
+// deadPid is above the maximum PID Linux kernel allocates, so no process ever runs with it
+const deadPid = 1<<22 + 1
+
+// otherPidNs is inode number of a PID namespace the tests never run in
+const otherPidNs = 1
+
+type ownerTagTest struct {
+	alias   string
+	owner   string
+	pidNs   uint64
+	pid     int
+	created int64
+	ok      bool
+}
+
+var ownerTagTests = []ownerTagTest{
+	{"tenus:app01:4026531836:1234:1500000000", "app01", 4026531836, 1234, 1500000000, true},
+	{"tenus:app:01:4026531836:1234:1500000000", "app:01", 4026531836, 1234, 1500000000, true},
+	{"tenus:app01:1234:1500000000", "", 0, 0, 0, false},
+	{"tenus:4026531836:1234:1500000000", "", 0, 0, 0, false},
+	{"tenus::4026531836:1234:1500000000", "", 0, 0, 0, false},
+	{"tenus:app01:pidns:1234:1500000000", "", 0, 0, 0, false},
+	{"tenus:app01:4026531836:pid:1500000000", "", 0, 0, 0, false},
+	{"tenus:app01:4026531836:1234:yesterday", "", 0, 0, 0, false},
+	{"uplink to core switch", "", 0, 0, 0, false},
+	{"", "", 0, 0, 0, false},
+}
+
+func Test_ParseOwnerTag(t *testing.T) {
+	for _, tt := range ownerTagTests {
+		tag, ok := parseOwnerTag(tt.alias)
+		if ok != tt.ok {
+			t.Errorf("parseOwnerTag(%q) failed: expected %v, returned %v", tt.alias, tt.ok, ok)
+			continue
+		}
+
+		if ok && (tag.owner != tt.owner || tag.pidNs != tt.pidNs || tag.pid != tt.pid || tag.created.Unix() != tt.created) {
+			t.Errorf("parseOwnerTag(%q) failed: returned %+v", tt.alias, tag)
+		}
+	}
+}
+
+// testOwnerTag returns ownership tag of the link created by the process in the PID namespace at the given time
+func testOwnerTag(owner string, pidNs uint64, pid int, created int64) string {
+	return fmt.Sprintf("tenus:%s:%d:%d:%d", owner, pidNs, pid, created)
+}
+
+func Test_ListOwnedLinks(t *testing.T) {
+	defer SetBackend(SetBackend(NewFakeBackend()))
+	defer SetLinkOwner(SetLinkOwner("app01"))
+
+	if _, err := NewVethPairWithOptions("vethown01", VethOptions{PeerName: "vethown02"}); err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	if err := NewNamedNetNs("nsown01"); err != nil {
+		t.Fatalf("NewNamedNetNs() failed: %s", err)
+	}
+
+	err := ExecInNamedNetNs("nsown01", func() error {
+		_, err := NewBridgeWithName("brown01")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("NewBridgeWithName() failed: %s", err)
+	}
+
+	SetLinkOwner("app02")
+	if _, err := NewBridgeWithName("brown02"); err != nil {
+		t.Fatalf("NewBridgeWithName() failed: %s", err)
+	}
+
+	SetLinkOwner("")
+	if _, err := NewBridgeWithName("brown03"); err != nil {
+		t.Fatalf("NewBridgeWithName() failed: %s", err)
+	}
+
+	owned, err := ListOwnedLinks("app01")
+	if err != nil {
+		t.Fatalf("ListOwnedLinks() failed: %s", err)
+	}
+
+	if len(owned) != 3 || owned[2].Ns != "nsown01" || owned[2].Attrs.Name != "brown01" {
+		t.Fatalf("ListOwnedLinks() failed: returned %+v", owned)
+	}
+
+	for _, link := range owned {
+		if link.Owner != "app01" || link.PidNs != pidNsInode() || link.Pid != os.Getpid() || time.Since(link.Created) > time.Minute {
+			t.Fatalf("ListOwnedLinks() failed: returned %+v", link)
+		}
+	}
+
+	if owned, err := ListOwnedLinks(""); err != nil || len(owned) != 4 {
+		t.Fatalf("ListOwnedLinks() failed: returned %+v, %v", owned, err)
+	}
+}
+
+func Test_GarbageCollect(t *testing.T) {
+	fake := NewFakeBackend()
+	defer SetBackend(SetBackend(fake))
+
+	pidNs := pidNsInode()
+	if pidNs == 0 {
+		t.Skipf("GarbageCollect test requires PID namespace of the process")
+	}
+
+	old := time.Now().Add(-2 * time.Hour).Unix()
+	orphan := testOwnerTag("app01", pidNs, deadPid, old)
+	specs := []LinkSpec{
+		{Name: "vethgc01", Kind: "veth", PeerName: "vethgc02", Alias: orphan},
+		{Name: "brgc01", Kind: "bridge", Alias: orphan},
+		// created by a running process
+		{Name: "brgc02", Kind: "bridge", Alias: testOwnerTag("app01", pidNs, os.Getpid(), old)},
+		// created recently
+		{Name: "brgc03", Kind: "bridge", Alias: testOwnerTag("app01", pidNs, deadPid, time.Now().Unix())},
+		// created by another owner
+		{Name: "brgc04", Kind: "bridge", Alias: testOwnerTag("app02", pidNs, deadPid, old)},
+		{Name: "brgc05", Kind: "bridge"},
+		// created in another PID namespace, e.g. by a process running in a container
+		{Name: "brgc06", Kind: "bridge", Alias: testOwnerTag("app01", otherPidNs, deadPid, old)},
+	}
+
+	for _, spec := range specs {
+		if err := fake.LinkAdd(context.Background(), spec); err != nil {
+			t.Fatalf("LinkAdd(%+v) failed: %s", spec, err)
+		}
+	}
+
+	if err := NewNamedNetNs("nsgc01"); err != nil {
+		t.Fatalf("NewNamedNetNs() failed: %s", err)
+	}
+
+	peer, err := fake.LinkByName(context.Background(), "vethgc02")
+	if err != nil {
+		t.Fatalf("LinkByName() failed: %s", err)
+	}
+
+	if err := fake.LinkSetNs(context.Background(), peer.Index, NetNsPath("nsgc01")); err != nil {
+		t.Fatalf("LinkSetNs() failed: %s", err)
+	}
+
+	if _, err := GarbageCollect("", time.Hour); err == nil {
+		t.Fatalf("GarbageCollect() expected to fail with empty owner")
+	}
+
+	deleted, err := GarbageCollect("app01", time.Hour)
+	if err != nil {
+		t.Fatalf("GarbageCollect() failed: %s", err)
+	}
+
+	if len(deleted) != 3 {
+		t.Fatalf("GarbageCollect() failed: expected 3 deleted links, returned %+v", deleted)
+	}
+
+	for _, name := range []string{"vethgc01", "brgc01"} {
+		if _, err := LinkAttrsByName(name); err == nil {
+			t.Fatalf("GarbageCollect() failed to delete %s", name)
+		}
+	}
+
+	for _, name := range []string{"brgc02", "brgc03", "brgc04", "brgc05", "brgc06"} {
+		if _, err := LinkAttrsByName(name); err != nil {
+			t.Fatalf("GarbageCollect() deleted %s: %s", name, err)
+		}
+	}
+
+	err = ExecInNamedNetNs("nsgc01", func() error {
+		links, err := LinkList()
+		if err != nil {
+			return err
+		}
+
+		if len(links) != 1 {
+			t.Errorf("GarbageCollect() failed to delete veth peer: %+v", links)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExecInNamedNetNs() failed: %s", err)
+	}
+}
+
+func Test_OwnerTagKernel(t *testing.T) {
+	ns := tenustest.New(t)
+	defer SetLinkOwner(SetLinkOwner("appown01"))
+
+	if _, err := NewVethPairWithOptions("vethown01", VethOptions{PeerName: "vethown02"}); err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	for _, name := range []string{"vethown01", "vethown02"} {
+		attrs, err := LinkAttrsByName(name)
+		if err != nil {
+			t.Fatalf("LinkAttrsByName() failed: %s", err)
+		}
+
+		tag, ok := parseOwnerTag(attrs.Alias)
+		if !ok || tag.owner != "appown01" || tag.pidNs != pidNsInode() || tag.pid != os.Getpid() {
+			t.Fatalf("NewVethPairWithOptions() failed to tag %s: alias %q", name, attrs.Alias)
+		}
+	}
+
+	owned, err := ListOwnedLinks("appown01")
+	if err != nil {
+		t.Fatalf("ListOwnedLinks() failed: %s", err)
+	}
+
+	if len(owned) != 2 {
+		t.Fatalf("ListOwnedLinks() failed: returned %+v", owned)
+	}
+
+	// links of running processes are never collected
+	deleted, err := GarbageCollect("appown01", 0)
+	if err != nil || len(deleted) != 0 {
+		t.Fatalf("GarbageCollect() failed: returned %+v, %v", deleted, err)
+	}
+
+	ns.AssertLinkExists("vethown01")
+}
@@ -0,0 +1,795 @@
+package tenus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"syscall"
+	"time"
+)
+
+// qdisc kind specific attributes which are not exported by syscall package
+const (
+	tcaTbfParms        = 1
+	tcaTbfRate64       = 4
+	tcaTbfPrate64      = 5
+	tcaTbfBurst        = 6
+	tcaTbfPburst       = 7
+	tcaHtbInit         = 2
+	tcaHtbDirectQlen   = 5
+	tcaFqCodelTarget   = 1
+	tcaFqCodelLimit    = 2
+	tcaFqCodelInterval = 3
+	tcaFqCodelEcn      = 4
+	tcaFqCodelFlows    = 5
+	tcaFqCodelQuantum  = 6
+	tcaNetemCorr       = 1
+	tcaNetemReorder    = 3
+	tcaNetemRate       = 6
+	tcaNetemRate64     = 8
+	tcaNetemLatency64  = 10
+	tcaNetemJitter64   = 11
+)
+
+// sizes of qdisc option structures
+const (
+	sizeofTcRatespec = 12
+	sizeofTbfQopt    = 36
+	sizeofHtbGlob    = 20
+	sizeofPrioQopt   = 20
+	sizeofNetemQopt  = 24
+	sizeofTcStats    = 36
+)
+
+// kernel defaults and constants of qdisc options
+const (
+	tcLinklayerEther  = 1
+	htbVersion        = 3
+	htbRate2Quantum   = 10
+	netemDefaultLimit = 1000
+)
+
+// qdiscKinds are qdisc kinds which can be attached to links by tenus
+var qdiscKinds = map[string]bool{
+	"pfifo_fast": true,
+	"fq_codel":   true,
+	"tbf":        true,
+	"htb":        true,
+	"prio":       true,
+	"ingress":    true,
+	"clsact":     true,
+	"netem":      true,
+}
+
+// defaultPriomap is the priority to band mapping used by prio qdisc with 3 bands
+var defaultPriomap = [16]uint8{1, 2, 2, 2, 1, 2, 0, 0, 1, 1, 1, 1, 1, 1, 1, 1}
+
+// Qdisc is a queueing discipline which schedules packets sent or received by a link.
+// Only the options field which matches the qdisc Kind is set. Qdiscs without options,
+// i.e. pfifo_fast, ingress and clsact, and qdiscs configured with kernel defaults don't need any.
+type Qdisc struct {
+	// Qdisc kind: pfifo_fast, fq_codel, tbf, htb, prio, ingress, clsact or netem
+	Kind string
+	// Qdisc handle, i.e. MakeHandle(1, 0). 0 lets the kernel choose the handle.
+	Handle uint32
+	// Parent handle: HandleRoot, HandleIngress or handle of the parent class.
+	// 0 attaches ingress and clsact qdiscs to HandleIngress and other qdiscs to HandleRoot.
+	Parent uint32
+	// Options of tbf qdisc. They're required.
+	Tbf *TbfOptions
+	// Options of htb qdisc
+	Htb *HtbOptions
+	// Options of prio qdisc
+	Prio *PrioOptions
+	// Options of fq_codel qdisc
+	FqCodel *FqCodelOptions
+	// Options of netem qdisc
+	Netem *NetemOptions
+	// Qdisc statistics. It's only set by Qdiscs.
+	Stats *QdiscStats
+}
+
+// TbfOptions configures token bucket filter which shapes traffic to the given rate.
+type TbfOptions struct {
+	// Rate in bytes per second
+	Rate uint64
+	// Size of the bucket in bytes
+	Burst uint32
+	// Number of bytes which can be queued waiting for tokens. It is computed from Latency if it's 0.
+	Limit uint32
+	// Maximum time a packet can wait for tokens. Only used if Limit is 0.
+	Latency time.Duration
+	// Peak rate in bytes per second. 0 disables the peak rate bucket.
+	PeakRate uint64
+	// Size of the peak rate bucket in bytes. Required if PeakRate is set.
+	MinBurst uint32
+}
+
+// HtbOptions configures hierarchy token bucket qdisc. Its classes are managed separately.
+type HtbOptions struct {
+	// Minor number of the class unclassified traffic is sent to. 0 sends it directly to the link.
+	Default uint16
+	// Divisor of class rates used to compute the classes' quantum. 0 uses the kernel default 10.
+	Rate2Quantum uint32
+	// Length of the queue of unclassified traffic sent directly to the link. 0 uses the link's TX queue length.
+	DirectQlen uint32
+}
+
+// PrioOptions configures priority qdisc with the given number of bands.
+type PrioOptions struct {
+	// Number of bands. 0 uses 3 bands.
+	Bands uint32
+	// Band of each of 16 packet priorities. Zero value uses the kernel default map if Bands is 0 or 3.
+	Priomap [16]uint8
+}
+
+// FqCodelOptions configures fair queuing controlled delay qdisc. Zero values keep the kernel defaults.
+type FqCodelOptions struct {
+	// Maximum number of queued packets
+	Limit uint32
+	// Number of flows packets are hashed into
+	Flows uint32
+	// Acceptable minimum queue delay
+	Target time.Duration
+	// Interval the queue delay is measured in
+	Interval time.Duration
+	// Number of bytes dequeued from a flow at once
+	Quantum uint32
+	// DisableECN drops packets instead of marking them with ECN
+	DisableECN bool
+}
+
+// NetemOptions configures network emulator which delays, drops, duplicates, reorders and rate limits packets.
+// Probabilities and correlations are percentages.
+type NetemOptions struct {
+	// Delay added to each packet
+	Delay time.Duration
+	// Random variation of the delay
+	Jitter time.Duration
+	// Correlation of the delay of successive packets
+	DelayCorrelation float64
+	// Probability of dropping a packet
+	Loss float64
+	// Correlation of successive packet drops
+	LossCorrelation float64
+	// Probability of duplicating a packet
+	Duplicate float64
+	// Correlation of successive packet duplications
+	DuplicateCorrelation float64
+	// Probability of sending a packet immediately instead of delaying it
+	Reorder float64
+	// Correlation of successive reorderings
+	ReorderCorrelation float64
+	// Reorder only every Gap-th packet. 0 reorders any packet if Reorder is set.
+	Gap uint32
+	// Rate in bytes per second. 0 doesn't limit the rate.
+	Rate uint64
+	// Maximum number of queued packets. 0 uses 1000 packets.
+	Limit uint32
+}
+
+// QdiscStats are qdisc and class statistics reported by Linux kernel.
+type QdiscStats struct {
+	// Number of bytes sent
+	Bytes uint64
+	// Number of packets sent
+	Packets uint32
+	// Number of dropped packets
+	Drops uint32
+	// Number of times the qdisc was over its limit
+	Overlimits uint32
+	// Number of queued packets
+	Qlen uint32
+	// Number of queued bytes
+	Backlog uint32
+}
+
+// AddQdisc attaches the qdisc to the link. It fails if the link already has a qdisc with the same parent or handle.
+// It is equivalent of running: tc qdisc add dev ${link} parent ${parent} handle ${handle} ${kind} ${options}
+func (l *Link) AddQdisc(q *Qdisc) error {
+	return l.AddQdiscContext(context.Background(), q)
+}
+
+// AddQdiscContext is like AddQdisc but it returns without changing the link if the context is done.
+func (l *Link) AddQdiscContext(ctx context.Context, q *Qdisc) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("add qdisc", l.ifc.Name, err)
+	}
+
+	return newLinkError("add qdisc", l.ifc.Name, backend.QdiscAdd(ctx, l.ifc.Index, q))
+}
+
+// ReplaceQdisc attaches the qdisc to the link replacing the qdisc of the same parent, or changes
+// the options of the qdisc with the same handle. Qdisc kind can't be changed while keeping the handle
+// and some kinds, i.e. htb, don't support changing their options.
+// It is equivalent of running: tc qdisc replace dev ${link} parent ${parent} handle ${handle} ${kind} ${options}
+func (l *Link) ReplaceQdisc(q *Qdisc) error {
+	return l.ReplaceQdiscContext(context.Background(), q)
+}
+
+// ReplaceQdiscContext is like ReplaceQdisc but it returns without changing the link if the context is done.
+func (l *Link) ReplaceQdiscContext(ctx context.Context, q *Qdisc) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("replace qdisc", l.ifc.Name, err)
+	}
+
+	return newLinkError("replace qdisc", l.ifc.Name, backend.QdiscReplace(ctx, l.ifc.Index, q))
+}
+
+// DelQdisc detaches the qdisc of the given parent and handle from the link. Options of the qdisc are ignored.
+// Deleting the root qdisc restores the link's default qdisc.
+// It is equivalent of running: tc qdisc del dev ${link} parent ${parent} handle ${handle}
+func (l *Link) DelQdisc(q *Qdisc) error {
+	return l.DelQdiscContext(context.Background(), q)
+}
+
+// DelQdiscContext is like DelQdisc but it returns without changing the link if the context is done.
+func (l *Link) DelQdiscContext(ctx context.Context, q *Qdisc) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("del qdisc", l.ifc.Name, err)
+	}
+
+	return newLinkError("del qdisc", l.ifc.Name, backend.QdiscDel(ctx, l.ifc.Index, q))
+}
+
+// Qdiscs returns qdiscs attached to the link including the default ones created by the kernel.
+// Options of the qdisc kinds supported by tenus are decoded.
+// It is equivalent of running: tc qdisc show dev ${link}
+func (l *Link) Qdiscs() ([]*Qdisc, error) {
+	return l.QdiscsContext(context.Background())
+}
+
+// QdiscsContext is like Qdiscs but it gives up waiting for the qdiscs once the context is done.
+func (l *Link) QdiscsContext(ctx context.Context) ([]*Qdisc, error) {
+	qdiscs, err := backend.QdiscList(ctx, l.ifc.Index)
+	if err != nil {
+		return nil, newLinkError("list qdiscs", l.ifc.Name, err)
+	}
+
+	return qdiscs, nil
+}
+
+func (k kernelBackend) QdiscAdd(ctx context.Context, index int, q *Qdisc) error {
+	return qdiscRequest(ctx, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, index, q)
+}
+
+func (k kernelBackend) QdiscReplace(ctx context.Context, index int, q *Qdisc) error {
+	return qdiscRequest(ctx, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE, index, q)
+}
+
+func (k kernelBackend) QdiscDel(ctx context.Context, index int, q *Qdisc) error {
+	return rtnlRequest(ctx, syscall.RTM_DELQDISC, 0, encodeTcmsg(index, q.handle(), q.parent(), 0))
+}
+
+func (k kernelBackend) QdiscList(ctx context.Context, index int) ([]*Qdisc, error) {
+	objs, err := tcDump(ctx, syscall.RTM_GETQDISC, index, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	qdiscs := make([]*Qdisc, 0, len(objs))
+	for _, obj := range objs {
+		q, err := parseQdisc(obj)
+		if err != nil {
+			return nil, err
+		}
+		qdiscs = append(qdiscs, q)
+	}
+
+	return qdiscs, nil
+}
+
+// ingress returns true if the qdisc is attached to the link's ingress
+func (q *Qdisc) ingress() bool {
+	return q.Kind == "ingress" || q.Kind == "clsact"
+}
+
+// handle returns the qdisc's handle. Ingress and clsact qdiscs always have handle ffff:.
+func (q *Qdisc) handle() uint32 {
+	if q.ingress() && q.Handle == 0 {
+		return MakeHandle(0xffff, 0)
+	}
+
+	return q.Handle
+}
+
+// parent returns the qdisc's parent handle
+func (q *Qdisc) parent() uint32 {
+	switch {
+	case q.Parent != 0:
+		return q.Parent
+	case q.ingress():
+		return HandleIngress
+	}
+
+	return HandleRoot
+}
+
+// copyQdisc returns deep copy of the qdisc
+func copyQdisc(q *Qdisc) *Qdisc {
+	c := *q
+	if q.Tbf != nil {
+		tbf := *q.Tbf
+		c.Tbf = &tbf
+	}
+	if q.Htb != nil {
+		htb := *q.Htb
+		c.Htb = &htb
+	}
+	if q.Prio != nil {
+		prio := *q.Prio
+		c.Prio = &prio
+	}
+	if q.FqCodel != nil {
+		fqCodel := *q.FqCodel
+		c.FqCodel = &fqCodel
+	}
+	if q.Netem != nil {
+		netem := *q.Netem
+		c.Netem = &netem
+	}
+	if q.Stats != nil {
+		stats := *q.Stats
+		c.Stats = &stats
+	}
+
+	return &c
+}
+
+// qdiscRequest sends rtnetlink request which creates or changes the qdisc of the link with the given index
+func qdiscRequest(ctx context.Context, flags uint16, index int, q *Qdisc) error {
+	data, err := q.encode(index)
+	if err != nil {
+		return err
+	}
+
+	return rtnlRequest(ctx, syscall.RTM_NEWQDISC, flags, data)
+}
+
+// encode encodes the qdisc into tcmsg header followed by its kind and options
+func (q *Qdisc) encode(index int) ([]byte, error) {
+	if !qdiscKinds[q.Kind] {
+		return nil, fmt.Errorf("Unsupported qdisc kind: %q", q.Kind)
+	}
+
+	data := append(encodeTcmsg(index, q.handle(), q.parent(), 0), encodeRtAttr(tcaKind, encodeString(q.Kind))...)
+
+	var options []byte
+	var err error
+	switch q.Kind {
+	case "tbf":
+		if q.Tbf == nil {
+			return nil, errors.New("tbf qdisc requires Tbf options")
+		}
+		options, err = q.Tbf.encode()
+	case "htb":
+		options = (&HtbOptions{}).encode()
+		if q.Htb != nil {
+			options = q.Htb.encode()
+		}
+	case "prio":
+		options, err = (&PrioOptions{}).encode()
+		if q.Prio != nil {
+			options, err = q.Prio.encode()
+		}
+	case "fq_codel":
+		options = (&FqCodelOptions{}).encode()
+		if q.FqCodel != nil {
+			options = q.FqCodel.encode()
+		}
+	case "netem":
+		options = (&NetemOptions{}).encode()
+		if q.Netem != nil {
+			options = q.Netem.encode()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if options != nil {
+		data = append(data, encodeRtAttr(tcaOptions, options)...)
+	}
+
+	return data, nil
+}
+
+// parseQdisc decodes qdisc dumped by the kernel
+func parseQdisc(obj *tcObject) (*Qdisc, error) {
+	q := &Qdisc{Kind: obj.kind, Handle: obj.handle, Parent: obj.parent}
+
+	var err error
+	switch obj.kind {
+	case "tbf":
+		q.Tbf, err = parseTbfOptions(obj.options)
+	case "htb":
+		q.Htb, err = parseHtbOptions(obj.options)
+	case "prio":
+		q.Prio, err = parsePrioOptions(obj.options)
+	case "fq_codel":
+		q.FqCodel, err = parseFqCodelOptions(obj.options)
+	case "netem":
+		q.Netem, err = parseNetemOptions(obj.options)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse %s qdisc options: %s", obj.kind, err)
+	}
+
+	q.Stats = parseTcStats(obj.stats)
+
+	return q, nil
+}
+
+// parseTcStats decodes tc_stats structure of qdiscs and classes. It returns nil if the stats are missing.
+func parseTcStats(b []byte) *QdiscStats {
+	if len(b) < sizeofTcStats {
+		return nil
+	}
+
+	return &QdiscStats{
+		Bytes:      nativeEndian.Uint64(b[0:8]),
+		Packets:    nativeEndian.Uint32(b[8:12]),
+		Drops:      nativeEndian.Uint32(b[12:16]),
+		Overlimits: nativeEndian.Uint32(b[16:20]),
+		Qlen:       nativeEndian.Uint32(b[28:32]),
+		Backlog:    nativeEndian.Uint32(b[32:36]),
+	}
+}
+
+// encode encodes tbf options. The kernel computes rates itself from rate specs of ethernet link layer.
+func (o *TbfOptions) encode() ([]byte, error) {
+	if o.Rate == 0 || o.Burst == 0 {
+		return nil, errors.New("tbf qdisc requires Rate and Burst")
+	}
+
+	if o.Limit == 0 && o.Latency <= 0 {
+		return nil, errors.New("tbf qdisc requires Limit or Latency")
+	}
+
+	if o.PeakRate != 0 && o.MinBurst == 0 {
+		return nil, errors.New("tbf qdisc requires MinBurst if PeakRate is set")
+	}
+
+	limit := uint64(o.Limit)
+	if limit == 0 {
+		limit = o.Rate*uint64(o.Latency)/uint64(time.Second) + uint64(o.Burst)
+		if o.PeakRate != 0 {
+			if peak := o.PeakRate*uint64(o.Latency)/uint64(time.Second) + uint64(o.MinBurst); peak < limit {
+				limit = peak
+			}
+		}
+		if limit > math.MaxUint32 {
+			limit = math.MaxUint32
+		}
+	}
+
+	qopt := make([]byte, sizeofTbfQopt)
+	copy(qopt[0:], encodeRatespec(o.Rate))
+	nativeEndian.PutUint32(qopt[24:], uint32(limit))
+	nativeEndian.PutUint32(qopt[28:], bytesToTicks(o.Burst, o.Rate))
+
+	attrs := [][]byte{encodeRtAttr(tcaTbfBurst, encodeUint32(o.Burst))}
+	if o.Rate > math.MaxUint32 {
+		attrs = append(attrs, encodeRtAttr(tcaTbfRate64, encodeUint64(o.Rate)))
+	}
+
+	if o.PeakRate != 0 {
+		copy(qopt[12:], encodeRatespec(o.PeakRate))
+		nativeEndian.PutUint32(qopt[32:], bytesToTicks(o.MinBurst, o.PeakRate))
+		attrs = append(attrs, encodeRtAttr(tcaTbfPburst, encodeUint32(o.MinBurst)))
+		if o.PeakRate > math.MaxUint32 {
+			attrs = append(attrs, encodeRtAttr(tcaTbfPrate64, encodeUint64(o.PeakRate)))
+		}
+	}
+
+	return append(encodeRtAttr(tcaTbfParms, qopt), concat(attrs)...), nil
+}
+
+// parseTbfOptions decodes tbf options dumped by the kernel.
+// The kernel stores bursts in psched ticks, so high rates don't round trip them exactly.
+func parseTbfOptions(b []byte) (*TbfOptions, error) {
+	attrs, err := tcAttrs(b)
+	if err != nil {
+		return nil, err
+	}
+
+	qopt := attrs[tcaTbfParms]
+	if len(qopt) < sizeofTbfQopt {
+		return nil, errors.New("missing tbf parameters")
+	}
+
+	o := &TbfOptions{
+		Rate:     uint64(nativeEndian.Uint32(qopt[8:12])),
+		PeakRate: uint64(nativeEndian.Uint32(qopt[20:24])),
+		Limit:    nativeEndian.Uint32(qopt[24:28]),
+	}
+
+	if rate64 := attrs[tcaTbfRate64]; len(rate64) == 8 {
+		o.Rate = nativeEndian.Uint64(rate64)
+	}
+
+	if prate64 := attrs[tcaTbfPrate64]; len(prate64) == 8 {
+		o.PeakRate = nativeEndian.Uint64(prate64)
+	}
+
+	o.Burst = ticksToBytes(nativeEndian.Uint32(qopt[28:32]), o.Rate)
+	if o.PeakRate != 0 {
+		o.MinBurst = ticksToBytes(nativeEndian.Uint32(qopt[32:36]), o.PeakRate)
+	}
+
+	return o, nil
+}
+
+// encode encodes htb qdisc options
+func (o *HtbOptions) encode() []byte {
+	r2q := o.Rate2Quantum
+	if r2q == 0 {
+		r2q = htbRate2Quantum
+	}
+
+	attrs := encodeRtAttr(tcaHtbInit, encodeUint32s(htbVersion, r2q, uint32(o.Default), 0, 0))
+	if o.DirectQlen != 0 {
+		attrs = append(attrs, encodeRtAttr(tcaHtbDirectQlen, encodeUint32(o.DirectQlen))...)
+	}
+
+	return attrs
+}
+
+// parseHtbOptions decodes htb qdisc options dumped by the kernel
+func parseHtbOptions(b []byte) (*HtbOptions, error) {
+	attrs, err := tcAttrs(b)
+	if err != nil {
+		return nil, err
+	}
+
+	glob := attrs[tcaHtbInit]
+	if len(glob) < sizeofHtbGlob {
+		return nil, errors.New("missing htb parameters")
+	}
+
+	o := &HtbOptions{
+		Rate2Quantum: nativeEndian.Uint32(glob[4:8]),
+		Default:      uint16(nativeEndian.Uint32(glob[8:12])),
+	}
+
+	if qlen := attrs[tcaHtbDirectQlen]; len(qlen) == 4 {
+		o.DirectQlen = nativeEndian.Uint32(qlen)
+	}
+
+	return o, nil
+}
+
+// encode encodes prio qdisc options. Unlike other qdiscs, the options are a plain structure.
+func (o *PrioOptions) encode() ([]byte, error) {
+	bands, priomap := o.Bands, o.Priomap
+	if bands == 0 {
+		bands = 3
+	}
+
+	if bands < 2 || bands > 16 {
+		return nil, fmt.Errorf("prio qdisc supports 2 to 16 bands, not %d", bands)
+	}
+
+	if bands == 3 && priomap == [16]uint8{} {
+		priomap = defaultPriomap
+	}
+
+	for _, band := range priomap {
+		if uint32(band) >= bands {
+			return nil, fmt.Errorf("prio qdisc priomap refers to band %d of %d bands", band, bands)
+		}
+	}
+
+	qopt := make([]byte, sizeofPrioQopt)
+	nativeEndian.PutUint32(qopt[0:4], bands)
+	copy(qopt[4:], priomap[:])
+
+	return qopt, nil
+}
+
+// parsePrioOptions decodes prio qdisc options dumped by the kernel
+func parsePrioOptions(b []byte) (*PrioOptions, error) {
+	if len(b) < sizeofPrioQopt {
+		return nil, errors.New("missing prio parameters")
+	}
+
+	o := &PrioOptions{Bands: nativeEndian.Uint32(b[0:4])}
+	copy(o.Priomap[:], b[4:sizeofPrioQopt])
+
+	return o, nil
+}
+
+// encode encodes fq_codel options. Options with zero values are not sent, so the kernel keeps their defaults.
+func (o *FqCodelOptions) encode() []byte {
+	var attrs [][]byte
+	if o.Target > 0 {
+		attrs = append(attrs, encodeRtAttr(tcaFqCodelTarget, encodeUint32(uint32(o.Target/time.Microsecond))))
+	}
+	if o.Limit > 0 {
+		attrs = append(attrs, encodeRtAttr(tcaFqCodelLimit, encodeUint32(o.Limit)))
+	}
+	if o.Interval > 0 {
+		attrs = append(attrs, encodeRtAttr(tcaFqCodelInterval, encodeUint32(uint32(o.Interval/time.Microsecond))))
+	}
+	if o.Flows > 0 {
+		attrs = append(attrs, encodeRtAttr(tcaFqCodelFlows, encodeUint32(o.Flows)))
+	}
+	if o.Quantum > 0 {
+		attrs = append(attrs, encodeRtAttr(tcaFqCodelQuantum, encodeUint32(o.Quantum)))
+	}
+
+	ecn := uint32(1)
+	if o.DisableECN {
+		ecn = 0
+	}
+
+	return append(concat(attrs), encodeRtAttr(tcaFqCodelEcn, encodeUint32(ecn))...)
+}
+
+// parseFqCodelOptions decodes fq_codel options dumped by the kernel
+func parseFqCodelOptions(b []byte) (*FqCodelOptions, error) {
+	attrs, err := tcAttrs(b)
+	if err != nil {
+		return nil, err
+	}
+
+	value := func(attrType uint16) uint32 {
+		if v := attrs[attrType]; len(v) == 4 {
+			return nativeEndian.Uint32(v)
+		}
+		return 0
+	}
+
+	return &FqCodelOptions{
+		Limit:      value(tcaFqCodelLimit),
+		Flows:      value(tcaFqCodelFlows),
+		Target:     time.Duration(value(tcaFqCodelTarget)) * time.Microsecond,
+		Interval:   time.Duration(value(tcaFqCodelInterval)) * time.Microsecond,
+		Quantum:    value(tcaFqCodelQuantum),
+		DisableECN: value(tcaFqCodelEcn) == 0,
+	}, nil
+}
+
+// encode encodes netem options. The options are a plain structure followed by attributes.
+func (o *NetemOptions) encode() []byte {
+	limit := o.Limit
+	if limit == 0 {
+		limit = netemDefaultLimit
+	}
+
+	gap := o.Gap
+	if o.Reorder > 0 && gap == 0 {
+		gap = 1
+	}
+
+	qopt := encodeUint32s(durationToTicks(o.Delay), limit, probability(o.Loss), gap,
+		probability(o.Duplicate), durationToTicks(o.Jitter))
+
+	attrs := [][]byte{
+		encodeRtAttr(tcaNetemLatency64, encodeUint64(uint64(o.Delay))),
+		encodeRtAttr(tcaNetemJitter64, encodeUint64(uint64(o.Jitter))),
+		encodeRtAttr(tcaNetemCorr, encodeUint32s(probability(o.DelayCorrelation),
+			probability(o.LossCorrelation), probability(o.DuplicateCorrelation))),
+		encodeRtAttr(tcaNetemReorder, encodeUint32s(probability(o.Reorder), probability(o.ReorderCorrelation))),
+	}
+
+	rate := o.Rate
+	if rate > math.MaxUint32 {
+		attrs = append(attrs, encodeRtAttr(tcaNetemRate64, encodeUint64(rate)))
+		rate = math.MaxUint32
+	}
+	attrs = append(attrs, encodeRtAttr(tcaNetemRate, encodeUint32s(uint32(rate), 0, 0, 0)))
+
+	return append(qopt, concat(attrs)...)
+}
+
+// parseNetemOptions decodes netem options dumped by the kernel
+func parseNetemOptions(b []byte) (*NetemOptions, error) {
+	if len(b) < sizeofNetemQopt {
+		return nil, errors.New("missing netem parameters")
+	}
+
+	o := &NetemOptions{
+		Delay:     ticksToDuration(nativeEndian.Uint32(b[0:4])),
+		Limit:     nativeEndian.Uint32(b[4:8]),
+		Loss:      percentage(nativeEndian.Uint32(b[8:12])),
+		Gap:       nativeEndian.Uint32(b[12:16]),
+		Duplicate: percentage(nativeEndian.Uint32(b[16:20])),
+		Jitter:    ticksToDuration(nativeEndian.Uint32(b[20:24])),
+	}
+
+	attrs, err := tcAttrs(b[sizeofNetemQopt:])
+	if err != nil {
+		return nil, err
+	}
+
+	if v := attrs[tcaNetemLatency64]; len(v) == 8 {
+		o.Delay = time.Duration(nativeEndian.Uint64(v))
+	}
+	if v := attrs[tcaNetemJitter64]; len(v) == 8 {
+		o.Jitter = time.Duration(nativeEndian.Uint64(v))
+	}
+	if v := attrs[tcaNetemCorr]; len(v) == 12 {
+		o.DelayCorrelation = percentage(nativeEndian.Uint32(v[0:4]))
+		o.LossCorrelation = percentage(nativeEndian.Uint32(v[4:8]))
+		o.DuplicateCorrelation = percentage(nativeEndian.Uint32(v[8:12]))
+	}
+	if v := attrs[tcaNetemReorder]; len(v) == 8 {
+		o.Reorder = percentage(nativeEndian.Uint32(v[0:4]))
+		o.ReorderCorrelation = percentage(nativeEndian.Uint32(v[4:8]))
+	}
+	if v := attrs[tcaNetemRate]; len(v) >= 4 {
+		o.Rate = uint64(nativeEndian.Uint32(v[0:4]))
+	}
+	if v := attrs[tcaNetemRate64]; len(v) == 8 {
+		o.Rate = nativeEndian.Uint64(v)
+	}
+
+	return o, nil
+}
+
+// encodeRatespec encodes tc_ratespec of ethernet link layer with the given rate in bytes per second.
+// Rates which don't fit into 32 bits are sent in separate 64-bit attributes.
+func encodeRatespec(rate uint64) []byte {
+	b := make([]byte, sizeofTcRatespec)
+	b[1] = tcLinklayerEther
+	if rate > math.MaxUint32 {
+		rate = math.MaxUint32
+	}
+	nativeEndian.PutUint32(b[8:12], uint32(rate))
+
+	return b
+}
+
+// bytesToTicks returns the time it takes to send size bytes at the given rate in psched ticks
+func bytesToTicks(size uint32, rate uint64) uint32 {
+	return durationToTicks(time.Duration(uint64(size) * uint64(time.Second) / rate))
+}
+
+// ticksToBytes returns the number of bytes sent at the given rate in the time expressed in psched ticks
+func ticksToBytes(ticks uint32, rate uint64) uint32 {
+	return uint32(math.Round(float64(ticks) * nsPerTick * float64(rate) / float64(time.Second)))
+}
+
+// durationToTicks converts the duration to psched ticks
+func durationToTicks(d time.Duration) uint32 {
+	ticks := uint64(d) / nsPerTick
+	if ticks > math.MaxUint32 {
+		return math.MaxUint32
+	}
+
+	return uint32(ticks)
+}
+
+// ticksToDuration converts psched ticks to duration
+func ticksToDuration(ticks uint32) time.Duration {
+	return time.Duration(ticks) * nsPerTick
+}
+
+// probability converts percentage to the kernel's probability which scales 100% to the maximum uint32 value
+func probability(percent float64) uint32 {
+	switch {
+	case percent <= 0:
+		return 0
+	case percent >= 100:
+		return math.MaxUint32
+	}
+
+	return uint32(math.Round(percent / 100 * math.MaxUint32))
+}
+
+// percentage converts the kernel's probability to percentage
+func percentage(p uint32) float64 {
+	return float64(p) * 100 / math.MaxUint32
+}
+
+// concat concatenates encoded attributes
+func concat(attrs [][]byte) []byte {
+	var b []byte
+	for _, attr := range attrs {
+		b = append(b, attr...)
+	}
+
+	return b
+}
@@ -0,0 +1,285 @@
+package tenus
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/milosgajdos/tenus/tenustest"
+)
+
+func Test_Handle(t *testing.T) {
+	for _, tt := range []struct {
+		handle uint32
+		s      string
+	}{
+		{MakeHandle(1, 0), "1:"},
+		{MakeHandle(1, 0x10), "1:10"},
+		{MakeHandle(0xffff, 0), "ffff:"},
+		{HandleRoot, "root"},
+		{HandleIngress, "ingress"},
+		{0, "none"},
+	} {
+		if s := HandleString(tt.handle); s != tt.s {
+			t.Errorf("HandleString(%x) failed: expected %s, returned %s", tt.handle, tt.s, s)
+		}
+
+		if handle, err := ParseHandle(tt.s); err != nil || handle != tt.handle {
+			t.Errorf("ParseHandle(%s) failed: expected %x, returned %x, %v", tt.s, tt.handle, handle, err)
+		}
+	}
+
+	for _, s := range []string{"1", "x:1", "1:10000", ""} {
+		if _, err := ParseHandle(s); err == nil {
+			t.Errorf("ParseHandle(%s) expected to fail", s)
+		}
+	}
+}
+
+type qdiscTest struct {
+	qdisc    *Qdisc
+	expected *Qdisc
+}
+
+var qdiscTests = []qdiscTest{
+	{
+		qdisc: &Qdisc{Kind: "pfifo_fast"},
+	},
+	{
+		qdisc:    &Qdisc{Kind: "tbf", Handle: MakeHandle(1, 0), Tbf: &TbfOptions{Rate: 125000, Burst: 5000, Latency: 50 * time.Millisecond}},
+		expected: &Qdisc{Kind: "tbf", Handle: MakeHandle(1, 0), Parent: HandleRoot, Tbf: &TbfOptions{Rate: 125000, Burst: 5000, Limit: 11250}},
+	},
+	{
+		qdisc:    &Qdisc{Kind: "tbf", Handle: MakeHandle(1, 0), Tbf: &TbfOptions{Rate: 16e9, Burst: 1 << 20, Limit: 1 << 22}},
+		expected: &Qdisc{Kind: "tbf", Handle: MakeHandle(1, 0), Parent: HandleRoot, Tbf: &TbfOptions{Rate: 16e9, Burst: 1 << 20, Limit: 1 << 22}},
+	},
+	{
+		qdisc:    &Qdisc{Kind: "htb", Handle: MakeHandle(1, 0), Htb: &HtbOptions{Default: 0x10, DirectQlen: 100}},
+		expected: &Qdisc{Kind: "htb", Handle: MakeHandle(1, 0), Parent: HandleRoot, Htb: &HtbOptions{Default: 0x10, Rate2Quantum: 10, DirectQlen: 100}},
+	},
+	{
+		qdisc:    &Qdisc{Kind: "prio", Handle: MakeHandle(2, 0)},
+		expected: &Qdisc{Kind: "prio", Handle: MakeHandle(2, 0), Parent: HandleRoot, Prio: &PrioOptions{Bands: 3, Priomap: defaultPriomap}},
+	},
+	{
+		qdisc: &Qdisc{Kind: "fq_codel", Handle: MakeHandle(3, 0), FqCodel: &FqCodelOptions{Limit: 2000, Target: 10 * time.Millisecond, DisableECN: true}},
+		expected: &Qdisc{Kind: "fq_codel", Handle: MakeHandle(3, 0), Parent: HandleRoot, FqCodel: &FqCodelOptions{Limit: 2000, Flows: 1024,
+			Target: 10 * time.Millisecond, Interval: 100 * time.Millisecond, Quantum: 1514, DisableECN: true}},
+	},
+	{
+		qdisc: &Qdisc{Kind: "netem", Handle: MakeHandle(4, 0), Netem: &NetemOptions{Delay: 100 * time.Millisecond, Jitter: 10 * time.Millisecond,
+			Loss: 1, Duplicate: 2, Reorder: 25, ReorderCorrelation: 50, Rate: 125000}},
+		expected: &Qdisc{Kind: "netem", Handle: MakeHandle(4, 0), Parent: HandleRoot, Netem: &NetemOptions{Delay: 100 * time.Millisecond,
+			Jitter: 10 * time.Millisecond, Loss: 1, Duplicate: 2, Reorder: 25, ReorderCorrelation: 50, Gap: 1, Rate: 125000, Limit: 1000}},
+	},
+	{
+		qdisc:    &Qdisc{Kind: "ingress"},
+		expected: &Qdisc{Kind: "ingress", Handle: MakeHandle(0xffff, 0), Parent: HandleIngress},
+	},
+	{
+		qdisc:    &Qdisc{Kind: "clsact"},
+		expected: &Qdisc{Kind: "clsact", Handle: MakeHandle(0xffff, 0), Parent: HandleIngress},
+	},
+}
+
+// findQdisc returns the link's qdisc of the given kind
+func findQdisc(t *testing.T, link Linker, kind string) *Qdisc {
+	qdiscs, err := link.Qdiscs()
+	if err != nil {
+		t.Fatalf("Qdiscs() failed: %s", err)
+	}
+
+	for _, q := range qdiscs {
+		if q.Kind == kind {
+			return q
+		}
+	}
+
+	return nil
+}
+
+// roundQdisc rounds the values of qdisc options which are converted by the kernel
+func roundQdisc(q *Qdisc) {
+	q.Stats = nil
+	if q.Netem != nil {
+		for _, p := range []*float64{&q.Netem.Loss, &q.Netem.Duplicate, &q.Netem.Reorder, &q.Netem.ReorderCorrelation} {
+			*p = math.Round(*p*1000) / 1000
+		}
+	}
+}
+
+func Test_Qdiscs(t *testing.T) {
+	tenustest.New(t)
+
+	veth, err := NewVethPairWithOptions("tcqdisc01", VethOptions{PeerName: "tcqdisc02"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	for _, tt := range qdiscTests {
+		if err := veth.AddQdisc(tt.qdisc); err != nil {
+			if errors.Is(err, syscall.ENOENT) {
+				t.Logf("Skipping %s qdisc not supported by the kernel", tt.qdisc.Kind)
+				continue
+			}
+			t.Fatalf("AddQdisc(%+v) failed: %s", tt.qdisc, err)
+		}
+
+		q := findQdisc(t, veth, tt.qdisc.Kind)
+		if q == nil || q.Stats == nil {
+			t.Fatalf("Qdiscs() failed to return %s qdisc with statistics: %+v", tt.qdisc.Kind, q)
+		}
+
+		if tt.expected != nil {
+			roundQdisc(q)
+			if !reflect.DeepEqual(q, tt.expected) {
+				t.Errorf("Qdiscs() failed: expected %+v, returned %+v", tt.expected, q)
+			}
+		}
+
+		if err := veth.AddQdisc(tt.qdisc); !errors.Is(err, syscall.EEXIST) {
+			t.Errorf("AddQdisc(%+v) failed: expected %v, returned %v", tt.qdisc, syscall.EEXIST, err)
+		}
+
+		if err := veth.DelQdisc(tt.qdisc); err != nil {
+			t.Fatalf("DelQdisc(%+v) failed: %s", tt.qdisc, err)
+		}
+
+		if q := findQdisc(t, veth, tt.qdisc.Kind); q != nil && tt.qdisc.Kind != "pfifo_fast" {
+			t.Fatalf("DelQdisc(%+v) failed to delete the qdisc", tt.qdisc)
+		}
+	}
+
+	// replace changes options of the qdisc of the same kind
+	tbf := &Qdisc{Kind: "tbf", Handle: MakeHandle(1, 0), Tbf: &TbfOptions{Rate: 125000, Burst: 5000, Limit: 10000}}
+	if err := veth.ReplaceQdisc(tbf); err != nil {
+		t.Fatalf("ReplaceQdisc() failed: %s", err)
+	}
+
+	tbf.Tbf.Rate = 250000
+	if err := veth.ReplaceQdisc(tbf); err != nil {
+		t.Fatalf("ReplaceQdisc() failed: %s", err)
+	}
+
+	if q := findQdisc(t, veth, "tbf"); q == nil || q.Tbf.Rate != 250000 {
+		t.Fatalf("ReplaceQdisc() failed to change tbf rate: %+v", q)
+	}
+
+	// replace with a different kind requires a new handle
+	htb := &Qdisc{Kind: "htb", Handle: MakeHandle(1, 0)}
+	if err := veth.ReplaceQdisc(htb); !errors.Is(err, syscall.EINVAL) {
+		t.Fatalf("ReplaceQdisc(%+v) failed: expected %v, returned %v", htb, syscall.EINVAL, err)
+	}
+
+	htb.Handle = MakeHandle(2, 0)
+	if err := veth.ReplaceQdisc(htb); err != nil {
+		t.Fatalf("ReplaceQdisc(%+v) failed: %s", htb, err)
+	}
+
+	if q := findQdisc(t, veth, "htb"); q == nil || q.Handle != htb.Handle || findQdisc(t, veth, "tbf") != nil {
+		t.Fatalf("ReplaceQdisc(%+v) failed to replace tbf qdisc: %+v", htb, q)
+	}
+
+	for _, q := range []*Qdisc{
+		{Kind: "sfq"},
+		{Kind: "tbf"},
+		{Kind: "tbf", Tbf: &TbfOptions{Rate: 125000, Burst: 5000}},
+		{Kind: "prio", Prio: &PrioOptions{Bands: 1}},
+		{Kind: "prio", Prio: &PrioOptions{Bands: 2, Priomap: [16]uint8{2}}},
+	} {
+		if err := veth.AddQdisc(q); err == nil || errors.Is(err, syscall.ENOENT) {
+			t.Errorf("AddQdisc(%+v) expected to fail validation, returned %v", q, err)
+		}
+	}
+}
+
+func Test_QdiscsFakeBackend(t *testing.T) {
+	defer SetBackend(SetBackend(NewFakeBackend()))
+
+	link, err := NewLinkWithOptions("tcqdisc03", LinkOptions{})
+	if err != nil {
+		t.Fatalf("NewLinkWithOptions() failed: %s", err)
+	}
+
+	htb := &Qdisc{Kind: "htb", Handle: MakeHandle(1, 0), Htb: &HtbOptions{Default: 0x10}}
+	tbf := &Qdisc{Kind: "tbf", Handle: MakeHandle(0x10, 0), Parent: MakeHandle(1, 0x10),
+		Tbf: &TbfOptions{Rate: 125000, Burst: 5000, Limit: 10000}}
+	if err := link.AddQdisc(htb); err != nil {
+		t.Fatalf("AddQdisc(%+v) failed: %s", htb, err)
+	}
+
+	if err := link.AddQdisc(tbf); !errors.Is(err, syscall.ENOENT) {
+		t.Fatalf("AddQdisc() failed: expected %v, returned %v", syscall.ENOENT, err)
+	}
+
+	class := &Class{Kind: "htb", Handle: MakeHandle(1, 0x10), Parent: MakeHandle(1, 0), Htb: &HtbClassOptions{Rate: 125000}}
+	if err := link.AddClass(class); err != nil {
+		t.Fatalf("AddClass() failed: %s", err)
+	}
+
+	for _, q := range []*Qdisc{tbf, {Kind: "ingress"}} {
+		if err := link.AddQdisc(q); err != nil {
+			t.Fatalf("AddQdisc(%+v) failed: %s", q, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		qdisc *Qdisc
+		err   error
+	}{
+		{&Qdisc{Kind: "htb", Handle: MakeHandle(2, 0)}, syscall.EEXIST},
+		{&Qdisc{Kind: "htb", Handle: MakeHandle(0x10, 0), Parent: MakeHandle(1, 0x10)}, syscall.EEXIST},
+		{&Qdisc{Kind: "clsact"}, syscall.EEXIST},
+		{&Qdisc{Kind: "htb", Parent: MakeHandle(3, 1)}, syscall.ENOENT},
+	} {
+		if err := link.AddQdisc(tt.qdisc); !errors.Is(err, tt.err) {
+			t.Errorf("AddQdisc(%+v) failed: expected %v, returned %v", tt.qdisc, tt.err, err)
+		}
+	}
+
+	if err := link.AddQdisc(&Qdisc{Kind: "sfq"}); err == nil {
+		t.Errorf("AddQdisc() expected to fail validation")
+	}
+
+	tbf.Tbf.Rate = 250000
+	if err := link.ReplaceQdisc(tbf); err != nil {
+		t.Fatalf("ReplaceQdisc() failed: %s", err)
+	}
+
+	if q := findQdisc(t, link, "tbf"); q == nil || q.Tbf.Rate != 250000 || q.Stats == nil {
+		t.Fatalf("ReplaceQdisc() failed to change tbf rate: %+v", q)
+	}
+
+	if err := link.ReplaceQdisc(&Qdisc{Kind: "htb", Handle: tbf.Handle, Parent: tbf.Parent}); !errors.Is(err, syscall.EINVAL) {
+		t.Fatalf("ReplaceQdisc() failed: expected %v, returned %v", syscall.EINVAL, err)
+	}
+
+	// deleting htb qdisc deletes tbf qdisc attached to its class
+	if err := link.DelQdisc(&Qdisc{}); err != nil {
+		t.Fatalf("DelQdisc() failed: %s", err)
+	}
+
+	if err := link.DelQdisc(&Qdisc{}); !errors.Is(err, syscall.ENOENT) {
+		t.Fatalf("DelQdisc() failed: expected %v, returned %v", syscall.ENOENT, err)
+	}
+
+	if err := link.AddQdisc(&Qdisc{Kind: "pfifo_fast"}); err != nil {
+		t.Fatalf("AddQdisc() failed: %s", err)
+	}
+
+	qdiscs, err := link.Qdiscs()
+	if err != nil {
+		t.Fatalf("Qdiscs() failed: %s", err)
+	}
+
+	if len(qdiscs) != 2 {
+		t.Fatalf("Qdiscs() failed: expected 2 qdiscs, returned %d", len(qdiscs))
+	}
+
+	if qdiscs[0].Kind != "ingress" || qdiscs[1].Handle != MakeHandle(0x8001, 0) {
+		t.Fatalf("Qdiscs() failed: returned %+v and %+v", qdiscs[0], qdiscs[1])
+	}
+}
@@ -0,0 +1,624 @@
+package tenus
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// OpType is the kind of network configuration change recorded by Recorder.
+type OpType string
+
+// Network configuration changes recorded by Recorder
+const (
+	OpLinkAdd             OpType = "link add"
+	OpLinkDel             OpType = "link del"
+	OpLinkSetName         OpType = "link set name"
+	OpLinkSetMTU          OpType = "link set mtu"
+	OpLinkSetHardwareAddr OpType = "link set address"
+	OpLinkSetUp           OpType = "link set up"
+	OpLinkSetDown         OpType = "link set down"
+	OpLinkSetMaster       OpType = "link set master"
+	OpLinkSetNs           OpType = "link set netns"
+	OpAddrAdd             OpType = "address add"
+	OpAddrDel             OpType = "address del"
+	OpRouteAdd            OpType = "route add"
+	OpRouteDel            OpType = "route del"
+	OpQdiscAdd            OpType = "qdisc add"
+	OpQdiscReplace        OpType = "qdisc replace"
+	OpQdiscDel            OpType = "qdisc del"
+	OpClassAdd            OpType = "class add"
+	OpClassReplace        OpType = "class replace"
+	OpClassDel            OpType = "class del"
+	OpFilterAdd           OpType = "filter add"
+	OpFilterReplace       OpType = "filter replace"
+	OpFilterDel           OpType = "filter del"
+	OpNsCreate            OpType = "netns add"
+	OpNsDelete            OpType = "netns delete"
+)
+
+// Op is a single network configuration change recorded by Recorder.
+// Links are referred to by their names at the time the change is made.
+type Op struct {
+	// Kind of the change
+	Type OpType
+	// Network namespace path the change is made in. Empty for the network namespace the recording started in.
+	Ns string
+	// Link name. Empty for network namespace changes.
+	Link string
+	// Link created by OpLinkAdd. ParentIndex refers to the recorded state; use Parent instead.
+	Spec LinkSpec
+	// Name of the parent device of vlan, macvlan and macvtap links created by OpLinkAdd
+	Parent string
+	// New link name set by OpLinkSetName
+	Name string
+	// MTU set by OpLinkSetMTU
+	MTU int
+	// MAC address set by OpLinkSetHardwareAddr
+	HardwareAddr net.HardwareAddr
+	// Master link name set by OpLinkSetMaster. Empty if the link is released from its master.
+	Master string
+	// Network namespace path of OpLinkSetNs, OpNsCreate and OpNsDelete
+	NsPath string
+	// IP address of OpAddrAdd and OpAddrDel
+	Addr *net.IPNet
+	// Route destination of OpRouteAdd and OpRouteDel. nil for the default route.
+	Dst *net.IPNet
+	// Route gateway of OpRouteAdd and OpRouteDel. nil for directly connected routes.
+	Gw net.IP
+	// Qdisc of OpQdiscAdd, OpQdiscReplace and OpQdiscDel
+	Qdisc *Qdisc
+	// Class of OpClassAdd, OpClassReplace and OpClassDel
+	Class *Class
+	// Filter of OpFilterAdd, OpFilterReplace and OpFilterDel
+	Filter *Filter
+}
+
+// String returns the iproute2 command equivalent to the change
+func (op Op) String() string {
+	if op.tc() {
+		cmd, err := tcCommand(op)
+		if err != nil {
+			return fmt.Sprintf("# %s", err)
+		}
+
+		return cmd
+	}
+
+	cmds, err := ipCommands(op)
+	if err != nil {
+		return fmt.Sprintf("# %s", err)
+	}
+
+	return "ip " + strings.Join(cmds, " && ip ")
+}
+
+// tc returns true if the change is made by tc rather than ip command
+func (op Op) tc() bool {
+	switch op.Type {
+	case OpQdiscAdd, OpQdiscReplace, OpQdiscDel, OpClassAdd, OpClassReplace, OpClassDel,
+		OpFilterAdd, OpFilterReplace, OpFilterDel:
+		return true
+	}
+
+	return false
+}
+
+// Recorder is Backend which records network configuration changes instead of applying them.
+//
+// Recorder keeps in-memory model of the intended state, so links created during the recording can be
+// looked up, configured and validated as if they existed. The model starts from the state of the base
+// backend: its links, addresses, traffic control and routes are read when the Recorder is created and network namespaces
+// are read when they're entered for the first time. The base backend is never changed.
+//
+// Install Recorder with SetBackend or use DryRun. Event subscriptions are not recorded, so functions
+// waiting for link events, like WaitForLinkState, must not be used while recording.
+type Recorder struct {
+	mu sync.Mutex
+	// backend the initial state is read from. nil if the recording starts from an empty host.
+	base Backend
+	// intended state of the host
+	model *FakeBackend
+	// recorded changes
+	ops []Op
+	// network namespace path the recorder is currently switched to
+	ns string
+	// network namespaces of the base backend which have already been read into the model
+	seen map[string]bool
+}
+
+// NewRecorder returns Recorder whose initial state is read from the base backend.
+// If base is nil, the recording starts from a host with only loopback link.
+func NewRecorder(base Backend) (*Recorder, error) {
+	r := &Recorder{
+		base:  base,
+		model: NewFakeBackend(),
+		seen:  make(map[string]bool),
+	}
+
+	if base == nil {
+		return r, nil
+	}
+
+	state, err := readNsState(base)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read network state: %w", err)
+	}
+	r.model.seed(r.model.root, state)
+
+	return r, nil
+}
+
+// DryRun runs fn with all network configuration changes recorded instead of applied and returns them.
+// The recording starts from the state of the current backend, which is restored once fn returns.
+// DryRun is not safe to call concurrently with other tenus functions.
+func DryRun(fn func() error) ([]Op, error) {
+	r, err := NewRecorder(backend)
+	if err != nil {
+		return nil, err
+	}
+
+	prev := SetBackend(r)
+	defer SetBackend(prev)
+
+	err = fn()
+
+	return r.Ops(), err
+}
+
+// Ops returns changes recorded so far in the order they were made.
+func (r *Recorder) Ops() []Op {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Op(nil), r.ops...)
+}
+
+// record applies the change to the model and records it if it succeeds
+func (r *Recorder) record(op Op, apply func() error) error {
+	if err := apply(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	op.Ns = r.ns
+	r.ops = append(r.ops, op)
+
+	return nil
+}
+
+// linkName returns name of the link in the model's current network namespace. Missing links have empty name.
+func (r *Recorder) linkName(index int) string {
+	attrs, err := r.model.LinkByIndex(context.Background(), index)
+	if err != nil {
+		return ""
+	}
+
+	return attrs.Name
+}
+
+// ensureNs adds network namespace which exists in the base backend to the model
+func (r *Recorder) ensureNs(nspath string) error {
+	r.mu.Lock()
+	seen := r.seen[nspath]
+	r.seen[nspath] = true
+	r.mu.Unlock()
+
+	// network namespaces deleted during the recording must not be read again
+	if r.base == nil || seen || r.model.NsExists(nspath) || !r.base.NsExists(nspath) {
+		return nil
+	}
+
+	var state *nsState
+	err := r.base.ExecInNs(nspath, func() error {
+		var err error
+		state, err = readNsState(r.base)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Could not read state of network namespace %s: %w", nspath, err)
+	}
+
+	r.model.mu.Lock()
+	defer r.model.mu.Unlock()
+
+	ns := r.model.newNs()
+	r.model.seed(ns, state)
+	r.model.namespaces[nspath] = ns
+
+	return nil
+}
+
+// nsState is the state of network namespace read from a backend
+type nsState struct {
+	links []*LinkAttrs
+	// addresses, qdiscs, classes and filters of the links keyed by link index
+	addrs   map[int][]*net.IPNet
+	qdiscs  map[int][]*Qdisc
+	classes map[int][]*Class
+	filters map[int][]*Filter
+	routes  []*Route
+}
+
+// readNsState reads links, their addresses and traffic control and routes of the backend's current network namespace
+func readNsState(b Backend) (*nsState, error) {
+	links, err := b.LinkList(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	state := &nsState{
+		links:   links,
+		addrs:   make(map[int][]*net.IPNet),
+		qdiscs:  make(map[int][]*Qdisc),
+		classes: make(map[int][]*Class),
+		filters: make(map[int][]*Filter),
+	}
+
+	for _, attrs := range links {
+		if state.addrs[attrs.Index], err = b.AddrList(context.Background(), attrs.Index); err != nil {
+			return nil, err
+		}
+
+		if err := readTcState(b, attrs.Index, state); err != nil {
+			return nil, err
+		}
+	}
+
+	if state.routes, err = b.RouteList(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// readTcState reads qdiscs, classes and filters of the link with the given index.
+// Only filters of the qdisc kinds supported by tenus are read.
+func readTcState(b Backend, index int, state *nsState) error {
+	qdiscs, err := b.QdiscList(context.Background(), index)
+	if err != nil {
+		return err
+	}
+
+	classes, err := b.ClassList(context.Background(), index)
+	if err != nil {
+		return err
+	}
+
+	var parents []uint32
+	for _, q := range qdiscs {
+		switch {
+		case q.Handle == 0:
+		case q.Kind == "clsact":
+			parents = append(parents, HandleMinIngress, HandleMinEgress)
+		case q.Kind == "htb" || q.Kind == "prio" || q.Kind == "ingress":
+			parents = append(parents, q.Handle)
+			for _, c := range classes {
+				if c.Handle&0xffff0000 == q.Handle {
+					parents = append(parents, c.Handle)
+				}
+			}
+		}
+	}
+
+	for _, parent := range parents {
+		filters, err := b.FilterList(context.Background(), index, parent)
+		if err != nil {
+			return err
+		}
+		state.filters[index] = append(state.filters[index], filters...)
+	}
+
+	state.qdiscs[index], state.classes[index] = qdiscs, classes
+
+	return nil
+}
+
+// LinkAdd records creation of the network link described by spec.
+func (r *Recorder) LinkAdd(ctx context.Context, spec LinkSpec) error {
+	op := Op{Type: OpLinkAdd, Link: spec.Name, Spec: spec}
+	if spec.ParentIndex != 0 {
+		op.Parent = r.linkName(spec.ParentIndex)
+	}
+
+	return r.record(op, func() error {
+		return r.model.LinkAdd(ctx, spec)
+	})
+}
+
+// LinkDel records deletion of the network link.
+func (r *Recorder) LinkDel(ctx context.Context, index int) error {
+	return r.record(Op{Type: OpLinkDel, Link: r.linkName(index)}, func() error {
+		return r.model.LinkDel(ctx, index)
+	})
+}
+
+// LinkByIndex returns attributes of the link with the given index in the intended state.
+func (r *Recorder) LinkByIndex(ctx context.Context, index int) (*LinkAttrs, error) {
+	return r.model.LinkByIndex(ctx, index)
+}
+
+// LinkByName returns attributes of the link with the given name in the intended state.
+func (r *Recorder) LinkByName(ctx context.Context, name string) (*LinkAttrs, error) {
+	return r.model.LinkByName(ctx, name)
+}
+
+// LinkList returns attributes of all network links in the intended state.
+func (r *Recorder) LinkList(ctx context.Context) ([]*LinkAttrs, error) {
+	return r.model.LinkList(ctx)
+}
+
+// LinkSetName records renaming of the link.
+func (r *Recorder) LinkSetName(ctx context.Context, index int, name string) error {
+	return r.record(Op{Type: OpLinkSetName, Link: r.linkName(index), Name: name}, func() error {
+		return r.model.LinkSetName(ctx, index, name)
+	})
+}
+
+// LinkSetMTU records change of the link's MTU.
+func (r *Recorder) LinkSetMTU(ctx context.Context, index int, mtu int) error {
+	return r.record(Op{Type: OpLinkSetMTU, Link: r.linkName(index), MTU: mtu}, func() error {
+		return r.model.LinkSetMTU(ctx, index, mtu)
+	})
+}
+
+// LinkSetHardwareAddr records change of the link's MAC address.
+func (r *Recorder) LinkSetHardwareAddr(ctx context.Context, index int, hwaddr net.HardwareAddr) error {
+	op := Op{Type: OpLinkSetHardwareAddr, Link: r.linkName(index), HardwareAddr: append(net.HardwareAddr(nil), hwaddr...)}
+
+	return r.record(op, func() error {
+		return r.model.LinkSetHardwareAddr(ctx, index, hwaddr)
+	})
+}
+
+// LinkSetUp records bringing the link up.
+func (r *Recorder) LinkSetUp(ctx context.Context, index int) error {
+	return r.record(Op{Type: OpLinkSetUp, Link: r.linkName(index)}, func() error {
+		return r.model.LinkSetUp(ctx, index)
+	})
+}
+
+// LinkSetDown records bringing the link down.
+func (r *Recorder) LinkSetDown(ctx context.Context, index int) error {
+	return r.record(Op{Type: OpLinkSetDown, Link: r.linkName(index)}, func() error {
+		return r.model.LinkSetDown(ctx, index)
+	})
+}
+
+// LinkSetMaster records enslaving the link to the master device or releasing it if masterIndex is 0.
+func (r *Recorder) LinkSetMaster(ctx context.Context, index int, masterIndex int) error {
+	op := Op{Type: OpLinkSetMaster, Link: r.linkName(index)}
+	if masterIndex != 0 {
+		op.Master = r.linkName(masterIndex)
+	}
+
+	return r.record(op, func() error {
+		return r.model.LinkSetMaster(ctx, index, masterIndex)
+	})
+}
+
+// LinkSetNs records moving the link to network namespace specified by filesystem path.
+func (r *Recorder) LinkSetNs(ctx context.Context, index int, nspath string) error {
+	if err := r.ensureNs(nspath); err != nil {
+		return err
+	}
+
+	return r.record(Op{Type: OpLinkSetNs, Link: r.linkName(index), NsPath: nspath}, func() error {
+		return r.model.LinkSetNs(ctx, index, nspath)
+	})
+}
+
+// AddrAdd records assigning IP address to the link.
+func (r *Recorder) AddrAdd(ctx context.Context, index int, addr *net.IPNet) error {
+	return r.record(Op{Type: OpAddrAdd, Link: r.linkName(index), Addr: copyIPNet(addr)}, func() error {
+		return r.model.AddrAdd(ctx, index, addr)
+	})
+}
+
+// AddrDel records removing IP address from the link.
+func (r *Recorder) AddrDel(ctx context.Context, index int, addr *net.IPNet) error {
+	return r.record(Op{Type: OpAddrDel, Link: r.linkName(index), Addr: copyIPNet(addr)}, func() error {
+		return r.model.AddrDel(ctx, index, addr)
+	})
+}
+
+// AddrList returns IP addresses assigned to the link in the intended state.
+func (r *Recorder) AddrList(ctx context.Context, index int) ([]*net.IPNet, error) {
+	return r.model.AddrList(ctx, index)
+}
+
+// RouteAdd records adding route to the main routing table.
+func (r *Recorder) RouteAdd(ctx context.Context, route *Route) error {
+	return r.record(routeOp(OpRouteAdd, r.linkName(route.Index), route), func() error {
+		return r.model.RouteAdd(ctx, route)
+	})
+}
+
+// RouteDel records deleting route from the main routing table.
+func (r *Recorder) RouteDel(ctx context.Context, route *Route) error {
+	return r.record(routeOp(OpRouteDel, r.linkName(route.Index), route), func() error {
+		return r.model.RouteDel(ctx, route)
+	})
+}
+
+// RouteList returns routes in the main routing table in the intended state.
+func (r *Recorder) RouteList(ctx context.Context) ([]*Route, error) {
+	return r.model.RouteList(ctx)
+}
+
+// QdiscAdd records attaching the qdisc to the link.
+func (r *Recorder) QdiscAdd(ctx context.Context, index int, q *Qdisc) error {
+	return r.record(Op{Type: OpQdiscAdd, Link: r.linkName(index), Qdisc: copyQdisc(q)}, func() error {
+		return r.model.QdiscAdd(ctx, index, q)
+	})
+}
+
+// QdiscReplace records replacing the link's qdisc.
+func (r *Recorder) QdiscReplace(ctx context.Context, index int, q *Qdisc) error {
+	return r.record(Op{Type: OpQdiscReplace, Link: r.linkName(index), Qdisc: copyQdisc(q)}, func() error {
+		return r.model.QdiscReplace(ctx, index, q)
+	})
+}
+
+// QdiscDel records detaching the qdisc from the link.
+func (r *Recorder) QdiscDel(ctx context.Context, index int, q *Qdisc) error {
+	return r.record(Op{Type: OpQdiscDel, Link: r.linkName(index), Qdisc: copyQdisc(q)}, func() error {
+		return r.model.QdiscDel(ctx, index, q)
+	})
+}
+
+// QdiscList returns qdiscs attached to the link in the intended state.
+func (r *Recorder) QdiscList(ctx context.Context, index int) ([]*Qdisc, error) {
+	return r.model.QdiscList(ctx, index)
+}
+
+// ClassAdd records adding the class to the link's classful qdisc.
+func (r *Recorder) ClassAdd(ctx context.Context, index int, c *Class) error {
+	return r.record(Op{Type: OpClassAdd, Link: r.linkName(index), Class: copyClass(c)}, func() error {
+		return r.model.ClassAdd(ctx, index, c)
+	})
+}
+
+// ClassReplace records adding or changing the link's class.
+func (r *Recorder) ClassReplace(ctx context.Context, index int, c *Class) error {
+	return r.record(Op{Type: OpClassReplace, Link: r.linkName(index), Class: copyClass(c)}, func() error {
+		return r.model.ClassReplace(ctx, index, c)
+	})
+}
+
+// ClassDel records deleting the link's class.
+func (r *Recorder) ClassDel(ctx context.Context, index int, c *Class) error {
+	return r.record(Op{Type: OpClassDel, Link: r.linkName(index), Class: copyClass(c)}, func() error {
+		return r.model.ClassDel(ctx, index, c)
+	})
+}
+
+// ClassList returns classes of qdiscs attached to the link in the intended state.
+func (r *Recorder) ClassList(ctx context.Context, index int) ([]*Class, error) {
+	return r.model.ClassList(ctx, index)
+}
+
+// FilterAdd records attaching the filter to the link's qdisc or class.
+func (r *Recorder) FilterAdd(ctx context.Context, index int, f *Filter) error {
+	return r.record(Op{Type: OpFilterAdd, Link: r.linkName(index), Filter: copyFilter(f)}, func() error {
+		return r.model.FilterAdd(ctx, index, f)
+	})
+}
+
+// FilterReplace records attaching or changing the link's filter.
+func (r *Recorder) FilterReplace(ctx context.Context, index int, f *Filter) error {
+	return r.record(Op{Type: OpFilterReplace, Link: r.linkName(index), Filter: copyFilter(f)}, func() error {
+		return r.model.FilterReplace(ctx, index, f)
+	})
+}
+
+// FilterDel records detaching the filter from the link.
+func (r *Recorder) FilterDel(ctx context.Context, index int, f *Filter) error {
+	return r.record(Op{Type: OpFilterDel, Link: r.linkName(index), Filter: copyFilter(f)}, func() error {
+		return r.model.FilterDel(ctx, index, f)
+	})
+}
+
+// FilterList returns filters attached to the link's qdisc or class in the intended state.
+func (r *Recorder) FilterList(ctx context.Context, index int, parent uint32) ([]*Filter, error) {
+	return r.model.FilterList(ctx, index, parent)
+}
+
+// NsCreate records creation of network namespace pinned to the filesystem path.
+func (r *Recorder) NsCreate(nspath string) error {
+	if err := r.ensureNs(nspath); err != nil {
+		return err
+	}
+
+	return r.record(Op{Type: OpNsCreate, NsPath: nspath}, func() error {
+		return r.model.NsCreate(nspath)
+	})
+}
+
+// NsDelete records deletion of network namespace pinned to the filesystem path.
+func (r *Recorder) NsDelete(nspath string) error {
+	if err := r.ensureNs(nspath); err != nil {
+		return err
+	}
+
+	return r.record(Op{Type: OpNsDelete, NsPath: nspath}, func() error {
+		return r.model.NsDelete(nspath)
+	})
+}
+
+// NsExists returns true if network namespace exists in the intended state.
+func (r *Recorder) NsExists(nspath string) bool {
+	if err := r.ensureNs(nspath); err != nil {
+		return false
+	}
+
+	return r.model.NsExists(nspath)
+}
+
+// NsList returns filesystem paths of network namespaces pinned in NetNsRunDir in the intended state.
+func (r *Recorder) NsList() ([]string, error) {
+	if r.base != nil {
+		nspaths, err := r.base.NsList()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, nspath := range nspaths {
+			if err := r.ensureNs(nspath); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return r.model.NsList()
+}
+
+// ExecInNs runs fn with the recorder switched to network namespace specified by filesystem path.
+// Changes made by fn are recorded with the network namespace path.
+func (r *Recorder) ExecInNs(nspath string, fn func() error) error {
+	if err := r.ensureNs(nspath); err != nil {
+		return err
+	}
+
+	// paths of the current process' network namespace refer to the namespace the recording started in
+	ns := nspath
+	if nspath == "/proc/self/ns/net" || nspath == pidNsPath(os.Getpid()) {
+		ns = ""
+	}
+
+	return r.model.ExecInNs(nspath, func() error {
+		r.mu.Lock()
+		prev := r.ns
+		r.ns = ns
+		r.mu.Unlock()
+
+		defer func() {
+			r.mu.Lock()
+			r.ns = prev
+			r.mu.Unlock()
+		}()
+
+		return fn()
+	})
+}
+
+// routeOp returns Op of the route change
+func routeOp(opType OpType, link string, route *Route) Op {
+	c := copyRoute(route)
+
+	return Op{Type: opType, Link: link, Dst: c.Dst, Gw: c.Gw}
+}
+
+// copyIPNet returns deep copy of the IP network
+func copyIPNet(n *net.IPNet) *net.IPNet {
+	if n == nil {
+		return nil
+	}
+
+	return &net.IPNet{IP: append(net.IP(nil), n.IP...), Mask: append(net.IPMask(nil), n.Mask...)}
+}
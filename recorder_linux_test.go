@@ -0,0 +1,385 @@
+package tenus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/milosgajdos/tenus/tenustest"
+)
+
+const recordedBatch = `link add vethrec01 txqueuelen 500 type veth peer name vethrec02 txqueuelen 500
+link add brrec01 type bridge
+link set dev vethrec01 master brrec01
+link set dev brrec01 mtu 1400
+link set dev brrec01 address 02:42:ac:11:00:02
+address add 10.40.0.1/24 dev brrec01
+link set dev brrec01 up
+route add default via 10.40.0.254 dev brrec01
+netns add nsrec01
+link set dev vethrec02 netns nsrec01
+netns exec nsrec01 ip address add 10.40.0.2/24 dev vethrec02
+netns exec nsrec01 ip link set dev vethrec02 up
+link set dev vethrec01 nomaster
+link del dev vethrec01
+`
+
+func Test_DryRun(t *testing.T) {
+	fake := NewFakeBackend()
+	defer SetBackend(SetBackend(fake))
+	// ownership tags contain creation time
+	defer SetLinkOwner(SetLinkOwner(""))
+
+	ops, err := DryRun(func() error {
+		veth, err := NewVethPairWithOptions("vethrec01", VethOptions{PeerName: "vethrec02", TxQueueLen: 500})
+		if err != nil {
+			return err
+		}
+
+		br, err := NewBridgeWithName("brrec01")
+		if err != nil {
+			return err
+		}
+
+		if err := br.AddSlaveIfc(veth.NetInterface()); err != nil {
+			return err
+		}
+
+		if err := br.SetLinkMTU(1400); err != nil {
+			return err
+		}
+
+		if err := br.SetLinkMacAddress("02:42:ac:11:00:02"); err != nil {
+			return err
+		}
+
+		ip, network, _ := net.ParseCIDR("10.40.0.1/24")
+		if err := br.SetLinkIp(ip, network); err != nil {
+			return err
+		}
+
+		if err := br.SetLinkUp(); err != nil {
+			return err
+		}
+
+		gw := net.ParseIP("10.40.0.254")
+		if err := br.SetLinkDefaultGw(&gw); err != nil {
+			return err
+		}
+
+		if err := NewNamedNetNs("nsrec01"); err != nil {
+			return err
+		}
+
+		if err := veth.SetPeerLinkNsFd(NetNsPath("nsrec01")); err != nil {
+			return err
+		}
+
+		err = ExecInNamedNetNs("nsrec01", func() error {
+			peer, err := NewLinkFrom("vethrec02")
+			if err != nil {
+				return err
+			}
+
+			ip, network, _ := net.ParseCIDR("10.40.0.2/24")
+			if err := peer.SetLinkIp(ip, network); err != nil {
+				return err
+			}
+
+			return peer.SetLinkUp()
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := br.RemoveSlaveIfc(veth.NetInterface()); err != nil {
+			return err
+		}
+
+		return veth.DeleteLink()
+	})
+	if err != nil {
+		t.Fatalf("DryRun() failed: %s", err)
+	}
+
+	var batch bytes.Buffer
+	if err := WriteIPBatch(&batch, ops); err != nil {
+		t.Fatalf("WriteIPBatch() failed: %s", err)
+	}
+
+	if batch.String() != recordedBatch {
+		t.Fatalf("WriteIPBatch() failed: expected\n%s\nreturned\n%s", recordedBatch, batch.String())
+	}
+
+	if ops[10].Ns != NetNsPath("nsrec01") || ops[10].String() != "ip netns exec nsrec01 ip address add 10.40.0.2/24 dev vethrec02" {
+		t.Fatalf("DryRun() failed: returned %+v", ops[10])
+	}
+
+	links, err := fake.LinkList(context.Background())
+	if err != nil {
+		t.Fatalf("LinkList() failed: %s", err)
+	}
+
+	if len(links) != 1 || fake.NsExists(NetNsPath("nsrec01")) {
+		t.Fatalf("DryRun() changed the backend: links %+v", links)
+	}
+}
+
+func Test_DryRunErrors(t *testing.T) {
+	fake := NewFakeBackend()
+	defer SetBackend(SetBackend(fake))
+
+	if err := fake.LinkAdd(context.Background(), LinkSpec{Name: "dummyrec01", Kind: "dummy"}); err != nil {
+		t.Fatalf("LinkAdd() failed: %s", err)
+	}
+
+	ops, err := DryRun(func() error {
+		if err := DeleteLink("dummyrec01"); err != nil {
+			return err
+		}
+
+		// the link no longer exists in the recorded state
+		return DeleteLink("dummyrec01")
+	})
+	if !errors.Is(err, ErrLinkNotFound) {
+		t.Fatalf("DryRun() failed: expected %v, returned %v", ErrLinkNotFound, err)
+	}
+
+	if len(ops) != 1 || ops[0].String() != "ip link del dev dummyrec01" {
+		t.Fatalf("DryRun() failed: returned %v", ops)
+	}
+
+	if _, err := fake.LinkByName(context.Background(), "dummyrec01"); err != nil {
+		t.Fatalf("DryRun() deleted the link from the backend: %s", err)
+	}
+}
+
+type ipBatchTest struct {
+	op       Op
+	expected string
+}
+
+var ipBatchTests = []ipBatchTest{
+	{Op{Type: OpLinkAdd, Link: "dummy01", Spec: LinkSpec{Name: "dummy01", Kind: "dummy"}}, "link add dummy01 type dummy"},
+	{Op{Type: OpLinkAdd, Link: "vlan01", Spec: LinkSpec{Name: "vlan01", Kind: "vlan", VlanId: 10}, Parent: "eth0"},
+		"link add link eth0 name vlan01 type vlan id 10"},
+	{Op{Type: OpLinkAdd, Link: "mc01", Spec: LinkSpec{Name: "mc01", Kind: "macvtap", MacVlanMode: "vepa"}, Parent: "eth0"},
+		"link add link eth0 name mc01 type macvtap mode vepa"},
+	{Op{Type: OpLinkAdd, Link: "veth01", Spec: LinkSpec{Name: "veth01", Kind: "veth", PeerName: "veth02", Alias: "tenus:my app:1:2"}},
+		"link add veth01 type veth peer name veth02\nlink set dev veth01 alias \"tenus:my app:1:2\"\nlink set dev veth02 alias \"tenus:my app:1:2\""},
+	{Op{Type: OpLinkAdd, Link: "br01", Spec: LinkSpec{Name: "br01", Kind: "bridge", Alias: "tenus:app:1:2"}, Ns: NetNsPath("ns01")},
+		"netns exec ns01 ip link add br01 type bridge\nnetns exec ns01 ip link set dev br01 alias tenus:app:1:2"},
+	{Op{Type: OpLinkSetName, Link: "dummy01", Name: "dummy02"}, "link set dev dummy01 name dummy02"},
+	{Op{Type: OpLinkSetDown, Link: "dummy01"}, "link set dev dummy01 down"},
+	{Op{Type: OpLinkSetNs, Link: "veth01", NsPath: "/proc/1234/ns/net"}, "link set dev veth01 netns 1234"},
+	{Op{Type: OpAddrDel, Link: "dummy01", Addr: &net.IPNet{IP: net.ParseIP("fd00::1"), Mask: net.CIDRMask(64, 128)}},
+		"address del fd00::1/64 dev dummy01"},
+	{Op{Type: OpRouteDel, Link: "dummy01", Dst: &net.IPNet{IP: net.IPv4(10, 1, 0, 0).To4(), Mask: net.CIDRMask(16, 32)}},
+		"route del 10.1.0.0/16 dev dummy01"},
+	{Op{Type: OpNsDelete, NsPath: NetNsPath("ns01")}, "netns delete ns01"},
+	{Op{Type: OpLinkSetUp, Link: "veth02", Ns: "/proc/1234/ns/net"}, ""},
+	{Op{Type: OpLinkSetNs, Link: "veth01", NsPath: "/tmp/ns01"}, ""},
+	{Op{Type: OpLinkAdd, Link: "tun01", Spec: LinkSpec{Name: "tun01", Kind: "tun"}}, ""},
+}
+
+func Test_WriteIPBatch(t *testing.T) {
+	for _, tt := range ipBatchTests {
+		var batch bytes.Buffer
+		err := WriteIPBatch(&batch, []Op{tt.op})
+		if tt.expected == "" {
+			if err == nil {
+				t.Errorf("WriteIPBatch(%+v) expected to fail, returned %q", tt.op, batch.String())
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("WriteIPBatch(%+v) failed: %s", tt.op, err)
+			continue
+		}
+
+		if got := strings.TrimSuffix(batch.String(), "\n"); got != tt.expected {
+			t.Errorf("WriteIPBatch(%+v) failed: expected %q, returned %q", tt.op, tt.expected, got)
+		}
+	}
+}
+
+type tcBatchTest struct {
+	ops      []Op
+	expected string
+}
+
+var tcBatchTests = []tcBatchTest{
+	{[]Op{{Type: OpQdiscAdd, Link: "veth01", Qdisc: &Qdisc{Kind: "htb", Handle: MakeHandle(1, 0), Htb: &HtbOptions{Default: 0x10}}}},
+		"qdisc add dev veth01 root handle 1: htb default 10"},
+	{[]Op{{Type: OpQdiscReplace, Link: "veth01", Qdisc: &Qdisc{Kind: "tbf", Parent: MakeHandle(1, 0x10),
+		Tbf: &TbfOptions{Rate: 125000, Burst: 5000, Latency: 50 * time.Millisecond}}}},
+		"qdisc replace dev veth01 parent 1:10 tbf rate 125000bps burst 5000 latency 50000us"},
+	{[]Op{{Type: OpQdiscAdd, Link: "veth01", Qdisc: &Qdisc{Kind: "netem", Netem: &NetemOptions{Delay: 10 * time.Millisecond,
+		Jitter: time.Millisecond, Loss: 0.5, Reorder: 25, ReorderCorrelation: 50}}}},
+		"qdisc add dev veth01 root netem limit 1000 delay 10000us 1000us loss 0.5% reorder 25% 50%"},
+	{[]Op{{Type: OpQdiscAdd, Link: "veth01", Qdisc: &Qdisc{Kind: "fq_codel", FqCodel: &FqCodelOptions{Target: 5 * time.Millisecond}}}},
+		"qdisc add dev veth01 root fq_codel target 5000us ecn"},
+	{[]Op{{Type: OpQdiscAdd, Link: "veth01", Qdisc: &Qdisc{Kind: "prio", Prio: &PrioOptions{Bands: 2}}}},
+		"qdisc add dev veth01 root prio bands 2 priomap 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0"},
+	{[]Op{{Type: OpQdiscDel, Link: "veth01", Qdisc: &Qdisc{Kind: "clsact"}}}, "qdisc del dev veth01 clsact"},
+	{[]Op{{Type: OpQdiscDel, Link: "veth01", Qdisc: &Qdisc{Kind: "htb", Handle: MakeHandle(1, 0), Htb: &HtbOptions{Default: 1}}}},
+		"qdisc del dev veth01 root handle 1:"},
+	{[]Op{{Type: OpLinkSetUp, Link: "veth01"}, {Type: OpQdiscAdd, Link: "veth01", Qdisc: &Qdisc{Kind: "ingress"}, Ns: NetNsPath("ns01")}},
+		"qdisc add dev veth01 ingress"},
+	{[]Op{{Type: OpClassAdd, Link: "veth01", Class: &Class{Kind: "htb", Handle: MakeHandle(1, 0x10), Parent: MakeHandle(1, 0),
+		Htb: &HtbClassOptions{Rate: 125000, Ceil: 250000, Burst: 5000, Prio: 1}}}},
+		"class add dev veth01 parent 1: classid 1:10 htb rate 125000bps ceil 250000bps burst 5000 prio 1"},
+	{[]Op{{Type: OpClassDel, Link: "veth01", Class: &Class{Kind: "htb", Handle: MakeHandle(1, 0x10), Parent: MakeHandle(1, 0),
+		Htb: &HtbClassOptions{Rate: 125000}}}},
+		"class del dev veth01 parent 1: classid 1:10"},
+	{[]Op{{Type: OpFilterAdd, Link: "veth01", Filter: &Filter{Kind: "u32", Parent: MakeHandle(1, 0), Priority: 1, Protocol: syscall.ETH_P_IP,
+		ClassID: MakeHandle(1, 0x10), U32: &U32Options{Keys: append(U32DstIP(mustParseCIDR("10.0.0.0/8")), U32DstPort(80))}}}},
+		"filter add dev veth01 parent 1: prio 1 protocol 0x0800 u32 match u32 0x0a000000 0xff000000 at 16 match u32 0x00000050 0x0000ffff at 20 classid 1:10"},
+	{[]Op{{Type: OpFilterReplace, Link: "veth01", Filter: &Filter{Kind: "u32", Parent: HandleMinIngress, Priority: 1, Handle: 0x80000800,
+		U32: &U32Options{}, Actions: []*Action{{Kind: "mirred", Mirred: &MirredAction{Dev: "veth02", Redirect: true, Ingress: true}}}}}},
+		"filter replace dev veth01 ingress handle 800:0:800 prio 1 protocol all u32 match u32 0x00000000 0x00000000 at 0 action mirred ingress redirect dev veth02"},
+	{[]Op{{Type: OpFilterAdd, Link: "veth01", Filter: &Filter{Kind: "matchall", Parent: HandleMinEgress, Priority: 2,
+		Actions: []*Action{{Kind: "police", Police: &PoliceAction{Rate: 125000, Burst: 10000}}, {Kind: "drop"}}}}},
+		"filter add dev veth01 egress prio 2 protocol all matchall action police rate 125000bps burst 10000 conform-exceed drop/pipe action drop"},
+	{[]Op{{Type: OpFilterAdd, Link: "veth01", Filter: &Filter{Kind: "flower", Parent: HandleMinIngress, Priority: 3, Protocol: syscall.ETH_P_IP,
+		Flower:  &FlowerOptions{IPProto: syscall.IPPROTO_TCP, DstIP: mustParseCIDR("10.0.0.0/8"), DstPort: 22},
+		Actions: []*Action{{Kind: "vlan", Vlan: &VlanAction{Pop: true}}, {Kind: "vlan", Vlan: &VlanAction{Id: 10, Priority: 3}}}}}},
+		"filter add dev veth01 ingress prio 3 protocol 0x0800 flower ip_proto tcp dst_ip 10.0.0.0/8 dst_port 22 action vlan pop action vlan push id 10 priority 3"},
+	{[]Op{{Type: OpFilterDel, Link: "veth01", Filter: &Filter{Kind: "u32", Parent: HandleMinEgress, Priority: 1, Handle: 0x80100805}}},
+		"filter del dev veth01 egress handle 801:0:805 prio 1 protocol all u32"},
+	{[]Op{{Type: OpFilterDel, Link: "veth01", Filter: &Filter{Parent: MakeHandle(1, 0)}}}, "filter del dev veth01 parent 1:"},
+	{[]Op{{Type: OpQdiscAdd, Link: "veth01", Qdisc: &Qdisc{Kind: "sfq"}}}, ""},
+	{[]Op{{Type: OpQdiscAdd, Link: "veth01", Qdisc: &Qdisc{Kind: "tbf"}}}, ""},
+	{[]Op{{Type: OpQdiscAdd, Link: "veth01", Qdisc: &Qdisc{Kind: "ingress"}},
+		{Type: OpQdiscAdd, Link: "veth02", Qdisc: &Qdisc{Kind: "ingress"}, Ns: NetNsPath("ns01")}}, ""},
+}
+
+func Test_WriteTcBatch(t *testing.T) {
+	for _, tt := range tcBatchTests {
+		var batch bytes.Buffer
+		err := WriteTcBatch(&batch, tt.ops)
+		if tt.expected == "" {
+			if err == nil {
+				t.Errorf("WriteTcBatch(%+v) expected to fail, returned %q", tt.ops, batch.String())
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("WriteTcBatch(%+v) failed: %s", tt.ops, err)
+			continue
+		}
+
+		if got := strings.TrimSuffix(batch.String(), "\n"); got != tt.expected {
+			t.Errorf("WriteTcBatch(%+v) failed: expected %q, returned %q", tt.ops, tt.expected, got)
+		}
+	}
+}
+
+func Test_DryRunQdiscs(t *testing.T) {
+	fake := NewFakeBackend()
+	defer SetBackend(SetBackend(fake))
+
+	ops, err := DryRun(func() error {
+		link, err := NewLinkWithOptions("dummyrec02", LinkOptions{})
+		if err != nil {
+			return err
+		}
+
+		if err := link.AddQdisc(&Qdisc{Kind: "htb", Handle: MakeHandle(1, 0)}); err != nil {
+			return err
+		}
+
+		class := &Class{Kind: "htb", Handle: MakeHandle(1, 0x10), Parent: MakeHandle(1, 0), Htb: &HtbClassOptions{Rate: 125000}}
+		if err := link.AddClass(class); err != nil {
+			return err
+		}
+
+		if err := link.AddFilter(&Filter{Kind: "matchall", ClassID: class.Handle}); err != nil {
+			return err
+		}
+
+		return link.AddQdisc(&Qdisc{Kind: "clsact"})
+	})
+	if err != nil {
+		t.Fatalf("DryRun() failed: %s", err)
+	}
+
+	var ipBatch, tcBatch bytes.Buffer
+	if err := WriteIPBatch(&ipBatch, ops); err != nil {
+		t.Fatalf("WriteIPBatch() failed: %s", err)
+	}
+
+	if err := WriteTcBatch(&tcBatch, ops); err != nil {
+		t.Fatalf("WriteTcBatch() failed: %s", err)
+	}
+
+	if strings.Contains(ipBatch.String(), "qdisc") || !strings.HasPrefix(ipBatch.String(), "link add dummyrec02 type dummy\n") {
+		t.Fatalf("WriteIPBatch() failed: returned\n%s", ipBatch.String())
+	}
+
+	expected := "qdisc add dev dummyrec02 root handle 1: htb\n" +
+		"class add dev dummyrec02 parent 1: classid 1:10 htb rate 125000bps\n" +
+		"filter add dev dummyrec02 root protocol all matchall classid 1:10\n" +
+		"qdisc add dev dummyrec02 clsact\n"
+	if tcBatch.String() != expected {
+		t.Fatalf("WriteTcBatch() failed: expected\n%s\nreturned\n%s", expected, tcBatch.String())
+	}
+
+	if ops[len(ops)-1].String() != "tc qdisc add dev dummyrec02 clsact" {
+		t.Fatalf("DryRun() failed: returned %v", ops[len(ops)-1])
+	}
+}
+
+func Test_DryRunKernel(t *testing.T) {
+	ns := tenustest.New(t)
+
+	veth, err := NewVethPairWithOptions("vethrec01", VethOptions{PeerName: "vethrec02"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	if err := veth.AddQdisc(&Qdisc{Kind: "ingress"}); err != nil {
+		t.Fatalf("AddQdisc() failed: %s", err)
+	}
+
+	ops, err := DryRun(func() error {
+		// the qdisc is read from the kernel when the recording starts
+		if err := veth.DelQdisc(&Qdisc{Kind: "ingress"}); err != nil {
+			return err
+		}
+
+		br, err := NewBridgeWithName("brrec01")
+		if err != nil {
+			return err
+		}
+
+		veth, err := NewLinkFrom("vethrec01")
+		if err != nil {
+			return err
+		}
+
+		return br.AddSlaveIfc(veth.NetInterface())
+	})
+	if err != nil {
+		t.Fatalf("DryRun() failed: %s", err)
+	}
+
+	if len(ops) != 3 || ops[0].String() != "tc qdisc del dev vethrec01 ingress" ||
+		ops[2].String() != "ip link set dev vethrec01 master brrec01" {
+		t.Fatalf("DryRun() failed: returned %v", ops)
+	}
+
+	if q := findQdisc(t, veth, "ingress"); q == nil {
+		t.Fatalf("DryRun() deleted ingress qdisc")
+	}
+
+	ns.AssertNoLink("brrec01")
+	ns.AssertLinkMaster("vethrec01", "")
+}
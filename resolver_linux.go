@@ -0,0 +1,320 @@
+package tenus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPodmanSocket is the path of the rootful Podman API socket
+	DefaultPodmanSocket = "/run/podman/podman.sock"
+	// DefaultCRIOSocket is the path of the CRI-O socket
+	DefaultCRIOSocket = "/var/run/crio/crio.sock"
+	// DefaultContainerdStateDir is the state directory of containerd v2 runtime shims
+	DefaultContainerdStateDir = "/run/containerd/io.containerd.runtime.v2.task"
+	// DefaultCgroupRoot is the mount point of the cgroup filesystem
+	DefaultCgroupRoot = "/sys/fs/cgroup"
+)
+
+// podmanAPIVersion is the libpod REST API version requested by PodmanResolver
+const podmanAPIVersion = "v4.0.0"
+
+// NsResolver resolves container IDs into filesystem paths of their network namespaces.
+type NsResolver interface {
+	// ResolveNs returns filesystem path of the network namespace of the container with the given ID.
+	// It returns ErrNsNotFound if the container does not exist or is not running.
+	ResolveNs(ctx context.Context, id string) (string, error)
+}
+
+// DockerResolver resolves Docker container IDs or names via Docker API.
+// DockerClient is NsResolver, too, and should be used to talk to Docker daemon over TLS.
+type DockerResolver struct {
+	// Host is the address of Docker daemon as accepted by DockerClient.
+	// If empty, Docker daemon is configured by DOCKER_HOST and related environment variables.
+	Host string
+}
+
+// ResolveNs returns network namespace path of the running Docker container.
+func (r *DockerResolver) ResolveNs(ctx context.Context, id string) (string, error) {
+	client := &DockerClient{Host: r.Host}
+	if r.Host == "" {
+		var err error
+		if client, err = NewDockerClientFromEnv(); err != nil {
+			return "", err
+		}
+	}
+
+	return client.ResolveNs(ctx, id)
+}
+
+// PodmanResolver resolves Podman container names or IDs via Podman's libpod REST API.
+type PodmanResolver struct {
+	// Socket is the path of Podman API UNIX socket. DefaultPodmanSocket is used if empty.
+	Socket string
+}
+
+// ResolveNs returns network namespace path of the running Podman container.
+func (r *PodmanResolver) ResolveNs(ctx context.Context, id string) (string, error) {
+	socket := r.Socket
+	if socket == "" {
+		socket = DefaultPodmanSocket
+	}
+
+	data := struct {
+		State struct {
+			Pid int
+		}
+	}{}
+
+	if err := unixGetJSON(ctx, socket, "/"+podmanAPIVersion+"/libpod/containers/"+url.PathEscape(id)+"/json", &data); err != nil {
+		return "", resolveError("podman", id, err)
+	}
+
+	return pidNsPathOf("podman", id, data.State.Pid)
+}
+
+// CRIOResolver resolves container or pod sandbox IDs via CRI-O's inspect API served on the CRI-O socket.
+// The ID of a pod sandbox is the ID of its infra container, so pod sandboxes resolve to the pod's network namespace.
+type CRIOResolver struct {
+	// Socket is the path of CRI-O UNIX socket. DefaultCRIOSocket is used if empty.
+	Socket string
+}
+
+// ResolveNs returns network namespace path of the running CRI-O container or pod sandbox.
+func (r *CRIOResolver) ResolveNs(ctx context.Context, id string) (string, error) {
+	socket := r.Socket
+	if socket == "" {
+		socket = DefaultCRIOSocket
+	}
+
+	data := struct {
+		Pid int `json:"pid"`
+	}{}
+
+	if err := unixGetJSON(ctx, socket, "/containers/"+url.PathEscape(id), &data); err != nil {
+		return "", resolveError("cri-o", id, err)
+	}
+
+	return pidNsPathOf("cri-o", id, data.Pid)
+}
+
+// ContainerdResolver resolves containerd container or pod sandbox IDs via PID files of containerd v2 runtime shims.
+// containerd serves CRI over gRPC only, so the resolver reads the init.pid file the shim keeps in its state directory.
+type ContainerdResolver struct {
+	// StateDir is the state directory of the runtime shims. DefaultContainerdStateDir is used if empty.
+	StateDir string
+	// Namespace is the containerd namespace of the container. Kubernetes uses "k8s.io", which is used if empty.
+	Namespace string
+}
+
+// ResolveNs returns network namespace path of the running containerd container or pod sandbox.
+func (r *ContainerdResolver) ResolveNs(ctx context.Context, id string) (string, error) {
+	dir, namespace := r.StateDir, r.Namespace
+	if dir == "" {
+		dir = DefaultContainerdStateDir
+	}
+
+	if namespace == "" {
+		namespace = "k8s.io"
+	}
+
+	if id == "" || strings.Contains(id, "/") {
+		return "", fmt.Errorf("%w: invalid containerd ID %q", ErrNsNotFound, id)
+	}
+
+	pidFile := &PidFileResolver{Pattern: filepath.Join(dir, namespace, "%s", "init.pid")}
+
+	return pidFile.ResolveNs(ctx, id)
+}
+
+// PidFileResolver resolves IDs via files containing PID of a process running in the network namespace.
+type PidFileResolver struct {
+	// Pattern is the path of the PID file in which %s is replaced with the ID.
+	// The ID must be a single path element, so it can't point the pattern to another file.
+	// If empty, the ID itself is the path of the PID file.
+	Pattern string
+}
+
+// ResolveNs returns network namespace path of the process whose PID is stored in the PID file.
+func (r *PidFileResolver) ResolveNs(ctx context.Context, id string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	path := id
+	if r.Pattern != "" {
+		if id == "" || id == "." || id == ".." || strings.Contains(id, "/") {
+			return "", fmt.Errorf("%w: invalid pid file ID %q", ErrNsNotFound, id)
+		}
+		path = strings.Replace(r.Pattern, "%s", id, -1)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", resolveError("pid file", id, err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return "", resolveError("pid file", id, fmt.Errorf("Invalid PID in %s: %s", path, err))
+	}
+
+	return pidNsPathOf("pid file", id, pid)
+}
+
+// CgroupResolver resolves cgroup paths via processes which are members of the cgroup.
+type CgroupResolver struct {
+	// Root is the mount point of the cgroup hierarchy. DefaultCgroupRoot is used if empty.
+	Root string
+}
+
+// ResolveNs returns network namespace path of the first process of the cgroup.
+// The ID is the path of the cgroup relative to the cgroup root, e.g. system.slice/docker-${ID}.scope
+func (r *CgroupResolver) ResolveNs(ctx context.Context, id string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	root := r.Root
+	if root == "" {
+		root = DefaultCgroupRoot
+	}
+
+	// cgroup paths must not escape the cgroup root
+	path := filepath.Join(root, filepath.Join("/", id), "cgroup.procs")
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", resolveError("cgroup", id, err)
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%w: cgroup %s: no processes", ErrNsNotFound, id)
+	}
+
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return "", resolveError("cgroup", id, fmt.Errorf("Invalid PID in %s: %s", path, err))
+	}
+
+	return pidNsPathOf("cgroup", id, pid)
+}
+
+// resolveNs resolves the ID with the resolver.
+// All errors but the cancellation of the context are reported as ErrNsNotFound.
+// The resolver's error is preserved, so DockerError can be retrieved with errors.As.
+func resolveNs(ctx context.Context, resolver NsResolver, id string) (string, error) {
+	if resolver == nil {
+		return "", fmt.Errorf("%w: no resolver for %s", ErrNsNotFound, id)
+	}
+
+	nspath, err := resolver.ResolveNs(ctx, id)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return "", ctxErr
+	}
+
+	if err != nil && !errors.Is(err, ErrNsNotFound) {
+		return "", &lookupError{id: id, err: err}
+	}
+
+	return nspath, err
+}
+
+// lookupError records failed lookup of the container's network namespace. It matches ErrNsNotFound.
+type lookupError struct {
+	id  string
+	err error
+}
+
+func (e *lookupError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ErrNsNotFound, e.id, e.err)
+}
+
+func (e *lookupError) Unwrap() error {
+	return e.err
+}
+
+func (e *lookupError) Is(target error) bool {
+	return target == ErrNsNotFound
+}
+
+// resolveError reports missing containers and files as ErrNsNotFound
+func resolveError(kind, id string, err error) error {
+	var statusErr *httpStatusError
+	if errors.Is(err, os.ErrNotExist) || (errors.As(err, &statusErr) && statusErr.code == http.StatusNotFound) {
+		return fmt.Errorf("%w: %s %s: %s", ErrNsNotFound, kind, id, err)
+	}
+
+	return fmt.Errorf("Could not resolve %s %s: %w", kind, id, err)
+}
+
+// pidNsPathOf returns network namespace path of the process running the container
+func pidNsPathOf(kind, id string, pid int) (string, error) {
+	if pid <= 0 {
+		return "", fmt.Errorf("%w: %s %s", ErrContainerNotRunning, kind, id)
+	}
+
+	return pidNsPath(pid), nil
+}
+
+// httpStatusError is returned by unixGetJSON when the server responds with unexpected status code
+type httpStatusError struct {
+	code int
+	msg  string
+}
+
+func (e *httpStatusError) Error() string {
+	if e.msg == "" {
+		return http.StatusText(e.code)
+	}
+
+	return fmt.Sprintf("%s: %s", http.StatusText(e.code), e.msg)
+}
+
+// unixGetJSON sends GET request for path to HTTP server listening on UNIX socket and decodes JSON response into v
+func unixGetJSON(ctx context.Context, socket, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://localhost"+path, nil)
+	if err != nil {
+		return fmt.Errorf("Fail to create http request: %s", err)
+	}
+
+	dialer := &net.Dialer{Timeout: 2 * time.Second}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, proto string, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+	defer transport.CloseIdleConnections()
+
+	client := http.Client{Transport: transport}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to query API at %s: %w", socket, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &httpStatusError{code: resp.StatusCode, msg: strings.TrimSpace(string(body))}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("Unable to decode json response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,262 @@
+package tenus
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newUnixServer starts HTTP server with handler listening on UNIX socket in dir and returns its path
+func newUnixServer(t *testing.T, dir string, handler http.Handler) (string, func()) {
+	sock := filepath.Join(dir, "api.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("Resolver test requires UNIX socket: %v", err)
+	}
+
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Listener = l
+	srv.Start()
+
+	return sock, srv.Close
+}
+
+// containerAPI serves JSON documents by URL path
+type containerAPI map[string]string
+
+func (api containerAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	doc, ok := api[r.URL.EscapedPath()]
+	if !ok {
+		http.Error(w, "no such container", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(doc))
+}
+
+type resolverTest struct {
+	id     string
+	nspath string
+	err    error
+}
+
+func testResolver(t *testing.T, resolver NsResolver, tests []resolverTest) {
+	for _, tt := range tests {
+		nspath, err := resolver.ResolveNs(context.Background(), tt.id)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%T.ResolveNs(%q) failed: expected %v, returned %v", resolver, tt.id, tt.err, err)
+			}
+			continue
+		}
+
+		if err != nil || nspath != tt.nspath {
+			t.Errorf("%T.ResolveNs(%q) failed: expected %s, returned %q, %v", resolver, tt.id, tt.nspath, nspath, err)
+		}
+	}
+}
+
+func Test_PodmanResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenus")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock, stop := newUnixServer(t, dir, containerAPI{
+		"/v4.0.0/libpod/containers/web01/json":       `{"Id":"abc","State":{"Status":"running","Pid":4321}}`,
+		"/v4.0.0/libpod/containers/web%2F02/json":    `{"Id":"def","State":{"Status":"running","Pid":4322}}`,
+		"/v4.0.0/libpod/containers/stopped01/json":   `{"Id":"ghi","State":{"Status":"exited","Pid":0}}`,
+		"/v4.0.0/libpod/containers/corrupted01/json": `{"State":`,
+	})
+	defer stop()
+
+	testResolver(t, &PodmanResolver{Socket: sock}, []resolverTest{
+		{"web01", "/proc/4321/ns/net", nil},
+		{"web/02", "/proc/4322/ns/net", nil},
+		{"stopped01", "", ErrContainerNotRunning},
+		{"missing01", "", ErrNsNotFound},
+	})
+
+	if _, err := (&PodmanResolver{Socket: sock}).ResolveNs(context.Background(), "corrupted01"); err == nil || errors.Is(err, ErrNsNotFound) {
+		t.Fatalf("PodmanResolver.ResolveNs() failed: expected decoding error, returned %v", err)
+	}
+
+	if _, err := (&PodmanResolver{Socket: filepath.Join(dir, "none.sock")}).ResolveNs(context.Background(), "web01"); err == nil {
+		t.Fatalf("PodmanResolver.ResolveNs() expected to fail without Podman API")
+	}
+}
+
+func Test_CRIOResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenus")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock, stop := newUnixServer(t, dir, containerAPI{
+		"/containers/5f2a": `{"name":"k8s_POD_web","pid":5555,"sandbox":"5f2a"}`,
+		"/containers/9c1e": `{"name":"k8s_nginx_web","pid":5560,"sandbox":"5f2a"}`,
+		"/containers/0d3b": `{"name":"k8s_POD_done","pid":0,"sandbox":"0d3b"}`,
+	})
+	defer stop()
+
+	testResolver(t, &CRIOResolver{Socket: sock}, []resolverTest{
+		{"5f2a", "/proc/5555/ns/net", nil},
+		{"9c1e", "/proc/5560/ns/net", nil},
+		{"0d3b", "", ErrNsNotFound},
+		{"ffff", "", ErrNsNotFound},
+	})
+}
+
+func Test_FileResolvers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenus")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"run/app.pid":                                 "2345\n",
+		"run/bad.pid":                                 "pid\n",
+		"containerd/k8s.io/5f2a/init.pid":             "3456",
+		"containerd/moby/5f2a/init.pid":               "3457",
+		"cgroup/system.slice/app.scope/cgroup.procs":  "4567\n4568\n",
+		"cgroup/system.slice/idle.scope/cgroup.procs": "",
+	}
+
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Could not create directory: %s", err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("Could not create file: %s", err)
+		}
+	}
+
+	testResolver(t, &PidFileResolver{}, []resolverTest{
+		{filepath.Join(dir, "run/app.pid"), "/proc/2345/ns/net", nil},
+		{filepath.Join(dir, "run/none.pid"), "", ErrNsNotFound},
+	})
+
+	testResolver(t, &PidFileResolver{Pattern: filepath.Join(dir, "run/%s.pid")}, []resolverTest{
+		{"app", "/proc/2345/ns/net", nil},
+		{"none", "", ErrNsNotFound},
+		// IDs must not escape the pattern
+		{"../run/app", "", ErrNsNotFound},
+		{"../containerd/k8s.io/5f2a/init", "", ErrNsNotFound},
+		{"..", "", ErrNsNotFound},
+		{"", "", ErrNsNotFound},
+	})
+
+	if _, err := (&PidFileResolver{}).ResolveNs(context.Background(), filepath.Join(dir, "run/bad.pid")); err == nil {
+		t.Fatalf("PidFileResolver.ResolveNs() expected to fail with invalid PID")
+	}
+
+	testResolver(t, &ContainerdResolver{StateDir: filepath.Join(dir, "containerd")}, []resolverTest{
+		{"5f2a", "/proc/3456/ns/net", nil},
+		{"9c1e", "", ErrNsNotFound},
+		{"../moby/5f2a", "", ErrNsNotFound},
+	})
+
+	testResolver(t, &ContainerdResolver{StateDir: filepath.Join(dir, "containerd"), Namespace: "moby"}, []resolverTest{
+		{"5f2a", "/proc/3457/ns/net", nil},
+	})
+
+	testResolver(t, &CgroupResolver{Root: filepath.Join(dir, "cgroup")}, []resolverTest{
+		{"system.slice/app.scope", "/proc/4567/ns/net", nil},
+		{"/system.slice/app.scope", "/proc/4567/ns/net", nil},
+		{"system.slice/idle.scope", "", ErrNsNotFound},
+		{"system.slice/none.scope", "", ErrNsNotFound},
+		{"../cgroup/system.slice/app.scope", "", ErrNsNotFound},
+	})
+}
+
+func Test_SetLinkNsTo(t *testing.T) {
+	defer SetBackend(SetBackend(NewFakeBackend()))
+
+	dir, err := ioutil.TempDir("", "tenus")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock, stop := newUnixServer(t, dir, containerAPI{
+		"/containers/5f2a": `{"pid":4321}`,
+	})
+	defer stop()
+
+	if err := backend.NsCreate("/proc/4321/ns/net"); err != nil {
+		t.Fatalf("NsCreate() failed: %s", err)
+	}
+
+	veth, err := NewVethPairWithOptions("vethres01", VethOptions{PeerName: "vethres02"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	br, err := NewBridgeWithName("brres01")
+	if err != nil {
+		t.Fatalf("NewBridgeWithName() failed: %s", err)
+	}
+
+	resolver := &CRIOResolver{Socket: sock}
+	if err := veth.SetPeerLinkNsTo(resolver, "ffff"); !errors.Is(err, ErrNsNotFound) {
+		t.Fatalf("SetPeerLinkNsTo() failed: expected %v, returned %v", ErrNsNotFound, err)
+	}
+
+	if err := veth.SetPeerLinkNsTo(resolver, "5f2a"); err != nil {
+		t.Fatalf("SetPeerLinkNsTo() failed: %s", err)
+	}
+
+	if pair := veth.(*VethPair); pair.peerNs != 4321 {
+		t.Fatalf("SetPeerLinkNsTo() failed: expected peer namespace PID 4321, returned %d", pair.peerNs)
+	}
+
+	// peer link counters are read from the container's network namespace
+	if _, err := veth.PeerStats(); err != nil {
+		t.Fatalf("PeerStats() failed: %s", err)
+	}
+
+	pidFile := filepath.Join(dir, "br.pid")
+	if err := ioutil.WriteFile(pidFile, []byte("4321"), 0644); err != nil {
+		t.Fatalf("Could not create PID file: %s", err)
+	}
+
+	if err := br.SetLinkNsTo(&PidFileResolver{}, pidFile); err != nil {
+		t.Fatalf("SetLinkNsTo() failed: %s", err)
+	}
+
+	if err := br.SetLinkNsTo(nil, "5f2a"); !errors.Is(err, ErrNsNotFound) {
+		t.Fatalf("SetLinkNsTo() failed: expected %v, returned %v", ErrNsNotFound, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := veth.SetLinkNsToContext(ctx, resolver, "5f2a"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("SetLinkNsToContext() failed: expected %v, returned %v", context.Canceled, err)
+	}
+
+	err = execInNetNsPath("/proc/4321/ns/net", func() error {
+		for _, name := range []string{"vethres02", "brres01"} {
+			if _, err := LinkAttrsByName(name); err != nil {
+				t.Errorf("SetLinkNsTo() failed to move %s: %s", name, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("execInNetNsPath() failed: %s", err)
+	}
+}
@@ -0,0 +1,262 @@
+package tenus
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// rtnlSeq is the sequence number of the last rtnetlink request
+var rtnlSeq uint32
+
+// rtnlRequest sends rtnetlink request of the given type and waits for kernel acknowledgement.
+// data contains the request's family header followed by its attributes.
+// Waiting for the acknowledgement is aborted when the context is cancelled.
+// EPERM is returned as *CapabilityError if the process lacks CAP_NET_ADMIN.
+func rtnlRequest(ctx context.Context, msgType, flags uint16, data []byte) error {
+	_, err := rtnlExecute(ctx, msgType, syscall.NLM_F_ACK|flags, data)
+	return capabilityError(capNetAdmin, err)
+}
+
+// rtnlDump sends rtnetlink dump request of the given type and returns all the messages of the multipart reply.
+// data contains the request's family header which selects the dumped objects.
+func rtnlDump(ctx context.Context, msgType uint16, data []byte) ([]syscall.NetlinkMessage, error) {
+	return rtnlExecute(ctx, msgType, syscall.NLM_F_DUMP, data)
+}
+
+// rtnlExecute sends rtnetlink request to the kernel and collects the reply until it's acknowledged
+// or the multipart reply is done. The socket is opened in the calling thread's network namespace.
+// Waiting for the reply is aborted when the context is cancelled.
+func rtnlExecute(ctx context.Context, msgType, flags uint16, data []byte) ([]syscall.NetlinkMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sock, err := openEventSocket(0)
+	if err != nil {
+		return nil, err
+	}
+	defer sock.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// expired deadline unblocks pending reads
+			sock.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	seq := atomic.AddUint32(&rtnlSeq, 1)
+	if err := sendRequest(sock, encodeNlMsg(msgType, syscall.NLM_F_REQUEST|flags, seq, data)); err != nil {
+		return nil, fmt.Errorf("Could not send netlink request: %s", err)
+	}
+
+	var msgs []syscall.NetlinkMessage
+	buf := make([]byte, eventBufSize)
+	for {
+		n, err := recvEvents(sock, buf)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, fmt.Errorf("Could not receive netlink response: %s", err)
+		}
+
+		reply, done, err := parseRtnlReply(buf[:n], seq)
+		if err != nil {
+			return nil, err
+		}
+
+		msgs = append(msgs, reply...)
+		if done {
+			return msgs, nil
+		}
+	}
+}
+
+// encodeNlMsg encodes netlink message of the given type with data as its payload
+func encodeNlMsg(msgType, flags uint16, seq uint32, data []byte) []byte {
+	msg := make([]byte, syscall.NLMSG_HDRLEN+len(data))
+	nativeEndian.PutUint32(msg[0:4], uint32(len(msg)))
+	nativeEndian.PutUint16(msg[4:6], msgType)
+	nativeEndian.PutUint16(msg[6:8], flags)
+	nativeEndian.PutUint32(msg[8:12], seq)
+	copy(msg[syscall.NLMSG_HDRLEN:], data)
+
+	return msg
+}
+
+// parseRtnlReply decodes rtnetlink messages which are replies to the request with the given sequence number.
+// Messages of other requests are skipped. It returns true once the request is acknowledged or its multipart
+// reply is done and it returns the kernel's error if the request failed. Returned messages don't reference b.
+func parseRtnlReply(b []byte, seq uint32) ([]syscall.NetlinkMessage, bool, error) {
+	msgs, err := syscall.ParseNetlinkMessage(b)
+	if err != nil {
+		return nil, false, fmt.Errorf("Could not parse netlink response: %s", err)
+	}
+
+	var reply []syscall.NetlinkMessage
+	for _, m := range msgs {
+		if m.Header.Seq != seq {
+			continue
+		}
+
+		switch m.Header.Type {
+		case syscall.NLMSG_ERROR, syscall.NLMSG_DONE:
+			if len(m.Data) < 4 {
+				if m.Header.Type == syscall.NLMSG_DONE {
+					return reply, true, nil
+				}
+				return nil, false, fmt.Errorf("Netlink message too short: %d", len(m.Data))
+			}
+
+			if errno := -int32(nativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+				return nil, false, syscall.Errno(errno)
+			}
+
+			return reply, true, nil
+		}
+
+		m.Data = append([]byte(nil), m.Data...)
+		reply = append(reply, m)
+	}
+
+	return reply, false, nil
+}
+
+// sendRequest writes netlink message to the kernel via the netlink socket
+func sendRequest(sock *os.File, msg []byte) error {
+	rc, err := sock.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sendErr error
+	if err := rc.Write(func(fd uintptr) bool {
+		sendErr = syscall.Sendto(int(fd), msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+		return sendErr != syscall.EAGAIN
+	}); err != nil {
+		return err
+	}
+
+	return sendErr
+}
+
+// encodeRtAttr encodes rtnetlink attribute padded to RTA_ALIGNTO
+func encodeRtAttr(attrType uint16, value []byte) []byte {
+	l := syscall.SizeofRtAttr + len(value)
+	b := make([]byte, (l+syscall.RTA_ALIGNTO-1) & ^(syscall.RTA_ALIGNTO-1))
+	nativeEndian.PutUint16(b[0:2], uint16(l))
+	nativeEndian.PutUint16(b[2:4], attrType)
+	copy(b[syscall.SizeofRtAttr:], value)
+
+	return b
+}
+
+// encodeUint32 encodes uint32 attribute value in host byte order
+func encodeUint32(n uint32) []byte {
+	b := make([]byte, 4)
+	nativeEndian.PutUint32(b, n)
+
+	return b
+}
+
+// encodeUint16 encodes uint16 attribute value in host byte order
+func encodeUint16(n uint16) []byte {
+	b := make([]byte, 2)
+	nativeEndian.PutUint16(b, n)
+
+	return b
+}
+
+// encodeString encodes NUL terminated string attribute value
+func encodeString(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+// encodeNestedRtAttr encodes rtnetlink attribute which contains encoded attributes
+func encodeNestedRtAttr(attrType uint16, attrs ...[]byte) []byte {
+	var value []byte
+	for _, attr := range attrs {
+		value = append(value, attr...)
+	}
+
+	return encodeRtAttr(attrType, value)
+}
+
+// encodeIfInfomsg encodes ifinfomsg family header of link messages
+func encodeIfInfomsg(ifim *syscall.IfInfomsg) []byte {
+	return append([]byte(nil), (*[syscall.SizeofIfInfomsg]byte)(unsafe.Pointer(ifim))[:]...)
+}
+
+// encodeIfAddrmsg encodes ifaddrmsg family header of address messages
+func encodeIfAddrmsg(ifam *syscall.IfAddrmsg) []byte {
+	return append([]byte(nil), (*[syscall.SizeofIfAddrmsg]byte)(unsafe.Pointer(ifam))[:]...)
+}
+
+// encodeRtMsg encodes rtmsg family header of route messages
+func encodeRtMsg(rtm *syscall.RtMsg) []byte {
+	return append([]byte(nil), (*[syscall.SizeofRtMsg]byte)(unsafe.Pointer(rtm))[:]...)
+}
+
+// RouteList returns routes of the main routing table.
+// It is equivalent of running: ip route show
+func RouteList() ([]*Route, error) {
+	return backend.RouteList(context.Background())
+}
+
+// AddRoute adds the route to the main routing table.
+// It is equivalent of running: ip route add ${dst} via ${gw} dev ${link}
+// It returns error if the route's link does not exist or if the route could not be added.
+func AddRoute(route *Route) error {
+	attrs, err := backend.LinkByIndex(context.Background(), route.Index)
+	if err != nil {
+		return newLinkError("add route", "", err)
+	}
+
+	return newLinkError("add route", attrs.Name, backend.RouteAdd(context.Background(), route))
+}
+
+// DelRoute deletes the route from the main routing table.
+// It is equivalent of running: ip route del ${dst} via ${gw} dev ${link}
+func DelRoute(route *Route) error {
+	attrs, err := backend.LinkByIndex(context.Background(), route.Index)
+	if err != nil {
+		return newLinkError("del route", "", err)
+	}
+
+	return newLinkError("del route", attrs.Name, backend.RouteDel(context.Background(), route))
+}
+
+// DelDefaultGw deletes default route via the gateway on the link of the given name.
+// It is equivalent of running: ip route del default via ${gw} dev ${ifcName}
+func DelDefaultGw(gw net.IP, ifcName string) error {
+	return DelDefaultGwContext(context.Background(), gw, ifcName)
+}
+
+// DelDefaultGwContext deletes default route via the gateway on the link of the given name.
+// It returns without changing the routes if the context is done.
+func DelDefaultGwContext(ctx context.Context, gw net.IP, ifcName string) error {
+	ifc, err := interfaceByName(ifcName)
+	if err != nil {
+		return newLinkError("del default gw", ifcName, err)
+	}
+
+	if gw.To16() == nil {
+		return fmt.Errorf("Invalid gateway address: %s", gw)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return newLinkError("del default gw", ifcName, err)
+	}
+
+	return newLinkError("del default gw", ifcName, backend.RouteDel(ctx, &Route{Gw: gw, Index: ifc.Index}))
+}
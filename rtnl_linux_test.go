@@ -0,0 +1,270 @@
+package tenus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/milosgajdos/tenus/tenustest"
+)
+
+// nativeBytes encodes values in host byte order the way rtnetlink expects them
+func nativeBytes(values ...interface{}) []byte {
+	var b []byte
+	for _, v := range values {
+		switch n := v.(type) {
+		case uint8:
+			b = append(b, n)
+		case uint16:
+			b = append(b, encodeUint16(n)...)
+		case uint32:
+			b = append(b, encodeUint32(n)...)
+		case []byte:
+			b = append(b, n...)
+		}
+	}
+
+	return b
+}
+
+func Test_EncodeNlMsg(t *testing.T) {
+	msg := encodeNlMsg(syscall.RTM_NEWLINK, syscall.NLM_F_REQUEST|syscall.NLM_F_ACK, 7, []byte{1, 2, 3, 4})
+
+	expected := nativeBytes(uint32(20), uint16(syscall.RTM_NEWLINK), uint16(syscall.NLM_F_REQUEST|syscall.NLM_F_ACK),
+		uint32(7), uint32(0), []byte{1, 2, 3, 4})
+	if !bytes.Equal(msg, expected) {
+		t.Fatalf("encodeNlMsg() failed: expected % x, returned % x", expected, msg)
+	}
+}
+
+func Test_EncodeRtAttr(t *testing.T) {
+	attr := encodeRtAttr(syscall.IFLA_IFNAME, encodeString("eth0"))
+
+	// 4 bytes header, 5 bytes value, 3 bytes padding
+	expected := nativeBytes(uint16(9), uint16(syscall.IFLA_IFNAME), []byte("eth0\x00\x00\x00\x00"))
+	if !bytes.Equal(attr, expected) {
+		t.Fatalf("encodeRtAttr() failed: expected % x, returned % x", expected, attr)
+	}
+
+	nested := encodeNestedRtAttr(syscall.IFLA_LINKINFO, attr, encodeRtAttr(iflaInfoData, encodeUint16(10)))
+	expected = nativeBytes(uint16(24), uint16(syscall.IFLA_LINKINFO), attr, uint16(6), uint16(iflaInfoData), uint16(10), uint16(0))
+	if !bytes.Equal(nested, expected) {
+		t.Fatalf("encodeNestedRtAttr() failed: expected % x, returned % x", expected, nested)
+	}
+}
+
+func Test_LinkAddMsgVeth(t *testing.T) {
+	data, err := linkAddMsg(LinkSpec{Name: "veth01", Kind: "veth", PeerName: "veth02", TxQueueLen: 500})
+	if err != nil {
+		t.Fatalf("linkAddMsg() failed: %s", err)
+	}
+
+	attrs, err := parseRouteAttrs(data[syscall.SizeofIfInfomsg:])
+	if err != nil {
+		t.Fatalf("parseRouteAttrs() failed: %s", err)
+	}
+
+	if len(attrs) != 3 || attrs[0].Attr.Type != syscall.IFLA_IFNAME || string(attrs[0].Value) != "veth01\x00" ||
+		attrs[1].Attr.Type != syscall.IFLA_TXQLEN || nativeUint32(attrs[1].Value) != 500 ||
+		attrs[2].Attr.Type != syscall.IFLA_LINKINFO {
+		t.Fatalf("linkAddMsg() failed: returned attributes %+v", attrs)
+	}
+
+	info, err := parseRouteAttrs(attrs[2].Value)
+	if err != nil || len(info) != 2 || string(info[0].Value) != "veth\x00" || info[1].Attr.Type != iflaInfoData {
+		t.Fatalf("linkAddMsg() failed: returned link info %+v: %v", info, err)
+	}
+
+	peerInfo, err := parseRouteAttrs(info[1].Value)
+	if err != nil || len(peerInfo) != 1 || peerInfo[0].Attr.Type != vethInfoPeer {
+		t.Fatalf("linkAddMsg() failed: returned veth info %+v: %v", peerInfo, err)
+	}
+
+	// peer is described by its own ifinfomsg followed by its attributes
+	peer := peerInfo[0].Value
+	if !bytes.Equal(peer[:syscall.SizeofIfInfomsg], make([]byte, syscall.SizeofIfInfomsg)) {
+		t.Fatalf("linkAddMsg() failed: returned peer header % x", peer[:syscall.SizeofIfInfomsg])
+	}
+
+	peerAttrs, err := parseRouteAttrs(peer[syscall.SizeofIfInfomsg:])
+	if err != nil || len(peerAttrs) != 2 || string(peerAttrs[0].Value) != "veth02\x00" || nativeUint32(peerAttrs[1].Value) != 500 {
+		t.Fatalf("linkAddMsg() failed: returned peer attributes %+v: %v", peerAttrs, err)
+	}
+}
+
+type linkAddMsgTest struct {
+	spec     LinkSpec
+	dataAttr []byte
+	fails    bool
+}
+
+var linkAddMsgTests = []linkAddMsgTest{
+	{LinkSpec{Name: "vlan01", Kind: "vlan", ParentIndex: 2, VlanId: 10}, encodeRtAttr(iflaVlanId, encodeUint16(10)), false},
+	{LinkSpec{Name: "mvlan01", Kind: "macvlan", ParentIndex: 2, MacVlanMode: "vepa"}, encodeRtAttr(iflaMacvlanMode, encodeUint32(2)), false},
+	{LinkSpec{Name: "mvtap01", Kind: "macvtap", ParentIndex: 2, MacVlanMode: "bridge"}, encodeRtAttr(iflaMacvlanMode, encodeUint32(4)), false},
+	{LinkSpec{Name: "mvlan01", Kind: "macvlan", ParentIndex: 2, MacVlanMode: "foo"}, nil, true},
+	{LinkSpec{Name: "foo01", Kind: "foo"}, nil, true},
+}
+
+func Test_LinkAddMsg(t *testing.T) {
+	for _, tt := range linkAddMsgTests {
+		data, err := linkAddMsg(tt.spec)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("linkAddMsg(%+v) expected to fail", tt.spec)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("linkAddMsg(%+v) failed: %s", tt.spec, err)
+			continue
+		}
+
+		expected := encodeIfInfomsg(&syscall.IfInfomsg{Family: syscall.AF_UNSPEC})
+		expected = append(expected, encodeRtAttr(syscall.IFLA_IFNAME, encodeString(tt.spec.Name))...)
+		expected = append(expected, encodeRtAttr(syscall.IFLA_LINK, encodeUint32(2))...)
+		expected = append(expected, encodeNestedRtAttr(syscall.IFLA_LINKINFO,
+			encodeRtAttr(iflaInfoKind, encodeString(tt.spec.Kind)),
+			encodeNestedRtAttr(iflaInfoData, tt.dataAttr))...)
+
+		if !bytes.Equal(data, expected) {
+			t.Errorf("linkAddMsg(%+v) failed: expected % x, returned % x", tt.spec, expected, data)
+		}
+	}
+}
+
+func Test_LinkMsg(t *testing.T) {
+	data := linkFlagsMsg(5, syscall.IFF_UP)
+	expected := nativeBytes(uint8(syscall.AF_UNSPEC), uint8(0), uint16(0), uint32(5), uint32(syscall.IFF_UP), uint32(syscall.IFF_UP))
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("linkFlagsMsg() failed: expected % x, returned % x", expected, data)
+	}
+
+	data = linkMsg(5, encodeRtAttr(syscall.IFLA_MTU, encodeUint32(1400)))
+	expected = nativeBytes(uint8(syscall.AF_UNSPEC), uint8(0), uint16(0), uint32(5), uint32(0), uint32(0),
+		uint16(8), uint16(syscall.IFLA_MTU), uint32(1400))
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("linkMsg() failed: expected % x, returned % x", expected, data)
+	}
+}
+
+func Test_AddrMsg(t *testing.T) {
+	ip, network, _ := net.ParseCIDR("10.0.0.1/24")
+
+	data, err := addrMsg(3, linkAddr(ip, network))
+	if err != nil {
+		t.Fatalf("addrMsg() failed: %s", err)
+	}
+
+	expected := nativeBytes(uint8(syscall.AF_INET), uint8(24), uint8(0), uint8(0), uint32(3),
+		uint16(8), uint16(syscall.IFA_LOCAL), []byte{10, 0, 0, 1},
+		uint16(8), uint16(syscall.IFA_ADDRESS), []byte{10, 0, 0, 1})
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("addrMsg() failed: expected % x, returned % x", expected, data)
+	}
+
+	ip, network, _ = net.ParseCIDR("fd00::1/64")
+	data, err = addrMsg(3, linkAddr(ip, network))
+	if err != nil {
+		t.Fatalf("addrMsg() failed: %s", err)
+	}
+
+	if data[0] != syscall.AF_INET6 || data[1] != 64 || len(data) != syscall.SizeofIfAddrmsg+2*(syscall.SizeofRtAttr+16) {
+		t.Fatalf("addrMsg() failed: returned % x", data)
+	}
+
+	if _, err := addrMsg(3, &net.IPNet{}); err == nil {
+		t.Fatalf("addrMsg() with empty address expected to fail")
+	}
+}
+
+func Test_RouteMsg(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("10.1.0.0/16")
+	data := routeMsg(&Route{Dst: dst, Gw: net.ParseIP("10.0.0.1"), Index: 3}, true)
+
+	expected := encodeRtMsg(&syscall.RtMsg{
+		Family:   syscall.AF_INET,
+		Dst_len:  16,
+		Table:    syscall.RT_TABLE_MAIN,
+		Protocol: syscall.RTPROT_BOOT,
+		Scope:    syscall.RT_SCOPE_UNIVERSE,
+		Type:     syscall.RTN_UNICAST,
+	})
+	expected = append(expected, nativeBytes(uint16(8), uint16(syscall.RTA_DST), []byte{10, 1, 0, 0},
+		uint16(8), uint16(syscall.RTA_GATEWAY), []byte{10, 0, 0, 1},
+		uint16(8), uint16(syscall.RTA_OIF), uint32(3))...)
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("routeMsg() failed: expected % x, returned % x", expected, data)
+	}
+
+	data = routeMsg(&Route{Gw: net.ParseIP("fd00::1")}, false)
+	if data[0] != syscall.AF_INET6 || data[6] != syscall.RT_SCOPE_NOWHERE || len(data) != syscall.SizeofRtMsg+syscall.SizeofRtAttr+16 {
+		t.Fatalf("routeMsg() failed: returned % x", data)
+	}
+}
+
+func Test_ParseRtnlReply(t *testing.T) {
+	link := encodeNlMsg(syscall.RTM_NEWLINK, syscall.NLM_F_MULTI, 9, encodeIfInfomsg(&syscall.IfInfomsg{Index: 2}))
+	other := encodeNlMsg(syscall.RTM_NEWLINK, syscall.NLM_F_MULTI, 8, encodeIfInfomsg(&syscall.IfInfomsg{Index: 3}))
+	done := encodeNlMsg(syscall.NLMSG_DONE, syscall.NLM_F_MULTI, 9, encodeUint32(0))
+
+	msgs, finished, err := parseRtnlReply(append(append([]byte(nil), link...), other...), 9)
+	if err != nil || finished || len(msgs) != 1 || msgs[0].Header.Type != syscall.RTM_NEWLINK {
+		t.Fatalf("parseRtnlReply() failed: returned %+v, %v, %v", msgs, finished, err)
+	}
+
+	msgs, finished, err = parseRtnlReply(done, 9)
+	if err != nil || !finished || len(msgs) != 0 {
+		t.Fatalf("parseRtnlReply() of NLMSG_DONE failed: returned %+v, %v, %v", msgs, finished, err)
+	}
+
+	// struct nlmsgerr: negative errno followed by the header of the failed request
+	errno := -int32(syscall.EEXIST)
+	nack := encodeNlMsg(syscall.NLMSG_ERROR, 0, 9, append(encodeUint32(uint32(errno)), make([]byte, syscall.NLMSG_HDRLEN)...))
+	if _, _, err := parseRtnlReply(nack, 9); err != syscall.EEXIST {
+		t.Fatalf("parseRtnlReply() failed: expected %v, returned %v", syscall.EEXIST, err)
+	}
+
+	ack := encodeNlMsg(syscall.NLMSG_ERROR, 0, 9, append(encodeUint32(0), make([]byte, syscall.NLMSG_HDRLEN)...))
+	if _, finished, err := parseRtnlReply(ack, 9); err != nil || !finished {
+		t.Fatalf("parseRtnlReply() of acknowledgement failed: returned %v, %v", finished, err)
+	}
+
+	if _, _, err := parseRtnlReply(link[:len(link)-4], 9); err == nil {
+		t.Fatalf("parseRtnlReply() of truncated message expected to fail")
+	}
+}
+
+func Test_RtnlExecuteContext(t *testing.T) {
+	tenustest.New(t)
+
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Fatalf("Could not find loopback link: %s", err)
+	}
+
+	// successful request without NLM_F_ACK is never replied to so it blocks until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := rtnlExecute(ctx, syscall.RTM_SETLINK, 0, linkFlagsMsg(lo.Index, syscall.IFF_UP)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("rtnlExecute() failed: expected %v, returned %v", context.DeadlineExceeded, err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 5*time.Second {
+		t.Fatalf("rtnlExecute() expected to return once the context was done, returned after %s", elapsed)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := (kernelBackend{}).LinkSetUp(cancelled, lo.Index); !errors.Is(err, context.Canceled) {
+		t.Fatalf("LinkSetUp() failed: expected %v, returned %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,609 @@
+package tenus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// link kinds Restore can recreate mapped to their creation priority.
+// Links with lower priority are created first so that parent devices exist
+// before the vlan, macvlan and macvtap links which depend on them.
+var snapshotKinds = map[string]int{
+	"dummy":   0,
+	"bridge":  0,
+	"veth":    0,
+	"vlan":    1,
+	"macvlan": 1,
+	"macvtap": 1,
+}
+
+// NetSnapshot describes network configuration of a network namespace: its links, their IP addresses
+// and routes in the main routing table. NetSnapshot can be serialized to JSON or YAML.
+// IPv6 link-local addresses and routes are left out as Linux kernel configures them automatically.
+type NetSnapshot struct {
+	// Named network namespace the snapshot was taken in. Empty for the current network namespace.
+	Ns string `json:"ns,omitempty" yaml:"ns,omitempty"`
+	// Network links
+	Links []SnapshotLink `json:"links" yaml:"links"`
+	// Routes in the main routing table
+	Routes []SnapshotRoute `json:"routes,omitempty" yaml:"routes,omitempty"`
+}
+
+// SnapshotLink describes a network link captured by Snapshot.
+type SnapshotLink struct {
+	// Link name
+	Name string `json:"name" yaml:"name"`
+	// Link kind i.e. bridge, veth, vlan. Empty for physical links.
+	Kind string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	// Name of the veth peer link. Empty if the peer lives in another network namespace.
+	Peer string `json:"peer,omitempty" yaml:"peer,omitempty"`
+	// Master device of vlan, macvlan and macvtap links. Empty if it lives in another network namespace.
+	Parent string `json:"parent,omitempty" yaml:"parent,omitempty"`
+	// VLAN tag of vlan links
+	VlanId uint16 `json:"vlanId,omitempty" yaml:"vlanId,omitempty"`
+	// Mode of macvlan and macvtap links
+	MacVlanMode string `json:"macvlanMode,omitempty" yaml:"macvlanMode,omitempty"`
+	// Maximum Transmission Unit
+	MTU int `json:"mtu,omitempty" yaml:"mtu,omitempty"`
+	// MAC address
+	HardwareAddr string `json:"address,omitempty" yaml:"address,omitempty"`
+	// TX queue length. It's only applied when the link is recreated.
+	TxQueueLen int `json:"txqueuelen,omitempty" yaml:"txqueuelen,omitempty"`
+	// Name of the bridge the link is attached to
+	Master string `json:"master,omitempty" yaml:"master,omitempty"`
+	// Link is up
+	Up bool `json:"up,omitempty" yaml:"up,omitempty"`
+	// IP addresses in CIDR notation
+	Addrs []string `json:"addrs,omitempty" yaml:"addrs,omitempty"`
+}
+
+// SnapshotRoute describes a route captured by Snapshot.
+type SnapshotRoute struct {
+	// Destination network in CIDR notation. Empty for default route.
+	Dst string `json:"dst,omitempty" yaml:"dst,omitempty"`
+	// Gateway IP address. Empty for directly connected routes.
+	Gw string `json:"gw,omitempty" yaml:"gw,omitempty"`
+	// Name of the link the route goes via
+	Dev string `json:"dev,omitempty" yaml:"dev,omitempty"`
+}
+
+// RestoreError is returned by Restore when some of the network configuration could not be reconstructed.
+// Restore carries on after a failure, so RestoreError lists all the failures.
+type RestoreError struct {
+	// Named network namespace of the snapshot. Empty for the current network namespace.
+	Ns string
+	// Errors of the configuration which could not be restored
+	Errs []error
+}
+
+// Error returns all the restore failures
+func (e *RestoreError) Error() string {
+	errs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		errs[i] = err.Error()
+	}
+
+	msg := "Could not restore network configuration"
+	if e.Ns != "" {
+		msg += " of netns " + e.Ns
+	}
+
+	return fmt.Sprintf("%s: %s", msg, strings.Join(errs, "; "))
+}
+
+// Snapshot returns description of network configuration of the named network namespace.
+// If ns is empty, the current network namespace is described.
+// It returns error if the network namespace does not exist or its configuration could not be retrieved.
+func Snapshot(ns string) (*NetSnapshot, error) {
+	s := &NetSnapshot{Ns: ns}
+	err := ExecInNamedNetNs(ns, func() error {
+		links, err := backend.LinkList(context.Background())
+		if err != nil {
+			return err
+		}
+
+		names := make(map[int]string, len(links))
+		for _, attrs := range links {
+			names[attrs.Index] = attrs.Name
+		}
+
+		for _, attrs := range links {
+			link, err := snapshotLink(attrs, names)
+			if err != nil {
+				return err
+			}
+			s.Links = append(s.Links, link)
+		}
+
+		routes, err := backend.RouteList(context.Background())
+		if err != nil {
+			return err
+		}
+
+		for _, route := range routes {
+			if route.Dst != nil && isIPv6LinkLocal(route.Dst.IP) {
+				continue
+			}
+			s.Routes = append(s.Routes, snapshotRoute(route, names))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// snapshotLink describes the link. names maps indices of the links to their names.
+func snapshotLink(attrs *LinkAttrs, names map[int]string) (SnapshotLink, error) {
+	link := SnapshotLink{
+		Name:        attrs.Name,
+		Kind:        attrs.Kind,
+		VlanId:      attrs.VlanId,
+		MacVlanMode: attrs.MacVlanMode,
+		MTU:         attrs.MTU,
+		TxQueueLen:  attrs.TxQueueLen,
+		Master:      names[attrs.MasterIndex],
+		Up:          attrs.Flags&net.FlagUp != 0,
+	}
+
+	if len(attrs.HardwareAddr) > 0 {
+		link.HardwareAddr = attrs.HardwareAddr.String()
+	}
+
+	if attrs.NetNsID < 0 {
+		switch attrs.Kind {
+		case "veth":
+			link.Peer = names[attrs.ParentIndex]
+		case "vlan", "macvlan", "macvtap":
+			link.Parent = names[attrs.ParentIndex]
+		}
+	}
+
+	addrs, err := backend.AddrList(context.Background(), attrs.Index)
+	if err != nil {
+		return link, newLinkError("list addresses", attrs.Name, err)
+	}
+
+	for _, addr := range addrs {
+		if isIPv6LinkLocal(addr.IP) {
+			continue
+		}
+		link.Addrs = append(link.Addrs, addr.String())
+	}
+
+	return link, nil
+}
+
+// snapshotRoute describes the route. names maps indices of the links to their names.
+func snapshotRoute(route *Route, names map[int]string) SnapshotRoute {
+	r := SnapshotRoute{Dev: names[route.Index]}
+	if route.Dst != nil {
+		r.Dst = route.Dst.String()
+	}
+	if route.Gw != nil {
+		r.Gw = route.Gw.String()
+	}
+
+	return r
+}
+
+// Restore reconstructs network configuration described by the snapshot in its network namespace.
+// Links missing from the network namespace are recreated, links created since the snapshot was taken
+// are deleted and link attributes, IP addresses and routes are reset to their captured values.
+//
+// Only dummy, bridge, veth, vlan, macvlan and macvtap links can be recreated or deleted. Restore carries on
+// when any part of the configuration can not be reconstructed and returns RestoreError listing all the failures.
+func Restore(s *NetSnapshot) error {
+	r := &restorer{ns: s.Ns}
+	if err := ExecInNamedNetNs(s.Ns, func() error { return r.restore(s) }); err != nil {
+		return err
+	}
+
+	if len(r.errs) > 0 {
+		return &RestoreError{Ns: s.Ns, Errs: r.errs}
+	}
+
+	return nil
+}
+
+// restorer collects failures of Restore
+type restorer struct {
+	ns   string
+	errs []error
+}
+
+// fail records failure of the operation on the link
+func (r *restorer) fail(op, link string, err error) {
+	r.errs = append(r.errs, newLinkNsError(op, link, r.ns, err))
+}
+
+// restore reconstructs the snapshot in the current network namespace
+func (r *restorer) restore(s *NetSnapshot) error {
+	if err := r.removeLinks(s); err != nil {
+		return err
+	}
+
+	if err := r.createLinks(s); err != nil {
+		return err
+	}
+
+	for _, link := range s.Links {
+		r.configureLink(link)
+	}
+
+	for _, link := range s.Links {
+		r.restoreAddrs(link)
+	}
+
+	for _, link := range s.Links {
+		r.setLinkState(link)
+	}
+
+	return r.restoreRoutes(s)
+}
+
+// removeLinks deletes the links which are not in the snapshot and the links whose kind
+// or parent device changed, so they can be recreated.
+func (r *restorer) removeLinks(s *NetSnapshot) error {
+	links, err := backend.LinkList(context.Background())
+	if err != nil {
+		return err
+	}
+
+	names := make(map[int]string, len(links))
+	for _, attrs := range links {
+		names[attrs.Index] = attrs.Name
+	}
+
+	want := make(map[string]SnapshotLink, len(s.Links))
+	for _, link := range s.Links {
+		want[link.Name] = link
+	}
+
+	for _, attrs := range links {
+		link, ok := want[attrs.Name]
+		if ok && sameLink(attrs, link, names) {
+			continue
+		}
+
+		if _, managed := snapshotKinds[attrs.Kind]; !managed {
+			if ok {
+				r.fail("restore", attrs.Name, fmt.Errorf("link kind %q can not be changed to %q", attrs.Kind, link.Kind))
+			} else {
+				r.fail("delete", attrs.Name, fmt.Errorf("unsupported link kind %q", attrs.Kind))
+			}
+			continue
+		}
+
+		// deleting veth or parent device might have already deleted the link
+		if _, err := backend.LinkByIndex(context.Background(), attrs.Index); err != nil {
+			continue
+		}
+
+		if err := backend.LinkDel(context.Background(), attrs.Index); err != nil && !errors.Is(err, ErrLinkNotFound) {
+			r.fail("delete", attrs.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// sameLink returns true if the link can be reconfigured to match the snapshot without recreating it
+func sameLink(attrs *LinkAttrs, link SnapshotLink, names map[int]string) bool {
+	if attrs.Kind != link.Kind {
+		return false
+	}
+
+	// links whose peer or parent lives in another network namespace are kept
+	if attrs.NetNsID >= 0 {
+		return true
+	}
+
+	switch attrs.Kind {
+	case "veth":
+		return link.Peer == "" || names[attrs.ParentIndex] == link.Peer
+	case "vlan":
+		return attrs.VlanId == link.VlanId && (link.Parent == "" || names[attrs.ParentIndex] == link.Parent)
+	case "macvlan", "macvtap":
+		return attrs.MacVlanMode == link.MacVlanMode && (link.Parent == "" || names[attrs.ParentIndex] == link.Parent)
+	}
+
+	return true
+}
+
+// createLinks recreates the links from the snapshot which are missing in the current network namespace
+func (r *restorer) createLinks(s *NetSnapshot) error {
+	links := make([]SnapshotLink, len(s.Links))
+	copy(links, s.Links)
+	sort.SliceStable(links, func(i, j int) bool {
+		return snapshotKinds[links[i].Kind] < snapshotKinds[links[j].Kind]
+	})
+
+	for _, link := range links {
+		if _, err := backend.LinkByName(context.Background(), link.Name); err == nil {
+			continue
+		} else if !errors.Is(err, ErrLinkNotFound) {
+			return err
+		}
+
+		spec, err := restoreSpec(link)
+		if err != nil {
+			r.fail("create", link.Name, err)
+			continue
+		}
+
+		if err := addLink(context.Background(), spec); err != nil {
+			r.fail("create", link.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// restoreSpec returns LinkSpec which recreates the link
+func restoreSpec(link SnapshotLink) (LinkSpec, error) {
+	spec := LinkSpec{Name: link.Name, Kind: link.Kind, TxQueueLen: link.TxQueueLen}
+
+	if _, ok := snapshotKinds[link.Kind]; !ok {
+		return spec, fmt.Errorf("unsupported link kind %q", link.Kind)
+	}
+
+	switch link.Kind {
+	case "veth":
+		if link.Peer == "" {
+			return spec, fmt.Errorf("veth peer lives in another network namespace")
+		}
+		spec.PeerName = link.Peer
+	case "vlan", "macvlan", "macvtap":
+		if link.Parent == "" {
+			return spec, fmt.Errorf("parent device lives in another network namespace")
+		}
+
+		parent, err := backend.LinkByName(context.Background(), link.Parent)
+		if err != nil {
+			return spec, err
+		}
+
+		spec.ParentIndex = parent.Index
+		spec.VlanId = link.VlanId
+		spec.MacVlanMode = link.MacVlanMode
+	}
+
+	return spec, nil
+}
+
+// configureLink resets MTU, MAC address and master device of the link to their captured values
+func (r *restorer) configureLink(link SnapshotLink) {
+	attrs, err := backend.LinkByName(context.Background(), link.Name)
+	if err != nil {
+		// missing links have already been reported by createLinks
+		return
+	}
+
+	if link.MTU != 0 && attrs.MTU != link.MTU {
+		if err := backend.LinkSetMTU(context.Background(), attrs.Index, link.MTU); err != nil {
+			r.fail("set mtu", link.Name, err)
+		}
+	}
+
+	if link.HardwareAddr != "" {
+		hwaddr, err := net.ParseMAC(link.HardwareAddr)
+		if err != nil {
+			r.fail("set address", link.Name, fmt.Errorf("%w: %s", ErrInvalidMAC, err))
+		} else if !bytes.Equal(attrs.HardwareAddr, hwaddr) {
+			if err := backend.LinkSetHardwareAddr(context.Background(), attrs.Index, hwaddr); err != nil {
+				r.fail("set address", link.Name, err)
+			}
+		}
+	}
+
+	masterIndex := 0
+	if link.Master != "" {
+		master, err := backend.LinkByName(context.Background(), link.Master)
+		if err != nil {
+			r.fail("set master", link.Name, err)
+			return
+		}
+		masterIndex = master.Index
+	}
+
+	if attrs.MasterIndex != masterIndex {
+		if err := backend.LinkSetMaster(context.Background(), attrs.Index, masterIndex); err != nil {
+			r.fail("set master", link.Name, err)
+		}
+	}
+}
+
+// restoreAddrs adds the captured IP addresses to the link and removes all the others
+func (r *restorer) restoreAddrs(link SnapshotLink) {
+	attrs, err := backend.LinkByName(context.Background(), link.Name)
+	if err != nil {
+		return
+	}
+
+	current, err := backend.AddrList(context.Background(), attrs.Index)
+	if err != nil {
+		r.fail("list addresses", link.Name, err)
+		return
+	}
+
+	var addrs []*net.IPNet
+	want := make(map[string]bool, len(link.Addrs))
+	for _, cidr := range link.Addrs {
+		ip, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			r.fail("add address", link.Name, err)
+			continue
+		}
+		addr := linkAddr(ip, network)
+		addrs = append(addrs, addr)
+		want[addr.String()] = true
+	}
+
+	for _, addr := range current {
+		if isIPv6LinkLocal(addr.IP) {
+			continue
+		}
+
+		if want[addr.String()] {
+			delete(want, addr.String())
+			continue
+		}
+
+		if err := backend.AddrDel(context.Background(), attrs.Index, addr); err != nil {
+			r.fail("delete address "+addr.String(), link.Name, err)
+		}
+	}
+
+	for _, addr := range addrs {
+		if !want[addr.String()] {
+			continue
+		}
+
+		if err := backend.AddrAdd(context.Background(), attrs.Index, addr); err != nil {
+			r.fail("add address "+addr.String(), link.Name, err)
+		}
+	}
+}
+
+// setLinkState brings the link up or down
+func (r *restorer) setLinkState(link SnapshotLink) {
+	attrs, err := backend.LinkByName(context.Background(), link.Name)
+	if err != nil {
+		return
+	}
+
+	up := attrs.Flags&net.FlagUp != 0
+	switch {
+	case link.Up && !up:
+		if err := backend.LinkSetUp(context.Background(), attrs.Index); err != nil {
+			r.fail("set up", link.Name, err)
+		}
+	case !link.Up && up:
+		if err := backend.LinkSetDown(context.Background(), attrs.Index); err != nil {
+			r.fail("set down", link.Name, err)
+		}
+	}
+}
+
+// restoreRoutes adds the captured routes and removes all the others from the main routing table
+func (r *restorer) restoreRoutes(s *NetSnapshot) error {
+	links, err := backend.LinkList(context.Background())
+	if err != nil {
+		return err
+	}
+
+	names := make(map[int]string, len(links))
+	indices := make(map[string]int, len(links))
+	for _, attrs := range links {
+		names[attrs.Index] = attrs.Name
+		indices[attrs.Name] = attrs.Index
+	}
+
+	current, err := backend.RouteList(context.Background())
+	if err != nil {
+		return err
+	}
+
+	want := make(map[SnapshotRoute]bool, len(s.Routes))
+	for _, route := range s.Routes {
+		want[route] = true
+	}
+
+	for _, route := range current {
+		if route.Dst != nil && isIPv6LinkLocal(route.Dst.IP) {
+			continue
+		}
+
+		sr := snapshotRoute(route, names)
+		if want[sr] {
+			delete(want, sr)
+			continue
+		}
+
+		if err := backend.RouteDel(context.Background(), route); err != nil {
+			r.fail("delete route "+sr.String(), sr.Dev, err)
+		}
+	}
+
+	// directly connected routes go first so that the gateways are reachable
+	routes := make([]SnapshotRoute, len(s.Routes))
+	copy(routes, s.Routes)
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routes[i].Gw == "" && routes[j].Gw != ""
+	})
+
+	for _, sr := range routes {
+		if !want[sr] {
+			continue
+		}
+
+		route, err := sr.route(indices)
+		if err == nil {
+			err = backend.RouteAdd(context.Background(), route)
+		}
+		if err != nil {
+			r.fail("add route "+sr.String(), sr.Dev, err)
+		}
+	}
+
+	return nil
+}
+
+// String returns the route in iproute2 notation
+func (sr SnapshotRoute) String() string {
+	route := "default"
+	if sr.Dst != "" {
+		route = sr.Dst
+	}
+	if sr.Gw != "" {
+		route += " via " + sr.Gw
+	}
+	if sr.Dev != "" {
+		route += " dev " + sr.Dev
+	}
+
+	return route
+}
+
+// route returns Route described by the snapshot. indices maps link names to their indices.
+func (sr SnapshotRoute) route(indices map[string]int) (*Route, error) {
+	route := &Route{}
+
+	if sr.Dst != "" {
+		_, dst, err := net.ParseCIDR(sr.Dst)
+		if err != nil {
+			return nil, err
+		}
+		route.Dst = dst
+	}
+
+	if sr.Gw != "" {
+		if route.Gw = net.ParseIP(sr.Gw); route.Gw == nil {
+			return nil, fmt.Errorf("Invalid gateway IP address: %s", sr.Gw)
+		}
+	}
+
+	if sr.Dev != "" {
+		index, ok := indices[sr.Dev]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrLinkNotFound, sr.Dev)
+		}
+		route.Index = index
+	}
+
+	return route, nil
+}
+
+// isIPv6LinkLocal returns true if ip is IPv6 link-local unicast address
+func isIPv6LinkLocal(ip net.IP) bool {
+	return ip.To4() == nil && ip.IsLinkLocalUnicast()
+}
@@ -0,0 +1,293 @@
+package tenus
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"syscall"
+	"testing"
+
+	"github.com/milosgajdos/tenus/tenustest"
+)
+
+// sortSnapshot orders links and routes of the snapshot so snapshots taken at different times can be compared
+func sortSnapshot(s *NetSnapshot) *NetSnapshot {
+	sort.Slice(s.Links, func(i, j int) bool { return s.Links[i].Name < s.Links[j].Name })
+	sort.Slice(s.Routes, func(i, j int) bool { return s.Routes[i].String() < s.Routes[j].String() })
+
+	return s
+}
+
+func Test_SnapshotRestore(t *testing.T) {
+	defer SetBackend(SetBackend(NewFakeBackend()))
+
+	dummy, err := NewLinkWithOptions("dummysnap01", LinkOptions{MTU: 1400, Flags: net.FlagUp})
+	if err != nil {
+		t.Fatalf("NewLinkWithOptions() failed: %s", err)
+	}
+
+	ip, network, _ := net.ParseCIDR("10.50.0.1/24")
+	if err := dummy.SetLinkIp(ip, network); err != nil {
+		t.Fatalf("SetLinkIp() failed: %s", err)
+	}
+
+	gw := net.ParseIP("10.50.0.254")
+	if err := dummy.SetLinkDefaultGw(&gw); err != nil {
+		t.Fatalf("SetLinkDefaultGw() failed: %s", err)
+	}
+
+	if _, err := NewVlanLinkWithOptions("dummysnap01", VlanOptions{Dev: "vlansnap01", Id: 10}); err != nil {
+		t.Fatalf("NewVlanLinkWithOptions() failed: %s", err)
+	}
+
+	if _, err := NewMacVtapLinkWithOptions("dummysnap01", MacVlanOptions{Dev: "mvtsnap01", Mode: "private"}); err != nil {
+		t.Fatalf("NewMacVtapLinkWithOptions() failed: %s", err)
+	}
+
+	br, err := NewBridgeWithName("brsnap01")
+	if err != nil {
+		t.Fatalf("NewBridgeWithName() failed: %s", err)
+	}
+
+	veth, err := NewVethPairWithOptions("vethsnap01", VethOptions{PeerName: "vethsnap02", TxQueueLen: 500})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	if err := br.AddSlaveIfc(veth.NetInterface()); err != nil {
+		t.Fatalf("AddSlaveIfc() failed: %s", err)
+	}
+
+	snap, err := Snapshot("")
+	if err != nil {
+		t.Fatalf("Snapshot() failed: %s", err)
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatalf("json.Marshal() failed: %s", err)
+	}
+
+	var decoded NetSnapshot
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal() failed: %s", err)
+	}
+
+	// break the configuration: deleting the parent device deletes vlan, macvtap and the default route
+	if err := DeleteLink("dummysnap01"); err != nil {
+		t.Fatalf("DeleteLink() failed: %s", err)
+	}
+
+	if err := DeleteLink("vethsnap02"); err != nil {
+		t.Fatalf("DeleteLink() failed: %s", err)
+	}
+
+	if err := br.SetLinkMTU(1300); err != nil {
+		t.Fatalf("SetLinkMTU() failed: %s", err)
+	}
+
+	ip, network, _ = net.ParseCIDR("10.60.0.1/24")
+	if err := br.SetLinkIp(ip, network); err != nil {
+		t.Fatalf("SetLinkIp() failed: %s", err)
+	}
+
+	if _, err := NewVethPairWithOptions("vethsnap03", VethOptions{PeerName: "vethsnap04"}); err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	// recreate the link with different kind
+	if _, err := NewVethPairWithOptions("vlansnap01", VethOptions{PeerName: "vethsnap05"}); err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	if err := Restore(&decoded); err != nil {
+		t.Fatalf("Restore() failed: %s", err)
+	}
+
+	restored, err := Snapshot("")
+	if err != nil {
+		t.Fatalf("Snapshot() failed: %s", err)
+	}
+
+	if !reflect.DeepEqual(sortSnapshot(snap), sortSnapshot(restored)) {
+		t.Fatalf("Restore() failed: expected\n%+v\nreturned\n%+v", snap, restored)
+	}
+
+	vlan, err := LinkAttrsByName("vlansnap01")
+	if err != nil || vlan.Kind != "vlan" || vlan.VlanId != 10 {
+		t.Fatalf("Restore() failed to recreate vlan link: %+v, %v", vlan, err)
+	}
+}
+
+func Test_SnapshotNs(t *testing.T) {
+	defer SetBackend(SetBackend(NewFakeBackend()))
+
+	if _, err := Snapshot("nssnap01"); !errors.Is(err, ErrNsNotFound) {
+		t.Fatalf("Snapshot() failed: expected %v, returned %v", ErrNsNotFound, err)
+	}
+
+	if err := NewNamedNetNs("nssnap01"); err != nil {
+		t.Fatalf("NewNamedNetNs() failed: %s", err)
+	}
+
+	snap, err := Snapshot("nssnap01")
+	if err != nil {
+		t.Fatalf("Snapshot() failed: %s", err)
+	}
+
+	if snap.Ns != "nssnap01" || len(snap.Links) != 1 || snap.Links[0].Name != "lo" {
+		t.Fatalf("Snapshot() failed: returned %+v", snap)
+	}
+
+	snap.Links = append(snap.Links, SnapshotLink{Name: "brsnap01", Kind: "bridge", Up: true, Addrs: []string{"10.50.0.1/24"}})
+	if err := Restore(snap); err != nil {
+		t.Fatalf("Restore() failed: %s", err)
+	}
+
+	err = ExecInNamedNetNs("nssnap01", func() error {
+		addrs, err := LinkAddrsByName("brsnap01")
+		if err != nil {
+			return err
+		}
+
+		if len(addrs) != 1 || addrs[0].String() != "10.50.0.1/24" {
+			t.Errorf("Restore() failed: returned addresses %v", addrs)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Restore() failed: %s", err)
+	}
+
+	if _, err := LinkAttrsByName("brsnap01"); err == nil {
+		t.Fatalf("Restore() created link in the wrong network namespace")
+	}
+}
+
+func Test_RestoreErrors(t *testing.T) {
+	defer SetBackend(SetBackend(NewFakeBackend()))
+
+	snap := &NetSnapshot{
+		Links: []SnapshotLink{
+			{Name: "lo", Up: true},
+			{Name: "tunsnap01", Kind: "tun"},
+			{Name: "vethsnap01", Kind: "veth"},
+			{Name: "vlansnap01", Kind: "vlan", Parent: "ethsnap01", VlanId: 10},
+			{Name: "brsnap01", Kind: "bridge", MTU: 1400},
+		},
+		Routes: []SnapshotRoute{
+			{Dst: "10.70.0.0/16", Dev: "tunsnap01"},
+		},
+	}
+
+	err := Restore(snap)
+
+	var restoreErr *RestoreError
+	if !errors.As(err, &restoreErr) {
+		t.Fatalf("Restore() failed: expected RestoreError, returned %v", err)
+	}
+
+	if len(restoreErr.Errs) != 4 {
+		t.Fatalf("Restore() failed: expected 4 errors, returned %s", err)
+	}
+
+	if !errors.Is(restoreErr.Errs[2], ErrLinkNotFound) || !errors.Is(restoreErr.Errs[3], ErrLinkNotFound) {
+		t.Fatalf("Restore() failed: expected %v, returned %s", ErrLinkNotFound, err)
+	}
+
+	attrs, err := LinkAttrsByName("brsnap01")
+	if err != nil || attrs.MTU != 1400 {
+		t.Fatalf("Restore() failed to restore brsnap01: %+v, %v", attrs, err)
+	}
+}
+
+func Test_ParseLinkInfoData(t *testing.T) {
+	specs := []LinkSpec{
+		{Name: "vlan01", Kind: "vlan", ParentIndex: 1, VlanId: 100},
+		{Name: "macvlan01", Kind: "macvlan", ParentIndex: 1, MacVlanMode: "vepa"},
+		{Name: "macvtap01", Kind: "macvtap", ParentIndex: 1, MacVlanMode: "bridge"},
+	}
+
+	for _, spec := range specs {
+		data, err := linkAddMsg(spec)
+		if err != nil {
+			t.Fatalf("linkAddMsg(%+v) failed: %s", spec, err)
+		}
+
+		attrs, err := parseLinkAttrs(&syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWLINK}, Data: data})
+		if err != nil {
+			t.Fatalf("parseLinkAttrs(%+v) failed: %s", spec, err)
+		}
+
+		if attrs.Kind != spec.Kind || attrs.VlanId != spec.VlanId || attrs.MacVlanMode != spec.MacVlanMode {
+			t.Fatalf("parseLinkAttrs(%+v) failed: returned %+v", spec, attrs)
+		}
+	}
+}
+
+func Test_SnapshotRestoreKernel(t *testing.T) {
+	ns := tenustest.New(t)
+
+	br, err := NewBridgeWithName("brsnap01")
+	if err != nil {
+		t.Fatalf("NewBridgeWithName() failed: %s", err)
+	}
+
+	veth, err := NewVethPairWithOptions("vethsnap01", VethOptions{PeerName: "vethsnap02"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	if err := br.AddSlaveIfc(veth.NetInterface()); err != nil {
+		t.Fatalf("AddSlaveIfc() failed: %s", err)
+	}
+
+	ip, network, _ := net.ParseCIDR("10.50.0.1/24")
+	if err := br.SetLinkIp(ip, network); err != nil {
+		t.Fatalf("SetLinkIp() failed: %s", err)
+	}
+
+	if err := br.SetLinkUp(); err != nil {
+		t.Fatalf("SetLinkUp() failed: %s", err)
+	}
+
+	gw := net.ParseIP("10.50.0.254")
+	if err := br.SetLinkDefaultGw(&gw); err != nil {
+		t.Fatalf("SetLinkDefaultGw() failed: %s", err)
+	}
+
+	snap, err := Snapshot("")
+	if err != nil {
+		t.Fatalf("Snapshot() failed: %s", err)
+	}
+
+	if err := br.DeleteLink(); err != nil {
+		t.Fatalf("DeleteLink() failed: %s", err)
+	}
+
+	if _, err := NewVethPairWithOptions("vethsnap03", VethOptions{PeerName: "vethsnap04"}); err != nil {
+		t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+	}
+
+	if err := Restore(snap); err != nil {
+		t.Fatalf("Restore() failed: %s", err)
+	}
+
+	ns.AssertNoLink("vethsnap03")
+	ns.AssertLinkKind("brsnap01", "bridge")
+	ns.AssertLinkUp("brsnap01")
+	ns.AssertLinkMaster("vethsnap01", "brsnap01")
+	ns.AssertLinkAddrs("brsnap01", "10.50.0.1/24")
+
+	restored, err := Snapshot("")
+	if err != nil {
+		t.Fatalf("Snapshot() failed: %s", err)
+	}
+
+	if !reflect.DeepEqual(sortSnapshot(snap), sortSnapshot(restored)) {
+		t.Fatalf("Restore() failed: expected\n%+v\nreturned\n%+v", snap, restored)
+	}
+}
@@ -0,0 +1,189 @@
+package tenus
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LinkStats contains 64-bit network link counters as reported by Linux kernel in IFLA_STATS64.
+type LinkStats struct {
+	// Time when the counters were sampled
+	Time time.Time
+	// Received packets
+	RxPackets uint64
+	// Transmitted packets
+	TxPackets uint64
+	// Received bytes
+	RxBytes uint64
+	// Transmitted bytes
+	TxBytes uint64
+	// Bad packets received
+	RxErrors uint64
+	// Packet transmit problems
+	TxErrors uint64
+	// Received packets dropped
+	RxDropped uint64
+	// Transmitted packets dropped
+	TxDropped uint64
+	// Multicast packets received
+	Multicast uint64
+	// Collisions
+	Collisions uint64
+	// Detailed receive errors
+	RxLengthErrors uint64
+	RxOverErrors   uint64
+	RxCrcErrors    uint64
+	RxFrameErrors  uint64
+	RxFifoErrors   uint64
+	RxMissedErrors uint64
+	// Detailed transmit errors
+	TxAbortedErrors   uint64
+	TxCarrierErrors   uint64
+	TxFifoErrors      uint64
+	TxHeartbeatErrors uint64
+	TxWindowErrors    uint64
+}
+
+// LinkStatsRate contains per second rates of network link counters computed from two LinkStats samples.
+type LinkStatsRate struct {
+	// Time elapsed between the two samples
+	Interval time.Duration
+	// Received packets per second
+	RxPackets float64
+	// Transmitted packets per second
+	TxPackets float64
+	// Received bytes per second
+	RxBytes float64
+	// Transmitted bytes per second
+	TxBytes float64
+	// Receive errors per second
+	RxErrors float64
+	// Transmit errors per second
+	TxErrors float64
+	// Received packets dropped per second
+	RxDropped float64
+	// Transmitted packets dropped per second
+	TxDropped float64
+	// Multicast packets received per second
+	Multicast float64
+}
+
+// Stats returns link's current 64-bit counters.
+// It returns error if the link no longer exists in the current network namespace.
+func (l *Link) Stats() (*LinkStats, error) {
+	stats, err := LinkStatsByIndex(l.ifc.Index)
+	if err != nil {
+		return nil, newLinkError("stats", l.ifc.Name, err)
+	}
+
+	return stats, nil
+}
+
+// LinkStatsByIndex returns 64-bit counters of the network link with the given index.
+// It returns error if the link could not be found or if the kernel did not report link statistics.
+func LinkStatsByIndex(index int) (*LinkStats, error) {
+	attrs, err := LinkAttrsByIndex(index)
+	if err != nil {
+		return nil, err
+	}
+
+	if attrs.Stats == nil {
+		return nil, fmt.Errorf("No statistics reported for link %s", attrs.Name)
+	}
+
+	return attrs.Stats, nil
+}
+
+// LinkStatsInNs returns 64-bit counters of the network link of the given name
+// in the network namespace specified by PID.
+// It returns error if the link could not be found or if the kernel did not report link statistics.
+func LinkStatsInNs(nspid int, name string) (*LinkStats, error) {
+	var attrs *LinkAttrs
+	if err := execInNetNs(nspid, func() error {
+		var err error
+		attrs, err = LinkAttrsByName(name)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	if attrs.Stats == nil {
+		return nil, fmt.Errorf("No statistics reported for link %s", attrs.Name)
+	}
+
+	return attrs.Stats, nil
+}
+
+// StatsDelta computes per second rates of network link counters between two samples.
+// Counters which decreased between the samples are assumed to have been reset and
+// their current value is used as the delta.
+// It returns error if cur was not sampled after prev.
+func StatsDelta(prev, cur *LinkStats) (*LinkStatsRate, error) {
+	if prev == nil || cur == nil {
+		return nil, errors.New("Both statistics samples must be specified")
+	}
+
+	interval := cur.Time.Sub(prev.Time)
+	if interval <= 0 {
+		return nil, fmt.Errorf("Statistics samples not in chronological order: %s", interval)
+	}
+
+	secs := interval.Seconds()
+	rate := func(p, c uint64) float64 {
+		if c < p {
+			return float64(c) / secs
+		}
+		return float64(c-p) / secs
+	}
+
+	return &LinkStatsRate{
+		Interval:  interval,
+		RxPackets: rate(prev.RxPackets, cur.RxPackets),
+		TxPackets: rate(prev.TxPackets, cur.TxPackets),
+		RxBytes:   rate(prev.RxBytes, cur.RxBytes),
+		TxBytes:   rate(prev.TxBytes, cur.TxBytes),
+		RxErrors:  rate(prev.RxErrors, cur.RxErrors),
+		TxErrors:  rate(prev.TxErrors, cur.TxErrors),
+		RxDropped: rate(prev.RxDropped, cur.RxDropped),
+		TxDropped: rate(prev.TxDropped, cur.TxDropped),
+		Multicast: rate(prev.Multicast, cur.Multicast),
+	}, nil
+}
+
+// parseLinkStats64 decodes struct rtnl_link_stats64.
+// Counters missing from older kernels are left zeroed.
+func parseLinkStats64(b []byte) *LinkStats {
+	counters := make([]uint64, 21)
+	for i := range counters {
+		if len(b) < (i+1)*8 {
+			break
+		}
+		counters[i] = nativeEndian.Uint64(b[i*8:])
+	}
+
+	return &LinkStats{
+		Time:              time.Now(),
+		RxPackets:         counters[0],
+		TxPackets:         counters[1],
+		RxBytes:           counters[2],
+		TxBytes:           counters[3],
+		RxErrors:          counters[4],
+		TxErrors:          counters[5],
+		RxDropped:         counters[6],
+		TxDropped:         counters[7],
+		Multicast:         counters[8],
+		Collisions:        counters[9],
+		RxLengthErrors:    counters[10],
+		RxOverErrors:      counters[11],
+		RxCrcErrors:       counters[12],
+		RxFrameErrors:     counters[13],
+		RxFifoErrors:      counters[14],
+		RxMissedErrors:    counters[15],
+		TxAbortedErrors:   counters[16],
+		TxCarrierErrors:   counters[17],
+		TxFifoErrors:      counters[18],
+		TxHeartbeatErrors: counters[19],
+		TxWindowErrors:    counters[20],
+	}
+}
@@ -0,0 +1,107 @@
+package tenus
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/milosgajdos/tenus/tenustest"
+)
+
+type statsDeltaTest struct {
+	prev     *LinkStats
+	cur      *LinkStats
+	expected *LinkStatsRate
+}
+
+var statsTime = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+var statsDeltaTests = []statsDeltaTest{
+	{&LinkStats{Time: statsTime, RxBytes: 1000, TxBytes: 500, RxPackets: 10},
+		&LinkStats{Time: statsTime.Add(2 * time.Second), RxBytes: 3000, TxBytes: 1500, RxPackets: 30},
+		&LinkStatsRate{Interval: 2 * time.Second, RxBytes: 1000, TxBytes: 500, RxPackets: 10}},
+	// counter reset
+	{&LinkStats{Time: statsTime, RxBytes: 5000, Multicast: 4},
+		&LinkStats{Time: statsTime.Add(time.Second), RxBytes: 100, Multicast: 6},
+		&LinkStatsRate{Interval: time.Second, RxBytes: 100, Multicast: 2}},
+	{&LinkStats{Time: statsTime.Add(time.Second)},
+		&LinkStats{Time: statsTime},
+		nil},
+	{nil, &LinkStats{Time: statsTime}, nil},
+}
+
+func Test_StatsDelta(t *testing.T) {
+	for _, tt := range statsDeltaTests {
+		rate, err := StatsDelta(tt.prev, tt.cur)
+		if tt.expected == nil {
+			if err == nil {
+				t.Errorf("StatsDelta(%v, %v) expected to fail, returned: %+v", tt.prev, tt.cur, rate)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("StatsDelta(%v, %v) failed: %s", tt.prev, tt.cur, err)
+			continue
+		}
+
+		if *rate != *tt.expected {
+			t.Errorf("StatsDelta(%v, %v): expected %+v, returned %+v", tt.prev, tt.cur, *tt.expected, *rate)
+		}
+	}
+}
+
+func Test_ParseLinkStats64(t *testing.T) {
+	b := make([]byte, 8*23)
+	for i := 0; i < 23; i++ {
+		nativeEndian.PutUint64(b[i*8:], uint64(i+1))
+	}
+
+	stats := parseLinkStats64(b)
+	if stats.RxPackets != 1 || stats.TxBytes != 4 || stats.Multicast != 9 || stats.TxWindowErrors != 21 {
+		t.Fatalf("parseLinkStats64() failed: returned %+v", *stats)
+	}
+
+	// older kernels report shorter structure
+	stats = parseLinkStats64(b[:8*4])
+	if stats.TxBytes != 4 || stats.RxErrors != 0 {
+		t.Fatalf("parseLinkStats64() failed on short input: returned %+v", *stats)
+	}
+}
+
+func Test_VethPairStats(t *testing.T) {
+	tenustest.New(t)
+
+	unsharePath, err := exec.LookPath("unshare")
+	if err != nil {
+		t.Skipf("Stats test requries external command: %v", err)
+	}
+
+	veth, err := NewVethPairWithOptions("vethstats01", VethOptions{PeerName: "vethstats02"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptions(%s) failed to run: %s", "vethstats01", err)
+	}
+
+	stats, err := veth.Stats()
+	if err != nil {
+		t.Fatalf("Stats() failed: %s", err)
+	}
+
+	if stats.Time.IsZero() {
+		t.Fatalf("Stats() failed: sample time not set")
+	}
+
+	nsCmd := exec.Command(unsharePath, "-n", "sleep", "10")
+	if err := nsCmd.Start(); err != nil {
+		t.Skipf("Stats test requries network namespace: %v", err)
+	}
+	defer nsCmd.Process.Kill()
+	time.Sleep(50 * time.Millisecond)
+
+	if err := veth.SetPeerLinkNsPid(nsCmd.Process.Pid); err != nil {
+		t.Fatalf("SetPeerLinkNsPid(%d) failed: %s", nsCmd.Process.Pid, err)
+	}
+
+	if _, err := veth.PeerStats(); err != nil {
+		t.Fatalf("PeerStats() failed: %s", err)
+	}
+}
@@ -0,0 +1,204 @@
+package tenus
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Traffic control handles of the link's attachment points
+const (
+	// HandleRoot is the parent of the qdisc attached to the link's egress root
+	HandleRoot uint32 = 0xffffffff
+	// HandleIngress is the parent of ingress and clsact qdiscs
+	HandleIngress uint32 = 0xfffffff1
+	// HandleMinIngress is the parent of filters attached to ingress hook of clsact qdisc
+	HandleMinIngress uint32 = 0xfffffff2
+	// HandleMinEgress is the parent of filters attached to egress hook of clsact qdisc
+	HandleMinEgress uint32 = 0xfffffff3
+)
+
+// traffic control attributes which are not exported by syscall package
+const (
+	tcaKind    = 1
+	tcaOptions = 2
+	tcaStats   = 3
+)
+
+// sizeofTcmsg is the size of tcmsg structure which prefixes traffic control netlink messages
+const sizeofTcmsg = 20
+
+// psched ticks used by the kernel to express time in traffic control structures are 64 nanoseconds long
+const nsPerTick = 64
+
+// MakeHandle returns traffic control handle made of the major and minor number.
+// Qdisc handles have minor number 0, class handles share the major number with their qdisc.
+func MakeHandle(major, minor uint16) uint32 {
+	return uint32(major)<<16 | uint32(minor)
+}
+
+// HandleString returns the handle in the format used by tc, i.e. 1:a, 1: or root.
+// Major and minor numbers are hexadecimal.
+func HandleString(handle uint32) string {
+	switch handle {
+	case HandleRoot:
+		return "root"
+	case HandleIngress:
+		return "ingress"
+	case 0:
+		return "none"
+	}
+
+	if handle&0xffff == 0 {
+		return fmt.Sprintf("%x:", handle>>16)
+	}
+
+	return fmt.Sprintf("%x:%x", handle>>16, handle&0xffff)
+}
+
+// ParseHandle parses traffic control handle in the format returned by HandleString.
+func ParseHandle(s string) (uint32, error) {
+	switch s {
+	case "root":
+		return HandleRoot, nil
+	case "ingress":
+		return HandleIngress, nil
+	case "none":
+		return 0, nil
+	}
+
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("Invalid traffic control handle: %s", s)
+	}
+
+	major, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid traffic control handle: %s", s)
+	}
+
+	var minor uint64
+	if parts[1] != "" {
+		if minor, err = strconv.ParseUint(parts[1], 16, 16); err != nil {
+			return 0, fmt.Errorf("Invalid traffic control handle: %s", s)
+		}
+	}
+
+	return MakeHandle(uint16(major), uint16(minor)), nil
+}
+
+// tcDump returns traffic control objects of the given type attached to the link with the given index
+func tcDump(ctx context.Context, msgType uint16, index int, parent uint32) ([]*tcObject, error) {
+	msgs, err := rtnlDump(ctx, msgType, encodeTcmsg(index, 0, parent, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	var objs []*tcObject
+	for i := range msgs {
+		obj, err := parseTcObject(&msgs[i])
+		if err != nil {
+			return nil, err
+		}
+
+		// some kernels dump objects of all links
+		if obj.index == index {
+			objs = append(objs, obj)
+		}
+	}
+
+	return objs, nil
+}
+
+// tcObject is decoded traffic control rtnetlink message
+type tcObject struct {
+	index  int
+	handle uint32
+	parent uint32
+	info   uint32
+	kind   string
+	// value of TCA_OPTIONS attribute
+	options []byte
+	// value of TCA_STATS attribute
+	stats []byte
+}
+
+// encodeTcmsg encodes tcmsg family header of traffic control messages
+func encodeTcmsg(index int, handle, parent, info uint32) []byte {
+	b := make([]byte, sizeofTcmsg)
+	b[0] = syscall.AF_UNSPEC
+	nativeEndian.PutUint32(b[4:8], uint32(int32(index)))
+	nativeEndian.PutUint32(b[8:12], handle)
+	nativeEndian.PutUint32(b[12:16], parent)
+	nativeEndian.PutUint32(b[16:20], info)
+
+	return b
+}
+
+// parseTcObject decodes traffic control rtnetlink message
+func parseTcObject(m *syscall.NetlinkMessage) (*tcObject, error) {
+	if len(m.Data) < sizeofTcmsg {
+		return nil, fmt.Errorf("Traffic control message too short: %d", len(m.Data))
+	}
+
+	obj := &tcObject{
+		index:  int(int32(nativeEndian.Uint32(m.Data[4:8]))),
+		handle: nativeEndian.Uint32(m.Data[8:12]),
+		parent: nativeEndian.Uint32(m.Data[12:16]),
+		info:   nativeEndian.Uint32(m.Data[16:20]),
+	}
+
+	rtas, err := parseRouteAttrs(m.Data[sizeofTcmsg:])
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse traffic control attributes: %s", err)
+	}
+
+	for _, rta := range rtas {
+		switch rta.Attr.Type {
+		case tcaKind:
+			obj.kind = strings.TrimRight(string(rta.Value), "\x00")
+		case tcaOptions:
+			obj.options = rta.Value
+		case tcaStats:
+			obj.stats = rta.Value
+		}
+	}
+
+	return obj, nil
+}
+
+// tcAttrs decodes nested traffic control attributes into a map keyed by attribute type
+func tcAttrs(b []byte) (map[uint16][]byte, error) {
+	rtas, err := parseRouteAttrs(b)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make(map[uint16][]byte, len(rtas))
+	for _, rta := range rtas {
+		// strip NLA_F_NESTED and NLA_F_NET_BYTEORDER flags
+		attrs[rta.Attr.Type&0x3fff] = rta.Value
+	}
+
+	return attrs, nil
+}
+
+// encodeUint64 encodes uint64 attribute value in host byte order
+func encodeUint64(n uint64) []byte {
+	b := make([]byte, 8)
+	nativeEndian.PutUint64(b, n)
+
+	return b
+}
+
+// encodeUint32s encodes structure made of uint32 fields in host byte order
+func encodeUint32s(values ...uint32) []byte {
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		nativeEndian.PutUint32(b[4*i:], v)
+	}
+
+	return b
+}
@@ -0,0 +1,469 @@
+// Package tenustest provides isolated network namespaces for integration tests of code managing Linux network links.
+//
+// New gives each test a fresh network namespace which the test's goroutine enters on a locked OS thread.
+// Links created by the test live only in that namespace, so tests can use fixed link names and run in parallel
+// without colliding with each other or with the host. The namespace is destroyed when the test finishes.
+//
+// Only the test's own goroutine runs in the namespace. Goroutines started by the test and external commands
+// run in the host's network namespace unless they enter the namespace via its Path.
+// tenustest does not depend on tenus so it can be used by tenus's own test suite.
+package tenustest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/milosgajdos/tenus/internal/netns"
+)
+
+// link attributes which are not exported by syscall package
+const (
+	iflaInfoKind = 1
+)
+
+// probeLinkName is the name of the link RequireLinkKind creates
+const probeLinkName = "tenustestprobe"
+
+// NetNs is a network namespace of a single test.
+type NetNs struct {
+	t testing.TB
+	// file descriptor of the network namespace the thread was in before the test
+	origNs int
+	// ID of the locked OS thread running in the namespace
+	tid int
+}
+
+// Link describes network link as reported by Linux kernel.
+type Link struct {
+	// Link index
+	Index int
+	// Link name
+	Name string
+	// Link kind, e.g. veth or bridge. Empty for links without kind such as loopback.
+	Kind string
+	// Maximum Transmission Unit
+	MTU int
+	// MAC address
+	HardwareAddr net.HardwareAddr
+	// Index of the master link, 0 if the link has no master
+	MasterIndex int
+	// true if the link is administratively up
+	Up bool
+}
+
+// New creates fresh network namespace and switches the calling goroutine to it on a locked OS thread.
+// Teardown is registered with t.Cleanup: the thread is switched back to its original network namespace
+// and unlocked, which destroys the test's namespace together with all its links.
+// New must be called from the test's goroutine. It skips the test if the namespace can not be created,
+// e.g. when the test is not run by root. Unprivileged users can run the tests in a user namespace
+// by calling tenus.ReexecInUserNs from TestMain.
+func New(t testing.TB) *NetNs {
+	t.Helper()
+
+	runtime.LockOSThread()
+
+	origNs, err := syscall.Open(threadNsPath(os.Getpid(), syscall.Gettid()), syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		runtime.UnlockOSThread()
+		t.Skipf("Could not open current network namespace: %s", err)
+	}
+
+	if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+		syscall.Close(origNs)
+		runtime.UnlockOSThread()
+		t.Skipf("Could not create network namespace: %s", err)
+	}
+
+	ns := &NetNs{
+		t:      t,
+		origNs: origNs,
+		tid:    syscall.Gettid(),
+	}
+	t.Cleanup(ns.close)
+
+	return ns
+}
+
+// close switches the thread back to the original network namespace and unlocks it.
+// If the thread can't be switched back it stays locked so that it's discarded when the goroutine exits.
+func (ns *NetNs) close() {
+	defer syscall.Close(ns.origNs)
+
+	if err := netns.Set(ns.origNs); err != nil {
+		ns.t.Errorf("Unable to restore the original network namespace: %s", err)
+		return
+	}
+
+	runtime.UnlockOSThread()
+}
+
+// Path returns filesystem path of the network namespace.
+// It can be used to move links to the namespace or to enter it from other goroutines while the test runs.
+func (ns *NetNs) Path() string {
+	return threadNsPath(os.Getpid(), ns.tid)
+}
+
+// Links returns all network links in the namespace.
+func (ns *NetNs) Links() []*Link {
+	ns.t.Helper()
+
+	links, err := linkList()
+	if err != nil {
+		ns.t.Fatalf("Could not list network links: %s", err)
+	}
+
+	return links
+}
+
+// Link returns network link of the given name. It fails the test if the link does not exist.
+func (ns *NetNs) Link(name string) *Link {
+	ns.t.Helper()
+
+	link := ns.findLink(name)
+	if link == nil {
+		ns.t.Fatalf("Link %s does not exist", name)
+	}
+
+	return link
+}
+
+// findLink returns network link of the given name or nil if it does not exist
+func (ns *NetNs) findLink(name string) *Link {
+	ns.t.Helper()
+
+	for _, link := range ns.Links() {
+		if link.Name == name {
+			return link
+		}
+	}
+
+	return nil
+}
+
+// RequireLinkKind skips the test if Linux kernel does not support links of the given kind,
+// e.g. when the kernel module implementing it is not available.
+// The kind is probed by creating a link in the namespace which is deleted right away.
+func (ns *NetNs) RequireLinkKind(kind string) {
+	ns.t.Helper()
+
+	err := linkAdd(probeLinkName, kind)
+	if err == syscall.EOPNOTSUPP {
+		ns.t.Skipf("Kernel does not support %s links", kind)
+	}
+
+	// other errors mean the kind is supported, but the link needs more attributes, e.g. its parent link
+	if err != nil {
+		return
+	}
+
+	if err := linkDel(probeLinkName); err != nil {
+		ns.t.Fatalf("Could not delete link %s: %s", probeLinkName, err)
+	}
+}
+
+// AssertLinkExists fails the test if the link does not exist.
+func (ns *NetNs) AssertLinkExists(name string) {
+	ns.t.Helper()
+
+	if ns.findLink(name) == nil {
+		ns.t.Errorf("Link %s does not exist", name)
+	}
+}
+
+// AssertNoLink fails the test if the link exists.
+func (ns *NetNs) AssertNoLink(name string) {
+	ns.t.Helper()
+
+	if ns.findLink(name) != nil {
+		ns.t.Errorf("Link %s exists", name)
+	}
+}
+
+// AssertLinkKind fails the test if the link is not of the given kind.
+func (ns *NetNs) AssertLinkKind(name, kind string) {
+	ns.t.Helper()
+
+	if link := ns.Link(name); link.Kind != kind {
+		ns.t.Errorf("Link %s kind: expected %q, got %q", name, kind, link.Kind)
+	}
+}
+
+// AssertLinkMTU fails the test if the link's MTU differs.
+func (ns *NetNs) AssertLinkMTU(name string, mtu int) {
+	ns.t.Helper()
+
+	if link := ns.Link(name); link.MTU != mtu {
+		ns.t.Errorf("Link %s MTU: expected %d, got %d", name, mtu, link.MTU)
+	}
+}
+
+// AssertLinkMAC fails the test if the link's MAC address differs.
+func (ns *NetNs) AssertLinkMAC(name, macaddr string) {
+	ns.t.Helper()
+
+	hwaddr, err := net.ParseMAC(macaddr)
+	if err != nil {
+		ns.t.Fatalf("Invalid MAC address %s: %s", macaddr, err)
+	}
+
+	if link := ns.Link(name); !bytes.Equal(link.HardwareAddr, hwaddr) {
+		ns.t.Errorf("Link %s MAC address: expected %s, got %s", name, hwaddr, link.HardwareAddr)
+	}
+}
+
+// AssertLinkUp fails the test if the link is not administratively up.
+func (ns *NetNs) AssertLinkUp(name string) {
+	ns.t.Helper()
+
+	if link := ns.Link(name); !link.Up {
+		ns.t.Errorf("Link %s is down", name)
+	}
+}
+
+// AssertLinkMaster fails the test if the link is not enslaved to master.
+// Empty master asserts that the link has no master.
+func (ns *NetNs) AssertLinkMaster(name, master string) {
+	ns.t.Helper()
+
+	link := ns.Link(name)
+	if master == "" {
+		if link.MasterIndex != 0 {
+			ns.t.Errorf("Link %s master: expected none, got index %d", name, link.MasterIndex)
+		}
+		return
+	}
+
+	if masterLink := ns.Link(master); link.MasterIndex != masterLink.Index {
+		ns.t.Errorf("Link %s master: expected %s (index %d), got index %d", name, master, masterLink.Index, link.MasterIndex)
+	}
+}
+
+// AssertLinkAddrs fails the test if any of the addresses in CIDR notation is not assigned to the link.
+// Addresses assigned to the link by the kernel, like IPv6 link-local ones, are ignored.
+func (ns *NetNs) AssertLinkAddrs(name string, cidrs ...string) {
+	ns.t.Helper()
+
+	ifc, err := net.InterfaceByIndex(ns.Link(name).Index)
+	if err != nil {
+		ns.t.Fatalf("Could not find link %s: %s", name, err)
+	}
+
+	addrs, err := ifc.Addrs()
+	if err != nil {
+		ns.t.Fatalf("Could not list addresses of link %s: %s", name, err)
+	}
+
+	for _, cidr := range cidrs {
+		ip, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			ns.t.Fatalf("Invalid address %s: %s", cidr, err)
+		}
+
+		found := false
+		for _, addr := range addrs {
+			if n, ok := addr.(*net.IPNet); ok && n.IP.Equal(ip) && bytes.Equal(n.Mask, ipNet.Mask) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			ns.t.Errorf("Link %s address %s: not assigned, got %v", name, cidr, addrs)
+		}
+	}
+}
+
+// linkList dumps network links of the calling thread's network namespace
+func linkList() ([]*Link, error) {
+	tab, err := syscall.NetlinkRIB(syscall.RTM_GETLINK, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage(tab)
+	if err != nil {
+		return nil, err
+	}
+
+	var links []*Link
+	for i := range msgs {
+		if msgs[i].Header.Type != syscall.RTM_NEWLINK || len(msgs[i].Data) < syscall.SizeofIfInfomsg {
+			continue
+		}
+
+		link, err := parseLink(&msgs[i])
+		if err != nil {
+			return nil, err
+		}
+
+		links = append(links, link)
+	}
+
+	return links, nil
+}
+
+// linkAdd creates link of the given name and kind in the calling thread's network namespace
+func linkAdd(name, kind string) error {
+	info := rtAttr(iflaInfoKind, []byte(kind))
+	data := append(make([]byte, syscall.SizeofIfInfomsg), rtAttr(syscall.IFLA_IFNAME, append([]byte(name), 0))...)
+	data = append(data, rtAttr(syscall.IFLA_LINKINFO, info)...)
+
+	return rtnlRequest(syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, data)
+}
+
+// linkDel deletes link of the given name in the calling thread's network namespace
+func linkDel(name string) error {
+	data := append(make([]byte, syscall.SizeofIfInfomsg), rtAttr(syscall.IFLA_IFNAME, append([]byte(name), 0))...)
+
+	return rtnlRequest(syscall.RTM_DELLINK, 0, data)
+}
+
+// rtnlRequest sends rtnetlink request from the calling thread's network namespace and waits for its acknowledgement
+func rtnlRequest(msgType, flags uint16, data []byte) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	sa := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}
+	if err := syscall.Bind(fd, sa); err != nil {
+		return err
+	}
+
+	b := make([]byte, syscall.NLMSG_HDRLEN+len(data))
+	nativeEndian.PutUint32(b[0:4], uint32(len(b)))
+	nativeEndian.PutUint16(b[4:6], msgType)
+	nativeEndian.PutUint16(b[6:8], flags|syscall.NLM_F_REQUEST|syscall.NLM_F_ACK)
+	nativeEndian.PutUint32(b[8:12], 1)
+	copy(b[syscall.NLMSG_HDRLEN:], data)
+
+	if err := syscall.Sendto(fd, b, 0, sa); err != nil {
+		return err
+	}
+
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return err
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+
+		for _, m := range msgs {
+			if m.Header.Type != syscall.NLMSG_ERROR || len(m.Data) < 4 {
+				continue
+			}
+
+			if errno := -int32(nativeUint32(m.Data[0:4])); errno != 0 {
+				return syscall.Errno(errno)
+			}
+
+			return nil
+		}
+	}
+}
+
+// rtAttr encodes rtnetlink attribute padded to RTA_ALIGNTO
+func rtAttr(attrType uint16, value []byte) []byte {
+	l := syscall.SizeofRtAttr + len(value)
+	b := make([]byte, (l+syscall.RTA_ALIGNTO-1) & ^(syscall.RTA_ALIGNTO-1))
+	nativeEndian.PutUint16(b[0:2], uint16(l))
+	nativeEndian.PutUint16(b[2:4], attrType)
+	copy(b[syscall.SizeofRtAttr:], value)
+
+	return b
+}
+
+// parseLink decodes RTM_NEWLINK netlink message into Link
+func parseLink(m *syscall.NetlinkMessage) (*Link, error) {
+	attrs, err := syscall.ParseNetlinkRouteAttr(m)
+	if err != nil {
+		return nil, err
+	}
+
+	// struct ifinfomsg: family, pad, type, index, flags, change
+	link := &Link{
+		Index: int(int32(nativeUint32(m.Data[4:8]))),
+		Up:    nativeUint32(m.Data[8:12])&syscall.IFF_UP != 0,
+	}
+
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case syscall.IFLA_IFNAME:
+			link.Name = string(bytes.TrimRight(attr.Value, "\x00"))
+		case syscall.IFLA_MTU:
+			link.MTU = int(nativeUint32(attr.Value))
+		case syscall.IFLA_ADDRESS:
+			link.HardwareAddr = net.HardwareAddr(append([]byte(nil), attr.Value...))
+		case syscall.IFLA_MASTER:
+			link.MasterIndex = int(nativeUint32(attr.Value))
+		case syscall.IFLA_LINKINFO:
+			link.Kind = linkKind(attr.Value)
+		}
+	}
+
+	return link, nil
+}
+
+// linkKind returns link kind from the nested IFLA_LINKINFO attributes
+func linkKind(b []byte) string {
+	for len(b) >= syscall.SizeofRtAttr {
+		l := int(nativeUint16(b[0:2]))
+		if l < syscall.SizeofRtAttr || l > len(b) {
+			return ""
+		}
+
+		if nativeUint16(b[2:4]) == iflaInfoKind {
+			return string(bytes.TrimRight(b[syscall.SizeofRtAttr:l], "\x00"))
+		}
+
+		alen := (l + syscall.RTA_ALIGNTO - 1) & ^(syscall.RTA_ALIGNTO - 1)
+		if alen > len(b) {
+			break
+		}
+		b = b[alen:]
+	}
+
+	return ""
+}
+
+// threadNsPath returns filesystem path of the network namespace of the thread
+func threadNsPath(pid, tid int) string {
+	return fmt.Sprintf("/proc/%d/task/%d/ns/net", pid, tid)
+}
+
+// nativeUint16 decodes uint16 in host byte order
+func nativeUint16(b []byte) uint16 {
+	return nativeEndian.Uint16(b)
+}
+
+// nativeUint32 decodes uint32 netlink attribute value in host byte order
+func nativeUint32(b []byte) uint32 {
+	if len(b) < 4 {
+		return 0
+	}
+
+	return nativeEndian.Uint32(b)
+}
+
+// nativeEndian is the host byte order used by netlink messages
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	var x uint32 = 0x01020304
+	if *(*byte)(unsafe.Pointer(&x)) == 0x01 {
+		return binary.BigEndian
+	}
+
+	return binary.LittleEndian
+}()
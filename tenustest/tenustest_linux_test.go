@@ -0,0 +1,136 @@
+package tenustest_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/milosgajdos/tenus"
+	"github.com/milosgajdos/tenus/tenustest"
+)
+
+func TestMain(m *testing.M) {
+	code, err := tenus.ReexecInUserNs(m.Run)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Running tests without user namespace: %s\n", err)
+		code = m.Run()
+	}
+
+	os.Exit(code)
+}
+
+func Test_NewIsolated(t *testing.T) {
+	for _, name := range []string{"first", "second"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ns := tenustest.New(t)
+
+			// both subtests create the same links without colliding
+			veth, err := tenus.NewVethPairWithOptions("vethns01", tenus.VethOptions{PeerName: "vethns02"})
+			if err != nil {
+				t.Fatalf("NewVethPairWithOptions() failed: %s", err)
+			}
+
+			if err := veth.SetLinkMTU(1400); err != nil {
+				t.Fatalf("SetLinkMTU() failed: %s", err)
+			}
+
+			if err := veth.SetLinkMacAddress("02:42:ac:11:00:02"); err != nil {
+				t.Fatalf("SetLinkMacAddress() failed: %s", err)
+			}
+
+			ip, network, _ := net.ParseCIDR("10.0.0.1/24")
+			if err := veth.SetLinkIp(ip, network); err != nil {
+				t.Fatalf("SetLinkIp() failed: %s", err)
+			}
+
+			if err := veth.SetLinkUp(); err != nil {
+				t.Fatalf("SetLinkUp() failed: %s", err)
+			}
+
+			br, err := tenus.NewBridgeWithName("brns01")
+			if err != nil {
+				t.Fatalf("NewBridgeWithName() failed: %s", err)
+			}
+
+			if err := br.AddSlaveIfc(veth.PeerNetInterface()); err != nil {
+				t.Fatalf("AddSlaveIfc() failed: %s", err)
+			}
+
+			ns.AssertLinkKind("vethns01", "veth")
+			ns.AssertLinkKind("brns01", "bridge")
+			ns.AssertLinkMTU("vethns01", 1400)
+			ns.AssertLinkMAC("vethns01", "02:42:ac:11:00:02")
+			ns.AssertLinkAddrs("vethns01", "10.0.0.1/24")
+			ns.AssertLinkUp("vethns01")
+			ns.AssertLinkMaster("vethns02", "brns01")
+			ns.AssertLinkMaster("vethns01", "")
+			ns.AssertNoLink("vethns03")
+		})
+	}
+}
+
+func Test_RequireLinkKind(t *testing.T) {
+	t.Run("supported", func(t *testing.T) {
+		ns := tenustest.New(t)
+		ns.RequireLinkKind("veth")
+		ns.RequireLinkKind("bridge")
+
+		if links := ns.Links(); len(links) != 1 || links[0].Name != "lo" {
+			t.Fatalf("RequireLinkKind() left probe links behind: %+v", links)
+		}
+	})
+
+	var skipped bool
+	t.Run("unsupported", func(t *testing.T) {
+		defer func() { skipped = t.Skipped() }()
+
+		ns := tenustest.New(t)
+		ns.RequireLinkKind("tenusnone")
+	})
+
+	if !skipped {
+		t.Fatalf("RequireLinkKind() failed to skip test of unsupported link kind")
+	}
+}
+
+func Test_NewPath(t *testing.T) {
+	hostNs, err := os.Readlink("/proc/self/ns/net")
+	if err != nil {
+		t.Skipf("Could not read network namespace of the process: %s", err)
+	}
+
+	t.Run("ns", func(t *testing.T) {
+		ns := tenustest.New(t)
+
+		testNs, err := os.Readlink(ns.Path())
+		if err != nil {
+			t.Fatalf("Readlink(%s) failed: %s", ns.Path(), err)
+		}
+
+		if testNs == hostNs {
+			t.Fatalf("New() failed: test runs in the host network namespace %s", hostNs)
+		}
+
+		tid := syscall.Gettid()
+		if path := fmt.Sprintf("/proc/%d/task/%d/ns/net", os.Getpid(), tid); ns.Path() != path {
+			t.Fatalf("Path() failed: expected %s, returned %s", path, ns.Path())
+		}
+
+		links := ns.Links()
+		if len(links) != 1 || links[0].Name != "lo" || links[0].Kind != "" {
+			t.Fatalf("New() failed: expected only loopback link, returned %+v", links)
+		}
+
+		if _, err := tenus.NewBridgeWithName("brns02"); err != nil {
+			t.Fatalf("NewBridgeWithName() failed: %s", err)
+		}
+	})
+
+	if _, err := net.InterfaceByName("brns02"); err == nil {
+		t.Fatalf("Link brns02 leaked to the host network namespace")
+	}
+}
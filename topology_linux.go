@@ -0,0 +1,840 @@
+package tenus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// link kinds supported by Topology mapped to their creation priority.
+// Links with lower priority are created first so that bridges and parent devices exist
+// before the links which depend on them.
+var topologyKinds = map[string]int{
+	"dummy":   0,
+	"bridge":  0,
+	"vlan":    1,
+	"macvlan": 1,
+	"macvtap": 1,
+	"veth":    2,
+}
+
+// Topology describes desired network setup of the Linux host: named network namespaces
+// and network links living in them. Topology can be loaded from YAML or JSON.
+type Topology struct {
+	// Named network namespaces managed by the topology
+	Namespaces []string `json:"namespaces,omitempty" yaml:"namespaces,omitempty"`
+	// Network links
+	Links []TopologyLink `json:"links" yaml:"links"`
+}
+
+// TopologyLink describes a network link of a given kind and its configuration.
+type TopologyLink struct {
+	// Link name
+	Name string `json:"name" yaml:"name"`
+	// Link kind: dummy, bridge, veth, vlan, macvlan or macvtap
+	Kind string `json:"kind" yaml:"kind"`
+	// Named network namespace the link lives in. Empty for the host network namespace.
+	Ns string `json:"ns,omitempty" yaml:"ns,omitempty"`
+	// Name of the bridge the link is attached to. The bridge must live in the same network namespace.
+	Master string `json:"master,omitempty" yaml:"master,omitempty"`
+	// Master device of vlan, macvlan and macvtap links. It must live in the host network namespace.
+	Parent string `json:"parent,omitempty" yaml:"parent,omitempty"`
+	// Link options. Ns option is not supported: use link's Ns instead.
+	Options LinkOptions `json:"options,omitempty" yaml:"options,omitempty"`
+	// Veth link options. PeerName is mandatory for veth links.
+	Veth *VethOptions `json:"veth,omitempty" yaml:"veth,omitempty"`
+	// Veth peer link configuration
+	Peer *TopologyPeer `json:"peer,omitempty" yaml:"peer,omitempty"`
+	// Vlan link options. Dev is ignored: link's Name is used instead.
+	Vlan *VlanOptions `json:"vlan,omitempty" yaml:"vlan,omitempty"`
+	// Macvlan and macvtap link options. Dev is ignored: link's Name is used instead.
+	MacVlan *MacVlanOptions `json:"macvlan,omitempty" yaml:"macvlan,omitempty"`
+	// IP network configuration of the link
+	Network *NetworkOptions `json:"network,omitempty" yaml:"network,omitempty"`
+}
+
+// TopologyPeer describes configuration of veth peer link.
+type TopologyPeer struct {
+	// Named network namespace the peer link lives in. Empty for the host network namespace.
+	Ns string `json:"ns,omitempty" yaml:"ns,omitempty"`
+	// Name of the bridge the peer link is attached to
+	Master string `json:"master,omitempty" yaml:"master,omitempty"`
+	// Peer link options. Ns option is not supported: use peer's Ns instead.
+	Options LinkOptions `json:"options,omitempty" yaml:"options,omitempty"`
+	// IP network configuration of the peer link
+	Network *NetworkOptions `json:"network,omitempty" yaml:"network,omitempty"`
+}
+
+// TopologyDiff describes a difference between the Topology and the live state of the Linux host.
+type TopologyDiff struct {
+	// Network namespace of the link. Empty for the host network namespace.
+	Ns string
+	// Link name. Empty for network namespace differences.
+	Link string
+	// Field which differs: netns, link, kind, ns, mtu, address, master, state, ip or gw
+	Field string
+	// Current value. Empty if missing.
+	Current string
+	// Desired value
+	Desired string
+}
+
+// String returns human readable description of the difference
+func (d TopologyDiff) String() string {
+	target := d.Link
+	if d.Link == "" {
+		target = d.Ns
+	} else if d.Ns != "" {
+		target = d.Ns + "/" + d.Link
+	}
+
+	return fmt.Sprintf("%s %s: %q -> %q", target, d.Field, d.Current, d.Desired)
+}
+
+// TopologyStep is a single operation Apply performs to converge the Linux host to the Topology.
+type TopologyStep struct {
+	// Operation i.e. "add link", "set mtu"
+	Op string
+	// Network namespace the operation is performed in. Empty for the host network namespace.
+	Ns string
+	// Link name. Empty for network namespace operations.
+	Link string
+	// Operation argument
+	Value string
+
+	run func(ctx context.Context) error
+}
+
+// String returns human readable description of the step
+func (s TopologyStep) String() string {
+	str := s.Op
+	if s.Ns != "" && s.Link != "" {
+		str += " [" + s.Ns + "]"
+	}
+	if s.Link != "" {
+		str += " " + s.Link
+	} else if s.Ns != "" {
+		str += " " + s.Ns
+	}
+	if s.Value != "" {
+		str += " " + s.Value
+	}
+
+	return str
+}
+
+// topologyEndpoint is a single network link described by the Topology. Veth links have two endpoints.
+type topologyEndpoint struct {
+	name    string
+	kind    string
+	parent  string
+	vlanId  uint16
+	ns      string
+	master  string
+	opts    LinkOptions
+	network *NetworkOptions
+}
+
+// LoadTopology loads Topology from a file. Files with .json extension are decoded as JSON, all other files as YAML.
+// It returns error if the file can not be read or if the Topology is invalid.
+func LoadTopology(path string) (*Topology, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read topology %s: %s", path, err)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return parseTopology(data, json.Unmarshal)
+	}
+
+	return parseTopology(data, yaml.Unmarshal)
+}
+
+// ParseTopology decodes Topology from YAML or JSON data.
+// It returns error if the data can not be decoded or if the Topology is invalid.
+func ParseTopology(data []byte) (*Topology, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return parseTopology(data, json.Unmarshal)
+	}
+
+	return parseTopology(data, yaml.Unmarshal)
+}
+
+func parseTopology(data []byte, unmarshal func([]byte, interface{}) error) (*Topology, error) {
+	topo := &Topology{}
+	if err := unmarshal(data, topo); err != nil {
+		return nil, fmt.Errorf("Could not decode topology: %s", err)
+	}
+
+	if err := topo.Validate(); err != nil {
+		return nil, err
+	}
+
+	return topo, nil
+}
+
+// Validate checks the Topology is consistent.
+// It returns error if any of the links is misconfigured.
+func (t *Topology) Validate() error {
+	namespaces := make(map[string]bool)
+	for _, ns := range t.Namespaces {
+		if ns == "" || filepath.Base(ns) != ns {
+			return fmt.Errorf("Invalid network namespace name: %q", ns)
+		}
+		namespaces[ns] = true
+	}
+
+	names := make(map[string]bool)
+	for _, l := range t.Links {
+		if _, ok := topologyKinds[l.Kind]; !ok {
+			return fmt.Errorf("Unsupported link kind %q of link %s", l.Kind, l.Name)
+		}
+
+		for _, ep := range l.endpoints() {
+			if ok, err := NetInterfaceNameValid(ep.name); !ok {
+				return err
+			}
+
+			key := ep.ns + "/" + ep.name
+			if names[key] {
+				return fmt.Errorf("Link %s specified more than once", ep.name)
+			}
+			names[key] = true
+
+			if ep.ns != "" && !namespaces[ep.ns] && !NamedNetNsExists(ep.ns) {
+				return fmt.Errorf("%w: %s of link %s is not managed and does not exist", ErrNsNotFound, ep.ns, ep.name)
+			}
+
+			if ep.opts.Ns != 0 {
+				return fmt.Errorf("Namespace PID option is not supported in topology: link %s", ep.name)
+			}
+
+			if ep.opts.MTU != 0 {
+				if err := validMtu(ep.opts.MTU); err != nil {
+					return err
+				}
+			}
+
+			if ep.opts.MacAddr != "" {
+				if ok, err := MacAddressValid(ep.opts.MacAddr); !ok {
+					return fmt.Errorf("link %s: %w", ep.name, err)
+				}
+			}
+
+			if ep.opts.Flags != 0 {
+				if err := validFlags(ep.opts.Flags); err != nil {
+					return err
+				}
+			}
+
+			if ep.network != nil {
+				if err := validTopologyNetwork(ep.network); err != nil {
+					return fmt.Errorf("Invalid network options of link %s: %s", ep.name, err)
+				}
+			}
+		}
+
+		switch l.Kind {
+		case "veth":
+			if l.Veth == nil || l.Veth.PeerName == "" {
+				return fmt.Errorf("Veth link %s requires peer name", l.Name)
+			}
+			if l.Veth.TxQueueLen < 0 {
+				return fmt.Errorf("TX queue length must be a positive integer: %d", l.Veth.TxQueueLen)
+			}
+		case "vlan":
+			if l.Parent == "" || l.Vlan == nil || l.Vlan.Id == 0 {
+				return fmt.Errorf("Vlan link %s requires parent device and VLAN id", l.Name)
+			}
+		case "macvlan", "macvtap":
+			if l.Parent == "" {
+				return fmt.Errorf("%s link %s requires parent device", l.Kind, l.Name)
+			}
+			if l.MacVlan != nil && l.MacVlan.Mode != "" && !MacVlanModes[l.MacVlan.Mode] {
+				return fmt.Errorf("Unsupported MacVlan mode specified: %s", l.MacVlan.Mode)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Diff compares the Topology with the live state of the Linux host.
+// It returns error if the live state can not be read.
+func (t *Topology) Diff() ([]TopologyDiff, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	var diffs []TopologyDiff
+	for _, ns := range t.Namespaces {
+		if !NamedNetNsExists(ns) {
+			diffs = append(diffs, TopologyDiff{Ns: ns, Field: "netns", Desired: "present"})
+		}
+	}
+
+	for _, l := range t.sortedLinks() {
+		for _, ep := range l.endpoints() {
+			epDiffs, err := ep.diff()
+			if err != nil {
+				return nil, err
+			}
+			diffs = append(diffs, epDiffs...)
+		}
+	}
+
+	return diffs, nil
+}
+
+// Plan returns ordered list of steps Apply performs to converge the Linux host to the Topology.
+// Empty plan means the Linux host already matches the Topology.
+// It returns error if the live state can not be read.
+func (t *Topology) Plan() ([]TopologyStep, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	var steps []TopologyStep
+	for _, ns := range t.Namespaces {
+		if !NamedNetNsExists(ns) {
+			ns := ns
+			steps = append(steps, TopologyStep{Op: "add netns", Ns: ns, run: func(ctx context.Context) error {
+				return NewNamedNetNs(ns)
+			}})
+		}
+	}
+
+	for _, l := range t.sortedLinks() {
+		l := l
+		endpoints := l.endpoints()
+
+		recreate := false
+		diffs := make([][]TopologyDiff, len(endpoints))
+		for i, ep := range endpoints {
+			epDiffs, err := ep.diff()
+			if err != nil {
+				return nil, err
+			}
+			diffs[i] = epDiffs
+
+			for _, d := range epDiffs {
+				if d.Field == "link" || d.Field == "kind" {
+					recreate = true
+				}
+			}
+		}
+
+		if !recreate {
+			for i, ep := range endpoints {
+				steps = append(steps, ep.configureSteps(diffs[i])...)
+			}
+			continue
+		}
+
+		for i, ep := range endpoints {
+			for _, d := range diffs[i] {
+				if d.Field == "kind" {
+					ep := ep
+					ns := d.Ns
+					steps = append(steps, TopologyStep{Op: "del link", Ns: ns, Link: ep.name, run: func(ctx context.Context) error {
+						return ExecInNamedNetNs(ns, func() error {
+							return DeleteLink(ep.name)
+						})
+					}})
+				}
+			}
+		}
+
+		steps = append(steps, TopologyStep{Op: "add link", Link: l.Name, Value: l.Kind, run: l.create})
+
+		for _, ep := range endpoints {
+			var epDiffs []TopologyDiff
+			if ep.ns != "" {
+				epDiffs = append(epDiffs, TopologyDiff{Ns: ep.ns, Link: ep.name, Field: "ns", Desired: ep.ns})
+			}
+			epDiffs = append(epDiffs, ep.desiredDiffs()...)
+			steps = append(steps, ep.configureSteps(epDiffs)...)
+		}
+	}
+
+	return steps, nil
+}
+
+// Apply converges the Linux host to the Topology.
+// Apply is idempotent: only the differences between the Topology and the live state are applied.
+// It returns error if any of the steps fails. Steps performed before the failure are not reverted.
+func (t *Topology) Apply() error {
+	return t.ApplyContext(context.Background())
+}
+
+// ApplyContext converges the Linux host to the Topology like Apply.
+// The context is checked before every step; once it is done, ApplyContext stops and returns the context's error.
+// Since Apply is idempotent, calling it again picks up where the cancelled call stopped.
+func (t *Topology) ApplyContext(ctx context.Context) error {
+	steps, err := t.Plan()
+	if err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("Topology step %q cancelled: %w", step, err)
+		}
+
+		if err := step.run(ctx); err != nil {
+			return fmt.Errorf("Topology step %q failed: %w", step, err)
+		}
+	}
+
+	return nil
+}
+
+// Destroy deletes all links and network namespaces described by the Topology.
+// Links and network namespaces which do not exist are skipped.
+// It returns error listing all the objects which could not be deleted.
+func (t *Topology) Destroy() error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+
+	var errs []string
+	links := t.sortedLinks()
+	for i := len(links) - 1; i >= 0; i-- {
+		for _, ep := range links[i].endpoints() {
+			ns, attrs, err := ep.locate()
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+
+			// deleting one veth endpoint deletes the other one, too
+			if attrs == nil {
+				continue
+			}
+
+			if err := ExecInNamedNetNs(ns, func() error {
+				return DeleteLink(ep.name)
+			}); err != nil {
+				errs = append(errs, fmt.Sprintf("Could not delete link %s: %s", ep.name, err))
+			}
+		}
+	}
+
+	for _, ns := range t.Namespaces {
+		if !NamedNetNsExists(ns) {
+			continue
+		}
+
+		if err := DeleteNamedNetNs(ns); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Could not destroy topology: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// sortedLinks returns topology links ordered by their creation priority
+func (t *Topology) sortedLinks() []TopologyLink {
+	links := make([]TopologyLink, len(t.Links))
+	copy(links, t.Links)
+
+	sort.SliceStable(links, func(i, j int) bool {
+		return topologyKinds[links[i].Kind] < topologyKinds[links[j].Kind]
+	})
+
+	return links
+}
+
+// endpoints returns network links described by the topology link
+func (l TopologyLink) endpoints() []topologyEndpoint {
+	endpoints := []topologyEndpoint{{
+		name:    l.Name,
+		kind:    l.Kind,
+		ns:      l.Ns,
+		master:  l.Master,
+		opts:    l.Options,
+		network: l.Network,
+	}}
+
+	switch l.Kind {
+	case "vlan":
+		endpoints[0].parent = l.Parent
+		if l.Vlan != nil {
+			endpoints[0].vlanId = l.Vlan.Id
+		}
+	case "macvlan", "macvtap":
+		endpoints[0].parent = l.Parent
+	}
+
+	if l.Kind == "veth" && l.Veth != nil {
+		peer := topologyEndpoint{name: l.Veth.PeerName, kind: l.Kind}
+		if l.Peer != nil {
+			peer.ns, peer.master, peer.opts, peer.network = l.Peer.Ns, l.Peer.Master, l.Peer.Options, l.Peer.Network
+		}
+		endpoints = append(endpoints, peer)
+	}
+
+	return endpoints
+}
+
+// create creates the topology link in the host network namespace
+func (l TopologyLink) create(ctx context.Context) error {
+	var err error
+	switch l.Kind {
+	case "dummy":
+		_, err = NewLink(l.Name)
+	case "bridge":
+		_, err = NewBridgeWithName(l.Name)
+	case "veth":
+		_, err = NewVethPairWithOptionsContext(ctx, l.Name, VethOptions{PeerName: l.Veth.PeerName, TxQueueLen: l.Veth.TxQueueLen})
+	case "vlan":
+		_, err = NewVlanLinkWithOptionsContext(ctx, l.Parent, VlanOptions{Dev: l.Name, Id: l.Vlan.Id})
+	case "macvlan", "macvtap":
+		opts := MacVlanOptions{Dev: l.Name}
+		if l.MacVlan != nil {
+			opts.Mode = l.MacVlan.Mode
+		}
+		if l.Kind == "macvlan" {
+			_, err = NewMacVlanLinkWithOptionsContext(ctx, l.Parent, opts)
+		} else {
+			_, err = NewMacVtapLinkWithOptionsContext(ctx, l.Parent, opts)
+		}
+	default:
+		err = fmt.Errorf("Unsupported link kind: %s", l.Kind)
+	}
+
+	return err
+}
+
+// locate finds the endpoint's link in its network namespace or in the host network namespace
+// if it has not been moved yet. It returns nil attributes if the link does not exist.
+func (ep topologyEndpoint) locate() (string, *LinkAttrs, error) {
+	if ep.ns != "" && NamedNetNsExists(ep.ns) {
+		var attrs *LinkAttrs
+		if err := ExecInNamedNetNs(ep.ns, func() error {
+			var err error
+			attrs, err = findLinkAttrs(ep.name)
+			return err
+		}); err != nil {
+			return "", nil, err
+		}
+
+		if attrs != nil {
+			return ep.ns, attrs, nil
+		}
+	}
+
+	attrs, err := findLinkAttrs(ep.name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return "", attrs, nil
+}
+
+// diff compares the endpoint with its live state
+func (ep topologyEndpoint) diff() ([]TopologyDiff, error) {
+	ns, attrs, err := ep.locate()
+	if err != nil {
+		return nil, err
+	}
+
+	if attrs == nil {
+		return []TopologyDiff{{Ns: ep.ns, Link: ep.name, Field: "link", Desired: ep.kind}}, nil
+	}
+
+	if attrs.Kind != ep.kind {
+		return []TopologyDiff{{Ns: ns, Link: ep.name, Field: "kind", Current: attrs.Kind, Desired: ep.kind}}, nil
+	}
+
+	// parent and VLAN id can not be changed without recreating the link
+	if ep.parent != "" {
+		parent := ""
+		// parent lives in the host network namespace: in the same one as the link or in a peer one
+		if attrs.ParentIndex != 0 && (ns == "") == (attrs.NetNsID < 0) {
+			if parentAttrs, err := LinkAttrsByIndex(attrs.ParentIndex); err == nil {
+				parent = parentAttrs.Name
+			}
+		}
+
+		current, desired := linkKind(attrs.Kind, parent, attrs.VlanId), linkKind(ep.kind, ep.parent, ep.vlanId)
+		if current != desired {
+			return []TopologyDiff{{Ns: ns, Link: ep.name, Field: "kind", Current: current, Desired: desired}}, nil
+		}
+	}
+
+	var diffs []TopologyDiff
+	if ns != ep.ns {
+		diffs = append(diffs, TopologyDiff{Ns: ep.ns, Link: ep.name, Field: "ns", Current: ns, Desired: ep.ns})
+	}
+
+	err = ExecInNamedNetNs(ns, func() error {
+		if ep.opts.MTU != 0 && attrs.MTU != ep.opts.MTU {
+			diffs = append(diffs, TopologyDiff{Ns: ep.ns, Link: ep.name, Field: "mtu",
+				Current: fmt.Sprint(attrs.MTU), Desired: fmt.Sprint(ep.opts.MTU)})
+		}
+
+		if ep.opts.MacAddr != "" {
+			hwaddr, _ := net.ParseMAC(ep.opts.MacAddr)
+			if !bytes.Equal(attrs.HardwareAddr, hwaddr) {
+				diffs = append(diffs, TopologyDiff{Ns: ep.ns, Link: ep.name, Field: "address",
+					Current: attrs.HardwareAddr.String(), Desired: hwaddr.String()})
+			}
+		}
+
+		if ep.master != "" {
+			master := ""
+			if attrs.MasterIndex != 0 && ns == ep.ns {
+				if masterAttrs, err := LinkAttrsByIndex(attrs.MasterIndex); err == nil {
+					master = masterAttrs.Name
+				}
+			}
+
+			if master != ep.master {
+				diffs = append(diffs, TopologyDiff{Ns: ep.ns, Link: ep.name, Field: "master",
+					Current: master, Desired: ep.master})
+			}
+		}
+
+		if ep.network != nil && ep.network.IpAddr != "" {
+			ip, ipNet, _ := net.ParseCIDR(ep.network.IpAddr)
+			assigned, err := linkHasAddr(ep.name, ip, ipNet)
+			if err != nil {
+				return err
+			}
+
+			if !assigned || ns != ep.ns {
+				diffs = append(diffs, TopologyDiff{Ns: ep.ns, Link: ep.name, Field: "ip", Desired: ep.network.IpAddr})
+			}
+		}
+
+		if (ep.opts.Flags&net.FlagUp) == net.FlagUp && ((attrs.Flags&net.FlagUp) != net.FlagUp || ns != ep.ns) {
+			diffs = append(diffs, TopologyDiff{Ns: ep.ns, Link: ep.name, Field: "state", Current: "down", Desired: "up"})
+		}
+
+		if ep.network != nil && ep.network.Gw != "" {
+			present, err := defaultGwPresent(net.ParseIP(ep.network.Gw))
+			if err != nil {
+				return err
+			}
+
+			if !present || ns != ep.ns {
+				diffs = append(diffs, TopologyDiff{Ns: ep.ns, Link: ep.name, Field: "gw", Desired: ep.network.Gw})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return diffs, nil
+}
+
+// linkKind returns link kind qualified with its parent and VLAN id i.e. vlan@eth0 id 10
+func linkKind(kind, parent string, vlanId uint16) string {
+	if kind == "vlan" {
+		return fmt.Sprintf("%s@%s id %d", kind, parent, vlanId)
+	}
+
+	return kind + "@" + parent
+}
+
+// desiredDiffs returns differences of newly created endpoint: all the configured fields
+func (ep topologyEndpoint) desiredDiffs() []TopologyDiff {
+	var diffs []TopologyDiff
+	if ep.opts.MTU != 0 {
+		diffs = append(diffs, TopologyDiff{Ns: ep.ns, Link: ep.name, Field: "mtu", Desired: fmt.Sprint(ep.opts.MTU)})
+	}
+	if ep.opts.MacAddr != "" {
+		diffs = append(diffs, TopologyDiff{Ns: ep.ns, Link: ep.name, Field: "address", Desired: ep.opts.MacAddr})
+	}
+	if ep.master != "" {
+		diffs = append(diffs, TopologyDiff{Ns: ep.ns, Link: ep.name, Field: "master", Desired: ep.master})
+	}
+	if ep.network != nil && ep.network.IpAddr != "" {
+		diffs = append(diffs, TopologyDiff{Ns: ep.ns, Link: ep.name, Field: "ip", Desired: ep.network.IpAddr})
+	}
+	if (ep.opts.Flags & net.FlagUp) == net.FlagUp {
+		diffs = append(diffs, TopologyDiff{Ns: ep.ns, Link: ep.name, Field: "state", Desired: "up"})
+	}
+	if ep.network != nil && ep.network.Gw != "" {
+		diffs = append(diffs, TopologyDiff{Ns: ep.ns, Link: ep.name, Field: "gw", Desired: ep.network.Gw})
+	}
+
+	return diffs
+}
+
+// configureSteps translates endpoint's differences into steps.
+// The order of differences is preserved: namespace move comes first and gateway last.
+func (ep topologyEndpoint) configureSteps(diffs []TopologyDiff) []TopologyStep {
+	var steps []TopologyStep
+	for _, d := range diffs {
+		d := d
+		step := TopologyStep{Ns: ep.ns, Link: ep.name, Value: d.Desired}
+
+		switch d.Field {
+		case "ns":
+			step.Op = "set ns"
+			step.Ns = ""
+			step.run = func(ctx context.Context) error {
+				return ExecInNamedNetNs(d.Current, func() error {
+					return setLinkNsPath(ep.name, NetNsPath(ep.ns))
+				})
+			}
+		case "mtu":
+			step.Op = "set mtu"
+			step.run = ep.inNs(func(ctx context.Context, ifc *net.Interface) error {
+				return backend.LinkSetMTU(ctx, ifc.Index, ep.opts.MTU)
+			})
+		case "address":
+			step.Op = "set address"
+			step.run = ep.inNs(func(ctx context.Context, ifc *net.Interface) error {
+				hwaddr, err := net.ParseMAC(ep.opts.MacAddr)
+				if err != nil {
+					return err
+				}
+				return backend.LinkSetHardwareAddr(ctx, ifc.Index, hwaddr)
+			})
+		case "master":
+			step.Op = "set master"
+			step.run = ep.inNs(func(ctx context.Context, ifc *net.Interface) error {
+				master, err := interfaceByName(ep.master)
+				if err != nil {
+					return fmt.Errorf("Could not find master %s: %w", ep.master, err)
+				}
+				return backend.LinkSetMaster(ctx, ifc.Index, master.Index)
+			})
+		case "ip":
+			step.Op = "add addr"
+			step.run = ep.inNs(func(ctx context.Context, ifc *net.Interface) error {
+				ip, ipNet, err := net.ParseCIDR(ep.network.IpAddr)
+				if err != nil {
+					return err
+				}
+				return backend.AddrAdd(ctx, ifc.Index, linkAddr(ip, ipNet))
+			})
+		case "state":
+			step.Op = "set up"
+			step.Value = ""
+			step.run = ep.inNs(func(ctx context.Context, ifc *net.Interface) error {
+				return backend.LinkSetUp(ctx, ifc.Index)
+			})
+		case "gw":
+			step.Op = "add gw"
+			step.run = ep.inNs(func(ctx context.Context, ifc *net.Interface) error {
+				return backend.RouteAdd(ctx, &Route{Gw: net.ParseIP(ep.network.Gw), Index: ifc.Index})
+			})
+		default:
+			continue
+		}
+
+		steps = append(steps, step)
+	}
+
+	return steps
+}
+
+// inNs returns function which runs fn on endpoint's network interface in endpoint's network namespace
+func (ep topologyEndpoint) inNs(fn func(context.Context, *net.Interface) error) func(context.Context) error {
+	return func(ctx context.Context) error {
+		return ExecInNamedNetNs(ep.ns, func() error {
+			ifc, err := interfaceByName(ep.name)
+			if err != nil {
+				return fmt.Errorf("Could not find link %s: %w", ep.name, err)
+			}
+			return fn(ctx, ifc)
+		})
+	}
+}
+
+// validTopologyNetwork validates network options of the topology link
+func validTopologyNetwork(opts *NetworkOptions) error {
+	if opts.IpAddr != "" {
+		if _, _, err := net.ParseCIDR(opts.IpAddr); err != nil {
+			return err
+		}
+	}
+
+	if opts.Gw != "" && net.ParseIP(opts.Gw) == nil {
+		return fmt.Errorf("Invalid gateway address: %s", opts.Gw)
+	}
+
+	if len(opts.Routes) > 0 {
+		return fmt.Errorf("Routes are not supported in topology")
+	}
+
+	return nil
+}
+
+// findLinkAttrs returns attributes of the link of the given name in the current network namespace.
+// It returns nil attributes if the link does not exist.
+func findLinkAttrs(name string) (*LinkAttrs, error) {
+	attrs, err := backend.LinkByName(context.Background(), name)
+	if errors.Is(err, ErrLinkNotFound) {
+		return nil, nil
+	}
+
+	return attrs, err
+}
+
+// linkHasAddr returns true if the IP address is assigned to the link in the current network namespace
+func linkHasAddr(name string, ip net.IP, ipNet *net.IPNet) (bool, error) {
+	ifc, err := interfaceByName(name)
+	if err != nil {
+		return false, err
+	}
+
+	addrs, err := backend.AddrList(context.Background(), ifc.Index)
+	if err != nil {
+		return false, err
+	}
+
+	for _, addr := range addrs {
+		if addr.IP.Equal(ip) && bytes.Equal(addr.Mask, ipNet.Mask) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// defaultGwPresent returns true if default route via gw exists in the current network namespace
+func defaultGwPresent(gw net.IP) (bool, error) {
+	routes, err := backend.RouteList(context.Background())
+	if err != nil {
+		return false, err
+	}
+
+	for _, route := range routes {
+		if route.Dst == nil && route.Gw.Equal(gw) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// setLinkNsPath moves the link of the given name to network namespace specified by filesystem path
+func setLinkNsPath(name, nspath string) error {
+	ifc, err := interfaceByName(name)
+	if err != nil {
+		return err
+	}
+
+	return backend.LinkSetNs(context.Background(), ifc.Index, nspath)
+}
@@ -0,0 +1,223 @@
+package tenus
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+)
+
+var topologyYAML = `
+namespaces: [tnsa01, tnsb01]
+links:
+- name: vethtopo01
+  kind: veth
+  master: brtopo01
+  options: {flags: 1}
+  veth: {peername: vethtopo02}
+  peer:
+    ns: tnsa01
+    options: {mtu: 1400, flags: 1}
+    network: {ipaddr: 10.10.0.2/24, gw: 10.10.0.1}
+- name: brtopo01
+  kind: bridge
+  options: {macaddr: "22:ce:e0:99:63:6f", flags: 1}
+  network: {ipaddr: 10.10.0.1/24}
+`
+
+var topologyJSON = `{
+  "namespaces": ["tnsa01"],
+  "links": [
+    {"name": "brtopo01", "kind": "bridge", "options": {"mtu": 1400}},
+    {"name": "vlantopo01", "kind": "vlan", "parent": "eth1", "vlan": {"id": 10}}
+  ]
+}`
+
+func Test_ParseTopology(t *testing.T) {
+	topo, err := ParseTopology([]byte(topologyYAML))
+	if err != nil {
+		t.Fatalf("ParseTopology(yaml) failed: %s", err)
+	}
+
+	if len(topo.Namespaces) != 2 || len(topo.Links) != 2 {
+		t.Fatalf("ParseTopology(yaml) failed: returned %+v", topo)
+	}
+
+	veth := topo.Links[0]
+	if veth.Veth.PeerName != "vethtopo02" || veth.Peer.Ns != "tnsa01" || veth.Peer.Options.MTU != 1400 ||
+		veth.Peer.Network.IpAddr != "10.10.0.2/24" || veth.Options.Flags != net.FlagUp {
+		t.Fatalf("ParseTopology(yaml) failed: returned %+v", veth)
+	}
+
+	if topo.Links[1].Options.MacAddr != "22:ce:e0:99:63:6f" {
+		t.Fatalf("ParseTopology(yaml) failed: returned %+v", topo.Links[1])
+	}
+
+	topo, err = ParseTopology([]byte(topologyJSON))
+	if err != nil {
+		t.Fatalf("ParseTopology(json) failed: %s", err)
+	}
+
+	if topo.Links[0].Options.MTU != 1400 || topo.Links[1].Vlan.Id != 10 || topo.Links[1].Parent != "eth1" {
+		t.Fatalf("ParseTopology(json) failed: returned %+v", topo.Links)
+	}
+
+	sorted := topo.sortedLinks()
+	if sorted[0].Kind != "bridge" || sorted[1].Kind != "vlan" {
+		t.Fatalf("sortedLinks() failed: returned %+v", sorted)
+	}
+}
+
+var invalidTopologies = []Topology{
+	{Links: []TopologyLink{{Name: "link01", Kind: "wireguard"}}},
+	{Links: []TopologyLink{{Name: "link01", Kind: "veth"}}},
+	{Links: []TopologyLink{{Name: "link01", Kind: "vlan", Parent: "eth0"}}},
+	{Links: []TopologyLink{{Name: "link01", Kind: "macvlan"}}},
+	{Links: []TopologyLink{{Name: "link01", Kind: "dummy"}, {Name: "link01", Kind: "bridge"}}},
+	{Links: []TopologyLink{{Name: "link01", Kind: "dummy", Options: LinkOptions{Ns: 100}}}},
+	{Links: []TopologyLink{{Name: "link01", Kind: "dummy", Options: LinkOptions{MacAddr: "random"}}}},
+	{Links: []TopologyLink{{Name: "link01", Kind: "dummy", Network: &NetworkOptions{IpAddr: "10.0.0.1"}}}},
+	{Links: []TopologyLink{{Name: "link01", Kind: "dummy", Ns: "tnsnotexist01"}}},
+	{Namespaces: []string{"../etc"}},
+}
+
+func Test_TopologyValidate(t *testing.T) {
+	for _, tt := range invalidTopologies {
+		if err := tt.Validate(); err == nil {
+			t.Errorf("Validate(%+v) expected to fail", tt)
+		}
+	}
+}
+
+func Test_TopologyApply(t *testing.T) {
+	if err := CheckCapability(capSysAdmin); err != nil {
+		t.Skipf("Topology test requires %s", err)
+	}
+
+	runDir, err := ioutil.TempDir("", "tenus-netns")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(runDir)
+
+	origRunDir := NetNsRunDir
+	NetNsRunDir = runDir
+	defer func() { NetNsRunDir = origRunDir }()
+
+	topo, err := ParseTopology([]byte(topologyYAML))
+	if err != nil {
+		t.Fatalf("ParseTopology(yaml) failed: %s", err)
+	}
+	defer topo.Destroy()
+
+	steps, err := topo.Plan()
+	if err != nil {
+		t.Fatalf("Plan() failed: %s", err)
+	}
+
+	if len(steps) == 0 || steps[0].Op != "add netns" {
+		t.Fatalf("Plan() failed: returned %v", steps)
+	}
+
+	if err := topo.Apply(); err != nil {
+		t.Fatalf("Apply() failed: %s", err)
+	}
+
+	diffs, err := topo.Diff()
+	if err != nil {
+		t.Fatalf("Diff() failed: %s", err)
+	}
+
+	if len(diffs) != 0 {
+		t.Fatalf("Diff() after Apply() expected no differences, returned: %v", diffs)
+	}
+
+	// drift the peer link and make sure Apply converges it back
+	if err := ExecInNamedNetNs("tnsa01", func() error {
+		ifc, err := net.InterfaceByName("vethtopo02")
+		if err != nil {
+			return err
+		}
+		return (&Link{ifc: ifc}).SetLinkMTU(1300)
+	}); err != nil {
+		t.Fatalf("Could not change peer MTU: %s", err)
+	}
+
+	steps, err = topo.Plan()
+	if err != nil {
+		t.Fatalf("Plan() failed: %s", err)
+	}
+
+	if len(steps) != 1 || steps[0].Op != "set mtu" || steps[0].Link != "vethtopo02" {
+		t.Fatalf("Plan() after drift failed: returned %v", steps)
+	}
+
+	if err := topo.Apply(); err != nil {
+		t.Fatalf("Apply() failed: %s", err)
+	}
+
+	if steps, err = topo.Plan(); err != nil || len(steps) != 0 {
+		t.Fatalf("Plan() after second Apply() expected no steps, returned: %v %v", steps, err)
+	}
+
+	if err := topo.Destroy(); err != nil {
+		t.Fatalf("Destroy() failed: %s", err)
+	}
+
+	if _, err := net.InterfaceByName("brtopo01"); err == nil {
+		t.Fatalf("Destroy() failed: brtopo01 still exists")
+	}
+
+	if NamedNetNsExists("tnsa01") {
+		t.Fatalf("Destroy() failed: tnsa01 still exists")
+	}
+}
+
+func Test_TopologyDiffParent(t *testing.T) {
+	defer SetBackend(SetBackend(NewFakeBackend()))
+
+	for _, name := range []string{"eth1", "eth2"} {
+		if err := backend.LinkAdd(context.Background(), LinkSpec{Name: name, Kind: "dummy"}); err != nil {
+			t.Fatalf("LinkAdd(%s) failed: %s", name, err)
+		}
+	}
+
+	topo := &Topology{Links: []TopologyLink{
+		{Name: "vlantopo01", Kind: "vlan", Parent: "eth1", Vlan: &VlanOptions{Id: 10}},
+		{Name: "mvltopo01", Kind: "macvlan", Parent: "eth1"},
+	}}
+
+	if err := topo.Apply(); err != nil {
+		t.Fatalf("Apply() failed: %s", err)
+	}
+
+	if diffs, err := topo.Diff(); err != nil || len(diffs) != 0 {
+		t.Fatalf("Diff() after Apply() expected no differences, returned: %v %v", diffs, err)
+	}
+
+	topo.Links[0].Vlan.Id = 20
+	topo.Links[1].Parent = "eth2"
+
+	diffs, err := topo.Diff()
+	if err != nil {
+		t.Fatalf("Diff() failed: %s", err)
+	}
+
+	expected := []TopologyDiff{
+		{Link: "vlantopo01", Field: "kind", Current: "vlan@eth1 id 10", Desired: "vlan@eth1 id 20"},
+		{Link: "mvltopo01", Field: "kind", Current: "macvlan@eth1", Desired: "macvlan@eth2"},
+	}
+	if !reflect.DeepEqual(diffs, expected) {
+		t.Fatalf("Diff() failed: expected %v, returned %v", expected, diffs)
+	}
+
+	if err := topo.Apply(); err != nil {
+		t.Fatalf("Apply() failed: %s", err)
+	}
+
+	if diffs, err := topo.Diff(); err != nil || len(diffs) != 0 {
+		t.Fatalf("Diff() after recreating links expected no differences, returned: %v %v", diffs, err)
+	}
+}
@@ -0,0 +1,279 @@
+package tenus
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// Tx records network link mutations and applies them as a single transaction.
+//
+// Mutations are recorded by Tx methods and applied in order by Commit. If any of the mutations fails,
+// Commit rolls back all the mutations applied before the failure in reverse order.
+// Links are referred to by name, so mutations can be recorded for links created earlier in the same Tx.
+// Once a link is moved to another network namespace by SetLinkNsPid, all subsequent mutations
+// of that link are applied in its new network namespace.
+type Tx struct {
+	steps []txStep
+	// network namespace PIDs of the links moved by the transaction
+	linkNs map[string]int
+	// context the mutations are applied with. Rollback does not use it.
+	ctx context.Context
+	// descriptors of the network namespaces the links are moved back to on rollback
+	nsFds []int
+}
+
+// txStep is a single mutation recorded in Tx
+type txStep struct {
+	desc string
+	do   func() error
+	undo func() error
+}
+
+// TxError is returned by Tx.Commit when any of the recorded mutations fails.
+// It contains the original error and all the errors which occurred during rollback.
+type TxError struct {
+	// Description of the mutation which failed
+	Step string
+	// Original error
+	Err error
+	// Errors returned by rolling back the mutations applied before the failure
+	RollbackErrs []error
+}
+
+// Error returns the original error and rollback errors if there are any
+func (e *TxError) Error() string {
+	msg := fmt.Sprintf("%s failed: %s", e.Step, e.Err)
+	if len(e.RollbackErrs) == 0 {
+		return msg
+	}
+
+	errs := make([]string, len(e.RollbackErrs))
+	for i, err := range e.RollbackErrs {
+		errs[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%s. Rollback failed: %s", msg, strings.Join(errs, "; "))
+}
+
+// Unwrap returns the original error
+func (e *TxError) Unwrap() error {
+	return e.Err
+}
+
+// NewTx returns empty network link transaction.
+func NewTx() *Tx {
+	return &Tx{
+		linkNs: make(map[string]int),
+	}
+}
+
+// Do records a custom mutation. undo is called on rollback; it can be nil if the mutation can't be undone.
+func (tx *Tx) Do(desc string, do, undo func() error) *Tx {
+	tx.steps = append(tx.steps, txStep{desc: desc, do: do, undo: undo})
+	return tx
+}
+
+// CreateLink records creation of the link of the given name by the create function.
+// The link is deleted on rollback.
+func (tx *Tx) CreateLink(name string, create func() error) *Tx {
+	return tx.Do("create link "+name, create, func() error {
+		return tx.inLinkNs(name, func() error {
+			return DeleteLink(name)
+		})
+	})
+}
+
+// SetLinkMTU records setting the link's MTU. The original MTU is restored on rollback.
+func (tx *Tx) SetLinkMTU(name string, mtu int) *Tx {
+	var origMTU int
+	return tx.Do(fmt.Sprintf("set link %s mtu %d", name, mtu), func() error {
+		return tx.withLink(name, func(ifc *net.Interface) error {
+			origMTU = ifc.MTU
+			return backend.LinkSetMTU(tx.ctx, ifc.Index, mtu)
+		})
+	}, func() error {
+		return tx.withLink(name, func(ifc *net.Interface) error {
+			return backend.LinkSetMTU(context.Background(), ifc.Index, origMTU)
+		})
+	})
+}
+
+// SetLinkMacAddress records setting the link's MAC address. The original MAC address is restored on rollback.
+func (tx *Tx) SetLinkMacAddress(name string, macaddr string) *Tx {
+	var origMac net.HardwareAddr
+	return tx.Do(fmt.Sprintf("set link %s address %s", name, macaddr), func() error {
+		hwaddr, err := net.ParseMAC(macaddr)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidMAC, err)
+		}
+
+		return tx.withLink(name, func(ifc *net.Interface) error {
+			origMac = ifc.HardwareAddr
+			return backend.LinkSetHardwareAddr(tx.ctx, ifc.Index, hwaddr)
+		})
+	}, func() error {
+		return tx.withLink(name, func(ifc *net.Interface) error {
+			return backend.LinkSetHardwareAddr(context.Background(), ifc.Index, origMac)
+		})
+	})
+}
+
+// SetLinkUp records bringing the link up. The link is brought down on rollback.
+func (tx *Tx) SetLinkUp(name string) *Tx {
+	return tx.Do(fmt.Sprintf("set link %s up", name), func() error {
+		return tx.withLink(name, func(ifc *net.Interface) error {
+			return backend.LinkSetUp(tx.ctx, ifc.Index)
+		})
+	}, func() error {
+		return tx.withLink(name, func(ifc *net.Interface) error {
+			return backend.LinkSetDown(context.Background(), ifc.Index)
+		})
+	})
+}
+
+// SetLinkNsPid records moving the link to network namespace specified by PID.
+// The link is moved back to the original network namespace on rollback.
+func (tx *Tx) SetLinkNsPid(name string, nspid int) *Tx {
+	var origNs int
+	var origNsPath string
+	return tx.Do(fmt.Sprintf("set link %s netns %d", name, nspid), func() error {
+		origNs = tx.linkNs[name]
+		origNsPath = pidNsPath(origNs)
+		if origNs == 0 {
+			// the link lives in the calling thread's network namespace which may differ from the process' one
+			fd, err := openThreadNetNs()
+			if err != nil {
+				return err
+			}
+			tx.nsFds = append(tx.nsFds, fd)
+			origNsPath = fmt.Sprintf("/proc/self/fd/%d", fd)
+		}
+
+		if err := tx.withLink(name, func(ifc *net.Interface) error {
+			return backend.LinkSetNs(tx.ctx, ifc.Index, pidNsPath(nspid))
+		}); err != nil {
+			return err
+		}
+
+		tx.linkNs[name] = nspid
+
+		return nil
+	}, func() error {
+		if err := tx.withLink(name, func(ifc *net.Interface) error {
+			return backend.LinkSetNs(context.Background(), ifc.Index, origNsPath)
+		}); err != nil {
+			return err
+		}
+
+		tx.linkNs[name] = origNs
+
+		return nil
+	})
+}
+
+// SetLinkIp records configuring the link's IP address. The IP address is removed on rollback.
+func (tx *Tx) SetLinkIp(name string, ip net.IP, network *net.IPNet) *Tx {
+	return tx.Do(fmt.Sprintf("add address %s dev %s", ip, name), func() error {
+		return tx.withLink(name, func(ifc *net.Interface) error {
+			return backend.AddrAdd(tx.ctx, ifc.Index, linkAddr(ip, network))
+		})
+	}, func() error {
+		return tx.withLink(name, func(ifc *net.Interface) error {
+			return backend.AddrDel(context.Background(), ifc.Index, linkAddr(ip, network))
+		})
+	})
+}
+
+// SetLinkDefaultGw records configuring default gateway via the link. The route is removed on rollback.
+func (tx *Tx) SetLinkDefaultGw(name string, gw net.IP) *Tx {
+	return tx.Do(fmt.Sprintf("add default via %s dev %s", gw, name), func() error {
+		return tx.withLink(name, func(ifc *net.Interface) error {
+			return backend.RouteAdd(tx.ctx, &Route{Gw: gw, Index: ifc.Index})
+		})
+	}, func() error {
+		return tx.inLinkNs(name, func() error {
+			return DelDefaultGw(gw, name)
+		})
+	})
+}
+
+// Commit applies all the recorded mutations in order.
+// If any mutation fails, all the mutations applied before it are rolled back in reverse order.
+// It returns *TxError which contains the original error and any errors which occurred during rollback.
+func (tx *Tx) Commit() error {
+	return tx.CommitContext(context.Background())
+}
+
+// CommitContext applies all the recorded mutations in order like Commit.
+// The context is checked before every mutation and it's passed down to the kernel requests;
+// once it is done, the mutations applied so far are rolled back and *TxError wrapping the context's error is returned.
+// Rollback itself is not interrupted by the context so no partially created links are left behind.
+func (tx *Tx) CommitContext(ctx context.Context) error {
+	tx.ctx = ctx
+	defer func() {
+		tx.ctx = nil
+		tx.closeNsFds()
+	}()
+
+	for i, step := range tx.steps {
+		err := ctx.Err()
+		if err == nil {
+			err = step.do()
+		}
+
+		if err != nil {
+			return &TxError{
+				Step:         step.desc,
+				Err:          err,
+				RollbackErrs: tx.rollback(i),
+			}
+		}
+	}
+
+	return nil
+}
+
+// rollback undoes the first n mutations in reverse order
+func (tx *Tx) rollback(n int) []error {
+	var errs []error
+	for i := n - 1; i >= 0; i-- {
+		step := tx.steps[i]
+		if step.undo == nil {
+			continue
+		}
+
+		if err := step.undo(); err != nil {
+			errs = append(errs, fmt.Errorf("undo %s: %s", step.desc, err))
+		}
+	}
+
+	return errs
+}
+
+// closeNsFds closes network namespace descriptors opened by the mutations
+func (tx *Tx) closeNsFds() {
+	for _, fd := range tx.nsFds {
+		syscall.Close(fd)
+	}
+	tx.nsFds = nil
+}
+
+// inLinkNs runs fn in the network namespace the link currently lives in
+func (tx *Tx) inLinkNs(name string, fn func() error) error {
+	return execInNetNs(tx.linkNs[name], fn)
+}
+
+// withLink runs fn on the link's network interface in the network namespace the link currently lives in
+func (tx *Tx) withLink(name string, fn func(*net.Interface) error) error {
+	return tx.inLinkNs(name, func() error {
+		ifc, err := interfaceByName(name)
+		if err != nil {
+			return err
+		}
+
+		return fn(ifc)
+	})
+}
@@ -0,0 +1,233 @@
+package tenus
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/milosgajdos/tenus/tenustest"
+)
+
+func Test_TxCommit(t *testing.T) {
+	var log []string
+	step := func(name string, fail bool) (func() error, func() error) {
+		return func() error {
+				if fail {
+					return errors.New(name + " failed")
+				}
+				log = append(log, "do "+name)
+				return nil
+			}, func() error {
+				log = append(log, "undo "+name)
+				return nil
+			}
+	}
+
+	tx := NewTx()
+	do1, undo1 := step("one", false)
+	do2, undo2 := step("two", false)
+	tx.Do("one", do1, undo1).Do("two", do2, undo2)
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %s", err)
+	}
+
+	if len(log) != 2 || log[0] != "do one" || log[1] != "do two" {
+		t.Fatalf("Commit() failed: expected mutations applied in order, returned %v", log)
+	}
+
+	log = nil
+	do3, undo3 := step("three", true)
+	tx = NewTx().Do("one", do1, undo1).Do("two", do2, nil).Do("three", do3, undo3)
+
+	err := tx.Commit()
+	if err == nil {
+		t.Fatalf("Commit() expected to fail")
+	}
+
+	expected := []string{"do one", "do two", "undo one"}
+	if len(log) != len(expected) {
+		t.Fatalf("Commit() failed: expected %v, returned %v", expected, log)
+	}
+	for i := range expected {
+		if log[i] != expected[i] {
+			t.Fatalf("Commit() failed: expected %v, returned %v", expected, log)
+		}
+	}
+
+	txErr, ok := err.(*TxError)
+	if !ok {
+		t.Fatalf("Commit() failed: expected *TxError, returned %T", err)
+	}
+
+	if txErr.Step != "three" || len(txErr.RollbackErrs) != 0 {
+		t.Fatalf("Commit() failed: returned %+v", txErr)
+	}
+}
+
+func Test_TxRollbackError(t *testing.T) {
+	errOrig := errors.New("original")
+	errUndo := errors.New("undo")
+
+	tx := NewTx().Do("one", func() error { return nil }, func() error { return errUndo }).
+		Do("two", func() error { return errOrig }, nil)
+
+	err := tx.Commit()
+	if !errors.Is(err, errOrig) {
+		t.Fatalf("Commit() failed: expected %v to wrap %v", err, errOrig)
+	}
+
+	txErr := err.(*TxError)
+	if len(txErr.RollbackErrs) != 1 {
+		t.Fatalf("Commit() failed: expected 1 rollback error, returned %v", txErr.RollbackErrs)
+	}
+
+	expected := "two failed: original. Rollback failed: undo one: undo"
+	if err.Error() != expected {
+		t.Fatalf("Commit() failed: expected %q, returned %q", expected, err.Error())
+	}
+}
+
+func Test_TxRollbackLink(t *testing.T) {
+	ns := tenustest.New(t)
+
+	// multicast MAC address is rejected by the kernel
+	tx := NewTx().CreateLink("vethtx01", func() error {
+		return backend.LinkAdd(context.Background(), LinkSpec{Name: "vethtx01", Kind: "veth", PeerName: "vethtx02"})
+	}).SetLinkMTU("vethtx01", 1400).SetLinkUp("vethtx01").SetLinkMacAddress("vethtx01", "01:00:5e:00:00:01")
+
+	err := tx.Commit()
+	if err == nil {
+		t.Fatalf("Commit() expected to fail")
+	}
+
+	if txErr, ok := err.(*TxError); !ok || len(txErr.RollbackErrs) != 0 {
+		t.Fatalf("Commit() expected clean rollback, returned: %v", err)
+	}
+
+	ns.AssertNoLink("vethtx01")
+	ns.AssertNoLink("vethtx02")
+}
+
+func Test_TxRollbackLinkNs(t *testing.T) {
+	ns := tenustest.New(t)
+
+	unsharePath, err := exec.LookPath("unshare")
+	if err != nil {
+		t.Skipf("Tx test requries external command: %v", err)
+	}
+
+	nsCmd := exec.Command(unsharePath, "-n", "sleep", "10")
+	if err := nsCmd.Start(); err != nil {
+		t.Skipf("Tx test requries network namespace: %v", err)
+	}
+	defer nsCmd.Process.Kill()
+	time.Sleep(50 * time.Millisecond)
+
+	if _, err := NewVethPairWithOptions("vethtx05", VethOptions{PeerName: "vethtx06"}); err != nil {
+		t.Fatalf("NewVethPairWithOptions(%s) failed to run: %s", "vethtx05", err)
+	}
+
+	errFail := errors.New("fail")
+	tx := NewTx().SetLinkNsPid("vethtx05", nsCmd.Process.Pid).Do("fail", func() error { return errFail }, nil)
+
+	err = tx.Commit()
+	if !errors.Is(err, errFail) {
+		t.Fatalf("Commit() failed: expected %v, returned %v", errFail, err)
+	}
+
+	if txErr, ok := err.(*TxError); !ok || len(txErr.RollbackErrs) != 0 {
+		t.Fatalf("Commit() expected clean rollback, returned: %v", err)
+	}
+
+	// the link must be moved back to the test's network namespace, not to the process' one
+	ns.AssertLinkExists("vethtx05")
+}
+
+func Test_TxCommitContext(t *testing.T) {
+	ns := tenustest.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// cancel the context half-way through the transaction
+	tx := NewTx().CreateLink("vethtx03", func() error {
+		return backend.LinkAdd(ctx, LinkSpec{Name: "vethtx03", Kind: "veth", PeerName: "vethtx04"})
+	}).Do("cancel", func() error {
+		cancel()
+		return nil
+	}, nil).SetLinkUp("vethtx03")
+
+	err := tx.CommitContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("CommitContext() failed: expected %v, returned %v", context.Canceled, err)
+	}
+
+	if txErr, ok := err.(*TxError); !ok || txErr.Step != "set link vethtx03 up" || len(txErr.RollbackErrs) != 0 {
+		t.Fatalf("CommitContext() expected clean rollback, returned: %v", err)
+	}
+
+	ns.AssertNoLink("vethtx03")
+}
+
+// lostLinkBackend fails the first lookup of a link after it was created, by name or by index
+type lostLinkBackend struct {
+	*FakeBackend
+	byIndex bool
+	lost    bool
+}
+
+func (b *lostLinkBackend) LinkAdd(ctx context.Context, spec LinkSpec) error {
+	if err := b.FakeBackend.LinkAdd(ctx, spec); err != nil {
+		return err
+	}
+
+	b.lost = true
+	return nil
+}
+
+func (b *lostLinkBackend) LinkByName(ctx context.Context, name string) (*LinkAttrs, error) {
+	if b.lost && !b.byIndex {
+		b.lost = false
+		return nil, ErrLinkNotFound
+	}
+
+	return b.FakeBackend.LinkByName(ctx, name)
+}
+
+func (b *lostLinkBackend) LinkByIndex(ctx context.Context, index int) (*LinkAttrs, error) {
+	if b.lost && b.byIndex {
+		b.lost = false
+		return nil, ErrLinkNotFound
+	}
+
+	return b.FakeBackend.LinkByIndex(ctx, index)
+}
+
+func Test_CreateLinkLookupRollback(t *testing.T) {
+	creates := []func() (Linker, error){
+		func() (Linker, error) { return NewLinkWithOptions("dummytx01", LinkOptions{MTU: 1400}) },
+		func() (Linker, error) { return NewVlanLinkWithOptions("lo", VlanOptions{Dev: "vlantx01", Id: 10}) },
+		func() (Linker, error) { return NewMacVlanLinkWithOptions("lo", MacVlanOptions{Dev: "mcvtx01"}) },
+		func() (Linker, error) { return NewMacVtapLinkWithOptions("lo", MacVlanOptions{Dev: "mvttx01"}) },
+	}
+
+	for _, byIndex := range []bool{false, true} {
+		for i, create := range creates {
+			fake := &lostLinkBackend{FakeBackend: NewFakeBackend(), byIndex: byIndex}
+			prev := SetBackend(fake)
+
+			_, err := create()
+			SetBackend(prev)
+			if !errors.Is(err, ErrLinkNotFound) {
+				t.Fatalf("create %d failed: expected %v, returned %v", i, ErrLinkNotFound, err)
+			}
+
+			if links, err := fake.LinkList(context.Background()); err != nil || len(links) != 1 {
+				t.Fatalf("create %d failed to delete the link it could not find: %v %v", i, links, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package tenus
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// capabilities which are not exported by syscall package
+const (
+	capNetAdmin = 12
+	capSysAdmin = 21
+)
+
+// capNames maps capabilities to their names used in CapabilityError
+var capNames = map[int]string{
+	capNetAdmin: "CAP_NET_ADMIN",
+	capSysAdmin: "CAP_SYS_ADMIN",
+}
+
+// UserNsEnv is the environment variable set in the process re-executed by ReexecInUserNs
+const UserNsEnv = "TENUS_USERNS"
+
+// HasCapability reports whether the process has the capability with the given number, i.e. 12 for
+// CAP_NET_ADMIN, in its effective set.
+// Capabilities are relative to the user namespace the process runs in, so a process with mapped root
+// inside a user namespace has CAP_NET_ADMIN over the network namespaces owned by that user namespace.
+func HasCapability(capability int) (bool, error) {
+	status, err := ioutil.ReadFile(fmt.Sprintf("/proc/self/task/%d/status", syscall.Gettid()))
+	if err != nil {
+		return false, fmt.Errorf("Could not read process status: %s", err)
+	}
+
+	capEff, err := parseCapEff(status)
+	if err != nil {
+		return false, err
+	}
+
+	return capEff&(1<<uint(capability)) != 0, nil
+}
+
+// CheckCapability returns *CapabilityError if the process does not have the given capability.
+func CheckCapability(capability int) error {
+	ok, err := HasCapability(capability)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return &CapabilityError{Cap: capName(capability)}
+	}
+
+	return nil
+}
+
+// CheckNetAdmin returns *CapabilityError if the process is not allowed to configure network links.
+// Unlike checking for UID 0 it works for unprivileged users running tenus inside a user namespace.
+func CheckNetAdmin() error {
+	return CheckCapability(capNetAdmin)
+}
+
+// ReexecInUserNs runs fn in a process which is allowed to configure network links and returns fn's exit code.
+//
+// If the process already has CAP_NET_ADMIN or it has been re-executed by ReexecInUserNs, fn is run directly.
+// Otherwise the current binary is re-executed with the same arguments in new user, mount and network namespaces
+// with the caller's UID and GID mapped to root, which is equivalent of running: unshare --user --map-root-user --mount --net
+// The re-executed binary is expected to call ReexecInUserNs again, so the function is typically used in TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		code, err := tenus.ReexecInUserNs(m.Run)
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		os.Exit(code)
+//	}
+//
+// It returns the exit code of the re-executed process or error if the process could not be started.
+// If unprivileged user namespaces are disabled on the host, the returned error matches ErrPermission.
+func ReexecInUserNs(fn func() int) (int, error) {
+	if os.Getenv(UserNsEnv) != "" || CheckNetAdmin() == nil {
+		return fn(), nil
+	}
+
+	cmd := exec.Command("/proc/self/exe", os.Args[1:]...)
+	cmd.Args[0] = os.Args[0]
+	cmd.Env = append(os.Environ(), UserNsEnv+"=1")
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+		},
+		GidMappingsEnableSetgroups: false,
+	}
+
+	err := cmd.Run()
+	if err == nil {
+		return 0, nil
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.ENOSPC) {
+		return 0, fmt.Errorf("%w: could not create user namespace: %s", ErrPermission, err)
+	}
+
+	return 0, fmt.Errorf("Could not re-execute %s in user namespace: %s", os.Args[0], err)
+}
+
+// capabilityError returns *CapabilityError wrapping err if err is EPERM and the process lacks the capability.
+// Other errors are returned unchanged.
+func capabilityError(capability int, err error) error {
+	if !errors.Is(err, syscall.EPERM) {
+		return err
+	}
+
+	if ok, capErr := HasCapability(capability); capErr != nil || ok {
+		return err
+	}
+
+	return &CapabilityError{Cap: capName(capability), Err: err}
+}
+
+// capName returns name of the capability
+func capName(capability int) string {
+	if name, ok := capNames[capability]; ok {
+		return name
+	}
+
+	return "CAP_" + strconv.Itoa(capability)
+}
+
+// parseCapEff returns the effective capability set from /proc/[pid]/status
+func parseCapEff(status []byte) (uint64, error) {
+	s := bufio.NewScanner(bytes.NewReader(status))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 || fields[0] != "CapEff:" {
+			continue
+		}
+
+		capEff, err := strconv.ParseUint(fields[1], 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid effective capability set %q: %s", fields[1], err)
+		}
+
+		return capEff, nil
+	}
+
+	return 0, errors.New("Effective capability set not found in process status")
+}
@@ -0,0 +1,76 @@
+package tenus
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+type capEffTest struct {
+	status   string
+	capEff   uint64
+	expected bool
+}
+
+var capEffTests = []capEffTest{
+	{"Name:\ttest\nCapInh:\t0000000000000000\nCapEff:\t000001ffffffffff\n", 0x1ffffffffff, true},
+	{"Name:\ttest\nCapEff:\t0000000000001000\nCapBnd:\t000001ffffffffff\n", 1 << capNetAdmin, true},
+	{"Name:\ttest\nCapEff:\t0000000000000000\n", 0, true},
+	{"Name:\ttest\nCapEff:\tzz\n", 0, false},
+	{"Name:\ttest\nCapPrm:\t0000000000000000\n", 0, false},
+}
+
+func Test_ParseCapEff(t *testing.T) {
+	for _, tt := range capEffTests {
+		capEff, err := parseCapEff([]byte(tt.status))
+		if (err == nil) != tt.expected {
+			t.Errorf("parseCapEff(%q) failed: expected success %v, returned error %v", tt.status, tt.expected, err)
+			continue
+		}
+
+		if capEff != tt.capEff {
+			t.Errorf("parseCapEff(%q) failed: expected %x, returned %x", tt.status, tt.capEff, capEff)
+		}
+	}
+}
+
+func Test_CapabilityError(t *testing.T) {
+	err := newLinkError("create", "veth01", &CapabilityError{Cap: "CAP_NET_ADMIN", Err: syscall.EPERM})
+
+	for _, sentinel := range []error{ErrNoCapability, ErrPermission, syscall.EPERM} {
+		if !errors.Is(err, sentinel) {
+			t.Errorf("errors.Is(%v, %v) failed: expected true", err, sentinel)
+		}
+	}
+
+	var capErr *CapabilityError
+	if !errors.As(err, &capErr) || capErr.Cap != "CAP_NET_ADMIN" {
+		t.Fatalf("errors.As(%v) failed: expected *CapabilityError of CAP_NET_ADMIN, returned %v", err, capErr)
+	}
+
+	if err := capabilityError(capNetAdmin, syscall.ENODEV); err != syscall.ENODEV {
+		t.Fatalf("capabilityError(%v) failed: expected error unchanged, returned %v", syscall.ENODEV, err)
+	}
+}
+
+func Test_CheckNetAdmin(t *testing.T) {
+	ok, err := HasCapability(capNetAdmin)
+	if err != nil {
+		t.Skipf("HasCapability test requires process status: %s", err)
+	}
+
+	err = CheckNetAdmin()
+	if ok != (err == nil) {
+		t.Fatalf("CheckNetAdmin() failed: HasCapability() returned %v, CheckNetAdmin() returned %v", ok, err)
+	}
+
+	if err != nil && !errors.Is(err, ErrNoCapability) {
+		t.Fatalf("CheckNetAdmin() failed: expected %v, returned %v", ErrNoCapability, err)
+	}
+
+	// the tests are re-executed by TestMain unless they are run with CAP_NET_ADMIN
+	if os.Getenv(UserNsEnv) != "" && !ok {
+		t.Fatalf("CheckNetAdmin() failed: expected CAP_NET_ADMIN in user namespace, returned %v", err)
+	}
+}
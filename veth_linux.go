@@ -1,13 +1,10 @@
 package tenus
 
 import (
+	"context"
 	"fmt"
 	"net"
-	"os"
-	"syscall"
-
-	"github.com/docker/libcontainer/netlink"
-	"github.com/docker/libcontainer/system"
+	"strconv"
 )
 
 // VethOptions allows you to specify options for veth link.
@@ -36,8 +33,28 @@ type Vether interface {
 	SetPeerLinkNsPid(int) error
 	// SetPeerLinkNsFd sends peer link into container specified by path
 	SetPeerLinkNsFd(string) error
+	// SetPeerLinkNsTo sends peer link into container resolved by NsResolver
+	SetPeerLinkNsTo(NsResolver, string) error
 	// SetPeerLinkNetInNs configures peer link's IP network in network namespace specified by PID
 	SetPeerLinkNetInNs(int, net.IP, *net.IPNet, *net.IP) error
+	// PeerStats returns peer link's 64-bit counters
+	PeerStats() (*LinkStats, error)
+	// SetPeerLinkUpContext sets peer link up unless the context is done
+	SetPeerLinkUpContext(context.Context) error
+	// DeletePeerLinkContext deletes peer link unless the context is done
+	DeletePeerLinkContext(context.Context) error
+	// SetPeerLinkIpContext configures peer link's IP address unless the context is done
+	SetPeerLinkIpContext(context.Context, net.IP, *net.IPNet) error
+	// SetPeerLinkNsToDockerContext sends peer link into Docker unless the context is done
+	SetPeerLinkNsToDockerContext(context.Context, string, string) error
+	// SetPeerLinkNsPidContext sends peer link into container specified by PID unless the context is done
+	SetPeerLinkNsPidContext(context.Context, int) error
+	// SetPeerLinkNsFdContext sends peer link into container specified by path unless the context is done
+	SetPeerLinkNsFdContext(context.Context, string) error
+	// SetPeerLinkNsToContext sends peer link into container resolved by NsResolver unless the context is done
+	SetPeerLinkNsToContext(context.Context, NsResolver, string) error
+	// SetPeerLinkNetInNsContext configures peer link's IP network in network namespace until the context is done
+	SetPeerLinkNetInNsContext(context.Context, int, net.IP, *net.IPNet, *net.IP) error
 }
 
 // VethPair is a Link. Veth links are created in pairs called peers.
@@ -45,6 +62,10 @@ type VethPair struct {
 	Link
 	// Peer network interface
 	peerIfc *net.Interface
+	// PID of the network namespace the peer link was moved to, -1 if it is not known
+	peerNs int
+	// prefix the peer link's name was generated with. Empty if the name was supplied by the caller.
+	peerNamePrefix string
 }
 
 // NewVethPair creates a pair of veth network links.
@@ -56,28 +77,31 @@ type VethPair struct {
 // are assigned random names starting with "veth".
 // NewVethPair returns error if the veth pair could not be created.
 func NewVethPair() (Vether, error) {
-	ifcName := makeNetInterfaceName("veth")
-	peerName := makeNetInterfaceName("veth")
-
-	if err := netlink.NetworkCreateVethPair(ifcName, peerName, 0); err != nil {
-		return nil, err
+	var ifcName, peerName string
+	err := createWithGeneratedNames("veth", []*string{&ifcName, &peerName}, func() error {
+		return addLink(context.Background(), LinkSpec{Name: ifcName, Kind: "veth", PeerName: peerName})
+	})
+	if err != nil {
+		return nil, newLinkError("create", ifcName, err)
 	}
 
-	newIfc, err := net.InterfaceByName(ifcName)
+	newIfc, err := interfaceByName(ifcName)
 	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+		return nil, newLinkError("create", ifcName, err)
 	}
 
-	peerIfc, err := net.InterfaceByName(peerName)
+	peerIfc, err := interfaceByName(peerName)
 	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+		return nil, newLinkError("create", peerName, err)
 	}
 
 	return &VethPair{
 		Link: Link{
-			ifc: newIfc,
+			ifc:        newIfc,
+			namePrefix: "veth",
 		},
-		peerIfc: peerIfc,
+		peerIfc:        peerIfc,
+		peerNamePrefix: "veth",
 	}, nil
 }
 
@@ -89,53 +113,58 @@ func NewVethPair() (Vether, error) {
 // veth link was successfully created on the Linux host. It accepts VethOptions which allow you to set
 // peer interface name. It returns error if the veth pair could not be created.
 func NewVethPairWithOptions(ifcName string, opts VethOptions) (Vether, error) {
+	return NewVethPairWithOptionsContext(context.Background(), ifcName, opts)
+}
+
+// NewVethPairWithOptionsContext is like NewVethPairWithOptions but it gives up once the context is done.
+// Links created before the context was done are deleted.
+func NewVethPairWithOptionsContext(ctx context.Context, ifcName string, opts VethOptions) (Vether, error) {
 	peerName := opts.PeerName
 	txQLen := opts.TxQueueLen
 
-	if ok, err := NetInterfaceNameValid(ifcName); !ok {
-		return nil, err
-	}
-
-	if _, err := net.InterfaceByName(ifcName); err == nil {
-		return nil, fmt.Errorf("Interface name %s already assigned on the host", ifcName)
+	if err := linkNameAvailable(ifcName); err != nil {
+		return nil, newLinkError("create", ifcName, err)
 	}
 
 	if peerName != "" {
-		if ok, err := NetInterfaceNameValid(peerName); !ok {
-			return nil, err
+		if err := linkNameAvailable(peerName); err != nil {
+			return nil, newLinkError("create", peerName, err)
 		}
-
-		if _, err := net.InterfaceByName(peerName); err == nil {
-			return nil, fmt.Errorf("Interface name %s already assigned on the host", peerName)
-		}
-	} else {
-		peerName = makeNetInterfaceName("veth")
 	}
 
 	if txQLen < 0 {
-		return nil, fmt.Errorf("TX queue length must be a positive integer: %d", txQLen)
+		return nil, newLinkError("create", ifcName, fmt.Errorf("TX queue length must be a positive integer: %d", txQLen))
 	}
 
-	if err := netlink.NetworkCreateVethPair(ifcName, peerName, txQLen); err != nil {
-		return nil, err
-	}
-
-	newIfc, err := net.InterfaceByName(ifcName)
-	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
-	}
-
-	peerIfc, err := net.InterfaceByName(peerName)
+	var newIfc, peerIfc *net.Interface
+	err := createWithGeneratedNames("veth", []*string{&peerName}, func() error {
+		return NewTx().CreateLink(ifcName, func() error {
+			return addLink(ctx, LinkSpec{Name: ifcName, Kind: "veth", PeerName: peerName, TxQueueLen: txQLen})
+		}).Do("find veth pair "+ifcName, func() error {
+			var err error
+			if newIfc, err = interfaceByName(ifcName); err != nil {
+				return err
+			}
+
+			peerIfc, err = interfaceByName(peerName)
+			return err
+		}, nil).CommitContext(ctx)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+		return nil, newLinkError("create", ifcName, err)
 	}
 
-	return &VethPair{
+	veth := &VethPair{
 		Link: Link{
 			ifc: newIfc,
 		},
 		peerIfc: peerIfc,
-	}, nil
+	}
+	if opts.PeerName == "" {
+		veth.peerNamePrefix = "veth"
+	}
+
+	return veth, nil
 }
 
 // NetInterface returns veth link's primary network interface
@@ -148,69 +177,192 @@ func (veth *VethPair) PeerNetInterface() *net.Interface {
 	return veth.peerIfc
 }
 
+// Refresh reloads veth link's network interfaces from Linux kernel.
+// Peer network interface is refreshed only if the peer link lives in the same network namespace.
+// It returns error if the primary link no longer exists in the current network namespace.
+func (veth *VethPair) Refresh() error {
+	if err := veth.Link.Refresh(); err != nil {
+		return err
+	}
+
+	attrs, err := veth.Attrs()
+	if err != nil {
+		return err
+	}
+
+	if attrs.NetNsID >= 0 || attrs.ParentIndex == 0 {
+		return nil
+	}
+
+	peerIfc, err := interfaceByIndex(attrs.ParentIndex)
+	if err != nil {
+		return newLinkError("refresh", veth.peerIfc.Name, err)
+	}
+
+	veth.peerIfc = peerIfc
+
+	return nil
+}
+
 // SetPeerLinkUp sets peer link up
 func (veth *VethPair) SetPeerLinkUp() error {
-	return netlink.NetworkLinkUp(veth.peerIfc)
+	return veth.SetPeerLinkUpContext(context.Background())
+}
+
+// SetPeerLinkUpContext is like SetPeerLinkUp but it returns without changing the link if the context is done.
+func (veth *VethPair) SetPeerLinkUpContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("set up", veth.peerIfc.Name, err)
+	}
+
+	if err := backend.LinkSetUp(ctx, veth.peerIfc.Index); err != nil {
+		return newLinkError("set up", veth.peerIfc.Name, err)
+	}
+
+	return veth.Refresh()
 }
 
 // DeletePeerLink deletes peer link. It also deletes the other peer interface in VethPair
 func (veth *VethPair) DeletePeerLink() error {
-	return netlink.NetworkLinkDel(veth.peerIfc.Name)
+	return veth.DeletePeerLinkContext(context.Background())
+}
+
+// DeletePeerLinkContext is like DeletePeerLink but it returns without changing the link if the context is done.
+func (veth *VethPair) DeletePeerLinkContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("delete", veth.peerIfc.Name, err)
+	}
+
+	return newLinkError("delete", veth.peerIfc.Name, backend.LinkDel(ctx, veth.peerIfc.Index))
 }
 
 // SetPeerLinkIp configures peer link's IP address
 func (veth *VethPair) SetPeerLinkIp(ip net.IP, nw *net.IPNet) error {
-	return netlink.NetworkLinkAddIp(veth.peerIfc, ip, nw)
+	return veth.SetPeerLinkIpContext(context.Background(), ip, nw)
+}
+
+// SetPeerLinkIpContext is like SetPeerLinkIp but it returns without changing the link if the context is done.
+func (veth *VethPair) SetPeerLinkIpContext(ctx context.Context, ip net.IP, nw *net.IPNet) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("add address", veth.peerIfc.Name, err)
+	}
+
+	return newLinkError("add address", veth.peerIfc.Name, backend.AddrAdd(ctx, veth.peerIfc.Index, linkAddr(ip, nw)))
 }
 
 // SetPeerLinkNsToDocker sends peer link into Docker
 func (veth *VethPair) SetPeerLinkNsToDocker(name string, dockerHost string) error {
-	pid, err := DockerPidByName(name, dockerHost)
+	return veth.SetPeerLinkNsToDockerContext(context.Background(), name, dockerHost)
+}
+
+// SetPeerLinkNsToDockerContext is like SetPeerLinkNsToDocker but the Docker API request is aborted when the context is cancelled.
+func (veth *VethPair) SetPeerLinkNsToDockerContext(ctx context.Context, name string, dockerHost string) error {
+	pid, err := DockerPidByNameContext(ctx, name, dockerHost)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return newLinkNsError("set netns", veth.peerIfc.Name, name, ctxErr)
+	}
+
 	if err != nil {
-		return fmt.Errorf("Failed to find docker %s :  %s", name, err)
+		return newLinkNsError("set netns", veth.peerIfc.Name, name, &lookupError{id: "docker " + name, err: err})
 	}
 
-	return netlink.NetworkSetNsPid(veth.peerIfc, pid)
+	if err := setLinkNs(ctx, veth.peerIfc, veth.peerNamePrefix, pidNsPath(pid)); err != nil {
+		return newLinkNsError("set netns", veth.peerIfc.Name, name, err)
+	}
+
+	veth.peerNs = pid
+
+	return nil
 }
 
 // SetPeerLinkNsPid sends peer link into container specified by PID
 func (veth *VethPair) SetPeerLinkNsPid(nspid int) error {
-	return netlink.NetworkSetNsPid(veth.peerIfc, nspid)
+	return veth.SetPeerLinkNsPidContext(context.Background(), nspid)
+}
+
+// SetPeerLinkNsPidContext is like SetPeerLinkNsPid but it returns without changing the link if the context is done.
+func (veth *VethPair) SetPeerLinkNsPidContext(ctx context.Context, nspid int) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("set netns", veth.peerIfc.Name, err)
+	}
+
+	if err := setLinkNs(ctx, veth.peerIfc, veth.peerNamePrefix, pidNsPath(nspid)); err != nil {
+		return newLinkNsError("set netns", veth.peerIfc.Name, strconv.Itoa(nspid), err)
+	}
+
+	veth.peerNs = nspid
+
+	return nil
 }
 
 // SetPeerLinkNsFd sends peer link into container specified by path
 func (veth *VethPair) SetPeerLinkNsFd(nspath string) error {
-	fd, err := syscall.Open(nspath, syscall.O_RDONLY, 0)
-	if err != nil {
-		return fmt.Errorf("Could not attach to Network namespace: %s", err)
+	return veth.SetPeerLinkNsFdContext(context.Background(), nspath)
+}
+
+// SetPeerLinkNsFdContext is like SetPeerLinkNsFd but it returns without changing the link if the context is done.
+func (veth *VethPair) SetPeerLinkNsFdContext(ctx context.Context, nspath string) error {
+	if err := ctx.Err(); err != nil {
+		return newLinkError("set netns", veth.peerIfc.Name, err)
+	}
+
+	if err := setLinkNs(ctx, veth.peerIfc, veth.peerNamePrefix, nspath); err != nil {
+		return newLinkNsError("set netns", veth.peerIfc.Name, nspath, err)
 	}
 
-	return netlink.NetworkSetNsFd(veth.peerIfc, fd)
+	// namespace specified by path can't be entered by PID
+	veth.peerNs = -1
+
+	return nil
 }
 
-// SetPeerLinkNetInNs configures peer link's IP network in network namespace specified by PID
-func (veth *VethPair) SetPeerLinkNetInNs(nspid int, ip net.IP, network *net.IPNet, gw *net.IP) error {
-	origNs, _ := NetNsHandle(os.Getpid())
-	defer syscall.Close(int(origNs))
-	defer system.Setns(origNs, syscall.CLONE_NEWNET)
+// SetPeerLinkNsTo sends peer link into container with the given ID.
+// The network namespace of the container is looked up by the resolver.
+func (veth *VethPair) SetPeerLinkNsTo(resolver NsResolver, id string) error {
+	return veth.SetPeerLinkNsToContext(context.Background(), resolver, id)
+}
 
-	if err := SetNetNsToPid(nspid); err != nil {
-		return fmt.Errorf("Setting network namespace failed: %s", err)
+// SetPeerLinkNsToContext is like SetPeerLinkNsTo but the container lookup is aborted when the context is cancelled.
+func (veth *VethPair) SetPeerLinkNsToContext(ctx context.Context, resolver NsResolver, id string) error {
+	nspath, err := resolveNs(ctx, resolver, id)
+	if err != nil {
+		return newLinkNsError("set netns", veth.peerIfc.Name, id, err)
 	}
 
-	if err := netlink.NetworkLinkAddIp(veth.peerIfc, ip, network); err != nil {
-		return fmt.Errorf("Unable to set IP: %s in pid: %d network namespace", ip.String(), nspid)
+	if err := setLinkNs(ctx, veth.peerIfc, veth.peerNamePrefix, nspath); err != nil {
+		return newLinkNsError("set netns", veth.peerIfc.Name, id, err)
 	}
 
-	if err := netlink.NetworkLinkUp(veth.peerIfc); err != nil {
-		return fmt.Errorf("Unable to bring %s interface UP: %d", veth.peerIfc.Name, nspid)
+	// namespaces of processes can be entered by PID, pinned namespaces only by path
+	veth.peerNs = -1
+	if pid, ok := pidNetNs(nspath); ok {
+		veth.peerNs, _ = strconv.Atoi(pid)
 	}
 
-	if gw != nil {
-		if err := netlink.AddDefaultGw(gw.String(), veth.peerIfc.Name); err != nil {
-			return fmt.Errorf("Unable to set Default gateway: %s in pid: %d network namespace", gw.String(), nspid)
-		}
+	return nil
+}
+
+// SetPeerLinkNetInNs configures peer link's IP network in network namespace specified by PID
+func (veth *VethPair) SetPeerLinkNetInNs(nspid int, ip net.IP, network *net.IPNet, gw *net.IP) error {
+	return veth.SetPeerLinkNetInNsContext(context.Background(), nspid, ip, network, gw)
+}
+
+// SetPeerLinkNetInNsContext is like SetPeerLinkNetInNs but it stops configuring the link once the context is done.
+func (veth *VethPair) SetPeerLinkNetInNsContext(ctx context.Context, nspid int, ip net.IP, network *net.IPNet, gw *net.IP) error {
+	return configureLinkInNs(ctx, veth.peerIfc, nspid, ip, network, gw)
+}
+
+// PeerStats returns peer link's 64-bit counters.
+// If the peer link was moved to another network namespace by PID, the counters are read from that namespace.
+// It returns error if the peer link can not be found.
+func (veth *VethPair) PeerStats() (*LinkStats, error) {
+	if veth.peerNs < 0 {
+		return nil, newLinkError("stats", veth.peerIfc.Name, fmt.Errorf("%w: peer link was moved to unknown network namespace", ErrNsNotFound))
 	}
 
-	return nil
+	if veth.peerNs != 0 {
+		return LinkStatsInNs(veth.peerNs, veth.peerIfc.Name)
+	}
+
+	return LinkStatsByIndex(veth.peerIfc.Index)
 }
@@ -1,9 +1,11 @@
 package tenus
 
 import (
-	"net"
+	"context"
+	"errors"
 	"testing"
-	"time"
+
+	"github.com/milosgajdos/tenus/tenustest"
 )
 
 type vethTest struct {
@@ -12,43 +14,15 @@ type vethTest struct {
 }
 
 func Test_NewVethPair(t *testing.T) {
+	ns := tenustest.New(t)
+
 	veth, err := NewVethPair()
 	if err != nil {
 		t.Fatalf("NewVethPair() failed to run: %s", err)
 	}
 
-	vethIfcName := veth.NetInterface().Name
-	vethPeerName := veth.PeerNetInterface().Name
-
-	tl := &testLink{}
-	if err := tl.prepTestLink(vethIfcName, ""); err != nil {
-		t.Skipf("NewVethPair test requries external command: %v", err)
-	}
-
-	if _, err := net.InterfaceByName(vethIfcName); err != nil {
-		t.Fatalf("Could not find %s on the host: %s", vethIfcName, err)
-	}
-
-	if _, err := net.InterfaceByName(vethPeerName); err != nil {
-		t.Fatalf("Could not find %s on the host: %s", vethPeerName, err)
-	}
-
-	testRes, err := linkInfo(vethIfcName, "veth")
-	if err != nil {
-		tl.teardown()
-		t.Fatalf("Failed to list %s operation mode: %s", vethIfcName, err)
-	}
-
-	if testRes.linkType != "veth" {
-		tl.teardown()
-		t.Fatalf("NewVethPair() failed: expected linktype veth, returned %s", testRes.linkType)
-	}
-
-	if err := tl.teardown(); err != nil {
-		t.Fatalf("testLink.teardown failed: %v", err)
-	} else {
-		time.Sleep(10 * time.Millisecond)
-	}
+	ns.AssertLinkKind(veth.NetInterface().Name, "veth")
+	ns.AssertLinkKind(veth.PeerNetInterface().Name, "veth")
 }
 
 var vethOptionTests = []vethTest{
@@ -57,56 +31,80 @@ var vethOptionTests = []vethTest{
 }
 
 func Test_NewVethPairWithOptions(t *testing.T) {
-	for _, tt := range vethOptionTests {
-		tl := &testLink{}
-
-		if err := tl.prepTestLink(tt.hostIfc, ""); err != nil {
-			t.Skipf("NewVlanLink test requries external command: %v", err)
-		}
+	ns := tenustest.New(t)
 
+	for _, tt := range vethOptionTests {
 		veth, err := NewVethPairWithOptions(tt.hostIfc, tt.vethOptions)
 		if err != nil {
 			t.Fatalf("NewVethPairWithOptions(%s, %v) failed to run: %s", tt.hostIfc, tt.vethOptions, err)
 		}
 
-		if _, err := net.InterfaceByName(tt.hostIfc); err != nil {
-			t.Fatalf("Could not find %s on the host: %s", tt.hostIfc, err)
-		}
-
-		if _, err := net.InterfaceByName(tt.vethOptions.PeerName); err != nil {
-			t.Fatalf("Could not find %s on the host: %s", tt.vethOptions.PeerName, err)
-		}
-
 		vethIfcName := veth.NetInterface().Name
 		if vethIfcName != tt.hostIfc {
-			tl.teardown()
 			t.Fatalf("NewVethPairWithOptions(%s, %v) failed: expected host ifc %s, returned %s",
 				tt.hostIfc, tt.vethOptions, tt.hostIfc, vethIfcName)
 		}
 
 		vethPeerName := veth.PeerNetInterface().Name
 		if vethPeerName != tt.vethOptions.PeerName {
-			tl.teardown()
 			t.Fatalf("NewVethPairWithOptions(%s, %v) failed: expected peer ifc %s, returned %s",
 				tt.hostIfc, tt.vethOptions, tt.vethOptions.PeerName, vethPeerName)
 		}
 
-		testRes, err := linkInfo(tt.hostIfc, "veth")
-		if err != nil {
-			tl.teardown()
-			t.Fatalf("Failed to list %s operation mode: %s", tt.hostIfc, err)
-		}
+		ns.AssertLinkKind(tt.hostIfc, "veth")
+		ns.AssertLinkKind(tt.vethOptions.PeerName, "veth")
+	}
+}
 
-		if testRes.linkType != "veth" {
-			tl.teardown()
-			t.Fatalf("NewVethPairWithOptions(%s, %v) failed: expected linktype veth, returned %s",
-				tt.hostIfc, tt.vethOptions, testRes.linkType)
-		}
+func Test_NewVethPairWithOptionsContext(t *testing.T) {
+	ns := tenustest.New(t)
 
-		if err := tl.teardown(); err != nil {
-			t.Fatalf("testLink.teardown failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
-		}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := NewVethPairWithOptionsContext(ctx, "vethctx01", VethOptions{PeerName: "vethctx02"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("NewVethPairWithOptionsContext() failed: expected %v, returned %v", context.Canceled, err)
+	}
+
+	ns.AssertNoLink("vethctx01")
+
+	veth, err := NewVethPairWithOptionsContext(context.Background(), "vethctx01", VethOptions{PeerName: "vethctx02"})
+	if err != nil {
+		t.Fatalf("NewVethPairWithOptionsContext(%s) failed to run: %s", "vethctx01", err)
+	}
+
+	if err := veth.SetLinkMTUContext(ctx, 1400); !errors.Is(err, context.Canceled) {
+		t.Fatalf("SetLinkMTUContext() failed: expected %v, returned %v", context.Canceled, err)
+	}
+
+	if ns.Link("vethctx01").MTU == 1400 {
+		t.Fatalf("SetLinkMTUContext() failed: MTU changed after cancellation")
+	}
+}
+
+func Test_NewVethPairInNetNs(t *testing.T) {
+	for _, tt := range []vethTest{
+		{"vethns01", VethOptions{PeerName: "vethns02"}},
+		{"vethns01", VethOptions{PeerName: "vethns03", TxQueueLen: 1000}},
+	} {
+		tt := tt
+		t.Run(tt.vethOptions.PeerName, func(t *testing.T) {
+			t.Parallel()
+
+			ns := tenustest.New(t)
+
+			veth, err := NewVethPairWithOptions(tt.hostIfc, tt.vethOptions)
+			if err != nil {
+				t.Fatalf("NewVethPairWithOptions(%s, %v) failed to run: %s", tt.hostIfc, tt.vethOptions, err)
+			}
+
+			if err := veth.SetLinkMTU(1400); err != nil {
+				t.Fatalf("SetLinkMTU() failed: %s", err)
+			}
+
+			ns.AssertLinkKind(tt.hostIfc, "veth")
+			ns.AssertLinkKind(tt.vethOptions.PeerName, "veth")
+			ns.AssertLinkMTU(tt.hostIfc, 1400)
+		})
 	}
 }
@@ -1,10 +1,9 @@
 package tenus
 
 import (
+	"context"
 	"fmt"
 	"net"
-
-	"github.com/docker/libcontainer/netlink"
 )
 
 // VlanOptions allows you to specify options for vlan link.
@@ -15,6 +14,8 @@ type VlanOptions struct {
 	Id uint16
 	// MAC address
 	MacAddr string
+	// MAC address generator used if MacAddr is empty
+	MacGen MacGenerator `json:"-" yaml:"-"`
 }
 
 // Vlaner is interface which embeds Linker interface and adds few more functions.
@@ -45,37 +46,42 @@ type VlanLink struct {
 // vlan link was successfully created on the Linux host. Newly created link is assigned
 // a random name starting with "vlan". It returns error if the link can not be created.
 func NewVlanLink(masterDev string, id uint16) (Vlaner, error) {
-	vlanDev := makeNetInterfaceName("vlan")
+	var vlanDev string
 
 	if ok, err := NetInterfaceNameValid(masterDev); !ok {
-		return nil, err
+		return nil, newLinkError("create", vlanDev, err)
 	}
 
-	if _, err := net.InterfaceByName(masterDev); err != nil {
-		return nil, fmt.Errorf("Master VLAN device %s does not exist on the host", masterDev)
+	master, err := interfaceByName(masterDev)
+	if err != nil {
+		return nil, newLinkError("create", vlanDev, fmt.Errorf("%w: master VLAN device %s does not exist on the host", ErrLinkNotFound, masterDev))
 	}
 
 	if id <= 0 {
-		return nil, fmt.Errorf("VLAN id must be a postive Integer: %d", id)
+		return nil, newLinkError("create", vlanDev, fmt.Errorf("VLAN id must be a postive Integer: %d", id))
 	}
 
-	if err := netlink.NetworkLinkAddVlan(masterDev, vlanDev, id); err != nil {
-		return nil, err
+	err = createWithGeneratedNames("vlan", []*string{&vlanDev}, func() error {
+		return addLink(context.Background(), LinkSpec{Name: vlanDev, Kind: "vlan", ParentIndex: master.Index, VlanId: id})
+	})
+	if err != nil {
+		return nil, newLinkError("create", vlanDev, err)
 	}
 
-	vlanIfc, err := net.InterfaceByName(vlanDev)
+	vlanIfc, err := interfaceByName(vlanDev)
 	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+		return nil, newLinkError("create", vlanDev, err)
 	}
 
-	masterIfc, err := net.InterfaceByName(masterDev)
+	masterIfc, err := interfaceByName(masterDev)
 	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+		return nil, newLinkError("create", vlanDev, err)
 	}
 
 	return &VlanLink{
 		Link: Link{
-			ifc: vlanIfc,
+			ifc:        vlanIfc,
+			namePrefix: "vlan",
 		},
 		masterIfc: masterIfc,
 		id:        id,
@@ -90,56 +96,60 @@ func NewVlanLink(masterDev string, id uint16) (Vlaner, error) {
 // NewVlanLinkWithOptions returns Vlaner which is initialized to a pointer of type VlanLink if the
 // vlan link was created successfully on the Linux host. It accepts VlanOptions which allow you to set
 // link's options. It returns error if the link could not be created.
+// If setting the MAC address fails, the newly created link is deleted.
 func NewVlanLinkWithOptions(masterDev string, opts VlanOptions) (Vlaner, error) {
+	return NewVlanLinkWithOptionsContext(context.Background(), masterDev, opts)
+}
+
+// NewVlanLinkWithOptionsContext is like NewVlanLinkWithOptions but it gives up once the context is done.
+// Links created before the context was done are deleted.
+func NewVlanLinkWithOptionsContext(ctx context.Context, masterDev string, opts VlanOptions) (Vlaner, error) {
 	if ok, err := NetInterfaceNameValid(masterDev); !ok {
-		return nil, err
+		return nil, newLinkError("create", opts.Dev, err)
 	}
 
-	if _, err := net.InterfaceByName(masterDev); err != nil {
-		return nil, fmt.Errorf("Master VLAN device %s does not exist on the host", masterDev)
+	master, err := interfaceByName(masterDev)
+	if err != nil {
+		return nil, newLinkError("create", opts.Dev, fmt.Errorf("%w: master VLAN device %s does not exist on the host", ErrLinkNotFound, masterDev))
 	}
 
 	if err := validateVlanOptions(&opts); err != nil {
-		return nil, err
-	}
-
-	if err := netlink.NetworkLinkAddVlan(masterDev, opts.Dev, opts.Id); err != nil {
-		return nil, err
+		return nil, newLinkError("create", opts.Dev, err)
 	}
 
-	vlanIfc, err := net.InterfaceByName(opts.Dev)
-	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+	vlan := &VlanLink{masterIfc: master, id: opts.Id}
+	if opts.Dev == "" {
+		vlan.namePrefix = "vlan"
 	}
-
-	if opts.MacAddr != "" {
-		if err := netlink.NetworkSetMacAddress(vlanIfc, opts.MacAddr); err != nil {
-			if errDel := DeleteLink(vlanIfc.Name); errDel != nil {
-				return nil, fmt.Errorf("Incorrect options specified. Attempt to delete the link failed: %s",
-					errDel)
-			}
+	err = createWithGeneratedNames("vlan", []*string{&opts.Dev}, func() error {
+		macaddr, err := linkMacAddr(opts.MacAddr, opts.MacGen, opts.Dev)
+		if err != nil {
+			return err
 		}
 
-		hwaddr, err := net.ParseMAC(opts.MacAddr)
-		if err != nil {
-			return nil, err
+		tx := NewTx().CreateLink(opts.Dev, func() error {
+			return addLink(ctx, LinkSpec{Name: opts.Dev, Kind: "vlan", ParentIndex: master.Index, VlanId: opts.Id})
+		})
+
+		if macaddr != "" {
+			tx.SetLinkMacAddress(opts.Dev, macaddr)
 		}
 
-		vlanIfc.HardwareAddr = hwaddr
-	}
+		// the link is deleted if it can't be looked up after it was created
+		return tx.Do("find vlan link "+opts.Dev, func() error {
+			var err error
+			if vlan.ifc, err = interfaceByName(opts.Dev); err != nil {
+				return err
+			}
 
-	masterIfc, err := net.InterfaceByName(masterDev)
+			return vlan.Refresh()
+		}, nil).CommitContext(ctx)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("Could not find the new interface: %s", err)
+		return nil, newLinkError("create", opts.Dev, err)
 	}
 
-	return &VlanLink{
-		Link: Link{
-			ifc: vlanIfc,
-		},
-		masterIfc: masterIfc,
-		id:        opts.Id,
-	}, nil
+	return vlan, nil
 }
 
 // NetInterface returns vlan link's network interface
@@ -147,6 +157,23 @@ func (vln *VlanLink) NetInterface() *net.Interface {
 	return vln.ifc
 }
 
+// Refresh reloads vlan link's and its master's network interfaces from Linux kernel.
+// It returns error if either of the links no longer exists in the current network namespace.
+func (vln *VlanLink) Refresh() error {
+	if err := vln.Link.Refresh(); err != nil {
+		return err
+	}
+
+	masterIfc, err := interfaceByIndex(vln.masterIfc.Index)
+	if err != nil {
+		return newLinkError("refresh", vln.masterIfc.Name, err)
+	}
+
+	vln.masterIfc = masterIfc
+
+	return nil
+}
+
 // MasterNetInterface returns vlan link's master network interface
 func (vln *VlanLink) MasterNetInterface() *net.Interface {
 	return vln.masterIfc
@@ -163,19 +190,19 @@ func validateVlanOptions(opts *VlanOptions) error {
 			return err
 		}
 
-		if _, err := net.InterfaceByName(opts.Dev); err == nil {
-			return fmt.Errorf("VLAN device %s already assigned on the host", opts.Dev)
+		if _, err := backend.LinkByName(context.Background(), opts.Dev); err == nil {
+			return fmt.Errorf("%w: VLAN device %s already assigned on the host", ErrLinkExists, opts.Dev)
 		}
-	} else {
-		opts.Dev = makeNetInterfaceName("vlan")
 	}
 
 	if opts.Id <= 0 {
 		return fmt.Errorf("Incorrect VLAN tag specified: %d", opts.Id)
 	}
 
-	if _, err := net.ParseMAC(opts.MacAddr); err != nil {
-		return fmt.Errorf("Incorrect MacAddress specified: %s", opts.MacAddr)
+	if opts.MacAddr != "" {
+		if ok, err := MacAddressValid(opts.MacAddr); !ok {
+			return err
+		}
 	}
 
 	return nil
@@ -1,10 +1,9 @@
 package tenus
 
 import (
-	"net"
-	"strconv"
 	"testing"
-	"time"
+
+	"github.com/milosgajdos/tenus/tenustest"
 )
 
 type vlnTest struct {
@@ -18,18 +17,11 @@ var vlnTests = []vlnTest{
 }
 
 func Test_NewVlanLink(t *testing.T) {
-	for _, tt := range vlnTests {
-		tl := &testLink{}
+	ns := tenustest.New(t)
+	ns.RequireLinkKind("vlan")
 
-		if err := tl.prepTestLink(tt.masterDev, "dummy"); err != nil {
-			t.Skipf("NewVlanLink test requries external command: %v", err)
-		}
-
-		if err := tl.create(); err != nil {
-			t.Fatalf("testLink.create failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
-		}
+	for _, tt := range vlnTests {
+		newTestMaster(t, tt.masterDev)
 
 		vln, err := NewVlanLink(tt.masterDev, tt.id)
 		if err != nil {
@@ -37,39 +29,10 @@ func Test_NewVlanLink(t *testing.T) {
 		}
 
 		vlnName := vln.NetInterface().Name
-		if _, err := net.InterfaceByName(vlnName); err != nil {
-			tl.teardown()
-			t.Fatalf("Could not find %s on the host: %s", vlnName, err)
-		}
-
-		testRes, err := linkInfo(vlnName, "vlan")
-		if err != nil {
-			tl.teardown()
-			t.Fatalf("Failed to list %s operation mode: %s", vlnName, err)
-		}
+		ns.AssertLinkKind(vlnName, "vlan")
 
-		if testRes.linkType != "vlan" {
-			tl.teardown()
-			t.Fatalf("NewVlanLink(%s, %d) failed: expected vlan, returned %s",
-				tt.masterDev, tt.id, testRes.linkType)
-		}
-
-		id, err := strconv.Atoi(testRes.linkData)
-		if err != nil {
-			tl.teardown()
-			t.Fatalf("Failed to convert link data %s : %s", testRes.linkData, err)
-		}
-
-		if uint16(id) != tt.id {
-			tl.teardown()
-			t.Fatalf("NewVlanLink(%s, %d) failed: expected %d, returned %d",
-				tt.masterDev, tt.id, tt.id, id)
-		}
-
-		if err := tl.teardown(); err != nil {
-			t.Fatalf("testLink.teardown failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
+		if id := kernelLinkAttrs(t, vlnName).VlanId; id != tt.id {
+			t.Fatalf("NewVlanLink(%s, %d) failed: expected %d, returned %d", tt.masterDev, tt.id, tt.id, id)
 		}
 	}
 }
@@ -80,73 +43,33 @@ type vlnWithOptionsTest struct {
 }
 
 var vlnWithOptionsTests = []vlnWithOptionsTest{
-	{"master01", &VlanOptions{Dev: "test", MacAddr: "aa:aa:aa:aa:aa:aa", Id: 10}},
-	{"master02", &VlanOptions{Dev: "test", MacAddr: "aa:aa:aa:aa:aa:aa", Id: 20}},
+	{"master01", &VlanOptions{Dev: "test01", MacAddr: "aa:aa:aa:aa:aa:aa", Id: 10}},
+	{"master02", &VlanOptions{Dev: "test02", MacAddr: "aa:aa:aa:aa:aa:aa", Id: 20}},
 }
 
 func Test_NewVlanLinkWithOptions(t *testing.T) {
-	for _, tt := range vlnWithOptionsTests {
-		var iface *net.Interface
+	ns := tenustest.New(t)
+	ns.RequireLinkKind("vlan")
 
-		tl := &testLink{}
-
-		if err := tl.prepTestLink(tt.masterDev, "dummy"); err != nil {
-			t.Skipf("NewVlanLinkWithOptions test requries external command: %v", err)
-		}
-
-		if err := tl.create(); err != nil {
-			t.Fatalf("testLink.create failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
-		}
+	for _, tt := range vlnWithOptionsTests {
+		newTestMaster(t, tt.masterDev)
 
 		vln, err := NewVlanLinkWithOptions(tt.masterDev, *tt.opts)
 		if err != nil {
 			t.Fatalf("NewVlanLinkWithOptions(%s, %v) failed to run: %s", tt.masterDev, *tt.opts, err)
 		}
 
-		iface = vln.NetInterface()
-
-		if iface.HardwareAddr.String() != tt.opts.MacAddr {
-			tl.teardown()
+		if vln.NetInterface().Name != tt.opts.Dev {
 			t.Fatalf("NewVlanLinkWithOptions(%s, %v) failed: expected %s, returned %s",
-				tt.masterDev, *tt.opts, tt.opts.MacAddr, iface.HardwareAddr.String())
-		}
-
-		vlnName := vln.NetInterface().Name
-		if _, err := net.InterfaceByName(vlnName); err != nil {
-			tl.teardown()
-			t.Fatalf("Could not find %s on the host: %s", vlnName, err)
+				tt.masterDev, *tt.opts, tt.opts.Dev, vln.NetInterface().Name)
 		}
 
-		testRes, err := linkInfo(vlnName, "vlan")
-		if err != nil {
-			tl.teardown()
-			t.Fatalf("Failed to list %s operation mode: %s", vlnName, err)
-		}
+		ns.AssertLinkKind(tt.opts.Dev, "vlan")
+		ns.AssertLinkMAC(tt.opts.Dev, tt.opts.MacAddr)
 
-		if testRes.linkType != "vlan" {
-			tl.teardown()
-			t.Fatalf("NewVlanLinkWithOptions(%s, %v) failed: expected vlan, returned %s",
-				tt.masterDev, *tt.opts, testRes.linkType)
-		}
-
-		id, err := strconv.Atoi(testRes.linkData)
-		if err != nil {
-			tl.teardown()
-			t.Fatalf("Failed to convert link data %s : %s", testRes.linkData, err)
-		}
-
-		if uint16(id) != tt.opts.Id {
-			tl.teardown()
+		if id := kernelLinkAttrs(t, tt.opts.Dev).VlanId; id != tt.opts.Id {
 			t.Fatalf("NewVlanLinkWithOptions(%s, %v) failed: expected %d, returned %d",
 				tt.masterDev, *tt.opts, tt.opts.Id, id)
 		}
-
-		if err := tl.teardown(); err != nil {
-			t.Fatalf("testLink.teardown failed: %v", err)
-		} else {
-			time.Sleep(10 * time.Millisecond)
-		}
 	}
 }